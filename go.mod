@@ -2,9 +2,12 @@ module github.com/tpdoyle87/simple-email-server
 
 go 1.21.3
 
+require (
+	github.com/emersion/go-smtp v0.23.0
+	github.com/google/uuid v1.6.0
+)
+
 require (
 	github.com/emersion/go-sasl v0.0.0-20241020182733-b788ff22d5a6 // indirect
-	github.com/emersion/go-smtp v0.23.0 // indirect
-	github.com/google/uuid v1.6.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -1,19 +1,37 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/mail"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 	
 	"github.com/google/uuid"
+	"github.com/tpdoyle87/simple-email-server/internal/attachment"
+	"github.com/tpdoyle87/simple-email-server/internal/audit"
 	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/internal/lifecycle"
+	"github.com/tpdoyle87/simple-email-server/internal/policy"
 	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/internal/quota"
+	"github.com/tpdoyle87/simple-email-server/internal/resource"
 	"github.com/tpdoyle87/simple-email-server/pkg/email"
+	"github.com/tpdoyle87/simple-email-server/pkg/traffic"
 )
 
 var (
@@ -27,18 +45,103 @@ type API struct {
 	config         *config.APIConfig
 	queue          queue.Queue
 	maxMessageSize int64
+	raceStats      func() delivery.RaceStats
+	policies       []policy.Policy
+	monitor        *resource.Monitor
+	fetcher        *attachment.Fetcher
+	audit          *audit.Log
+	auditStrict    bool
+	draining       atomic.Bool
+	drainHook      func()
+	earlyTalkers   func() int64
+	throttles      func() []delivery.DomainThrottle
+	warmup         func() *delivery.WarmupStats
+	breakers       func() []delivery.BreakerState
+	reputation     func() delivery.ReputationStats
+	egress         func() delivery.EgressStatus
+	domainRates    func(domain string) (config.Rate, bool)
+	pauser         DomainPauser
+	deliveryPauser DeliveryPauser
+	workerScaler   WorkerScaler
+	forecasts      forecastCache
+	recorder       *traffic.Recorder
+	lifecycle      lifecycle.Lifecycle
+	quota          *quota.Tracker
 	
-	// Stats
-	totalSent      atomic.Int64
-	totalFailed    atomic.Int64
-	totalDelivered atomic.Int64
+	// Queue watermarks and limits; see SetLimits
+	maxBatchSize     int
+	maxRecipients    int
+	queueCapacity    int
+	softWatermark    float64
+	hardWatermark    float64
+	warnQueuePercent float64
+	warnSizePercent  float64
+	warnQuotaPercent float64
+	
+	// Stats; see SetCounters
+	counters          *Counters
+	countersFromQueue bool
 	
 	// Email status tracking
 	emailStatus sync.Map // map[string]*email.Email
+	statusCache *StatusCache
+	
+	// Content shared between queued batch emails, released as they finish
+	shared *sharedContent
+	
+	// Bulk operation events; see AddEventListener
+	events eventLog
+	
+	// Bounces that couldn't be processed; see HandleBounce
+	bounces bounceReview
+	
+	// Test emails awaiting their delivery result; see SetDiagnostics
+	waiters  sync.Map // map[string]chan delivery.Result
+	version  string
+	settings map[string]string
 	
 	mux *http.ServeMux
 }
 
+// Counters holds the totals reported by /stats. It is a queue.Listener:
+// pass it to the queue at construction and install it with SetCounters.
+// Without one the API keeps its own, counting emails it queues and the
+// outcomes reported to DeliveryResult.
+type Counters struct {
+	sent      atomic.Int64
+	delivered atomic.Int64
+	failed    atomic.Int64
+	rejected  atomic.Int64
+	
+	// The same events over time, for /stats/timeseries
+	series *TimeSeries
+}
+
+func NewCounters() *Counters {
+	return &Counters{series: NewTimeSeries()}
+}
+
+func (c *Counters) OnEnqueued(e *email.Email) {
+	c.sent.Add(1)
+	c.series.Add(MetricAccepted, time.Now(), 1)
+}
+
+func (c *Counters) OnDequeued(e *email.Email) {}
+
+func (c *Counters) OnDelivered(id string, duration time.Duration) {
+	c.delivered.Add(1)
+	c.series.Add(MetricDelivered, time.Now(), 1)
+}
+
+func (c *Counters) OnFailed(id string, reason string, willRetry bool) {
+	if willRetry {
+		c.series.Add(MetricDeferred, time.Now(), 1)
+		return
+	}
+	c.failed.Add(1)
+	c.series.Add(MetricFailed, time.Now(), 1)
+}
+
 type SendEmailRequest struct {
 	From        string            `json:"from"`
 	To          []string          `json:"to"`
@@ -49,22 +152,142 @@ type SendEmailRequest struct {
 	HTML        string            `json:"html,omitempty"`
 	Headers     map[string]string `json:"headers,omitempty"`
 	ScheduledAt *time.Time        `json:"scheduled_at,omitempty"`
+	
+	Attachments []AttachmentRequest `json:"attachments,omitempty"`
+	
+	// AllowDuplicate skips duplicate suppression for intentional resends
+	AllowDuplicate bool `json:"allow_duplicate,omitempty"`
+	
+	// RaceMX races connections to the top MX hosts, for latency-sensitive
+	// mail such as OTP codes
+	RaceMX bool `json:"race_mx,omitempty"`
+	
+	// Sandbox runs delivery without the final SMTP send, for testing
+	Sandbox bool `json:"sandbox,omitempty"`
+	
+	// Debug records the SMTP exchange of each delivery attempt, for
+	// GET /status/{id}/transcript
+	Debug bool `json:"debug,omitempty"`
+	
+	// NotifyOnFailure sends a non-delivery report to From if delivery
+	// fails for good, as is done for mail relayed over SMTP
+	NotifyOnFailure bool `json:"notify_on_failure,omitempty"`
+	
+	// Lane is "transactional" (default) or "bulk"
+	Lane string `json:"lane,omitempty"`
+	
+	// Priority: 0 is normal, higher is sent sooner
+	Priority int `json:"priority,omitempty"`
+	
+	// SLA is how soon after acceptance, or after ScheduledAt, delivery
+	// must start, such as "60s". Mail nearing its deadline is sent first.
+	SLA string `json:"sla,omitempty"`
+	
+	// MaxRetry overrides the server's retry limit; 0 means no retries,
+	// for one-shot notifications
+	MaxRetry *int `json:"max_retry,omitempty"`
+	
+	// Durability "confirmed" holds the response until the queue backend
+	// has committed the email to durable storage. The X-Durability header
+	// sets it too.
+	Durability string `json:"durability,omitempty"`
+}
+
+// DurabilityConfirmed asks for a send to be acknowledged only once the
+// email is in durable storage.
+const DurabilityConfirmed = "confirmed"
+
+// AttachmentRequest carries attachment content inline as base64 Data, or
+// by reference as an https URL or a path in an allowed directory, fetched
+// when the email is accepted.
+type AttachmentRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Path        string `json:"path,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
 }
 
 type SendEmailResponse struct {
 	ID      string `json:"id"`
 	Status  string `json:"status"`
 	Message string `json:"message"`
+	
+	// Warnings report soft limits that were crossed; the email was still
+	// accepted
+	Warnings []string `json:"warnings,omitempty"`
+	
+	// Committed is true when the email was in durable storage before the
+	// response was sent. It is only ever set for confirmed sends to a
+	// queue backend that commits.
+	Committed bool `json:"committed"`
+}
+
+// QuotaResponse reports the calling key's sends today. Limit is zero for
+// keys without a daily quota.
+type QuotaResponse struct {
+	Key       string    `json:"key"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
 }
 
 type StatusResponse struct {
 	ID          string     `json:"id"`
 	Status      string     `json:"status"`
 	RetryCount  int        `json:"retry_count"`
+	DeferCount  int        `json:"defer_count"`
 	LastError   string     `json:"last_error,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
 	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Priority    int        `json:"priority"`
+	
+	// Why a queued email is being held back, such as "domain_paused"
+	DeferredReason string `json:"deferred_reason,omitempty"`
+	
+	// When delivery was first attempted, and when a queued email will
+	// next be attempted
+	FirstAttemptAt *time.Time `json:"first_attempt_at,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	
+	// LastError classified: the SMTP reply code and text, and whether
+	// the failure is permanent
+	LastFailure *email.Failure `json:"last_failure,omitempty"`
+	
+	// Outcome per recipient, once delivery has been attempted
+	Recipients map[string]email.RecipientStatus `json:"recipients,omitempty"`
+	
+	// Set for rejected emails
+	RejectedBy   string `json:"rejected_by,omitempty"`
+	RejectReason string `json:"reject_reason,omitempty"`
+	
+	// Set for quarantined emails
+	QuarantineReason string     `json:"quarantine_reason,omitempty"`
+	QuarantinedAt    *time.Time `json:"quarantined_at,omitempty"`
+	
+	// Set for held emails
+	HoldReason string     `json:"hold_reason,omitempty"`
+	HeldAt     *time.Time `json:"held_at,omitempty"`
+	
+	// Set for emails sent with an SLA; SLABreached once the first
+	// attempt started after SLADeadline
+	SLADeadline *time.Time `json:"sla_deadline,omitempty"`
+	SLABreached bool       `json:"sla_breached,omitempty"`
+	
+	// The envelope sender (MAIL FROM) of the latest attempt
+	EnvelopeFrom string `json:"envelope_from,omitempty"`
+	
+	// The SMTP transactions tried, oldest first, up to the last
+	// email.MaxAttempts
+	Attempts []email.Attempt `json:"attempts,omitempty"`
+	
+	// Set when delivery sent some recipients' mail to a catch-all
+	// address instead
+	Redirect *email.Redirect `json:"redirect,omitempty"`
 }
 
 type StatsResponse struct {
@@ -72,12 +295,106 @@ type StatsResponse struct {
 	TotalSent      int64 `json:"total_sent"`
 	TotalDelivered int64 `json:"total_delivered"`
 	TotalFailed    int64 `json:"total_failed"`
+	TotalExpired   int64 `json:"total_expired"`
+	TotalRejected  int64 `json:"total_rejected"`
+	
+	// Queue breakdown
+	Queued                 int     `json:"queued"`
+	Sending                int     `json:"sending"`
+	Scheduled              int     `json:"scheduled"`
+	Retrying               int     `json:"retrying"`
+	Quarantined            int     `json:"quarantined"`
+	Held                   int     `json:"held"`
+	OldestQueuedAgeSeconds float64 `json:"oldest_queued_age_seconds"`
+	
+	// Most common reasons emails failed for good, most frequent first
+	FailureReasons []FailureReasonCount `json:"failure_reasons,omitempty"`
+	
+	// SMTP clients disconnected for speaking before the greeting
+	EarlyTalkers int64 `json:"early_talkers"`
+	
+	// Emails first attempted after their SLA deadline
+	TotalSLABreached int64 `json:"total_sla_breached"`
+	
+	// Recipients dropped at send time because they were suppressed after
+	// the email was accepted; total_rejected counts whole emails
+	TotalSuppressed int64 `json:"total_suppressed"`
+	
+	// Status lookups served from the cache; see SetStatusCache
+	StatusCache *StatusCacheStats `json:"status_cache,omitempty"`
+	
+	// Per-domain send rate limits and how often they held mail back; see
+	// SetThrottleStats
+	DomainThrottles []delivery.DomainThrottle `json:"domain_throttles,omitempty"`
+	
+	// The IP warm-up schedule and each domain's use of today's cap; see
+	// SetWarmupStats
+	Warmup *delivery.WarmupStats `json:"warmup,omitempty"`
+	
+	// Per-domain circuit breakers that have tripped or are counting
+	// failures; see SetBreakerStats
+	CircuitBreakers []delivery.BreakerState `json:"circuit_breakers,omitempty"`
+	
+	// Refusals because of the sending IP's reputation, and the blocklists
+	// currently cited; see SetReputationStats
+	ReputationBlock int64                      `json:"reputation_block"`
+	Blocklists      []delivery.ReputationBlock `json:"blocklists,omitempty"`
+	
+	// Whether all outbound delivery is paused; see SetDeliveryPauser
+	DeliveryPaused bool `json:"delivery_paused"`
+	
+	// Delivery workers running; see SetWorkerScaler
+	DeliveryWorkers *int `json:"delivery_workers,omitempty"`
+	
+	// How often connections to a second MX host were raced and which won;
+	// see SetRaceStats
+	Racing *delivery.RaceStats `json:"racing,omitempty"`
+}
+
+type FailureReasonCount struct {
+	Reason string `json:"reason"`
+	Count  int64  `json:"count"`
+}
+
+// maxFailureReasons caps the failure breakdown in /stats.
+const maxFailureReasons = 10
+
+// QuarantineRequest is the optional body of the quarantine and reject
+// admin actions.
+type QuarantineRequest struct {
+	Reason string `json:"reason"`
+}
+
+// HoldRequest is the optional body of a hold or release.
+type HoldRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type AuditResponse struct {
+	Entries    []audit.Entry `json:"entries"`
+	Total      int           `json:"total"`
+	ChainValid bool          `json:"chain_valid"`
 }
 
 type HealthResponse struct {
 	Status    string `json:"status"`
 	QueueSize int    `json:"queue_size"`
 	Uptime    string `json:"uptime"`
+	
+	Resources *resource.Status `json:"resources,omitempty"`
+	
+	// Why the status is degraded, such as a blocklist refusing mail
+	Reasons []string `json:"reasons,omitempty"`
+	
+	// Recipient domains whose delivery is paused; see SetDomainPauser
+	PausedDomains []delivery.DomainPause `json:"paused_domains,omitempty"`
+	
+	// Set while all outbound delivery is paused; see SetDeliveryPauser
+	DeliveryPaused *delivery.Pause `json:"delivery_paused,omitempty"`
+	
+	// Set if the startup probe found outbound port 25 blocked; see
+	// SetEgressStatus
+	Port25Blocked bool `json:"port25_blocked,omitempty"`
 }
 
 func New(cfg *config.APIConfig, q queue.Queue, maxMessageSize int64) *API {
@@ -85,44 +402,495 @@ func New(cfg *config.APIConfig, q queue.Queue, maxMessageSize int64) *API {
 		config:         cfg,
 		queue:          q,
 		maxMessageSize: maxMessageSize,
+		counters:       NewCounters(),
+		quota:          quota.NewTracker(),
+		shared:         newSharedContent(),
+		maxBatchSize:   defaultMaxBatchSize,
 		mux:            http.NewServeMux(),
 	}
 	
 	// Register routes
 	api.mux.HandleFunc("/send", api.authenticate(api.handleSendEmail))
 	api.mux.HandleFunc("/send/batch", api.authenticate(api.handleSendBatch))
+	api.mux.HandleFunc("/send/raw", api.authenticate(api.handleSendRaw))
 	api.mux.HandleFunc("/status/", api.authenticate(api.handleGetStatus))
+	api.mux.HandleFunc("/emails", api.authenticate(api.handleListEmails))
+	api.mux.HandleFunc("/emails/", api.authenticate(api.handleEmail))
 	api.mux.HandleFunc("/stats", api.authenticate(api.handleGetStats))
+	api.mux.HandleFunc("/stats/timeseries", api.authenticate(api.handleGetTimeSeries))
+	api.mux.HandleFunc("/stats/forecast", api.authenticate(api.handleGetForecast))
+	api.mux.HandleFunc("/quota", api.authenticate(api.handleGetQuota))
 	api.mux.HandleFunc("/health", api.handleHealthCheck)
+	api.mux.HandleFunc("/admin/audit", api.requireAdmin(api.handleGetAudit))
+	api.mux.HandleFunc("/admin/drain", api.requireAdmin(api.handleDrain))
+	api.mux.HandleFunc("/admin/quarantine", api.requireAdmin(api.handleQuarantine))
+	api.mux.HandleFunc("/admin/quarantine/", api.requireAdmin(api.handleQuarantine))
+	api.mux.HandleFunc("/admin/domains", api.requireAdmin(api.handleDomains))
+	api.mux.HandleFunc("/admin/domains/", api.requireAdmin(api.handleDomains))
+	api.mux.HandleFunc("/admin/delivery", api.requireAdmin(api.handleDelivery))
+	api.mux.HandleFunc("/admin/delivery/", api.requireAdmin(api.handleDelivery))
+	api.mux.HandleFunc("/admin/delivery/workers", api.requireAdmin(api.handleWorkers))
+	api.mux.HandleFunc("/admin/purge", api.requireAdmin(api.handlePurge))
+	api.mux.HandleFunc("/admin/cancel", api.requireAdmin(api.handleCancelBatch))
+	api.mux.HandleFunc("/admin/events/", api.requireAdmin(api.handleEventExport))
+	api.mux.HandleFunc("/admin/bounces", api.requireAdmin(api.handleBounces))
+	api.mux.HandleFunc("/admin/test-email", api.handleTestEmail)
 	
 	return api
 }
 
+// SetCounters reports totals from c, which should be listening to the
+// API's queue. The API then stops counting sends and delivery results
+// itself, so nothing is counted twice.
+func (a *API) SetCounters(c *Counters) {
+	a.counters = c
+	a.countersFromQueue = true
+}
+
+// SetStatusCache serves status lookups through c, which should be
+// listening to the API's queue.
+func (a *API) SetStatusCache(c *StatusCache) {
+	a.statusCache = c
+}
+
+// SetTrafficRecorder records an anonymized event for every email queued
+// through the API, for replaying the traffic later with traffic.Replay.
+func (a *API) SetTrafficRecorder(r *traffic.Recorder) {
+	a.recorder = r
+}
+
+// track starts tracking e, which has just been queued.
+func (a *API) track(e *email.Email) {
+	a.emailStatus.Store(e.ID, e)
+	if a.recorder != nil {
+		if err := a.recorder.Record(traffic.EventFor(e)); err != nil {
+			log.Printf("Failed to record traffic event: %v", err)
+		}
+	}
+	if !a.countersFromQueue {
+		a.counters.OnEnqueued(e)
+	}
+}
+
+// DeliveryResult records the outcome of a delivery attempt: it counts
+// the email as delivered or failed for /stats and brings its tracked
+// status up to date, which matters once the email has left the queue and
+// can no longer be looked up there. Install it with the delivery
+// service's SetResultHook, and a Tracker for what happens to the email in
+// the queue after that.
+func (a *API) DeliveryResult(r delivery.Result) {
+	a.invalidate(r.ID)
+	a.notifyWaiter(r)
+	if r.Status.Final() {
+		a.shared.release(r.ID)
+	}
+	
+	if !a.countersFromQueue {
+		switch r.Status {
+		case email.StatusDelivered:
+			a.counters.OnDelivered(r.ID, 0)
+		case email.StatusFailed, email.StatusBounced:
+			a.counters.OnFailed(r.ID, r.Err.Error(), false)
+		case email.StatusQueued:
+			a.counters.OnFailed(r.ID, r.Err.Error(), true)
+		}
+	}
+	
+	// The queue cannot tell bounces from other failures
+	if r.Status == email.StatusBounced {
+		a.counters.series.Add(MetricBounced, r.At, 1)
+	}
+	
+	a.updateTracked(r.ID, func(e *email.Email) {
+		e.SLABreached = e.SLABreached || r.SLABreached
+		if r.EnvelopeFrom != "" {
+			e.EnvelopeFrom = r.EnvelopeFrom
+		}
+		if e.FirstAttemptAt == nil {
+			e.FirstAttemptAt = &r.At
+		}
+		e.RecordAttempts(r.Attempts)
+		if r.Redirect != nil {
+			e.RecordRedirect(r.Redirect.To, r.Redirect.Recipients)
+		}
+		retryCount, lastError := e.RetryCount, ""
+		if r.Err != nil {
+			retryCount, lastError = r.Attempt, r.Err.Error()
+		}
+		// Reports of attempts can arrive out of order
+		if !e.Mirror(r.Status, r.At, retryCount, lastError) {
+			return
+		}
+		if r.Err != nil {
+			e.LastFailure = r.Failure
+		}
+		if len(r.Recipients) > 0 && e.RecipientStatus == nil {
+			e.RecipientStatus = make(map[string]email.RecipientStatus, len(r.Recipients))
+		}
+		for rcpt, status := range r.Recipients {
+			e.RecipientStatus[rcpt] = status
+		}
+	})
+}
+
+// updateTracked applies update to a copy of the tracked email id and
+// tracks the copy instead, doing nothing if id is not tracked. An update
+// that races another is applied again on top of it rather than lost.
+func (a *API) updateTracked(id string, update func(e *email.Email)) {
+	for {
+		value, ok := a.emailStatus.Load(id)
+		if !ok {
+			return
+		}
+		e := a.current(context.Background(), value.(*email.Email)).Clone()
+		update(e)
+		if a.emailStatus.CompareAndSwap(id, value, e) {
+			return
+		}
+	}
+}
+
+// SetQuota records daily quota usage in t, typically one opened next to
+// the queue's storage so usage survives a restart. Until it is called
+// usage is kept in memory.
+func (a *API) SetQuota(t *quota.Tracker) {
+	a.quota = t
+}
+
+// SetPolicies installs admission policies, checked before an email is
+// queued. Rejected emails are tracked but never queued.
+func (a *API) SetPolicies(policies ...policy.Policy) {
+	a.policies = policies
+}
+
+// SetRaceStats sets the source of the MX connection racing counters
+// reported in /stats, normally the delivery service's RaceStats method.
+func (a *API) SetRaceStats(stats func() delivery.RaceStats) {
+	a.raceStats = stats
+}
+
+// SetMonitor makes the API defer new submissions with 503 while the
+// monitor reports resource pressure, and report it in /health.
+func (a *API) SetMonitor(m *resource.Monitor) {
+	a.monitor = m
+}
+
+// SetFetcher enables attachments by URL or path. Without a fetcher only
+// inline attachment data is accepted.
+func (a *API) SetFetcher(f *attachment.Fetcher) {
+	a.fetcher = f
+}
+
+// SetAuditLog records admin actions in l. With strict set, admin actions
+// are refused while the log cannot be written.
+func (a *API) SetAuditLog(l *audit.Log, strict bool) {
+	a.audit = l
+	a.auditStrict = strict
+}
+
+// defaultMaxBatchSize caps batch requests until SetLimits is called.
+const defaultMaxBatchSize = 100
+
+// SetLimits applies the batch and recipient limits in cfg, and enables
+// load shedding at the queue watermarks and soft limit warnings in send
+// responses. queueCapacity is the queue's maximum size.
+func (a *API) SetLimits(cfg *config.LimitsConfig, queueCapacity int) {
+	if cfg.MaxBatchSize > 0 {
+		a.maxBatchSize = cfg.MaxBatchSize
+	}
+	a.maxRecipients = cfg.MaxRecipients
+	a.queueCapacity = queueCapacity
+	a.softWatermark = cfg.SoftWatermarkPercent
+	a.hardWatermark = cfg.HardWatermarkPercent
+	a.warnQueuePercent = cfg.WarnQueuePercent
+	a.warnSizePercent = cfg.WarnSizePercent
+	a.warnQuotaPercent = cfg.WarnQuotaPercent
+}
+
+// warnings lists the soft limits crossed by accepting e, charged to a key
+// whose quota then stands at usage.
+func (a *API) warnings(e *email.Email, usage quota.Usage) []string {
+	var warnings []string
+	
+	if a.warnQueuePercent > 0 && a.queueCapacity > 0 {
+		used := float64(a.queue.Size()) * 100 / float64(a.queueCapacity)
+		if used >= a.warnQueuePercent {
+			warnings = append(warnings, fmt.Sprintf("queue utilization %.0f%%", used))
+		}
+	}
+	
+	if a.warnSizePercent > 0 && a.maxMessageSize > 0 {
+		size := e.Size()
+		if float64(size)*100/float64(a.maxMessageSize) >= a.warnSizePercent {
+			warnings = append(warnings, fmt.Sprintf("message size %s of %s limit",
+				formatMB(size), formatMB(a.maxMessageSize)))
+		}
+	}
+	
+	if a.warnQuotaPercent > 0 && usage.Limit > 0 {
+		if float64(usage.Used)*100/float64(usage.Limit) >= a.warnQuotaPercent {
+			warnings = append(warnings, fmt.Sprintf("%s of %s daily quota used",
+				formatCount(usage.Used), formatCount(usage.Limit)))
+		}
+	}
+	
+	return warnings
+}
+
+// formatCount formats n with commas between groups of three digits.
+func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0 && s[i-1] != '-'; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// formatMB formats n bytes as megabytes with at most one decimal.
+func formatMB(n int64) string {
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/(1024*1024)), ".0") + "MB"
+}
+
+// SetDrainHook sets a function run in the background when a drain is
+// requested through the admin API, to drain the rest of the server.
+func (a *API) SetDrainHook(hook func()) {
+	a.drainHook = hook
+}
+
+// SetEarlyTalkers sets the source of the early talker count reported in
+// /stats, normally the SMTP server's EarlyTalkers method.
+func (a *API) SetEarlyTalkers(count func() int64) {
+	a.earlyTalkers = count
+}
+
+// SetThrottleStats sets the source of the per-domain rate limit state
+// reported in /stats, normally the delivery service's ThrottleStats method.
+func (a *API) SetThrottleStats(stats func() []delivery.DomainThrottle) {
+	a.throttles = stats
+}
+
+// SetWarmupStats sets the source of the warm-up schedule state reported in
+// /stats, normally the delivery service's WarmupStats method.
+func (a *API) SetWarmupStats(stats func() *delivery.WarmupStats) {
+	a.warmup = stats
+}
+
+// SetBreakerStats sets the source of the per-domain circuit breaker state
+// reported in /stats, normally the delivery service's BreakerStats method.
+func (a *API) SetBreakerStats(stats func() []delivery.BreakerState) {
+	a.breakers = stats
+}
+
+// SetReputationStats sets the source of the sender reputation refusals
+// counted in /stats and reported as degraded in /health, normally the
+// delivery service's ReputationStats method.
+func (a *API) SetReputationStats(stats func() delivery.ReputationStats) {
+	a.reputation = stats
+}
+
+// SetEgressStatus sets the source of the startup port 25 check reported
+// in /health, normally the delivery service's EgressStatus method.
+func (a *API) SetEgressStatus(status func() delivery.EgressStatus) {
+	a.egress = status
+}
+
+// Drain makes the API refuse new submissions with 503 and report
+// "draining" in /health so load balancers take the node out.
+func (a *API) Drain() {
+	a.draining.Store(true)
+}
+
 func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.mux.ServeHTTP(w, r)
 }
 
 func (a *API) authenticate(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		if auth == "" {
-			a.errorResponse(w, http.StatusUnauthorized, "missing authorization header")
+		token, err := a.credential(r)
+		if err != nil {
+			a.errorResponse(w, http.StatusUnauthorized, err.Error())
 			return
 		}
 		
-		parts := strings.Split(auth, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			a.errorResponse(w, http.StatusUnauthorized, "invalid authorization format")
+		name, ok := a.keyName(token)
+		if !ok {
+			a.errorResponse(w, http.StatusUnauthorized, "invalid token")
 			return
 		}
 		
-		if parts[1] != a.config.AuthToken {
-			a.errorResponse(w, http.StatusUnauthorized, "invalid token")
+		ctx := context.WithValue(r.Context(), actorKey{}, name)
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// keyName returns the name of the key whose token matches, or defaultActor
+// for the main auth token. Every token is compared so the time taken does
+// not reveal which one matched.
+func (a *API) keyName(token string) (string, bool) {
+	name, ok := "", false
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.config.AuthToken)) == 1 {
+		name, ok = defaultActor, true
+	}
+	for _, key := range a.config.Keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.Token)) == 1 && !ok {
+			name, ok = key.Name, true
+		}
+	}
+	return name, ok
+}
+
+// isAdmin reports whether the named key may use the /admin endpoints and
+// reach every email: the main auth token always can, other keys only when
+// configured with admin.
+func (a *API) isAdmin(name string) bool {
+	if name == defaultActor {
+		return true
+	}
+	key := a.key(name)
+	return key != nil && key.Admin
+}
+
+// requireAdmin authenticates like authenticate, then refuses keys without
+// admin access.
+func (a *API) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return a.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if !a.isAdmin(actor(r)) {
+			a.errorResponse(w, http.StatusForbidden, "admin access required")
 			return
 		}
-		
 		handler(w, r)
+	})
+}
+
+// canSee reports whether the caller may see or act on e. Keys without
+// admin access only reach the emails they submitted.
+func (a *API) canSee(r *http.Request, e *email.Email) bool {
+	name := actor(r)
+	return e.SubmittedBy == name || a.isAdmin(name)
+}
+
+// owns reports whether the caller may act on the email id, going by its
+// tracked record. Emails the API is not tracking, such as mail received
+// over SMTP, are only reachable by admins.
+func (a *API) owns(r *http.Request, id string) bool {
+	if a.isAdmin(actor(r)) {
+		return true
+	}
+	value, ok := a.emailStatus.Load(id)
+	return ok && a.canSee(r, value.(*email.Email))
+}
+
+// key returns the configuration of the named key, or nil for the main
+// auth token.
+func (a *API) key(name string) *config.APIKeyConfig {
+	for i := range a.config.Keys {
+		if a.config.Keys[i].Name == name {
+			return &a.config.Keys[i]
+		}
+	}
+	return nil
+}
+
+// dailyQuota returns the daily quota of the named key, or zero if it has
+// none.
+func (a *API) dailyQuota(name string) int {
+	if key := a.key(name); key != nil {
+		return key.DailyQuota
+	}
+	return 0
+}
+
+// batchLimit returns the caller's batch size limit.
+func (a *API) batchLimit(r *http.Request) int {
+	if key := a.key(actor(r)); key != nil && key.MaxBatchSize > 0 {
+		return key.MaxBatchSize
+	}
+	return a.maxBatchSize
+}
+
+// checkRecipients returns an error if e has more recipients than the
+// caller may send to at once. A limit of zero means no limit.
+func (a *API) checkRecipients(r *http.Request, e *email.Email) error {
+	limit := a.maxRecipients
+	if key := a.key(actor(r)); key != nil && key.MaxRecipients > 0 {
+		limit = key.MaxRecipients
+	}
+	if limit > 0 && len(e.Recipients()) > limit {
+		return fmt.Errorf("too many recipients (limit %d)", limit)
+	}
+	return nil
+}
+
+// chargeQuota takes up to n sends from the caller's daily quota, sets the
+// quota headers and returns how many were granted and the usage after.
+func (a *API) chargeQuota(w http.ResponseWriter, r *http.Request, n int) (int, quota.Usage) {
+	name := actor(r)
+	granted, usage := a.quota.Take(name, a.dailyQuota(name), n)
+	setQuotaHeaders(w, usage)
+	return granted, usage
+}
+
+// refundQuota returns n charged sends that were not queued, sets the
+// quota headers and returns the usage left.
+func (a *API) refundQuota(w http.ResponseWriter, r *http.Request, n int) quota.Usage {
+	name := actor(r)
+	usage := a.quota.Return(name, a.dailyQuota(name), n)
+	setQuotaHeaders(w, usage)
+	return usage
+}
+
+// quotaExceeded responds 429 with the quota headers and a Retry-After
+// lasting until the quota resets.
+func (a *API) quotaExceeded(w http.ResponseWriter, r *http.Request) {
+	name := actor(r)
+	usage := a.quota.Usage(name, a.dailyQuota(name))
+	setQuotaHeaders(w, usage)
+	wait := time.Until(usage.ResetAt).Round(time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+	a.errorResponse(w, http.StatusTooManyRequests, "daily quota exceeded")
+}
+
+// setQuotaHeaders reports a limited key's usage in the X-Quota-* headers;
+// the reset time is in Unix seconds.
+func setQuotaHeaders(w http.ResponseWriter, usage quota.Usage) {
+	if usage.Limit == 0 {
+		return
+	}
+	w.Header().Set("X-Quota-Limit", strconv.Itoa(usage.Limit))
+	w.Header().Set("X-Quota-Remaining", strconv.Itoa(usage.Remaining))
+	w.Header().Set("X-Quota-Reset", strconv.FormatInt(usage.ResetAt.Unix(), 10))
+}
+
+// credential extracts the API token from the Authorization header, the
+// X-API-Key header or, when enabled, the api_key query parameter, in that
+// order. The scheme is matched case-insensitively and surrounding
+// whitespace is ignored.
+func (a *API) credential(r *http.Request) (string, error) {
+	if auth := strings.TrimSpace(r.Header.Get("Authorization")); auth != "" {
+		scheme, token := auth, ""
+		if i := strings.IndexAny(auth, " \t"); i >= 0 {
+			scheme, token = auth[:i], strings.TrimSpace(auth[i+1:])
+		}
+		if !strings.EqualFold(scheme, "Bearer") {
+			return "", errors.New("unsupported authorization scheme, expected Bearer")
+		}
+		if token == "" {
+			return "", errors.New("missing bearer token")
+		}
+		return token, nil
+	}
+	
+	if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" {
+		return key, nil
+	}
+	
+	if a.config.AllowQueryToken {
+		if key := strings.TrimSpace(r.URL.Query().Get("api_key")); key != "" {
+			return key, nil
+		}
 	}
+	
+	return "", errors.New("missing authorization header")
 }
 
 func (a *API) handleSendEmail(w http.ResponseWriter, r *http.Request) {
@@ -131,99 +899,271 @@ func (a *API) handleSendEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	if a.shedding(w) {
+		return
+	}
+	
 	var req SendEmailRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		a.errorResponse(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
 	
+	if a.overloaded(w, email.Lane(req.Lane) == email.LaneBulk) {
+		return
+	}
+	
+	if req.Durability == "" {
+		req.Durability = r.Header.Get("X-Durability")
+	}
+	if req.Durability != "" && req.Durability != DurabilityConfirmed {
+		a.errorResponse(w, http.StatusBadRequest, "durability must be \"confirmed\" when set")
+		return
+	}
+	
 	// Create email
 	e := &email.Email{
-		ID:          uuid.New().String(),
-		From:        req.From,
-		To:          req.To,
-		CC:          req.CC,
-		BCC:         req.BCC,
-		Subject:     req.Subject,
-		Body:        req.Body,
-		HTML:        req.HTML,
-		Headers:     req.Headers,
-		Status:      email.StatusQueued,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
-		ScheduledAt: req.ScheduledAt,
+		ID:              uuid.New().String(),
+		From:            req.From,
+		To:              req.To,
+		CC:              req.CC,
+		BCC:             req.BCC,
+		Subject:         req.Subject,
+		Body:            req.Body,
+		HTML:            req.HTML,
+		Headers:         req.Headers,
+		Status:          email.StatusQueued,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+		ScheduledAt:     req.ScheduledAt,
+		AllowDuplicate:  req.AllowDuplicate,
+		RaceMX:          req.RaceMX,
+		Sandbox:         req.Sandbox,
+		Debug:           req.Debug,
+		NotifyOnFailure: req.NotifyOnFailure,
+		Lane:            email.Lane(req.Lane),
+		Priority:        req.Priority,
+		MaxRetry:        req.MaxRetry,
+		SubmittedBy:     actor(r),
+	}
+	
+	deadline, err := slaDeadline(req.SLA, e.CreatedAt, e.ScheduledAt)
+	if err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	e.SLADeadline = deadline
+	
+	attachments, err := a.attachments(r.Context(), req.Attachments)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
 	}
+	e.Attachments = attachments
 	
 	// Validate
 	if err := e.Validate(a.maxMessageSize); err != nil {
 		a.errorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if err := a.checkRecipients(r, e); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	
+	// Check admission policies
+	if a.reject(r.Context(), e) {
+		a.rejectedResponse(w, e)
+		return
+	}
+	
+	granted, usage := a.chargeQuota(w, r, 1)
+	if granted == 0 {
+		a.quotaExceeded(w, r)
+		return
+	}
 	
 	// Enqueue
-	if err := a.queue.Enqueue(e); err != nil {
+	if err := a.queue.Enqueue(r.Context(), e); err != nil {
+		a.refundQuota(w, r, 1)
 		if err == queue.ErrQueueFull {
 			a.errorResponse(w, http.StatusServiceUnavailable, "queue is full")
 			return
 		}
+		if err == queue.ErrDraining {
+			a.errorResponse(w, http.StatusServiceUnavailable, "server is draining")
+			return
+		}
+		if r.Context().Err() != nil {
+			// Client went away; nobody is left to read a response
+			return
+		}
+		var dupErr *queue.DuplicateError
+		if errors.As(err, &dupErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(SendEmailResponse{
+				ID:      dupErr.OriginalID,
+				Status:  "duplicate",
+				Message: "identical email already queued",
+			})
+			return
+		}
 		a.errorResponse(w, http.StatusInternalServerError, "failed to queue email")
 		return
 	}
 	
-	// Track email
-	a.emailStatus.Store(e.ID, e)
-	a.totalSent.Add(1)
+	a.track(e)
 	
 	// Response
 	resp := SendEmailResponse{
 		ID:      e.ID,
-		Status:  string(e.Status),
-		Message: "Email queued for delivery",
+		Status:   string(email.StatusQueued),
+		Message:  "Email queued for delivery",
+		Warnings: a.warnings(e, usage),
 	}
 	
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
+	code := http.StatusAccepted
+	if req.Durability == DurabilityConfirmed {
+		committed, err := a.commit(r.Context(), e.ID)
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			log.Printf("Email %s queued but not committed: %v", e.ID, err)
+			a.errorResponse(w, http.StatusInternalServerError, "email queued but not committed: "+err.Error())
+			return
+		}
+		if committed {
+			code = http.StatusCreated
+			resp.Committed = true
+			resp.Message = "Email committed for delivery"
+		}
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(resp)
 }
 
+// commit waits for the queue backend to commit the email to durable
+// storage. It reports false without waiting when the backend keeps
+// emails only in memory.
+func (a *API) commit(ctx context.Context, id string) (bool, error) {
+	c, ok := a.queue.(queue.Committer)
+	if !ok {
+		return false, nil
+	}
+	if err := c.Commit(ctx, id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (a *API) handleSendBatch(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	
+	if a.shedding(w) {
+		return
+	}
+	
 	var requests []SendEmailRequest
 	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
 		a.errorResponse(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
 	
-	if len(requests) > 100 {
-		a.errorResponse(w, http.StatusBadRequest, "batch size exceeds limit (100)")
+	if limit := a.batchLimit(r); len(requests) > limit {
+		a.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("batch size exceeds limit (%d)", limit))
+		return
+	}
+	
+	if a.overloaded(w, true) {
+		return
+	}
+	
+	// The whole batch is charged at once. It is refused outright only when
+	// no quota is left; otherwise emails are accepted until the grant runs
+	// out, and whatever they did not use is given back at the end.
+	granted, usage := a.chargeQuota(w, r, len(requests))
+	if granted == 0 && len(requests) > 0 {
+		a.quotaExceeded(w, r)
 		return
 	}
+	charged := 0
 	
 	responses := make([]SendEmailResponse, 0, len(requests))
 	
-	for _, req := range requests {
+	for i := range requests {
+		// Stop early if the client disconnected
+		if r.Context().Err() != nil {
+			a.refundQuota(w, r, granted-charged)
+			return
+		}
+		
+		// Let the decoded request go once its email is built
+		req := requests[i]
+		requests[i] = SendEmailRequest{}
+		
 		e := &email.Email{
-			ID:          uuid.New().String(),
-			From:        req.From,
-			To:          req.To,
-			CC:          req.CC,
-			BCC:         req.BCC,
-			Subject:     req.Subject,
-			Body:        req.Body,
-			HTML:        req.HTML,
-			Headers:     req.Headers,
-			Status:      email.StatusQueued,
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
-			ScheduledAt: req.ScheduledAt,
+			ID:              uuid.New().String(),
+			From:            req.From,
+			To:              req.To,
+			CC:              req.CC,
+			BCC:             req.BCC,
+			Subject:         req.Subject,
+			Body:            req.Body,
+			HTML:            req.HTML,
+			Headers:         req.Headers,
+			Status:          email.StatusQueued,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+			ScheduledAt:     req.ScheduledAt,
+			AllowDuplicate:  req.AllowDuplicate,
+			RaceMX:          req.RaceMX,
+			Sandbox:         req.Sandbox,
+			Debug:           req.Debug,
+			NotifyOnFailure: req.NotifyOnFailure,
+			Lane:            email.Lane(req.Lane),
+			Priority:        req.Priority,
+			MaxRetry:        req.MaxRetry,
+			SubmittedBy:     actor(r),
 		}
 		
+		deadline, err := slaDeadline(req.SLA, e.CreatedAt, e.ScheduledAt)
+		if err != nil {
+			responses = append(responses, SendEmailResponse{
+				ID:      "",
+				Status:  "error",
+				Message: err.Error(),
+			})
+			continue
+		}
+		e.SLADeadline = deadline
+		
+		attachments, err := a.attachments(r.Context(), req.Attachments)
+		if err != nil {
+			responses = append(responses, SendEmailResponse{
+				ID:      "",
+				Status:  "error",
+				Message: err.Error(),
+			})
+			continue
+		}
+		e.Attachments = attachments
+		
 		// Validate
-		if err := e.Validate(a.maxMessageSize); err != nil {
+		err = e.Validate(a.maxMessageSize)
+		if err == nil {
+			err = a.checkRecipients(r, e)
+		}
+		if err != nil {
 			responses = append(responses, SendEmailResponse{
 				ID:      "",
 				Status:  "error",
@@ -232,8 +1172,43 @@ func (a *API) handleSendBatch(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		
+		// Check admission policies
+		if a.reject(r.Context(), e) {
+			responses = append(responses, SendEmailResponse{
+				ID:      e.ID,
+				Status:  string(e.Status),
+				Message: e.RejectReason,
+			})
+			continue
+		}
+		
+		if charged == granted {
+			responses = append(responses, SendEmailResponse{
+				ID:      "",
+				Status:  "error",
+				Message: "daily quota exceeded",
+			})
+			continue
+		}
+		charged++
+		
+		// Emails with the same content keep one copy of it between them
+		// until they finish
+		a.shared.share(e)
+		
 		// Enqueue
-		if err := a.queue.Enqueue(e); err != nil {
+		if err := a.queue.Enqueue(r.Context(), e); err != nil {
+			a.shared.release(e.ID)
+			charged--
+			var dupErr *queue.DuplicateError
+			if errors.As(err, &dupErr) {
+				responses = append(responses, SendEmailResponse{
+					ID:      dupErr.OriginalID,
+					Status:  "duplicate",
+					Message: "identical email already queued",
+				})
+				continue
+			}
 			responses = append(responses, SendEmailResponse{
 				ID:      "",
 				Status:  "error",
@@ -242,24 +1217,304 @@ func (a *API) handleSendBatch(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		
-		// Track email
-		a.emailStatus.Store(e.ID, e)
-		a.totalSent.Add(1)
-		
+		a.track(e)
+			
 		responses = append(responses, SendEmailResponse{
 			ID:      e.ID,
-			Status:  string(e.Status),
-			Message: "Email queued for delivery",
+			Status:   string(email.StatusQueued),
+			Message:  "Email queued for delivery",
+			Warnings: a.warnings(e, quota.Usage{Limit: usage.Limit, Used: usage.Used - granted + charged}),
 		})
 	}
 	
+	if charged < granted {
+		a.refundQuota(w, r, granted-charged)
+	}
+	
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(responses)
 }
 
+// handleSendRaw accepts a complete message/rfc822 body. The envelope is
+// taken from the from/to query parameters or X-Envelope-From/X-Envelope-To
+// headers, falling back to the message's own From, To and Cc headers.
+func (a *API) handleSendRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	
+	if a.shedding(w) || a.overloaded(w, false) {
+		return
+	}
+	
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || mediaType != "message/rfc822" {
+			a.errorResponse(w, http.StatusUnsupportedMediaType, "content type must be message/rfc822")
+			return
+		}
+	}
+	
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, a.maxMessageSize+1))
+	if err != nil {
+		a.errorResponse(w, http.StatusRequestEntityTooLarge, email.ErrMessageTooLarge.Error())
+		return
+	}
+	
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		from = r.Header.Get("X-Envelope-From")
+	}
+	to := splitAddresses(r.URL.Query()["to"])
+	if len(to) == 0 {
+		to = splitAddresses(r.Header.Values("X-Envelope-To"))
+	}
+	
+	e, err := email.Parse(from, to, bytes.NewReader(raw))
+	if err != nil {
+		a.errorResponse(w, http.StatusBadRequest, "invalid message: "+err.Error())
+		return
+	}
+	
+	// Fall back to the message headers for any missing envelope parts
+	if e.From == "" {
+		if addr, err := mail.ParseAddress(e.Headers["From"]); err == nil {
+			e.From = addr.Address
+		}
+	}
+	if len(e.To) == 0 {
+		e.To = splitAddresses([]string{e.Headers["To"]})
+		e.To = append(e.To, e.CC...)
+		e.CC = nil
+	}
+	
+	e.ID = uuid.New().String()
+	e.Raw = raw
+	e.BCC = nil
+	e.SubmittedBy = actor(r)
+	e.CreatedAt = time.Now()
+	e.MarkQueued(e.CreatedAt)
+	
+	// Validate
+	if err := e.Validate(a.maxMessageSize); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := a.checkRecipients(r, e); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	
+	// Check admission policies
+	if a.reject(r.Context(), e) {
+		a.rejectedResponse(w, e)
+		return
+	}
+	
+	granted, usage := a.chargeQuota(w, r, 1)
+	if granted == 0 {
+		a.quotaExceeded(w, r)
+		return
+	}
+	
+	// Enqueue
+	if err := a.queue.Enqueue(r.Context(), e); err != nil {
+		a.refundQuota(w, r, 1)
+		if err == queue.ErrQueueFull {
+			a.errorResponse(w, http.StatusServiceUnavailable, "queue is full")
+			return
+		}
+		if err == queue.ErrDraining {
+			a.errorResponse(w, http.StatusServiceUnavailable, "server is draining")
+			return
+		}
+		if r.Context().Err() != nil {
+			// Client went away; nobody is left to read a response
+			return
+		}
+		var dupErr *queue.DuplicateError
+		if errors.As(err, &dupErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(SendEmailResponse{
+				ID:      dupErr.OriginalID,
+				Status:  "duplicate",
+				Message: "identical email already queued",
+			})
+			return
+		}
+		a.errorResponse(w, http.StatusInternalServerError, "failed to queue email")
+		return
+	}
+	
+	a.track(e)
+	
+	resp := SendEmailResponse{
+		ID:      e.ID,
+		Status:   string(email.StatusQueued),
+		Message:  "Email queued for delivery",
+		Warnings: a.warnings(e, usage),
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// slaDeadline returns when delivery of an email accepted at now must
+// start, or nil if no SLA was requested. A scheduled email's SLA runs
+// from its scheduled time.
+func slaDeadline(sla string, now time.Time, scheduledAt *time.Time) (*time.Time, error) {
+	if sla == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(sla)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid sla %q: must be a positive duration such as \"60s\"", sla)
+	}
+	
+	start := now
+	if scheduledAt != nil && scheduledAt.After(start) {
+		start = *scheduledAt
+	}
+	deadline := start.Add(d)
+	return &deadline, nil
+}
+
+// attachments resolves the requested attachments, fetching any given by
+// reference. Errors name the attachment that failed.
+func (a *API) attachments(ctx context.Context, specs []AttachmentRequest) ([]email.Attachment, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	
+	result := make([]email.Attachment, 0, len(specs))
+	for i, spec := range specs {
+		if spec.Filename == "" {
+			return nil, fmt.Errorf("attachment %d: filename is required", i+1)
+		}
+		
+		data := spec.Data
+		if spec.URL != "" || spec.Path != "" {
+			var err error
+			if a.fetcher == nil {
+				err = attachment.ErrFetchDisabled
+			} else {
+				data, err = a.fetcher.Fetch(ctx, attachment.Ref{
+					URL:    spec.URL,
+					Path:   spec.Path,
+					SHA256: spec.SHA256,
+				})
+			}
+			if err != nil {
+				return nil, fmt.Errorf("attachment %q: %w", spec.Filename, err)
+			}
+		} else if len(data) == 0 {
+			return nil, fmt.Errorf("attachment %q: %w", spec.Filename, attachment.ErrNoSource)
+		}
+		
+		contentType := spec.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		
+		result = append(result, email.Attachment{
+			Filename:    spec.Filename,
+			ContentType: contentType,
+			Data:        data,
+		})
+	}
+	return result, nil
+}
+
+// shedding defers the request with 503 while draining or while resources
+// are constrained.
+func (a *API) shedding(w http.ResponseWriter) bool {
+	if a.draining.Load() {
+		a.errorResponse(w, http.StatusServiceUnavailable, "server is draining")
+		return true
+	}
+	
+	if a.monitor == nil || !a.monitor.Degraded() {
+		return false
+	}
+	
+	w.Header().Set("Retry-After", "30")
+	a.errorResponse(w, http.StatusServiceUnavailable, "server is under resource pressure, retry later")
+	return true
+}
+
+// overloaded sheds submissions as the queue fills. Above the hard
+// watermark everything is refused with 503; above the soft watermark
+// deferrable submissions (bulk and batch) get 429 so transactional mail
+// still gets through.
+func (a *API) overloaded(w http.ResponseWriter, deferrable bool) bool {
+	if a.queueCapacity <= 0 {
+		return false
+	}
+	
+	used := float64(a.queue.Size()) * 100 / float64(a.queueCapacity)
+	switch {
+	case a.hardWatermark > 0 && used >= a.hardWatermark:
+		w.Header().Set("Retry-After", "30")
+		a.errorResponse(w, http.StatusServiceUnavailable, "queue is full, retry later")
+		return true
+	case deferrable && a.softWatermark > 0 && used >= a.softWatermark:
+		w.Header().Set("Retry-After", "30")
+		a.errorResponse(w, http.StatusTooManyRequests, "queue is busy, retry bulk sends later")
+		return true
+	}
+	return false
+}
+
+// reject evaluates the admission policies against e. A rejected email is
+// tracked so its status can be looked up, and reject returns true.
+func (a *API) reject(ctx context.Context, e *email.Email) bool {
+	name, err := policy.Evaluate(ctx, a.policies, e)
+	if err == nil {
+		return false
+	}
+	
+	e.Reject(name, err.Error())
+	a.emailStatus.Store(e.ID, e)
+	a.counters.rejected.Add(1)
+	return true
+}
+
+func (a *API) rejectedResponse(w http.ResponseWriter, e *email.Email) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(SendEmailResponse{
+		ID:      e.ID,
+		Status:  string(e.Status),
+		Message: e.RejectReason,
+	})
+}
+
+// splitAddresses flattens repeated and comma-separated address values.
+func splitAddresses(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if list, err := email.ParseAddressList(v); err == nil {
+			result = append(result, list...)
+			continue
+		}
+		for _, addr := range strings.Split(v, ",") {
+			if trimmed := strings.TrimSpace(addr); trimmed != "" {
+				result = append(result, trimmed)
+			}
+		}
+	}
+	return result
+}
+
 func (a *API) handleGetStatus(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
 		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
@@ -271,27 +1526,269 @@ func (a *API) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	if id, ok := strings.CutSuffix(path, "/transcript"); ok {
+		a.handleTranscript(w, r, id)
+		return
+	}
+	
+	if r.Method == http.MethodDelete {
+		a.cancelEmail(w, r, path)
+		return
+	}
+	
 	// Look up email
 	value, ok := a.emailStatus.Load(path)
+	if !ok || !a.canSee(r, value.(*email.Email)) {
+		a.errorResponse(w, http.StatusNotFound, "email not found")
+		return
+	}
+	
+	resp := statusResponse(a.current(r.Context(), value.(*email.Email)))
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// cancelEmail cancels an email that has not been delivered yet. A waiting
+// email is rejected at once; one being delivered is rejected before its
+// SMTP transaction unless that has already started.
+func (a *API) cancelEmail(w http.ResponseWriter, r *http.Request, id string) {
+	c, ok := a.queue.(queue.Canceller)
 	if !ok {
+		a.errorResponse(w, http.StatusNotImplemented, "queue does not support cancellation")
+		return
+	}
+	if !a.owns(r, id) {
+		a.errorResponse(w, http.StatusNotFound, "email not found or already finished")
+		return
+	}
+	
+	var req QuarantineRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		a.errorResponse(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	
+	params := map[string]string{"id": id}
+	if req.Reason != "" {
+		params["reason"] = req.Reason
+	}
+	_, err := a.audited(r, "cancel", params, func() (int, error) {
+		defer a.invalidate(id)
+		if err := c.Cancel(r.Context(), id, req.Reason); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	})
+	switch {
+	case err == nil:
+	case r.Context().Err() != nil:
+		return
+	case errors.Is(err, queue.ErrEmailNotFound):
+		a.errorResponse(w, http.StatusNotFound, "email not found or already finished")
+		return
+	case errors.Is(err, email.ErrInvalidTransition):
+		a.errorResponse(w, http.StatusConflict, "email can no longer be cancelled")
+		return
+	default:
+		a.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	
+	// An email still in the queue is being delivered
+	if g, ok := a.queue.(queue.Getter); ok {
+		if _, err := g.Get(r.Context(), id); err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(SendEmailResponse{
+				ID:      id,
+				Status:  string(email.StatusSending),
+				Message: "Email will be cancelled before it is sent",
+			})
+			return
+		}
+	}
+	
+	a.updateTracked(id, func(e *email.Email) {
+		e.Reject(queue.CancelledBy, req.Reason)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SendEmailResponse{
+		ID:      id,
+		Status:  string(email.StatusRejected),
+		Message: "Email cancelled",
+	})
+}
+
+// handleListEmails lists tracked emails, optionally filtered by the status
+// query parameter. Keys without admin access see only their own.
+func (a *API) handleListEmails(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	
+	status := email.Status(r.URL.Query().Get("status"))
+	
+	resp := make([]StatusResponse, 0)
+	a.emailStatus.Range(func(key, value any) bool {
+		if !a.canSee(r, value.(*email.Email)) {
+			return true
+		}
+		e := a.current(r.Context(), value.(*email.Email))
+		if status == "" || e.Status == status {
+			resp = append(resp, statusResponse(e))
+		}
+		return true
+	})
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].CreatedAt.Before(resp[j].CreatedAt)
+	})
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleEmail serves the /emails/{id} endpoints: the raw message, and
+// holding and releasing the email.
+func (a *API) handleEmail(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/emails/")
+	if strings.HasSuffix(path, "/hold") || strings.HasSuffix(path, "/release") {
+		a.handleHold(w, r)
+		return
+	}
+	a.handleGetRaw(w, r)
+}
+
+// handleGetRaw serves GET /emails/{id}/raw: the message exactly as it will
+// be sent. Mail relayed over SMTP is returned as received, behind our trace
+// header; other mail is built from its fields the way delivery builds it.
+func (a *API) handleGetRaw(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/emails/"), "/raw")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		a.errorResponse(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	
+	// Mail received over SMTP is only known to the queue
+	var e *email.Email
+	if g, ok := a.queue.(queue.Getter); ok {
+		e, _ = g.Get(r.Context(), id)
+	}
+	if e == nil {
+		value, ok := a.emailStatus.Load(id)
+		if !ok {
+			a.errorResponse(w, http.StatusNotFound, "email not found")
+			return
+		}
+		e = value.(*email.Email)
+	}
+	if !a.canSee(r, e) {
 		a.errorResponse(w, http.StatusNotFound, "email not found")
 		return
 	}
 	
-	e := value.(*email.Email)
+	var buf bytes.Buffer
+	if err := delivery.WriteMessage(&buf, e, a.config.Identity); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			a.errorResponse(w, http.StatusGone, "message is no longer stored")
+			return
+		}
+		a.errorResponse(w, http.StatusInternalServerError, "failed to render message")
+		return
+	}
 	
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Write(buf.Bytes())
+}
+
+// current returns an up-to-date copy of a tracked email from the queue.
+// Once the email has left the queue it no longer changes, so the tracked
+// record itself is returned. Until then the record may be the queue's
+// own, changed under the queue's lock, so it is not read before the
+// queue says it is gone.
+func (a *API) current(ctx context.Context, e *email.Email) *email.Email {
+	g, ok := a.queue.(queue.Getter)
+	if !ok {
+		return e
+	}
+	
+	var c *email.Email
+	var err error
+	if a.statusCache != nil {
+		c, err = a.statusCache.Get(ctx, g, e.ID)
+	} else {
+		c, err = g.Get(ctx, e.ID)
+	}
+	if err != nil {
+		return e
+	}
+	return c
+}
+
+// invalidate drops any cached status for id after the API has changed it.
+func (a *API) invalidate(id string) {
+	if a.statusCache != nil {
+		a.statusCache.Invalidate(id)
+	}
+}
+
+func statusResponse(e *email.Email) StatusResponse {
 	resp := StatusResponse{
-		ID:          e.ID,
-		Status:      string(e.Status),
-		RetryCount:  e.RetryCount,
-		LastError:   e.LastError,
-		CreatedAt:   e.CreatedAt,
-		UpdatedAt:   e.UpdatedAt,
-		DeliveredAt: e.DeliveredAt,
+		ID:           e.ID,
+		Status:       string(e.Status),
+		RetryCount:   e.RetryCount,
+		DeferCount:   e.DeferCount,
+		LastError:    e.LastError,
+		CreatedAt:    e.CreatedAt,
+		UpdatedAt:    e.UpdatedAt,
+		DeliveredAt:  e.DeliveredAt,
+		ExpiresAt:    e.ExpiresAt,
+		Priority:     e.Priority,
+		RejectedBy:   e.RejectedBy,
+		RejectReason: e.RejectReason,
+		
+		DeferredReason:   e.DeferredReason,
+		FirstAttemptAt:   e.FirstAttemptAt,
+		LastFailure:      e.LastFailure,
+		Recipients:       e.RecipientStatus,
+		QuarantineReason: e.QuarantineReason,
+		QuarantinedAt:    e.QuarantinedAt,
+		HoldReason:       e.HoldReason,
+		HeldAt:           e.HeldAt,
+		SLADeadline:      e.SLADeadline,
+		SLABreached:      e.SLABreached,
+		EnvelopeFrom:     e.EnvelopeFrom,
+		Attempts:         withoutTranscripts(e.Attempts),
+		Redirect:         e.Redirect,
+	}
+	if e.Status == email.StatusQueued {
+		resp.NextAttemptAt = e.ScheduledAt
+	}
+	return resp
+}
+
+func (a *API) handleGetQuota(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
 	}
 	
+	name := actor(r)
+	usage := a.quota.Usage(name, a.dailyQuota(name))
+	setQuotaHeaders(w, usage)
+	
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(QuotaResponse{
+		Key:       name,
+		Limit:     usage.Limit,
+		Used:      usage.Used,
+		Remaining: usage.Remaining,
+		ResetAt:   usage.ResetAt,
+	})
 }
 
 func (a *API) handleGetStats(w http.ResponseWriter, r *http.Request) {
@@ -300,17 +1797,382 @@ func (a *API) handleGetStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	queueStats := a.queue.Stats()
+	
 	resp := StatsResponse{
-		QueueSize:      a.queue.Size(),
-		TotalSent:      a.totalSent.Load(),
-		TotalDelivered: a.totalDelivered.Load(),
-		TotalFailed:    a.totalFailed.Load(),
+		QueueSize:              a.queue.Size(),
+		TotalSent:              a.counters.sent.Load(),
+		TotalDelivered:         a.counters.delivered.Load(),
+		TotalFailed:            a.counters.failed.Load(),
+		TotalExpired:           queueStats.TotalExpired,
+		TotalRejected:          a.counters.rejected.Load() + queueStats.TotalRejected,
+		Queued:                 queueStats.Queued,
+		Sending:                queueStats.Sending,
+		Scheduled:              queueStats.Scheduled,
+		Retrying:               queueStats.Retrying,
+		Quarantined:            queueStats.Quarantined,
+		Held:                   queueStats.Held,
+		OldestQueuedAgeSeconds: queueStats.OldestQueuedAge.Seconds(),
+		FailureReasons:         topFailureReasons(queueStats.FailureCategories),
+		TotalSLABreached:       queueStats.TotalSLABreached,
+		TotalSuppressed:        queueStats.TotalSuppressed,
+	}
+	if a.earlyTalkers != nil {
+		resp.EarlyTalkers = a.earlyTalkers()
+	}
+	if a.throttles != nil {
+		resp.DomainThrottles = a.throttles()
+	}
+	if a.warmup != nil {
+		resp.Warmup = a.warmup()
+	}
+	if a.breakers != nil {
+		resp.CircuitBreakers = a.breakers()
+	}
+	if a.reputation != nil {
+		stats := a.reputation()
+		resp.ReputationBlock = stats.Blocked
+		resp.Blocklists = stats.Active
+	}
+	if a.statusCache != nil {
+		stats := a.statusCache.Stats()
+		resp.StatusCache = &stats
+	}
+	if a.deliveryPauser != nil {
+		resp.DeliveryPaused = a.deliveryPauser.PauseState().Paused
+	}
+	if a.workerScaler != nil {
+		workers := a.workerScaler.Workers()
+		resp.DeliveryWorkers = &workers
+	}
+	if a.raceStats != nil {
+		stats := a.raceStats()
+		resp.Racing = &stats
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// topFailureReasons returns the most frequent failure categories, ties
+// broken by name so the order is stable.
+func topFailureReasons(categories map[string]int64) []FailureReasonCount {
+	reasons := make([]FailureReasonCount, 0, len(categories))
+	for reason, count := range categories {
+		reasons = append(reasons, FailureReasonCount{Reason: reason, Count: count})
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if reasons[i].Count != reasons[j].Count {
+			return reasons[i].Count > reasons[j].Count
+		}
+		return reasons[i].Reason < reasons[j].Reason
+	})
+	if len(reasons) > maxFailureReasons {
+		reasons = reasons[:maxFailureReasons]
+	}
+	return reasons
+}
+
+// handleQuarantine lists quarantined emails (GET /admin/quarantine),
+// quarantines a queued email (POST /admin/quarantine/{id}) and releases or
+// rejects a quarantined one (POST /admin/quarantine/{id}/release and
+// /admin/quarantine/{id}/reject).
+func (a *API) handleQuarantine(w http.ResponseWriter, r *http.Request) {
+	qr, ok := a.queue.(queue.Quarantiner)
+	if !ok {
+		a.errorResponse(w, http.StatusNotImplemented, "queue does not support quarantine")
+		return
+	}
+	
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/quarantine"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		
+		emails, err := qr.Quarantined(r.Context())
+		if err != nil {
+			return
+		}
+		resp := make([]StatusResponse, 0, len(emails))
+		for _, e := range emails {
+			resp = append(resp, statusResponse(e))
+		}
+		
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	
+	if r.Method != http.MethodPost {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	
+	var req QuarantineRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		a.errorResponse(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	
+	id, op, _ := strings.Cut(path, "/")
+	var (
+		action, message string
+		status          email.Status
+		apply           func() error
+	)
+	switch op {
+	case "":
+		action, status, message = "quarantine", email.StatusQuarantined, "Email quarantined"
+		apply = func() error { return qr.Quarantine(r.Context(), id, req.Reason) }
+	case "release":
+		action, status, message = "quarantine.release", email.StatusQueued, "Email released for delivery"
+		apply = func() error { return qr.ReleaseQuarantined(r.Context(), id) }
+	case "reject":
+		action, status, message = "quarantine.reject", email.StatusRejected, "Email rejected"
+		apply = func() error { return qr.RejectQuarantined(r.Context(), id, req.Reason) }
+	default:
+		a.errorResponse(w, http.StatusNotFound, "not found")
+		return
+	}
+	
+	params := map[string]string{"id": id}
+	if req.Reason != "" {
+		params["reason"] = req.Reason
+	}
+	_, err := a.audited(r, action, params, func() (int, error) {
+		defer a.invalidate(id)
+		if err := apply(); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	})
+	switch {
+	case err == nil:
+	case r.Context().Err() != nil:
+		return
+	case errors.Is(err, queue.ErrEmailNotFound):
+		a.errorResponse(w, http.StatusNotFound, "email not found")
+		return
+	case errors.Is(err, email.ErrInvalidTransition):
+		a.errorResponse(w, http.StatusConflict, "email cannot be moved to "+string(status))
+		return
+	default:
+		a.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SendEmailResponse{
+		ID:      id,
+		Status:  string(status),
+		Message: message,
+	})
+}
+
+// handleHold holds a queued email back (POST /emails/{id}/hold) and
+// returns a held one to the queue (POST /emails/{id}/release).
+func (a *API) handleHold(w http.ResponseWriter, r *http.Request) {
+	id, op, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/emails/"), "/")
+	if id == "" || strings.Contains(op, "/") {
+		a.errorResponse(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	holder, ok := a.queue.(queue.EmailHolder)
+	if !ok {
+		a.errorResponse(w, http.StatusNotImplemented, "queue does not support holding emails")
+		return
+	}
+	if !a.owns(r, id) {
+		a.errorResponse(w, http.StatusNotFound, "email not found")
+		return
+	}
+	
+	var req HoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		a.errorResponse(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	
+	action, status, message := "email.hold", email.StatusHeld, "Email held"
+	apply := func() error { return holder.HoldEmail(r.Context(), id, req.Reason) }
+	if op == "release" {
+		action, status, message = "email.release", email.StatusQueued, "Email released for delivery"
+		apply = func() error { return holder.ReleaseEmail(r.Context(), id) }
+	}
+	
+	params := map[string]string{"id": id}
+	if req.Reason != "" {
+		params["reason"] = req.Reason
+	}
+	_, err := a.audited(r, action, params, func() (int, error) {
+		defer a.invalidate(id)
+		if err := apply(); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	})
+	switch {
+	case err == nil:
+	case r.Context().Err() != nil:
+		return
+	case errors.Is(err, queue.ErrEmailNotFound):
+		a.errorResponse(w, http.StatusNotFound, "email not found")
+		return
+	case errors.Is(err, email.ErrInvalidTransition):
+		a.errorResponse(w, http.StatusConflict, "email cannot be moved to "+string(status))
+		return
+	default:
+		a.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SendEmailResponse{
+		ID:      id,
+		Status:  string(status),
+		Message: message,
+	})
+}
+
+// actorKey carries the name of the authenticated key in the request
+// context. The main auth token is defaultActor.
+type actorKey struct{}
+
+const defaultActor = "default"
+
+func actor(r *http.Request) string {
+	if name, ok := r.Context().Value(actorKey{}).(string); ok {
+		return name
+	}
+	return ""
+}
+
+// audited applies an admin action and records it in the audit log. In
+// strict mode the action is refused up front if the log is unavailable,
+// and a failed write is reported to the caller.
+func (a *API) audited(r *http.Request, action string, params map[string]string, apply func() (int, error)) (int, error) {
+	if a.audit == nil {
+		return apply()
+	}
+	
+	if a.auditStrict {
+		if err := a.audit.Healthy(); err != nil {
+			return 0, err
+		}
+	}
+	
+	affected, err := apply()
+	if err != nil {
+		return affected, err
+	}
+	
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+	
+	_, err = a.audit.Record(audit.Entry{
+		RequestID: requestID,
+		Actor:     actor(r),
+		Action:    action,
+		Params:    params,
+		Affected:  affected,
+	})
+	if err != nil {
+		log.Printf("ERROR failed to record audit entry for %s: %v", action, err)
+		if a.auditStrict {
+			return affected, err
+		}
+	}
+	return affected, nil
+}
+
+// handleGetAudit lists audit entries, filtered by actor, action and a
+// since/until time range (RFC 3339), paginated with offset and limit.
+func (a *API) handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Limit:  100,
+	}
+	
+	var err error
+	if v := query.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			a.errorResponse(w, http.StatusBadRequest, "invalid since")
+			return
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			a.errorResponse(w, http.StatusBadRequest, "invalid until")
+			return
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			a.errorResponse(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 || filter.Limit > 1000 {
+			a.errorResponse(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+	}
+	
+	resp := AuditResponse{Entries: []audit.Entry{}, ChainValid: true}
+	if a.audit != nil {
+		resp.Entries, resp.Total = a.audit.Query(filter)
+		resp.ChainValid = a.audit.Verify() == nil
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleDrain puts the server into drain mode.
+func (a *API) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	
+	_, err := a.audited(r, "drain", nil, func() (int, error) {
+		if a.draining.Swap(true) {
+			return 0, nil
+		}
+		if a.drainHook != nil {
+			go a.drainHook()
+		}
+		return a.queue.Size(), nil
+	})
+	if err != nil {
+		a.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(HealthResponse{
+		Status:    "draining",
+		QueueSize: a.queue.Size(),
+	})
+}
+
 func (a *API) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -323,6 +2185,49 @@ func (a *API) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 		Uptime:    "0s", // TODO: Track actual uptime
 	}
 	
+	if a.monitor != nil {
+		status := a.monitor.Status()
+		resp.Resources = &status
+		if status.Degraded {
+			resp.Status = "degraded"
+			resp.Reasons = append(resp.Reasons, "resource pressure")
+		}
+	}
+	
+	if a.reputation != nil {
+		for _, b := range a.reputation().Active {
+			resp.Status = "degraded"
+			resp.Reasons = append(resp.Reasons, fmt.Sprintf("sending IP blocked by %s: %s refused mail with %q", b.Blocklist, b.Host, b.Reply))
+		}
+	}
+	
+	for _, problem := range a.config.Identity.Problems() {
+		resp.Status = "degraded"
+		resp.Reasons = append(resp.Reasons, "server.hostname: "+problem)
+	}
+	
+	if a.egress != nil {
+		if egress := a.egress(); egress.Blocked {
+			resp.Status = "degraded"
+			resp.Port25Blocked = true
+			resp.Reasons = append(resp.Reasons, "outbound port 25 blocked: "+egress.Error)
+		}
+	}
+	
+	if a.pauser != nil {
+		resp.PausedDomains = a.pauser.PausedDomains()
+	}
+	
+	if a.deliveryPauser != nil {
+		if pause := a.deliveryPauser.PauseState(); pause.Paused {
+			resp.DeliveryPaused = &pause
+		}
+	}
+	
+	if a.draining.Load() {
+		resp.Status = "draining"
+	}
+	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
@@ -335,7 +2240,25 @@ func (a *API) errorResponse(w http.ResponseWriter, code int, message string) {
 	})
 }
 
+// Start serves the API until Stop is called. It returns
+// lifecycle.ErrAlreadyRunning if the API is already running; a stopped API
+// cannot be restarted.
 func (a *API) Start() error {
+	srv := &http.Server{Addr: a.config.ListenAddress, Handler: a}
+	if err := a.lifecycle.Start(srv.Close); err != nil {
+		return err
+	}
+	defer a.lifecycle.Finished()
+	
 	log.Printf("Starting API server on %s", a.config.ListenAddress)
-	return http.ListenAndServe(a.config.ListenAddress, a)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Stop closes the listener and open connections and waits for Start to
+// return. It is safe to call more than once.
+func (a *API) Stop() error {
+	return a.lifecycle.Stop()
 }
\ No newline at end of file
@@ -2,37 +2,66 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 	
+	"github.com/tpdoyle87/simple-email-server/internal/attachment"
+	"github.com/tpdoyle87/simple-email-server/internal/audit"
 	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/internal/identity"
+	"github.com/tpdoyle87/simple-email-server/internal/lifecycle"
+	"github.com/tpdoyle87/simple-email-server/internal/policy"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/internal/resource"
 	"github.com/tpdoyle87/simple-email-server/pkg/email"
 )
 
 type mockQueue struct {
 	emails   []*email.Email
 	failNext bool
+	listener queue.Listener
 }
 
-func (m *mockQueue) Enqueue(e *email.Email) error {
+func (m *mockQueue) Enqueue(ctx context.Context, e *email.Email) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if m.failNext {
 		return ErrQueueFull
 	}
 	m.emails = append(m.emails, e)
+	if m.listener != nil {
+		m.listener.OnEnqueued(e)
+	}
 	return nil
 }
 
-func (m *mockQueue) Dequeue(count int) ([]*email.Email, error) {
+func (m *mockQueue) Dequeue(ctx context.Context, count int) ([]*email.Email, error) {
+	return nil, nil
+}
+
+func (m *mockQueue) DequeueLane(ctx context.Context, lane email.Lane, count int) ([]*email.Email, error) {
 	return nil, nil
 }
 
-func (m *mockQueue) MarkDelivered(id string) error {
+func (m *mockQueue) MarkDelivered(ctx context.Context, id string) error {
 	return nil
 }
 
-func (m *mockQueue) MarkFailed(id string, reason string, retry bool) error {
+func (m *mockQueue) MarkFailed(ctx context.Context, id string, reason string, retry bool) error {
 	return nil
 }
 
@@ -40,6 +69,10 @@ func (m *mockQueue) Size() int {
 	return len(m.emails)
 }
 
+func (m *mockQueue) Stats() queue.QueueStats {
+	return queue.QueueStats{Queued: len(m.emails)}
+}
+
 func TestAPI_SendEmail(t *testing.T) {
 	cfg := &config.APIConfig{
 		AuthToken: "test-token",
@@ -125,114 +158,2204 @@ func TestAPI_SendEmail(t *testing.T) {
 	}
 }
 
-func TestAPI_GetStatus(t *testing.T) {
+func TestAPI_Authenticate(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     map[string]string
+		query      string
+		allowQuery bool
+		wantStatus int
+		wantError  string
+	}{
+		{name: "bearer", header: map[string]string{"Authorization": "Bearer test-token"}, wantStatus: http.StatusOK},
+		{name: "lowercase scheme", header: map[string]string{"Authorization": "bearer test-token"}, wantStatus: http.StatusOK},
+		{name: "uppercase scheme", header: map[string]string{"Authorization": "BEARER test-token"}, wantStatus: http.StatusOK},
+		{name: "double space", header: map[string]string{"Authorization": "Bearer  test-token"}, wantStatus: http.StatusOK},
+		{name: "tab separator", header: map[string]string{"Authorization": "Bearer\ttest-token"}, wantStatus: http.StatusOK},
+		{name: "surrounding whitespace", header: map[string]string{"Authorization": "  Bearer test-token \t"}, wantStatus: http.StatusOK},
+		{name: "missing header", wantStatus: http.StatusUnauthorized, wantError: "missing authorization header"},
+		{name: "blank header", header: map[string]string{"Authorization": "   "}, wantStatus: http.StatusUnauthorized, wantError: "missing authorization header"},
+		{name: "scheme only", header: map[string]string{"Authorization": "Bearer"}, wantStatus: http.StatusUnauthorized, wantError: "missing bearer token"},
+		{name: "scheme and space", header: map[string]string{"Authorization": "Bearer   "}, wantStatus: http.StatusUnauthorized, wantError: "missing bearer token"},
+		{name: "basic scheme", header: map[string]string{"Authorization": "Basic dGVzdDp0ZXN0"}, wantStatus: http.StatusUnauthorized, wantError: "unsupported authorization scheme, expected Bearer"},
+		{name: "token without scheme", header: map[string]string{"Authorization": "test-token"}, wantStatus: http.StatusUnauthorized, wantError: "unsupported authorization scheme, expected Bearer"},
+		{name: "wrong token", header: map[string]string{"Authorization": "Bearer wrong-token"}, wantStatus: http.StatusUnauthorized, wantError: "invalid token"},
+		{name: "token prefix", header: map[string]string{"Authorization": "Bearer test"}, wantStatus: http.StatusUnauthorized, wantError: "invalid token"},
+		{name: "extra words", header: map[string]string{"Authorization": "Bearer test-token extra"}, wantStatus: http.StatusUnauthorized, wantError: "invalid token"},
+		{name: "api key header", header: map[string]string{"X-API-Key": "test-token"}, wantStatus: http.StatusOK},
+		{name: "wrong api key", header: map[string]string{"X-API-Key": "wrong-token"}, wantStatus: http.StatusUnauthorized, wantError: "invalid token"},
+		{name: "authorization wins over api key", header: map[string]string{"Authorization": "Bearer wrong-token", "X-API-Key": "test-token"}, wantStatus: http.StatusUnauthorized, wantError: "invalid token"},
+		{name: "query disabled", query: "?api_key=test-token", wantStatus: http.StatusUnauthorized, wantError: "missing authorization header"},
+		{name: "query enabled", query: "?api_key=test-token", allowQuery: true, wantStatus: http.StatusOK},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.APIConfig{
+				AuthToken:       "test-token",
+				AllowQueryToken: tt.allowQuery,
+			}
+			api := New(cfg, &mockQueue{}, 25*1024*1024)
+			
+			req := httptest.NewRequest("GET", "/stats"+tt.query, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, req)
+			
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tt.wantError != "" {
+				var resp map[string]string
+				json.NewDecoder(w.Body).Decode(&resp)
+				if resp["error"] != tt.wantError {
+					t.Errorf("Expected error %q, got %q", tt.wantError, resp["error"])
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_SendEmailDuplicate(t *testing.T) {
 	cfg := &config.APIConfig{
 		AuthToken: "test-token",
 	}
 	
-	queue := &mockQueue{}
-	api := New(cfg, queue, 25*1024*1024)
+	q := queue.NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:     10,
+		DedupWindow: time.Minute,
+	})
+	api := New(cfg, q, 25*1024*1024)
 	
-	// Add test email to tracking
-	testEmail := &email.Email{
-		ID:     "test-123",
-		Status: email.StatusDelivered,
+	send := func(payload SendEmailRequest) (*httptest.ResponseRecorder, SendEmailResponse) {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		
+		var resp SendEmailResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+	
+	payload := SendEmailRequest{
+		From:    "sender@example.com",
+		To:      []string{"recipient@example.com"},
+		Subject: "Test",
+		Body:    "Test body",
+	}
+	
+	w, first := send(payload)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+	
+	w, dup := send(payload)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+	if dup.ID != first.ID {
+		t.Errorf("Expected original ID %s, got %s", first.ID, dup.ID)
+	}
+	
+	payload.AllowDuplicate = true
+	w, _ = send(payload)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status %d with allow_duplicate, got %d", http.StatusAccepted, w.Code)
+	}
+}
+
+// TestAPI_StatusDuringDelivery reads status while workers move the same
+// emails through the queue; run with -race.
+func TestAPI_StatusDuringDelivery(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	q := queue.NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:    100,
+		RetryDelay: time.Nanosecond,
+	})
+	api := New(cfg, q, 25*1024*1024)
+	
+	var ids []string
+	for i := 0; i < 20; i++ {
+		body, _ := json.Marshal(SendEmailRequest{
+			From:           "sender@example.com",
+			To:             []string{"recipient@example.com"},
+			Subject:        "Test",
+			Body:           "Test body",
+			AllowDuplicate: true,
+		})
+		req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		
+		var resp SendEmailResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		ids = append(ids, resp.ID)
+	}
+	
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for q.Size() > 0 && ctx.Err() == nil {
+				emails, _ := q.Dequeue(ctx, 2)
+				for _, e := range emails {
+					e.LastError = "worker scratch"
+					if e.RetryCount < 2 {
+						q.MarkFailed(ctx, e.ID, "451 try again later", true)
+					} else {
+						q.MarkDelivered(ctx, e.ID)
+					}
+				}
+			}
+		}()
+	}
+	
+	for q.Size() > 0 && ctx.Err() == nil {
+		for _, id := range ids {
+			req := httptest.NewRequest("GET", "/status/"+id, nil)
+			req.Header.Set("Authorization", "Bearer test-token")
+			api.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		req := httptest.NewRequest("GET", "/emails", nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		api.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	wg.Wait()
+	
+	req := httptest.NewRequest("GET", "/status/"+ids[0], nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	var status StatusResponse
+	json.NewDecoder(w.Body).Decode(&status)
+	if status.Status != string(email.StatusDelivered) || status.RetryCount != 2 {
+		t.Errorf("Expected delivered after 2 retries, got %+v", status)
+	}
+}
+
+// TestAPI_ConcurrentDeliveryResults reports attempts for emails that have
+// left the queue out of order and all at once, with status reads going on;
+// run it with -race.
+func TestAPI_ConcurrentDeliveryResults(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(100)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	
+	var ids []string
+	for i := 0; i < 5; i++ {
+		body, _ := json.Marshal(SendEmailRequest{
+			From:           "sender@example.com",
+			To:             []string{"recipient@example.com"},
+			Subject:        "Test",
+			Body:           "Test body",
+			AllowDuplicate: true,
+		})
+		req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		
+		var resp SendEmailResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		ids = append(ids, resp.ID)
+	}
+	emails, _ := q.Dequeue(ctx, len(ids))
+	for _, e := range emails {
+		q.MarkFailed(ctx, e.ID, "550 no such user", false)
+	}
+	
+	const attempts = 6
+	var wg sync.WaitGroup
+	for attempt := 1; attempt <= attempts; attempt++ {
+		wg.Add(1)
+		go func(attempt int) {
+			defer wg.Done()
+			for _, id := range ids {
+				api.DeliveryResult(delivery.Result{
+					ID:      id,
+					Status:  email.StatusFailed,
+					Err:     errors.New("550 no such user"),
+					Attempt: attempt,
+					At:      time.Now(),
+					
+					EnvelopeFrom: "bounce@bounces.example.com",
+				})
+			}
+		}(attempt)
+	}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				for _, id := range ids {
+					req := httptest.NewRequest("GET", "/status/"+id, nil)
+					req.Header.Set("Authorization", "Bearer test-token")
+					api.ServeHTTP(httptest.NewRecorder(), req)
+				}
+				req := httptest.NewRequest("GET", "/emails", nil)
+				req.Header.Set("Authorization", "Bearer test-token")
+				api.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		}()
+	}
+	wg.Wait()
+	
+	for _, id := range ids {
+		req := httptest.NewRequest("GET", "/status/"+id, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		
+		var status StatusResponse
+		json.NewDecoder(w.Body).Decode(&status)
+		if status.Status != string(email.StatusFailed) || status.RetryCount != attempts {
+			t.Errorf("Expected every attempt counted, got %+v", status)
+		}
+		if status.EnvelopeFrom != "bounce@bounces.example.com" {
+			t.Errorf("Expected the envelope sender recorded, got %q", status.EnvelopeFrom)
+		}
+	}
+	
+	// A retry reported after the email failed does not undo it
+	api.DeliveryResult(delivery.Result{ID: ids[0], Status: email.StatusQueued, Err: errors.New("421 too busy"), Attempt: 1, At: time.Now()})
+	req := httptest.NewRequest("GET", "/status/"+ids[0], nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	var status StatusResponse
+	json.NewDecoder(w.Body).Decode(&status)
+	if status.Status != string(email.StatusFailed) || status.LastError != "550 no such user" {
+		t.Errorf("Expected failed after a late retry report, got %+v", status)
+	}
+}
+
+func TestAPI_LoadShedding(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
 	}
-	api.emailStatus.Store("test-123", testEmail)
 	
 	tests := []struct {
 		name       string
-		emailID    string
-		token      string
+		queued     int
+		lane       string
+		batch      bool
 		wantStatus int
 	}{
-		{
-			name:       "valid status request",
-			emailID:    "test-123",
-			token:      "test-token",
-			wantStatus: http.StatusOK,
-		},
-		{
-			name:       "missing auth",
-			emailID:    "test-123",
-			token:      "",
-			wantStatus: http.StatusUnauthorized,
-		},
-		{
-			name:       "email not found",
-			emailID:    "unknown-id",
-			token:      "test-token",
-			wantStatus: http.StatusNotFound,
-		},
+		{name: "bulk below soft", queued: 7, lane: "bulk", wantStatus: http.StatusAccepted},
+		{name: "batch below soft", queued: 7, batch: true, wantStatus: http.StatusAccepted},
+		{name: "transactional above soft", queued: 8, wantStatus: http.StatusAccepted},
+		{name: "bulk above soft", queued: 8, lane: "bulk", wantStatus: http.StatusTooManyRequests},
+		{name: "batch above soft", queued: 8, batch: true, wantStatus: http.StatusTooManyRequests},
+		{name: "transactional above hard", queued: 10, wantStatus: http.StatusServiceUnavailable},
+		{name: "batch above hard", queued: 10, batch: true, wantStatus: http.StatusServiceUnavailable},
 	}
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest("GET", "/status/"+tt.emailID, nil)
-			if tt.token != "" {
-				req.Header.Set("Authorization", "Bearer "+tt.token)
+			q := queue.NewMemoryQueue(20)
+			for i := 0; i < tt.queued; i++ {
+				q.Enqueue(context.Background(), &email.Email{ID: fmt.Sprintf("queued-%d", i), Status: email.StatusQueued})
 			}
+			api := New(cfg, q, 25*1024*1024)
+			api.SetLimits(&config.LimitsConfig{SoftWatermarkPercent: 80, HardWatermarkPercent: 100}, 10)
 			
+			payload := SendEmailRequest{
+				From:    "sender@example.com",
+				To:      []string{"recipient@example.com"},
+				Subject: "Test",
+				Body:    "Test body",
+				Lane:    tt.lane,
+			}
+			path := "/send"
+			body, _ := json.Marshal(payload)
+			if tt.batch {
+				path = "/send/batch"
+				body, _ = json.Marshal([]SendEmailRequest{payload})
+			}
+			
+			req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+			req.Header.Set("Authorization", "Bearer test-token")
 			w := httptest.NewRecorder()
 			api.ServeHTTP(w, req)
 			
 			if w.Code != tt.wantStatus {
-				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if w.Code != http.StatusAccepted && w.Header().Get("Retry-After") == "" {
+				t.Error("Expected Retry-After header when shedding")
 			}
 		})
 	}
 }
 
-func TestAPI_GetStats(t *testing.T) {
+func TestAPI_SendEmailWarnings(t *testing.T) {
 	cfg := &config.APIConfig{
 		AuthToken: "test-token",
 	}
 	
-	queue := &mockQueue{}
+	tests := []struct {
+		name      string
+		queued    int
+		body      string
+		wantWarns []string
+	}{
+		{name: "below thresholds", queued: 5, body: "Test body"},
+		{name: "queue nearly full", queued: 9, body: "Test body", wantWarns: []string{"queue utilization 100%"}},
+		{name: "large message", queued: 0, body: strings.Repeat("x", 950*1024), wantWarns: []string{"message size 0.9MB of 1MB limit"}},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueue{}
+			for i := 0; i < tt.queued; i++ {
+				q.emails = append(q.emails, &email.Email{})
+			}
+			api := New(cfg, q, 1024*1024)
+			api.SetLimits(&config.LimitsConfig{WarnQueuePercent: 95, WarnSizePercent: 90}, 10)
+			
+			body, _ := json.Marshal(SendEmailRequest{
+				From:    "sender@example.com",
+				To:      []string{"recipient@example.com"},
+				Subject: "Test",
+				Body:    tt.body,
+			})
+			req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, req)
+			
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("Warnings must not change the status, got %d", w.Code)
+			}
+			var resp SendEmailResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if strings.Join(resp.Warnings, "|") != strings.Join(tt.wantWarns, "|") {
+				t.Errorf("Expected warnings %v, got %v", tt.wantWarns, resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestAPI_QuotaWarning(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+		Keys: []config.APIKeyConfig{
+			{Name: "marketing", Token: "marketing-token", DailyQuota: 3500},
+		},
+	}
+	api := New(cfg, &mockQueue{}, 25*1024*1024)
+	api.SetLimits(&config.LimitsConfig{WarnQuotaPercent: 90}, 1000)
+	api.quota.Take("marketing", 3500, 3148)
+	
+	send := func(path string, body interface{}) []string {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer marketing-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		if path == "/send/batch" {
+			var responses []SendEmailResponse
+			json.NewDecoder(w.Body).Decode(&responses)
+			return responses[len(responses)-1].Warnings
+		}
+		var resp SendEmailResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Warnings
+	}
+	message := SendEmailRequest{
+		From:    "sender@example.com",
+		To:      []string{"recipient@example.com"},
+		Subject: "Test",
+		Body:    "Test body",
+	}
+	
+	if warnings := send("/send", message); len(warnings) != 0 {
+		t.Errorf("Expected no warning below 90%%, got %v", warnings)
+	}
+	want := "3,150 of 3,500 daily quota used"
+	if warnings := send("/send", message); len(warnings) != 1 || warnings[0] != want {
+		t.Errorf("Expected %q, got %v", want, warnings)
+	}
+	
+	// Each email in a batch reports the usage as of itself
+	message2 := message
+	message2.Subject = "Other"
+	want = "3,152 of 3,500 daily quota used"
+	if warnings := send("/send/batch", []SendEmailRequest{message2, message2}); len(warnings) != 1 || warnings[0] != want {
+		t.Errorf("Expected %q, got %v", want, warnings)
+	}
+}
+
+func TestAPI_SendEmailRejected(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	counters := NewCounters()
+	queue := &mockQueue{listener: counters}
 	api := New(cfg, queue, 25*1024*1024)
+	api.SetCounters(counters)
+	api.SetPolicies(policy.Func{
+		PolicyName: "blocked-sender",
+		CheckFunc: func(ctx context.Context, e *email.Email) error {
+			if e.From == "spammer@example.com" {
+				return errors.New("sender is blocked")
+			}
+			return nil
+		},
+	})
 	
-	req := httptest.NewRequest("GET", "/stats", nil)
-	req.Header.Set("Authorization", "Bearer test-token")
+	send := func(from string) (*httptest.ResponseRecorder, SendEmailResponse) {
+		body, _ := json.Marshal(SendEmailRequest{
+			From:    from,
+			To:      []string{"recipient@example.com"},
+			Subject: "Test",
+			Body:    "Test body",
+		})
+		req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		
+		var resp SendEmailResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
 	
-	w := httptest.NewRecorder()
+	w, rejected := send("spammer@example.com")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+	if rejected.Status != string(email.StatusRejected) || rejected.Message != "sender is blocked" {
+		t.Errorf("Unexpected response: %+v", rejected)
+	}
+	if len(queue.emails) != 0 {
+		t.Errorf("Rejected email should not be queued")
+	}
+	
+	if w, _ := send("sender@example.com"); w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+	
+	// Only the rejected email is listed under the rejected filter
+	req := httptest.NewRequest("GET", "/emails?status=rejected", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w = httptest.NewRecorder()
 	api.ServeHTTP(w, req)
 	
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+	var list []StatusResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode list response: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != rejected.ID {
+		t.Fatalf("Expected only rejected email, got %+v", list)
 	}
+	if list[0].RejectedBy != "blocked-sender" || list[0].RejectReason != "sender is blocked" {
+		t.Errorf("Unexpected rejection details: %+v", list[0])
+	}
+	
+	req = httptest.NewRequest("GET", "/stats", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
 	
 	var stats StatsResponse
-	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
-		t.Fatalf("Failed to decode stats response: %v", err)
+	json.NewDecoder(w.Body).Decode(&stats)
+	if stats.TotalRejected != 1 || stats.TotalSent != 1 {
+		t.Errorf("Expected 1 rejected and 1 sent, got %d and %d", stats.TotalRejected, stats.TotalSent)
+	}
+}
+
+func TestAPI_SendRaw(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
 	}
 	
-	if stats.QueueSize != 0 {
-		t.Errorf("Expected queue size 0, got %d", stats.QueueSize)
+	raw := "From: Sender <sender@example.com>\r\n" +
+		"To: header@example.com\r\n" +
+		"Subject: Signed upstream\r\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.com\r\n" +
+		"\r\n" +
+		"Body that must not change\r\n"
+	
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		wantStatus  int
+		wantFrom    string
+		wantTo      []string
+	}{
+		{
+			name:        "envelope from query",
+			target:      "/send/raw?from=bounce@example.com&to=a@example.com&to=b@example.com",
+			contentType: "message/rfc822",
+			body:        raw,
+			wantStatus:  http.StatusAccepted,
+			wantFrom:    "bounce@example.com",
+			wantTo:      []string{"a@example.com", "b@example.com"},
+		},
+		{
+			name:        "envelope from headers",
+			target:      "/send/raw",
+			contentType: "message/rfc822",
+			body:        raw,
+			wantStatus:  http.StatusAccepted,
+			wantFrom:    "sender@example.com",
+			wantTo:      []string{"header@example.com"},
+		},
+		{
+			name:        "wrong content type",
+			target:      "/send/raw",
+			contentType: "application/json",
+			body:        raw,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "invalid recipient",
+			target:      "/send/raw?to=not-an-address",
+			contentType: "message/rfc822",
+			body:        raw,
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := &mockQueue{}
+			api := New(cfg, queue, 25*1024*1024)
+			
+			req := httptest.NewRequest("POST", tt.target, bytes.NewReader([]byte(tt.body)))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Authorization", "Bearer test-token")
+			
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, req)
+			
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusAccepted {
+				return
+			}
+			
+			if len(queue.emails) != 1 {
+				t.Fatalf("Expected 1 queued email, got %d", len(queue.emails))
+			}
+			e := queue.emails[0]
+			if e.From != tt.wantFrom {
+				t.Errorf("Expected envelope from %s, got %s", tt.wantFrom, e.From)
+			}
+			if strings.Join(e.To, ",") != strings.Join(tt.wantTo, ",") {
+				t.Errorf("Expected envelope to %v, got %v", tt.wantTo, e.To)
+			}
+			if string(e.Raw) != raw {
+				t.Error("Raw message should be stored verbatim")
+			}
+			if e.Subject != "Signed upstream" {
+				t.Errorf("Expected parsed subject, got %q", e.Subject)
+			}
+		})
 	}
 }
 
-func TestAPI_HealthCheck(t *testing.T) {
+func TestAPI_SendRawDuplicate(t *testing.T) {
 	cfg := &config.APIConfig{
 		AuthToken: "test-token",
 	}
 	
-	queue := &mockQueue{}
-	api := New(cfg, queue, 25*1024*1024)
+	q := queue.NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:     10,
+		DedupWindow: time.Minute,
+	})
+	api := New(cfg, q, 25*1024*1024)
+	
+	send := func(raw string) (*httptest.ResponseRecorder, SendEmailResponse) {
+		req := httptest.NewRequest("POST", "/send/raw?from=a@example.com&to=b@example.com", strings.NewReader(raw))
+		req.Header.Set("Content-Type", "message/rfc822")
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		
+		var resp SendEmailResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+	
+	raw := "Subject: Test\r\n\r\nBody\r\n"
+	w, first := send(raw)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+	w, dup := send(raw)
+	if w.Code != http.StatusConflict || dup.ID != first.ID {
+		t.Errorf("Expected status %d naming %s, got %d naming %s", http.StatusConflict, first.ID, w.Code, dup.ID)
+	}
+}
+
+func TestAPI_SendRawTooLarge(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	api := New(cfg, &mockQueue{}, 64)
+	
+	body := "Subject: Big\r\n\r\n" + strings.Repeat("x", 128)
+	req := httptest.NewRequest("POST", "/send/raw?from=a@example.com&to=b@example.com", strings.NewReader(body))
+	req.Header.Set("Content-Type", "message/rfc822")
+	req.Header.Set("Authorization", "Bearer test-token")
 	
-	req := httptest.NewRequest("GET", "/health", nil)
 	w := httptest.NewRecorder()
 	api.ServeHTTP(w, req)
 	
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
+
+func TestAPI_SendEmailClientDisconnected(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
 	}
 	
-	var health HealthResponse
-	if err := json.NewDecoder(w.Body).Decode(&health); err != nil {
-		t.Fatalf("Failed to decode health response: %v", err)
+	queue := &mockQueue{}
+	api := New(cfg, queue, 25*1024*1024)
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	
+	for _, path := range []string{"/send", "/send/batch"} {
+		var payload interface{} = SendEmailRequest{
+			From:    "sender@example.com",
+			To:      []string{"recipient@example.com"},
+			Subject: "Test",
+			Body:    "Test body",
+		}
+		if path == "/send/batch" {
+			payload = []interface{}{payload}
+		}
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body)).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer test-token")
+		
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
 	}
 	
-	if health.Status != "healthy" {
-		t.Errorf("Expected status 'healthy', got '%s'", health.Status)
+	if len(queue.emails) != 0 {
+		t.Errorf("Expected no emails queued for a disconnected client, got %d", len(queue.emails))
 	}
-}
\ No newline at end of file
+}
+
+func TestAPI_GetStatus(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	queue := &mockQueue{}
+	api := New(cfg, queue, 25*1024*1024)
+	
+	// Add test email to tracking
+	testEmail := &email.Email{
+		ID:     "test-123",
+		Status: email.StatusDelivered,
+	}
+	api.emailStatus.Store("test-123", testEmail)
+	
+	tests := []struct {
+		name       string
+		emailID    string
+		token      string
+		wantStatus int
+	}{
+		{
+			name:       "valid status request",
+			emailID:    "test-123",
+			token:      "test-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing auth",
+			emailID:    "test-123",
+			token:      "",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "email not found",
+			emailID:    "unknown-id",
+			token:      "test-token",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/status/"+tt.emailID, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, req)
+			
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestAPI_GetStats(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	queue := &mockQueue{}
+	api := New(cfg, queue, 25*1024*1024)
+	api.SetEarlyTalkers(func() int64 { return 3 })
+	
+	req := httptest.NewRequest("GET", "/stats", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+	
+	var stats StatsResponse
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats response: %v", err)
+	}
+	
+	if stats.QueueSize != 0 {
+		t.Errorf("Expected queue size 0, got %d", stats.QueueSize)
+	}
+	
+	if stats.EarlyTalkers != 3 {
+		t.Errorf("Expected 3 early talkers, got %d", stats.EarlyTalkers)
+	}
+}
+
+func TestAPI_RaceStats(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	api := New(cfg, &mockQueue{}, 25*1024*1024)
+	api.SetRaceStats(func() delivery.RaceStats {
+		return delivery.RaceStats{Races: 5, PrimaryWon: 2, RacerWon: 3}
+	})
+	
+	req := httptest.NewRequest("GET", "/stats", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	var stats StatsResponse
+	json.NewDecoder(w.Body).Decode(&stats)
+	if stats.Racing == nil || stats.Racing.Races != 5 || stats.Racing.RacerWon != 3 {
+		t.Errorf("Expected 5 races with 3 won by the racer, got %+v", stats.Racing)
+	}
+}
+
+func TestAPI_HealthCheck(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	queue := &mockQueue{}
+	api := New(cfg, queue, 25*1024*1024)
+	
+	req := httptest.NewRequest("GET", "/health", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+	
+	var health HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&health); err != nil {
+		t.Fatalf("Failed to decode health response: %v", err)
+	}
+	
+	if health.Status != "healthy" {
+		t.Errorf("Expected status 'healthy', got '%s'", health.Status)
+	}
+}
+
+func TestAPI_ReputationBlock(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	api := New(cfg, &mockQueue{}, 25*1024*1024)
+	api.SetReputationStats(func() delivery.ReputationStats {
+		return delivery.ReputationStats{
+			Blocked: 4,
+			Active: []delivery.ReputationBlock{{
+				Blocklist: "Spamhaus",
+				Domain:    "example.com",
+				Host:      "mx1.example.com",
+				Reply:     "550 5.7.1 blocked using zen.spamhaus.org",
+				Count:     4,
+			}},
+		}
+	})
+	
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	var health HealthResponse
+	json.NewDecoder(w.Body).Decode(&health)
+	if health.Status != "degraded" || len(health.Reasons) != 1 || !strings.Contains(health.Reasons[0], "Spamhaus") {
+		t.Errorf("Expected degraded naming Spamhaus, got %+v", health)
+	}
+	
+	req := httptest.NewRequest("GET", "/stats", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	var stats StatsResponse
+	json.NewDecoder(w.Body).Decode(&stats)
+	if stats.ReputationBlock != 4 || len(stats.Blocklists) != 1 {
+		t.Errorf("Expected 4 reputation blocks under one blocklist, got %d %+v", stats.ReputationBlock, stats.Blocklists)
+	}
+}
+
+func TestAPI_HostnameProblems(t *testing.T) {
+	id := identity.New("localhost")
+	id.Warn(context.Background(), nil)
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+		Identity:  id,
+	}
+	
+	api := New(cfg, &mockQueue{}, 25*1024*1024)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	var health HealthResponse
+	json.NewDecoder(w.Body).Decode(&health)
+	if health.Status != "degraded" || len(health.Reasons) != 1 || !strings.Contains(health.Reasons[0], "not fully qualified") {
+		t.Errorf("Expected degraded naming the hostname problem, got %+v", health)
+	}
+	if e := api.testEmail("rcpt@example.com"); !strings.Contains(e.Body, "Hostname problem: it is not fully qualified") || e.From != "postmaster@localhost" {
+		t.Errorf("Expected the test email from localhost to report the problem, got %s:\n%s", e.From, e.Body)
+	}
+}
+
+func TestAPI_Port25Blocked(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	api := New(cfg, &mockQueue{}, 25*1024*1024)
+	egress := delivery.EgressStatus{Checked: true}
+	api.SetEgressStatus(func() delivery.EgressStatus { return egress })
+	
+	health := func() HealthResponse {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		var health HealthResponse
+		json.NewDecoder(w.Body).Decode(&health)
+		return health
+	}
+	if h := health(); h.Status != "healthy" || h.Port25Blocked {
+		t.Errorf("Expected healthy with port 25 open, got %+v", h)
+	}
+	
+	egress.Blocked = true
+	egress.Error = "dial tcp: i/o timeout"
+	h := health()
+	if h.Status != "degraded" || !h.Port25Blocked || len(h.Reasons) != 1 || !strings.Contains(h.Reasons[0], "i/o timeout") {
+		t.Errorf("Expected degraded with port 25 blocked, got %+v", h)
+	}
+}
+func TestAPI_Drain(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	api := New(cfg, &mockQueue{}, 25*1024*1024)
+	hooked := make(chan struct{})
+	api.SetDrainHook(func() { close(hooked) })
+	
+	req := httptest.NewRequest("POST", "/admin/drain", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", w.Code)
+	}
+	select {
+	case <-hooked:
+	case <-time.After(time.Second):
+		t.Fatal("Expected drain hook to run")
+	}
+	
+	req = httptest.NewRequest("GET", "/health", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	var health HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&health); err != nil {
+		t.Fatalf("Failed to decode health response: %v", err)
+	}
+	if health.Status != "draining" {
+		t.Errorf("Expected status 'draining', got '%s'", health.Status)
+	}
+	
+	body, _ := json.Marshal(SendEmailRequest{
+		From:    "sender@example.com",
+		To:      []string{"recipient@example.com"},
+		Subject: "Test",
+		Body:    "Test body",
+	})
+	req = httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 while draining, got %d", w.Code)
+	}
+}
+
+func TestAPI_Quarantine(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	q := queue.NewMemoryQueue(10)
+	api := New(cfg, q, 25*1024*1024)
+	auditLog, _ := audit.NewLog(nil, nil)
+	api.SetAuditLog(auditLog, false)
+	
+	q.Enqueue(context.Background(), &email.Email{ID: "test-1", Status: email.StatusQueued})
+	q.Enqueue(context.Background(), &email.Email{ID: "test-2", Status: email.StatusQueued})
+	
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	
+	for _, id := range []string{"test-1", "test-2"} {
+		if w := do("POST", "/admin/quarantine/"+id, `{"reason":"suspicious link"}`); w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 quarantining %s, got %d", id, w.Code)
+		}
+	}
+	if w := do("POST", "/admin/quarantine/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	
+	w := do("GET", "/admin/quarantine", "")
+	var list []StatusResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode list: %v", err)
+	}
+	if len(list) != 2 || list[0].Status != string(email.StatusQuarantined) || list[0].QuarantineReason != "suspicious link" {
+		t.Fatalf("Unexpected quarantine list: %+v", list)
+	}
+	
+	if w := do("POST", "/admin/quarantine/test-1/release", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 releasing, got %d", w.Code)
+	}
+	if w := do("POST", "/admin/quarantine/test-1/release", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 releasing twice, got %d", w.Code)
+	}
+	if w := do("POST", "/admin/quarantine/test-2/reject", `{"reason":"phishing"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 rejecting, got %d", w.Code)
+	}
+	
+	w = do("GET", "/stats", "")
+	var stats StatsResponse
+	json.NewDecoder(w.Body).Decode(&stats)
+	if stats.Quarantined != 0 || stats.Queued != 1 || stats.TotalRejected != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	
+	if entries, total := auditLog.Query(audit.Filter{}); total != 4 || entries[3].Action != "quarantine.reject" {
+		t.Errorf("Expected 4 audited actions, got %d", total)
+	}
+}
+
+func TestAPI_HoldEmail(t *testing.T) {
+	q := queue.NewMemoryQueue(10)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	auditLog, _ := audit.NewLog(nil, nil)
+	api.SetAuditLog(auditLog, false)
+	
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	
+	var sent SendEmailResponse
+	json.NewDecoder(do("POST", "/send", `{"from":"sender@example.com","to":["a@example.com"],"subject":"Hi","body":"Body"}`).Body).Decode(&sent)
+	id := sent.ID
+	
+	if w := do("POST", "/emails/"+id+"/hold", `{"reason":"customer dispute"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 holding, got %d: %s", w.Code, w.Body)
+	}
+	if w := do("POST", "/emails/"+id+"/hold", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 holding twice, got %d", w.Code)
+	}
+	if w := do("POST", "/emails/missing/hold", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if w := do("GET", "/emails/"+id+"/hold", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+	
+	var status StatusResponse
+	json.NewDecoder(do("GET", "/status/"+id, "").Body).Decode(&status)
+	if status.Status != string(email.StatusHeld) || status.HoldReason != "customer dispute" || status.HeldAt == nil {
+		t.Errorf("Expected the email held, got %+v", status)
+	}
+	var stats StatsResponse
+	json.NewDecoder(do("GET", "/stats", "").Body).Decode(&stats)
+	if stats.Held != 1 || stats.Queued != 0 {
+		t.Errorf("Unexpected stats while held: %+v", stats)
+	}
+	
+	if w := do("POST", "/emails/"+id+"/release", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 releasing, got %d", w.Code)
+	}
+	json.NewDecoder(do("GET", "/status/"+id, "").Body).Decode(&status)
+	if status.Status != string(email.StatusQueued) {
+		t.Errorf("Expected the email queued again, got %s", status.Status)
+	}
+	if w := do("GET", "/emails/"+id+"/raw", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the raw message still served, got %d", w.Code)
+	}
+	
+	if entries, total := auditLog.Query(audit.Filter{}); total != 2 || entries[1].Action != "email.release" {
+		t.Errorf("Expected the hold and release audited, got %d", total)
+	}
+}
+
+func TestAPI_ResourcePressure(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	queue := &mockQueue{}
+	api := New(cfg, queue, 25*1024*1024)
+	
+	monitor := resource.NewMonitor(&config.ResourceConfig{MaxGoroutines: 1})
+	monitor.Check()
+	api.SetMonitor(monitor)
+	
+	body, _ := json.Marshal(SendEmailRequest{
+		From:    "sender@example.com",
+		To:      []string{"recipient@example.com"},
+		Subject: "Test",
+		Body:    "Test body",
+	})
+	req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header")
+	}
+	if len(queue.emails) != 0 {
+		t.Error("Email should not be queued under resource pressure")
+	}
+	
+	req = httptest.NewRequest("GET", "/health", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	var health HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&health); err != nil {
+		t.Fatalf("Failed to decode health response: %v", err)
+	}
+	if health.Status != "degraded" {
+		t.Errorf("Expected status 'degraded', got '%s'", health.Status)
+	}
+	if health.Resources == nil || health.Resources.Thresholds.MaxGoroutines != 1 {
+		t.Errorf("Expected resource detail in health response, got %+v", health.Resources)
+	}
+}
+
+func TestAPI_SendEmailAttachments(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "report.csv"), []byte("a,b,c"), 0o644)
+	
+	tests := []struct {
+		name        string
+		fetcher     bool
+		attachments []AttachmentRequest
+		wantStatus  int
+		wantError   string
+	}{
+		{
+			name:        "inline data",
+			attachments: []AttachmentRequest{{Filename: "note.txt", Data: []byte("hello")}},
+			wantStatus:  http.StatusAccepted,
+		},
+		{
+			name:        "by path",
+			fetcher:     true,
+			attachments: []AttachmentRequest{{Filename: "report.csv", Path: filepath.Join(dir, "report.csv")}},
+			wantStatus:  http.StatusAccepted,
+		},
+		{
+			name:        "fetching disabled",
+			attachments: []AttachmentRequest{{Filename: "report.csv", Path: filepath.Join(dir, "report.csv")}},
+			wantStatus:  http.StatusBadRequest,
+			wantError:   `attachment "report.csv"`,
+		},
+		{
+			name:        "path outside allowlist",
+			fetcher:     true,
+			attachments: []AttachmentRequest{{Filename: "passwd", Path: "/etc/passwd"}},
+			wantStatus:  http.StatusBadRequest,
+			wantError:   `attachment "passwd"`,
+		},
+		{
+			name:        "no content",
+			attachments: []AttachmentRequest{{Filename: "empty.txt"}},
+			wantStatus:  http.StatusBadRequest,
+			wantError:   `attachment "empty.txt"`,
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := &mockQueue{}
+			api := New(cfg, queue, 25*1024*1024)
+			if tt.fetcher {
+				api.SetFetcher(attachment.NewFetcher(&config.AttachmentConfig{
+					MaxSize:      1024,
+					AllowedPaths: []string{dir},
+				}))
+			}
+			
+			body, _ := json.Marshal(SendEmailRequest{
+				From:        "sender@example.com",
+				To:          []string{"recipient@example.com"},
+				Subject:     "Test",
+				Body:        "Test body",
+				Attachments: tt.attachments,
+			})
+			req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, req)
+			
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantError != "" {
+				var resp map[string]string
+				json.NewDecoder(w.Body).Decode(&resp)
+				if !strings.Contains(resp["error"], tt.wantError) {
+					t.Errorf("Expected error naming the attachment, got %q", resp["error"])
+				}
+			}
+			if tt.wantStatus == http.StatusAccepted {
+				if len(queue.emails) != 1 || len(queue.emails[0].Attachments) != 1 {
+					t.Fatalf("Expected queued email with one attachment")
+				}
+				if att := queue.emails[0].Attachments[0]; len(att.Data) == 0 || att.ContentType == "" {
+					t.Errorf("Unexpected stored attachment: %+v", att)
+				}
+			}
+		})
+	}
+}
+
+type failingAuditSink struct{}
+
+func (failingAuditSink) Append(e audit.Entry) error { return errors.New("disk full") }
+func (failingAuditSink) Healthy() error             { return errors.New("disk full") }
+
+func TestAPI_AuditedAction(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+	}
+	
+	tests := []struct {
+		name        string
+		sink        audit.Sink
+		strict      bool
+		wantStatus  int
+		wantApplied bool
+		wantEntries int
+	}{
+		{name: "recorded", wantStatus: http.StatusOK, wantApplied: true, wantEntries: 1},
+		{name: "sink down, lenient", sink: failingAuditSink{}, wantStatus: http.StatusOK, wantApplied: true},
+		{name: "sink down, strict", sink: failingAuditSink{}, strict: true, wantStatus: http.StatusServiceUnavailable},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := New(cfg, &mockQueue{}, 25*1024*1024)
+			auditLog, _ := audit.NewLog(tt.sink, nil)
+			api.SetAuditLog(auditLog, tt.strict)
+			
+			applied := false
+			api.mux.HandleFunc("/admin/audited-action", api.authenticate(func(w http.ResponseWriter, r *http.Request) {
+				_, err := api.audited(r, "purge", map[string]string{"status": "failed"}, func() (int, error) {
+					applied = true
+					return 7, nil
+				})
+				if errors.Is(err, audit.ErrUnavailable) {
+					api.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			
+			req := httptest.NewRequest("POST", "/admin/audited-action", nil)
+			req.Header.Set("Authorization", "Bearer test-token")
+			req.Header.Set("X-Request-ID", "req-1")
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, req)
+			
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if applied != tt.wantApplied {
+				t.Errorf("Expected applied=%v", tt.wantApplied)
+			}
+			
+			req = httptest.NewRequest("GET", "/admin/audit?action=purge", nil)
+			req.Header.Set("Authorization", "Bearer test-token")
+			w = httptest.NewRecorder()
+			api.ServeHTTP(w, req)
+			
+			var resp AuditResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode audit response: %v", err)
+			}
+			if resp.Total != tt.wantEntries || !resp.ChainValid {
+				t.Fatalf("Expected %d valid entries, got %+v", tt.wantEntries, resp)
+			}
+			if tt.wantEntries > 0 {
+				e := resp.Entries[0]
+				if e.Actor != defaultActor || e.RequestID != "req-1" || e.Affected != 7 || e.Params["status"] != "failed" {
+					t.Errorf("Unexpected audit entry: %+v", e)
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_StartStopConcurrent(t *testing.T) {
+	cfg := &config.APIConfig{
+		ListenAddress: "127.0.0.1:0",
+		AuthToken:     "test-token",
+	}
+	api := New(cfg, &mockQueue{}, 25*1024*1024)
+	
+	results := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() { results <- api.Start() }()
+	}
+	
+	// All but one Start fail straight away
+	for i := 0; i < 4; i++ {
+		if err := <-results; err != lifecycle.ErrAlreadyRunning {
+			t.Fatalf("Expected ErrAlreadyRunning, got %v", err)
+		}
+	}
+	
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			api.Stop()
+		}()
+	}
+	wg.Wait()
+	
+	if err := <-results; err != nil {
+		t.Errorf("Expected the running Start to return cleanly, got %v", err)
+	}
+	if err := api.Start(); err != lifecycle.ErrStopped {
+		t.Errorf("Expected ErrStopped on restart, got %v", err)
+	}
+}
+
+func TestAPI_CountersFromQueue(t *testing.T) {
+	ctx := context.Background()
+	counters := NewCounters()
+	q := queue.NewMemoryQueue(10, counters)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	api.SetCounters(counters)
+	
+	for _, id := range []string{"test-1", "test-2", "test-3"} {
+		q.Enqueue(ctx, &email.Email{ID: id, Status: email.StatusQueued})
+	}
+	q.Dequeue(ctx, 3)
+	q.MarkDelivered(ctx, "test-1")
+	q.MarkFailed(ctx, "test-2", "mailbox full", true)
+	q.MarkFailed(ctx, "test-3", "no such user", false)
+	
+	req := httptest.NewRequest("GET", "/stats", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	var stats StatsResponse
+	json.NewDecoder(w.Body).Decode(&stats)
+	if stats.TotalSent != 3 || stats.TotalDelivered != 1 || stats.TotalFailed != 1 {
+		t.Errorf("Expected 3 sent, 1 delivered and 1 failed, got %+v", stats)
+	}
+}
+
+func TestAPI_DailyQuota(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+		Keys: []config.APIKeyConfig{
+			{Name: "marketing", Token: "marketing-token", DailyQuota: 3},
+		},
+	}
+	api := New(cfg, &mockQueue{}, 25*1024*1024)
+	
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer marketing-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	message := SendEmailRequest{
+		From:    "sender@example.com",
+		To:      []string{"recipient@example.com"},
+		Subject: "Test",
+		Body:    "Test body",
+	}
+	
+	w := do("POST", "/send", message)
+	if w.Code != http.StatusAccepted || w.Header().Get("X-Quota-Remaining") != "2" {
+		t.Fatalf("Expected send with 2 remaining, got %d and %q", w.Code, w.Header().Get("X-Quota-Remaining"))
+	}
+	
+	// The batch is accepted up to the remaining quota
+	w = do("POST", "/send/batch", []SendEmailRequest{message, message, message})
+	var responses []SendEmailResponse
+	json.NewDecoder(w.Body).Decode(&responses)
+	if w.Code != http.StatusAccepted || len(responses) != 3 {
+		t.Fatalf("Expected 3 batch responses, got %d: %+v", w.Code, responses)
+	}
+	if responses[0].Status != "queued" || responses[1].Status != "queued" {
+		t.Errorf("Expected first two emails queued, got %+v", responses)
+	}
+	if responses[2].Status != "error" || responses[2].Message != "daily quota exceeded" {
+		t.Errorf("Expected third email over quota, got %+v", responses[2])
+	}
+	
+	for _, path := range []string{"/send", "/send/batch"} {
+		body := interface{}(message)
+		if path == "/send/batch" {
+			body = []SendEmailRequest{message}
+		}
+		w = do("POST", path, body)
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusTooManyRequests, w.Code)
+		}
+		if w.Header().Get("X-Quota-Remaining") != "0" || w.Header().Get("X-Quota-Reset") == "" || w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected quota headers, got %v", path, w.Header())
+		}
+	}
+	
+	w = do("GET", "/quota", nil)
+	var quota QuotaResponse
+	json.NewDecoder(w.Body).Decode(&quota)
+	if quota.Key != "marketing" || quota.Limit != 3 || quota.Used != 3 || quota.Remaining != 0 {
+		t.Errorf("Unexpected quota: %+v", quota)
+	}
+	
+	// The main token is unlimited
+	req := httptest.NewRequest("GET", "/quota", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	json.NewDecoder(w.Body).Decode(&quota)
+	if quota.Key != "default" || quota.Limit != 0 {
+		t.Errorf("Expected unlimited default key, got %+v", quota)
+	}
+}
+
+func TestAPI_KeyScopes(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+		Keys: []config.APIKeyConfig{
+			{Name: "team", Token: "team-token"},
+			{Name: "ops", Token: "ops-token", Admin: true},
+		},
+	}
+	api := New(cfg, queue.NewMemoryQueue(10), 25*1024*1024)
+	
+	do := func(token, method, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	
+	send := func(token string) string {
+		w := do(token, "POST", "/send", SendEmailRequest{
+			From:    "sender@example.com",
+			To:      []string{"recipient@example.com"},
+			Subject: "Test " + token,
+			Body:    "Test body",
+		})
+		var resp SendEmailResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.ID
+	}
+	own, other := send("team-token"), send("test-token")
+	
+	// A team key reaches only the emails it submitted
+	if w := do("team-token", "GET", "/status/"+own, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected a key to see its own email, got %d", w.Code)
+	}
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/status/" + other},
+		{"GET", "/emails/" + other + "/raw"},
+		{"DELETE", "/status/" + other},
+	} {
+		if w := do("team-token", req.method, req.path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404 for another key's email, got %d", req.method, req.path, w.Code)
+		}
+	}
+	
+	var list []StatusResponse
+	json.NewDecoder(do("team-token", "GET", "/emails", nil).Body).Decode(&list)
+	if len(list) != 1 || list[0].ID != own {
+		t.Errorf("Expected a team key to list only its own email, got %+v", list)
+	}
+	json.NewDecoder(do("ops-token", "GET", "/emails", nil).Body).Decode(&list)
+	if len(list) != 2 {
+		t.Errorf("Expected an admin key to list every email, got %d", len(list))
+	}
+	
+	// Team keys are kept out of the admin endpoints
+	for _, path := range []string{"/admin/audit", "/admin/drain", "/admin/quarantine", "/admin/domains", "/admin/delivery", "/admin/purge", "/admin/bounces", "/admin/test-email"} {
+		method := "GET"
+		if path == "/admin/test-email" || path == "/admin/drain" || path == "/admin/purge" {
+			method = "POST"
+		}
+		if w := do("team-token", method, path, nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected 403 for a team key, got %d", method, path, w.Code)
+		}
+		if w := do("ops-token", method, path, nil); w.Code == http.StatusForbidden || w.Code == http.StatusUnauthorized {
+			t.Errorf("%s %s: expected an admin key to be let in, got %d", method, path, w.Code)
+		}
+	}
+}
+
+func TestTopFailureReasons(t *testing.T) {
+	categories := map[string]int64{"other": 2, "dns lookup failed": 5, "smtp 55x": 9, "smtp 45x": 2}
+	for i := 0; i < 20; i++ {
+		categories[fmt.Sprintf("rare %02d", i)] = 1
+	}
+	
+	reasons := topFailureReasons(categories)
+	if len(reasons) != maxFailureReasons {
+		t.Fatalf("Expected %d reasons, got %d", maxFailureReasons, len(reasons))
+	}
+	want := []FailureReasonCount{
+		{"smtp 55x", 9},
+		{"dns lookup failed", 5},
+		{"other", 2},
+		{"smtp 45x", 2},
+		{"rare 00", 1},
+	}
+	for i, w := range want {
+		if reasons[i] != w {
+			t.Errorf("Reason %d: expected %+v, got %+v", i, w, reasons[i])
+		}
+	}
+}
+
+func TestAPI_PerKeyLimits(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+		Keys: []config.APIKeyConfig{
+			{Name: "bulk", Token: "bulk-token", MaxBatchSize: 150, MaxRecipients: 3},
+		},
+	}
+	api := New(cfg, &mockQueue{}, 25*1024*1024)
+	api.SetLimits(&config.LimitsConfig{MaxBatchSize: 100, MaxRecipients: 2}, 1000)
+	
+	do := func(token, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	message := SendEmailRequest{
+		From:    "sender@example.com",
+		To:      []string{"a@example.com", "b@example.com", "c@example.com"},
+		Subject: "Test",
+		Body:    "Test body",
+	}
+	batch := make([]SendEmailRequest, 120)
+	for i := range batch {
+		batch[i] = SendEmailRequest{From: "sender@example.com", To: []string{"a@example.com"}, Subject: "Test", Body: "Test body"}
+	}
+	
+	w := do("test-token", "/send/batch", batch)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "batch size exceeds limit (100)") {
+		t.Errorf("Expected the global batch limit, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("bulk-token", "/send/batch", batch); w.Code != http.StatusAccepted {
+		t.Errorf("Expected the key's larger batch limit to apply, got %d: %s", w.Code, w.Body.String())
+	}
+	
+	w = do("test-token", "/send", message)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "too many recipients (limit 2)") {
+		t.Errorf("Expected the global recipient limit, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("bulk-token", "/send", message); w.Code != http.StatusAccepted {
+		t.Errorf("Expected the key's recipient limit to apply, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAPI_GetRaw(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	
+	get := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/emails/"+id+"/raw", nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	
+	// Mail received over SMTP is returned exactly as stored
+	raw := "Received: from client (192.0.2.1:40000) by mx.test with ESMTP id relayed-1; Fri, 16 Oct 2026 08:00:00 +0000\r\n" +
+		"From:  Sender <sender@example.com>\r\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.com\r\n" +
+		"\r\n" +
+		"Body that must not change\r\n"
+	q.Enqueue(ctx, &email.Email{
+		ID:      "relayed-1",
+		From:    "sender@example.com",
+		To:      []string{"rcpt@example.com"},
+		Subject: "parsed",
+		Body:    "parsed",
+		Raw:     []byte(raw),
+		Relayed: true,
+		Status:  email.StatusQueued,
+	})
+	
+	w := get("relayed-1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "message/rfc822" {
+		t.Errorf("Expected message/rfc822, got %q", ct)
+	}
+	if w.Body.String() != raw {
+		t.Errorf("Expected the relayed message unmodified, got %q", w.Body)
+	}
+	
+	// API mail is built from its fields
+	body, _ := json.Marshal(SendEmailRequest{
+		From:    "sender@example.com",
+		To:      []string{"recipient@example.com"},
+		Subject: "Built",
+		Body:    "Built body",
+	})
+	req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	var sent SendEmailResponse
+	json.NewDecoder(w.Body).Decode(&sent)
+	
+	w = get(sent.ID)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Subject: Built\r\n") {
+		t.Errorf("Expected the built message, got %d: %q", w.Code, w.Body)
+	}
+	
+	if w := get("missing"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown email, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestAPI_GetStatusRecipients(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	
+	body, _ := json.Marshal(SendEmailRequest{
+		From:    "sender@example.com",
+		To:      []string{"a@example.com", "b@example.com"},
+		Subject: "Test",
+		Body:    "Test body",
+	})
+	req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	var sent SendEmailResponse
+	json.NewDecoder(w.Body).Decode(&sent)
+	
+	q.Dequeue(ctx, 1)
+	q.UpdateRecipients(ctx, sent.ID, map[string]email.RecipientStatus{
+		"a@example.com": {Status: email.StatusDelivered},
+		"b@example.com": {Status: email.StatusQueued, LastError: "450 mailbox busy"},
+	})
+	
+	req = httptest.NewRequest("GET", "/status/"+sent.ID, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	var status StatusResponse
+	json.NewDecoder(w.Body).Decode(&status)
+	if status.Recipients["a@example.com"].Status != email.StatusDelivered {
+		t.Errorf("Expected a@example.com delivered, got %+v", status.Recipients)
+	}
+	if b := status.Recipients["b@example.com"]; b.Status != email.StatusQueued || b.LastError != "450 mailbox busy" {
+		t.Errorf("Expected b@example.com waiting with its error, got %+v", b)
+	}
+}
+
+func TestAPI_GetStatusAttempts(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	
+	body, _ := json.Marshal(SendEmailRequest{
+		From:    "sender@example.com",
+		To:      []string{"a@example.com"},
+		Subject: "Test",
+		Body:    "Test body",
+	})
+	req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	var sent SendEmailResponse
+	json.NewDecoder(w.Body).Decode(&sent)
+	
+	at := time.Now().Add(-time.Minute).UTC().Truncate(time.Millisecond)
+	failed := email.Attempt{At: at, Number: 1, Host: "mx.example.com", IP: "192.0.2.25", Result: email.AttemptSMTPError, Code: 451, Text: "4.7.1 greylisted", DurationMS: 120}
+	q.Dequeue(ctx, 1)
+	q.RecordAttempts(ctx, sent.ID, []email.Attempt{failed})
+	q.MarkDelivered(ctx, sent.ID)
+	
+	// Once the email has left the queue, results add to its history
+	delivered := email.Attempt{At: at.Add(time.Minute), Number: 2, Host: "mx.example.com", IP: "192.0.2.25", Result: email.AttemptDelivered, DurationMS: 80, TLS: true}
+	api.DeliveryResult(delivery.Result{
+		ID:       sent.ID,
+		Status:   email.StatusDelivered,
+		Attempt:  2,
+		At:       time.Now(),
+		Attempts: []email.Attempt{delivered},
+		Redirect: &email.Redirect{To: "qa@example.com", Recipients: []string{"a@example.com"}},
+	})
+	
+	req = httptest.NewRequest("GET", "/status/"+sent.ID, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	var status StatusResponse
+	json.NewDecoder(w.Body).Decode(&status)
+	if len(status.Attempts) != 2 || status.Attempts[0] != failed || status.Attempts[1] != delivered {
+		t.Errorf("Expected the greylisted attempt and then delivery, got %+v", status.Attempts)
+	}
+	if status.Redirect == nil || status.Redirect.To != "qa@example.com" || len(status.Redirect.Recipients) != 1 {
+		t.Errorf("Expected the redirect shown, got %+v", status.Redirect)
+	}
+}
+
+type stubResolver struct{}
+
+func (stubResolver) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	return []*net.MX{{Host: "mx." + domain, Pref: 10}}, nil
+}
+
+// refusingClient accepts every recipient except those at refused.test.
+type refusingClient struct{}
+
+func (refusingClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	if host != "mx.refused.test" {
+		return nil
+	}
+	rejected := make(map[string]error)
+	for _, rcpt := range rcpts {
+		rejected[rcpt] = &textproto.Error{Code: 550, Msg: "no such user"}
+	}
+	return &delivery.RecipientError{Rejected: rejected}
+}
+
+func TestAPI_StatusAfterExpiry(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewTracker()
+	q := queue.NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:     10,
+		RetryDelay:  time.Nanosecond,
+		MaxQueueAge: 50 * time.Millisecond,
+	}, tracker)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	api.SetTracker(tracker)
+	
+	body, _ := json.Marshal(SendEmailRequest{
+		From:    "sender@example.com",
+		To:      []string{"recipient@example.com"},
+		Subject: "Test",
+		Body:    "Test body",
+	})
+	req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	var sent SendEmailResponse
+	json.NewDecoder(w.Body).Decode(&sent)
+	
+	// One failed attempt leaves the API tracking its own copy
+	q.Dequeue(ctx, 1)
+	q.MarkFailed(ctx, sent.ID, "451 try again later", true)
+	api.DeliveryResult(delivery.Result{
+		ID:      sent.ID,
+		Status:  email.StatusQueued,
+		Err:     errors.New("451 try again later"),
+		Attempt: 1,
+		At:      time.Now(),
+	})
+	
+	// The retry then expires in the queue
+	time.Sleep(60 * time.Millisecond)
+	if emails, _ := q.Dequeue(ctx, 1); len(emails) != 0 {
+		t.Fatalf("Expected the email to expire, got %d", len(emails))
+	}
+	if stats := q.Stats(); stats.TotalExpired != 1 {
+		t.Fatalf("Expected 1 expired, got %d", stats.TotalExpired)
+	}
+	
+	req = httptest.NewRequest("GET", "/status/"+sent.ID, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	var status StatusResponse
+	json.NewDecoder(w.Body).Decode(&status)
+	if status.Status != string(email.StatusFailed) || status.RetryCount != 1 {
+		t.Errorf("Expected failed after 1 retry, got %s with %d", status.Status, status.RetryCount)
+	}
+	if status.LastError != queue.ErrExpired {
+		t.Errorf("Expected the expiry as the last error, got %q", status.LastError)
+	}
+}
+
+func TestAPI_DeliveryResults(t *testing.T) {
+	q := queue.NewMemoryQueue(10)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	
+	cfg := config.DefaultConfig().Delivery
+	cfg.Workers = 1
+	service := delivery.NewService(&cfg, q)
+	service.SetResolver(stubResolver{})
+	service.SetClient(refusingClient{})
+	service.SetResultHook(api.DeliveryResult)
+	
+	send := func(to string) string {
+		body, _ := json.Marshal(SendEmailRequest{
+			From:    "sender@example.com",
+			To:      []string{to},
+			Subject: "Test",
+			Body:    "Test body",
+		})
+		req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		var resp SendEmailResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.ID
+	}
+	delivered := send("someone@accepted.test")
+	failed := send("nobody@refused.test")
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		service.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	
+	var stats StatsResponse
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		req := httptest.NewRequest("GET", "/stats", nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		json.NewDecoder(w.Body).Decode(&stats)
+		if stats.TotalDelivered+stats.TotalFailed == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats.TotalSent != 2 || stats.TotalDelivered != 1 || stats.TotalFailed != 1 {
+		t.Fatalf("Expected 2 sent, 1 delivered and 1 failed, got %+v", stats)
+	}
+	
+	status := func(id string) StatusResponse {
+		req := httptest.NewRequest("GET", "/status/"+id, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		var resp StatusResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+	if s := status(delivered); s.Status != string(email.StatusDelivered) || s.DeliveredAt == nil {
+		t.Errorf("Expected %s delivered, got %+v", delivered, s)
+	}
+	s := status(failed)
+	if s.Status != string(email.StatusBounced) || !strings.Contains(s.LastError, "no such user") {
+		t.Errorf("Expected %s bounced with its error, got %+v", failed, s)
+	}
+	if f := s.LastFailure; f == nil || f.Code != 550 || f.Text != "no such user" || !f.Permanent {
+		t.Errorf("Expected a permanent 550 failure, got %+v", f)
+	}
+	if s.Recipients["nobody@refused.test"].Status != email.StatusFailed {
+		t.Errorf("Expected the recipient to have failed, got %+v", s.Recipients)
+	}
+}
+
+func TestAPI_SendWithSLA(t *testing.T) {
+	q := queue.NewMemoryQueue(10)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	
+	send := func(sla string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(SendEmailRequest{
+			From:    "sender@example.com",
+			To:      []string{"recipient@example.com"},
+			Subject: "Test",
+			Body:    "Test body",
+			SLA:     sla,
+		})
+		req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	
+	for _, sla := range []string{"soon", "-1m", "0s"} {
+		if w := send(sla); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for sla %q, got %d", sla, w.Code)
+		}
+	}
+	
+	before := time.Now()
+	w := send("60s")
+	var sent SendEmailResponse
+	json.NewDecoder(w.Body).Decode(&sent)
+	
+	req := httptest.NewRequest("GET", "/status/"+sent.ID, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	var status StatusResponse
+	json.NewDecoder(w.Body).Decode(&status)
+	if status.SLADeadline == nil || status.SLADeadline.Before(before.Add(60*time.Second)) || status.SLABreached {
+		t.Errorf("Expected a deadline 60s after acceptance, got %+v", status)
+	}
+}
+
+func TestAPI_TestEmail(t *testing.T) {
+	q := queue.NewMemoryQueue(10)
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+		TestEmail: config.TestEmailConfig{From: "postmaster@example.com", Timeout: 5 * time.Second},
+	}
+	api := New(cfg, q, 25*1024*1024)
+	api.SetDiagnostics("1.2.3", map[string]string{"DKIM": "enabled"})
+	
+	send := func(to string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(TestEmailRequest{To: to})
+		req := httptest.NewRequest("POST", "/admin/test-email", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	
+	// The form needs no token; sending does
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest("GET", "/admin/test-email", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<form") {
+		t.Errorf("Expected the form, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest("POST", "/admin/test-email", strings.NewReader(`{"to":"a@example.com"}`)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", w.Code)
+	}
+	if w := send(""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a recipient, got %d", w.Code)
+	}
+	
+	dcfg := config.DefaultConfig().Delivery
+	dcfg.Workers = 1
+	service := delivery.NewService(&dcfg, q)
+	service.SetResolver(stubResolver{})
+	service.SetClient(refusingClient{})
+	
+	// Capture the queued email before delivery to check its content
+	var queued *email.Email
+	service.SetResultHook(func(r delivery.Result) {
+		if v, ok := api.emailStatus.Load(r.ID); ok {
+			queued = v.(*email.Email)
+		}
+		api.DeliveryResult(r)
+	})
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		service.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	
+	w = send("someone@accepted.test")
+	var report TestEmailResponse
+	json.NewDecoder(w.Body).Decode(&report)
+	if report.Status != string(email.StatusDelivered) || report.DeliveredTo != "mx.accepted.test" {
+		t.Fatalf("Expected delivery to mx.accepted.test, got %+v", report)
+	}
+	if !strings.Contains(strings.Join(report.Log, "\n"), "Email delivered to mx.accepted.test") {
+		t.Errorf("Expected the attempt log, got %q", report.Log)
+	}
+	if queued == nil || queued.Priority != testEmailPriority || !strings.Contains(queued.Body, "Version: 1.2.3") || !strings.Contains(queued.Body, "DKIM: enabled") {
+		t.Errorf("Expected a high-priority diagnostic message, got %+v", queued)
+	}
+	
+	w = send("nobody@refused.test")
+	report = TestEmailResponse{}
+	json.NewDecoder(w.Body).Decode(&report)
+	if report.Status != string(email.StatusBounced) || report.Failure == nil || report.Failure.Code != 550 || len(report.Transactions) != 1 {
+		t.Errorf("Expected a bounce with its failure details, got %+v", report)
+	}
+}
+
+func TestAPI_TestEmailTimeout(t *testing.T) {
+	cfg := &config.APIConfig{
+		AuthToken: "test-token",
+		TestEmail: config.TestEmailConfig{Timeout: 10 * time.Millisecond},
+	}
+	api := New(cfg, queue.NewMemoryQueue(10), 25*1024*1024)
+	
+	req := httptest.NewRequest("POST", "/admin/test-email", strings.NewReader(`{"to":"someone@example.com"}`))
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	
+	var report TestEmailResponse
+	json.NewDecoder(w.Body).Decode(&report)
+	if w.Code != http.StatusOK || report.Status != "pending" || report.ID == "" {
+		t.Errorf("Expected a pending report, got %d %+v", w.Code, report)
+	}
+}
+
+func TestAPI_Cancel(t *testing.T) {
+	q := queue.NewMemoryQueue(10)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	auditLog, _ := audit.NewLog(nil, nil)
+	api.SetAuditLog(auditLog, false)
+	
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	send := func() string {
+		body, _ := json.Marshal(SendEmailRequest{
+			From:    "sender@example.com",
+			To:      []string{"recipient@example.com"},
+			Subject: "Test",
+			Body:    "Test body",
+		})
+		var sent SendEmailResponse
+		json.NewDecoder(do("POST", "/send", body).Body).Decode(&sent)
+		return sent.ID
+	}
+	
+	// A queued email is rejected at once
+	waiting := send()
+	w := do("DELETE", "/status/"+waiting, []byte(`{"reason":"sent in error"}`))
+	var resp SendEmailResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Status != string(email.StatusRejected) {
+		t.Fatalf("Expected the email rejected, got %d %+v", w.Code, resp)
+	}
+	var status StatusResponse
+	json.NewDecoder(do("GET", "/status/"+waiting, nil).Body).Decode(&status)
+	if status.Status != string(email.StatusRejected) || status.RejectedBy != queue.CancelledBy || status.RejectReason != "sent in error" {
+		t.Errorf("Expected a cancelled email, got %+v", status)
+	}
+	
+	// An email being delivered is cancelled before it is sent
+	sending := send()
+	q.Dequeue(context.Background(), 1)
+	w = do("DELETE", "/status/"+sending, nil)
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Status != string(email.StatusSending) {
+		t.Errorf("Expected cancellation to be pending, got %d %+v", w.Code, resp)
+	}
+	if _, ok := q.Cancelled(context.Background(), sending); !ok {
+		t.Error("Expected the sending email to be flagged")
+	}
+	
+	if w := do("DELETE", "/status/"+waiting, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 cancelling a finished email, got %d", w.Code)
+	}
+}
+
+// committingQueue stands in for a durable backend whose commit takes delay.
+type committingQueue struct {
+	mockQueue
+	delay     time.Duration
+	err       error
+	committed []string
+}
+
+func (c *committingQueue) Commit(ctx context.Context, id string) error {
+	select {
+	case <-time.After(c.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if c.err != nil {
+		return c.err
+	}
+	c.committed = append(c.committed, id)
+	return nil
+}
+
+func TestAPI_SendEmailDurability(t *testing.T) {
+	cfg := &config.APIConfig{AuthToken: "test-token"}
+	
+	send := func(api *API, payload SendEmailRequest, header string) (*httptest.ResponseRecorder, SendEmailResponse, time.Duration) {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		if header != "" {
+			req.Header.Set("X-Durability", header)
+		}
+		w := httptest.NewRecorder()
+		start := time.Now()
+		api.ServeHTTP(w, req)
+		elapsed := time.Since(start)
+		var resp SendEmailResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp, elapsed
+	}
+	payload := SendEmailRequest{
+		From:    "sender@example.com",
+		To:      []string{"recipient@example.com"},
+		Subject: "Test",
+		Body:    "Test body",
+	}
+	
+	q := &committingQueue{delay: 50 * time.Millisecond}
+	api := New(cfg, q, 25*1024*1024)
+	
+	// The default stays a fast acknowledgement
+	w, resp, fast := send(api, payload, "")
+	if w.Code != http.StatusAccepted || resp.Committed {
+		t.Errorf("Expected 202 uncommitted, got %d %+v", w.Code, resp)
+	}
+	if len(q.committed) != 0 {
+		t.Errorf("Expected no commit for a default send, got %v", q.committed)
+	}
+	
+	confirmed := payload
+	confirmed.Durability = DurabilityConfirmed
+	w, resp, slow := send(api, confirmed, "")
+	if w.Code != http.StatusCreated || !resp.Committed {
+		t.Fatalf("Expected 201 committed, got %d %+v", w.Code, resp)
+	}
+	if len(q.committed) != 1 || q.committed[0] != resp.ID {
+		t.Errorf("Expected %s committed, got %v", resp.ID, q.committed)
+	}
+	if slow < q.delay || fast >= q.delay {
+		t.Errorf("Expected only the confirmed send to wait for the commit, took %v and %v", fast, slow)
+	}
+	
+	// The header works as well as the field
+	if w, resp, _ := send(api, payload, DurabilityConfirmed); w.Code != http.StatusCreated || !resp.Committed {
+		t.Errorf("Expected 201 committed via header, got %d %+v", w.Code, resp)
+	}
+	
+	if w, _, _ := send(api, payload, "eventually"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown durability, got %d", w.Code)
+	}
+	
+	q.err = errors.New("disk full")
+	if w, _, _ := send(api, confirmed, ""); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when the commit fails, got %d", w.Code)
+	}
+	
+	// A memory queue cannot commit, so confirmed sends are acknowledged
+	// as before and say so
+	memory := New(cfg, &mockQueue{}, 25*1024*1024)
+	if w, resp, _ := send(memory, confirmed, ""); w.Code != http.StatusAccepted || resp.Committed {
+		t.Errorf("Expected 202 uncommitted from a memory queue, got %d %+v", w.Code, resp)
+	}
+}
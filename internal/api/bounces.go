@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	
+	"github.com/google/uuid"
+	"github.com/tpdoyle87/simple-email-server/internal/bounce"
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// EventEmailBounced reports an email a remote server bounced after
+// accepting it, one event per bounce.
+const EventEmailBounced = "email.bounced"
+
+const (
+	// bounceReviewSize is how many unprocessed bounces are kept
+	bounceReviewSize = 100
+	
+	// bounceReviewMaxBytes is how much of each one's message is kept
+	bounceReviewMaxBytes = 64 * 1024
+)
+
+// UnprocessedBounce is mail sent to the return path that could not be
+// matched to an email: it isn't a delivery status notification, names no
+// email with a valid signature, or names one the API doesn't track. It is
+// kept for review.
+type UnprocessedBounce struct {
+	ID        string    `json:"id"`
+	Received  time.Time `json:"received"`
+	Recipient string    `json:"recipient"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// bounceReview holds the return path's local part and VERP secret, and
+// the most recent unprocessed bounces.
+type bounceReview struct {
+	mu        sync.Mutex
+	localPart string
+	secret    string
+	items     []UnprocessedBounce
+}
+
+// SetReturnPath sets the return path bounces are sent to, so HandleBounce
+// can find and verify the email a VERP address names.
+func (a *API) SetReturnPath(cfg *config.ReturnPathConfig) {
+	a.bounces.mu.Lock()
+	defer a.bounces.mu.Unlock()
+	a.bounces.localPart = cfg.LocalPart
+	a.bounces.secret = cfg.Secret
+}
+
+// HandleBounce processes a message sent to the return path address rcpt.
+// A delivery status notification whose signed VERP address or envelope ID
+// names an email the API tracks marks its
+// failed recipients, and bounces the email once none of its recipients
+// remains delivered; reports of delayed delivery are ignored. Anything
+// else is kept for review at /admin/bounces. Install it with the SMTP
+// server's SetBounceHandler.
+func (a *API) HandleBounce(ctx context.Context, rcpt string, msg []byte) error {
+	report, err := bounce.Parse(msg)
+	if err != nil {
+		a.reviewBounce(rcpt, msg, err.Error())
+		return nil
+	}
+	failed := report.Failed()
+	if len(failed) == 0 {
+		log.Printf("Ignoring delivery status notification to %s without failures", rcpt)
+		return nil
+	}
+	
+	a.bounces.mu.Lock()
+	localPart, secret := a.bounces.localPart, a.bounces.secret
+	a.bounces.mu.Unlock()
+	if localPart == "" {
+		localPart = "bounce"
+	}
+	id, ok := report.EmailID(rcpt, localPart, secret)
+	if !ok {
+		a.reviewBounce(rcpt, msg, "no signed email ID found")
+		return nil
+	}
+	if !a.bounced(id, failed) {
+		a.reviewBounce(rcpt, msg, "unknown email "+id)
+		return nil
+	}
+	
+	a.counters.series.Add(MetricBounced, time.Now(), 1)
+	a.emit(EventEmailBounced, map[string]string{"recipient": failed[0].Address}, 1, []string{id})
+	log.Printf("Email %s bounced for %s: %s", id, failed[0].Address, failed[0].Reason())
+	return nil
+}
+
+// bounced records the failed recipients of the tracked email id, and
+// reports whether it is tracked.
+func (a *API) bounced(id string, failed []bounce.Recipient) bool {
+	if _, ok := a.emailStatus.Load(id); !ok {
+		return false
+	}
+	a.invalidate(id)
+	
+	now := time.Now()
+	reason := failed[0].Reason()
+	a.updateTracked(id, func(e *email.Email) {
+		for _, f := range failed {
+			rcpt, ok := emailRecipient(e, f.Address)
+			if !ok {
+				continue
+			}
+			if e.RecipientStatus == nil {
+				e.RecipientStatus = make(map[string]email.RecipientStatus)
+			}
+			e.RecipientStatus[rcpt] = email.RecipientStatus{Status: email.StatusFailed, LastError: f.Reason()}
+		}
+		e.LastFailure = &email.Failure{Code: replyCode(reason), Text: reason, Permanent: true}
+		
+		for _, status := range e.RecipientStatus {
+			if status.Status == email.StatusDelivered {
+				e.SetError(reason)
+				return
+			}
+		}
+		e.Mirror(email.StatusBounced, now, e.RetryCount, reason)
+	})
+	return true
+}
+
+// emailRecipient returns e's recipient matching addr in any case.
+func emailRecipient(e *email.Email, addr string) (string, bool) {
+	for _, list := range [][]string{e.To, e.CC, e.BCC} {
+		for _, rcpt := range list {
+			if strings.EqualFold(rcpt, addr) {
+				return rcpt, true
+			}
+		}
+	}
+	return "", false
+}
+
+// replyCode returns the SMTP reply code a diagnostic starts with, or 0.
+func replyCode(diagnostic string) int {
+	if len(diagnostic) < 3 {
+		return 0
+	}
+	code, err := strconv.Atoi(diagnostic[:3])
+	if err != nil {
+		return 0
+	}
+	return code
+}
+
+// reviewBounce keeps msg, sent to rcpt, for review, dropping the oldest
+// once bounceReviewSize are kept.
+func (a *API) reviewBounce(rcpt string, msg []byte, reason string) {
+	b := UnprocessedBounce{
+		ID:        uuid.New().String(),
+		Received:  time.Now(),
+		Recipient: rcpt,
+		Reason:    reason,
+		Message:   string(msg[:min(len(msg), bounceReviewMaxBytes)]),
+		Truncated: len(msg) > bounceReviewMaxBytes,
+	}
+	log.Printf("Keeping bounce %s to %s for review: %s", b.ID, rcpt, reason)
+	
+	r := &a.bounces
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items = append(r.items, b)
+	if len(r.items) > bounceReviewSize {
+		r.items = r.items[len(r.items)-bounceReviewSize:]
+	}
+}
+
+// handleBounces serves GET /admin/bounces, the unprocessed bounces oldest
+// first.
+func (a *API) handleBounces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	a.bounces.mu.Lock()
+	items := append([]UnprocessedBounce{}, a.bounces.items...)
+	a.bounces.mu.Unlock()
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
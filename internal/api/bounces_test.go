@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// dsn returns a delivery status notification failing rcpt.
+func dsn(rcpt string) []byte {
+	return []byte(fmt.Sprintf("From: MAILER-DAEMON@mx.example.com\r\n"+
+		"Content-Type: multipart/report; report-type=delivery-status; boundary=b\r\n"+
+		"\r\n"+
+		"--b\r\n"+
+		"Content-Type: message/delivery-status\r\n"+
+		"\r\n"+
+		"Reporting-MTA: dns; mx.example.com\r\n"+
+		"\r\n"+
+		"Final-Recipient: rfc822; %s\r\n"+
+		"Action: failed\r\n"+
+		"Status: 5.1.1\r\n"+
+		"Diagnostic-Code: smtp; 550 5.1.1 User unknown\r\n"+
+		"\r\n"+
+		"--b--\r\n", rcpt))
+}
+
+func TestAPI_HandleBounce(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(100)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	api.SetReturnPath(&config.ReturnPathConfig{Domain: "bounces.example.com", LocalPart: "bounce", VERP: true, Secret: "secret"})
+	recorder := &eventRecorder{}
+	api.AddEventListener(recorder)
+	
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	send := func(to ...string) string {
+		body, _ := json.Marshal(SendEmailRequest{From: "sender@example.com", To: to, Subject: "Test", Body: "Test body"})
+		var sent SendEmailResponse
+		json.NewDecoder(do("POST", "/send", body).Body).Decode(&sent)
+		return sent.ID
+	}
+	status := func(id string) StatusResponse {
+		var s StatusResponse
+		json.NewDecoder(do("GET", "/status/"+id, nil).Body).Decode(&s)
+		return s
+	}
+	// deliver delivers the queued emails to every recipient
+	deliver := func() {
+		emails, _ := q.Dequeue(ctx, 10)
+		for _, e := range emails {
+			q.MarkDelivered(ctx, e.ID)
+			recipients := make(map[string]email.RecipientStatus)
+			for _, rcpt := range e.To {
+				recipients[rcpt] = email.RecipientStatus{Status: email.StatusDelivered}
+			}
+			api.DeliveryResult(delivery.Result{ID: e.ID, Status: email.StatusDelivered, Attempt: 1, Recipients: recipients, At: time.Now()})
+		}
+	}
+	
+	single := send("missing@example.com")
+	multi := send("missing@example.org", "present@example.org")
+	deliver()
+	
+	verp := func(id string) string { return email.EncodeVERP("bounce", id, "bounces.example.com", "secret") }
+	if err := api.HandleBounce(ctx, verp(single), dsn("Missing@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	s := status(single)
+	if s.Status != string(email.StatusBounced) || s.LastError != "550 5.1.1 User unknown" || s.LastFailure == nil || s.LastFailure.Code != 550 {
+		t.Errorf("Expected the email bounced with the diagnostic, got %+v", s)
+	}
+	if s.Recipients["missing@example.com"].Status != email.StatusFailed {
+		t.Errorf("Expected the recipient failed, got %+v", s.Recipients)
+	}
+	if len(recorder.events) != 1 || recorder.events[0].Type != EventEmailBounced || recorder.events[0].SampleIDs[0] != single {
+		t.Errorf("Expected one bounce event, got %+v", recorder.events)
+	}
+	
+	// Another recipient was delivered, so the email stays delivered
+	api.HandleBounce(ctx, verp(multi), dsn("missing@example.org"))
+	s = status(multi)
+	if s.Status != string(email.StatusDelivered) || s.Recipients["missing@example.org"].Status != email.StatusFailed || s.Recipients["present@example.org"].Status != email.StatusDelivered {
+		t.Errorf("Expected one recipient failed and the email delivered, got %+v", s)
+	}
+	
+	// What can't be matched to an email is kept for review, including a
+	// forged bounce naming an email without the signature
+	api.HandleBounce(ctx, "bounce@bounces.example.com", dsn("someone@example.com"))
+	api.HandleBounce(ctx, verp("no-such-email"), dsn("someone@example.com"))
+	api.HandleBounce(ctx, verp(single), []byte("Subject: Out of office\r\n\r\nAway\r\n"))
+	api.HandleBounce(ctx, "bounce+"+multi+"@bounces.example.com", dsn("present@example.org"))
+	var review []UnprocessedBounce
+	json.NewDecoder(do("GET", "/admin/bounces", nil).Body).Decode(&review)
+	if len(review) != 4 {
+		t.Fatalf("Expected 4 bounces for review, got %+v", review)
+	}
+	if s := status(multi); s.Recipients["present@example.org"].Status != email.StatusDelivered {
+		t.Errorf("Expected a forged bounce to change nothing, got %+v", s.Recipients)
+	}
+	for i, want := range []string{"no signed email ID found", "unknown email no-such-email", "not a delivery status notification", "no signed email ID found"} {
+		if review[i].Reason != want || review[i].Message == "" {
+			t.Errorf("Review %d: expected %q with its message, got %+v", i, want, review[i])
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+)
+
+var errNotPaused = errors.New("domain is not paused")
+
+// DomainPauser pauses and resumes delivery to recipient domains,
+// normally the delivery service.
+type DomainPauser interface {
+	PauseDomain(domain string, d time.Duration, reason string) (delivery.DomainPause, error)
+	ResumeDomain(domain string) (bool, error)
+	PausedDomains() []delivery.DomainPause
+}
+
+// PauseDomainRequest is the optional body of a domain pause. Duration is
+// a Go duration such as "2h"; without one the pause lasts until resumed.
+type PauseDomainRequest struct {
+	Duration string `json:"duration,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// PausedDomain is a paused recipient domain and how many queued emails
+// are held for it.
+type PausedDomain struct {
+	delivery.DomainPause
+	Held int `json:"held"`
+}
+
+// SetDomainPauser enables the /admin/domains endpoints and reports paused
+// domains in /health.
+func (a *API) SetDomainPauser(p DomainPauser) {
+	a.pauser = p
+}
+
+// handleDomains serves /admin/domains, listing the paused domains, and
+// /admin/domains/{domain}/pause and /resume.
+func (a *API) handleDomains(w http.ResponseWriter, r *http.Request) {
+	if a.pauser == nil {
+		a.errorResponse(w, http.StatusNotImplemented, "domain pausing is not enabled")
+		return
+	}
+	
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/domains"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.pausedDomains())
+		return
+	}
+	
+	if r.Method != http.MethodPost {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	domain, op, _ := strings.Cut(strings.ToLower(path), "/")
+	if domain == "" || strings.Contains(op, "/") {
+		a.errorResponse(w, http.StatusNotFound, "not found")
+		return
+	}
+	
+	switch op {
+	case "pause":
+		a.pauseDomain(w, r, domain)
+	case "resume":
+		a.resumeDomain(w, r, domain)
+	default:
+		a.errorResponse(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (a *API) pauseDomain(w http.ResponseWriter, r *http.Request, domain string) {
+	var req PauseDomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		a.errorResponse(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	var d time.Duration
+	if req.Duration != "" {
+		var err error
+		if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 {
+			a.errorResponse(w, http.StatusBadRequest, "duration must be a positive duration such as \"2h\"")
+			return
+		}
+	}
+	
+	params := map[string]string{"domain": domain}
+	if req.Duration != "" {
+		params["duration"] = req.Duration
+	}
+	if req.Reason != "" {
+		params["reason"] = req.Reason
+	}
+	var resp PausedDomain
+	_, err := a.audited(r, "domain.pause", params, func() (int, error) {
+		pause, err := a.pauser.PauseDomain(domain, d, req.Reason)
+		resp = PausedDomain{DomainPause: pause, Held: a.heldFor(domain)}
+		return resp.Held, err
+	})
+	if err != nil {
+		// The pause is in effect even if it could not be saved
+		a.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	a.emitDomainPaused(r.Context(), params, domain, resp.Held)
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (a *API) resumeDomain(w http.ResponseWriter, r *http.Request, domain string) {
+	var resp PausedDomain
+	_, err := a.audited(r, "domain.resume", map[string]string{"domain": domain}, func() (int, error) {
+		for _, p := range a.pauser.PausedDomains() {
+			if p.Domain == domain {
+				resp = PausedDomain{DomainPause: p, Held: a.heldFor(domain)}
+			}
+		}
+		resumed, err := a.pauser.ResumeDomain(domain)
+		if err == nil && !resumed {
+			err = errNotPaused
+		}
+		return resp.Held, err
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, errNotPaused):
+		a.errorResponse(w, http.StatusNotFound, err.Error())
+		return
+	default:
+		a.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// pausedDomains returns the paused domains with the emails held for each.
+func (a *API) pausedDomains() []PausedDomain {
+	pauses := a.pauser.PausedDomains()
+	resp := make([]PausedDomain, len(pauses))
+	if len(pauses) == 0 {
+		return resp
+	}
+	var backlog queue.Backlog
+	if reporter, ok := a.queue.(queue.BacklogReporter); ok {
+		backlog = reporter.Backlog(time.Now(), 0, 1)
+	}
+	for i, p := range pauses {
+		d := backlog.Domains[p.Domain]
+		resp[i] = PausedDomain{DomainPause: p, Held: d.Ready + d.Scheduled}
+	}
+	return resp
+}
+
+// heldFor is how many queued emails are waiting for domain, zero if the
+// queue can't tell.
+func (a *API) heldFor(domain string) int {
+	reporter, ok := a.queue.(queue.BacklogReporter)
+	if !ok {
+		return 0
+	}
+	d := reporter.Backlog(time.Now(), 0, 1).Domains[domain]
+	return d.Ready + d.Scheduled
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// fakePauser keeps pauses in a map.
+type fakePauser struct {
+	pauses map[string]delivery.DomainPause
+}
+
+func (f *fakePauser) PauseDomain(domain string, d time.Duration, reason string) (delivery.DomainPause, error) {
+	p := delivery.DomainPause{Domain: domain, Reason: reason, PausedAt: time.Now()}
+	if d > 0 {
+		until := p.PausedAt.Add(d)
+		p.Until = &until
+	}
+	f.pauses[domain] = p
+	return p, nil
+}
+
+func (f *fakePauser) ResumeDomain(domain string) (bool, error) {
+	_, ok := f.pauses[domain]
+	delete(f.pauses, domain)
+	return ok, nil
+}
+
+func (f *fakePauser) PausedDomains() []delivery.DomainPause {
+	var pauses []delivery.DomainPause
+	for _, p := range f.pauses {
+		pauses = append(pauses, p)
+	}
+	return pauses
+}
+
+func TestAPI_PauseDomain(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(100)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	
+	if w := do("GET", "/admin/domains", ""); w.Code != http.StatusNotImplemented {
+		t.Fatalf("Expected 501 without a pauser, got %d", w.Code)
+	}
+	api.SetDomainPauser(&fakePauser{pauses: make(map[string]delivery.DomainPause)})
+	
+	for _, to := range []string{"a@example.com", "b@example.com", "c@other.com"} {
+		if err := q.Enqueue(ctx, &email.Email{ID: to, From: "sender@test.com", To: []string{to}, Subject: "Hi", Body: "Body"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	
+	w := do("POST", "/admin/domains/Example.com/pause", `{"duration": "2h", "reason": "incident"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var paused PausedDomain
+	json.NewDecoder(w.Body).Decode(&paused)
+	if paused.Domain != "example.com" || paused.Reason != "incident" || paused.Until == nil || paused.Held != 2 {
+		t.Errorf("Unexpected pause %+v", paused)
+	}
+	
+	if w := do("POST", "/admin/domains/example.com/pause", `{"duration": "-1h"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative duration, got %d", w.Code)
+	}
+	
+	w = do("GET", "/admin/domains", "")
+	var list []PausedDomain
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list) != 1 || list[0].Domain != "example.com" || list[0].Held != 2 {
+		t.Errorf("Expected example.com listed with 2 held, got %+v", list)
+	}
+	
+	req := httptest.NewRequest("GET", "/health", nil)
+	hw := httptest.NewRecorder()
+	api.ServeHTTP(hw, req)
+	var health HealthResponse
+	json.NewDecoder(hw.Body).Decode(&health)
+	if health.Status != "healthy" || len(health.PausedDomains) != 1 {
+		t.Errorf("Expected the pause in a healthy /health, got %+v", health)
+	}
+	
+	if w := do("POST", "/admin/domains/example.com/resume", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", w.Code)
+	}
+	if w := do("POST", "/admin/domains/example.com/resume", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 resuming a domain that is not paused, got %d", w.Code)
+	}
+}
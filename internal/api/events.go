@@ -0,0 +1,302 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	
+	"github.com/google/uuid"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// EventSchemaVersion is the Version of every Event. It goes up when a
+// field is removed or changes meaning; adding one does not change it.
+const EventSchemaVersion = 1
+
+// Events for operations on many emails at once. Each is reported once per
+// operation, however many emails it touched. EventEmailBounced is the
+// exception, reported for each bounce HandleBounce processes.
+const (
+	EventEmailsPurged   = "emails.purged"
+	EventBatchCancelled = "batch.cancelled"
+	EventDomainPaused   = "domain.paused"
+)
+
+const (
+	// eventSampleSize is how many affected IDs an Event carries
+	eventSampleSize = 10
+	
+	// eventExports is how many events' full ID lists are kept for export
+	eventExports = 100
+)
+
+// Event reports one bulk operation: the filter it was given, how many
+// emails it affected and a sample of their IDs. The full list can be
+// fetched from ExportURL, a path on this API, until eventExports later
+// events have replaced it.
+type Event struct {
+	ID        string            `json:"id"`
+	Version   int               `json:"version"`
+	Type      string            `json:"type"`
+	Time      time.Time         `json:"time"`
+	Filter    map[string]string `json:"filter,omitempty"`
+	Count     int               `json:"count"`
+	SampleIDs []string          `json:"sample_ids"`
+	ExportURL string            `json:"export_url,omitempty"`
+}
+
+// EventListener receives bulk operation events, for example to forward
+// them to a webhook. Callbacks run on the request's goroutine after the
+// operation has been applied.
+type EventListener interface {
+	OnEvent(ev Event)
+}
+
+// EventExport is the full list of IDs affected by an event.
+type EventExport struct {
+	EventID string   `json:"event_id"`
+	IDs     []string `json:"ids"`
+}
+
+// PurgeRequest selects the emails to purge. At least one of Domain, From,
+// Status and Before must be set; Before is RFC 3339.
+type PurgeRequest struct {
+	Domain string `json:"domain,omitempty"`
+	From   string `json:"from,omitempty"`
+	Status string `json:"status,omitempty"`
+	Before string `json:"before,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// CancelBatchRequest lists the emails to cancel.
+type CancelBatchRequest struct {
+	IDs    []string `json:"ids"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+// eventLog fans events out to listeners and keeps the ID lists of recent
+// ones.
+type eventLog struct {
+	mu        sync.Mutex
+	listeners []EventListener
+	exports   map[string][]string
+	order     []string
+}
+
+// AddEventListener reports bulk operations to l.
+func (a *API) AddEventListener(l EventListener) {
+	a.events.mu.Lock()
+	defer a.events.mu.Unlock()
+	a.events.listeners = append(a.events.listeners, l)
+}
+
+// emit reports an operation of eventType that affected count emails,
+// whose IDs are ids if known, and returns the event.
+func (a *API) emit(eventType string, filter map[string]string, count int, ids []string) Event {
+	ev := Event{
+		ID:        uuid.New().String(),
+		Version:   EventSchemaVersion,
+		Type:      eventType,
+		Time:      time.Now(),
+		Filter:    filter,
+		Count:     count,
+		SampleIDs: append([]string{}, ids[:min(len(ids), eventSampleSize)]...),
+	}
+	
+	l := &a.events
+	l.mu.Lock()
+	if len(ids) > 0 {
+		ev.ExportURL = "/admin/events/" + ev.ID + "/ids"
+		if l.exports == nil {
+			l.exports = make(map[string][]string)
+		}
+		l.exports[ev.ID] = ids
+		l.order = append(l.order, ev.ID)
+		if len(l.order) > eventExports {
+			delete(l.exports, l.order[0])
+			l.order = l.order[1:]
+		}
+	}
+	listeners := l.listeners
+	l.mu.Unlock()
+	
+	for _, listener := range listeners {
+		listener.OnEvent(ev)
+	}
+	return ev
+}
+
+// handleEventExport serves /admin/events/{id}/ids.
+func (a *API) handleEventExport(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/admin/events/"), "/ids")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		a.errorResponse(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	
+	a.events.mu.Lock()
+	ids, ok := a.events.exports[id]
+	a.events.mu.Unlock()
+	if !ok {
+		a.errorResponse(w, http.StatusNotFound, "event not found or expired")
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(EventExport{EventID: id, IDs: ids})
+}
+
+// handlePurge serves POST /admin/purge, cancelling every email in the
+// queue matching the request's filter.
+func (a *API) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	bulk, ok := a.queue.(queue.BulkOperator)
+	if !ok {
+		a.errorResponse(w, http.StatusNotImplemented, "queue does not support bulk operations")
+		return
+	}
+	
+	var req PurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		a.errorResponse(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	f := queue.Filter{Domain: req.Domain, From: req.From, Status: email.Status(req.Status)}
+	switch f.Status {
+	case "", email.StatusQueued, email.StatusSending, email.StatusQuarantined, email.StatusHeld:
+	default:
+		a.errorResponse(w, http.StatusBadRequest, "status must be queued, sending, quarantined or held")
+		return
+	}
+	if req.Before != "" {
+		var err error
+		if f.Before, err = time.Parse(time.RFC3339, req.Before); err != nil {
+			a.errorResponse(w, http.StatusBadRequest, "before must be an RFC 3339 time")
+			return
+		}
+	}
+	if f == (queue.Filter{}) {
+		a.errorResponse(w, http.StatusBadRequest, "a purge needs at least one of domain, from, status and before")
+		return
+	}
+	
+	filter := make(map[string]string)
+	for key, value := range map[string]string{"domain": req.Domain, "from": req.From, "status": req.Status, "before": req.Before} {
+		if value != "" {
+			filter[key] = value
+		}
+	}
+	params := make(map[string]string, len(filter)+1)
+	for key, value := range filter {
+		params[key] = value
+	}
+	if req.Reason != "" {
+		params["reason"] = req.Reason
+	}
+	var purged []string
+	_, err := a.audited(r, "purge", params, func() (int, error) {
+		var err error
+		purged, err = bulk.Purge(r.Context(), f, req.Reason)
+		return len(purged), err
+	})
+	if err != nil {
+		if r.Context().Err() == nil {
+			a.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+		}
+		return
+	}
+	a.cancelled(r.Context(), purged, queue.PurgedBy, req.Reason)
+	
+	ev := a.emit(EventEmailsPurged, filter, len(purged), purged)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ev)
+}
+
+// handleCancelBatch serves POST /admin/cancel, cancelling the listed
+// emails as DELETE /status/{id} does each one. IDs no longer in the queue
+// are skipped and not counted.
+func (a *API) handleCancelBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	bulk, ok := a.queue.(queue.BulkOperator)
+	if !ok {
+		a.errorResponse(w, http.StatusNotImplemented, "queue does not support bulk operations")
+		return
+	}
+	
+	var req CancelBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if len(req.IDs) == 0 {
+		a.errorResponse(w, http.StatusBadRequest, "ids is required")
+		return
+	}
+	
+	params := map[string]string{"ids": strconv.Itoa(len(req.IDs))}
+	if req.Reason != "" {
+		params["reason"] = req.Reason
+	}
+	var cancelled []string
+	_, err := a.audited(r, "cancel.batch", params, func() (int, error) {
+		var err error
+		cancelled, err = bulk.CancelBatch(r.Context(), req.IDs, req.Reason)
+		return len(cancelled), err
+	})
+	if err != nil {
+		if r.Context().Err() == nil {
+			a.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+		}
+		return
+	}
+	a.cancelled(r.Context(), cancelled, queue.CancelledBy, req.Reason)
+	
+	ev := a.emit(EventBatchCancelled, nil, len(cancelled), cancelled)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ev)
+}
+
+// emitDomainPaused reports a domain pause with the queued emails it
+// holds. Without a BulkOperator queue only their number is known.
+func (a *API) emitDomainPaused(ctx context.Context, filter map[string]string, domain string, held int) {
+	var ids []string
+	if bulk, ok := a.queue.(queue.BulkOperator); ok {
+		ids, _ = bulk.Match(ctx, queue.Filter{Domain: domain, Status: email.StatusQueued})
+		held = len(ids)
+	}
+	a.emit(EventDomainPaused, filter, held, ids)
+}
+
+// cancelled brings the tracked status of each of ids up to date after a
+// bulk cancellation. Emails still in the queue are being delivered and
+// are rejected by their worker instead.
+func (a *API) cancelled(ctx context.Context, ids []string, by, reason string) {
+	g, _ := a.queue.(queue.Getter)
+	for _, id := range ids {
+		a.invalidate(id)
+		if g != nil {
+			if _, err := g.Get(ctx, id); err == nil {
+				continue
+			}
+		}
+		a.updateTracked(id, func(e *email.Email) {
+			e.Reject(by, reason)
+		})
+	}
+}
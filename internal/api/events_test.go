@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// eventRecorder keeps the events it is given.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) OnEvent(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func TestAPI_BulkEvents(t *testing.T) {
+	q := queue.NewMemoryQueue(100)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	recorder := &eventRecorder{}
+	api.AddEventListener(recorder)
+	
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	send := func(to string) string {
+		body, _ := json.Marshal(SendEmailRequest{From: "sender@example.com", To: []string{to}, Subject: "Test", Body: "Test body"})
+		var sent SendEmailResponse
+		json.NewDecoder(do("POST", "/send", body).Body).Decode(&sent)
+		return sent.ID
+	}
+	event := func(w *httptest.ResponseRecorder) Event {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+		}
+		var ev Event
+		json.NewDecoder(w.Body).Decode(&ev)
+		return ev
+	}
+	
+	var bulk []string
+	for i := 0; i < 25; i++ {
+		bulk = append(bulk, send("user@bulk.example.com"))
+	}
+	other := []string{send("a@other.com"), send("b@other.com")}
+	
+	if w := do("POST", "/admin/purge", []byte(`{}`)); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a purge without a filter refused, got %d", w.Code)
+	}
+	
+	// One event for the whole purge, with a sample and the full list
+	ev := event(do("POST", "/admin/purge", []byte(`{"domain": "bulk.example.com", "reason": "spam run"}`)))
+	if ev.Type != EventEmailsPurged || ev.Version != EventSchemaVersion || ev.Count != 25 || len(ev.SampleIDs) != eventSampleSize || ev.Filter["domain"] != "bulk.example.com" {
+		t.Fatalf("Unexpected purge event %+v", ev)
+	}
+	var export EventExport
+	json.NewDecoder(do("GET", ev.ExportURL, nil).Body).Decode(&export)
+	if export.EventID != ev.ID || len(export.IDs) != 25 {
+		t.Fatalf("Expected all 25 IDs exported, got %+v", export)
+	}
+	for _, id := range bulk {
+		var status StatusResponse
+		json.NewDecoder(do("GET", "/status/"+id, nil).Body).Decode(&status)
+		if status.Status != string(email.StatusRejected) || status.RejectedBy != queue.PurgedBy || status.RejectReason != "spam run" {
+			t.Fatalf("Expected %s purged, got %+v", id, status)
+		}
+	}
+	
+	// Unknown IDs are skipped
+	body, _ := json.Marshal(CancelBatchRequest{IDs: append(other, "missing"), Reason: "sent in error"})
+	ev = event(do("POST", "/admin/cancel", body))
+	if ev.Type != EventBatchCancelled || ev.Count != 2 || len(ev.SampleIDs) != 2 {
+		t.Fatalf("Unexpected cancel event %+v", ev)
+	}
+	
+	api.SetDomainPauser(&fakePauser{pauses: make(map[string]delivery.DomainPause)})
+	for i := 0; i < 3; i++ {
+		send("user@paused.example.com")
+	}
+	event(do("POST", "/admin/domains/paused.example.com/pause", []byte(`{"reason": "incident"}`)))
+	
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.events) != 3 {
+		t.Fatalf("Expected one event per operation, got %d", len(recorder.events))
+	}
+	paused := recorder.events[2]
+	if paused.Type != EventDomainPaused || paused.Count != 3 || paused.Filter["reason"] != "incident" {
+		t.Errorf("Unexpected pause event %+v", paused)
+	}
+	
+	if stats := q.Stats(); stats.Queued != 3 || stats.TotalRejected != 27 {
+		t.Errorf("Expected the purged and cancelled emails rejected, got %+v", stats)
+	}
+}
+
+func TestAPI_EventExportsExpire(t *testing.T) {
+	api := New(&config.APIConfig{AuthToken: "test-token"}, queue.NewMemoryQueue(10), 25*1024*1024)
+	first := api.emit(EventBatchCancelled, nil, 1, []string{"a"})
+	for i := 0; i < eventExports; i++ {
+		api.emit(EventBatchCancelled, nil, 1, []string{"b"})
+	}
+	
+	req := httptest.NewRequest("GET", first.ExportURL, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected the oldest export gone, got %d", w.Code)
+	}
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+)
+
+const (
+	// The forecast is a point per minute for the next hour
+	forecastInterval = time.Minute
+	forecastPoints   = 60
+	
+	// Past the series the backlog is simulated for up to a week more to
+	// find when it drains
+	forecastHorizon = 7 * 24 * time.Hour
+	
+	// Throughput is averaged over the last few complete minutes
+	forecastThroughputMinutes = 5
+	
+	// Domains of the backlog whose rate limits are modelled, the largest
+	forecastDomains = 10
+	
+	// A forecast is reused for this long
+	forecastCacheTTL = 5 * time.Second
+)
+
+// ForecastPoint is the backlog expected at a time.
+type ForecastPoint struct {
+	Time        time.Time `json:"time"`
+	Outstanding int       `json:"outstanding"`
+}
+
+// ForecastResponse is the response from /stats/forecast.
+type ForecastResponse struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Ready       int       `json:"ready"`
+	Sending     int       `json:"sending"`
+	Scheduled   int       `json:"scheduled"`
+	
+	// Emails finished, delivered or failed, per minute lately
+	ThroughputPerMinute float64 `json:"throughput_per_minute"`
+	
+	// When the backlog is expected to be gone, null if it is not expected
+	// to drain within a week at the current throughput
+	EstimatedDrainAt *time.Time `json:"estimated_drain_at"`
+	
+	// The recipient domain whose rate limit holds draining back, if any
+	LimitedBy string `json:"limited_by,omitempty"`
+	
+	Points []ForecastPoint `json:"points"`
+}
+
+// SetDomainRates sets the source of per-domain send rate limits the drain
+// forecast allows for, normally the delivery service's DomainRate method.
+func (a *API) SetDomainRates(rate func(domain string) (config.Rate, bool)) {
+	a.domainRates = rate
+}
+
+// forecastCache holds the latest forecast, so that polling the endpoint
+// does not walk the queue on every request.
+type forecastCache struct {
+	mu       sync.Mutex
+	forecast *ForecastResponse
+}
+
+// handleGetForecast serves /stats/forecast: how the backlog is expected
+// to shrink at the current throughput, as scheduled emails become ready.
+func (a *API) handleGetForecast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	reporter, ok := a.queue.(queue.BacklogReporter)
+	if !ok {
+		a.errorResponse(w, http.StatusNotImplemented, "queue does not support forecasts")
+		return
+	}
+	
+	now := time.Now()
+	a.forecasts.mu.Lock()
+	f := a.forecasts.forecast
+	if f == nil || now.Sub(f.GeneratedAt) >= forecastCacheTTL {
+		backlog := reporter.Backlog(now, forecastInterval, forecastPoints)
+		f = forecast(backlog, a.throughput(now), a.domainRates, now)
+		a.forecasts.forecast = f
+	}
+	a.forecasts.mu.Unlock()
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
+
+// throughput is how many emails were delivered or failed per minute over
+// the last few complete minutes.
+func (a *API) throughput(now time.Time) float64 {
+	var total int64
+	for _, metric := range []string{MetricDelivered, MetricFailed} {
+		points, _ := a.counters.series.Points(metric, ResolutionMinute, now)
+		for _, p := range points[len(points)-1-forecastThroughputMinutes : len(points)-1] {
+			total += p.Count
+		}
+	}
+	return float64(total) / forecastThroughputMinutes
+}
+
+// forecastLane is part of the backlog drained at its own pace: a rate
+// limited domain, or all the rest.
+type forecastLane struct {
+	domain    string
+	pending   float64
+	scheduled float64
+	// Most that can be sent per interval, unused for the rest
+	limit float64
+}
+
+// forecast simulates the backlog draining at throughput emails per minute,
+// with scheduled emails joining as they are released. The largest rate
+// limited domains can't drain faster than their limits, leaving the rest
+// of the throughput to other mail.
+func forecast(b queue.Backlog, throughput float64, rates func(string) (config.Rate, bool), now time.Time) *ForecastResponse {
+	scheduled := b.Scheduled()
+	f := &ForecastResponse{
+		GeneratedAt:         now,
+		Ready:               b.Ready,
+		Sending:             b.Sending,
+		Scheduled:           scheduled,
+		ThroughputPerMinute: throughput,
+	}
+	
+	lanes := forecastLanes(b, scheduled, rates)
+	capacity := throughput * forecastInterval.Minutes()
+	unreleased := float64(scheduled)
+	var laneScheduled float64
+	for _, l := range lanes {
+		laneScheduled += l.scheduled
+	}
+	outstanding := func() float64 {
+		total := unreleased
+		for _, l := range lanes {
+			total += l.pending
+		}
+		return total
+	}
+	
+	t := now
+	f.Points = append(f.Points, ForecastPoint{Time: t, Outstanding: b.Ready + scheduled})
+	for i := 0; t.Sub(now) < forecastHorizon; i++ {
+		// Releases due in this interval join their lanes
+		if i < len(b.Releases) && laneScheduled > 0 {
+			released := float64(b.Releases[i])
+			for _, l := range lanes {
+				l.pending += released * l.scheduled / laneScheduled
+			}
+			unreleased -= released
+		}
+		
+		before := outstanding()
+		if before < 0.5 {
+			drained := t
+			f.EstimatedDrainAt = &drained
+			break
+		}
+		sent, possible, limitedBy := drainInterval(lanes, capacity)
+		after := outstanding()
+		if limitedBy != "" {
+			f.LimitedBy = limitedBy
+		}
+		
+		if i < forecastPoints {
+			f.Points = append(f.Points, ForecastPoint{Time: t.Add(forecastInterval), Outstanding: int(after + 0.5)})
+		}
+		if after < 0.5 {
+			// Drained part way through the interval, or at its end if
+			// emails were still being released
+			drained := t.Add(forecastInterval)
+			if i >= len(b.Releases) || b.Releases[i] == 0 {
+				drained = t.Add(time.Duration(float64(forecastInterval) * sent / possible))
+			}
+			f.EstimatedDrainAt = &drained
+			break
+		}
+		if sent == 0 && i >= len(b.Releases) {
+			// Nothing is draining and nothing more is coming
+			break
+		}
+		t = t.Add(forecastInterval)
+	}
+	if f.EstimatedDrainAt == nil {
+		f.LimitedBy = ""
+	}
+	for len(f.Points) <= forecastPoints {
+		last := f.Points[len(f.Points)-1]
+		f.Points = append(f.Points, ForecastPoint{Time: last.Time.Add(forecastInterval), Outstanding: last.Outstanding})
+	}
+	return f
+}
+
+// forecastLanes splits the backlog into a lane for each of the largest
+// rate limited domains and one for the rest.
+func forecastLanes(b queue.Backlog, scheduled int, rates func(string) (config.Rate, bool)) []*forecastLane {
+	rest := &forecastLane{pending: float64(b.Ready), scheduled: float64(scheduled)}
+	if rates == nil {
+		return []*forecastLane{rest}
+	}
+	
+	domains := make([]string, 0, len(b.Domains))
+	for domain := range b.Domains {
+		domains = append(domains, domain)
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		di, dj := b.Domains[domains[i]], b.Domains[domains[j]]
+		if ni, nj := di.Ready+di.Scheduled, dj.Ready+dj.Scheduled; ni != nj {
+			return ni > nj
+		}
+		return domains[i] < domains[j]
+	})
+	
+	var lanes []*forecastLane
+	for _, domain := range domains {
+		if len(lanes) == forecastDomains {
+			break
+		}
+		rate, ok := rates(domain)
+		if !ok {
+			continue
+		}
+		d := b.Domains[domain]
+		lanes = append(lanes, &forecastLane{
+			domain:    domain,
+			pending:   float64(d.Ready),
+			scheduled: float64(d.Scheduled),
+			limit:     rate.PerSecond() * forecastInterval.Seconds(),
+		})
+		// The rest is what no modelled domain accounts for
+		rest.pending = max(rest.pending-float64(d.Ready), 0)
+		rest.scheduled = max(rest.scheduled-float64(d.Scheduled), 0)
+	}
+	return append(lanes, rest)
+}
+
+// drainInterval sends one interval's capacity from the lanes. Rate
+// limited lanes go first, each no more than its limit, and the rest gets
+// what capacity they leave. It returns how much was sent, how much could
+// have been at most, and the largest domain whose limit held it back.
+func drainInterval(lanes []*forecastLane, capacity float64) (sent, possible float64, limitedBy string) {
+	rest := lanes[len(lanes)-1]
+	limited := lanes[:len(lanes)-1]
+	
+	var want float64
+	wants := make([]float64, len(limited))
+	for i, l := range limited {
+		wants[i] = min(l.pending, l.limit)
+		if l.limit < l.pending && limitedBy == "" {
+			limitedBy = l.domain
+		}
+		if l.pending > 0 {
+			possible += l.limit
+		}
+		want += wants[i]
+	}
+	if rest.pending > 0 || possible > capacity {
+		possible = capacity
+	}
+	
+	// Short of capacity, the limited lanes share it
+	scale := 1.0
+	if want > capacity {
+		scale = capacity / want
+		limitedBy = ""
+	}
+	for i, l := range limited {
+		l.pending = max(l.pending-wants[i]*scale, 0)
+		sent += wants[i] * scale
+	}
+	restSent := min(rest.pending, capacity-sent)
+	rest.pending -= restSent
+	return sent + restSent, possible, limitedBy
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestForecast_SteadyState(t *testing.T) {
+	now := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	gmail := func(domain string) (config.Rate, bool) {
+		if domain == "gmail.com" {
+			return config.Rate{Count: 20, Per: time.Minute}, true
+		}
+		return config.Rate{}, false
+	}
+	steady := make([]int, forecastPoints)
+	for i := range steady {
+		steady[i] = 30
+	}
+	
+	tests := []struct {
+		name       string
+		backlog    queue.Backlog
+		throughput float64
+		rates      func(string) (config.Rate, bool)
+		wantDrain  time.Duration
+		wantAt10m  int
+		wantLimit  string
+	}{
+		{
+			name:       "ready only",
+			backlog:    queue.Backlog{Ready: 600},
+			throughput: 60,
+			wantDrain:  10 * time.Minute,
+			wantAt10m:  0,
+		},
+		{
+			// The ready backlog is gone after 10 minutes, then the
+			// releases drain as they come
+			name:       "steady releases",
+			backlog:    queue.Backlog{Ready: 300, Releases: steady},
+			throughput: 60,
+			wantDrain:  60 * time.Minute,
+			wantAt10m:  1500,
+		},
+		{
+			name: "one domain dominates",
+			backlog: queue.Backlog{Ready: 1000, Domains: map[string]queue.DomainBacklog{
+				"gmail.com":   {Ready: 600},
+				"example.com": {Ready: 400},
+			}},
+			throughput: 100,
+			rates:      gmail,
+			wantDrain:  30 * time.Minute,
+			wantAt10m:  400,
+			wantLimit:  "gmail.com",
+		},
+		{
+			name: "limit not reached",
+			backlog: queue.Backlog{Ready: 100, Domains: map[string]queue.DomainBacklog{
+				"gmail.com": {Ready: 10},
+			}},
+			throughput: 100,
+			rates:      gmail,
+			wantDrain:  time.Minute,
+		},
+		{
+			name:      "empty",
+			wantDrain: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := forecast(tt.backlog, tt.throughput, tt.rates, now)
+			if f.EstimatedDrainAt == nil {
+				t.Fatal("Expected a drain estimate")
+			}
+			if got := f.EstimatedDrainAt.Sub(now); got < tt.wantDrain-time.Second || got > tt.wantDrain+time.Second {
+				t.Errorf("Expected to drain in %s, got %s", tt.wantDrain, got)
+			}
+			if len(f.Points) != forecastPoints+1 || !f.Points[10].Time.Equal(now.Add(10*time.Minute)) {
+				t.Fatalf("Expected a point a minute for an hour, got %d", len(f.Points))
+			}
+			if got := f.Points[10].Outstanding; got != tt.wantAt10m {
+				t.Errorf("Expected %d outstanding after 10 minutes, got %d", tt.wantAt10m, got)
+			}
+			if f.LimitedBy != tt.wantLimit {
+				t.Errorf("Expected limited by %q, got %q", tt.wantLimit, f.LimitedBy)
+			}
+		})
+	}
+	
+	// Without throughput the backlog is not expected to drain
+	f := forecast(queue.Backlog{Ready: 10}, 0, nil, now)
+	if f.EstimatedDrainAt != nil || f.Points[forecastPoints].Outstanding != 10 {
+		t.Errorf("Expected no drain estimate, got %v, %+v", f.EstimatedDrainAt, f.Points[forecastPoints])
+	}
+}
+
+func TestAPI_GetForecast(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(100)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	
+	now := time.Now()
+	later := now.Add(30 * time.Minute)
+	for i, scheduledAt := range []*time.Time{nil, nil, nil, &later} {
+		e := &email.Email{ID: string(rune('a' + i)), From: "sender@test.com", To: []string{"rcpt@example.com"}, Subject: "Hi", Body: "Body", ScheduledAt: scheduledAt}
+		if err := q.Enqueue(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two emails a minute over the last five minutes
+	for m := 1; m <= forecastThroughputMinutes; m++ {
+		api.counters.series.Add(MetricDelivered, now.Add(-time.Duration(m)*time.Minute), 2)
+	}
+	
+	req := httptest.NewRequest("GET", "/stats/forecast", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	var resp ForecastResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Ready != 3 || resp.Scheduled != 1 || resp.ThroughputPerMinute != 2 {
+		t.Fatalf("Unexpected forecast %+v", resp)
+	}
+	// The ready emails go within two minutes, the scheduled one once it
+	// is released
+	if resp.Points[2].Outstanding != 1 || resp.EstimatedDrainAt == nil {
+		t.Fatalf("Expected one email left after two minutes, got %+v", resp.Points[:3])
+	}
+	if d := resp.EstimatedDrainAt.Sub(now); d < 29*time.Minute || d > 31*time.Minute {
+		t.Errorf("Expected to drain about half an hour from now, got %s", d)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+)
+
+// DeliveryPauser pauses and resumes all outbound delivery, normally the
+// delivery service.
+type DeliveryPauser interface {
+	Pause(reason string) delivery.Pause
+	Resume() bool
+	PauseState() delivery.Pause
+}
+
+// PauseDeliveryRequest is the optional body of a delivery pause.
+type PauseDeliveryRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// SetDeliveryPauser enables the /admin/delivery endpoints and reports
+// whether delivery is paused in /health and /stats.
+func (a *API) SetDeliveryPauser(p DeliveryPauser) {
+	a.deliveryPauser = p
+}
+
+// handleDelivery serves /admin/delivery, the state of the pause, and
+// /admin/delivery/pause and /resume.
+func (a *API) handleDelivery(w http.ResponseWriter, r *http.Request) {
+	if a.deliveryPauser == nil {
+		a.errorResponse(w, http.StatusNotImplemented, "delivery pausing is not enabled")
+		return
+	}
+	
+	op := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/delivery"), "/")
+	if op == "" {
+		if r.Method != http.MethodGet {
+			a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.deliveryPauser.PauseState())
+		return
+	}
+	
+	if op != "pause" && op != "resume" {
+		a.errorResponse(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	
+	var params map[string]string
+	var req PauseDeliveryRequest
+	if op == "pause" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			a.errorResponse(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+		if req.Reason != "" {
+			params = map[string]string{"reason": req.Reason}
+		}
+	}
+	
+	var resp delivery.Pause
+	_, err := a.audited(r, "delivery."+op, params, func() (int, error) {
+		if op == "pause" {
+			resp = a.deliveryPauser.Pause(req.Reason)
+		} else {
+			a.deliveryPauser.Resume()
+			resp = a.deliveryPauser.PauseState()
+		}
+		return a.queue.Size(), nil
+	})
+	if err != nil {
+		a.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
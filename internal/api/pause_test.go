@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+)
+
+func TestAPI_PauseDelivery(t *testing.T) {
+	q := queue.NewMemoryQueue(100)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	
+	if w := do("POST", "/admin/delivery/pause", ""); w.Code != http.StatusNotImplemented {
+		t.Fatalf("Expected 501 without a pauser, got %d", w.Code)
+	}
+	service := delivery.NewService(&config.DeliveryConfig{Workers: 1}, q)
+	api.SetDeliveryPauser(service)
+	
+	w := do("POST", "/admin/delivery/pause", `{"reason": "bad campaign"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var pause delivery.Pause
+	json.NewDecoder(w.Body).Decode(&pause)
+	if !pause.Paused || pause.Reason != "bad campaign" || pause.PausedAt == nil {
+		t.Errorf("Unexpected pause %+v", pause)
+	}
+	if !service.PauseState().Paused {
+		t.Error("Expected the service paused")
+	}
+	
+	var health HealthResponse
+	json.NewDecoder(do("GET", "/health", "").Body).Decode(&health)
+	if health.DeliveryPaused == nil || health.DeliveryPaused.Reason != "bad campaign" {
+		t.Errorf("Expected /health to report the pause, got %+v", health.DeliveryPaused)
+	}
+	var stats StatsResponse
+	json.NewDecoder(do("GET", "/stats", "").Body).Decode(&stats)
+	if !stats.DeliveryPaused {
+		t.Error("Expected /stats to report delivery paused")
+	}
+	
+	if w := do("GET", "/admin/delivery/pause", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", w.Code)
+	}
+	if w := do("POST", "/admin/delivery/stop", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown action, got %d", w.Code)
+	}
+	
+	w = do("POST", "/admin/delivery/resume", "")
+	json.NewDecoder(w.Body).Decode(&pause)
+	if w.Code != http.StatusOK || pause.Paused {
+		t.Fatalf("Expected delivery resumed, got %d %+v", w.Code, pause)
+	}
+	
+	health = HealthResponse{}
+	json.NewDecoder(do("GET", "/health", "").Body).Decode(&health)
+	if health.DeliveryPaused != nil {
+		t.Errorf("Expected no pause in /health after resuming, got %+v", health.DeliveryPaused)
+	}
+	json.NewDecoder(do("GET", "/admin/delivery", "").Body).Decode(&pause)
+	if pause.Paused {
+		t.Errorf("Expected GET /admin/delivery to report delivery running, got %+v", pause)
+	}
+}
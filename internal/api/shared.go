@@ -0,0 +1,125 @@
+package api
+
+import (
+	"crypto/sha256"
+	"sync"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// sharedContent lets queued emails hold a single copy of the content they
+// have in common, such as the same HTML body sent to thousands of
+// recipients, instead of one copy each. Copies are kept by content, with a
+// count of the emails using each, and dropped when that count reaches
+// zero as the emails finish.
+//
+// Strings are immutable, so emails sharing a body cannot see each other's
+// changes: replacing one email's body leaves its siblings alone, and
+// every code path reads the fields as before. Attachment and raw data are
+// already treated as read-only and shared between clones.
+type sharedContent struct {
+	mu   sync.Mutex
+	text map[string]*sharedText
+	data map[[sha256.Size]byte]*sharedData
+	
+	// What each email holds, so it can be released by ID
+	held map[string]heldContent
+}
+
+type sharedText struct {
+	text string
+	refs int
+}
+
+type sharedData struct {
+	data []byte
+	refs int
+}
+
+type heldContent struct {
+	text []string
+	data [][sha256.Size]byte
+}
+
+func newSharedContent() *sharedContent {
+	return &sharedContent{
+		text: make(map[string]*sharedText),
+		data: make(map[[sha256.Size]byte]*sharedData),
+		held: make(map[string]heldContent),
+	}
+}
+
+// share points e's body, HTML and attachment data at copies already held
+// for other emails, adding any not held yet, until e is released.
+func (s *sharedContent) share(e *email.Email) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	var h heldContent
+	e.Body = s.shareText(&h, e.Body)
+	e.HTML = s.shareText(&h, e.HTML)
+	for i := range e.Attachments {
+		e.Attachments[i].Data = s.shareData(&h, e.Attachments[i].Data)
+	}
+	if len(h.text) > 0 || len(h.data) > 0 {
+		s.held[e.ID] = h
+	}
+}
+
+// release drops the email id's hold on its content, freeing whatever no
+// other email uses. Releasing an email twice, or one never shared, does
+// nothing.
+func (s *sharedContent) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	h, ok := s.held[id]
+	if !ok {
+		return
+	}
+	delete(s.held, id)
+	for _, text := range h.text {
+		t := s.text[text]
+		t.refs--
+		if t.refs == 0 {
+			delete(s.text, text)
+		}
+	}
+	for _, sum := range h.data {
+		d := s.data[sum]
+		d.refs--
+		if d.refs == 0 {
+			delete(s.data, sum)
+		}
+	}
+}
+
+func (s *sharedContent) shareText(h *heldContent, text string) string {
+	if text == "" {
+		return text
+	}
+	t, ok := s.text[text]
+	if !ok {
+		t = &sharedText{text: text}
+		s.text[text] = t
+	}
+	t.refs++
+	h.text = append(h.text, t.text)
+	return t.text
+}
+
+func (s *sharedContent) shareData(h *heldContent, data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	sum := sha256.Sum256(data)
+	d, ok := s.data[sum]
+	if !ok {
+		// Capped so an append to one email's copy cannot write into another's
+		d = &sharedData{data: data[:len(data):len(data)]}
+		s.data[sum] = d
+	}
+	d.refs++
+	h.data = append(h.data, sum)
+	return d.data
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestAPI_SendBatchSharesContent(t *testing.T) {
+	cfg := &config.APIConfig{AuthToken: "test-token"}
+	q := &mockQueue{}
+	api := New(cfg, q, 25*1024*1024)
+	api.SetLimits(&config.LimitsConfig{MaxBatchSize: 1000}, 0)
+	
+	const copies = 200
+	html := "<p>" + strings.Repeat("Big sale! ", 10*1024) + "</p>"
+	attachment := bytes.Repeat([]byte("%PDF"), 16*1024)
+	requests := make([]SendEmailRequest, copies)
+	for i := range requests {
+		requests[i] = SendEmailRequest{
+			From:        "sender@example.com",
+			To:          []string{fmt.Sprintf("user%d@example.com", i)},
+			Subject:     "Sale",
+			Body:        "Big sale!",
+			HTML:        html,
+			Attachments: []AttachmentRequest{{Filename: "flyer.pdf", Data: attachment}},
+		}
+	}
+	body, _ := json.Marshal(requests)
+	requests = nil
+	
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	
+	req := httptest.NewRequest("POST", "/send/batch", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	body = nil
+	if w.Code != http.StatusAccepted || len(q.emails) != copies {
+		t.Fatalf("Expected %d emails queued, got %d with status %d: %s", copies, len(q.emails), w.Code, w.Body)
+	}
+	
+	var after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	
+	// Every email refers to the first one's content
+	first := q.emails[0]
+	for _, e := range q.emails[1:] {
+		if e.HTML != html || unsafe.StringData(e.HTML) != unsafe.StringData(first.HTML) {
+			t.Fatal("Expected the HTML body to be shared")
+		}
+		if &e.Attachments[0].Data[0] != &first.Attachments[0].Data[0] {
+			t.Fatal("Expected the attachment data to be shared")
+		}
+	}
+	
+	// The queued emails hold about one copy, not one each
+	perCopy := uint64(len(html) + len(attachment))
+	if grown := int64(after.HeapAlloc) - int64(before.HeapAlloc); grown > int64(10*perCopy) {
+		t.Errorf("Expected about one copy (%d bytes) retained, heap grew by %d", perCopy, grown)
+	}
+	
+	// Changing one email's content leaves the others alone
+	first.HTML = "<p>Sold out</p>"
+	first.Attachments[0].Data = append(first.Attachments[0].Data, "%%EOF"...)
+	second := q.emails[1]
+	if second.HTML != html || !bytes.Equal(second.Attachments[0].Data, attachment) {
+		t.Error("Expected sibling emails unaffected by a change to one")
+	}
+	runtime.KeepAlive(q)
+}
+
+func TestAPI_SharedContentReleased(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewTracker()
+	q := queue.NewMemoryQueue(10, tracker)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	api.SetTracker(tracker)
+	
+	html := "<p>" + strings.Repeat("Big sale! ", 1024) + "</p>"
+	send := func() []SendEmailResponse {
+		requests := make([]SendEmailRequest, 2)
+		for i := range requests {
+			requests[i] = SendEmailRequest{
+				From:    "sender@example.com",
+				To:      []string{fmt.Sprintf("user%d@example.com", i)},
+				Subject: "Sale",
+				Body:    "Big sale!",
+				HTML:    html,
+			}
+		}
+		body, _ := json.Marshal(requests)
+		req := httptest.NewRequest("POST", "/send/batch", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		var responses []SendEmailResponse
+		json.NewDecoder(w.Body).Decode(&responses)
+		return responses
+	}
+	refs := func() int {
+		api.shared.mu.Lock()
+		defer api.shared.mu.Unlock()
+		if t := api.shared.text[html]; t != nil {
+			return t.refs
+		}
+		return 0
+	}
+	
+	// A second batch shares the first one's copy
+	sent := append(send(), send()...)
+	if refs() != 4 {
+		t.Fatalf("Expected 4 emails holding the HTML body, got %d", refs())
+	}
+	
+	// Delivered and cancelled emails let go of it
+	q.Dequeue(ctx, 1)
+	q.MarkDelivered(ctx, sent[0].ID)
+	api.DeliveryResult(delivery.Result{ID: sent[0].ID, Status: email.StatusDelivered, At: time.Now()})
+	q.Cancel(ctx, sent[1].ID, "")
+	q.Cancel(ctx, sent[2].ID, "")
+	if refs() != 1 {
+		t.Fatalf("Expected 1 email holding the HTML body, got %d", refs())
+	}
+	
+	// And the copy is freed with the last one
+	q.Reject(ctx, sent[3].ID, "test", "done")
+	if refs() != 0 || len(api.shared.text) != 0 || len(api.shared.held) != 0 {
+		t.Errorf("Expected the shared content freed, %d texts and %d emails left", len(api.shared.text), len(api.shared.held))
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// StatusCache is a read-through cache in front of the queue for status
+// lookups, so clients polling GET /status do not each cost a queue read.
+// It is a queue.Listener: pass it to the queue at construction and install
+// it with SetStatusCache. An email's entry is dropped on each queue event
+// for it, which the queue reports before the call that made the change
+// returns, and expires after ttl in case a change went unreported. At most
+// size emails are cached; the least recently used is evicted first.
+type StatusCache struct {
+	size int
+	ttl  time.Duration
+	
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *statusEntry, most recently used first
+	
+	// Lookups in progress, so one that raced with an invalidation does not
+	// cache what it read
+	flights map[string]*flight
+	
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type statusEntry struct {
+	id      string
+	email   *email.Email
+	expires time.Time
+}
+
+type flight struct {
+	lookups int
+	epoch   int
+}
+
+// StatusCacheStats reports how well the status cache is doing.
+type StatusCacheStats struct {
+	Entries int     `json:"entries"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+func NewStatusCache(size int, ttl time.Duration) *StatusCache {
+	return &StatusCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		flights: make(map[string]*flight),
+	}
+}
+
+// Get returns the email from the cache, or looks it up in g and caches it.
+// The email returned is shared with other callers and must not be
+// modified.
+func (c *StatusCache) Get(ctx context.Context, g queue.Getter, id string) (*email.Email, error) {
+	if e := c.lookup(id); e != nil {
+		c.hits.Add(1)
+		return e, nil
+	}
+	c.misses.Add(1)
+	
+	epoch := c.takeOff(id)
+	e, err := g.Get(ctx, id)
+	c.land(id, epoch, e, err)
+	return e, err
+}
+
+// Invalidate drops id's entry, and stops lookups already under way from
+// caching what they read.
+func (c *StatusCache) Invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	if elem, ok := c.entries[id]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, id)
+	}
+	if f, ok := c.flights[id]; ok {
+		f.epoch++
+	}
+}
+
+// Stats reports the cache's size and hit rate.
+func (c *StatusCache) Stats() StatusCacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	
+	stats := StatusCacheStats{
+		Entries: entries,
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+func (c *StatusCache) OnEnqueued(e *email.Email) { c.Invalidate(e.ID) }
+
+func (c *StatusCache) OnDequeued(e *email.Email) { c.Invalidate(e.ID) }
+
+func (c *StatusCache) OnDelivered(id string, duration time.Duration) { c.Invalidate(id) }
+
+func (c *StatusCache) OnFailed(id string, reason string, willRetry bool) { c.Invalidate(id) }
+
+func (c *StatusCache) OnRemoved(e *email.Email) { c.Invalidate(e.ID) }
+
+// lookup returns id's unexpired entry, or nil.
+func (c *StatusCache) lookup(id string) *email.Email {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*statusEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, id)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return entry.email
+}
+
+// takeOff registers a lookup of id, returning the invalidation epoch it
+// started in.
+func (c *StatusCache) takeOff(id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	f, ok := c.flights[id]
+	if !ok {
+		f = &flight{}
+		c.flights[id] = f
+	}
+	f.lookups++
+	return f.epoch
+}
+
+// land ends a lookup of id, caching its result unless id was invalidated
+// while it ran.
+func (c *StatusCache) land(id string, epoch int, e *email.Email, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	f := c.flights[id]
+	if f.lookups--; f.lookups == 0 {
+		delete(c.flights, id)
+	}
+	if err != nil || f.epoch != epoch || c.size <= 0 {
+		return
+	}
+	
+	entry := &statusEntry{id: id, email: e, expires: time.Now().Add(c.ttl)}
+	if elem, ok := c.entries[id]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[id] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*statusEntry).id)
+	}
+}
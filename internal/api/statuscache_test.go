@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// countingQueue counts Get calls, optionally making each one slow like a
+// read from persistent storage.
+type countingQueue struct {
+	*queue.MemoryQueue
+	gets  atomic.Int64
+	delay time.Duration
+}
+
+func (q *countingQueue) Get(ctx context.Context, id string) (*email.Email, error) {
+	q.gets.Add(1)
+	if q.delay > 0 {
+		time.Sleep(q.delay)
+	}
+	return q.MemoryQueue.Get(ctx, id)
+}
+
+// getterFunc adapts a function to queue.Getter.
+type getterFunc func(ctx context.Context, id string) (*email.Email, error)
+
+func (f getterFunc) Get(ctx context.Context, id string) (*email.Email, error) {
+	return f(ctx, id)
+}
+
+func TestStatusCache_ServesRepeatedLookups(t *testing.T) {
+	ctx := context.Background()
+	cache := NewStatusCache(10, time.Minute)
+	q := &countingQueue{MemoryQueue: queue.NewMemoryQueue(10, cache)}
+	q.Enqueue(ctx, &email.Email{ID: "a", From: "s@test.com", To: []string{"r@test.com"}, Status: email.StatusQueued})
+	
+	for i := 0; i < 5; i++ {
+		e, err := cache.Get(ctx, q, "a")
+		if err != nil || e.Status != email.StatusQueued {
+			t.Fatalf("Expected queued email, got %v, %v", e, err)
+		}
+	}
+	if got := q.gets.Load(); got != 1 {
+		t.Errorf("Expected 1 queue read, got %d", got)
+	}
+	
+	// Dequeuing is a status change the cache hears about
+	q.Dequeue(ctx, 1)
+	e, _ := cache.Get(ctx, q, "a")
+	if e.Status != email.StatusSending {
+		t.Errorf("Expected sending after dequeue, got %s", e.Status)
+	}
+	
+	stats := cache.Stats()
+	if stats.Hits != 4 || stats.Misses != 2 || stats.HitRate != 4.0/6 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestStatusCache_ExpiresAndEvicts(t *testing.T) {
+	ctx := context.Background()
+	var gets atomic.Int64
+	g := getterFunc(func(ctx context.Context, id string) (*email.Email, error) {
+		gets.Add(1)
+		return &email.Email{ID: id, Status: email.StatusQueued}, nil
+	})
+	
+	cache := NewStatusCache(2, 20*time.Millisecond)
+	cache.Get(ctx, g, "a")
+	cache.Get(ctx, g, "b")
+	cache.Get(ctx, g, "a")
+	cache.Get(ctx, g, "c") // evicts b, the least recently used
+	if stats := cache.Stats(); stats.Entries != 2 {
+		t.Errorf("Expected 2 entries, got %d", stats.Entries)
+	}
+	
+	gets.Store(0)
+	cache.Get(ctx, g, "a")
+	cache.Get(ctx, g, "b")
+	if got := gets.Load(); got != 1 {
+		t.Errorf("Expected only the evicted email to be read, got %d reads", got)
+	}
+	
+	time.Sleep(30 * time.Millisecond)
+	gets.Store(0)
+	cache.Get(ctx, g, "b")
+	if got := gets.Load(); got != 1 {
+		t.Errorf("Expected an expired entry to be read again, got %d reads", got)
+	}
+}
+
+func TestStatusCache_InvalidationDuringLookup(t *testing.T) {
+	ctx := context.Background()
+	cache := NewStatusCache(10, time.Minute)
+	
+	status := email.StatusSending
+	read, resume := make(chan struct{}), make(chan struct{})
+	g := getterFunc(func(ctx context.Context, id string) (*email.Email, error) {
+		e := &email.Email{ID: id, Status: status}
+		if read != nil {
+			close(read)
+			<-resume
+		}
+		return e, nil
+	})
+	
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.Get(ctx, g, "a")
+	}()
+	
+	// The email is delivered after the lookup read it but before it
+	// finished
+	<-read
+	status = email.StatusDelivered
+	cache.OnDelivered("a", time.Second)
+	close(resume)
+	<-done
+	
+	read = nil
+	e, _ := cache.Get(ctx, g, "a")
+	if e.Status != email.StatusDelivered {
+		t.Errorf("Expected delivered, got stale %s", e.Status)
+	}
+}
+
+func TestAPI_StatusThroughCache(t *testing.T) {
+	cache := NewStatusCache(10, time.Minute)
+	q := &countingQueue{MemoryQueue: queue.NewMemoryQueue(10, cache)}
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 1024*1024)
+	api.SetStatusCache(cache)
+	
+	e := &email.Email{ID: "cached-1", From: "s@test.com", To: []string{"r@test.com"}, Status: email.StatusQueued}
+	q.Enqueue(context.Background(), e)
+	api.track(e.Clone())
+	
+	status := func() StatusResponse {
+		req := httptest.NewRequest("GET", "/status/cached-1", nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		var resp StatusResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+	
+	for i := 0; i < 3; i++ {
+		if got := status().Status; got != string(email.StatusQueued) {
+			t.Fatalf("Expected queued, got %s", got)
+		}
+	}
+	if got := q.gets.Load(); got != 1 {
+		t.Errorf("Expected 1 queue read for 3 polls, got %d", got)
+	}
+	
+	quarantine := httptest.NewRequest("POST", "/admin/quarantine/cached-1", nil)
+	quarantine.Header.Set("Authorization", "Bearer test-token")
+	api.ServeHTTP(httptest.NewRecorder(), quarantine)
+	if got := status().Status; got != string(email.StatusQuarantined) {
+		t.Errorf("Expected quarantined after the admin action, got %s", got)
+	}
+	
+	req := httptest.NewRequest("GET", "/stats", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	var stats StatsResponse
+	json.NewDecoder(w.Body).Decode(&stats)
+	if stats.StatusCache == nil || stats.StatusCache.Hits != 2 {
+		t.Errorf("Expected 2 cache hits in stats, got %+v", stats.StatusCache)
+	}
+}
+
+// BenchmarkStatusPolling polls GET /status for 100 emails from 10k
+// concurrent clients against a queue whose reads take 200µs, and reports
+// the p99 latency with and without the status cache.
+func BenchmarkStatusPolling(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			var listeners []queue.Listener
+			cache := NewStatusCache(1000, time.Minute)
+			if cached {
+				listeners = append(listeners, cache)
+			}
+			q := &countingQueue{MemoryQueue: queue.NewMemoryQueue(1000, listeners...), delay: 200 * time.Microsecond}
+			api := New(&config.APIConfig{AuthToken: "test-token"}, q, 1024*1024)
+			if cached {
+				api.SetStatusCache(cache)
+			}
+			
+			var ids []string
+			for i := 0; i < 100; i++ {
+				e := &email.Email{ID: fmt.Sprintf("bench-%d", i), From: "s@test.com", To: []string{"r@test.com"}, Status: email.StatusQueued}
+				q.Enqueue(context.Background(), e)
+				api.track(e.Clone())
+				ids = append(ids, e.ID)
+			}
+			
+			var mu sync.Mutex
+			var latencies []time.Duration
+			var next atomic.Int64
+			b.SetParallelism(max(1, 10000/runtime.GOMAXPROCS(0)))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var local []time.Duration
+				for pb.Next() {
+					id := ids[next.Add(1)%int64(len(ids))]
+					req := httptest.NewRequest("GET", "/status/"+id, nil)
+					req.Header.Set("Authorization", "Bearer test-token")
+					start := time.Now()
+					api.ServeHTTP(httptest.NewRecorder(), req)
+					local = append(local, time.Since(start))
+				}
+				mu.Lock()
+				latencies = append(latencies, local...)
+				mu.Unlock()
+			})
+			b.StopTimer()
+			
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			if len(latencies) > 0 {
+				p99 := latencies[len(latencies)*99/100]
+				b.ReportMetric(float64(p99.Microseconds()), "p99-µs")
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	
+	"github.com/google/uuid"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// testEmailPriority puts test emails ahead of ordinary mail.
+const testEmailPriority = 100
+
+// TestEmailRequest asks for a diagnostic email to be sent to To.
+type TestEmailRequest struct {
+	To string `json:"to"`
+}
+
+// TestEmailResponse reports how delivery of a test email went. Status is
+// the email's status after its first attempt, or "pending" if no result
+// arrived in time; its progress can then be followed at /status.
+type TestEmailResponse struct {
+	ID     string `json:"id"`
+	To     string `json:"to"`
+	Status string `json:"status"`
+	
+	// The MX host that accepted the email, and whether TLS was used
+	DeliveredTo string `json:"delivered_to,omitempty"`
+	TLS         bool   `json:"tls"`
+	
+	// Set if the attempt failed
+	Error   string         `json:"error,omitempty"`
+	Failure *email.Failure `json:"failure,omitempty"`
+	
+	Transactions   []delivery.Transaction `json:"transactions,omitempty"`
+	Log            []string               `json:"log,omitempty"`
+	ElapsedSeconds float64                `json:"elapsed_seconds"`
+}
+
+// SetDiagnostics sets what the test email reports about this deployment:
+// its version, and settings worth checking such as whether DKIM signing
+// and SMTP TLS are enabled.
+func (a *API) SetDiagnostics(version string, settings map[string]string) {
+	a.version = version
+	a.settings = settings
+}
+
+// handleTestEmail serves the test email form to GET, which needs no
+// token, and sends a test email on an authenticated POST.
+func (a *API) handleTestEmail(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, testEmailForm)
+	case http.MethodPost:
+		a.requireAdmin(a.sendTestEmail)(w, r)
+	default:
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// sendTestEmail queues a diagnostic email to the requested recipient and
+// waits for the result of its first delivery attempt.
+func (a *API) sendTestEmail(w http.ResponseWriter, r *http.Request) {
+	var req TestEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	
+	e := a.testEmail(strings.TrimSpace(req.To))
+	if err := e.Validate(a.maxMessageSize); err != nil {
+		a.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	
+	// Register for the result before queueing so it cannot be missed
+	results := make(chan delivery.Result, 1)
+	a.waiters.Store(e.ID, results)
+	defer a.waiters.Delete(e.ID)
+	
+	start := time.Now()
+	_, err := a.audited(r, "test_email", map[string]string{"to": e.To[0]}, func() (int, error) {
+		if err := a.queue.Enqueue(r.Context(), e); err != nil {
+			return 0, err
+		}
+		a.track(e)
+		return 1, nil
+	})
+	if err != nil {
+		a.errorResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("failed to queue test email: %v", err))
+		return
+	}
+	
+	resp := TestEmailResponse{ID: e.ID, To: e.To[0], Status: "pending"}
+	timeout := time.NewTimer(a.config.TestEmail.Timeout)
+	defer timeout.Stop()
+	
+	select {
+	case <-r.Context().Done():
+		return
+	case <-timeout.C:
+	case result := <-results:
+		resp.Status = string(result.Status)
+		resp.Transactions = result.Transactions
+		resp.Log = result.Log
+		resp.Failure = result.Failure
+		if result.Err != nil {
+			resp.Error = result.Err.Error()
+		}
+		for _, txn := range result.Transactions {
+			if txn.Error == "" {
+				resp.DeliveredTo, resp.TLS = txn.Host, txn.TLS
+			}
+		}
+	}
+	resp.ElapsedSeconds = time.Since(start).Seconds()
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// testEmail composes the diagnostic message sent to to.
+func (a *API) testEmail(to string) *email.Email {
+	hostname := a.config.Identity.Hostname()
+	from := a.config.TestEmail.From
+	if from == "" {
+		from = "postmaster@" + hostname
+	}
+	
+	now := time.Now()
+	id := uuid.New().String()
+	version := a.version
+	if version == "" {
+		version = "unknown"
+	}
+	apiTLS := "disabled"
+	if a.config.TLS.Enabled {
+		apiTLS = "enabled"
+	}
+	
+	var body strings.Builder
+	fmt.Fprintf(&body, "This is a test email from %s, sent to check delivery end to end.\n\n", hostname)
+	fmt.Fprintf(&body, "Hostname: %s\n", hostname)
+	fmt.Fprintf(&body, "Version: %s\n", version)
+	fmt.Fprintf(&body, "Email ID: %s\n", id)
+	fmt.Fprintf(&body, "Sent at: %s\n", now.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&body, "API TLS: %s\n", apiTLS)
+	fmt.Fprintf(&body, "Queue size: %d\n", a.queue.Size())
+	for _, problem := range a.config.Identity.Problems() {
+		fmt.Fprintf(&body, "Hostname problem: %s\n", problem)
+	}
+	
+	names := make([]string, 0, len(a.settings))
+	for name := range a.settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&body, "%s: %s\n", name, a.settings[name])
+	}
+	
+	var rcpts []string
+	if to != "" {
+		rcpts = []string{to}
+	}
+	return &email.Email{
+		ID:             id,
+		From:           from,
+		To:             rcpts,
+		Subject:        "Test email from " + hostname,
+		Body:           body.String(),
+		Status:         email.StatusQueued,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		AllowDuplicate: true,
+		Diagnostic:     true,
+		Lane:           email.LaneTransactional,
+		Priority:       testEmailPriority,
+	}
+}
+
+// notifyWaiter hands r to a test email request waiting for it.
+func (a *API) notifyWaiter(r delivery.Result) {
+	if ch, ok := a.waiters.LoadAndDelete(r.ID); ok {
+		ch.(chan delivery.Result) <- r
+	}
+}
+
+const testEmailForm = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Send a test email</title></head>
+<body>
+<h1>Send a test email</h1>
+<form id="test-email">
+<p><label>Recipient <input name="to" type="email" required></label></p>
+<p><label>API token <input name="token" type="password" required></label></p>
+<p><button type="submit">Send</button></p>
+</form>
+<pre id="report"></pre>
+<script>
+document.getElementById("test-email").addEventListener("submit", async (event) => {
+	event.preventDefault();
+	const form = event.target;
+	const report = document.getElementById("report");
+	report.textContent = "Sending...";
+	const resp = await fetch("/admin/test-email", {
+		method: "POST",
+		headers: {"Authorization": "Bearer " + form.token.value, "Content-Type": "application/json"},
+		body: JSON.stringify({to: form.to.value}),
+	});
+	report.textContent = JSON.stringify(await resp.json(), null, 2);
+});
+</script>
+</body>
+</html>
+`
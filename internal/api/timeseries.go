@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics kept as time series. Failed counts emails that failed for good,
+// bounced ones included; deferred counts attempts that will be retried.
+const (
+	MetricAccepted  = "accepted"
+	MetricDelivered = "delivered"
+	MetricFailed    = "failed"
+	MetricDeferred  = "deferred"
+	MetricBounced   = "bounced"
+)
+
+// Series resolutions and how many buckets of each are kept
+const (
+	ResolutionMinute = "minute"
+	ResolutionHour   = "hour"
+	ResolutionDay    = "day"
+)
+
+var resolutions = map[string]struct {
+	width   time.Duration
+	buckets int
+}{
+	ResolutionMinute: {time.Minute, 60},
+	ResolutionHour:   {time.Hour, 48},
+	ResolutionDay:    {24 * time.Hour, 30},
+}
+
+var metrics = []string{MetricAccepted, MetricDelivered, MetricFailed, MetricDeferred, MetricBounced}
+
+// TimeSeries counts events per minute for the last hour, per hour for the
+// last 48 hours and per day for the last 30 days. Buckets are rings
+// indexed by time, so old buckets roll over on their own as new ones are
+// written; recording is a couple of atomic operations.
+type TimeSeries struct {
+	series map[string]map[string]*ring
+}
+
+// NewTimeSeries returns empty series for every metric.
+func NewTimeSeries() *TimeSeries {
+	ts := &TimeSeries{series: make(map[string]map[string]*ring, len(metrics))}
+	for _, metric := range metrics {
+		rings := make(map[string]*ring, len(resolutions))
+		for name, res := range resolutions {
+			rings[name] = newRing(res.width, res.buckets)
+		}
+		ts.series[metric] = rings
+	}
+	return ts
+}
+
+// Add counts n events of metric at t. Unknown metrics are ignored.
+func (ts *TimeSeries) Add(metric string, t time.Time, n int64) {
+	for _, r := range ts.series[metric] {
+		r.add(t, n)
+	}
+}
+
+// Point is one bucket of a time series.
+type Point struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// Points returns metric's buckets at resolution up to and including the
+// one containing now, oldest first. It returns false for an unknown
+// metric or resolution.
+func (ts *TimeSeries) Points(metric, resolution string, now time.Time) ([]Point, bool) {
+	r, ok := ts.series[metric][resolution]
+	if !ok {
+		return nil, false
+	}
+	return r.points(now), true
+}
+
+// rolling marks a bucket being reset for a new window
+const rolling = -1
+
+type ring struct {
+	width   time.Duration
+	buckets []bucket
+}
+
+type bucket struct {
+	// Index of the window the count belongs to, counted in widths since
+	// the Unix epoch
+	window atomic.Int64
+	count  atomic.Int64
+}
+
+func newRing(width time.Duration, n int) *ring {
+	return &ring{width: width, buckets: make([]bucket, n)}
+}
+
+func (r *ring) window(t time.Time) int64 {
+	return t.UnixNano() / int64(r.width)
+}
+
+func (r *ring) add(t time.Time, n int64) {
+	w := r.window(t)
+	b := &r.buckets[w%int64(len(r.buckets))]
+	for {
+		current := b.window.Load()
+		switch {
+		case current == w:
+			b.count.Add(n)
+			return
+		case current == rolling:
+			runtime.Gosched()
+		case current > w:
+			// Too old for the ring
+			return
+		case b.window.CompareAndSwap(current, rolling):
+			b.count.Store(0)
+			b.window.Store(w)
+		}
+	}
+}
+
+func (r *ring) points(now time.Time) []Point {
+	last := r.window(now)
+	points := make([]Point, len(r.buckets))
+	for i := range points {
+		w := last - int64(len(points)-1-i)
+		points[i].Start = time.Unix(0, w*int64(r.width)).UTC()
+		if w < 0 {
+			continue
+		}
+		b := &r.buckets[w%int64(len(r.buckets))]
+		if b.window.Load() == w {
+			points[i].Count = b.count.Load()
+		}
+	}
+	return points
+}
+
+// TimeSeriesResponse is the response from /stats/timeseries.
+type TimeSeriesResponse struct {
+	Metric        string  `json:"metric"`
+	Resolution    string  `json:"resolution"`
+	BucketSeconds float64 `json:"bucket_seconds"`
+	Points        []Point `json:"points"`
+}
+
+// handleGetTimeSeries serves one metric's series, such as
+// /stats/timeseries?metric=failed&resolution=hour. The resolution
+// defaults to minute.
+func (a *API) handleGetTimeSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	
+	metric := r.URL.Query().Get("metric")
+	resolution := r.URL.Query().Get("resolution")
+	if resolution == "" {
+		resolution = ResolutionMinute
+	}
+	points, ok := a.counters.series.Points(metric, resolution, time.Now())
+	if !ok {
+		a.errorResponse(w, http.StatusBadRequest, "metric must be one of accepted, delivered, failed, deferred, bounced and resolution one of minute, hour, day")
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TimeSeriesResponse{
+		Metric:        metric,
+		Resolution:    resolution,
+		BucketSeconds: resolutions[resolution].width.Seconds(),
+		Points:        points,
+	})
+}
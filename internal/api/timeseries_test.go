@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestTimeSeries_Rollover(t *testing.T) {
+	ts := NewTimeSeries()
+	start := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	
+	ts.Add(MetricFailed, start, 2)
+	ts.Add(MetricFailed, start.Add(30*time.Second), 1)
+	ts.Add(MetricFailed, start.Add(time.Minute), 4)
+	
+	points, ok := ts.Points(MetricFailed, ResolutionMinute, start.Add(time.Minute))
+	if !ok || len(points) != 60 {
+		t.Fatalf("Expected 60 minute buckets, got %d", len(points))
+	}
+	last, prev := points[59], points[58]
+	if !last.Start.Equal(start.Add(time.Minute)) || last.Count != 4 || prev.Count != 3 {
+		t.Fatalf("Expected 3 then 4, got %+v %+v", prev, last)
+	}
+	
+	// An hour later the minute buckets have rolled over, reusing the slot
+	later := start.Add(time.Hour)
+	ts.Add(MetricFailed, later, 1)
+	points, _ = ts.Points(MetricFailed, ResolutionMinute, later)
+	var total int64
+	for _, p := range points {
+		total += p.Count
+	}
+	if total != 5 || points[59].Count != 1 {
+		t.Errorf("Expected the first minute rolled over, got total %d, last %d", total, points[59].Count)
+	}
+	
+	// The hourly and daily series still hold everything
+	hours, _ := ts.Points(MetricFailed, ResolutionHour, later)
+	if len(hours) != 48 || hours[46].Count != 7 || hours[47].Count != 1 {
+		t.Errorf("Expected 7 then 1 per hour, got %+v %+v", hours[46], hours[47])
+	}
+	days, _ := ts.Points(MetricFailed, ResolutionDay, later)
+	if len(days) != 30 || days[29].Count != 8 {
+		t.Errorf("Expected 8 today, got %+v", days[29])
+	}
+	
+	// Other metrics are separate
+	if points, _ := ts.Points(MetricDelivered, ResolutionDay, later); points[29].Count != 0 {
+		t.Errorf("Expected no deliveries, got %d", points[29].Count)
+	}
+	if _, ok := ts.Points("opened", ResolutionHour, later); ok {
+		t.Error("Expected an unknown metric to be refused")
+	}
+}
+
+func TestTimeSeries_ConcurrentAdd(t *testing.T) {
+	ts := NewTimeSeries()
+	now := time.Now()
+	
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				ts.Add(MetricAccepted, now, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	
+	points, _ := ts.Points(MetricAccepted, ResolutionMinute, now)
+	if got := points[59].Count; got != 8000 {
+		t.Errorf("Expected 8000, got %d", got)
+	}
+}
+
+func TestAPI_GetTimeSeries(t *testing.T) {
+	cfg := &config.APIConfig{AuthToken: "test-token"}
+	api := New(cfg, &mockQueue{}, 25*1024*1024)
+	
+	now := time.Now()
+	api.DeliveryResult(delivery.Result{ID: "a", Status: email.StatusDelivered, At: now})
+	api.DeliveryResult(delivery.Result{ID: "b", Status: email.StatusBounced, At: now, Err: errors.New("550 no such user")})
+	api.DeliveryResult(delivery.Result{ID: "c", Status: email.StatusQueued, At: now, Err: errors.New("451 try later")})
+	
+	get := func(query string) (*httptest.ResponseRecorder, TimeSeriesResponse) {
+		req := httptest.NewRequest("GET", "/stats/timeseries?"+query, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		var resp TimeSeriesResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+	
+	for metric, want := range map[string]int64{
+		MetricDelivered: 1,
+		MetricFailed:    1,
+		MetricBounced:   1,
+		MetricDeferred:  1,
+	} {
+		w, resp := get("metric=" + metric + "&resolution=hour")
+		if w.Code != http.StatusOK || resp.BucketSeconds != 3600 || len(resp.Points) != 48 {
+			t.Fatalf("Expected 48 hourly buckets for %s, got %d %+v", metric, w.Code, resp)
+		}
+		if got := resp.Points[47].Count; got != want {
+			t.Errorf("Expected %d %s this hour, got %d", want, metric, got)
+		}
+	}
+	
+	if _, resp := get("metric=failed"); resp.Resolution != ResolutionMinute || len(resp.Points) != 60 {
+		t.Errorf("Expected minute resolution by default, got %+v", resp)
+	}
+	if w, _ := get("metric=failed&resolution=week"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown resolution, got %d", w.Code)
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"sync/atomic"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// Tracker keeps the API's tracked emails current as they leave the queue.
+// Once an email has been reported to DeliveryResult the API tracks its own
+// copy, which the queue no longer changes; without a Tracker an email
+// that then expires or is rejected in the queue would stay "queued", and
+// any content it shares with other batch emails would never be freed. It is
+// a queue.Listener: pass it to the queue at construction and install it
+// with SetTracker.
+type Tracker struct {
+	api atomic.Pointer[API]
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{}
+}
+
+func (t *Tracker) OnEnqueued(e *email.Email) {}
+
+func (t *Tracker) OnDequeued(e *email.Email) {}
+
+func (t *Tracker) OnDelivered(id string, duration time.Duration) {}
+
+func (t *Tracker) OnFailed(id string, reason string, willRetry bool) {}
+
+// OnRemoved implements queue.RemovalListener.
+func (t *Tracker) OnRemoved(e *email.Email) {
+	if a := t.api.Load(); a != nil {
+		a.removed(e)
+	}
+}
+
+// SetTracker has t bring tracked emails up to date as they leave the
+// queue. t should be listening to the API's queue.
+func (a *API) SetTracker(t *Tracker) {
+	t.api.Store(a)
+}
+
+// removed records the final state of an email the queue has let go of,
+// unless a delivery result has already finished it, and releases its
+// shared content.
+func (a *API) removed(final *email.Email) {
+	a.invalidate(final.ID)
+	a.shared.release(final.ID)
+	a.updateTracked(final.ID, func(e *email.Email) {
+		if e.Status.Final() {
+			return
+		}
+		e.Mirror(final.Status, final.UpdatedAt, final.RetryCount, final.LastError)
+		e.RejectedBy = final.RejectedBy
+		e.RejectReason = final.RejectReason
+	})
+}
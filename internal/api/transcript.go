@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// TranscriptResponse is the SMTP exchange of each transaction of an email
+// sent with debug, oldest first.
+type TranscriptResponse struct {
+	ID           string                  `json:"id"`
+	Transactions []TranscriptTransaction `json:"transactions"`
+}
+
+// TranscriptTransaction is one transaction's exchange: lines the client
+// sent start "C: " and the server's replies "S: ".
+type TranscriptTransaction struct {
+	Attempt int       `json:"attempt"`
+	At      time.Time `json:"at"`
+	Host    string    `json:"host"`
+	Result  string    `json:"result"`
+	Lines   []string  `json:"lines"`
+}
+
+// handleTranscript serves GET /status/{id}/transcript.
+func (a *API) handleTranscript(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	value, ok := a.emailStatus.Load(id)
+	if !ok || !a.canSee(r, value.(*email.Email)) {
+		a.errorResponse(w, http.StatusNotFound, "email not found")
+		return
+	}
+	e := a.current(r.Context(), value.(*email.Email))
+	if !e.Debug {
+		a.errorResponse(w, http.StatusNotFound, "no transcript: the email was not sent with debug")
+		return
+	}
+	
+	resp := TranscriptResponse{ID: e.ID, Transactions: []TranscriptTransaction{}}
+	for _, attempt := range e.Attempts {
+		lines := []string{}
+		if attempt.Transcript != "" {
+			lines = strings.Split(attempt.Transcript, "\n")
+		}
+		resp.Transactions = append(resp.Transactions, TranscriptTransaction{
+			Attempt: attempt.Number,
+			At:      attempt.At,
+			Host:    attempt.Host,
+			Result:  attempt.Result,
+			Lines:   lines,
+		})
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// withoutTranscripts returns attempts with their transcripts left out,
+// which only GET /status/{id}/transcript returns.
+func withoutTranscripts(attempts []email.Attempt) []email.Attempt {
+	if attempts == nil {
+		return nil
+	}
+	out := make([]email.Attempt, len(attempts))
+	for i, attempt := range attempts {
+		attempt.Transcript = ""
+		out[i] = attempt
+	}
+	return out
+}
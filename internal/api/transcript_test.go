@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestAPI_Transcript(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	send := func(debug bool) string {
+		body, _ := json.Marshal(SendEmailRequest{
+			From:    "sender@example.com",
+			To:      []string{"a@example.com"},
+			Subject: "Test",
+			Body:    "Test body",
+			Debug:   debug,
+		})
+		var sent SendEmailResponse
+		json.NewDecoder(do("POST", "/send", body).Body).Decode(&sent)
+		return sent.ID
+	}
+	
+	id := send(true)
+	q.Dequeue(ctx, 1)
+	q.MarkDelivered(ctx, id)
+	api.DeliveryResult(delivery.Result{
+		ID:      id,
+		Status:  email.StatusDelivered,
+		Attempt: 1,
+		At:      time.Now(),
+		Attempts: []email.Attempt{{
+			At:         time.Now(),
+			Number:     1,
+			Host:       "mx.example.com",
+			Result:     email.AttemptDelivered,
+			Transcript: "S: 220 mx.example.com ESMTP\nC: EHLO mail.example.com",
+		}},
+	})
+	
+	w := do("GET", "/status/"+id+"/transcript", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var transcript TranscriptResponse
+	json.NewDecoder(w.Body).Decode(&transcript)
+	if len(transcript.Transactions) != 1 || len(transcript.Transactions[0].Lines) != 2 || transcript.Transactions[0].Lines[1] != "C: EHLO mail.example.com" {
+		t.Errorf("Expected the transaction's two lines, got %+v", transcript)
+	}
+	
+	// The status leaves transcripts out
+	w = do("GET", "/status/"+id, nil)
+	if strings.Contains(w.Body.String(), "transcript") {
+		t.Errorf("Expected no transcript in the status, got %s", w.Body)
+	}
+	
+	if w := do("GET", "/status/"+send(false)+"/transcript", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an email sent without debug, got %d", w.Code)
+	}
+	if w := do("DELETE", "/status/"+id+"/transcript", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+)
+
+// WorkerScaler changes how many delivery workers run, normally the
+// delivery service.
+type WorkerScaler interface {
+	Workers() int
+	SetWorkers(n int) error
+}
+
+// WorkersRequest is the body of a change to the number of workers.
+type WorkersRequest struct {
+	Count *int `json:"count"`
+}
+
+// WorkersResponse reports how many delivery workers are running.
+type WorkersResponse struct {
+	Count int `json:"count"`
+}
+
+// SetWorkerScaler enables /admin/delivery/workers and reports the number
+// of delivery workers in /stats.
+func (a *API) SetWorkerScaler(s WorkerScaler) {
+	a.workerScaler = s
+}
+
+// handleWorkers serves /admin/delivery/workers: GET reports how many
+// delivery workers are running and POST changes it.
+func (a *API) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	if a.workerScaler == nil {
+		a.errorResponse(w, http.StatusNotImplemented, "worker scaling is not enabled")
+		return
+	}
+	
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req WorkersRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			a.errorResponse(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+		if req.Count == nil {
+			a.errorResponse(w, http.StatusBadRequest, "count is required")
+			return
+		}
+		if *req.Count < 0 || *req.Count > delivery.MaxWorkers {
+			a.errorResponse(w, http.StatusBadRequest, "count must be between 0 and "+strconv.Itoa(delivery.MaxWorkers))
+			return
+		}
+		
+		params := map[string]string{"count": strconv.Itoa(*req.Count)}
+		_, err := a.audited(r, "delivery.workers", params, func() (int, error) {
+			return *req.Count, a.workerScaler.SetWorkers(*req.Count)
+		})
+		if errors.Is(err, delivery.ErrNotRunning) {
+			a.errorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			a.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+	default:
+		a.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WorkersResponse{Count: a.workerScaler.Workers()})
+}
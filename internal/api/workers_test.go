@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+)
+
+func TestAPI_DeliveryWorkers(t *testing.T) {
+	q := queue.NewMemoryQueue(100)
+	api := New(&config.APIConfig{AuthToken: "test-token"}, q, 25*1024*1024)
+	
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	
+	if w := do("POST", "/admin/delivery/workers", `{"count": 4}`); w.Code != http.StatusNotImplemented {
+		t.Fatalf("Expected 501 without a scaler, got %d", w.Code)
+	}
+	service := delivery.NewService(&config.DeliveryConfig{Workers: 2}, q)
+	api.SetWorkerScaler(service)
+	
+	if w := do("POST", "/admin/delivery/workers", `{"count": 4}`); w.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 before the service starts, got %d: %s", w.Code, w.Body)
+	}
+	go service.Start(context.Background())
+	defer service.Stop(context.Background())
+	time.Sleep(50 * time.Millisecond)
+	
+	w := do("POST", "/admin/delivery/workers", `{"count": 4}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var resp WorkersResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Count != 4 {
+		t.Errorf("Expected 4 workers, got %+v (%v)", resp, err)
+	}
+	
+	for _, body := range []string{`{}`, `{"count": -1}`, `{"count": 100000}`, `nope`} {
+		if w := do("POST", "/admin/delivery/workers", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	if w := do("DELETE", "/admin/delivery/workers", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+	
+	// Scaling to zero is reported, not left out
+	do("POST", "/admin/delivery/workers", `{"count": 0}`)
+	var stats StatsResponse
+	w = do("GET", "/stats", "")
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.DeliveryWorkers == nil || *stats.DeliveryWorkers != 0 {
+		t.Errorf("Expected 0 workers in stats, got %v", stats.DeliveryWorkers)
+	}
+}
@@ -0,0 +1,211 @@
+package attachment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+)
+
+var (
+	ErrFetchDisabled    = errors.New("fetching by reference is not enabled")
+	ErrNoSource         = errors.New("needs data, url or path")
+	ErrInsecureURL      = errors.New("url must use https")
+	ErrPrivateAddress   = errors.New("url resolves to a private address")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrPathNotAllowed   = errors.New("path is not in an allowed directory")
+	ErrTooLarge         = errors.New("exceeds maximum attachment size")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// Ref describes where to fetch an attachment's content from. Exactly one
+// of URL and Path is set.
+type Ref struct {
+	URL    string
+	Path   string
+	SHA256 string // optional hex digest to verify the content against
+}
+
+// Fetcher retrieves attachments by reference at submission time, so large
+// files need not be base64-encoded into the JSON request.
+type Fetcher struct {
+	maxSize      int64
+	allowedPaths []string
+	client       *http.Client
+}
+
+func NewFetcher(cfg *config.AttachmentConfig) *Fetcher {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !cfg.AllowPrivateNetworks {
+		// Checked at connect time so DNS rebinding cannot bypass it
+		dialer.Control = denyPrivate
+	}
+	
+	allowed := make([]string, 0, len(cfg.AllowedPaths))
+	for _, dir := range cfg.AllowedPaths {
+		// Compare against the real location, as requested paths are
+		// resolved the same way
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		allowed = append(allowed, filepath.Clean(dir))
+	}
+	
+	maxRedirects := cfg.MaxRedirects
+	return &Fetcher{
+		maxSize:      cfg.MaxSize,
+		allowedPaths: allowed,
+		client: &http.Client{
+			Timeout: cfg.FetchTimeout,
+			Transport: &http.Transport{
+				Proxy:               nil,
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return ErrTooManyRedirects
+				}
+				if req.URL.Scheme != "https" {
+					return ErrInsecureURL
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Fetch returns the content ref points to, verifying its size and checksum.
+func (f *Fetcher) Fetch(ctx context.Context, ref Ref) ([]byte, error) {
+	var data []byte
+	var err error
+	switch {
+	case ref.URL != "":
+		data, err = f.fetchURL(ctx, ref.URL)
+	case ref.Path != "":
+		data, err = f.readPath(ref.Path)
+	default:
+		return nil, ErrNoSource
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	if ref.SHA256 != "" {
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), ref.SHA256) {
+			return nil, ErrChecksumMismatch
+		}
+	}
+	
+	return data, nil
+}
+
+func (f *Fetcher) fetchURL(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "https" {
+		return nil, ErrInsecureURL
+	}
+	
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch returned status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > f.maxSize {
+		return nil, ErrTooLarge
+	}
+	
+	return readLimited(resp.Body, f.maxSize)
+}
+
+func (f *Fetcher) readPath(path string) ([]byte, error) {
+	if !filepath.IsAbs(path) {
+		return nil, ErrPathNotAllowed
+	}
+	
+	// Resolve symlinks so a link cannot point outside the allowlist
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	if !f.pathAllowed(resolved) {
+		return nil, ErrPathNotAllowed
+	}
+	
+	file, err := os.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	
+	return readLimited(file, f.maxSize)
+}
+
+func (f *Fetcher) pathAllowed(path string) bool {
+	for _, dir := range f.allowedPaths {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// denyPrivate refuses connections to loopback, private, link-local and
+// other non-public addresses.
+func denyPrivate(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublic(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598).
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublic(ip net.IP) bool {
+	return !(ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
@@ -0,0 +1,137 @@
+package attachment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+)
+
+func newTestFetcher(t *testing.T, srv *httptest.Server, cfg *config.AttachmentConfig) *Fetcher {
+	t.Helper()
+	
+	if cfg.FetchTimeout == 0 {
+		cfg.FetchTimeout = 5 * time.Second
+	}
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = 1024
+	}
+	if cfg.MaxRedirects == 0 {
+		cfg.MaxRedirects = 3
+	}
+	f := NewFetcher(cfg)
+	
+	// Trust the test server's certificate
+	if srv != nil {
+		transport := f.client.Transport.(*http.Transport)
+		transport.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+	}
+	return f
+}
+
+func TestFetcher_URL(t *testing.T) {
+	content := []byte("report contents")
+	sum := sha256.Sum256(content)
+	
+	mux := http.NewServeMux()
+	mux.HandleFunc("/report.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 2048)))
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	
+	f := newTestFetcher(t, srv, &config.AttachmentConfig{AllowPrivateNetworks: true})
+	
+	tests := []struct {
+		name    string
+		ref     Ref
+		wantErr error
+	}{
+		{name: "fetched", ref: Ref{URL: srv.URL + "/report.pdf"}},
+		{name: "checksum verified", ref: Ref{URL: srv.URL + "/report.pdf", SHA256: hex.EncodeToString(sum[:])}},
+		{name: "checksum mismatch", ref: Ref{URL: srv.URL + "/report.pdf", SHA256: strings.Repeat("0", 64)}, wantErr: ErrChecksumMismatch},
+		{name: "too large", ref: Ref{URL: srv.URL + "/large"}, wantErr: ErrTooLarge},
+		{name: "redirect loop", ref: Ref{URL: srv.URL + "/loop"}, wantErr: ErrTooManyRedirects},
+		{name: "plain http", ref: Ref{URL: strings.Replace(srv.URL, "https", "http", 1) + "/report.pdf"}, wantErr: ErrInsecureURL},
+		{name: "no source", ref: Ref{}, wantErr: ErrNoSource},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := f.Fetch(context.Background(), tt.ref)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(data) != string(content) {
+				t.Errorf("Expected %q, got %q", content, data)
+			}
+		})
+	}
+	
+	if _, err := f.Fetch(context.Background(), Ref{URL: srv.URL + "/missing"}); err == nil {
+		t.Error("Expected error for 404 response")
+	}
+}
+
+func TestFetcher_DeniesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer srv.Close()
+	
+	f := newTestFetcher(t, srv, &config.AttachmentConfig{})
+	
+	_, err := f.Fetch(context.Background(), Ref{URL: srv.URL})
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("Expected %v, got %v", ErrPrivateAddress, err)
+	}
+}
+
+func TestFetcher_Path(t *testing.T) {
+	allowed := t.TempDir()
+	outside := t.TempDir()
+	
+	os.WriteFile(filepath.Join(allowed, "invoice.pdf"), []byte("invoice"), 0o644)
+	os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644)
+	os.Symlink(filepath.Join(outside, "secret"), filepath.Join(allowed, "link"))
+	
+	f := newTestFetcher(t, nil, &config.AttachmentConfig{AllowedPaths: []string{allowed}})
+	
+	data, err := f.Fetch(context.Background(), Ref{Path: filepath.Join(allowed, "invoice.pdf")})
+	if err != nil || string(data) != "invoice" {
+		t.Fatalf("Expected invoice contents, got %q: %v", data, err)
+	}
+	
+	for _, path := range []string{
+		filepath.Join(outside, "secret"),
+		filepath.Join(allowed, "..", filepath.Base(outside), "secret"),
+		filepath.Join(allowed, "link"),
+		"invoice.pdf",
+	} {
+		if _, err := f.Fetch(context.Background(), Ref{Path: path}); !errors.Is(err, ErrPathNotAllowed) {
+			t.Errorf("%s: expected %v, got %v", path, ErrPathNotAllowed, err)
+		}
+	}
+}
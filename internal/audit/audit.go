@@ -0,0 +1,236 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnavailable = errors.New("audit log unavailable")
+	ErrTampered    = errors.New("audit log hash chain broken")
+)
+
+// Entry is one administrative action. Hash covers every other field,
+// including PrevHash, so altering or removing an entry breaks the chain.
+type Entry struct {
+	Seq       int64             `json:"seq"`
+	Time      time.Time         `json:"time"`
+	RequestID string            `json:"request_id"`
+	Actor     string            `json:"actor"`
+	Action    string            `json:"action"`
+	Params    map[string]string `json:"params,omitempty"`
+	Affected  int               `json:"affected"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+// computeHash hashes the entry with its Hash field cleared. Map keys are
+// sorted by encoding/json, so the encoding is stable.
+func (e Entry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sink persists entries. Append must not return until the entry is durable.
+type Sink interface {
+	Append(e Entry) error
+	// Healthy reports whether the sink can currently accept entries.
+	Healthy() error
+}
+
+// Filter selects entries in Query. Zero values match everything.
+type Filter struct {
+	Actor  string
+	Action string
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int
+}
+
+// Log is an append-only, hash-chained record of administrative actions.
+type Log struct {
+	mu      sync.RWMutex
+	sink    Sink
+	entries []Entry
+}
+
+// NewLog creates a log that writes through to sink, continuing the chain
+// from existing entries.
+func NewLog(sink Sink, existing []Entry) (*Log, error) {
+	if err := Verify(existing); err != nil {
+		return nil, err
+	}
+	return &Log{sink: sink, entries: existing}, nil
+}
+
+// Healthy reports whether entries can currently be recorded.
+func (l *Log) Healthy() error {
+	if l.sink == nil {
+		return nil
+	}
+	if err := l.sink.Healthy(); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return nil
+}
+
+// Record appends e to the chain, filling in its sequence, time and hashes.
+// The entry only becomes part of the log once the sink accepted it.
+func (l *Log) Record(e Entry) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	e.Seq = int64(len(l.entries)) + 1
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if n := len(l.entries); n > 0 {
+		e.PrevHash = l.entries[n-1].Hash
+	}
+	e.Hash = e.computeHash()
+	
+	if l.sink != nil {
+		if err := l.sink.Append(e); err != nil {
+			return Entry{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+	}
+	
+	l.entries = append(l.entries, e)
+	return e, nil
+}
+
+// Query returns the entries matching f, oldest first, and the total number
+// of matches before pagination.
+func (l *Log) Query(f Filter) ([]Entry, int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	
+	var matched []Entry
+	for _, e := range l.entries {
+		if f.Actor != "" && e.Actor != f.Actor {
+			continue
+		}
+		if f.Action != "" && e.Action != f.Action {
+			continue
+		}
+		if !f.Since.IsZero() && e.Time.Before(f.Since) {
+			continue
+		}
+		if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	
+	total := len(matched)
+	if f.Offset >= total {
+		return []Entry{}, total
+	}
+	matched = matched[f.Offset:]
+	if f.Limit > 0 && len(matched) > f.Limit {
+		matched = matched[:f.Limit]
+	}
+	return matched, total
+}
+
+// Verify checks the whole log's hash chain.
+func (l *Log) Verify() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	
+	return Verify(l.entries)
+}
+
+// Verify checks that entries form an unbroken hash chain from the start
+// of the log.
+func Verify(entries []Entry) error {
+	prev := ""
+	for i, e := range entries {
+		if e.Seq != int64(i)+1 || e.PrevHash != prev || e.Hash != e.computeHash() {
+			return fmt.Errorf("%w at entry %d", ErrTampered, i+1)
+		}
+		prev = e.Hash
+	}
+	return nil
+}
+
+// FileSink appends entries as JSON lines, syncing after each write.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+	err  error
+}
+
+// OpenFile opens the audit file at path for appending and returns its
+// existing entries.
+func OpenFile(path string) (*FileSink, []Entry, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("%w: %v", ErrTampered, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	
+	return &FileSink{file: file}, entries, nil
+}
+
+func (s *FileSink) Append(e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		s.err = err
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		s.err = err
+		return err
+	}
+	s.err = nil
+	return nil
+}
+
+// Healthy reports the last write error, or whether the file is still open.
+func (s *FileSink) Healthy() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	if s.err != nil {
+		return s.err
+	}
+	_, err := s.file.Stat()
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
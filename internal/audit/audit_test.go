@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLog_HashChain(t *testing.T) {
+	l, _ := NewLog(nil, nil)
+	
+	for _, action := range []string{"purge", "retry", "suppression.add"} {
+		if _, err := l.Record(Entry{Actor: "ops", Action: action, Affected: 3}); err != nil {
+			t.Fatalf("Failed to record %s: %v", action, err)
+		}
+	}
+	
+	if err := l.Verify(); err != nil {
+		t.Fatalf("Expected valid chain, got %v", err)
+	}
+	
+	entries, _ := l.Query(Filter{})
+	
+	tests := []struct {
+		name   string
+		tamper func(entries []Entry) []Entry
+	}{
+		{"edited params", func(e []Entry) []Entry { e[1].Affected = 300; return e }},
+		{"edited and rehashed", func(e []Entry) []Entry { e[1].Actor = "mallory"; e[1].Hash = e[1].computeHash(); return e }},
+		{"removed entry", func(e []Entry) []Entry { return append(e[:1:1], e[2:]...) }},
+		{"reordered", func(e []Entry) []Entry { e[0], e[1] = e[1], e[0]; return e }},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copied := append([]Entry(nil), entries...)
+			if err := Verify(tt.tamper(copied)); !errors.Is(err, ErrTampered) {
+				t.Errorf("Expected %v, got %v", ErrTampered, err)
+			}
+		})
+	}
+}
+
+func TestLog_Query(t *testing.T) {
+	l, _ := NewLog(nil, nil)
+	start := time.Now().UTC()
+	
+	for i := 0; i < 5; i++ {
+		l.Record(Entry{Actor: "ops", Action: "retry", Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	l.Record(Entry{Actor: "admin", Action: "purge", Time: start.Add(10 * time.Minute)})
+	
+	entries, total := l.Query(Filter{Action: "retry", Offset: 1, Limit: 2})
+	if total != 5 || len(entries) != 2 || entries[0].Seq != 2 {
+		t.Errorf("Unexpected page: total=%d entries=%+v", total, entries)
+	}
+	
+	entries, total = l.Query(Filter{Since: start.Add(3 * time.Minute)})
+	if total != 3 {
+		t.Errorf("Expected 3 entries since minute 3, got %d", total)
+	}
+	
+	entries, _ = l.Query(Filter{Actor: "admin"})
+	if len(entries) != 1 || entries[0].Action != "purge" {
+		t.Errorf("Expected the admin purge, got %+v", entries)
+	}
+}
+
+func TestFileSink_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	
+	sink, existing, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit file: %v", err)
+	}
+	l, _ := NewLog(sink, existing)
+	l.Record(Entry{Actor: "ops", Action: "purge"})
+	sink.Close()
+	
+	// The chain continues across restarts
+	sink, existing, err = OpenFile(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen audit file: %v", err)
+	}
+	l, err = NewLog(sink, existing)
+	if err != nil {
+		t.Fatalf("Failed to load audit log: %v", err)
+	}
+	e, _ := l.Record(Entry{Actor: "ops", Action: "retry"})
+	if e.Seq != 2 || e.PrevHash != existing[0].Hash {
+		t.Errorf("Expected entry chained to the previous run, got %+v", e)
+	}
+	
+	// A closed file is reported as unavailable
+	sink.Close()
+	if err := l.Healthy(); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected %v, got %v", ErrUnavailable, err)
+	}
+	
+	// Tampering on disk is detected at load
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, append([]byte(`{"seq":1,"action":"forged"}`+"\n"), data...), 0o600)
+	sink, existing, _ = OpenFile(path)
+	defer sink.Close()
+	if _, err := NewLog(sink, existing); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected %v, got %v", ErrTampered, err)
+	}
+}
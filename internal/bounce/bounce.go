@@ -0,0 +1,177 @@
+// Package bounce parses the delivery status notifications (RFC 3464)
+// remote servers send back to the return path when they accept an email
+// and later fail to deliver it.
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// ErrNotDSN is returned for a message that is not a multipart/report with
+// a message/delivery-status part, such as an auto-reply.
+var ErrNotDSN = errors.New("not a delivery status notification")
+
+// Report is a parsed delivery status notification.
+type Report struct {
+	// Original-Envelope-Id, if the sender asked for one
+	EnvelopeID string
+	
+	Recipients []Recipient
+	
+	// Headers of the returned message, if the report includes them
+	Original mail.Header
+}
+
+// Recipient is the status the report gives one recipient. Action is
+// "failed", "delayed", "delivered", "relayed" or "expanded"; Status is an
+// enhanced status code such as "5.1.1", and Diagnostic the remote
+// server's reply, if given.
+type Recipient struct {
+	Address    string
+	Action     string
+	Status     string
+	Diagnostic string
+}
+
+// Failed reports whether delivery to the recipient failed for good.
+func (r Recipient) Failed() bool {
+	return strings.EqualFold(r.Action, "failed")
+}
+
+// Reason is the recipient's diagnostic, or its status code without one.
+func (r Recipient) Reason() string {
+	if r.Diagnostic != "" {
+		return r.Diagnostic
+	}
+	return r.Status
+}
+
+// Failed returns the recipients delivery failed to.
+func (r *Report) Failed() []Recipient {
+	var failed []Recipient
+	for _, rcpt := range r.Recipients {
+		if rcpt.Failed() {
+			failed = append(failed, rcpt)
+		}
+	}
+	return failed
+}
+
+// EmailID returns the ID of the email the report is about, found from
+// the VERP address it was sent to, its envelope ID, or the return path in
+// the returned message's headers, in that order. Each must carry the ID
+// signed with secret, so anyone can send a bounce but only one about mail
+// this server sent names an email. localPart and secret are the return
+// path's; see config.ReturnPathConfig.
+func (r *Report) EmailID(rcpt, localPart, secret string) (string, bool) {
+	if id, ok := email.DecodeVERP(rcpt, localPart, secret); ok {
+		return id, true
+	}
+	if r.EnvelopeID != "" {
+		if id, ok := email.ParseVERPToken(r.EnvelopeID, secret); ok {
+			return id, true
+		}
+	}
+	if r.Original != nil {
+		if addr, err := mail.ParseAddress(r.Original.Get("Return-Path")); err == nil {
+			return email.DecodeVERP(addr.Address, localPart, secret)
+		}
+	}
+	return "", false
+}
+
+// Parse reads a delivery status notification from msg.
+func Parse(msg []byte) (*Report, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotDSN, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["boundary"] == "" {
+		return nil, ErrNotDSN
+	}
+	
+	var report *Report
+	var original mail.Header
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read report: %w", err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			if report, err = parseStatus(part); err != nil {
+				return nil, err
+			}
+		case "message/rfc822", "text/rfc822-headers", "message/global", "message/global-headers":
+			header, err := textproto.NewReader(bufio.NewReader(part)).ReadMIMEHeader()
+			if len(header) > 0 || err == nil {
+				original = mail.Header(header)
+			}
+		}
+	}
+	if report == nil {
+		return nil, ErrNotDSN
+	}
+	report.Original = original
+	return report, nil
+}
+
+// parseStatus reads a delivery-status part: a block of per-message
+// fields, then one block per recipient.
+func parseStatus(r io.Reader) (*Report, error) {
+	tr := textproto.NewReader(bufio.NewReader(r))
+	report := &Report{}
+	perMessage := true
+	for {
+		fields, err := tr.ReadMIMEHeader()
+		if len(fields) > 0 {
+			if perMessage {
+				report.EnvelopeID = strings.TrimSpace(fields.Get("Original-Envelope-Id"))
+				perMessage = false
+			} else if rcpt := typedValue(fields.Get("Final-Recipient")); rcpt != "" {
+				report.Recipients = append(report.Recipients, Recipient{
+					Address:    rcpt,
+					Action:     strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+					Status:     strings.TrimSpace(fields.Get("Status")),
+					Diagnostic: typedValue(fields.Get("Diagnostic-Code")),
+				})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid delivery status: %w", err)
+		}
+	}
+	if len(report.Recipients) == 0 {
+		return nil, fmt.Errorf("invalid delivery status: no recipients")
+	}
+	return report, nil
+}
+
+// typedValue returns the value of a field like "rfc822; user@example.com"
+// or "smtp; 550 5.1.1 unknown user" without its type.
+func typedValue(field string) string {
+	if _, value, ok := strings.Cut(field, ";"); ok {
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(field)
+}
@@ -0,0 +1,94 @@
+package bounce
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+const emailID = "3f2b8c1e-9d4a-4e6b-8f1a-2c3d4e5f6a7b"
+
+func TestParse(t *testing.T) {
+	msg, err := os.ReadFile("testdata/postfix.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Parse(msg)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	
+	if len(report.Recipients) != 2 {
+		t.Fatalf("Expected 2 recipients, got %+v", report.Recipients)
+	}
+	failed := report.Failed()
+	if len(failed) != 1 {
+		t.Fatalf("Expected only the failed recipient, got %+v", failed)
+	}
+	want := Recipient{
+		Address:    "missing@example.com",
+		Action:     "failed",
+		Status:     "5.1.1",
+		Diagnostic: "550 5.1.1 <missing@example.com>: Recipient address rejected: User unknown",
+	}
+	if failed[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, failed[0])
+	}
+	if report.Original.Get("Subject") != "Hello" {
+		t.Errorf("Expected the returned headers, got %v", report.Original)
+	}
+	
+	tests := []struct {
+		name   string
+		rcpt   string
+		report *Report
+		wantID string
+		wantOK bool
+	}{
+		{name: "VERP recipient", rcpt: email.EncodeVERP("bounce", emailID, "bounces.example.com", "secret"), report: &Report{}, wantID: emailID, wantOK: true},
+		{name: "envelope ID", rcpt: "bounce@bounces.example.com", report: &Report{EnvelopeID: email.VERPToken("envid-1", "secret")}, wantID: "envid-1", wantOK: true},
+		{name: "returned Return-Path", rcpt: "bounce@bounces.example.com", report: &Report{Original: report.Original}, wantID: emailID, wantOK: true},
+		{name: "nothing to go on", rcpt: "bounce@bounces.example.com", report: &Report{}},
+		{name: "unsigned VERP recipient", rcpt: "bounce+" + emailID + "@bounces.example.com", report: &Report{}},
+		{name: "unsigned envelope ID", rcpt: "bounce@bounces.example.com", report: &Report{EnvelopeID: "envid-1"}},
+		{name: "VERP signed with another key", rcpt: email.EncodeVERP("bounce", emailID, "bounces.example.com", "other"), report: &Report{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := tt.report.EmailID(tt.rcpt, "bounce", "secret")
+			if id != tt.wantID || ok != tt.wantOK {
+				t.Errorf("EmailID() = %q, %v, want %q, %v", id, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParse_NotDSN(t *testing.T) {
+	for name, msg := range map[string]string{
+		"plain text": "From: someone@example.com\r\nSubject: Out of office\r\n\r\nI'm away.\r\n",
+		"mixed":      "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nhi\r\n--b--\r\n",
+		"no status":  "Content-Type: multipart/report; report-type=delivery-status; boundary=b\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nhi\r\n--b--\r\n",
+	} {
+		if _, err := Parse([]byte(msg)); !errors.Is(err, ErrNotDSN) {
+			t.Errorf("%s: expected ErrNotDSN, got %v", name, err)
+		}
+	}
+	
+	msg := strings.Join([]string{
+		"Content-Type: multipart/report; report-type=delivery-status; boundary=b",
+		"",
+		"--b",
+		"Content-Type: message/delivery-status",
+		"",
+		"Reporting-MTA: dns; mx.example.com",
+		"",
+		"--b--",
+		"",
+	}, "\r\n")
+	if _, err := Parse([]byte(msg)); err == nil {
+		t.Error("Expected a report without recipients to fail")
+	}
+}
@@ -2,7 +2,14 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/identity"
 )
 
 type Config struct {
@@ -12,12 +19,49 @@ type Config struct {
 	Delivery DeliveryConfig `yaml:"delivery"`
 	Limits   LimitsConfig   `yaml:"limits"`
 	Logging  LoggingConfig  `yaml:"logging"`
+	
+	Resources   ResourceConfig   `yaml:"resources"`
+	Attachments AttachmentConfig `yaml:"attachments"`
+	Audit       AuditConfig      `yaml:"audit"`
 }
 
 type ServerConfig struct {
 	Hostname      string     `yaml:"hostname"`
 	ListenAddress string     `yaml:"listen_address"`
 	TLS           TLSConfig  `yaml:"tls"`
+	
+	// Mail received over SMTP is relayed exactly as received. Messages
+	// larger than RawMemoryLimit bytes are kept in RawSpoolDir, or the
+	// system temporary directory, until delivered.
+	RawMemoryLimit int64  `yaml:"raw_memory_limit"`
+	RawSpoolDir    string `yaml:"raw_spool_dir"`
+	
+	// Greeting sent after "220". {hostname} and {product} are replaced;
+	// the default is "{hostname} ESMTP {product}".
+	Banner  string `yaml:"banner"`
+	Product string `yaml:"product"`
+	
+	// Hold the greeting back this long and disconnect clients that speak
+	// before it, which well-behaved senders never do
+	BannerDelay time.Duration `yaml:"banner_delay"`
+	
+	Extensions ExtensionsConfig `yaml:"extensions"`
+	
+	// Built by Validate from Hostname, once normalized, and shared with
+	// the API and delivery service. Not read from the file.
+	Identity *identity.Identity `yaml:"-"`
+}
+
+// ExtensionsConfig controls the capabilities advertised in reply to EHLO.
+type ExtensionsConfig struct {
+	// Advertise AUTH only once the session is encrypted
+	AuthRequiresTLS bool `yaml:"auth_requires_tls"`
+	
+	// Leave SIZE out; the message size limit is still enforced
+	HideSize bool `yaml:"hide_size"`
+	
+	// Further extensions to leave out, such as "CHUNKING"
+	Hide []string `yaml:"hide"`
 }
 
 type TLSConfig struct {
@@ -31,6 +75,59 @@ type APIConfig struct {
 	ListenAddress string `yaml:"listen_address"`
 	AuthToken     string `yaml:"auth_token"`
 	TLS           TLSConfig `yaml:"tls"`
+	
+	// Accept the token in the api_key query parameter for clients that
+	// cannot set headers. Query strings end up in logs, so it is off by
+	// default.
+	AllowQueryToken bool `yaml:"allow_query_token"`
+	
+	// Additional tokens, each identified by name in the audit log and
+	// optionally capped at a number of emails per day
+	Keys []APIKeyConfig `yaml:"keys"`
+	
+	// POST /admin/test-email
+	TestEmail TestEmailConfig `yaml:"test_email"`
+	
+	// Cache of email statuses in front of the queue for GET /status
+	StatusCache StatusCacheConfig `yaml:"status_cache"`
+	
+	// File to record anonymized submission events to for load testing;
+	// empty means no recording
+	TrafficLog string `yaml:"traffic_log"`
+	
+	// The server's name, in the test email. Not read from the file:
+	// Validate copies server's here.
+	Identity *identity.Identity `yaml:"-"`
+}
+
+// StatusCacheConfig bounds the status cache: at most Size emails, each
+// cached for at most TTL in case a status change goes unreported.
+type StatusCacheConfig struct {
+	Size int           `yaml:"size"`
+	TTL  time.Duration `yaml:"ttl"`
+}
+
+// TestEmailConfig configures the diagnostic test email. From defaults to
+// postmaster at the server's hostname; Timeout is how long the request
+// waits for the delivery result.
+type TestEmailConfig struct {
+	From    string        `yaml:"from"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// APIKeyConfig is a named API token. DailyQuota limits the emails it may
+// submit per UTC day; zero means unlimited. MaxBatchSize and
+// MaxRecipients override the global limits for this key when set. Only
+// keys with Admin set may use the /admin endpoints or reach emails other
+// keys submitted.
+type APIKeyConfig struct {
+	Name       string `yaml:"name"`
+	Token      string `yaml:"token"`
+	DailyQuota int    `yaml:"daily_quota"`
+	Admin      bool   `yaml:"admin"`
+	
+	MaxBatchSize  int `yaml:"max_batch_size"`
+	MaxRecipients int `yaml:"max_recipients"`
 }
 
 type QueueConfig struct {
@@ -39,6 +136,42 @@ type QueueConfig struct {
 	MaxRetry      int           `yaml:"max_retry"`
 	RetryDelay    time.Duration `yaml:"retry_delay"`
 	BatchSize     int           `yaml:"batch_size"`
+	MaxQueueAge   time.Duration `yaml:"max_queue_age"`
+	
+	// Stop retrying once this long has passed since the first delivery
+	// attempt, whatever the retry count; disabled when zero
+	MaxRetryDuration time.Duration `yaml:"max_retry_duration"`
+	
+	// Duplicate suppression; disabled when DedupWindow is zero
+	DedupWindow     time.Duration `yaml:"dedup_window"`
+	DedupMaxEntries int           `yaml:"dedup_max_entries"`
+	
+	// Time a queued email waits to gain one level of effective priority
+	PriorityAging time.Duration `yaml:"priority_aging"`
+	
+	// Emails this close to their SLA deadline are sent ahead of all
+	// other ready mail
+	SLABoost time.Duration `yaml:"sla_boost"`
+	
+	// Retries back off exponentially from RetryDelay up to MaxRetryDelay,
+	// unless RetrySchedule lists the delays explicitly. Each delay varies
+	// by up to ±RetryJitter (default 0.2); a negative value disables it.
+	MaxRetryDelay time.Duration   `yaml:"max_retry_delay"`
+	RetrySchedule []time.Duration `yaml:"retry_schedule"`
+	RetryJitter   float64         `yaml:"retry_jitter"`
+	
+	// A first attempt that looks greylisted (a 4xx reply saying so, or
+	// any 450 or 451) is retried after GreylistRetryDelay instead, 3m by
+	// default; a negative value disables this. A delay the server names
+	// in its reply, such as "try again in 300 seconds", is used instead of
+	// either, up to MaxRetryDelay.
+	GreylistRetryDelay time.Duration `yaml:"greylist_retry_delay"`
+	
+	// Failures where delivery was never attempted, such as a resolver
+	// outage, are retried after DeferDelay without counting against
+	// MaxRetry, up to MaxDeferrals times
+	DeferDelay   time.Duration `yaml:"defer_delay"`
+	MaxDeferrals int           `yaml:"max_deferrals"`
 }
 
 type DeliveryConfig struct {
@@ -46,12 +179,331 @@ type DeliveryConfig struct {
 	DNSCacheTTL        time.Duration `yaml:"dns_cache_ttl"`
 	ConnectionTimeout  time.Duration `yaml:"connection_timeout"`
 	ConnectionPoolSize int           `yaml:"connection_pool_size"`
+	
+	// Negative MX answers, for domains that do not exist or have no mail
+	// hosts, are cached for DNSNegativeTTL. The cache holds at most
+	// DNSCacheSize domains, evicting the least recently used.
+	DNSNegativeTTL time.Duration `yaml:"dns_negative_ttl"`
+	DNSCacheSize   int           `yaml:"dns_cache_size"`
+	
+	// DNS server for delivery lookups, as host or host:port; empty uses
+	// the system resolver. Each lookup gets DNSTimeout, zero for no limit.
+	DNSServer  string        `yaml:"dns_server"`
+	DNSTimeout time.Duration `yaml:"dns_timeout"`
+	
+	// Pooled SMTP sessions left idle this long are closed
+	ConnectionIdleTimeout time.Duration `yaml:"connection_idle_timeout"`
+	
+	// On shutdown, deliveries under way get ShutdownTimeout to finish
+	// before they are aborted; emails not yet started go back to the queue.
+	// It is also the grace period the server's signal handler gives Stop.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	
+	// An email whose MX hosts all refuse in their greeting, such as "421
+	// too busy" on connect, is deferred this long without using a retry
+	GreetingDeferDelay time.Duration `yaml:"greeting_defer_delay"`
+	
+	// MTASTS is "enforce" to honour recipient domains' MTA-STS policies,
+	// "testing" to only log what enforced policies would refuse, or "off"
+	MTASTS string `yaml:"mta_sts"`
+	
+	// STARTTLS policy for outbound delivery. "required" fails delivery
+	// temporarily unless TLS is established with a verified certificate;
+	// "opportunistic-verify" uses TLS when offered and fails if the
+	// certificate doesn't verify; "opportunistic" (default) uses TLS when
+	// offered without verifying it, falling back to plaintext; "none"
+	// never uses TLS. TLSPolicy applies to domains without an entry in
+	// TLSPolicies. A host's DANE records or its domain's enforced MTA-STS
+	// policy require verified TLS whatever the policy.
+	TLSPolicy   string            `yaml:"tls_policy"`
+	TLSPolicies map[string]string `yaml:"tls_policies"`
+	
+	// Oldest TLS version accepted from MX hosts: "1.0", "1.1", "1.2"
+	// (default) or "1.3"
+	TLSMinVersion string `yaml:"tls_min_version"`
+	
+	// Mode is "mx" to deliver to recipients' MX hosts or "relay" to hand
+	// every email to Relay instead. It defaults to "relay" when a relay
+	// host is set, so setting it to "mx" bypasses a configured relay.
+	Mode  string      `yaml:"mode"`
+	Relay RelayConfig `yaml:"relay"`
+	
+	// Routes send mail for the recipient domains they match another way
+	// than Mode does
+	Routes []RouteConfig `yaml:"routes"`
+	
+	// ReturnPath is the envelope sender bounces go to instead of From
+	ReturnPath ReturnPathConfig `yaml:"return_path"`
+	
+	// Local addresses outbound connections are made from, chosen by
+	// SourceIPStrategy: "fixed" (default) always uses the first,
+	// "round-robin" rotates through them and "per-domain" hashes the
+	// host connected to, so each MX host or relay always sees the same
+	// one. Empty lets the OS choose.
+	SourceIPs        []string `yaml:"source_ips"`
+	SourceIPStrategy string   `yaml:"source_ip_strategy"`
+	
+	// IPFamily limits outbound connections to "ipv4" or "ipv6"
+	// addresses. With "any" (default) both are tried, staggered, and the
+	// first to connect is used.
+	IPFamily string `yaml:"ip_family"`
+	
+	// MX racing for latency-sensitive mail
+	MXRaceStagger  time.Duration `yaml:"mx_race_stagger"`
+	MXRaceMaxExtra int           `yaml:"mx_race_max_extra"`
+	
+	// Fraction of workers that only deliver the transactional lane. A
+	// pointer so an explicit 0, which turns the reservation off, is told
+	// apart from leaving it unset for the default of 0.25.
+	TransactionalWorkerRatio *float64 `yaml:"transactional_worker_ratio"`
+	
+	// Window over which identical delivery failures are collapsed into a
+	// single summary log line
+	FailureLogWindow time.Duration `yaml:"failure_log_window"`
+	
+	// Pre-delivery hooks get HookTimeout to finish. HookFailurePolicy
+	// decides what happens when one fails or times out: "closed" (default)
+	// defers the email, "open" sends it anyway.
+	HookTimeout       time.Duration `yaml:"hook_timeout"`
+	HookFailurePolicy string        `yaml:"hook_failure_policy"`
+	
+	// Send rates per recipient domain, such as "10/m". DomainRateLimit
+	// applies to domains without an entry in DomainLimits; empty means no
+	// limit. Emails over the limit wait in the queue.
+	DomainRateLimit string            `yaml:"domain_rate_limit"`
+	DomainLimits    map[string]string `yaml:"domain_limits"`
+	
+	// Most workers delivering to one recipient domain at once.
+	// DomainConcurrency applies to domains without an entry in
+	// DomainConcurrencyLimits; zero means no limit.
+	DomainConcurrency       int            `yaml:"domain_concurrency"`
+	DomainConcurrencyLimits map[string]int `yaml:"domain_concurrency_limits"`
+	
+	// A recipient domain's circuit opens after BreakerThreshold
+	// consecutive failures within BreakerWindow, holding its mail for
+	// BreakerCooldown before one email probes it. A negative threshold
+	// disables the breaker.
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerWindow    time.Duration `yaml:"breaker_window"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+	
+	// An MX host that fails to answer is tried after the domain's other
+	// hosts for MXHostBackoff, doubling with each further failure up to
+	// MXHostMaxBackoff. A negative backoff disables this.
+	MXHostBackoff    time.Duration `yaml:"mx_host_backoff"`
+	MXHostMaxBackoff time.Duration `yaml:"mx_host_max_backoff"`
+	
+	// Replies refusing mail because of the sending IP's reputation, such
+	// as a blocklist listing, are recognized by a built-in table extended
+	// by ReputationPatterns. A blocklist is reported in /health until none
+	// of its replies has been seen for ReputationClearAfter, and each new
+	// block is POSTed to ReputationWebhook if set.
+	ReputationPatterns   []ReputationPattern `yaml:"reputation_patterns"`
+	ReputationClearAfter time.Duration       `yaml:"reputation_clear_after"`
+	ReputationWebhook    string              `yaml:"reputation_webhook"`
+	
+	// Recipient domains paused through the admin API are saved here, so
+	// a restart keeps them paused. Empty keeps pauses in memory only.
+	PausedDomainsFile string `yaml:"paused_domains_file"`
+	
+	// Start with all outbound delivery paused, until resumed through the
+	// admin API. Mail is still accepted and queued.
+	Paused bool `yaml:"paused"`
+	
+	// Sandbox runs the whole delivery pipeline, MX lookups included, but
+	// sends nothing and marks each email delivered, for staging. Built
+	// messages are written to SandboxDir if set.
+	Sandbox    bool   `yaml:"sandbox"`
+	SandboxDir string `yaml:"sandbox_dir"`
+	
+	// RedirectAllTo sends mail for every recipient outside
+	// RedirectAllowDomains to this one address instead, for
+	// pre-production. It is refused unless RedirectConfirm is set too, so
+	// one stray setting cannot turn it on.
+	RedirectAllTo        string   `yaml:"redirect_all_to"`
+	RedirectAllowDomains []string `yaml:"redirect_allow_domains"`
+	RedirectConfirm      bool     `yaml:"redirect_confirm"`
+	
+	// Mail for LocalDomains, the domains this server hosts, is handed to
+	// the local store instead of being sent over SMTP to their MX hosts,
+	// which would be this server. The built-in store writes it under
+	// LocalDir, one directory per mailbox.
+	LocalDomains []string `yaml:"local_domains"`
+	LocalDir     string   `yaml:"local_dir"`
+	
+	// Mail carrying more than MaxHops Received headers is failed as a mail
+	// loop instead of being sent on
+	MaxHops int `yaml:"max_hops"`
+	
+	// SendingWindows restrict when mail in a lane is delivered, such as
+	// bulk mail only in working hours. Mail outside its lane's windows
+	// waits in the queue until the next one opens; lanes without a window
+	// are delivered at any time.
+	SendingWindows []SendingWindow `yaml:"sending_windows"`
+	
+	// Warmup caps how much mail each recipient domain gets per day while
+	// a new sending IP builds its reputation
+	Warmup WarmupConfig `yaml:"warmup"`
+	
+	// EgressProbe checks at startup that outbound port 25 is open
+	EgressProbe EgressProbeConfig `yaml:"egress_probe"`
+	
+	// Failed attempts retried before an email fails for good. Not read
+	// from the file: Validate copies queue.max_retry here.
+	MaxRetry int `yaml:"-"`
+	
+	// Most emails a worker dequeues at once; each worker adapts its batch
+	// to how quickly it delivers, up to this. Not read from the file:
+	// Validate copies queue.batch_size here.
+	BatchSize int `yaml:"-"`
+	
+	// Name announced in EHLO, Received headers and non-delivery reports.
+	// Not read from the file: Validate copies server's here.
+	Identity *identity.Identity `yaml:"-"`
+}
+
+// RelayConfig is a smarthost all mail is relayed through, such as SES,
+// Mailgun or a corporate relay. TLS is "starttls" (default, port 587),
+// "tls" for TLS from the start of the connection (port 465), or "none".
+// The relay's certificate must verify, against the CAs in CAFile if set.
+// With a username, the client logs in with the first of Mechanisms the
+// relay offers: XOAUTH2 with OAuthToken, or a token the program supplies,
+// and PLAIN, LOGIN or CRAM-MD5 with Password. Logging in needs TLS.
+type RelayConfig struct {
+	Host       string   `yaml:"host"`
+	Port       int      `yaml:"port"`
+	Username   string   `yaml:"username"`
+	Password   string   `yaml:"password"`
+	OAuthToken string   `yaml:"oauth_token"`
+	Mechanisms []string `yaml:"mechanisms"`
+	TLS        string   `yaml:"tls"`
+	CAFile     string   `yaml:"ca_file"`
+}
+
+// RouteConfig sends mail for the recipient domains matching Domain through
+// Relay, or straight to their MX hosts if Target is "direct". Domain is a
+// domain, "*.example.com" for every subdomain of example.com, or "*" for
+// any domain; the most specific route matching a domain applies. Target
+// defaults to "relay" if a relay host is set. TLSPolicy, one of the
+// tls_policy values, replaces the domain's TLS policy on a direct route,
+// and the verified TLS a relay using starttls otherwise requires.
+type RouteConfig struct {
+	Domain    string      `yaml:"domain"`
+	Target    string      `yaml:"target"`
+	Relay     RelayConfig `yaml:"relay"`
+	TLSPolicy string      `yaml:"tls_policy"`
+}
+
+// ReturnPathConfig sets the envelope sender (MAIL FROM), which bounces are
+// sent back to, while the From header stays the author's. With a Domain it
+// is LocalPart at Domain, or with VERP LocalPart, '+' and the email's ID
+// signed with Secret there, so each bounce names its email and cannot be
+// forged; see email.EncodeVERP. LocalPart defaults to "bounce". Mail
+// received over SMTP keeps its own.
+type ReturnPathConfig struct {
+	Domain    string `yaml:"domain"`
+	LocalPart string `yaml:"local_part"`
+	VERP      bool   `yaml:"verp"`
+	Secret    string `yaml:"secret"`
+}
+
+// WarmupConfig is a daily cap per recipient domain that ramps up from
+// Start, a date (2006-01-02): Schedule[0] emails on that day, Schedule[1]
+// the next, and so on, days running midnight to midnight UTC. Mail over
+// the cap waits for the next day, and once the schedule runs out the cap
+// is lifted. Each day's counts are saved to StateFile, if set, so a
+// restart does not reset them.
+type WarmupConfig struct {
+	Start     string `yaml:"start"`
+	Schedule  []int  `yaml:"schedule"`
+	StateFile string `yaml:"state_file"`
+}
+
+// WarmupDateFormat is the layout of WarmupConfig.Start.
+const WarmupDateFormat = "2006-01-02"
+
+// EgressProbeConfig is the check, made when delivery starts, that this
+// host can reach mail servers on port 25, which many cloud providers
+// block. Each of Targets, host:port, is dialled with Timeout; if none
+// answers a warning is logged and /health reports it, and delivery
+// refuses to start if Required is set. Skip turns the check off, for
+// test environments without network access. Mail that all goes through
+// a relay is not checked.
+type EgressProbeConfig struct {
+	Skip     bool          `yaml:"skip"`
+	Targets  []string      `yaml:"targets"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Required bool          `yaml:"required"`
+}
+
+// DefaultEgressProbeTargets are well-known MX hosts the egress probe
+// dials when no targets are configured.
+var DefaultEgressProbeTargets = []string{
+	"gmail-smtp-in.l.google.com:25",
+	"outlook-com.olc.protection.outlook.com:25",
+}
+
+// RelayMechanisms are the SASL mechanisms supported for logging in to a
+// relay, in the default order of preference.
+var RelayMechanisms = []string{"XOAUTH2", "PLAIN", "LOGIN", "CRAM-MD5"}
+
+// ReputationPattern recognizes a reply refusing mail because of the
+// sending IP's reputation. Pattern is a regular expression matched against
+// the reply text, and Codes limits it to those reply codes; any 4xx or 5xx
+// reply matches if it is empty. Name identifies the blocklist in /health
+// and alerts.
+type ReputationPattern struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+	Codes   []int  `yaml:"codes"`
 }
 
 type LimitsConfig struct {
 	MaxRecipients   int    `yaml:"max_recipients"`
 	MaxMessageSize  int64  `yaml:"max_message_size"`
 	RateLimit       string `yaml:"rate_limit"`
+	
+	// Most emails accepted in one batch request
+	MaxBatchSize int `yaml:"max_batch_size"`
+	
+	// Queue fill percentages for load shedding. Above the soft watermark
+	// (default 80) bulk and batch sends get 429; above the hard watermark
+	// (default 100) every send gets 503. A negative value disables one.
+	SoftWatermarkPercent float64 `yaml:"soft_watermark_percent"`
+	HardWatermarkPercent float64 `yaml:"hard_watermark_percent"`
+	
+	// Percentages of the hard limits at which send responses carry a
+	// warning (default 90); a negative value disables that warning
+	WarnQueuePercent float64 `yaml:"warn_queue_percent"`
+	WarnSizePercent  float64 `yaml:"warn_size_percent"`
+	WarnQuotaPercent float64 `yaml:"warn_quota_percent"`
+}
+
+// ResourceConfig sets the thresholds at which the server sheds load. A zero
+// threshold disables that check.
+type ResourceConfig struct {
+	SampleInterval time.Duration `yaml:"sample_interval"`
+	MaxHeapBytes   uint64        `yaml:"max_heap_bytes"`
+	MaxOpenFiles   int           `yaml:"max_open_files"`
+	MaxGoroutines  int           `yaml:"max_goroutines"`
+}
+
+// AttachmentConfig controls fetching attachments by reference. URLs must
+// be https and may not reach private networks unless AllowPrivateNetworks
+// is set; paths must be inside one of AllowedPaths.
+type AttachmentConfig struct {
+	FetchTimeout         time.Duration `yaml:"fetch_timeout"`
+	MaxSize              int64         `yaml:"max_size"`
+	MaxRedirects         int           `yaml:"max_redirects"`
+	AllowedPaths         []string      `yaml:"allowed_paths"`
+	AllowPrivateNetworks bool          `yaml:"allow_private_networks"`
+}
+
+// AuditConfig controls the audit log of administrative actions. With
+// Strict set, admin actions are refused when the log cannot be written.
+type AuditConfig struct {
+	Path   string `yaml:"path"`
+	Strict bool   `yaml:"strict"`
 }
 
 type LoggingConfig struct {
@@ -63,11 +515,33 @@ func (c *Config) Validate() error {
 	if c.Server.Hostname == "" {
 		return fmt.Errorf("server.hostname is required")
 	}
+	hostname, err := identity.Normalize(c.Server.Hostname)
+	if err != nil {
+		return fmt.Errorf("server.hostname: %w", err)
+	}
+	c.Server.Hostname = hostname
+	c.Server.Identity = identity.New(hostname)
+	c.API.Identity = c.Server.Identity
+	c.Delivery.Identity = c.Server.Identity
 	
 	if c.Server.ListenAddress == "" {
 		c.Server.ListenAddress = "0.0.0.0:587"
 	}
 	
+	if c.Server.RawMemoryLimit < 0 {
+		return fmt.Errorf("server.raw_memory_limit must not be negative")
+	}
+	if c.Server.RawMemoryLimit == 0 {
+		c.Server.RawMemoryLimit = 1024 * 1024
+	}
+	
+	if c.Server.Banner == "" {
+		c.Server.Banner = "{hostname} ESMTP {product}"
+	}
+	if c.Server.BannerDelay < 0 {
+		return fmt.Errorf("server.banner_delay must not be negative")
+	}
+	
 	if c.API.ListenAddress == "" {
 		c.API.ListenAddress = "127.0.0.1:8080"
 	}
@@ -76,22 +550,96 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("api.auth_token is required")
 	}
 	
+	if c.API.TestEmail.Timeout == 0 {
+		c.API.TestEmail.Timeout = 30 * time.Second
+	}
+	
+	if c.API.StatusCache.Size < 0 {
+		return fmt.Errorf("api.status_cache.size must not be negative")
+	}
+	if c.API.StatusCache.Size == 0 {
+		c.API.StatusCache.Size = 10000
+	}
+	if c.API.StatusCache.TTL == 0 {
+		c.API.StatusCache.TTL = 2 * time.Second
+	}
+	
+	names := make(map[string]bool)
+	for i, key := range c.API.Keys {
+		if key.Name == "" || key.Token == "" {
+			return fmt.Errorf("api.keys[%d] requires a name and token", i)
+		}
+		if key.Name == "default" || names[key.Name] {
+			return fmt.Errorf("api.keys[%d]: name %q is already in use", i, key.Name)
+		}
+		if key.DailyQuota < 0 || key.MaxBatchSize < 0 || key.MaxRecipients < 0 {
+			return fmt.Errorf("api.keys[%d]: limits must not be negative", i)
+		}
+		names[key.Name] = true
+	}
+	
+	if c.Queue.MaxRetry < 0 {
+		return fmt.Errorf("queue.max_retry must not be negative")
+	}
 	if c.Queue.MaxRetry == 0 {
 		c.Queue.MaxRetry = 5
 	}
+	c.Delivery.MaxRetry = c.Queue.MaxRetry
 	
 	if c.Queue.RetryDelay == 0 {
 		c.Queue.RetryDelay = 5 * time.Minute
 	}
 	
+	if c.Queue.BatchSize < 0 {
+		return fmt.Errorf("queue.batch_size must be positive")
+	}
 	if c.Queue.BatchSize == 0 {
 		c.Queue.BatchSize = 100
 	}
+	c.Delivery.BatchSize = c.Queue.BatchSize
 	
 	if c.Queue.MaxSize == 0 {
 		c.Queue.MaxSize = 10000
 	}
 	
+	if c.Queue.PriorityAging == 0 {
+		c.Queue.PriorityAging = time.Minute
+	}
+	
+	if c.Queue.SLABoost == 0 {
+		c.Queue.SLABoost = 10 * time.Second
+	}
+	
+	if c.Queue.MaxRetryDelay == 0 {
+		c.Queue.MaxRetryDelay = 8 * time.Hour
+	}
+	
+	if c.Queue.RetryJitter >= 1 {
+		return fmt.Errorf("queue.retry_jitter must be less than 1")
+	}
+	
+	if c.Queue.RetryJitter == 0 {
+		c.Queue.RetryJitter = 0.2
+	}
+	
+	if c.Queue.GreylistRetryDelay == 0 {
+		c.Queue.GreylistRetryDelay = 3 * time.Minute
+	}
+	
+	if c.Queue.DeferDelay == 0 {
+		c.Queue.DeferDelay = time.Minute
+	}
+	
+	if c.Queue.MaxDeferrals == 0 {
+		c.Queue.MaxDeferrals = 100
+	}
+	
+	for _, d := range c.Queue.RetrySchedule {
+		if d <= 0 {
+			return fmt.Errorf("queue.retry_schedule entries must be positive")
+		}
+	}
+	
 	if c.Delivery.Workers == 0 {
 		c.Delivery.Workers = 20
 	}
@@ -99,6 +647,20 @@ func (c *Config) Validate() error {
 	if c.Delivery.DNSCacheTTL == 0 {
 		c.Delivery.DNSCacheTTL = 5 * time.Minute
 	}
+	if c.Delivery.DNSNegativeTTL == 0 {
+		c.Delivery.DNSNegativeTTL = time.Minute
+	}
+	if c.Delivery.DNSCacheSize == 0 {
+		c.Delivery.DNSCacheSize = 10000
+	}
+	if c.Delivery.DNSTimeout < 0 {
+		return fmt.Errorf("delivery.dns_timeout must not be negative")
+	}
+	if c.Delivery.DNSServer != "" {
+		if _, err := DNSServerAddress(c.Delivery.DNSServer); err != nil {
+			return fmt.Errorf("delivery.dns_server: %w", err)
+		}
+	}
 	
 	if c.Delivery.ConnectionTimeout == 0 {
 		c.Delivery.ConnectionTimeout = 30 * time.Second
@@ -108,6 +670,235 @@ func (c *Config) Validate() error {
 		c.Delivery.ConnectionPoolSize = 100
 	}
 	
+	if c.Delivery.ConnectionIdleTimeout == 0 {
+		c.Delivery.ConnectionIdleTimeout = 30 * time.Second
+	}
+	
+	if c.Delivery.ShutdownTimeout == 0 {
+		c.Delivery.ShutdownTimeout = 10 * time.Second
+	}
+	if c.Delivery.ShutdownTimeout < 0 {
+		return fmt.Errorf("delivery.shutdown_timeout must not be negative")
+	}
+	
+	if c.Delivery.GreetingDeferDelay == 0 {
+		c.Delivery.GreetingDeferDelay = 30 * time.Second
+	}
+	if c.Delivery.GreetingDeferDelay < 0 {
+		return fmt.Errorf("delivery.greeting_defer_delay must not be negative")
+	}
+	
+	switch c.Delivery.MTASTS {
+	case "":
+		c.Delivery.MTASTS = "enforce"
+	case "enforce", "testing", "off":
+	default:
+		return fmt.Errorf("delivery.mta_sts must be \"enforce\", \"testing\" or \"off\"")
+	}
+	
+	if c.Delivery.TLSPolicy == "" {
+		c.Delivery.TLSPolicy = "opportunistic"
+	}
+	if !validTLSPolicy(c.Delivery.TLSPolicy) {
+		return fmt.Errorf("delivery.tls_policy must be %s", tlsPolicyNames)
+	}
+	for domain, policy := range c.Delivery.TLSPolicies {
+		if !validTLSPolicy(policy) {
+			return fmt.Errorf("delivery.tls_policies[%s] must be %s", domain, tlsPolicyNames)
+		}
+	}
+	switch c.Delivery.TLSMinVersion {
+	case "":
+		c.Delivery.TLSMinVersion = "1.2"
+	case "1.0", "1.1", "1.2", "1.3":
+	default:
+		return fmt.Errorf("delivery.tls_min_version must be \"1.0\", \"1.1\", \"1.2\" or \"1.3\"")
+	}
+	
+	if err := c.Delivery.validateRouting(); err != nil {
+		return err
+	}
+	if rp := &c.Delivery.ReturnPath; rp.Domain != "" {
+		if rp.LocalPart == "" {
+			rp.LocalPart = "bounce"
+		}
+		if strings.ContainsAny(rp.Domain, "@ ") {
+			return fmt.Errorf("delivery.return_path.domain must be a domain")
+		}
+		if strings.ContainsAny(rp.LocalPart, "@+ ") {
+			return fmt.Errorf("delivery.return_path.local_part must not contain '@', '+' or spaces")
+		}
+		if rp.VERP && rp.Secret == "" {
+			return fmt.Errorf("delivery.return_path.verp requires delivery.return_path.secret")
+		}
+	} else if rp.VERP {
+		return fmt.Errorf("delivery.return_path.verp requires delivery.return_path.domain")
+	}
+	if to := c.Delivery.RedirectAllTo; to != "" {
+		local, domain, ok := strings.Cut(to, "@")
+		if !ok || local == "" || domain == "" || strings.ContainsAny(domain, "@ ") {
+			return fmt.Errorf("delivery.redirect_all_to must be an email address")
+		}
+		if !c.Delivery.RedirectConfirm {
+			return fmt.Errorf("delivery.redirect_all_to requires delivery.redirect_confirm: true")
+		}
+	} else if c.Delivery.RedirectConfirm {
+		return fmt.Errorf("delivery.redirect_confirm is set without delivery.redirect_all_to")
+	}
+	for i, domain := range c.Delivery.LocalDomains {
+		if domain == "" || strings.ContainsAny(domain, "@ ") {
+			return fmt.Errorf("delivery.local_domains[%d] must be a domain name", i)
+		}
+	}
+	if c.Delivery.MaxHops < 0 {
+		return fmt.Errorf("delivery.max_hops must not be negative")
+	}
+	if c.Delivery.MaxHops == 0 {
+		c.Delivery.MaxHops = 100
+	}
+	for i, w := range c.Delivery.SendingWindows {
+		if w.Lane != "transactional" && w.Lane != "bulk" {
+			return fmt.Errorf("delivery.sending_windows[%d].lane must be \"transactional\" or \"bulk\"", i)
+		}
+		if _, err := ParseWindow(w); err != nil {
+			return fmt.Errorf("delivery.sending_windows[%d]: %w", i, err)
+		}
+	}
+	if w := &c.Delivery.Warmup; len(w.Schedule) > 0 {
+		if _, err := time.Parse(WarmupDateFormat, w.Start); err != nil {
+			return fmt.Errorf("delivery.warmup.start must be a date such as 2024-01-31")
+		}
+		for i, n := range w.Schedule {
+			if n <= 0 {
+				return fmt.Errorf("delivery.warmup.schedule[%d] must be positive", i)
+			}
+		}
+	}
+	if p := &c.Delivery.EgressProbe; p.Skip {
+		p.Targets = nil
+	} else {
+		if len(p.Targets) == 0 {
+			p.Targets = append([]string(nil), DefaultEgressProbeTargets...)
+		}
+		for i, target := range p.Targets {
+			if _, _, err := net.SplitHostPort(target); err != nil {
+				return fmt.Errorf("delivery.egress_probe.targets[%d] must be host:port", i)
+			}
+		}
+		if p.Timeout < 0 {
+			return fmt.Errorf("delivery.egress_probe.timeout must not be negative")
+		}
+		if p.Timeout == 0 {
+			p.Timeout = 5 * time.Second
+		}
+	}
+	for i, ip := range c.Delivery.SourceIPs {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("delivery.source_ips[%d] must be an IP address", i)
+		}
+	}
+	switch c.Delivery.SourceIPStrategy {
+	case "":
+		c.Delivery.SourceIPStrategy = "fixed"
+	case "fixed", "round-robin", "per-domain":
+	default:
+		return fmt.Errorf("delivery.source_ip_strategy must be \"fixed\", \"round-robin\" or \"per-domain\"")
+	}
+	switch c.Delivery.IPFamily {
+	case "":
+		c.Delivery.IPFamily = "any"
+	case "any", "ipv4", "ipv6":
+	default:
+		return fmt.Errorf("delivery.ip_family must be \"any\", \"ipv4\" or \"ipv6\"")
+	}
+	
+	if c.Delivery.MXRaceStagger == 0 {
+		c.Delivery.MXRaceStagger = 2 * time.Second
+	}
+	
+	if c.Delivery.MXRaceMaxExtra == 0 {
+		c.Delivery.MXRaceMaxExtra = 1
+	}
+	
+	if c.Delivery.TransactionalWorkerRatio == nil {
+		ratio := 0.25
+		c.Delivery.TransactionalWorkerRatio = &ratio
+	}
+	
+	if r := *c.Delivery.TransactionalWorkerRatio; r < 0 || r > 1 {
+		return fmt.Errorf("delivery.transactional_worker_ratio must be between 0 and 1")
+	}
+	
+	if c.Delivery.FailureLogWindow == 0 {
+		c.Delivery.FailureLogWindow = time.Minute
+	}
+	
+	if c.Delivery.HookTimeout == 0 {
+		c.Delivery.HookTimeout = 5 * time.Second
+	}
+	
+	switch c.Delivery.HookFailurePolicy {
+	case "":
+		c.Delivery.HookFailurePolicy = "closed"
+	case "open", "closed":
+	default:
+		return fmt.Errorf("delivery.hook_failure_policy must be \"open\" or \"closed\"")
+	}
+	
+	if c.Delivery.DomainRateLimit != "" {
+		if _, err := ParseRate(c.Delivery.DomainRateLimit); err != nil {
+			return fmt.Errorf("delivery.domain_rate_limit: %w", err)
+		}
+	}
+	for domain, limit := range c.Delivery.DomainLimits {
+		if _, err := ParseRate(limit); err != nil {
+			return fmt.Errorf("delivery.domain_limits[%s]: %w", domain, err)
+		}
+	}
+	
+	if c.Delivery.BreakerThreshold == 0 {
+		c.Delivery.BreakerThreshold = 5
+	}
+	if c.Delivery.BreakerWindow == 0 {
+		c.Delivery.BreakerWindow = 5 * time.Minute
+	}
+	if c.Delivery.BreakerCooldown == 0 {
+		c.Delivery.BreakerCooldown = time.Minute
+	}
+	if c.Delivery.BreakerWindow < 0 || c.Delivery.BreakerCooldown < 0 {
+		return fmt.Errorf("delivery.breaker_window and delivery.breaker_cooldown must not be negative")
+	}
+	
+	if c.Delivery.MXHostBackoff == 0 {
+		c.Delivery.MXHostBackoff = time.Minute
+	}
+	if c.Delivery.MXHostMaxBackoff == 0 {
+		c.Delivery.MXHostMaxBackoff = 15 * time.Minute
+	}
+	if c.Delivery.MXHostMaxBackoff < 0 {
+		return fmt.Errorf("delivery.mx_host_max_backoff must not be negative")
+	}
+	
+	for i, p := range c.Delivery.ReputationPatterns {
+		if p.Name == "" || p.Pattern == "" {
+			return fmt.Errorf("delivery.reputation_patterns[%d] requires a name and pattern", i)
+		}
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return fmt.Errorf("delivery.reputation_patterns[%d]: %w", i, err)
+		}
+		for _, code := range p.Codes {
+			if code < 400 || code > 599 {
+				return fmt.Errorf("delivery.reputation_patterns[%d]: code %d is not a 4xx or 5xx reply", i, code)
+			}
+		}
+	}
+	if c.Delivery.ReputationClearAfter == 0 {
+		c.Delivery.ReputationClearAfter = time.Hour
+	}
+	if c.Delivery.ReputationClearAfter < 0 {
+		return fmt.Errorf("delivery.reputation_clear_after must not be negative")
+	}
+	
 	if c.Limits.MaxRecipients == 0 {
 		c.Limits.MaxRecipients = 100
 	}
@@ -116,39 +907,298 @@ func (c *Config) Validate() error {
 		c.Limits.MaxMessageSize = 25 * 1024 * 1024 // 25MB
 	}
 	
+	if c.Limits.MaxBatchSize == 0 {
+		c.Limits.MaxBatchSize = 100
+	}
+	
+	if c.Limits.MaxRecipients < 0 || c.Limits.MaxBatchSize < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	
+	if c.Queue.BatchSize > c.Queue.MaxSize {
+		return fmt.Errorf("queue.batch_size must not exceed queue.max_queue_size")
+	}
+	
+	if c.Limits.MaxBatchSize > c.Queue.MaxSize {
+		return fmt.Errorf("limits.max_batch_size must not exceed queue.max_queue_size")
+	}
+	
+	for i, key := range c.API.Keys {
+		if key.MaxBatchSize > c.Queue.MaxSize {
+			return fmt.Errorf("api.keys[%d]: max_batch_size must not exceed queue.max_queue_size", i)
+		}
+	}
+	
+	if c.Limits.SoftWatermarkPercent > 100 || c.Limits.HardWatermarkPercent > 100 {
+		return fmt.Errorf("limits watermark percentages must be at most 100")
+	}
+	
+	if c.Limits.SoftWatermarkPercent == 0 {
+		c.Limits.SoftWatermarkPercent = 80
+	}
+	
+	if c.Limits.HardWatermarkPercent == 0 {
+		c.Limits.HardWatermarkPercent = 100
+	}
+	
+	if c.Limits.WarnQueuePercent > 100 || c.Limits.WarnSizePercent > 100 || c.Limits.WarnQuotaPercent > 100 {
+		return fmt.Errorf("limits warning percentages must be at most 100")
+	}
+	
+	if c.Limits.WarnQueuePercent == 0 {
+		c.Limits.WarnQueuePercent = 90
+	}
+	
+	if c.Limits.WarnSizePercent == 0 {
+		c.Limits.WarnSizePercent = 90
+	}
+	
+	if c.Limits.WarnQuotaPercent == 0 {
+		c.Limits.WarnQuotaPercent = 90
+	}
+	
 	if c.Logging.Level == "" {
 		c.Logging.Level = "info"
 	}
 	
+	if c.Resources.SampleInterval == 0 {
+		c.Resources.SampleInterval = 5 * time.Second
+	}
+	
+	if c.Attachments.FetchTimeout == 0 {
+		c.Attachments.FetchTimeout = 30 * time.Second
+	}
+	
+	if c.Attachments.MaxSize == 0 {
+		c.Attachments.MaxSize = c.Limits.MaxMessageSize
+	}
+	
+	if c.Attachments.MaxRedirects == 0 {
+		c.Attachments.MaxRedirects = 3
+	}
+	
 	return nil
 }
 
 func DefaultConfig() *Config {
+	transactionalWorkerRatio := 0.25
 	return &Config{
 		Server: ServerConfig{
-			ListenAddress: "0.0.0.0:587",
+			ListenAddress:  "0.0.0.0:587",
+			RawMemoryLimit: 1024 * 1024,
+			Banner:         "{hostname} ESMTP {product}",
 		},
 		API: APIConfig{
 			ListenAddress: "127.0.0.1:8080",
+			TestEmail: TestEmailConfig{
+				Timeout: 30 * time.Second,
+			},
+			StatusCache: StatusCacheConfig{
+				Size: 10000,
+				TTL:  2 * time.Second,
+			},
 		},
 		Queue: QueueConfig{
 			MaxSize:    10000,
 			MaxRetry:   5,
 			RetryDelay: 5 * time.Minute,
 			BatchSize:  100,
+			
+			PriorityAging: time.Minute,
+			SLABoost:      10 * time.Second,
+			MaxRetryDelay: 8 * time.Hour,
+			RetryJitter:   0.2,
+			DeferDelay:    time.Minute,
+			MaxDeferrals:  100,
+			
+			GreylistRetryDelay: 3 * time.Minute,
 		},
 		Delivery: DeliveryConfig{
 			Workers:            20,
 			DNSCacheTTL:        5 * time.Minute,
 			ConnectionTimeout:  30 * time.Second,
 			ConnectionPoolSize: 100,
+			MaxRetry:           5,
+			BatchSize:          100,
+			MXRaceStagger:      2 * time.Second,
+			MXRaceMaxExtra:     1,
+			
+			ConnectionIdleTimeout:    30 * time.Second,
+			ShutdownTimeout:          10 * time.Second,
+			MaxHops:                  100,
+			TransactionalWorkerRatio: &transactionalWorkerRatio,
+			FailureLogWindow:         time.Minute,
+			HookTimeout:              5 * time.Second,
+			HookFailurePolicy:        "closed",
+			BreakerThreshold:         5,
+			BreakerWindow:            5 * time.Minute,
+			BreakerCooldown:          time.Minute,
+			DNSNegativeTTL:           time.Minute,
+			DNSCacheSize:             10000,
+			MXHostBackoff:            time.Minute,
+			MXHostMaxBackoff:         15 * time.Minute,
+			ReputationClearAfter:     time.Hour,
+			GreetingDeferDelay:       30 * time.Second,
+			MTASTS:                   "enforce",
+			TLSPolicy:                "opportunistic",
+			TLSMinVersion:            "1.2",
+			SourceIPStrategy:         "fixed",
+			IPFamily:                 "any",
+			Mode:                     "mx",
 		},
 		Limits: LimitsConfig{
 			MaxRecipients:  100,
 			MaxMessageSize: 25 * 1024 * 1024,
+			MaxBatchSize:   100,
+			
+			SoftWatermarkPercent: 80,
+			HardWatermarkPercent: 100,
+			WarnQueuePercent:     90,
+			WarnSizePercent:      90,
+			WarnQuotaPercent:     90,
 		},
 		Logging: LoggingConfig{
 			Level: "info",
 		},
+		Resources: ResourceConfig{
+			SampleInterval: 5 * time.Second,
+		},
+		Attachments: AttachmentConfig{
+			FetchTimeout: 30 * time.Second,
+			MaxSize:      25 * 1024 * 1024,
+			MaxRedirects: 3,
+		},
+	}
+}
+// DNSServerAddress returns server as host:port, adding port 53 if it has
+// none.
+func DNSServerAddress(server string) (string, error) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = strings.Trim(server, "[]"), "53"
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || host == "" || strings.ContainsAny(host, " /") {
+		return "", fmt.Errorf("invalid address %q", server)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+const tlsPolicyNames = `"required", "opportunistic-verify", "opportunistic" or "none"`
+
+func validTLSPolicy(policy string) bool {
+	switch policy {
+	case "required", "opportunistic-verify", "opportunistic", "none":
+		return true
+	}
+	return false
+}
+
+// validateRouting checks the delivery mode, relay and routes, filling in
+// defaults.
+func (d *DeliveryConfig) validateRouting() error {
+	if d.Mode == "" {
+		d.Mode = "mx"
+		if d.Relay.Host != "" {
+			d.Mode = "relay"
+		}
+	}
+	switch d.Mode {
+	case "mx":
+	case "relay":
+		if d.Relay.Host == "" {
+			return fmt.Errorf("delivery.relay.host is required in relay mode")
+		}
+		if err := d.Relay.validate("delivery.relay"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("delivery.mode must be \"mx\" or \"relay\"")
+	}
+	
+	seen := make(map[string]bool, len(d.Routes))
+	for i := range d.Routes {
+		route := &d.Routes[i]
+		name := fmt.Sprintf("delivery.routes[%d]", i)
+		route.Domain = strings.ToLower(strings.TrimSpace(route.Domain))
+		if !validRoutePattern(route.Domain) {
+			return fmt.Errorf("%s.domain must be a domain, \"*.\" and a domain, or \"*\"", name)
+		}
+		if seen[route.Domain] {
+			return fmt.Errorf("%s: another route is for %s", name, route.Domain)
+		}
+		seen[route.Domain] = true
+		
+		if route.Target == "" {
+			route.Target = "direct"
+			if route.Relay.Host != "" {
+				route.Target = "relay"
+			}
+		}
+		if route.TLSPolicy != "" && !validTLSPolicy(route.TLSPolicy) {
+			return fmt.Errorf("%s.tls_policy must be %s", name, tlsPolicyNames)
+		}
+		switch route.Target {
+		case "direct":
+			continue
+		case "relay":
+		default:
+			return fmt.Errorf("%s.target must be \"direct\" or \"relay\"", name)
+		}
+		
+		if route.Relay.Host == "" {
+			return fmt.Errorf("%s.relay.host is required for a relay route", name)
+		}
+		if err := route.Relay.validate(name + ".relay"); err != nil {
+			return err
+		}
+		if route.TLSPolicy != "" && route.Relay.TLS != "starttls" {
+			return fmt.Errorf("%s.tls_policy only applies to relays using starttls", name)
+		}
+		if route.TLSPolicy == "none" && route.Relay.Username != "" {
+			return fmt.Errorf("%s.relay.username requires tls, the password would be sent in the clear", name)
+		}
 	}
-}
\ No newline at end of file
+	return nil
+}
+
+// validRoutePattern reports whether pattern is a domain, "*." and a
+// domain, or "*".
+func validRoutePattern(pattern string) bool {
+	domain := strings.TrimPrefix(pattern, "*.")
+	return pattern == "*" || (domain != "" && !strings.Contains(domain, "*"))
+}
+
+// validate checks the relay, filling in defaults. name prefixes errors.
+func (r *RelayConfig) validate(name string) error {
+	if r.TLS == "" {
+		r.TLS = "starttls"
+	}
+	switch r.TLS {
+	case "starttls", "tls", "none":
+	default:
+		return fmt.Errorf("%s.tls must be \"starttls\", \"tls\" or \"none\"", name)
+	}
+	if r.Port == 0 {
+		r.Port = 587
+		if r.TLS == "tls" {
+			r.Port = 465
+		}
+	}
+	if r.Port < 1 || r.Port > 65535 {
+		return fmt.Errorf("%s.port must be between 1 and 65535", name)
+	}
+	if r.Username != "" && r.TLS == "none" {
+		return fmt.Errorf("%s.username requires tls, the password would be sent in the clear", name)
+	}
+	
+	if len(r.Mechanisms) == 0 {
+		r.Mechanisms = slices.Clone(RelayMechanisms)
+	}
+	for i, mechanism := range r.Mechanisms {
+		r.Mechanisms[i] = strings.ToUpper(mechanism)
+		if !slices.Contains(RelayMechanisms, r.Mechanisms[i]) {
+			return fmt.Errorf("%s.mechanisms: unknown mechanism %q, must be one of %s", name, mechanism, strings.Join(RelayMechanisms, ", "))
+		}
+	}
+	return nil
+}
@@ -41,6 +41,390 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "duplicate key name",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+					Keys: []APIKeyConfig{
+						{Name: "marketing", Token: "token-1", DailyQuota: 10000},
+						{Name: "marketing", Token: "token-2"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "batch larger than queue",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Queue: QueueConfig{
+					MaxSize: 50,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "key batch larger than queue",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+					Keys:      []APIKeyConfig{{Name: "bulk", Token: "bulk-token", MaxBatchSize: 20000}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max retry",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Queue: QueueConfig{
+					MaxRetry: -1,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "key without token",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+					Keys:      []APIKeyConfig{{Name: "marketing"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative banner delay",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname:    "mail.example.com",
+					BannerDelay: -time.Second,
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative batch size",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Queue: QueueConfig{
+					BatchSize: -1,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid local domain",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					LocalDomains: []string{"user@example.com"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid hostname",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail_server.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "egress probe target without port",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					EgressProbe: EgressProbeConfig{Targets: []string{"mx.example.com"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max hops",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					MaxHops: -1,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative shutdown timeout",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					ShutdownTimeout: -time.Second,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid domain limit",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					DomainLimits: map[string]string{"gmail.com": "ten a minute"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid reputation pattern",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					ReputationPatterns: []ReputationPattern{{Name: "Example RBL", Pattern: "rbl.example.net("}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid mta_sts mode",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					MTASTS: "strict",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid domain tls_policy",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					TLSPolicies: map[string]string{"example.com": "always"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid tls_min_version",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					TLSMinVersion: "1.4",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "verp without return_path domain",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					ReturnPath: ReturnPathConfig{VERP: true},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "verp without secret",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					ReturnPath: ReturnPathConfig{Domain: "bounces.example.com", VERP: true},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid return_path local_part",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					ReturnPath: ReturnPathConfig{Domain: "bounces.example.com", LocalPart: "bounce+verp"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid source_ips",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					SourceIPs: []string{"203.0.113.10", "mail.example.com"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid source_ip_strategy",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					SourceIPs:        []string{"203.0.113.10"},
+					SourceIPStrategy: "random",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "redirect without confirmation",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					RedirectAllTo: "qa@example.com",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "confirmed redirect",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					RedirectAllTo:   "qa@example.com",
+					RedirectConfirm: true,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "warm-up without a start date",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					Warmup: WarmupConfig{Schedule: []int{50, 100}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid ip_family",
+			config: &Config{
+				Server: ServerConfig{
+					Hostname: "mail.example.com",
+				},
+				API: APIConfig{
+					AuthToken: "test-token",
+				},
+				Delivery: DeliveryConfig{
+					IPFamily: "ipv5",
+				},
+			},
+			wantErr: true,
+		},
 	}
 	
 	for _, tt := range tests {
@@ -64,11 +448,39 @@ func TestConfig_Validate(t *testing.T) {
 				if tt.config.Delivery.Workers == 0 {
 					t.Error("Delivery.Workers should have default value")
 				}
+				if got := tt.config.Delivery.Identity.Hostname(); got != tt.config.Server.Hostname {
+					t.Errorf("Delivery's hostname should be %q, got %q", tt.config.Server.Hostname, got)
+				}
+				if tt.config.API.Identity != tt.config.Server.Identity {
+					t.Error("API should share the server's identity")
+				}
+				if tt.config.Server.Banner == "" {
+					t.Error("Server.Banner should have default value")
+				}
+				if tt.config.Delivery.IPFamily != "any" {
+					t.Errorf("Delivery.IPFamily should default to any, got %q", tt.config.Delivery.IPFamily)
+				}
+				if tt.config.Delivery.BatchSize != tt.config.Queue.BatchSize {
+					t.Errorf("Delivery.BatchSize should be %d, got %d", tt.config.Queue.BatchSize, tt.config.Delivery.BatchSize)
+				}
 			}
 		})
 	}
 }
 
+func TestConfig_NormalizeHostname(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{Hostname: "Mail.Example.COM."},
+		API:    APIConfig{AuthToken: "test-token"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Hostname != "mail.example.com" || cfg.Delivery.Identity.Hostname() != "mail.example.com" {
+		t.Errorf("Expected mail.example.com, got %q and %q", cfg.Server.Hostname, cfg.Delivery.Identity.Hostname())
+	}
+}
+
 func TestDefaultConfig(t *testing.T) {
 	cfg := DefaultConfig()
 	
@@ -95,4 +507,250 @@ func TestDefaultConfig(t *testing.T) {
 	if cfg.Limits.MaxMessageSize != 25*1024*1024 {
 		t.Errorf("Expected max message size 25MB, got %d", cfg.Limits.MaxMessageSize)
 	}
-}
\ No newline at end of file
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rate
+	}{
+		{"10/m", Rate{Count: 10, Per: time.Minute}},
+		{" 5 / second ", Rate{Count: 5, Per: time.Second}},
+		{"1000/Hour", Rate{Count: 1000, Per: time.Hour}},
+		{"20000/d", Rate{Count: 20000, Per: 24 * time.Hour}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRate(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	
+	for _, in := range []string{"", "10", "0/m", "-1/m", "10/week", "x/m"} {
+		if _, err := ParseRate(in); err == nil {
+			t.Errorf("ParseRate(%q) should fail", in)
+		}
+	}
+}
+
+func TestWindow_Next(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("No timezone data: %v", err)
+	}
+	window := func(w SendingWindow) Window {
+		t.Helper()
+		parsed, err := ParseWindow(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	day := window(SendingWindow{Start: "08:00", End: "20:00", Timezone: "America/New_York"})
+	weekdays := window(SendingWindow{Start: "08:00", End: "20:00", Timezone: "America/New_York", Days: []string{"mon", "tue", "wed", "thu", "fri"}})
+	night := window(SendingWindow{Start: "22:00", End: "06:00"})
+	fridayNight := window(SendingWindow{Start: "22:00", End: "06:00", Days: []string{"Fri"}})
+	
+	at := func(loc *time.Location, s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		name   string
+		window Window
+		at     time.Time
+		want   time.Time
+	}{
+		{name: "open", window: day, at: at(ny, "2024-06-05 12:00"), want: at(ny, "2024-06-05 12:00")},
+		{name: "before opening", window: day, at: at(ny, "2024-06-05 07:00"), want: at(ny, "2024-06-05 08:00")},
+		{name: "at closing", window: day, at: at(ny, "2024-06-05 20:00"), want: at(ny, "2024-06-06 08:00")},
+		{name: "given in UTC", window: day, at: at(time.UTC, "2024-06-05 01:00"), want: at(ny, "2024-06-05 08:00")},
+		{name: "into daylight saving", window: day, at: at(ny, "2024-03-09 21:00"), want: at(time.UTC, "2024-03-10 12:00")},
+		{name: "out of daylight saving", window: day, at: at(ny, "2024-11-02 21:00"), want: at(time.UTC, "2024-11-03 13:00")},
+		{name: "weekend", window: weekdays, at: at(ny, "2024-06-07 21:00"), want: at(ny, "2024-06-10 08:00")},
+		{name: "past midnight", window: night, at: at(time.UTC, "2024-06-05 03:00"), want: at(time.UTC, "2024-06-05 03:00")},
+		{name: "before an overnight window", window: night, at: at(time.UTC, "2024-06-05 12:00"), want: at(time.UTC, "2024-06-05 22:00")},
+		{name: "overnight from an allowed day", window: fridayNight, at: at(time.UTC, "2024-06-08 03:00"), want: at(time.UTC, "2024-06-08 03:00")},
+		{name: "overnight from another day", window: fridayNight, at: at(time.UTC, "2024-06-09 03:00"), want: at(time.UTC, "2024-06-14 22:00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Next(tt.at); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+	
+	for _, w := range []SendingWindow{
+		{Start: "8am", End: "20:00"},
+		{Start: "08:00", End: "08:00"},
+		{Start: "08:00", End: "20:00", Timezone: "Mars/Olympus_Mons"},
+		{Start: "08:00", End: "20:00", Days: []string{"someday"}},
+	} {
+		if _, err := ParseWindow(w); err == nil {
+			t.Errorf("ParseWindow(%+v) should fail", w)
+		}
+	}
+}
+
+func TestDNSServerAddress(t *testing.T) {
+	tests := []struct {
+		server  string
+		want    string
+		wantErr bool
+	}{
+		{server: "10.0.0.2", want: "10.0.0.2:53"},
+		{server: "10.0.0.2:5353", want: "10.0.0.2:5353"},
+		{server: "2001:db8::53", want: "[2001:db8::53]:53"},
+		{server: "[2001:db8::53]:53", want: "[2001:db8::53]:53"},
+		{server: "dns.internal", want: "dns.internal:53"},
+		{server: "udp://10.0.0.2", wantErr: true},
+	}
+	
+	for _, tt := range tests {
+		got, err := DNSServerAddress(tt.server)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("DNSServerAddress(%q) = %q, %v, want %q", tt.server, got, err, tt.want)
+		}
+	}
+}
+
+func TestDeliveryConfig_Routes(t *testing.T) {
+	relay := RelayConfig{Host: "relay.partner.com"}
+	tests := []struct {
+		name       string
+		routes     []RouteConfig
+		wantTarget string
+		wantErr    bool
+	}{
+		{name: "direct by default", routes: []RouteConfig{{Domain: "example.com"}}, wantTarget: "direct"},
+		{name: "host implies relay", routes: []RouteConfig{{Domain: "*.partner.com", Relay: relay}}, wantTarget: "relay"},
+		{name: "catch-all", routes: []RouteConfig{{Domain: "*", Target: "direct", TLSPolicy: "required"}}, wantTarget: "direct"},
+		{name: "missing domain", routes: []RouteConfig{{Target: "direct"}}, wantErr: true},
+		{name: "wildcard inside a domain", routes: []RouteConfig{{Domain: "mail.*.com"}}, wantErr: true},
+		{name: "duplicate domain", routes: []RouteConfig{{Domain: "example.com"}, {Domain: "EXAMPLE.com"}}, wantErr: true},
+		{name: "unknown target", routes: []RouteConfig{{Domain: "example.com", Target: "drop"}}, wantErr: true},
+		{name: "relay without host", routes: []RouteConfig{{Domain: "example.com", Target: "relay"}}, wantErr: true},
+		{name: "unknown tls_policy", routes: []RouteConfig{{Domain: "example.com", TLSPolicy: "always"}}, wantErr: true},
+		{name: "tls_policy on implicit TLS", routes: []RouteConfig{{Domain: "example.com", Relay: RelayConfig{Host: "relay.partner.com", TLS: "tls"}, TLSPolicy: "required"}}, wantErr: true},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:   ServerConfig{Hostname: "mail.example.com"},
+				API:      APIConfig{AuthToken: "test-token"},
+				Delivery: DeliveryConfig{Routes: tt.routes},
+			}
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := cfg.Delivery.Routes[0].Target; got != tt.wantTarget {
+				t.Errorf("Expected target %s, got %s", tt.wantTarget, got)
+			}
+		})
+	}
+}
+
+func TestDeliveryConfig_EgressProbe(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{Hostname: "mail.example.com"},
+		API:    APIConfig{AuthToken: "test-token"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if p := cfg.Delivery.EgressProbe; len(p.Targets) != len(DefaultEgressProbeTargets) || p.Timeout != 5*time.Second {
+		t.Errorf("Expected the default targets and timeout, got %+v", p)
+	}
+	
+	cfg.Delivery.EgressProbe = EgressProbeConfig{Skip: true, Targets: []string{"mx.example.com:25"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if p := cfg.Delivery.EgressProbe; len(p.Targets) != 0 {
+		t.Errorf("Expected no targets when skipped, got %v", p.Targets)
+	}
+}
+
+func TestDeliveryConfig_TransactionalWorkerRatio(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{Hostname: "mail.example.com"},
+		API:    APIConfig{AuthToken: "test-token"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if r := cfg.Delivery.TransactionalWorkerRatio; r == nil || *r != 0.25 {
+		t.Errorf("Expected the default ratio of 0.25 when unset, got %v", r)
+	}
+	
+	off := 0.0
+	cfg.Delivery.TransactionalWorkerRatio = &off
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if r := *cfg.Delivery.TransactionalWorkerRatio; r != 0 {
+		t.Errorf("Expected an explicit 0 to turn the reservation off, got %v", r)
+	}
+	
+	over := 1.5
+	cfg.Delivery.TransactionalWorkerRatio = &over
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected a ratio above 1 to be rejected")
+	}
+}
+
+func TestDeliveryConfig_Relay(t *testing.T) {
+	tests := []struct {
+		name     string
+		delivery DeliveryConfig
+		wantMode string
+		wantPort int
+		wantErr  bool
+		
+		wantMechanism string
+	}{
+		{name: "no relay", wantMode: "mx"},
+		{name: "host implies relay", delivery: DeliveryConfig{Relay: RelayConfig{Host: "smtp.example.com"}}, wantMode: "relay", wantPort: 587, wantMechanism: "XOAUTH2"},
+		{name: "implicit TLS port", delivery: DeliveryConfig{Relay: RelayConfig{Host: "smtp.example.com", TLS: "tls"}}, wantMode: "relay", wantPort: 465},
+		{name: "mx overrides relay", delivery: DeliveryConfig{Mode: "mx", Relay: RelayConfig{Host: "smtp.example.com"}}, wantMode: "mx"},
+		{name: "relay without host", delivery: DeliveryConfig{Mode: "relay"}, wantErr: true},
+		{name: "unknown mode", delivery: DeliveryConfig{Mode: "direct"}, wantErr: true},
+		{name: "unknown tls", delivery: DeliveryConfig{Relay: RelayConfig{Host: "smtp.example.com", TLS: "ssl"}}, wantErr: true},
+		{name: "bad port", delivery: DeliveryConfig{Relay: RelayConfig{Host: "smtp.example.com", Port: 70000}}, wantErr: true},
+		{name: "password in the clear", delivery: DeliveryConfig{Relay: RelayConfig{Host: "smtp.example.com", TLS: "none", Username: "user"}}, wantErr: true},
+		{name: "mechanisms", delivery: DeliveryConfig{Relay: RelayConfig{Host: "smtp.example.com", Mechanisms: []string{"cram-md5", "xoauth2"}}}, wantMode: "relay", wantPort: 587, wantMechanism: "CRAM-MD5"},
+		{name: "unknown mechanism", delivery: DeliveryConfig{Relay: RelayConfig{Host: "smtp.example.com", Mechanisms: []string{"GSSAPI"}}}, wantErr: true},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:   ServerConfig{Hostname: "mail.example.com"},
+				API:      APIConfig{AuthToken: "test-token"},
+				Delivery: tt.delivery,
+			}
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.Delivery.Mode != tt.wantMode || cfg.Delivery.Relay.Port != tt.wantPort {
+				t.Errorf("Expected mode %s on port %d, got %s on %d", tt.wantMode, tt.wantPort, cfg.Delivery.Mode, cfg.Delivery.Relay.Port)
+			}
+			if mechanisms := cfg.Delivery.Relay.Mechanisms; tt.wantMechanism != "" && (len(mechanisms) == 0 || mechanisms[0] != tt.wantMechanism) {
+				t.Errorf("Expected %s preferred, got %v", tt.wantMechanism, mechanisms)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is a number of events allowed per period, written as "10/m" or
+// "100/hour".
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+var ratePeriods = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+	"h": time.Hour, "hour": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour,
+}
+
+// ParseRate parses a rate such as "10/m". The count must be positive.
+func ParseRate(s string) (Rate, error) {
+	count, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q: want count/period, e.g. 10/m", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q: count must be a positive integer", s)
+	}
+	per, ok := ratePeriods[strings.ToLower(strings.TrimSpace(period))]
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q: period must be s, m, h or d", s)
+	}
+	return Rate{Count: n, Per: per}, nil
+}
+
+// PerSecond returns the rate in events per second.
+func (r Rate) PerSecond() float64 {
+	return float64(r.Count) / r.Per.Seconds()
+}
+
+func (r Rate) String() string {
+	switch r.Per {
+	case time.Second:
+		return fmt.Sprintf("%d/s", r.Count)
+	case time.Minute:
+		return fmt.Sprintf("%d/m", r.Count)
+	case time.Hour:
+		return fmt.Sprintf("%d/h", r.Count)
+	}
+	return fmt.Sprintf("%d/d", r.Count)
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SendingWindow limits when mail in Lane is delivered: from Start to End
+// each day, clock times such as "08:00" in Timezone (default UTC), on Days
+// ("mon" to "sun") if set, otherwise every day. A window that ends before
+// it starts runs past midnight, and Days are the days it starts on.
+type SendingWindow struct {
+	Lane     string   `yaml:"lane"`
+	Start    string   `yaml:"start"`
+	End      string   `yaml:"end"`
+	Timezone string   `yaml:"timezone"`
+	Days     []string `yaml:"days"`
+}
+
+// Window is a parsed SendingWindow. Start and End are minutes after
+// midnight, and Days is indexed by time.Weekday.
+type Window struct {
+	Start    int
+	End      int
+	Location *time.Location
+	Days     [7]bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseWindow parses w's times, timezone and days.
+func ParseWindow(w SendingWindow) (Window, error) {
+	var parsed Window
+	var err error
+	if parsed.Start, err = parseClock(w.Start); err != nil {
+		return Window{}, fmt.Errorf("invalid start: %w", err)
+	}
+	if parsed.End, err = parseClock(w.End); err != nil {
+		return Window{}, fmt.Errorf("invalid end: %w", err)
+	}
+	if parsed.Start == parsed.End {
+		return Window{}, fmt.Errorf("start and end must differ")
+	}
+	
+	parsed.Location = time.UTC
+	if w.Timezone != "" {
+		if parsed.Location, err = time.LoadLocation(w.Timezone); err != nil {
+			return Window{}, fmt.Errorf("invalid timezone %q: %w", w.Timezone, err)
+		}
+	}
+	
+	for _, day := range w.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return Window{}, fmt.Errorf("invalid day %q: want mon to sun", day)
+		}
+		parsed.Days[weekday] = true
+	}
+	if len(w.Days) == 0 {
+		for i := range parsed.Days {
+			parsed.Days[i] = true
+		}
+	}
+	return parsed, nil
+}
+
+// parseClock returns the minutes after midnight of a time such as "08:00".
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time such as 08:00", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Next returns t if the window is open at t, and otherwise when it next
+// opens. Clock times are taken on each local day, so across a daylight
+// saving change the window keeps its local hours.
+func (w Window) Next(t time.Time) time.Time {
+	y, m, d := t.In(w.Location).Date()
+	var next time.Time
+	// The window that started yesterday may still be open
+	for i := -1; i <= 7; i++ {
+		start := time.Date(y, m, d+i, 0, w.Start, 0, 0, w.Location)
+		if !w.Days[start.Weekday()] {
+			continue
+		}
+		endDay := d + i
+		if w.End < w.Start {
+			endDay++
+		}
+		end := time.Date(y, m, endDay, 0, w.End, 0, 0, w.Location)
+		if !t.Before(start) && t.Before(end) {
+			return t
+		}
+		if start.After(t) && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+	return next
+}
@@ -0,0 +1,228 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// Transaction is one SMTP transaction of a delivery attempt: the MX host
+// it was sent to, whether the connection was upgraded with STARTTLS, and
+// the error, if any. Outcome is OutcomeGreetingDeferred when the host
+// refused the connection in its greeting.
+type Transaction struct {
+	Host    string   `json:"host"`
+	Rcpts   []string `json:"rcpts"`
+	TLS     bool     `json:"tls"`
+	Error   string   `json:"error,omitempty"`
+	Outcome string   `json:"outcome,omitempty"`
+	
+	// The TLS policy the connection was opened under, such as "required"
+	TLSPolicy string `json:"tls_policy,omitempty"`
+	
+	// The local address the connection was made from
+	SourceIP string `json:"source_ip,omitempty"`
+	
+	// The address of the host the connection was made to
+	RemoteIP string `json:"remote_ip,omitempty"`
+}
+
+// OutcomeGreetingDeferred marks a transaction the host refused with a 4xx
+// greeting.
+const OutcomeGreetingDeferred = "greeting-deferred"
+
+// attempt collects what happens during one delivery attempt. It travels
+// in the attempt's context, so the client can note TLS on the current
+// transaction. history is the transactions as recorded in the email's
+// Attempts.
+type attempt struct {
+	mu      sync.Mutex
+	txns    []Transaction
+	history []email.Attempt
+	log     []string
+	
+	// Set for an email sent with Debug, whose transactions are each
+	// recorded in a transcript
+	debug bool
+}
+
+type attemptKey struct{}
+
+// withAttempt returns ctx carrying a new attempt. A diagnostic email's
+// attempt also keeps every log line.
+func withAttempt(ctx context.Context, e *email.Email) (context.Context, *attempt) {
+	a := &attempt{debug: e.Debug}
+	ctx = context.WithValue(ctx, attemptKey{}, a)
+	if e.Diagnostic {
+		ctx = logctx.WithRecorder(ctx, a.record)
+	}
+	return ctx, a
+}
+
+func attemptFrom(ctx context.Context) *attempt {
+	a, _ := ctx.Value(attemptKey{}).(*attempt)
+	return a
+}
+
+func (a *attempt) record(line string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.log = append(a.log, line)
+}
+
+// markSession notes on the current transaction whether its connection s
+// uses TLS, under which policy, and the addresses it was made from and
+// to.
+func markSession(ctx context.Context, s *session) {
+	a := attemptFrom(ctx)
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n := len(a.txns); n > 0 {
+		a.txns[n-1].TLS = s.tls
+		a.txns[n-1].TLSPolicy = s.policy
+		noteAddrs(&a.txns[n-1], s.conn)
+	}
+}
+
+// markConn notes on the current transaction the addresses of conn before
+// a session is started on it, so they are known even if the host refuses
+// the session.
+func markConn(ctx context.Context, conn net.Conn) {
+	a := attemptFrom(ctx)
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n := len(a.txns); n > 0 {
+		noteAddrs(&a.txns[n-1], conn)
+	}
+}
+
+func noteAddrs(txn *Transaction, conn net.Conn) {
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		txn.SourceIP = addr.IP.String()
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		txn.RemoteIP = addr.IP.String()
+	}
+}
+
+// transactions returns a copy of the transactions so far.
+func (a *attempt) transactions() []Transaction {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Transaction(nil), a.txns...)
+}
+
+// attempts returns the transactions so far as history entries for the
+// email's delivery attempt number.
+func (a *attempt) attempts(number int) []email.Attempt {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	attempts := append([]email.Attempt(nil), a.history...)
+	for i := range attempts {
+		attempts[i].Number = number
+	}
+	return attempts
+}
+
+func (a *attempt) lines() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.log...)
+}
+
+// transact runs one SMTP transaction to host through send, within the
+// connection timeout, and records it in the attempt. A refusal because of
+// the sending IP's reputation is returned as a deferred reputationError.
+func (s *Service) transact(ctx context.Context, host string, rcpts []string, send func(context.Context) error) error {
+	start := time.Now()
+	a := attemptFrom(ctx)
+	if a != nil {
+		a.mu.Lock()
+		a.txns = append(a.txns, Transaction{Host: host, Rcpts: rcpts})
+		a.mu.Unlock()
+	}
+	
+	deliveryCtx, cancel := context.WithTimeout(ctx, s.config.ConnectionTimeout)
+	var t *transcript
+	if a != nil && a.debug {
+		t = &transcript{}
+		deliveryCtx = withTranscript(deliveryCtx, t)
+	}
+	err := send(deliveryCtx)
+	cancel()
+	if err != nil {
+		err = s.reputation.check(host, rcpts, err)
+	}
+	
+	if a == nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	txn := &a.txns[len(a.txns)-1]
+	if err != nil {
+		txn.Error = err.Error()
+		if isGreetingDeferral(err) {
+			txn.Outcome = OutcomeGreetingDeferred
+		}
+	}
+	entry := historyEntry(txn, start, err)
+	if t != nil {
+		entry.Transcript = strings.Join(t.recorded(), "\n")
+	}
+	a.history = append(a.history, entry)
+	return err
+}
+
+// historyEntry describes txn, started at start and ended with err, as an
+// entry in the email's Attempts.
+func historyEntry(txn *Transaction, start time.Time, err error) email.Attempt {
+	entry := email.Attempt{
+		At:         start,
+		Host:       txn.Host,
+		IP:         txn.RemoteIP,
+		Result:     email.AttemptDelivered,
+		DurationMS: time.Since(start).Milliseconds(),
+		TLS:        txn.TLS,
+	}
+	if err == nil {
+		return entry
+	}
+	if failure := Classify(err, 0); failure.Code != 0 {
+		entry.Result = email.AttemptSMTPError
+		entry.Code = failure.Code
+		entry.Text = failure.Text
+	} else {
+		entry.Result = email.AttemptNetworkError
+		entry.Text = err.Error()
+	}
+	return entry
+}
+
+// recordAttempts adds the transactions of e's attempt a to its history in
+// the queue, if the queue keeps one.
+func (s *Service) recordAttempts(ctx context.Context, e *email.Email, a *attempt) {
+	attempts := a.attempts(e.RetryCount + 1)
+	if len(attempts) == 0 {
+		return
+	}
+	recorder, ok := s.queue.(queue.AttemptRecorder)
+	if !ok {
+		return
+	}
+	if err := recorder.RecordAttempts(ctx, e.ID, attempts); err != nil {
+		logctx.Printf(ctx, "Failed to record delivery attempts: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestDeliveryService_AttemptHistory(t *testing.T) {
+	ctx := context.Background()
+	busy := newBusyServer(t)
+	sink := newSinkServer(t, false)
+	
+	// A port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+	
+	q := queue.NewMemoryQueue(10)
+	var result Result
+	newService := func(mx ...*net.MX) *Service {
+		service := NewService(&config.DeliveryConfig{
+			Workers:           1,
+			DNSCacheTTL:       5 * time.Minute,
+			ConnectionTimeout: 5 * time.Second,
+			MaxRetry:          3,
+		}, q)
+		service.resolver = &mockDNSResolver{mx: map[string][]*net.MX{"example.com": mx}}
+		service.SetResultHook(func(r Result) { result = r })
+		return service
+	}
+	service := newService(&net.MX{Host: busy.addr(), Pref: 10}, &net.MX{Host: closed, Pref: 20})
+	
+	q.Enqueue(ctx, &email.Email{
+		ID:     "history-1",
+		From:   "sender@test.com",
+		To:     []string{"rcpt@example.com"},
+		Status: email.StatusQueued,
+	})
+	emails, _ := q.Dequeue(ctx, 1)
+	service.deliver(ctx, emails[0])
+	
+	e, err := q.Get(ctx, "history-1")
+	if err != nil {
+		t.Fatalf("Expected the email queued for retry, got %v", err)
+	}
+	if len(e.Attempts) != 2 {
+		t.Fatalf("Expected both MX hosts in the history, got %+v", e.Attempts)
+	}
+	first, second := e.Attempts[0], e.Attempts[1]
+	if first.Number != 1 || first.Host != busy.addr() || first.IP != "127.0.0.1" || first.Result != email.AttemptSMTPError || first.Code != 421 {
+		t.Errorf("Expected the busy host's 421, got %+v", first)
+	}
+	if second.Host != closed || second.Result != email.AttemptNetworkError || second.Code != 0 || second.Text == "" {
+		t.Errorf("Expected a network error from the closed port, got %+v", second)
+	}
+	if first.At.IsZero() || second.At.Before(first.At) {
+		t.Errorf("Expected the attempts in order, got %v then %v", first.At, second.At)
+	}
+	
+	// The next attempt is added to the history and reported
+	newService(&net.MX{Host: sink.addr(), Pref: 10}).deliver(ctx, e)
+	
+	if result.Status != email.StatusDelivered || len(result.Attempts) != 1 {
+		t.Fatalf("Expected one delivered transaction reported, got %s %+v", result.Status, result.Attempts)
+	}
+	if got := result.Attempts[0]; got.Number != 2 || got.Host != sink.addr() || got.Result != email.AttemptDelivered || got.TLS {
+		t.Errorf("Expected delivery on the second attempt, got %+v", got)
+	}
+}
@@ -0,0 +1,145 @@
+package delivery
+
+import (
+	"context"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// SessionClient is implemented by SMTP clients that can hold one session
+// open for several transactions in a row, so a worker can send a batch of
+// emails for the same domain over a single connection.
+type SessionClient interface {
+	OpenSession(ctx context.Context, host string) (Session, error)
+}
+
+// Session is an open SMTP session. Send runs one MAIL/RCPT/DATA
+// transaction; Close ends the session.
+type Session interface {
+	Send(ctx context.Context, e *email.Email, rcpts []string) error
+	Close() error
+}
+
+// domainBatch is a run of dequeued emails bound for the same domain.
+// Emails to several domains, and those that race their MX hosts, are
+// batches of their own with no domain.
+type domainBatch struct {
+	domain string
+	emails []*email.Email
+}
+
+// batchByDomain groups emails by the one domain their outstanding
+// recipients are in, keeping the order in which each domain first appears.
+func batchByDomain(emails []*email.Email) []domainBatch {
+	var batches []domainBatch
+	index := make(map[string]int)
+	for _, e := range emails {
+		domain := destination(e)
+		if domain == "" || e.RaceMX {
+			batches = append(batches, domainBatch{emails: []*email.Email{e}})
+			continue
+		}
+		i, ok := index[domain]
+		if !ok {
+			i = len(batches)
+			index[domain] = i
+			batches = append(batches, domainBatch{domain: domain})
+		}
+		batches[i].emails = append(batches[i].emails, e)
+	}
+	return batches
+}
+
+// destination returns the domain of e's outstanding recipients, or "" if
+// they are spread over several.
+func destination(e *email.Email) string {
+	groups, err := groupRecipients(e)
+	if err != nil || len(groups) != 1 {
+		return ""
+	}
+	return groups[0].domain
+}
+
+// deliverBatch delivers a batch, each email recorded individually, sharing
+// one SMTP session among the batch's emails where the client allows.
+func (s *Service) deliverBatch(ctx context.Context, b domainBatch) {
+	client, ok := s.client.(SessionClient)
+	if !ok || b.domain == "" || len(b.emails) < 2 {
+		for _, e := range b.emails {
+			s.deliver(ctx, e)
+		}
+		return
+	}
+	
+	bs := &batchSession{domain: b.domain, client: client, fallback: s.client}
+	defer bs.close()
+	ctx = context.WithValue(ctx, batchKey{}, bs)
+	for _, e := range b.emails {
+		s.deliver(ctx, e)
+	}
+}
+
+type batchKey struct{}
+
+// batchSession is the SMTP session shared by a batch. It is opened by the
+// batch's first transaction and used for every later one to the same
+// host. Once the server drops it, the remaining emails are sent one
+// connection each.
+type batchSession struct {
+	domain   string
+	client   SessionClient
+	fallback SMTPClient
+	
+	session Session
+	host    string
+	sent    int
+	dropped bool
+}
+
+// send sends e to host for domain, over the batch's session if there is
+// one for them in ctx, or to the sandbox if e is sandboxed. An email sent
+// with Debug gets a session of its own so its transcript is complete.
+func (s *Service) send(ctx context.Context, host, domain string, e *email.Email, rcpts []string) error {
+	if s.sandboxed(e) {
+		return s.sandbox.Send(ctx, host, e, rcpts)
+	}
+	bs, _ := ctx.Value(batchKey{}).(*batchSession)
+	if bs == nil || bs.domain != domain || e.Debug {
+		return s.client.Send(ctx, host, e, rcpts)
+	}
+	return bs.send(ctx, host, e, rcpts)
+}
+
+func (bs *batchSession) send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	if bs.dropped || (bs.session != nil && bs.host != host) {
+		return bs.fallback.Send(ctx, host, e, rcpts)
+	}
+	if bs.session == nil {
+		session, err := bs.client.OpenSession(ctx, host)
+		if err != nil {
+			return err
+		}
+		bs.session, bs.host = session, host
+	}
+	
+	err := bs.session.Send(ctx, e, rcpts)
+	if err != nil && !reusable(err) {
+		bs.dropped = true
+		bs.close()
+		if bs.sent > 0 {
+			logctx.Printf(ctx, "Batch session with %s dropped after %d emails, sending the rest individually: %v", host, bs.sent, err)
+			return bs.fallback.Send(ctx, host, e, rcpts)
+		}
+		return err
+	}
+	bs.sent++
+	return err
+}
+
+func (bs *batchSession) close() {
+	if bs.session != nil {
+		bs.session.Close()
+		bs.session = nil
+	}
+}
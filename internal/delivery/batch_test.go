@@ -0,0 +1,112 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestBatchByDomain(t *testing.T) {
+	emails := []*email.Email{
+		{ID: "1", To: []string{"a@one.com"}},
+		{ID: "2", To: []string{"a@two.com"}},
+		{ID: "3", To: []string{"b@One.com"}},
+		{ID: "4", To: []string{"a@one.com", "a@two.com"}},
+		{ID: "5", To: []string{"c@one.com"}, RaceMX: true},
+		{
+			ID:              "6",
+			To:              []string{"a@two.com", "d@one.com"},
+			RecipientStatus: map[string]email.RecipientStatus{"a@two.com": {Status: email.StatusDelivered}},
+		},
+	}
+	
+	var got []string
+	for _, b := range batchByDomain(emails) {
+		ids := ""
+		for _, e := range b.emails {
+			ids += e.ID
+		}
+		got = append(got, b.domain+":"+ids)
+	}
+	want := []string{"one.com:136", "two.com:2", ":4", ":5"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected batches %v, got %v", want, got)
+	}
+}
+
+// newBatchService returns a service delivering example.com mail to sink
+// with a real SMTP client, pooling up to poolSize sessions, and a queue
+// holding n emails for example.com.
+func newBatchService(t *testing.T, sink *sinkServer, poolSize, n int) (*Service, *mockQueue) {
+	t.Helper()
+	cfg := &config.DeliveryConfig{
+		Workers:            1,
+		DNSCacheTTL:        5 * time.Minute,
+		ConnectionTimeout:  5 * time.Second,
+		ConnectionPoolSize: poolSize,
+	}
+	q := newMockQueue()
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: sink.addr(), Pref: 10}},
+		},
+	}
+	service.client = newClient(cfg)
+	
+	for i := 0; i < n; i++ {
+		q.Enqueue(context.Background(), &email.Email{
+			ID:      fmt.Sprintf("batch-%d", i),
+			From:    "sender@test.com",
+			To:      []string{fmt.Sprintf("rcpt%d@example.com", i)},
+			Subject: "Batched",
+			Body:    "Body",
+			Status:  email.StatusQueued,
+		})
+	}
+	return service, q
+}
+
+func TestDeliveryService_BatchSharesSession(t *testing.T) {
+	sink := newSinkServer(t, false)
+	service, q := newBatchService(t, sink, 0, 5)
+	
+	if n := service.poll(context.Background(), 0, ""); n != 5 {
+		t.Fatalf("Expected 5 emails polled, got %d", n)
+	}
+	
+	if got := sink.messages.Load(); got != 5 {
+		t.Errorf("Expected 5 messages, got %d", got)
+	}
+	if got := sink.dials.Load(); got != 1 {
+		t.Errorf("Expected 1 connection for the batch, got %d", got)
+	}
+	if len(q.delivered) != 5 {
+		t.Errorf("Expected 5 emails marked delivered, got %d (failed: %v)", len(q.delivered), q.failed)
+	}
+	waitFor(t, func() bool { return sink.quits.Load() == 1 })
+}
+
+func TestDeliveryService_BatchFallsBackWhenDropped(t *testing.T) {
+	// The sink drops each connection after one message, so individual
+	// sends go through the pool, which does not wait for QUIT
+	sink := newSinkServer(t, true)
+	service, q := newBatchService(t, sink, 10, 5)
+	
+	service.poll(context.Background(), 0, "")
+	
+	if got := sink.messages.Load(); got != 5 {
+		t.Errorf("Expected 5 messages, got %d", got)
+	}
+	if got := sink.dials.Load(); got != 5 {
+		t.Errorf("Expected a connection per email after the drop, got %d", got)
+	}
+	if len(q.delivered) != 5 || len(q.failed) != 0 {
+		t.Errorf("Expected 5 emails delivered, got %d delivered and failures %v", len(q.delivered), q.failed)
+	}
+}
@@ -0,0 +1,44 @@
+package delivery
+
+import "time"
+
+// defaultBatchSize is how many emails a worker dequeues at once when no
+// batch size is configured.
+const defaultBatchSize = 10
+
+// batchTarget is how long a worker's batch should take to deliver. Emails
+// wait claimed by a worker until their turn in its batch, so batches that
+// run longer shrink and full ones that finish well within it grow.
+var batchTarget = 10 * time.Second
+
+// batchSizer adapts how many emails a worker dequeues at once, between one
+// and the configured batch size. It starts at defaultBatchSize, or the
+// configured size if smaller.
+type batchSizer struct {
+	size int
+	max  int
+}
+
+func newBatchSizer(limit int) *batchSizer {
+	return &batchSizer{size: min(defaultBatchSize, limit), max: limit}
+}
+
+// observe adjusts the size after a batch of n emails took elapsed to
+// deliver: halving it if the batch overran batchTarget, and doubling it
+// if the batch was full, so more is probably waiting, and quick.
+func (b *batchSizer) observe(n int, elapsed time.Duration) {
+	switch {
+	case elapsed > batchTarget:
+		b.size = max(b.size/2, 1)
+	case n == b.size && elapsed < batchTarget/2:
+		b.size = min(b.size*2, b.max)
+	}
+}
+
+// batchSize is the most emails a worker dequeues at once.
+func (s *Service) batchSize() int {
+	if s.config.BatchSize > 0 {
+		return s.config.BatchSize
+	}
+	return defaultBatchSize
+}
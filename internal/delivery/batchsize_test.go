@@ -0,0 +1,74 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestBatchSizer(t *testing.T) {
+	b := newBatchSizer(25)
+	if b.size != 10 {
+		t.Fatalf("Expected to start at 10, got %d", b.size)
+	}
+	
+	// Full, quick batches grow up to the configured size
+	b.observe(10, time.Second)
+	b.observe(20, time.Second)
+	if b.size != 25 {
+		t.Errorf("Expected to grow to 25, got %d", b.size)
+	}
+	
+	// A part-full batch suggests the queue is short, so stays the same
+	b.observe(3, time.Second)
+	if b.size != 25 {
+		t.Errorf("Expected to stay at 25, got %d", b.size)
+	}
+	
+	// Slow batches shrink, down to one
+	for i := 0; i < 6; i++ {
+		b.observe(b.size, batchTarget+time.Second)
+	}
+	if b.size != 1 {
+		t.Errorf("Expected to shrink to 1, got %d", b.size)
+	}
+	
+	if b := newBatchSizer(4); b.size != 4 {
+		t.Errorf("Expected to start at the configured size, got %d", b.size)
+	}
+}
+
+func TestDeliveryService_BatchSize(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(20)
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		BatchSize:         3,
+	}, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{"example.com": {{Host: "mail.example.com", Pref: 10}}},
+	}
+	client := &mockSMTPClient{}
+	service.client = client
+	
+	for i := 0; i < 5; i++ {
+		e := &email.Email{ID: fmt.Sprintf("test-%d", i), From: "sender@test.com", To: []string{"rcpt@example.com"}}
+		if err := q.Enqueue(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := service.poll(ctx, 0, ""); n != 3 {
+		t.Errorf("Expected a batch of 3, took %d", n)
+	}
+	if len(client.sent) != 3 || q.Size() != 2 {
+		t.Errorf("Expected 3 sent and 2 left, got %d sent and %d left", len(client.sent), q.Size())
+	}
+}
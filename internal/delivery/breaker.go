@@ -0,0 +1,279 @@
+package delivery
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// probeWaitDelay is how long emails are put back for while another email
+// is probing a domain whose circuit has cooled down.
+const probeWaitDelay = 5 * time.Second
+
+// Circuit states reported in BreakerState
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// BreakerState is the circuit breaker state of one recipient domain.
+type BreakerState struct {
+	Domain string `json:"domain"`
+	State  string `json:"state"`
+	
+	// Consecutive failures counted towards opening the circuit
+	Failures int `json:"failures"`
+	
+	// Times the circuit has opened since the server started
+	Opened   int64      `json:"opened"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	RetryAt  *time.Time `json:"retry_at,omitempty"`
+}
+
+// domainBreakers stops sending to a recipient domain after threshold
+// consecutive failures within window. Mail for the domain is held for
+// cooldown, then one email is let through as a probe: if it gets an answer
+// the circuit closes, and if not it stays open for another cooldown.
+type domainBreakers struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state    string
+	failures []time.Time
+	opened   int64
+	openedAt time.Time
+	
+	// While half-open, when the probe in flight is given up on
+	probeUntil time.Time
+}
+
+// newDomainBreakers returns the breakers configured by cfg, or nil if the
+// threshold is zero or negative.
+func newDomainBreakers(cfg *config.DeliveryConfig) *domainBreakers {
+	if cfg.BreakerThreshold <= 0 {
+		return nil
+	}
+	return &domainBreakers{
+		threshold: cfg.BreakerThreshold,
+		window:    cfg.BreakerWindow,
+		cooldown:  cfg.BreakerCooldown,
+		circuits:  make(map[string]*circuit),
+	}
+}
+
+// allow reports whether mail may be sent to all of domains now. If one
+// is held, it returns that domain and how long until it may be tried, and
+// takes no probes. Otherwise the email becomes the probe for any domain
+// whose circuit has cooled down.
+func (b *domainBreakers) allow(ctx context.Context, domains []string, now time.Time) (string, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	
+	var probes []string
+	for _, domain := range domains {
+		c := b.circuits[domain]
+		if c == nil {
+			continue
+		}
+		switch {
+		case c.state == CircuitOpen && now.Before(c.openedAt.Add(b.cooldown)):
+			return domain, c.openedAt.Add(b.cooldown).Sub(now)
+		case c.state == CircuitHalfOpen && now.Before(c.probeUntil):
+			return domain, probeWaitDelay
+		case c.state != CircuitClosed:
+			probes = append(probes, domain)
+		}
+	}
+	for _, domain := range probes {
+		c := b.circuits[domain]
+		if c.state == CircuitOpen {
+			logctx.Printf(ctx, "Circuit for %s half-open, sending a probe", domain)
+		}
+		c.state = CircuitHalfOpen
+		c.probeUntil = now.Add(b.cooldown)
+	}
+	return "", 0
+}
+
+// success closes domain's circuit after a server answered.
+func (b *domainBreakers) success(ctx context.Context, domain string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	
+	c := b.circuits[domain]
+	if c == nil {
+		return
+	}
+	if c.state != CircuitClosed {
+		logctx.Printf(ctx, "Circuit for %s closed, delivery recovered", domain)
+	}
+	if c.opened == 0 {
+		delete(b.circuits, domain)
+		return
+	}
+	c.state = CircuitClosed
+	c.failures = nil
+}
+
+// failure counts a failed attempt to domain, opening its circuit when
+// that makes threshold in a row within window, or reopening it when the
+// attempt was a probe.
+func (b *domainBreakers) failure(ctx context.Context, domain string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	
+	c := b.circuit(domain, now)
+	switch c.state {
+	case CircuitOpen:
+		// An attempt that started before the circuit opened
+		return
+	case CircuitHalfOpen:
+		logctx.Printf(ctx, "Probe to %s failed, circuit open for another %s", domain, b.cooldown)
+		b.open(c, now)
+		return
+	}
+	
+	recent := c.failures[:0]
+	for _, t := range c.failures {
+		if now.Sub(t) < b.window {
+			recent = append(recent, t)
+		}
+	}
+	c.failures = append(recent, now)
+	if len(c.failures) >= b.threshold {
+		logctx.Printf(ctx, "Circuit for %s open after %d consecutive failures, holding mail for %s", domain, len(c.failures), b.cooldown)
+		b.open(c, now)
+	}
+}
+
+// trip opens domain's circuit at once, however many failures it has
+// counted.
+func (b *domainBreakers) trip(ctx context.Context, domain string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	
+	c := b.circuit(domain, now)
+	if c.state == CircuitOpen {
+		return
+	}
+	logctx.Printf(ctx, "Circuit for %s open, sending IP refused, holding mail for %s", domain, b.cooldown)
+	b.open(c, now)
+}
+
+// circuit returns domain's circuit, creating a closed one if it has none.
+// Callers must hold b.mu.
+func (b *domainBreakers) circuit(domain string, now time.Time) *circuit {
+	c := b.circuits[domain]
+	if c == nil {
+		if len(b.circuits) >= maxIdleBuckets {
+			b.forgetStale(now)
+		}
+		c = &circuit{state: CircuitClosed}
+		b.circuits[domain] = c
+	}
+	return c
+}
+
+// open opens c at now. Callers must hold b.mu.
+func (b *domainBreakers) open(c *circuit, now time.Time) {
+	c.state = CircuitOpen
+	c.openedAt = now
+	c.opened++
+	c.failures = nil
+}
+
+// forgetStale drops closed circuits with no failures inside the window,
+// which a new circuit would be the same as. Callers must hold b.mu.
+func (b *domainBreakers) forgetStale(now time.Time) {
+	for domain, c := range b.circuits {
+		if c.state != CircuitClosed {
+			continue
+		}
+		if n := len(c.failures); n == 0 || now.Sub(c.failures[n-1]) >= b.window {
+			delete(b.circuits, domain)
+		}
+	}
+}
+
+func (b *domainBreakers) stats() []BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	
+	stats := make([]BreakerState, 0, len(b.circuits))
+	for domain, c := range b.circuits {
+		st := BreakerState{
+			Domain:   domain,
+			State:    c.state,
+			Failures: len(c.failures),
+			Opened:   c.opened,
+		}
+		if c.state != CircuitClosed {
+			openedAt, retryAt := c.openedAt, c.openedAt.Add(b.cooldown)
+			st.OpenedAt, st.RetryAt = &openedAt, &retryAt
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Domain < stats[j].Domain })
+	return stats
+}
+
+// BreakerStats reports the circuit breaker state of each recipient domain
+// that has failed recently, sorted by domain. It is empty when the
+// breaker is disabled.
+func (s *Service) BreakerStats() []BreakerState {
+	if s.breakers == nil {
+		return nil
+	}
+	return s.breakers.stats()
+}
+
+// circuitOpen checks e's recipient domains against their circuit
+// breakers. If one is open it puts e back in the queue until the domain
+// may be tried again, without an attempt, and returns true.
+func (s *Service) circuitOpen(ctx, resultCtx context.Context, e *email.Email) bool {
+	if s.breakers == nil {
+		return false
+	}
+	domains, ok := pendingDomains(e)
+	if !ok {
+		return false
+	}
+	domain, wait := s.breakers.allow(ctx, domains, time.Now())
+	if domain == "" {
+		return false
+	}
+	wait = max(wait, minThrottleDelay)
+	logctx.Printf(ctx, "Circuit for %s open, holding email for %s", domain, wait.Round(time.Millisecond))
+	s.postponeEmail(resultCtx, e, "circuit for "+domain+" open", wait)
+	return true
+}
+
+// recordCircuit counts the outcome of an attempt to domain towards its
+// circuit breaker. Any answer from a server counts as success, including
+// refused recipients; no answer, or a temporary error reply, counts as a
+// failure. A refusal because of the sending IP's reputation opens the
+// circuit at once, since every email would meet it. Attempts cut short by
+// shutdown and domains that do not exist do not count.
+func (s *Service) recordCircuit(ctx context.Context, domain string, err error) {
+	switch {
+	case s.breakers == nil, ctx.Err() != nil:
+	case isReputationBlock(err):
+		s.breakers.trip(ctx, domain, time.Now())
+	case hostAnswered(err):
+		s.breakers.success(ctx, domain)
+	case !permanent(err):
+		s.breakers.failure(ctx, domain, time.Now())
+	}
+}
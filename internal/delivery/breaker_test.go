@@ -0,0 +1,140 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestDomainBreakers(t *testing.T) {
+	ctx := context.Background()
+	b := newDomainBreakers(&config.DeliveryConfig{
+		BreakerThreshold: 3,
+		BreakerWindow:    time.Minute,
+		BreakerCooldown:  30 * time.Second,
+	})
+	now := time.Now()
+	
+	// Failures spread wider than the window do not add up
+	b.failure(ctx, "down.com", now)
+	b.failure(ctx, "down.com", now.Add(2*time.Minute))
+	b.failure(ctx, "down.com", now.Add(2*time.Minute+time.Second))
+	if domain, _ := b.allow(ctx, []string{"down.com"}, now.Add(2*time.Minute+time.Second)); domain != "" {
+		t.Fatal("Expected circuit to stay closed for failures outside the window")
+	}
+	
+	// A success resets the count
+	b.success(ctx, "down.com")
+	now = now.Add(5 * time.Minute)
+	for i := 0; i < 2; i++ {
+		b.failure(ctx, "down.com", now)
+	}
+	if domain, _ := b.allow(ctx, []string{"down.com"}, now); domain != "" {
+		t.Fatal("Expected circuit closed after a success reset the count")
+	}
+	b.failure(ctx, "down.com", now)
+	
+	domain, wait := b.allow(ctx, []string{"up.com", "down.com"}, now.Add(10*time.Second))
+	if domain != "down.com" || wait != 20*time.Second {
+		t.Fatalf("Expected down.com held for 20s, got %q for %s", domain, wait)
+	}
+	
+	// Other domains are unaffected
+	if domain, _ := b.allow(ctx, []string{"up.com"}, now); domain != "" {
+		t.Fatalf("Expected up.com allowed, got %q", domain)
+	}
+	
+	// After the cooldown one email probes; others wait for it
+	now = now.Add(30 * time.Second)
+	if domain, _ := b.allow(ctx, []string{"down.com"}, now); domain != "" {
+		t.Fatal("Expected a probe allowed after the cooldown")
+	}
+	if domain, wait := b.allow(ctx, []string{"down.com"}, now); domain != "down.com" || wait != probeWaitDelay {
+		t.Fatalf("Expected others held while the probe is in flight, got %q for %s", domain, wait)
+	}
+	
+	// A failed probe reopens the circuit
+	b.failure(ctx, "down.com", now)
+	stats := b.stats()
+	if len(stats) != 1 || stats[0].State != CircuitOpen || stats[0].Opened != 2 || stats[0].RetryAt == nil || !stats[0].RetryAt.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("Expected down.com open a second time, got %+v", stats)
+	}
+	
+	// A probe that never reports back is given up on after a cooldown
+	now = now.Add(30 * time.Second)
+	b.allow(ctx, []string{"down.com"}, now)
+	if domain, _ := b.allow(ctx, []string{"down.com"}, now.Add(30*time.Second)); domain != "" {
+		t.Fatal("Expected a new probe once the lost one expired")
+	}
+	
+	b.success(ctx, "down.com")
+	stats = b.stats()
+	if len(stats) != 1 || stats[0].State != CircuitClosed || stats[0].Failures != 0 || stats[0].OpenedAt != nil {
+		t.Fatalf("Expected down.com closed after a successful probe, got %+v", stats)
+	}
+	
+	if newDomainBreakers(&config.DeliveryConfig{BreakerThreshold: -1}) != nil {
+		t.Error("Expected no breakers with a negative threshold")
+	}
+}
+
+func TestDeliveryService_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		BreakerThreshold:  2,
+		BreakerWindow:     time.Minute,
+		BreakerCooldown:   time.Minute,
+	}
+	q := queue.NewMemoryQueue(10)
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com", Pref: 10}},
+		},
+	}
+	client := &mockSMTPClient{shouldErr: true}
+	service.client = client
+	
+	for i := 0; i < 4; i++ {
+		q.Enqueue(ctx, &email.Email{
+			ID:     fmt.Sprintf("held-%d", i),
+			From:   "sender@test.com",
+			To:     []string{"recipient@example.com"},
+			Status: email.StatusQueued,
+		})
+	}
+	service.poll(ctx, 0, "")
+	
+	for i := 0; i < 4; i++ {
+		e, err := q.Get(ctx, fmt.Sprintf("held-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 {
+			if e.RetryCount != 1 {
+				t.Errorf("Expected email %d attempted once, got %d retries", i, e.RetryCount)
+			}
+			continue
+		}
+		if e.Status != email.StatusQueued || e.RetryCount != 0 || e.LastError != "" {
+			t.Errorf("Expected email %d held without an attempt, got %+v", i, e)
+		}
+		if e.ScheduledAt == nil || !e.ScheduledAt.After(time.Now().Add(50*time.Second)) {
+			t.Errorf("Expected email %d held for the cooldown, scheduled at %v", i, e.ScheduledAt)
+		}
+	}
+	
+	stats := service.BreakerStats()
+	if len(stats) != 1 || stats[0].Domain != "example.com" || stats[0].State != CircuitOpen || stats[0].Opened != 1 {
+		t.Errorf("Expected example.com circuit open, got %+v", stats)
+	}
+}
@@ -0,0 +1,156 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// Failure reasons reported in stats. SMTP replies are bucketed by code
+// class instead, as "smtp 55x" and so on.
+const (
+	ReasonDNS               = "dns lookup failed"
+	ReasonConnectionRefused = "connection refused"
+	ReasonConnectionTimeout = "connection timeout"
+	ReasonConnectionReset   = "connection reset"
+	ReasonUnreachable       = "host unreachable"
+	ReasonTLS               = "tls error"
+	ReasonNullMX            = "domain does not accept mail"
+	ReasonReputation        = "sending IP blocked"
+	ReasonMailLoop          = "mail loop"
+	ReasonOther             = "other"
+)
+
+// FailureReason buckets a delivery error for the failure breakdown in
+// stats. It is coarser than the categories used to collapse log lines so
+// the breakdown stays short.
+func FailureReason(err error) string {
+	if isReputationBlock(err) {
+		return ReasonReputation
+	}
+	if code := smtpCode(err); code != 0 {
+		return fmt.Sprintf("smtp %dx", code/10)
+	}
+	
+	switch classifyError(err) {
+	case "dns not found", "dns error":
+		return ReasonDNS
+	case "connection refused":
+		return ReasonConnectionRefused
+	case "timeout", "canceled":
+		return ReasonConnectionTimeout
+	case "connection reset":
+		return ReasonConnectionReset
+	case "unreachable":
+		return ReasonUnreachable
+	case "tls error":
+		return ReasonTLS
+	case "null mx":
+		return ReasonNullMX
+	case "mail loop":
+		return ReasonMailLoop
+	}
+	return ReasonOther
+}
+
+// permanent reports whether retrying err cannot help: a 5xx SMTP reply, a
+// mail loop, or a recipient domain that does not exist or publishes a null
+// MX. Other DNS failures, such as SERVFAIL, and network errors are
+// temporary, as is a refusal because of the sending IP's reputation,
+// whatever its code.
+func permanent(err error) bool {
+	if isReputationBlock(err) {
+		return false
+	}
+	if code := smtpCode(err); code != 0 {
+		return code >= 500
+	}
+	if errors.Is(err, ErrNullMX) || errors.Is(err, ErrRelayAuth) || errors.Is(err, ErrMailLoop) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// Classify describes a delivery error for the queue's LastFailure, for an
+// email already retried retryCount times. A 4xx reply is marked
+// greylisted if it looks like greylisting, and carries any wait the
+// server asked for, so the queue can retry sooner.
+func Classify(err error, retryCount int) email.Failure {
+	f := email.Failure{Text: err.Error(), Permanent: isRejected(err) || permanent(err)}
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		f.Code, f.Text = protoErr.Code, protoErr.Msg
+	}
+	if f.Code >= 400 && f.Code < 500 {
+		f.Greylisted = greylisted(f.Code, f.Text, retryCount)
+		f.RetryAfter = int(retryHint(f.Text) / time.Second)
+	}
+	return f
+}
+
+// greylistPattern matches the wording greylisting servers use, such as
+// Postgrey's "Greylisted, see http://postgrey.schweikert.ch/" and
+// milter-greylist's "Greylisting in action, please come back later".
+var greylistPattern = regexp.MustCompile(`(?i)gr[ae]y-?list|try (again )?later|come back (later|in)|temporarily (deferred|rejected)`)
+
+// retryHintPatterns find a wait named in a reply, as "try again in 300
+// seconds", "retry after 5 minutes" or "come back in 00:05:00".
+var (
+	retryHintUnits = regexp.MustCompile(`(?i)\b(?:in|after|for|wait)\s+(\d+)\s*(seconds?|secs?|s|minutes?|mins?|m|hours?|h)\b`)
+	retryHintClock = regexp.MustCompile(`(?i)\bin\s+(\d{1,2}):(\d\d):(\d\d)\b`)
+)
+
+// greylisted reports whether a 4xx reply looks like greylisting: one that
+// says so, or a bare 450 or 451 to a first attempt. Full mailboxes and
+// local errors answer 450 and 451 too, so a retry refused with one backs
+// off as usual unless its text says otherwise.
+func greylisted(code int, text string, retryCount int) bool {
+	if retryCount == 0 && (code == 450 || code == 451) {
+		return true
+	}
+	return greylistPattern.MatchString(text)
+}
+
+// retryHint returns the wait a reply asks for before the next attempt, or
+// zero if it names none.
+func retryHint(text string) time.Duration {
+	if m := retryHintClock.FindStringSubmatch(text); m != nil {
+		hours, _ := strconv.Atoi(m[1])
+		mins, _ := strconv.Atoi(m[2])
+		secs, _ := strconv.Atoi(m[3])
+		return time.Duration(hours)*time.Hour + time.Duration(mins)*time.Minute + time.Duration(secs)*time.Second
+	}
+	m := retryHintUnits.FindStringSubmatch(text)
+	if m == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+	switch unit := strings.ToLower(m[2]); {
+	case strings.HasPrefix(unit, "h"):
+		return time.Duration(n) * time.Hour
+	case strings.HasPrefix(unit, "m"):
+		return time.Duration(n) * time.Minute
+	}
+	return time.Duration(n) * time.Second
+}
+
+// smtpCode returns the SMTP reply code carried by err, or 0 if there is
+// none.
+func smtpCode(err error) int {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code
+	}
+	return 0
+}
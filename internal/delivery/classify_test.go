@@ -0,0 +1,275 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "nxdomain",
+			err:  lookupFailed(fmt.Errorf("failed to get MX records: %w", &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true})),
+			want: ReasonDNS,
+		},
+		{
+			name: "servfail",
+			err:  &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true},
+			want: ReasonDNS,
+		},
+		{
+			name: "connection refused",
+			err:  &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			want: ReasonConnectionRefused,
+		},
+		{
+			name: "dial timeout",
+			err:  fmt.Errorf("failed to connect: %w", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ETIMEDOUT)}),
+			want: ReasonConnectionTimeout,
+		},
+		{
+			name: "context deadline",
+			err:  fmt.Errorf("all MX servers failed: %w", context.DeadlineExceeded),
+			want: ReasonConnectionTimeout,
+		},
+		{
+			name: "connection reset",
+			err:  &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+			want: ReasonConnectionReset,
+		},
+		{
+			name: "greylisted",
+			err:  fmt.Errorf("failed to set recipient a@example.com: %w", &textproto.Error{Code: 451, Msg: "4.7.1 greylisted, try again later"}),
+			want: "smtp 45x",
+		},
+		{
+			name: "service unavailable",
+			err:  &textproto.Error{Code: 421, Msg: "4.3.2 service not available"},
+			want: "smtp 42x",
+		},
+		{
+			name: "user unknown",
+			err:  fmt.Errorf("all MX servers failed: %w", &textproto.Error{Code: 550, Msg: "5.1.1 user unknown"}),
+			want: "smtp 55x",
+		},
+		{
+			name: "message too large",
+			err:  &textproto.Error{Code: 552, Msg: "5.3.4 message size exceeds limit"},
+			want: "smtp 55x",
+		},
+		{
+			name: "authentication required",
+			err:  &textproto.Error{Code: 530, Msg: "5.7.0 authentication required"},
+			want: "smtp 53x",
+		},
+		{
+			name: "tls",
+			err:  errors.New("tls: handshake failure"),
+			want: ReasonTLS,
+		},
+		{
+			name: "unknown",
+			err:  errors.New("no recipients"),
+			want: ReasonOther,
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FailureReason(tt.err); got != tt.want {
+				t.Errorf("FailureReason(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+type replyClient struct {
+	err error
+}
+
+func (c *replyClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	return c.err
+}
+
+func TestDeliveryService_FailureCategories(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com", Pref: 10}},
+		},
+	}
+	service.client = &replyClient{err: &textproto.Error{Code: 550, Msg: "5.1.1 user unknown"}}
+	service.failures.logf = (&capturedLog{}).logf
+	service.maxRetry = 0
+	
+	q.Enqueue(ctx, &email.Email{ID: "test-1", From: "sender@test.com", To: []string{"a@example.com"}, Status: email.StatusQueued})
+	q.Enqueue(ctx, &email.Email{ID: "test-2", From: "sender@test.com", To: []string{"b@nowhere.test"}, Status: email.StatusQueued})
+	
+	emails, _ := q.Dequeue(ctx, 2)
+	for _, e := range emails {
+		service.deliver(ctx, e)
+	}
+	
+	categories := q.Stats().FailureCategories
+	if categories["smtp 55x"] != 1 || categories[ReasonDNS] != 1 {
+		t.Errorf("Expected one 55x and one DNS failure, got %v", categories)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want email.Failure
+	}{
+		{
+			name: "try again later",
+			err:  fmt.Errorf("all MX servers failed: %w", &textproto.Error{Code: 421, Msg: "4.7.0 try again later"}),
+			want: email.Failure{Code: 421, Text: "4.7.0 try again later", Greylisted: true},
+		},
+		{
+			name: "user unknown",
+			err:  &textproto.Error{Code: 550, Msg: "5.1.1 user unknown"},
+			want: email.Failure{Code: 550, Text: "5.1.1 user unknown", Permanent: true},
+		},
+		{
+			name: "nxdomain",
+			err:  &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true},
+			want: email.Failure{Text: "lookup example.com: no such host", Permanent: true},
+		},
+		{
+			name: "servfail",
+			err:  &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true},
+			want: email.Failure{Text: "lookup example.com: server misbehaving"},
+		},
+		{
+			name: "dial timeout",
+			err:  fmt.Errorf("dial: %w", context.DeadlineExceeded),
+			want: email.Failure{Text: "dial: context deadline exceeded"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err, 0); got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestClassify_Greylisting(t *testing.T) {
+	tests := []struct {
+		name           string
+		code           int
+		msg            string
+		retryCount     int
+		wantGreylisted bool
+		wantRetryAfter int
+	}{
+		{name: "postgrey", code: 450, msg: "4.2.0 <a@example.com>: Recipient address rejected: Greylisted, see http://postgrey.schweikert.ch/help/example.com.html", wantGreylisted: true},
+		{name: "postgrey with delay", code: 450, msg: "4.2.0 <a@example.com>: Recipient address rejected: Greylisted for 300 seconds", wantGreylisted: true, wantRetryAfter: 300},
+		{name: "milter-greylist", code: 451, msg: "4.7.1 Greylisting in action, please come back in 00:05:00", wantGreylisted: true, wantRetryAfter: 300},
+		{name: "sqlgrey", code: 451, msg: "Greylisted, please try again later", wantGreylisted: true},
+		{name: "exim", code: 451, msg: "Temporary local problem - please try later", wantGreylisted: true},
+		{name: "gmail", code: 421, msg: "4.7.0 Try again later, closing connection.", wantGreylisted: true},
+		{name: "yahoo", code: 421, msg: "4.7.0 [TS01] Messages from 203.0.113.10 temporarily deferred due to unexpected volume or user complaints", wantGreylisted: true},
+		{name: "outlook", code: 451, msg: "4.7.500 Server busy. Please try again later from [203.0.113.10]. (S77719)", wantGreylisted: true},
+		{name: "retry hint in minutes", code: 421, msg: "4.3.2 Service shutting down, try again in 5 minutes", wantRetryAfter: 300},
+		{name: "retry hint after", code: 421, msg: "4.7.0 Too many connections, retry after 90s", wantRetryAfter: 90},
+		{name: "mailbox full", code: 452, msg: "4.2.2 Mailbox full"},
+		{name: "bare 450 on first attempt", code: 450, msg: "4.2.0 try later", wantGreylisted: true},
+		{name: "bare 451 on first attempt", code: 451, msg: "4.7.1 Service unavailable", wantGreylisted: true},
+		{name: "bare 450 on retry", code: 450, msg: "4.2.1 Mailbox temporarily unavailable", retryCount: 1},
+		{name: "bare 451 on retry", code: 451, msg: "4.3.0 Requested action aborted: local error in processing", retryCount: 2},
+		{name: "greylisted on retry", code: 451, msg: "Greylisted, please try again later", retryCount: 1, wantGreylisted: true},
+		{name: "too many connections", code: 421, msg: "4.7.0 Too many concurrent SMTP connections"},
+		{name: "permanent", code: 550, msg: "5.7.1 Greylisted forever, go away"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Classify(&textproto.Error{Code: tt.code, Msg: tt.msg}, tt.retryCount)
+			if f.Greylisted != tt.wantGreylisted || f.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("Expected greylisted %v, retry after %d, got %+v", tt.wantGreylisted, tt.wantRetryAfter, f)
+			}
+		})
+	}
+}
+
+func TestDeliveryService_PermanentFailureBounces(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	client := &envelopeClient{
+		fail: map[string]error{
+			"mx1.one.test": &textproto.Error{Code: 550, Msg: "5.7.1 relaying denied"},
+			"mx2.one.test": &textproto.Error{Code: 421, Msg: "4.3.2 shutting down"},
+		},
+	}
+	service := newDomainTestService(q, client)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"one.test": {{Host: "mx1.one.test", Pref: 10}, {Host: "mx2.one.test", Pref: 20}},
+		},
+	}
+	var result Result
+	service.SetResultHook(func(r Result) { result = r })
+	
+	q.Enqueue(ctx, &email.Email{
+		ID:     "test-1",
+		From:   "sender@test.com",
+		To:     []string{"a@one.test"},
+		Status: email.StatusQueued,
+	})
+	emails, _ := q.Dequeue(ctx, 1)
+	service.deliver(ctx, emails[0])
+	
+	// A 5xx from the first MX host is final; the second is never asked
+	if len(client.sent) != 0 {
+		t.Errorf("Expected nothing sent, got %v", client.sent)
+	}
+	if _, err := q.Get(ctx, "test-1"); !errors.Is(err, queue.ErrEmailNotFound) {
+		t.Errorf("Expected the email to leave the queue without a retry, got %v", err)
+	}
+	if result.Status != email.StatusBounced || result.Failure == nil || result.Failure.Code != 550 {
+		t.Errorf("Expected a bounce with code 550, got %+v", result)
+	}
+	
+	// A 4xx moves on to the next host and is retried
+	client.fail["mx1.one.test"] = &textproto.Error{Code: 421, Msg: "4.7.0 try again later"}
+	q.Enqueue(ctx, &email.Email{
+		ID:     "test-2",
+		From:   "sender@test.com",
+		To:     []string{"a@one.test"},
+		Status: email.StatusQueued,
+	})
+	emails, _ = q.Dequeue(ctx, 1)
+	service.deliver(ctx, emails[0])
+	
+	e, err := q.Get(ctx, "test-2")
+	if err != nil {
+		t.Fatalf("Expected the email to be retried, got %v", err)
+	}
+	if e.Status != email.StatusQueued || e.LastFailure == nil || e.LastFailure.Code != 421 || e.LastFailure.Permanent {
+		t.Errorf("Expected a temporary 421 from the last host, got %s %+v", e.Status, e.LastFailure)
+	}
+}
@@ -3,18 +3,55 @@ package delivery
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/smtp"
+	"net/textproto"
+	"sort"
 	"strings"
 	"time"
 	
+	"github.com/tpdoyle87/simple-email-server/internal/identity"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
 	"github.com/tpdoyle87/simple-email-server/pkg/email"
 )
 
 type SimpleSMTPClient struct {
 	timeout time.Duration
+	pool    *connPool
+	
+	// Looks up MX hosts' TLSA records for DANE, if set
+	lookupTLSA func(ctx context.Context, host string) ([]TLSARecord, error)
+	
+	// Oldest TLS version accepted, zero for crypto/tls's default
+	minTLSVersion uint16
+	
+	// Local addresses to connect from, nil to let the OS choose
+	sourceIPs *sourceIPs
+	
+	// Name announced in EHLO and in Received headers; see SetIdentity
+	identity *identity.Identity
+	
+	// Address family to connect over, IPFamilyAny if empty, and how MX
+	// hosts' addresses are looked up, the system resolver if nil
+	ipFamily string
+	lookupIP func(ctx context.Context, host string) ([]net.IPAddr, error)
+	
+	// Connects to one address instead of the dialer, for tests
+	dial func(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error)
+	
+	// Set for a relay; see SetRelay and SetRelayAuth
+	username, password string
+	implicitTLS        bool
+	rootCAs            *x509.CertPool
+	mechanisms         []string
+	tokens             TokenSource
 }
 
 func NewSMTPClient(timeout time.Duration) *SimpleSMTPClient {
@@ -23,7 +60,39 @@ func NewSMTPClient(timeout time.Duration) *SimpleSMTPClient {
 	}
 }
 
-func (c *SimpleSMTPClient) Send(ctx context.Context, host string, e *email.Email) error {
+// SetPool keeps up to size SMTP sessions open between emails, so
+// consecutive transactions to the same MX host share a connection. Idle
+// sessions are closed after idleTimeout. Call it before the first Send.
+func (c *SimpleSMTPClient) SetPool(size int, idleTimeout time.Duration) {
+	c.pool = newConnPool(size, idleTimeout, c.openSession)
+}
+
+// Close ends the pooled sessions, if any.
+func (c *SimpleSMTPClient) Close() error {
+	if c.pool == nil {
+		return nil
+	}
+	return c.pool.Close()
+}
+
+func (c *SimpleSMTPClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	// A transcript starts with the server's greeting, so needs a session
+	// of its own
+	if c.pool != nil && transcriptFrom(ctx) == nil {
+		return c.sendPooled(ctx, host, e, rcpts)
+	}
+	
+	conn, err := c.Dial(ctx, host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	
+	return c.SendOnConn(ctx, conn, host, e, rcpts)
+}
+
+// Dial opens a TCP connection to host without starting an SMTP session.
+func (c *SimpleSMTPClient) Dial(ctx context.Context, host string) (net.Conn, error) {
 	// Add port if not present
 	if !strings.Contains(host, ":") {
 		host = host + ":25"
@@ -33,41 +102,258 @@ func (c *SimpleSMTPClient) Send(ctx context.Context, host string, e *email.Email
 	dialer := &net.Dialer{
 		Timeout: c.timeout,
 	}
+	if ip := c.sourceIPs.pick(host); ip != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
 	
-	// Dial with context
-	conn, err := dialer.DialContext(ctx, "tcp", host)
+	// Dial with context, racing the host's addresses
+	conn, err := c.dialHost(ctx, dialer, host)
 	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if !c.implicitTLS {
+		return conn, nil
+	}
+	
+	serverName, _, _ := net.SplitHostPort(host)
+	tlsConn := tls.Client(conn, c.tlsConfig(serverName, TLSPolicyRequired, nil))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: TLS with %s failed: %v", ErrTLSRequired, serverName, err)
+	}
+	return tlsConn, nil
+}
+
+// SendOnConn runs the SMTP transaction for e over an already established
+// connection, addressed to rcpts only. The connection is closed when the
+// transaction ends.
+func (c *SimpleSMTPClient) SendOnConn(ctx context.Context, conn net.Conn, host string, e *email.Email, rcpts []string) error {
+	markConn(ctx, conn)
+	s, err := c.startSession(ctx, conn, host)
+	if err != nil {
+		return err
+	}
+	defer s.client.Close()
+	markSession(ctx, s)
+	
+	err = transaction(s.client, e, rcpts, c.identity)
+	var rcptErr *RecipientError
+	if err == nil || errors.As(err, &rcptErr) {
+		if quitErr := s.client.Quit(); err == nil {
+			err = quitErr
+		}
+	}
+	return err
+}
+
+// sendPooled runs the transaction on a pooled session to host, returning
+// the session to the pool afterwards if it is still usable.
+func (c *SimpleSMTPClient) sendPooled(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	s, err := c.pool.get(ctx, host)
+	if err != nil {
+		return err
+	}
+	markSession(ctx, s)
+	
+	err = transaction(s.client, e, rcpts, c.identity)
+	c.pool.put(s, reusable(err))
+	return err
+}
+
+// OpenSession starts an SMTP session to host, taken from the pool when
+// there is one, for running several transactions in a row.
+func (c *SimpleSMTPClient) OpenSession(ctx context.Context, host string) (Session, error) {
+	var s *session
+	var err error
+	if c.pool != nil {
+		s, err = c.pool.get(ctx, host)
+	} else {
+		s, err = c.openSession(ctx, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &smtpSession{session: s, pool: c.pool, identity: c.identity, healthy: true}, nil
+}
+
+// smtpSession is a session handed out by OpenSession.
+type smtpSession struct {
+	*session
+	pool     *connPool
+	identity *identity.Identity
+	used     bool
+	healthy  bool
+}
+
+func (s *smtpSession) Send(ctx context.Context, e *email.Email, rcpts []string) error {
+	if s.policy != tlsPolicy(ctx) && s.policy != TLSPolicyDANE {
+		return fmt.Errorf("%w: session to %s was opened under TLS policy %s", ErrTLSRequired, s.host, s.policy)
+	}
+	setDeadline(ctx, s.conn)
+	if s.used {
+		if err := s.client.Reset(); err != nil {
+			s.healthy = false
+			return fmt.Errorf("failed to reset session: %v", err)
+		}
+	}
+	s.used = true
+	markSession(ctx, s.session)
+	
+	err := transaction(s.client, e, rcpts, s.identity)
+	s.healthy = reusable(err)
+	return err
+}
+
+// Close returns the session to the pool, or ends it when there is none.
+func (s *smtpSession) Close() error {
+	if s.pool != nil {
+		s.pool.put(s.session, s.healthy)
+		return nil
+	}
+	if s.healthy {
+		s.conn.SetDeadline(time.Now().Add(5 * time.Second))
+		s.client.Quit()
+	}
+	return s.client.Close()
+}
+
+// openSession dials host and starts an SMTP session for the pool.
+func (c *SimpleSMTPClient) openSession(ctx context.Context, host string) (*session, error) {
+	conn, err := c.Dial(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	setDeadline(ctx, conn)
+	markConn(ctx, conn)
+	
+	s, err := c.startSession(ctx, conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetIdentity sets the server identity whose hostname is announced in
+// EHLO. Without one the machine's hostname is used.
+func (c *SimpleSMTPClient) SetIdentity(id *identity.Identity) {
+	c.identity = id
+}
+
+// startSession greets the server on conn, upgrades to TLS as ctx's TLS
+// policy asks unless conn is already TLS, and logs in if the client has a
+// relay user. A host with TLSA records must offer TLS with a certificate
+// matching them, whatever the policy.
+func (c *SimpleSMTPClient) startSession(ctx context.Context, conn net.Conn, host string) (*session, error) {
+	serverName := strings.Split(host, ":")[0]
+	policy := tlsPolicy(ctx)
+	tlsa := c.tlsaRecords(ctx, host)
+	if len(tlsa) > 0 {
+		policy = TLSPolicyDANE
 	}
-	defer conn.Close()
 	
-	// Create SMTP client
-	client, err := smtp.NewClient(conn, strings.Split(host, ":")[0])
+	// Create SMTP client, recording the exchange if asked to
+	_, implicitTLS := conn.(*tls.Conn)
+	t := transcriptFrom(ctx)
+	clientConn := conn
+	if t != nil {
+		clientConn = t.wrap(conn)
+	}
+	client, err := smtp.NewClient(clientConn, serverName)
 	if err != nil {
-		return fmt.Errorf("failed to create SMTP client: %w", err)
+		if code := smtpCode(err); code >= 400 && code < 500 {
+			err = &greetingError{err: err}
+		}
+		return nil, fmt.Errorf("failed to create SMTP client: %w", err)
 	}
-	defer client.Close()
 	
-	// Try STARTTLS
-	if ok, _ := client.Extension("STARTTLS"); ok {
-		config := &tls.Config{ServerName: strings.Split(host, ":")[0]}
-		if err = client.StartTLS(config); err != nil {
-			// Log but continue without TLS
-			fmt.Printf("STARTTLS failed: %v\n", err)
+	// net/smtp would otherwise announce itself as "localhost"
+	if err := client.Hello(c.identity.Hostname()); err != nil {
+		client.Close()
+		if code := smtpCode(err); code >= 400 && code < 500 {
+			err = &greetingError{err: err}
 		}
+		return nil, fmt.Errorf("EHLO refused: %w", err)
+	}
+	s := &session{host: host, conn: conn, client: client, policy: policy}
+	if implicitTLS {
+		s.tls = true
+	} else if err := c.startTLS(ctx, s, serverName, tlsa); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if c.username != "" {
+		if err := c.login(ctx, client, serverName); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// startTLS runs STARTTLS on s under its policy, returning an error only if
+// the policy can't be met.
+func (c *SimpleSMTPClient) startTLS(ctx context.Context, s *session, serverName string, tlsa []TLSARecord) error {
+	if s.policy == TLSPolicyNone {
+		return nil
 	}
 	
+	// Try STARTTLS. Without it the session continues in plaintext unless
+	// the policy requires TLS.
+	required := s.policy == TLSPolicyRequired || s.policy == TLSPolicyDANE
+	if ok, _ := s.client.Extension("STARTTLS"); !ok {
+		if required {
+			return fmt.Errorf("%w: %s does not offer STARTTLS", ErrTLSRequired, serverName)
+		}
+		return nil
+	}
+	if err := s.client.StartTLS(c.tlsConfig(serverName, s.policy, tlsa)); err != nil {
+		if s.policy != TLSPolicyOpportunistic {
+			return fmt.Errorf("%w: STARTTLS with %s failed: %v", ErrTLSRequired, serverName, err)
+		}
+		// Log but continue without TLS
+		logctx.Printf(ctx, "STARTTLS with %s failed, continuing without TLS: %v", serverName, err)
+		return nil
+	}
+	s.tls = true
+	if t := transcriptFrom(ctx); t != nil {
+		t.follow(s.client)
+	}
+	return nil
+}
+
+// mailFrom is e's envelope sender: the return path if delivery chose
+// one, its From otherwise, and the null sender for non-delivery reports.
+func mailFrom(e *email.Email) string {
+	if e.EnvelopeFrom == "" && !e.Notification {
+		return e.From
+	}
+	return e.EnvelopeFrom
+}
+
+// transaction sends e to rcpts over an established session as the server
+// id, leaving the session open for the caller to reuse or end.
+func transaction(client *smtp.Client, e *email.Email, rcpts []string, id *identity.Identity) error {
 	// Set sender
-	if err = client.Mail(e.From); err != nil {
+	if err := client.Mail(mailFrom(e)); err != nil {
 		return fmt.Errorf("failed to set sender: %w", err)
 	}
 	
-	// Set recipients
-	for _, to := range e.Recipients() {
-		if err = client.Rcpt(to); err != nil {
-			return fmt.Errorf("failed to set recipient %s: %w", to, err)
+	// Set recipients. A recipient the server refuses doesn't stop
+	// delivery to the others.
+	rejected := make(map[string]error)
+	for _, to := range rcpts {
+		if err := client.Rcpt(to); err != nil {
+			var protoErr *textproto.Error
+			if !errors.As(err, &protoErr) {
+				return fmt.Errorf("failed to set recipient %s: %w", to, err)
+			}
+			rejected[to] = err
 		}
 	}
+	if len(rejected) == len(rcpts) {
+		return &RecipientError{Rejected: rejected}
+	}
 	
 	// Send data
 	w, err := client.Data()
@@ -76,7 +362,7 @@ func (c *SimpleSMTPClient) Send(ctx context.Context, host string, e *email.Email
 	}
 	
 	// Write email
-	if err = writeEmail(w, e); err != nil {
+	if err = WriteMessage(w, e, id); err != nil {
 		w.Close()
 		return fmt.Errorf("failed to write email: %w", err)
 	}
@@ -85,38 +371,77 @@ func (c *SimpleSMTPClient) Send(ctx context.Context, host string, e *email.Email
 		return fmt.Errorf("failed to close data writer: %w", err)
 	}
 	
-	// Quit
-	return client.Quit()
+	if len(rejected) > 0 {
+		return &RecipientError{Rejected: rejected}
+	}
+	return nil
 }
 
-func writeEmail(w io.Writer, e *email.Email) error {
-	// Write headers
-	headers := []string{
-		fmt.Sprintf("From: %s", e.From),
-		fmt.Sprintf("To: %s", strings.Join(e.To, ", ")),
-		fmt.Sprintf("Subject: %s", e.Subject),
-		fmt.Sprintf("Date: %s", time.Now().Format(time.RFC1123Z)),
-		"MIME-Version: 1.0",
+// RecipientError is returned by an SMTPClient when the server refused some
+// recipients at RCPT TO. The message was still sent to the rest of the
+// transaction's recipients unless every one of them was refused.
+type RecipientError struct {
+	Rejected map[string]error
+}
+
+func (e *RecipientError) Error() string {
+	rcpts := e.recipients()
+	msgs := make([]string, len(rcpts))
+	for i, rcpt := range rcpts {
+		msgs[i] = fmt.Sprintf("recipient %s refused: %v", rcpt, e.Rejected[rcpt])
 	}
-	
-	if len(e.CC) > 0 {
-		headers = append(headers, fmt.Sprintf("Cc: %s", strings.Join(e.CC, ", ")))
+	return strings.Join(msgs, "; ")
+}
+
+func (e *RecipientError) Unwrap() []error {
+	rcpts := e.recipients()
+	errs := make([]error, len(rcpts))
+	for i, rcpt := range rcpts {
+		errs[i] = e.Rejected[rcpt]
 	}
-	
-	// Add custom headers
-	for k, v := range e.Headers {
-		if !isStandardHeader(k) {
-			headers = append(headers, fmt.Sprintf("%s: %s", k, v))
+	return errs
+}
+
+// recipients returns the refused recipients in a stable order.
+func (e *RecipientError) recipients() []string {
+	rcpts := make([]string, 0, len(e.Rejected))
+	for rcpt := range e.Rejected {
+		rcpts = append(rcpts, rcpt)
+	}
+	sort.Strings(rcpts)
+	return rcpts
+}
+
+// WriteMessage writes e as it is sent over SMTP by the server id: a raw
+// message as submitted, or one built from e's fields, after an
+// X-Original-To header if e is being redirected.
+func WriteMessage(w io.Writer, e *email.Email, id *identity.Identity) error {
+	if len(e.OriginalTo) > 0 {
+		if _, err := fmt.Fprintf(w, "X-Original-To: %s\r\n", strings.Join(e.OriginalTo, ", ")); err != nil {
+			return err
 		}
 	}
+	if e.HasRaw() {
+		return writeRawEmail(w, e, id)
+	}
 	
 	// Determine content type
+	bodyType := "text/plain; charset=utf-8"
 	if e.HTML != "" {
-		headers = append(headers, "Content-Type: text/html; charset=utf-8")
-	} else {
-		headers = append(headers, "Content-Type: text/plain; charset=utf-8")
+		bodyType = "text/html; charset=utf-8"
 	}
 	
+	contentType := bodyType
+	var mw *multipart.Writer
+	if len(e.Attachments) > 0 {
+		mw = multipart.NewWriter(w)
+		if err := mw.SetBoundary(multipartBoundary(e)); err != nil {
+			return err
+		}
+		contentType = fmt.Sprintf("multipart/mixed; boundary=%q", mw.Boundary())
+	}
+	headers := buildHeaders(e, contentType)
+	
 	// Write headers
 	for _, h := range headers {
 		if _, err := fmt.Fprintf(w, "%s\r\n", h); err != nil {
@@ -135,17 +460,76 @@ func writeEmail(w io.Writer, e *email.Email) error {
 		body = e.HTML
 	}
 	
-	_, err := fmt.Fprint(w, body)
+	if mw == nil {
+		_, err := fmt.Fprint(w, body)
+		return err
+	}
+	
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {bodyType}})
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprint(part, body); err != nil {
+		return err
+	}
+	
+	for _, att := range e.Attachments {
+		if err := writeAttachment(mw, att); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// writeAttachment adds att to mw as a base64 part wrapped at 76 columns.
+func writeAttachment(mw *multipart.Writer, att email.Attachment) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {att.ContentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename})},
+	})
+	if err != nil {
+		return err
+	}
+	
+	encoded := base64.StdEncoding.EncodeToString(att.Data)
+	for len(encoded) > 76 {
+		if _, err := fmt.Fprintf(part, "%s\r\n", encoded[:76]); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = fmt.Fprintf(part, "%s\r\n", encoded)
 	return err
 }
 
-func isStandardHeader(key string) bool {
-	standard := []string{"from", "to", "cc", "bcc", "subject", "date", "mime-version", "content-type"}
-	lower := strings.ToLower(key)
-	for _, s := range standard {
-		if lower == s {
-			return true
+// writeRawEmail transmits a pre-built message unmodified apart from a
+// prepended trace header. Relayed mail was given its trace header on
+// receipt, so it is sent byte for byte as stored.
+func writeRawEmail(w io.Writer, e *email.Email, id *identity.Identity) error {
+	if !e.Relayed {
+		if _, err := fmt.Fprintf(w, "Received: by %s with HTTP id %s; %s\r\n",
+			id.Hostname(), e.ID, time.Now().Format(time.RFC1123Z)); err != nil {
+			return err
 		}
 	}
-	return false
-}
\ No newline at end of file
+	
+	raw, err := e.OpenRaw()
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+	
+	_, err = io.Copy(w, raw)
+	return err
+}
+
+// addressHeader returns the original text of an address header from a
+// parsed message, so group syntax is re-emitted as received, or else
+// joins addrs.
+func addressHeader(e *email.Email, key string, addrs []string) string {
+	if v := e.Headers[key]; v != "" {
+		return v
+	}
+	return strings.Join(addrs, ", ")
+}
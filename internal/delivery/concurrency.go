@@ -0,0 +1,137 @@
+package delivery
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+)
+
+// busyDomainDelay is how long emails are put back for when every domain
+// they go to is at its concurrency limit.
+const busyDomainDelay = time.Second
+
+// domainSlots caps how many workers deliver to one recipient domain at
+// once, since many receivers refuse more than a few simultaneous
+// connections from one IP.
+type domainSlots struct {
+	limit  int
+	limits map[string]int
+	
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// newDomainSlots returns the caps configured by cfg, or nil if no domain
+// is capped.
+func newDomainSlots(cfg *config.DeliveryConfig) *domainSlots {
+	d := &domainSlots{
+		limit:  cfg.DomainConcurrency,
+		limits: make(map[string]int),
+		active: make(map[string]int),
+	}
+	for domain, limit := range cfg.DomainConcurrencyLimits {
+		d.limits[strings.ToLower(domain)] = limit
+	}
+	if d.limit <= 0 && len(d.limits) == 0 {
+		return nil
+	}
+	return d
+}
+
+// tryAcquire takes a slot for each of domains if all of them have one
+// free, and otherwise takes none.
+func (d *domainSlots) tryAcquire(domains []string) bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	
+	for _, domain := range domains {
+		if limit := d.limitFor(domain); limit > 0 && d.active[domain] >= limit {
+			return false
+		}
+	}
+	for _, domain := range domains {
+		d.active[domain]++
+	}
+	return true
+}
+
+func (d *domainSlots) release(domains []string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	
+	for _, domain := range domains {
+		if d.active[domain]--; d.active[domain] <= 0 {
+			delete(d.active, domain)
+		}
+	}
+}
+
+// limitFor returns domain's cap; zero or less means none.
+func (d *domainSlots) limitFor(domain string) int {
+	if limit, ok := d.limits[domain]; ok {
+		return limit
+	}
+	return d.limit
+}
+
+// domains returns the recipient domains b is delivered to.
+func (b domainBatch) domains() []string {
+	if b.domain != "" {
+		return []string{b.domain}
+	}
+	var domains []string
+	for _, e := range b.emails {
+		groups, _ := groupRecipients(e)
+		for _, g := range groups {
+			domains = append(domains, g.domain)
+		}
+	}
+	return domains
+}
+
+// deliverBatches delivers batches, passing over those whose domains are at
+// their concurrency limit and coming back to them once the rest are done.
+// If a pass delivers nothing, the batches still waiting go back in the
+// queue for a moment so the worker can take other mail.
+func (s *Service) deliverBatches(ctx context.Context, batches []domainBatch) {
+	for len(batches) > 0 {
+		var waiting []domainBatch
+		for _, b := range batches {
+			domains := b.domains()
+			if !s.slots.tryAcquire(domains) {
+				waiting = append(waiting, b)
+				continue
+			}
+			s.deliverBatch(ctx, b)
+			s.slots.release(domains)
+		}
+		if len(waiting) == len(batches) {
+			s.postponeBusy(ctx, waiting)
+			return
+		}
+		batches = waiting
+	}
+}
+
+// postponeBusy puts batches back in the queue until their domains may
+// have a free slot.
+func (s *Service) postponeBusy(ctx context.Context, batches []domainBatch) {
+	ctx = context.WithoutCancel(ctx)
+	for _, b := range batches {
+		domains := strings.Join(b.domains(), ", ")
+		logctx.Printf(ctx, "%d emails for %s waiting for a free connection", len(b.emails), domains)
+		for _, e := range b.emails {
+			s.postponeEmail(logctx.With(ctx, "email_id", e.ID), e, "too many concurrent deliveries to "+domains, busyDomainDelay)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// slowSMTPClient takes a while over each send and records the most sends
+// in progress at once to each host.
+type slowSMTPClient struct {
+	delay time.Duration
+	
+	mu     sync.Mutex
+	active map[string]int
+	peak   map[string]int
+	sent   map[string]int
+}
+
+func (c *slowSMTPClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	c.mu.Lock()
+	c.active[host]++
+	c.peak[host] = max(c.peak[host], c.active[host])
+	c.mu.Unlock()
+	
+	time.Sleep(c.delay)
+	
+	c.mu.Lock()
+	c.active[host]--
+	c.sent[host]++
+	c.mu.Unlock()
+	return nil
+}
+
+func TestDeliveryService_DomainConcurrency(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.DeliveryConfig{
+		Workers:                 4,
+		DNSCacheTTL:             5 * time.Minute,
+		ConnectionTimeout:       30 * time.Second,
+		DomainConcurrencyLimits: map[string]int{"slow.test": 1},
+	}
+	q := queue.NewMemoryQueue(20)
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"slow.test": {{Host: "mx.slow.test", Pref: 10}},
+			"fast.test": {{Host: "mx.fast.test", Pref: 10}},
+		},
+	}
+	client := &slowSMTPClient{
+		delay:  100 * time.Millisecond,
+		active: make(map[string]int),
+		peak:   make(map[string]int),
+		sent:   make(map[string]int),
+	}
+	service.client = client
+	
+	for i := 0; i < 4; i++ {
+		for _, domain := range []string{"slow.test", "fast.test"} {
+			q.Enqueue(ctx, &email.Email{
+				ID:     fmt.Sprintf("%s-%d", domain, i),
+				From:   "sender@test.com",
+				To:     []string{"rcpt@" + domain},
+				Status: email.StatusQueued,
+			})
+		}
+	}
+	emails, _ := q.Dequeue(ctx, 8)
+	
+	// Four workers, each with an email for both domains
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(batch []*email.Email) {
+			defer wg.Done()
+			service.deliverBatches(ctx, batchByDomain(batch))
+		}(emails[2*i : 2*i+2])
+	}
+	wg.Wait()
+	
+	if peak := client.peak["mx.slow.test"]; peak != 1 {
+		t.Errorf("Expected at most 1 delivery to slow.test at once, got %d", peak)
+	}
+	if peak := client.peak["mx.fast.test"]; peak < 2 {
+		t.Errorf("Expected fast.test deliveries in parallel, got at most %d at once", peak)
+	}
+	if sent := client.sent["mx.fast.test"]; sent != 4 {
+		t.Errorf("Expected all 4 fast.test emails sent, got %d", sent)
+	}
+	
+	// slow.test emails that never got a slot wait in the queue
+	waiting := 0
+	for i := 0; i < 4; i++ {
+		e, err := q.Get(ctx, fmt.Sprintf("slow.test-%d", i))
+		if err != nil {
+			continue
+		}
+		if e.Status != email.StatusQueued || e.RetryCount != 0 || e.DeferCount != 0 {
+			t.Errorf("Expected waiting email queued without a failure, got %+v", e)
+		}
+		waiting++
+	}
+	if sent := client.sent["mx.slow.test"]; sent == 0 || sent+waiting != 4 {
+		t.Errorf("Expected every slow.test email sent or waiting, got %d sent and %d waiting", sent, waiting)
+	}
+}
+
+// flakySMTPClient refuses the first send of each email with a temporary
+// error and accepts the next.
+type flakySMTPClient struct {
+	mu    sync.Mutex
+	tries map[string]int
+}
+
+func (c *flakySMTPClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tries[e.ID]++
+	if c.tries[e.ID] == 1 {
+		return &textproto.Error{Code: 451, Msg: "4.7.1 try again later"}
+	}
+	return nil
+}
+
+// TestDeliveryService_ConcurrentStateChanges delivers with several workers
+// while the queue and results are read; run it with -race.
+func TestDeliveryService_ConcurrentStateChanges(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cfg := &config.DeliveryConfig{
+		Workers:           4,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	q := queue.NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:     100,
+		MaxRetry:    5,
+		RetryDelay:  time.Nanosecond,
+		RetryJitter: -1,
+		
+		// The 451 would otherwise be retried after the greylist delay
+		GreylistRetryDelay: -1,
+	})
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com", Pref: 10}},
+		},
+	}
+	service.client = &flakySMTPClient{tries: make(map[string]int)}
+	
+	var mu sync.Mutex
+	results := make(map[string][]Result)
+	service.SetResultHook(func(r Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[r.ID] = append(results[r.ID], r)
+	})
+	
+	const total = 20
+	var ids []string
+	for i := 0; i < total; i++ {
+		id := fmt.Sprintf("stress-%d", i)
+		ids = append(ids, id)
+		q.Enqueue(ctx, &email.Email{
+			ID:     id,
+			From:   "sender@test.com",
+			To:     []string{"rcpt@example.com"},
+			Status: email.StatusQueued,
+		})
+	}
+	
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for q.Size() > 0 && ctx.Err() == nil {
+				service.poll(ctx, worker, "")
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for q.Size() > 0 && ctx.Err() == nil {
+			for _, id := range ids {
+				q.Get(ctx, id)
+			}
+		}
+	}()
+	wg.Wait()
+	
+	if size := q.Size(); size != 0 {
+		t.Fatalf("Expected every email delivered, %d left", size)
+	}
+	for _, id := range ids {
+		// A worker can report its attempt after the retry it queued was
+		// delivered
+		rs := results[id]
+		sort.Slice(rs, func(i, j int) bool { return rs[i].Attempt < rs[j].Attempt })
+		if len(rs) != 2 || rs[0].Status != email.StatusQueued || rs[1].Status != email.StatusDelivered || rs[1].Attempt != 2 {
+			t.Errorf("Expected %s retried once then delivered, got %+v", id, rs)
+		}
+	}
+}
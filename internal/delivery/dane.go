@@ -0,0 +1,354 @@
+package delivery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+)
+
+// TLSA certificate usages, selectors and matching types (RFC 6698). Only
+// DANE-TA and DANE-EE are usable for SMTP (RFC 7672).
+const (
+	TLSAUsageDANETA = 2
+	TLSAUsageDANEEE = 3
+	
+	TLSASelectorCert = 0
+	TLSASelectorSPKI = 1
+	
+	TLSAMatchFull   = 0
+	TLSAMatchSHA256 = 1
+	TLSAMatchSHA512 = 2
+)
+
+const (
+	dnsTypeTLSA = 52
+	dnsTypeOPT  = 41
+	
+	// defaultTLSATimeout bounds a TLSA lookup when no DNS timeout is set
+	defaultTLSATimeout = 5 * time.Second
+)
+
+// TLSARecord is a TLSA record published for an MX host.
+type TLSARecord struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Data         []byte
+}
+
+// usable reports whether SMTP clients can use the record (RFC 7672 3.1).
+func (r TLSARecord) usable() bool {
+	return (r.Usage == TLSAUsageDANETA || r.Usage == TLSAUsageDANEEE) &&
+		r.Selector <= TLSASelectorSPKI && r.MatchingType <= TLSAMatchSHA512
+}
+
+// matches reports whether cert is the one the record names.
+func (r TLSARecord) matches(cert *x509.Certificate) bool {
+	data := cert.Raw
+	if r.Selector == TLSASelectorSPKI {
+		data = cert.RawSubjectPublicKeyInfo
+	}
+	switch r.MatchingType {
+	case TLSAMatchSHA256:
+		sum := sha256.Sum256(data)
+		return bytes.Equal(sum[:], r.Data)
+	case TLSAMatchSHA512:
+		sum := sha512.Sum512(data)
+		return bytes.Equal(sum[:], r.Data)
+	default:
+		return bytes.Equal(data, r.Data)
+	}
+}
+
+// verifyDANE checks the chain an MX host presented against its TLSA
+// records. A DANE-EE record must match the host's own certificate, with
+// no further checks of names or expiry. A DANE-TA record must match a
+// certificate in the chain that the host's certificate then verifies up
+// to, for serverName.
+func verifyDANE(certs []*x509.Certificate, serverName string, records []TLSARecord) error {
+	if len(certs) == 0 {
+		return errors.New("no certificate presented")
+	}
+	leaf := certs[0]
+	for _, r := range records {
+		if r.Usage == TLSAUsageDANEEE && r.matches(leaf) {
+			return nil
+		}
+	}
+	
+	var lastErr error
+	for _, r := range records {
+		if r.Usage != TLSAUsageDANETA {
+			continue
+		}
+		for _, ta := range certs[1:] {
+			if !r.matches(ta) {
+				continue
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(ta)
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := leaf.Verify(x509.VerifyOptions{
+				DNSName:       serverName,
+				Roots:         roots,
+				Intermediates: intermediates,
+			})
+			if err == nil {
+				return nil
+			}
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("certificate does not verify to the TLSA trust anchor: %w", lastErr)
+	}
+	return errors.New("certificate matches no TLSA record")
+}
+
+// daneTLSConfig returns the STARTTLS configuration for a host with TLSA
+// records: its certificate is checked against them instead of the system
+// roots.
+func daneTLSConfig(serverName string, records []TLSARecord) *tls.Config {
+	return &tls.Config{
+		ServerName: serverName,
+		// Verified by VerifyConnection against the TLSA records instead
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return verifyDANE(state.PeerCertificates, serverName, records)
+		},
+	}
+}
+
+// SetDANE makes the client look up each MX host's TLSA records with
+// lookup before STARTTLS. A host with usable records only gets mail over
+// TLS with a certificate matching them. Call it before the first Send.
+func (c *SimpleSMTPClient) SetDANE(lookup func(ctx context.Context, host string) ([]TLSARecord, error)) {
+	c.lookupTLSA = lookup
+}
+
+// tlsaRecords returns host's usable TLSA records, none if it has none or
+// they can't be looked up.
+func (c *SimpleSMTPClient) tlsaRecords(ctx context.Context, host string) []TLSARecord {
+	if c.lookupTLSA == nil {
+		return nil
+	}
+	records, err := c.lookupTLSA(ctx, host)
+	if err != nil {
+		logctx.Printf(ctx, "TLSA lookup for %s failed, continuing without DANE: %v", host, err)
+		return nil
+	}
+	usable := records[:0:0]
+	for _, r := range records {
+		if r.usable() {
+			usable = append(usable, r)
+		}
+	}
+	return usable
+}
+
+// LookupTLSA returns the TLSA records of MX host, given as host or
+// host:port, from _<port>._tcp.<host>. Only records the DNS server marks
+// as authenticated by DNSSEC are returned, so DANE needs a validating
+// resolver; hosts given as IP addresses have none.
+func (d *dnsResolver) LookupTLSA(ctx context.Context, host string) ([]TLSARecord, error) {
+	name, port := host, "25"
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		name, port = h, p
+	}
+	if net.ParseIP(name) != nil {
+		return nil, nil
+	}
+	
+	timeout := d.timeout
+	if timeout <= 0 {
+		timeout = defaultTLSATimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	
+	server := d.server
+	if server == "" {
+		server = systemNameserver()
+	}
+	return queryTLSA(ctx, server, "_"+port+"._tcp."+strings.TrimSuffix(name, "."))
+}
+
+// systemNameserver returns the first nameserver in /etc/resolv.conf.
+func systemNameserver() string {
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// queryTLSA asks server for name's TLSA records over UDP, retrying over
+// TCP if the answer is truncated.
+func queryTLSA(ctx context.Context, server, name string) ([]TLSARecord, error) {
+	query, id, err := tlsaQuery(name)
+	if err != nil {
+		return nil, err
+	}
+	
+	var dialer net.Dialer
+	resp, err := exchangeDNS(ctx, &dialer, "udp", server, query)
+	if err == nil && len(resp) > 2 && resp[2]&0x02 != 0 {
+		resp, err = exchangeDNS(ctx, &dialer, "tcp", server, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseTLSAResponse(resp, id)
+}
+
+func exchangeDNS(ctx context.Context, dialer *net.Dialer, network, server string, query []byte) ([]byte, error) {
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err = io.ReadFull(conn, resp)
+	return resp, err
+}
+
+// tlsaQuery builds a recursive TLSA query for name that asks for DNSSEC
+// validation, returning it and its id.
+func tlsaQuery(name string) ([]byte, uint16, error) {
+	id := uint16(rand.Intn(1 << 16))
+	// RD and AD set, one question and one additional record
+	msg := []byte{byte(id >> 8), byte(id), 0x01, 0x20, 0, 1, 0, 0, 0, 0, 0, 1}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, fmt.Errorf("invalid DNS name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, dnsTypeTLSA, 0, 1)
+	// EDNS0 with a 4096 byte UDP size and the DO bit
+	msg = append(msg, 0, 0, dnsTypeOPT, 0x10, 0x00, 0, 0, 0x80, 0x00, 0, 0)
+	return msg, id, nil
+}
+
+var errDNSMessage = errors.New("malformed DNS response")
+
+// parseTLSAResponse returns the TLSA records answering query id, or none
+// if the response is not authenticated or the name does not exist.
+func parseTLSAResponse(resp []byte, id uint16) ([]TLSARecord, error) {
+	if len(resp) < 12 || binary.BigEndian.Uint16(resp) != id || resp[2]&0x80 == 0 {
+		return nil, errDNSMessage
+	}
+	switch rcode := resp[3] & 0x0f; rcode {
+	case 0:
+	case 3:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("DNS server returned rcode %d", rcode)
+	}
+	if resp[3]&0x20 == 0 {
+		// Not validated, so not to be relied on
+		return nil, nil
+	}
+	
+	questions := int(binary.BigEndian.Uint16(resp[4:]))
+	answers := int(binary.BigEndian.Uint16(resp[6:]))
+	off := 12
+	for i := 0; i < questions; i++ {
+		var ok bool
+		if off, ok = skipDNSName(resp, off); !ok || off+4 > len(resp) {
+			return nil, errDNSMessage
+		}
+		off += 4
+	}
+	
+	var records []TLSARecord
+	for i := 0; i < answers; i++ {
+		var ok bool
+		if off, ok = skipDNSName(resp, off); !ok || off+10 > len(resp) {
+			return nil, errDNSMessage
+		}
+		rtype := binary.BigEndian.Uint16(resp[off:])
+		length := int(binary.BigEndian.Uint16(resp[off+8:]))
+		off += 10
+		if off+length > len(resp) {
+			return nil, errDNSMessage
+		}
+		if rtype == dnsTypeTLSA && length > 3 {
+			rdata := resp[off : off+length]
+			records = append(records, TLSARecord{
+				Usage:        rdata[0],
+				Selector:     rdata[1],
+				MatchingType: rdata[2],
+				Data:         append([]byte(nil), rdata[3:]...),
+			})
+		}
+		off += length
+	}
+	return records, nil
+}
+
+// skipDNSName returns the offset just past the name at off.
+func skipDNSName(msg []byte, off int) (int, bool) {
+	for off < len(msg) {
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			return off + 1, true
+		case n&0xc0 == 0xc0:
+			// Compression pointer, which ends the name
+			return off + 2, off+2 <= len(msg)
+		default:
+			off += n + 1
+		}
+	}
+	return off, false
+}
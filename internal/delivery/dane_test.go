@@ -0,0 +1,260 @@
+package delivery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+)
+
+// daneFixture is a CA and a leaf certificate it issued for
+// mx.example.com and 127.0.0.1.
+type daneFixture struct {
+	ca, leaf *x509.Certificate
+	tls      *tls.Config
+}
+
+func newDANEFixture(t *testing.T, notAfter time.Time) *daneFixture {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "mx.example.com"},
+		DNSNames:     []string{"mx.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(leafDER)
+	
+	return &daneFixture{
+		ca:   ca,
+		leaf: leaf,
+		tls: &tls.Config{Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leafDER, caDER},
+			PrivateKey:  leafKey,
+		}}},
+	}
+}
+
+func sha256Of(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func TestTLSARecord_Matches(t *testing.T) {
+	f := newDANEFixture(t, time.Now().Add(time.Hour))
+	sum512 := sha512.Sum512(f.leaf.RawSubjectPublicKeyInfo)
+	
+	tests := []struct {
+		name   string
+		record TLSARecord
+		want   bool
+	}{
+		{"full cert", TLSARecord{TLSAUsageDANEEE, TLSASelectorCert, TLSAMatchFull, f.leaf.Raw}, true},
+		{"cert SHA-256", TLSARecord{TLSAUsageDANEEE, TLSASelectorCert, TLSAMatchSHA256, sha256Of(f.leaf.Raw)}, true},
+		{"SPKI SHA-256", TLSARecord{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchSHA256, sha256Of(f.leaf.RawSubjectPublicKeyInfo)}, true},
+		{"SPKI SHA-512", TLSARecord{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchSHA512, sum512[:]}, true},
+		{"full SPKI", TLSARecord{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchFull, f.leaf.RawSubjectPublicKeyInfo}, true},
+		{"selector mismatch", TLSARecord{TLSAUsageDANEEE, TLSASelectorCert, TLSAMatchSHA256, sha256Of(f.leaf.RawSubjectPublicKeyInfo)}, false},
+		{"other cert", TLSARecord{TLSAUsageDANEEE, TLSASelectorCert, TLSAMatchSHA256, sha256Of(f.ca.Raw)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.record.matches(f.leaf); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	
+	// PKIX usages are not usable for SMTP
+	if (TLSARecord{Usage: 1, MatchingType: TLSAMatchSHA256}).usable() {
+		t.Error("Expected a PKIX-EE record to be unusable")
+	}
+}
+
+func TestVerifyDANE(t *testing.T) {
+	valid := newDANEFixture(t, time.Now().Add(time.Hour))
+	expired := newDANEFixture(t, time.Now().Add(-time.Hour))
+	ee := func(f *daneFixture) TLSARecord {
+		return TLSARecord{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchSHA256, sha256Of(f.leaf.RawSubjectPublicKeyInfo)}
+	}
+	ta := func(f *daneFixture) TLSARecord {
+		return TLSARecord{TLSAUsageDANETA, TLSASelectorCert, TLSAMatchSHA256, sha256Of(f.ca.Raw)}
+	}
+	
+	tests := []struct {
+		name       string
+		chain      []*x509.Certificate
+		serverName string
+		records    []TLSARecord
+		wantErr    bool
+	}{
+		{name: "DANE-EE", chain: []*x509.Certificate{valid.leaf}, serverName: "mx.example.com", records: []TLSARecord{ee(valid)}},
+		// DANE-EE ignores names and expiry
+		{name: "DANE-EE other name, expired", chain: []*x509.Certificate{expired.leaf}, serverName: "other.example.net", records: []TLSARecord{ee(expired)}},
+		{name: "DANE-EE mismatch", chain: []*x509.Certificate{valid.leaf}, serverName: "mx.example.com", records: []TLSARecord{ee(expired)}, wantErr: true},
+		{name: "DANE-TA", chain: []*x509.Certificate{valid.leaf, valid.ca}, serverName: "mx.example.com", records: []TLSARecord{ta(valid)}},
+		{name: "DANE-TA wrong name", chain: []*x509.Certificate{valid.leaf, valid.ca}, serverName: "other.example.net", records: []TLSARecord{ta(valid)}, wantErr: true},
+		{name: "DANE-TA expired", chain: []*x509.Certificate{expired.leaf, expired.ca}, serverName: "mx.example.com", records: []TLSARecord{ta(expired)}, wantErr: true},
+		{name: "DANE-TA not in chain", chain: []*x509.Certificate{valid.leaf}, serverName: "mx.example.com", records: []TLSARecord{ta(valid)}, wantErr: true},
+		{name: "DANE-TA other CA", chain: []*x509.Certificate{valid.leaf, valid.ca}, serverName: "mx.example.com", records: []TLSARecord{ta(expired)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyDANE(tt.chain, tt.serverName, tt.records)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyDANE() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeTLSAServer answers every query on a local UDP port with one TLSA
+// record, marked authenticated if authenticated is set.
+func fakeTLSAServer(t *testing.T, record TLSARecord, authenticated bool) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			end, ok := skipDNSName(buf[:n], 12)
+			if !ok {
+				continue
+			}
+			// The query's header and question, answered
+			resp := append([]byte(nil), buf[:end+4]...)
+			resp[2], resp[3] = 0x81, 0x80
+			if authenticated {
+				resp[3] |= 0x20
+			}
+			binary.BigEndian.PutUint16(resp[6:], 1)
+			binary.BigEndian.PutUint16(resp[10:], 0)
+			rdata := append([]byte{record.Usage, record.Selector, record.MatchingType}, record.Data...)
+			resp = append(resp, 0xc0, 12, 0, dnsTypeTLSA, 0, 1, 0, 0, 1, 0)
+			resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+			conn.WriteTo(append(resp, rdata...), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDNSResolver_LookupTLSA(t *testing.T) {
+	ctx := context.Background()
+	record := TLSARecord{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchSHA256, sha256Of([]byte("key"))}
+	
+	secure := newDNSResolver(&config.DeliveryConfig{DNSServer: fakeTLSAServer(t, record, true), DNSTimeout: 2 * time.Second})
+	records, err := secure.LookupTLSA(ctx, "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Usage != record.Usage || string(records[0].Data) != string(record.Data) {
+		t.Errorf("Expected the TLSA record, got %+v", records)
+	}
+	
+	// Unauthenticated answers are ignored
+	insecure := newDNSResolver(&config.DeliveryConfig{DNSServer: fakeTLSAServer(t, record, false), DNSTimeout: 2 * time.Second})
+	if records, err := insecure.LookupTLSA(ctx, "mx.example.com"); err != nil || len(records) != 0 {
+		t.Errorf("Expected no records without DNSSEC, got %+v, %v", records, err)
+	}
+	
+	// IP literals have no TLSA records and are not looked up
+	if records, err := secure.LookupTLSA(ctx, "127.0.0.1:2525"); err != nil || len(records) != 0 {
+		t.Errorf("Expected no records for an IP, got %+v, %v", records, err)
+	}
+}
+
+func TestSMTPClient_DANE(t *testing.T) {
+	f := newDANEFixture(t, time.Now().Add(time.Hour))
+	other := newDANEFixture(t, time.Now().Add(time.Hour))
+	secure := startSinkServer(t, &sinkServer{tls: f.tls})
+	plain := newSinkServer(t, false)
+	ee := func(f *daneFixture) []TLSARecord {
+		return []TLSARecord{{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchSHA256, sha256Of(f.leaf.RawSubjectPublicKeyInfo)}}
+	}
+	
+	tests := []struct {
+		name          string
+		server        *sinkServer
+		records       []TLSARecord
+		wantDelivered bool
+	}{
+		{name: "matching record", server: secure, records: ee(f), wantDelivered: true},
+		{name: "mismatching record", server: secure, records: ee(other)},
+		{name: "no STARTTLS", server: plain, records: ee(f)},
+		{name: "no records", server: plain, wantDelivered: true},
+		{name: "only unusable records", server: plain, records: []TLSARecord{{Usage: 1, MatchingType: TLSAMatchSHA256}}, wantDelivered: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewSMTPClient(5 * time.Second)
+			client.SetDANE(func(ctx context.Context, host string) ([]TLSARecord, error) {
+				return tt.records, nil
+			})
+			
+			messages := tt.server.messages.Load()
+			err := client.Send(context.Background(), tt.server.addr(), poolTestEmail(), []string{"rcpt@test.com"})
+			delivered := tt.server.messages.Load() > messages
+			if tt.wantDelivered {
+				if err != nil || !delivered {
+					t.Fatalf("Expected delivery, got %v", err)
+				}
+				return
+			}
+			if delivered || !errors.Is(err, ErrTLSRequired) {
+				t.Fatalf("Expected ErrTLSRequired without delivery, got %v", err)
+			}
+			if permanent(err) {
+				t.Errorf("Expected a temporary failure, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package delivery
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// deferralError marks a failure where delivery was never attempted, such
+// as a resolver outage. The email is postponed without using up a retry,
+// for delay or the queue's defer delay if zero.
+type deferralError struct {
+	err   error
+	delay time.Duration
+}
+
+func (d *deferralError) Error() string { return d.err.Error() }
+func (d *deferralError) Unwrap() error { return d.err }
+
+func deferred(err error) error {
+	return &deferralError{err: err}
+}
+
+// deferredFor is deferred with a delay of its own.
+func deferredFor(err error, delay time.Duration) error {
+	return &deferralError{err: err, delay: delay}
+}
+
+func isDeferral(err error) bool {
+	var d *deferralError
+	return errors.As(err, &d)
+}
+
+// deferralDelay returns how long a deferral asks to be postponed for, zero
+// for the queue's defer delay.
+func deferralDelay(err error) time.Duration {
+	var d *deferralError
+	if errors.As(err, &d) {
+		return d.delay
+	}
+	return 0
+}
+
+// greetingError is a temporary refusal in a server's greeting, such as
+// "421 too busy" on connect. Nothing was sent, so the next MX host is
+// tried, and if every host refuses this way the email is deferred
+// briefly instead of using up a retry.
+type greetingError struct {
+	err error
+}
+
+func (g *greetingError) Error() string { return "greeting refused: " + g.err.Error() }
+func (g *greetingError) Unwrap() error { return g.err }
+
+func isGreetingDeferral(err error) bool {
+	var g *greetingError
+	return errors.As(err, &g)
+}
+
+// lookupFailed classifies an MX lookup error. A definitive answer that the
+// domain does not exist or does not accept mail counts as an attempt;
+// anything else, such as a timeout or SERVFAIL, says nothing about the
+// destination and is deferred.
+func lookupFailed(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound || errors.Is(err, ErrNullMX) {
+		return err
+	}
+	return deferred(err)
+}
@@ -2,24 +2,43 @@ package delivery
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"math/rand"
 	"net"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	
 	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/lifecycle"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
 	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/internal/resource"
 	"github.com/tpdoyle87/simple-email-server/pkg/email"
 )
 
 type DNSResolver interface {
-	LookupMX(domain string) ([]*net.MX, error)
+	LookupMX(ctx context.Context, domain string) ([]*net.MX, error)
 }
 
+// HostResolver is implemented by resolvers that can also look up a host's
+// addresses. It lets delivery fall back to a domain's implicit MX, its
+// own A or AAAA record, when the domain has no MX records.
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// SMTPClient sends an email to host in one SMTP transaction. Only rcpts
+// are given in the envelope, so an email with recipients at several
+// domains is sent once per domain.
 type SMTPClient interface {
-	Send(ctx context.Context, host string, email *email.Email) error
+	Send(ctx context.Context, host string, email *email.Email, rcpts []string) error
 }
 
 type Service struct {
@@ -29,162 +48,812 @@ type Service struct {
 	client   SMTPClient
 	maxRetry int
 	
-	dnsCache     map[string]*dnsCacheEntry
-	dnsCacheMu   sync.RWMutex
+	// Sends nothing, for sandboxed mail
+	sandbox SMTPClient
+	
+	dnsCache *dnsCache
+	lookups  *mxLookups
+	
+	race     raceCounters
+	failures *failureLog
+	monitor  *resource.Monitor
+	hooks    []PreDeliveryHook
+	
+	// Checked just before sending
+	suppression SuppressionList
+	limiter     *domainLimiter
+	slots       *domainSlots
+	breakers    *domainBreakers
+	pauses      *domainPauses
+	warmup      *warmup
+	
+	// The pause of all outbound delivery
+	hold *deliveryPause
+	
+	// When each lane's mail may be delivered; lanes without windows are
+	// delivered at any time
+	windows map[email.Lane][]config.Window
+	
+	// MX hosts that recently failed, tried last
+	hosts *mxHealth
+	
+	// Refusals because of the sending IP's reputation
+	reputation *reputation
+	
+	// Recipient domains' MTA-STS policies, nil if disabled
+	sts *mtaSTS
+	
+	// Configured STARTTLS policy of each recipient domain
+	tlsPolicies *tlsPolicies
+	
+	// Relays and direct delivery by recipient domain, nil if everything
+	// goes to MX hosts
+	routes *routeTable
+	
+	// The catch-all address test mail goes to, nil if none
+	redirect *redirector
+	
+	// The domains this server hosts and where their mail is stored
+	local        map[string]bool
+	localHandler LocalHandler
+	
+	resultHook func(Result)
+	
+	// The running workers; see SetWorkers
+	pool workerPool
+	
+	// The outcome of the startup check that port 25 is open
+	egress atomic.Pointer[EgressStatus]
+	
+	// Cancelled when Stop's deadline passes, aborting deliveries under way
+	aborted context.Context
+	abort   context.CancelFunc
 	
 	wg           sync.WaitGroup
+	lifecycle    lifecycle.Lifecycle
 }
 
-type dnsCacheEntry struct {
-	mx        []*net.MX
-	expiresAt time.Time
+// dnsResolver is the default resolver: the system's, or one querying a
+// configured DNS server, with each lookup bounded by timeout if set.
+type dnsResolver struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+	lookupMX func(context.Context, string) ([]*net.MX, error)
+	
+	// The configured server's address, empty for the system's
+	server string
 }
 
-type dnsResolver struct {
-	lookupMX func(string) ([]*net.MX, error)
+// newDNSResolver returns the resolver configured by cfg. Without a server
+// or timeout it behaves exactly like the system resolver.
+func newDNSResolver(cfg *config.DeliveryConfig) *dnsResolver {
+	d := &dnsResolver{resolver: net.DefaultResolver, timeout: cfg.DNSTimeout}
+	if cfg.DNSServer == "" {
+		return d
+	}
+	server, err := config.DNSServerAddress(cfg.DNSServer)
+	if err != nil {
+		log.Printf("Ignoring DNS server %q, using the system resolver: %v", cfg.DNSServer, err)
+		return d
+	}
+	d.server = server
+	d.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+	return d
+}
+
+// withTimeout bounds a lookup by the resolver's timeout, if any.
+func (d *dnsResolver) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d.timeout)
 }
 
-func (d *dnsResolver) LookupMX(domain string) ([]*net.MX, error) {
+func (d *dnsResolver) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
 	if d.lookupMX != nil {
-		return d.lookupMX(domain)
+		return d.lookupMX(ctx, domain)
 	}
-	return net.LookupMX(domain)
+	return d.resolver.LookupMX(ctx, domain)
+}
+
+func (d *dnsResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+	return d.resolver.LookupHost(ctx, host)
+}
+
+func (d *dnsResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+	return d.resolver.LookupIPAddr(ctx, host)
+}
+
+func (d *dnsResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+	return d.resolver.LookupTXT(ctx, name)
 }
 
 func NewService(cfg *config.DeliveryConfig, q queue.Queue) *Service {
+	resolver := newDNSResolver(cfg)
+	client := newClient(cfg)
+	client.SetDANE(resolver.LookupTLSA)
+	client.lookupIP = resolver.LookupIPAddr
+	aborted, abort := context.WithCancel(context.Background())
+	
 	return &Service{
 		config:   cfg,
 		queue:    q,
-		resolver: &dnsResolver{},
-		client:   NewSMTPClient(cfg.ConnectionTimeout),
-		dnsCache: make(map[string]*dnsCacheEntry),
-		maxRetry: 5, // Default max retry
+		resolver: resolver,
+		client:   client,
+		sandbox:  &SandboxClient{Dir: cfg.SandboxDir, Identity: cfg.Identity},
+		dnsCache: newDNSCache(cfg),
+		lookups:  newMXLookups(),
+		failures: newFailureLog(cfg.FailureLogWindow),
+		maxRetry: maxRetry(cfg),
+		limiter:  newDomainLimiter(cfg),
+		slots:    newDomainSlots(cfg),
+		breakers: newDomainBreakers(cfg),
+		pauses:   newDomainPauses(cfg),
+		warmup:   newWarmup(cfg),
+		hold:     newDeliveryPause(cfg),
+		windows:  newSendingWindows(cfg),
+		hosts:    newMXHealth(cfg),
+		
+		reputation: newReputation(cfg),
+		sts:        newMTASTS(cfg),
+		
+		tlsPolicies: newTLSPolicies(cfg),
+		routes:      newRoutes(cfg),
+		redirect:    newRedirector(cfg),
+		
+		local:        newLocalDomains(cfg),
+		localHandler: newLocalHandler(cfg),
+		
+		aborted: aborted,
+		abort:   abort,
 	}
 }
 
-func (s *Service) Start(ctx context.Context) {
-	log.Printf("Starting delivery service with %d workers", s.config.Workers)
+// newClient returns the SMTP client for cfg, pooling sessions unless the
+// pool size is zero or negative.
+func newClient(cfg *config.DeliveryConfig) *SimpleSMTPClient {
+	client := NewSMTPClient(cfg.ConnectionTimeout)
+	client.SetTLSMinVersion(tlsVersions[cfg.TLSMinVersion])
+	client.SetIdentity(cfg.Identity)
+	client.ipFamily = cfg.IPFamily
+	client.sourceIPs = newSourceIPs(cfg.SourceIPs, cfg.SourceIPStrategy)
+	if cfg.ConnectionPoolSize > 0 {
+		client.SetPool(cfg.ConnectionPoolSize, cfg.ConnectionIdleTimeout)
+	}
+	return client
+}
+
+// maxRetry is the configured retry limit, 5 if unset.
+func maxRetry(cfg *config.DeliveryConfig) int {
+	if cfg.MaxRetry > 0 {
+		return cfg.MaxRetry
+	}
+	return 5
+}
+
+// retries reports whether e may be retried after a failed attempt: its own
+// limit if it has one, otherwise the service's.
+func (s *Service) retries(e *email.Email) bool {
+	limit := s.maxRetry
+	if e.MaxRetry != nil {
+		limit = *e.MaxRetry
+	}
+	return e.RetryCount < limit
+}
+
+// SetMonitor makes the service halve its worker concurrency while the
+// monitor reports resource pressure.
+func (s *Service) SetMonitor(m *resource.Monitor) {
+	s.monitor = m
+}
+
+// Start runs the workers until ctx is cancelled or Stop is called. It
+// returns lifecycle.ErrAlreadyRunning if the service is already running,
+// or an error without starting if a configured source IP can't be bound.
+// With delivery.egress_probe.required it returns an error before starting
+// workers if port 25 is blocked. A stopped service, including one stopped
+// that way, cannot be restarted.
+func (s *Service) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	
+	if err := newSourceIPs(s.config.SourceIPs, s.config.SourceIPStrategy).check(); err != nil {
+		return err
+	}
+	if err := s.lifecycle.Start(func() error { cancel(); return nil }); err != nil {
+		return err
+	}
+	defer s.lifecycle.Finished()
+	
+	// Network checks only once this call is the one starting the service
+	if err := s.probeEgress(ctx); err != nil {
+		return err
+	}
+	if s.config.Identity != nil {
+		s.config.Identity.Warn(ctx, net.DefaultResolver)
+	}
+	
+	reserved := s.reservedWorkers()
+	log.Printf("Starting delivery service with %d workers (%d reserved for transactional mail)",
+		s.config.Workers, reserved)
+	if s.routes != nil {
+		log.Printf("Routing mail by recipient domain with %d routes", s.routes.len())
+		if r := s.routes.fallback; r != nil && r.relay != nil {
+			log.Printf("Relaying mail without a route through %s", r.relay.addr)
+		}
+	}
 	
-	// Start workers
-	for i := 0; i < s.config.Workers; i++ {
+	// Start workers; the first few only take transactional mail. A
+	// worker that dies of a panic is started again.
+	s.startWorkers(ctx)
+	
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.failures.run(ctx)
+	}()
+	if s.warmup != nil {
 		s.wg.Add(1)
-		go s.worker(ctx, i)
+		go func() {
+			defer s.wg.Done()
+			s.warmup.run(ctx)
+		}()
 	}
 	
 	// Wait for context cancellation
 	<-ctx.Done()
 	
 	log.Println("Stopping delivery service...")
+	s.closeWorkers()
 	s.wg.Wait()
+	if s.warmup != nil {
+		// After the workers, so the last deliveries are counted
+		s.warmup.flush()
+	}
+	if closer, ok := s.client.(io.Closer); ok {
+		closer.Close()
+	}
+	for _, r := range s.routes.relays() {
+		r.client.Close()
+	}
 	log.Println("Delivery service stopped")
+	return nil
+}
+
+// Stop stops the workers and waits for Start to return. No new delivery
+// starts and emails workers had taken but not started go back to the
+// queue. Deliveries under way may finish until ctx is done, or
+// delivery.shutdown_timeout passes if sooner; then they are aborted and
+// their emails requeued without using up a retry. Stop returns an error
+// if it had to abort deliveries because ctx was done. It is safe to call
+// more than once.
+func (s *Service) Stop(ctx context.Context) error {
+	abort := context.AfterFunc(ctx, s.abort)
+	s.lifecycle.Stop()
+	if !abort() {
+		return fmt.Errorf("delivery service stopped with deliveries aborted: %w", ctx.Err())
+	}
+	return nil
+}
+
+// reservedWorkers returns how many of the configured workers are
+// dedicated to the transactional lane.
+func (s *Service) reservedWorkers() int {
+	return s.reservedFor(s.config.Workers)
 }
 
-func (s *Service) worker(ctx context.Context, id int) {
-	defer s.wg.Done()
+// reservedFor returns how many of n workers are dedicated to the
+// transactional lane. At least one worker is always left for bulk mail.
+func (s *Service) reservedFor(n int) int {
+	var ratio float64
+	if s.config.TransactionalWorkerRatio != nil {
+		ratio = *s.config.TransactionalWorkerRatio
+	}
+	reserved := int(math.Ceil(float64(n) * ratio))
+	if reserved >= n {
+		reserved = n - 1
+	}
+	if reserved < 0 {
+		reserved = 0
+	}
+	return reserved
+}
+
+// Drain waits for the queue to finish delivering what it holds while
+// workers keep running. Queues that implement queue.Drainer also stop
+// accepting new emails. It returns ctx's error if the deadline passes
+// first; the caller then stops the service and persists the remainder.
+func (s *Service) Drain(ctx context.Context) error {
+	log.Printf("Draining delivery queue (%d emails)", s.queue.Size())
+	
+	var err error
+	if d, ok := s.queue.(queue.Drainer); ok {
+		err = d.Drain(ctx)
+	} else {
+		err = s.waitEmpty(ctx)
+	}
 	
-	ticker := time.NewTicker(1 * time.Second)
+	if err != nil {
+		log.Printf("Drain deadline reached with %d emails remaining", s.queue.Size())
+		return err
+	}
+	log.Println("Delivery queue drained")
+	return nil
+}
+
+// waitEmpty polls until the queue is empty or ctx is done.
+func (s *Service) waitEmpty(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 	
-	for {
+	for s.queue.Size() > 0 {
 		select {
 		case <-ctx.Done():
-			return
+			return ctx.Err()
 		case <-ticker.C:
-			// Dequeue emails
-			emails, err := s.queue.Dequeue(10)
-			if err != nil {
-				log.Printf("Worker %d: Failed to dequeue emails: %v", id, err)
-				continue
-			}
-			
-			// Process emails
-			for _, e := range emails {
-				if err := s.processEmail(ctx, e); err != nil {
-					log.Printf("Worker %d: Failed to deliver email %s: %v", id, e.ID, err)
-					
-					// Mark as failed with retry
-					shouldRetry := e.RetryCount < s.maxRetry
-					if err := s.queue.MarkFailed(e.ID, err.Error(), shouldRetry); err != nil {
-						log.Printf("Worker %d: Failed to mark email %s as failed: %v", id, e.ID, err)
-					}
-				} else {
-					// Mark as delivered
-					if err := s.queue.MarkDelivered(e.ID); err != nil {
-						log.Printf("Worker %d: Failed to mark email %s as delivered: %v", id, e.ID, err)
-					}
-				}
+		}
+	}
+	return nil
+}
+
+// throttled reports whether worker id should sit out while resources are
+// constrained. The lowest IDs, including the transactional workers, keep
+// running.
+func (s *Service) throttled(id int) bool {
+	if s.monitor == nil || !s.monitor.Degraded() {
+		return false
+	}
+	
+	active := s.Workers() / 2
+	if active < 1 {
+		active = 1
+	}
+	return id >= active
+}
+
+// worker delivers emails from lane, or from every lane when lane is empty,
+// until ctx is done or stop is closed. It waits for the queue to signal
+// new mail, falling back to polling once a second so scheduled and
+// retried emails are picked up when due.
+func (s *Service) worker(ctx context.Context, id int, lane email.Lane, stop <-chan struct{}) {
+	ctx = logctx.With(ctx, "worker", id)
+	notifier, _ := s.queue.(queue.Notifier)
+	
+	fallback := time.NewTicker(1 * time.Second)
+	defer fallback.Stop()
+	
+	sizer := newBatchSizer(s.batchSize())
+	for {
+		if ctx.Err() != nil || stopped(stop) {
+			return
+		}
+		
+		// Idle while all delivery is paused
+		if paused, resumed := s.hold.paused(); paused {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			case <-resumed:
 			}
+			continue
+		}
+		
+		// Take the wakeup channel before dequeuing so an Enqueue that
+		// races with the dequeue is not missed
+		var wake <-chan struct{}
+		if notifier != nil {
+			wake = notifier.Notify()
+		}
+		
+		// A full batch suggests more is waiting
+		size := sizer.size
+		start := time.Now()
+		n := s.pollBatch(ctx, id, lane, size)
+		sizer.observe(n, time.Since(start))
+		if n == size {
+			continue
+		}
+		
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-wake:
+		case <-fallback.C:
+		}
+	}
+}
+
+// poll dequeues and delivers one batch of the configured size, returning
+// how many emails it took.
+func (s *Service) poll(ctx context.Context, id int, lane email.Lane) int {
+	return s.pollBatch(ctx, id, lane, s.batchSize())
+}
+
+// pollBatch dequeues and delivers up to size emails, returning how many it
+// took.
+func (s *Service) pollBatch(ctx context.Context, id int, lane email.Lane, size int) int {
+	if s.throttled(id) {
+		return 0
+	}
+	
+	emails, err := s.queue.DequeueLane(ctx, lane, size)
+	if err != nil {
+		if ctx.Err() == nil {
+			logctx.Printf(ctx, "Failed to dequeue emails: %v", err)
+		}
+		return 0
+	}
+	
+	defer s.releaseOnPanic(ctx, emails)
+	deliverCtx, cancel := s.shutdownContext(ctx)
+	defer cancel()
+	s.deliverBatches(deliverCtx, batchByDomain(emails))
+	return len(emails)
+}
+
+// deliver attempts a single email and records the outcome in the queue.
+func (s *Service) deliver(ctx context.Context, e *email.Email) {
+	emailCtx, trace := withAttempt(emailContext(ctx, e), e)
+	
+	// Outcomes are recorded even if shutdown cancels the attempt
+	resultCtx := context.WithoutCancel(emailCtx)
+	defer s.recoverDelivery(resultCtx, e, trace)
+	
+	if s.shuttingDown(emailCtx, resultCtx, e) {
+		return
+	}
+	if s.deliveryPaused(emailCtx, resultCtx, e) {
+		return
+	}
+	if s.outsideWindow(emailCtx, resultCtx, e) {
+		return
+	}
+	if e = s.preDeliver(emailCtx, resultCtx, e); e == nil {
+		return
+	}
+	if e = s.recheck(emailCtx, resultCtx, e); e == nil {
+		return
+	}
+	if s.domainPaused(emailCtx, resultCtx, e) {
+		return
+	}
+	if s.circuitOpen(emailCtx, resultCtx, e) {
+		return
+	}
+	if s.warmupLimited(emailCtx, resultCtx, e) {
+		return
+	}
+	if s.throttle(emailCtx, resultCtx, e) {
+		return
+	}
+	
+	results, err := s.processEmail(emailCtx, e)
+	
+	// Recipients still waiting when the email fails for good fail with it
+	shouldRetry := s.retries(e) && !isRejected(err)
+	if err != nil && !shouldRetry && !isDeferral(err) {
+		failOutstanding(results)
+	}
+	s.updateRecipients(resultCtx, e, results)
+	s.recordAttempts(resultCtx, e, trace)
+	s.notifySender(resultCtx, e, results)
+	
+	if err != nil {
+		// An attempt cut short by shutdown is tried again on the next
+		// start as though it had not been made
+		if interrupted(emailCtx, err) {
+			logctx.Printf(resultCtx, "Delivery interrupted by shutdown, returning email to the queue")
+			s.postponeEmail(resultCtx, e, "delivery interrupted by shutdown", 0)
+			return
+		}
+		s.failures.count(err)
+		
+		// Postpone without using up a retry if nothing was attempted
+		if isDeferral(err) {
+			s.deferEmail(resultCtx, e, err.Error(), deferralDelay(err))
+			return
+		}
+		
+		// Mark as failed with retry
+		if err := s.markFailed(resultCtx, e, err, shouldRetry); err != nil {
+			logctx.Printf(resultCtx, "Failed to mark email as failed: %v", err)
+			return
+		}
+		status := email.StatusFailed
+		switch {
+		case shouldRetry:
+			status = email.StatusQueued
+		case isRejected(err):
+			status = email.StatusBounced
+		}
+		s.report(e, trace, status, err, results)
+	} else {
+		// Mark as delivered
+		if err := s.queue.MarkDelivered(resultCtx, e.ID); err != nil {
+			logctx.Printf(resultCtx, "Failed to mark email as delivered: %v", err)
+			return
 		}
+		s.report(e, trace, email.StatusDelivered, nil, results)
 	}
 }
 
-func (s *Service) processEmail(ctx context.Context, e *email.Email) error {
-	// Extract domain from first recipient
-	if len(e.To) == 0 {
-		return fmt.Errorf("no recipients")
+// markFailed records a failed attempt at e, with its failure reason if
+// the queue breaks failures down by category and its classification if
+// the queue keeps one.
+func (s *Service) markFailed(ctx context.Context, e *email.Email, err error, retry bool) error {
+	id := e.ID
+	if r, ok := s.queue.(queue.FailureRecorder); ok {
+		return r.RecordFailure(ctx, id, err.Error(), FailureReason(err), Classify(err, e.RetryCount), retry)
+	}
+	if c, ok := s.queue.(queue.FailureCategorizer); ok {
+		return c.MarkFailedCategory(ctx, id, err.Error(), FailureReason(err), retry)
+	}
+	return s.queue.MarkFailed(ctx, id, err.Error(), retry)
+}
+
+// emailContext derives the per-email context used for delivery, carrying
+// the email ID and attempt number for logging.
+func emailContext(ctx context.Context, e *email.Email) context.Context {
+	ctx = logctx.With(ctx, "email_id", e.ID)
+	return logctx.With(ctx, "attempt", e.RetryCount+1)
+}
+
+// processEmail delivers e to its outstanding recipients in one
+// transaction per domain. It returns the outcome for each recipient it
+// attempted, and an error if some are left to retry or every recipient
+// was refused.
+func (s *Service) processEmail(ctx context.Context, e *email.Email) (map[string]email.RecipientStatus, error) {
+	e.EnvelopeFrom = s.envelopeFrom(e)
+	groups, err := groupRecipients(e)
+	if err != nil {
+		logctx.Printf(ctx, "Failed to deliver email: %v", err)
+		return nil, err
+	}
+	
+	results := make(map[string]email.RecipientStatus)
+	if err := s.checkLoop(e); err != nil {
+		logctx.Printf(ctx, "Failed to deliver email: %v", err)
+		for _, g := range groups {
+			recordOutcome(results, g.rcpts, err)
+		}
+		return results, &rejectedError{err: err}
+	}
+	
+	groups, redirected := s.redirect.apply(groups)
+	if len(redirected) > 0 {
+		e.RecordRedirect(s.redirect.to, redirected)
 	}
 	
-	domain := extractDomain(e.To[0])
-	if domain == "" {
-		return fmt.Errorf("invalid recipient domain")
+	var retry, refused []*domainError
+	for _, g := range groups {
+		var err error
+		switch {
+		case g.redirectTo != "":
+			err = s.deliverRedirected(ctx, e, g)
+		case s.local[g.domain]:
+			err = s.deliverLocal(ctx, e, g.rcpts)
+		default:
+			err = s.deliverDomain(ctx, e, g.domain, g.rcpts)
+		}
+		if err == nil {
+			s.failures.recovered(ctx, g.domain)
+		} else {
+			s.failures.failure(ctx, g.domain, err)
+		}
+		s.recordReputation(ctx, g.domain, err)
+		s.recordCircuit(ctx, g.domain, err)
+		
+		temporary, permanent := recordOutcome(results, g.rcpts, err)
+		s.countWarmup(g.domain, g.rcpts, results)
+		if temporary != nil {
+			retry = append(retry, &domainError{domain: g.domain, err: temporary})
+		}
+		if permanent != nil {
+			refused = append(refused, &domainError{domain: g.domain, err: permanent})
+		}
+	}
+	
+	if len(retry) > 0 {
+		if len(groups) == 1 {
+			return results, retry[0].err
+		}
+		return results, joinDomainErrors(retry)
+	}
+	if len(refused) > 0 && !anyDelivered(e, results) {
+		if len(groups) == 1 {
+			return results, &rejectedError{err: refused[0].err}
+		}
+		return results, &rejectedError{err: joinDomainErrors(refused)}
+	}
+	return results, nil
+}
+
+// deliverDomain sends e to rcpts, all at domain, through domain's MX hosts
+// or the relay its route names.
+func (s *Service) deliverDomain(ctx context.Context, e *email.Email, domain string, rcpts []string) error {
+	policy := s.tlsPolicies.policy(domain)
+	if r := s.route(ctx, domain); r != nil {
+		if r.relay != nil {
+			return s.deliverRelay(ctx, r.relay, e, rcpts)
+		}
+		if r.tlsPolicy != "" {
+			policy = r.tlsPolicy
+		}
 	}
 	
 	// Get MX records
-	mxRecords, err := s.getMXRecords(domain)
+	mxRecords, err := s.getMXRecords(ctx, domain)
 	if err != nil {
-		return fmt.Errorf("failed to get MX records: %w", err)
+		return lookupFailed(fmt.Errorf("failed to get MX records: %w", err))
+	}
+	mxRecords = s.orderHosts(mxRecords)
+	
+	// Keep to the hosts the domain's MTA-STS policy allows, which may
+	// require TLS over the domain's own policy
+	ctx = withTLSPolicy(ctx, policy)
+	ctx, mxRecords, err = s.applyMTASTS(ctx, domain, mxRecords)
+	if err != nil {
+		return err
+	}
+	
+	if s.shouldRace(e, mxRecords) {
+		return s.deliverRaced(ctx, e, rcpts, mxRecords)
 	}
 	
 	// Try each MX server
 	var lastErr error
+	busy := true
 	for _, mx := range mxRecords {
-		// Create context with timeout
-		deliveryCtx, cancel := context.WithTimeout(ctx, s.config.ConnectionTimeout)
-		
-		// Attempt delivery
-		err := s.client.Send(deliveryCtx, mx.Host, e)
-		cancel()
+		err := s.transact(ctx, mx.Host, rcpts, func(ctx context.Context) error {
+			return s.send(ctx, mx.Host, domain, e, rcpts)
+		})
+		s.recordHost(ctx, mx.Host, err)
 		
-		if err == nil {
-			log.Printf("Email %s delivered to %s", e.ID, mx.Host)
-			return nil
+		if hostAnswered(err) {
+			logDelivered(ctx, mx.Host, rcpts, err, "")
+			s.failures.recovered(ctx, mx.Host)
+			return err
 		}
 		
 		lastErr = err
-		log.Printf("Failed to deliver email %s to %s: %v", e.ID, mx.Host, err)
+		busy = busy && isGreetingDeferral(err)
+		s.failures.failure(ctx, mx.Host, err)
+		
+		if ctx.Err() != nil {
+			break
+		}
 	}
 	
 	if lastErr != nil {
-		return fmt.Errorf("all MX servers failed: %w", lastErr)
+		return s.allMXFailed(lastErr, busy)
 	}
 	
 	return fmt.Errorf("no MX servers found")
 }
 
-func (s *Service) getMXRecords(domain string) ([]*net.MX, error) {
+// allMXFailed is the error for a domain whose MX hosts have all failed,
+// the last with lastErr. If every one of them refused in its greeting
+// (busy), nothing was sent and the email is deferred for the greeting
+// defer delay instead of using up a retry.
+func (s *Service) allMXFailed(lastErr error, busy bool) error {
+	err := fmt.Errorf("all MX servers failed: %w", lastErr)
+	if busy {
+		return deferredFor(err, s.config.GreetingDeferDelay)
+	}
+	return err
+}
+
+// ErrNullMX is returned for a domain that publishes a null MX record
+// (RFC 7505), declaring that it does not accept mail. It is permanent.
+var ErrNullMX = errors.New("domain does not accept mail (null MX)")
+
+// getMXRecords returns domain's MX hosts in the order to try them: by
+// preference, with hosts of equal preference shuffled so that mail is
+// spread across them.
+func (s *Service) getMXRecords(ctx context.Context, domain string) ([]*net.MX, error) {
 	// Check cache
-	s.dnsCacheMu.RLock()
-	entry, exists := s.dnsCache[domain]
-	s.dnsCacheMu.RUnlock()
-	
-	if exists && entry.expiresAt.After(time.Now()) {
-		return entry.mx, nil
+	if entry, ok := s.dnsCache.get(domain, time.Now()); ok {
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		return arrangeMX(entry.mx)
 	}
 	
-	// Lookup MX records
-	mx, err := s.resolver.LookupMX(domain)
+	// Lookup MX records, caching definitive negative answers too.
+	// Concurrent misses for the domain share one lookup.
+	mx, err := s.lookups.do(ctx, domain, func(ctx context.Context) ([]*net.MX, error) {
+		mx, err := s.lookupMX(ctx, domain)
+		s.dnsCache.put(domain, mx, err, time.Now())
+		return mx, err
+	})
 	if err != nil {
 		return nil, err
 	}
 	
-	// Update cache
-	s.dnsCacheMu.Lock()
-	s.dnsCache[domain] = &dnsCacheEntry{
-		mx:        mx,
-		expiresAt: time.Now().Add(s.config.DNSCacheTTL),
+	return arrangeMX(mx)
+}
+
+// lookupMX looks up domain's MX records. A domain without any is sent
+// mail at its own address, as RFC 5321 requires, if it has an A or AAAA
+// record; this implicit MX is returned as a single record for the domain
+// itself. A domain that does not exist at all stays an error.
+func (s *Service) lookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	mx, err := s.resolver.LookupMX(ctx, domain)
+	
+	// Both NXDOMAIN and a domain with no MX records are reported as not
+	// found; only the address lookup tells them apart
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return nil, err
+	}
+	if err == nil && len(mx) > 0 {
+		return mx, nil
+	}
+	
+	hr, ok := s.resolver.(HostResolver)
+	if !ok {
+		return mx, err
+	}
+	addrs, hostErr := hr.LookupHost(ctx, domain)
+	if hostErr != nil {
+		if err != nil && errors.As(hostErr, &dnsErr) && dnsErr.IsNotFound {
+			return nil, err
+		}
+		return nil, hostErr
+	}
+	if len(addrs) == 0 {
+		return mx, err
+	}
+	logctx.Printf(ctx, "No MX records for %s, delivering to its A/AAAA record", domain)
+	return []*net.MX{{Host: domain, Pref: 0}}, nil
+}
+
+// arrangeMX returns a copy of records sorted by preference, lowest first,
+// with each run of equal preference in random order, as RFC 5321 asks.
+// Null MX records (host ".") are dropped; if nothing else is left, the
+// domain does not accept mail and ErrNullMX is returned.
+func arrangeMX(records []*net.MX) ([]*net.MX, error) {
+	mx := make([]*net.MX, 0, len(records))
+	null := false
+	for _, r := range records {
+		if r.Host == "." || r.Host == "" {
+			null = true
+			continue
+		}
+		mx = append(mx, r)
+	}
+	if len(mx) == 0 && null {
+		return nil, ErrNullMX
 	}
-	s.dnsCacheMu.Unlock()
 	
+	sort.SliceStable(mx, func(i, j int) bool { return mx[i].Pref < mx[j].Pref })
+	for start := 0; start < len(mx); {
+		end := start + 1
+		for end < len(mx) && mx[end].Pref == mx[start].Pref {
+			end++
+		}
+		run := mx[start:end]
+		rand.Shuffle(len(run), func(i, j int) { run[i], run[j] = run[j], run[i] })
+		start = end
+	}
 	return mx, nil
 }
 
@@ -1,13 +1,27 @@
 package delivery
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net"
+	"net/mail"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	
 	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/lifecycle"
+	"github.com/tpdoyle87/simple-email-server/internal/resource"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
 	"github.com/tpdoyle87/simple-email-server/pkg/email"
 )
 
@@ -27,14 +41,18 @@ func newMockQueue() *mockQueue {
 	}
 }
 
-func (m *mockQueue) Enqueue(e *email.Email) error {
+func (m *mockQueue) Enqueue(ctx context.Context, e *email.Email) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.emails = append(m.emails, e)
 	return nil
 }
 
-func (m *mockQueue) Dequeue(count int) ([]*email.Email, error) {
+func (m *mockQueue) Dequeue(ctx context.Context, count int) ([]*email.Email, error) {
+	return m.DequeueLane(ctx, "", count)
+}
+
+func (m *mockQueue) DequeueLane(ctx context.Context, lane email.Lane, count int) ([]*email.Email, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
@@ -42,8 +60,11 @@ func (m *mockQueue) Dequeue(count int) ([]*email.Email, error) {
 	
 	result := make([]*email.Email, 0)
 	for i, e := range m.emails {
+		if lane != "" && e.DeliveryLane() != lane {
+			continue
+		}
 		if e.Status == email.StatusQueued && len(result) < count {
-			e.Status = email.StatusSending
+			e.MarkSending(time.Now())
 			result = append(result, m.emails[i])
 		}
 	}
@@ -51,14 +72,14 @@ func (m *mockQueue) Dequeue(count int) ([]*email.Email, error) {
 	return result, nil
 }
 
-func (m *mockQueue) MarkDelivered(id string) error {
+func (m *mockQueue) MarkDelivered(ctx context.Context, id string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.delivered[id] = true
 	return nil
 }
 
-func (m *mockQueue) MarkFailed(id string, reason string, retry bool) error {
+func (m *mockQueue) MarkFailed(ctx context.Context, id string, reason string, retry bool) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.failed[id] = reason
@@ -69,15 +90,19 @@ func (m *mockQueue) Size() int {
 	return len(m.emails)
 }
 
+func (m *mockQueue) Stats() queue.QueueStats {
+	return queue.QueueStats{}
+}
+
 type mockDNSResolver struct {
 	mx map[string][]*net.MX
 }
 
-func (m *mockDNSResolver) LookupMX(domain string) ([]*net.MX, error) {
+func (m *mockDNSResolver) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
 	if mx, ok := m.mx[domain]; ok {
 		return mx, nil
 	}
-	return nil, &net.DNSError{Err: "no such host", Name: domain}
+	return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
 }
 
 type mockSMTPClient struct {
@@ -86,7 +111,7 @@ type mockSMTPClient struct {
 	shouldErr bool
 }
 
-func (m *mockSMTPClient) Send(ctx context.Context, host string, e *email.Email) error {
+func (m *mockSMTPClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
 	if m.shouldErr {
 		return &net.OpError{Op: "dial", Err: &net.DNSError{Err: "connection refused"}}
 	}
@@ -124,7 +149,7 @@ func TestDeliveryService_Start(t *testing.T) {
 		Body:    "Test body",
 		Status:  email.StatusQueued,
 	}
-	queue.Enqueue(testEmail)
+	queue.Enqueue(context.Background(), testEmail)
 	
 	// Start service
 	ctx, cancel := context.WithCancel(context.Background())
@@ -138,11 +163,144 @@ func TestDeliveryService_Start(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 	
 	// Check if email was delivered
-	if !queue.delivered["test-1"] {
+	queue.mu.Lock()
+	delivered := queue.delivered["test-1"]
+	queue.mu.Unlock()
+	if !delivered {
 		t.Error("Email should have been marked as delivered")
 	}
 }
 
+func TestDeliveryService_StartStopConcurrent(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           2,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	service := NewService(cfg, newMockQueue())
+	
+	results := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() { results <- service.Start(context.Background()) }()
+	}
+	
+	for i := 0; i < 4; i++ {
+		if err := <-results; err != lifecycle.ErrAlreadyRunning {
+			t.Fatalf("Expected ErrAlreadyRunning, got %v", err)
+		}
+	}
+	
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.Stop(context.Background())
+		}()
+	}
+	wg.Wait()
+	
+	if err := <-results; err != nil {
+		t.Errorf("Expected the running Start to return cleanly, got %v", err)
+	}
+	if err := service.Start(context.Background()); err != lifecycle.ErrStopped {
+		t.Errorf("Expected ErrStopped on restart, got %v", err)
+	}
+}
+
+func TestDeliveryService_WakesOnEnqueue(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           2,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	
+	q := queue.NewMemoryQueue(10)
+	client := &mockSMTPClient{}
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com", Pref: 10}},
+		},
+	}
+	service.client = client
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go service.Start(ctx)
+	
+	// Let the workers go idle before submitting
+	time.Sleep(50 * time.Millisecond)
+	
+	q.Enqueue(ctx, &email.Email{
+		ID:      "test-1",
+		From:    "sender@test.com",
+		To:      []string{"recipient@example.com"},
+		Subject: "Test",
+		Body:    "Test body",
+		Status:  email.StatusQueued,
+	})
+	
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		client.mu.Lock()
+		sent := len(client.sent)
+		client.mu.Unlock()
+		if sent == 1 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Email was not picked up within 200ms of being enqueued")
+}
+
+func TestDeliveryService_Drain(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           2,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	
+	q := queue.NewMemoryQueue(10)
+	client := &mockSMTPClient{}
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com", Pref: 10}},
+		},
+	}
+	service.client = client
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	
+	for i := 0; i < 3; i++ {
+		q.Enqueue(ctx, &email.Email{
+			ID:     fmt.Sprintf("test-%d", i),
+			From:   "sender@test.com",
+			To:     []string{"recipient@example.com"},
+			Status: email.StatusQueued,
+		})
+	}
+	go service.Start(ctx)
+	
+	drainCtx, drainCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer drainCancel()
+	if err := service.Drain(drainCtx); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	
+	client.mu.Lock()
+	sent := len(client.sent)
+	client.mu.Unlock()
+	if sent != 3 {
+		t.Errorf("Expected 3 emails delivered before drain finished, got %d", sent)
+	}
+	if err := q.Enqueue(ctx, &email.Email{ID: "late", Status: email.StatusQueued}); err != queue.ErrDraining {
+		t.Errorf("Expected ErrDraining after drain, got %v", err)
+	}
+}
+
 func TestDeliveryService_ProcessEmail(t *testing.T) {
 	cfg := &config.DeliveryConfig{
 		Workers:           1,
@@ -170,7 +328,7 @@ func TestDeliveryService_ProcessEmail(t *testing.T) {
 		Body:    "Test body",
 	}
 	
-	err := service.processEmail(context.Background(), testEmail)
+	_, err := service.processEmail(context.Background(), testEmail)
 	if err != nil {
 		t.Fatalf("Failed to process email: %v", err)
 	}
@@ -212,7 +370,7 @@ func TestDeliveryService_RetryOnFailure(t *testing.T) {
 		RetryCount: 0,
 	}
 	
-	err := service.processEmail(context.Background(), testEmail)
+	_, err := service.processEmail(context.Background(), testEmail)
 	if err == nil {
 		t.Fatal("Expected error for failed delivery")
 	}
@@ -221,6 +379,68 @@ func TestDeliveryService_RetryOnFailure(t *testing.T) {
 	// So we just check that an error was returned
 }
 
+type failingDNSResolver struct {
+	err error
+}
+
+func (f *failingDNSResolver) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	return nil, f.err
+}
+
+func TestDeliveryService_DeferOnResolverFailure(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	
+	// A domain that does not exist is a permanent failure
+	tests := []struct {
+		name         string
+		err          error
+		wantDeferred bool
+	}{
+		{name: "timeout", err: &net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}, wantDeferred: true},
+		{name: "server failure", err: &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}, wantDeferred: true},
+		{name: "no such domain", err: &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			q := queue.NewMemoryQueue(10)
+			service := NewService(cfg, q)
+			service.resolver = &failingDNSResolver{err: tt.err}
+			service.client = &mockSMTPClient{}
+			var result Result
+			service.SetResultHook(func(r Result) { result = r })
+			
+			q.Enqueue(ctx, &email.Email{
+				ID:     "test-1",
+				From:   "sender@test.com",
+				To:     []string{"recipient@example.com"},
+				Status: email.StatusQueued,
+			})
+			emails, _ := q.Dequeue(ctx, 1)
+			service.deliver(ctx, emails[0])
+			
+			e, err := q.Get(ctx, "test-1")
+			if !tt.wantDeferred {
+				if err != queue.ErrEmailNotFound || result.Status != email.StatusBounced {
+					t.Errorf("Expected the email to bounce, got %v with status %q", err, result.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected email to remain queued, got %v", err)
+			}
+			if e.DeferCount != 1 || e.RetryCount != 0 {
+				t.Errorf("Expected a deferral without a retry, got defer=%d retry=%d", e.DeferCount, e.RetryCount)
+			}
+		})
+	}
+}
+
 func TestDeliveryService_DNSCache(t *testing.T) {
 	cfg := &config.DeliveryConfig{
 		Workers:           1,
@@ -237,9 +457,9 @@ func TestDeliveryService_DNSCache(t *testing.T) {
 	
 	// Wrap resolver to count lookups
 	countingResolver := &dnsResolver{
-		lookupMX: func(domain string) ([]*net.MX, error) {
+		lookupMX: func(ctx context.Context, domain string) ([]*net.MX, error) {
 			lookupCount++
-			return resolver.LookupMX(domain)
+			return resolver.LookupMX(ctx, domain)
 		},
 	}
 	
@@ -247,13 +467,13 @@ func TestDeliveryService_DNSCache(t *testing.T) {
 	service.resolver = countingResolver
 	
 	// First lookup
-	mx1, err := service.getMXRecords("example.com")
+	mx1, err := service.getMXRecords(context.Background(), "example.com")
 	if err != nil {
 		t.Fatalf("Failed to get MX records: %v", err)
 	}
 	
 	// Second lookup (should be cached)
-	mx2, err := service.getMXRecords("example.com")
+	mx2, err := service.getMXRecords(context.Background(), "example.com")
 	if err != nil {
 		t.Fatalf("Failed to get MX records: %v", err)
 	}
@@ -270,7 +490,7 @@ func TestDeliveryService_DNSCache(t *testing.T) {
 	time.Sleep(150 * time.Millisecond)
 	
 	// Third lookup (cache expired)
-	_, err = service.getMXRecords("example.com")
+	_, err = service.getMXRecords(context.Background(), "example.com")
 	if err != nil {
 		t.Fatalf("Failed to get MX records: %v", err)
 	}
@@ -278,4 +498,789 @@ func TestDeliveryService_DNSCache(t *testing.T) {
 	if lookupCount != 2 {
 		t.Errorf("Expected 2 DNS lookups after cache expiry, got %d", lookupCount)
 	}
-}
\ No newline at end of file
+}
+
+// fakeDNSServer answers every query on a local UDP port with NXDOMAIN, or
+// not at all if silent, and counts the queries.
+func fakeDNSServer(t *testing.T, silent bool) (string, *atomic.Int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	
+	var queries atomic.Int32
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			queries.Add(1)
+			if silent || n < 12 {
+				continue
+			}
+			// Echo the query back as a response with NXDOMAIN
+			resp := append([]byte(nil), buf[:n]...)
+			resp[2], resp[3] = 0x81, 0x83
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String(), &queries
+}
+
+func TestDNSResolver_Server(t *testing.T) {
+	addr, queries := fakeDNSServer(t, false)
+	resolver := newDNSResolver(&config.DeliveryConfig{DNSServer: addr, DNSTimeout: 2 * time.Second})
+	
+	_, err := resolver.LookupMX(context.Background(), "example.com")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("Expected NXDOMAIN from the configured server, got %v", err)
+	}
+	if queries.Load() == 0 {
+		t.Error("Expected the lookup to query the configured server")
+	}
+}
+
+func TestDNSResolver_Timeout(t *testing.T) {
+	addr, _ := fakeDNSServer(t, true)
+	resolver := newDNSResolver(&config.DeliveryConfig{DNSServer: addr, DNSTimeout: 100 * time.Millisecond})
+	
+	start := time.Now()
+	_, err := resolver.LookupMX(context.Background(), "example.com")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the lookup to give up after the timeout, took %s", elapsed)
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsTimeout {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	
+	// A timeout says nothing about the domain, so the email is deferred
+	if !isDeferral(lookupFailed(err)) {
+		t.Errorf("Expected a timed out lookup to be deferred")
+	}
+	
+	// The timeout also bounds the mockable lookup
+	resolver.lookupMX = func(ctx context.Context, domain string) ([]*net.MX, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if _, err := resolver.LookupMX(context.Background(), "example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+func TestDeliveryService_DNSLookupSingleflight(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           20,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	
+	var lookupCount atomic.Int32
+	release := make(chan struct{})
+	resolver := &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com", Pref: 10}},
+		},
+	}
+	
+	// Wrap resolver to count lookups, holding each until released
+	countingResolver := &dnsResolver{
+		lookupMX: func(ctx context.Context, domain string) ([]*net.MX, error) {
+			lookupCount.Add(1)
+			<-release
+			return resolver.LookupMX(ctx, domain)
+		},
+	}
+	
+	service := NewService(cfg, newMockQueue())
+	service.resolver = countingResolver
+	
+	const callers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mx, err := service.getMXRecords(context.Background(), "example.com")
+			if err == nil && (len(mx) != 1 || mx[0].Host != "mail.example.com") {
+				err = fmt.Errorf("unexpected MX records %v", mx)
+			}
+			errs <- err
+		}()
+	}
+	
+	// Release the lookup once every caller is waiting on it
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		service.lookups.mu.Lock()
+		f := service.lookups.flights["example.com"]
+		waiting := f != nil && f.waiters == callers
+		service.lookups.mu.Unlock()
+		if waiting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Callers did not join one lookup")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+	
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Failed to get MX records: %v", err)
+		}
+	}
+	if n := lookupCount.Load(); n != 1 {
+		t.Errorf("Expected 1 DNS lookup for %d concurrent callers, got %d", callers, n)
+	}
+	
+	// The shared result was cached once
+	if _, err := service.getMXRecords(context.Background(), "example.com"); err != nil || lookupCount.Load() != 1 {
+		t.Errorf("Expected the result cached, got %v after %d lookups", err, lookupCount.Load())
+	}
+}
+type racingSMTPClient struct {
+	mockSMTPClient
+	delays  map[string]time.Duration
+	failing map[string]bool
+	
+	mu     sync.Mutex
+	sentOn []string
+	dialed []string
+}
+
+func (m *racingSMTPClient) Dial(ctx context.Context, host string) (net.Conn, error) {
+	m.mu.Lock()
+	m.dialed = append(m.dialed, host)
+	m.mu.Unlock()
+	
+	select {
+	case <-time.After(m.delays[host]):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if m.failing[host] {
+		return nil, &net.OpError{Op: "dial", Err: &net.DNSError{Err: "connection refused"}}
+	}
+	client, server := net.Pipe()
+	server.Close()
+	return client, nil
+}
+
+func (m *racingSMTPClient) SendOnConn(ctx context.Context, conn net.Conn, host string, e *email.Email, rcpts []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sentOn = append(m.sentOn, host)
+	return nil
+}
+
+func TestDeliveryService_RaceMX(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 5 * time.Second,
+		MXRaceStagger:     20 * time.Millisecond,
+		MXRaceMaxExtra:    1,
+	}
+	
+	service := NewService(cfg, newMockQueue())
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {
+				{Host: "mx1.example.com", Pref: 10},
+				{Host: "mx2.example.com", Pref: 20},
+				{Host: "mx3.example.com", Pref: 30},
+			},
+		},
+	}
+	client := &racingSMTPClient{
+		delays: map[string]time.Duration{
+			"mx1.example.com": time.Second,
+			"mx2.example.com": 0,
+		},
+	}
+	service.client = client
+	
+	testEmail := &email.Email{
+		ID:     "test-1",
+		From:   "sender@test.com",
+		To:     []string{"recipient@example.com"},
+		RaceMX: true,
+	}
+	
+	start := time.Now()
+	if _, err := service.processEmail(context.Background(), testEmail); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Racing should not wait for the slow primary, took %v", elapsed)
+	}
+	
+	if len(client.sentOn) != 1 || client.sentOn[0] != "mx2.example.com" {
+		t.Errorf("Expected exactly one send on mx2.example.com, got %v", client.sentOn)
+	}
+	
+	// Only the top two hosts are raced
+	for _, host := range client.dialed {
+		if host == "mx3.example.com" {
+			t.Error("mx3.example.com should not be dialed when racing succeeds")
+		}
+	}
+	
+	stats := service.RaceStats()
+	if stats.Races != 1 || stats.RacerWon != 1 || stats.PrimaryWon != 0 {
+		t.Errorf("Unexpected race stats: %+v", stats)
+	}
+}
+
+func TestDeliveryService_RaceMXFallback(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 5 * time.Second,
+		MXRaceStagger:     time.Second,
+		MXRaceMaxExtra:    1,
+	}
+	
+	service := NewService(cfg, newMockQueue())
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {
+				{Host: "mx1.example.com", Pref: 10},
+				{Host: "mx2.example.com", Pref: 20},
+				{Host: "mx3.example.com", Pref: 30},
+			},
+		},
+	}
+	client := &racingSMTPClient{
+		failing: map[string]bool{
+			"mx1.example.com": true,
+			"mx2.example.com": true,
+		},
+	}
+	service.client = client
+	
+	testEmail := &email.Email{
+		ID:     "test-1",
+		From:   "sender@test.com",
+		To:     []string{"recipient@example.com"},
+		RaceMX: true,
+	}
+	
+	start := time.Now()
+	if _, err := service.processEmail(context.Background(), testEmail); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+	
+	// A failed primary starts the racer without waiting out the stagger
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected fast fallback, took %v", elapsed)
+	}
+	
+	if len(client.sent) != 1 {
+		t.Errorf("Expected fallback send to mx3.example.com, got %d sends", len(client.sent))
+	}
+	if stats := service.RaceStats(); stats.AllFailed != 1 {
+		t.Errorf("Expected 1 failed race, got %+v", stats)
+	}
+}
+
+func TestWriteEmail_Attachments(t *testing.T) {
+	e := &email.Email{
+		From:    "sender@example.com",
+		To:      []string{"recipient@example.com"},
+		Subject: "Invoice",
+		Body:    "See attached",
+		Attachments: []email.Attachment{
+			{Filename: "invoice.pdf", ContentType: "application/pdf", Data: bytes.Repeat([]byte("pdf"), 100)},
+		},
+	}
+	
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, e, nil); err != nil {
+		t.Fatalf("Failed to write email: %v", err)
+	}
+	
+	msg, err := mail.ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("Failed to parse written email: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Expected multipart/mixed, got %q", msg.Header.Get("Content-Type"))
+	}
+	
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	body, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("Missing body part: %v", err)
+	}
+	if text, _ := io.ReadAll(body); string(text) != "See attached" {
+		t.Errorf("Expected body text, got %q", text)
+	}
+	
+	att, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("Missing attachment part: %v", err)
+	}
+	if att.FileName() != "invoice.pdf" {
+		t.Errorf("Expected filename invoice.pdf, got %q", att.FileName())
+	}
+	data, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, att))
+	if !bytes.Equal(data, e.Attachments[0].Data) {
+		t.Errorf("Attachment data did not round-trip")
+	}
+}
+
+func TestWriteEmail_Raw(t *testing.T) {
+	raw := "From: sender@example.com\r\nSubject: Test\r\nDKIM-Signature: v=1\r\n\r\nBody\r\n"
+	e := &email.Email{
+		ID:      "raw-1",
+		Subject: "ignored",
+		Body:    "ignored",
+		Raw:     []byte(raw),
+	}
+	
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, e, nil); err != nil {
+		t.Fatalf("Failed to write email: %v", err)
+	}
+	
+	out := buf.String()
+	if !strings.HasPrefix(out, "Received: ") {
+		t.Errorf("Expected trace header first, got %q", out)
+	}
+	if !strings.HasSuffix(out, raw) {
+		t.Errorf("Raw message should be transmitted unmodified, got %q", out)
+	}
+}
+
+func TestWriteEmail_GroupHeaders(t *testing.T) {
+	e := &email.Email{
+		From:    "sender@example.com",
+		To:      []string{"recipient@example.com"},
+		CC:      []string{"alice@example.com", "bob@example.com"},
+		Subject: "Test",
+		Body:    "Body",
+		Headers: map[string]string{
+			"To": "undisclosed-recipients:;",
+			"Cc": "Team: alice@example.com, bob@example.com;",
+		},
+	}
+	
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, e, nil); err != nil {
+		t.Fatalf("Failed to write email: %v", err)
+	}
+	
+	out := buf.String()
+	if !strings.Contains(out, "To: undisclosed-recipients:;\r\n") {
+		t.Errorf("Expected original To header, got %q", out)
+	}
+	if !strings.Contains(out, "Cc: Team: alice@example.com, bob@example.com;\r\n") {
+		t.Errorf("Expected original Cc group, got %q", out)
+	}
+}
+
+type blockingDNSResolver struct {
+	started chan struct{}
+}
+
+func (b *blockingDNSResolver) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	close(b.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestDeliveryService_CancelAbortsDNSLookup(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	
+	service := NewService(cfg, newMockQueue())
+	resolver := &blockingDNSResolver{started: make(chan struct{})}
+	service.resolver = resolver
+	service.client = &mockSMTPClient{}
+	
+	testEmail := &email.Email{
+		ID:   "test-1",
+		From: "sender@test.com",
+		To:   []string{"recipient@example.com"},
+	}
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := service.processEmail(ctx, testEmail)
+		errCh <- err
+	}()
+	
+	<-resolver.started
+	cancel()
+	
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Canceling the context should abort the in-flight DNS lookup")
+	}
+}
+
+func TestEmailContext(t *testing.T) {
+	e := &email.Email{ID: "test-1", RetryCount: 2}
+	ctx := emailContext(context.Background(), e)
+	
+	if id, _ := logctx.Value(ctx, "email_id"); id != "test-1" {
+		t.Errorf("Expected email_id test-1, got %q", id)
+	}
+	if attempt, _ := logctx.Value(ctx, "attempt"); attempt != "3" {
+		t.Errorf("Expected attempt 3, got %q", attempt)
+	}
+}
+
+func TestDeliveryService_ReservedWorkers(t *testing.T) {
+	tests := []struct {
+		workers int
+		ratio   float64
+		want    int
+	}{
+		{workers: 20, ratio: 0.25, want: 5},
+		{workers: 10, ratio: 0.25, want: 3},
+		{workers: 2, ratio: 1, want: 1},
+		{workers: 1, ratio: 0.5, want: 0},
+		{workers: 4, ratio: 0, want: 0},
+	}
+	
+	for _, tt := range tests {
+		service := NewService(&config.DeliveryConfig{
+			Workers:                  tt.workers,
+			TransactionalWorkerRatio: &tt.ratio,
+		}, newMockQueue())
+		
+		if got := service.reservedWorkers(); got != tt.want {
+			t.Errorf("workers=%d ratio=%v: expected %d reserved, got %d", tt.workers, tt.ratio, tt.want, got)
+		}
+	}
+}
+
+func TestDeliveryService_Throttled(t *testing.T) {
+	service := NewService(&config.DeliveryConfig{Workers: 4}, newMockQueue())
+	
+	monitor := resource.NewMonitor(&config.ResourceConfig{MaxGoroutines: 1})
+	service.SetMonitor(monitor)
+	
+	if service.throttled(3) {
+		t.Fatal("Workers should not be throttled before pressure is detected")
+	}
+	
+	// Tests always run more than one goroutine
+	monitor.Check()
+	
+	for id, want := range []bool{false, false, true, true} {
+		if got := service.throttled(id); got != want {
+			t.Errorf("worker %d: expected throttled=%v, got %v", id, want, got)
+		}
+	}
+}
+
+func TestDeliveryService_MaxRetry(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DefaultConfig()
+	cfg.Server.Hostname = "mail.test"
+	cfg.API.AuthToken = "token"
+	cfg.Queue.MaxRetry = 1
+	cfg.Queue.RetrySchedule = []time.Duration{time.Millisecond}
+	cfg.Queue.RetryJitter = -1
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	
+	q := queue.NewMemoryQueueWithConfig(&cfg.Queue)
+	client := &envelopeClient{fail: map[string]error{"mx.one.test": errors.New("connection refused")}}
+	service := newDomainTestService(q, client)
+	service.maxRetry = NewService(&cfg.Delivery, q).maxRetry
+	
+	attempt := func(id string) *email.Email {
+		t.Helper()
+		time.Sleep(5 * time.Millisecond)
+		emails, _ := q.Dequeue(ctx, 1)
+		if len(emails) != 1 || emails[0].ID != id {
+			t.Fatalf("Expected %s ready, got %v", id, emails)
+		}
+		service.deliver(ctx, emails[0])
+		e, err := q.Get(ctx, id)
+		if errors.Is(err, queue.ErrEmailNotFound) {
+			return nil
+		}
+		return e
+	}
+	
+	q.Enqueue(ctx, &email.Email{ID: "configured", From: "sender@test.com", To: []string{"a@one.test"}, Status: email.StatusQueued})
+	if e := attempt("configured"); e == nil || e.Status != email.StatusQueued {
+		t.Fatalf("Expected the first failure to be retried, got %+v", e)
+	}
+	if e := attempt("configured"); e != nil {
+		t.Errorf("Expected the second failure to be final with max_retry=1, got %s", e.Status)
+	}
+	
+	// A per-email limit of zero never retries
+	none := 0
+	q.Enqueue(ctx, &email.Email{ID: "one-shot", From: "sender@test.com", To: []string{"a@one.test"}, Status: email.StatusQueued, MaxRetry: &none})
+	if e := attempt("one-shot"); e != nil {
+		t.Errorf("Expected a one-shot email to fail on its first attempt, got %s", e.Status)
+	}
+}
+
+func TestArrangeMX(t *testing.T) {
+	// Unsorted input comes back by preference
+	mx, err := arrangeMX([]*net.MX{
+		{Host: "mx30.example.com", Pref: 30},
+		{Host: "mx10.example.com", Pref: 10},
+		{Host: "mx20.example.com", Pref: 20},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, want := range []string{"mx10.example.com", "mx20.example.com", "mx30.example.com"} {
+		if mx[i].Host != want {
+			t.Fatalf("Expected %s at %d, got %s", want, i, mx[i].Host)
+		}
+	}
+	
+	// Equal preferences are shuffled, but stay ahead of higher ones
+	records := []*net.MX{
+		{Host: "backup.example.com", Pref: 20},
+		{Host: "a.example.com", Pref: 10},
+		{Host: "b.example.com", Pref: 10},
+		{Host: "c.example.com", Pref: 10},
+	}
+	first := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		mx, _ := arrangeMX(records)
+		if len(mx) != 4 || mx[3].Host != "backup.example.com" {
+			t.Fatalf("Expected the backup host last, got %v", mx)
+		}
+		first[mx[0].Host]++
+	}
+	if len(first) != 3 {
+		t.Errorf("Expected every equal-preference host to come first sometimes, got %v", first)
+	}
+	if records[0].Host != "backup.example.com" {
+		t.Error("Expected the input records to be left unchanged")
+	}
+	
+	// A null MX means the domain does not accept mail
+	if _, err := arrangeMX([]*net.MX{{Host: ".", Pref: 0}}); !errors.Is(err, ErrNullMX) {
+		t.Fatalf("Expected ErrNullMX, got %v", err)
+	}
+	if !permanent(fmt.Errorf("failed to get MX records: %w", ErrNullMX)) {
+		t.Error("Expected a null MX to be a permanent failure")
+	}
+	
+	// Alongside real hosts it is just dropped
+	mx, err = arrangeMX([]*net.MX{{Host: ".", Pref: 0}, {Host: "mx.example.com", Pref: 10}})
+	if err != nil || len(mx) != 1 || mx[0].Host != "mx.example.com" {
+		t.Errorf("Expected only mx.example.com, got %v, %v", mx, err)
+	}
+}
+
+func TestDeliveryService_NullMXBounces(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		MaxRetry:          5,
+	}
+	q := newMockQueue()
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{"nomail.example.com": {{Host: ".", Pref: 0}}},
+	}
+	client := &mockSMTPClient{}
+	service.client = client
+	
+	e := &email.Email{
+		ID:      "null-mx",
+		From:    "sender@example.com",
+		To:      []string{"user@nomail.example.com"},
+		Subject: "Test",
+		Body:    "Body",
+		Status:  email.StatusQueued,
+	}
+	q.Enqueue(context.Background(), e)
+	emails, _ := q.Dequeue(context.Background(), 1)
+	
+	var results []Result
+	service.SetResultHook(func(r Result) { results = append(results, r) })
+	service.deliver(context.Background(), emails[0])
+	
+	if len(client.sent) != 0 {
+		t.Errorf("Expected nothing sent to a null MX domain, got %d", len(client.sent))
+	}
+	if len(results) != 1 || results[0].Status != email.StatusBounced {
+		t.Fatalf("Expected the email bounced without retry, got %+v", results)
+	}
+}
+
+// hostDNSResolver answers MX lookups from mx and address lookups from
+// hosts; a domain in neither does not exist.
+type hostDNSResolver struct {
+	mockDNSResolver
+	hosts map[string][]string
+}
+
+func (h *hostDNSResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := h.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestDeliveryService_ImplicitMX(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		MaxRetry:          5,
+	}
+	resolver := &hostDNSResolver{
+		mockDNSResolver: mockDNSResolver{mx: map[string][]*net.MX{
+			"empty.example.com":  {},
+			"nomail.example.com": {{Host: ".", Pref: 0}},
+		}},
+		hosts: map[string][]string{
+			"nomx.example.com":   {"192.0.2.10"},
+			"empty.example.com":  {"2001:db8::10"},
+			"nomail.example.com": {"192.0.2.11"},
+		},
+	}
+	
+	tests := []struct {
+		name       string
+		domain     string
+		wantHost   string
+		wantStatus email.Status
+	}{
+		{"no MX records", "nomx.example.com", "nomx.example.com", email.StatusDelivered},
+		{"empty MX answer", "empty.example.com", "empty.example.com", email.StatusDelivered},
+		{"NXDOMAIN", "missing.example.com", "", email.StatusBounced},
+		{"null MX", "nomail.example.com", "", email.StatusBounced},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newMockQueue()
+			service := NewService(cfg, q)
+			service.resolver = resolver
+			client := &hostSMTPClient{}
+			service.client = client
+			
+			var results []Result
+			service.SetResultHook(func(r Result) { results = append(results, r) })
+			q.Enqueue(context.Background(), &email.Email{
+				ID:      "implicit-mx",
+				From:    "sender@example.com",
+				To:      []string{"user@" + tt.domain},
+				Subject: "Test",
+				Body:    "Body",
+				Status:  email.StatusQueued,
+			})
+			emails, _ := q.Dequeue(context.Background(), 1)
+			service.deliver(context.Background(), emails[0])
+			
+			if len(results) != 1 || results[0].Status != tt.wantStatus {
+				t.Fatalf("Expected %s, got %+v", tt.wantStatus, results)
+			}
+			if tt.wantHost == "" {
+				if len(client.tried) != 0 {
+					t.Errorf("Expected no connection, tried %v", client.tried)
+				}
+				return
+			}
+			if len(client.tried) != 1 || client.tried[0] != tt.wantHost {
+				t.Errorf("Expected delivery to %s, tried %v", tt.wantHost, client.tried)
+			}
+		})
+	}
+}
+
+func TestDeliveryService_GreetingDeferred(t *testing.T) {
+	busy1, busy2, sink := newBusyServer(t), newBusyServer(t), newSinkServer(t, false)
+	
+	tests := []struct {
+		name          string
+		hosts         []string
+		wantDelivered bool
+	}{
+		{name: "all busy", hosts: []string{busy1.addr(), busy2.addr()}},
+		{name: "next host answers", hosts: []string{busy1.addr(), sink.addr()}, wantDelivered: true},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cfg := &config.DeliveryConfig{
+				Workers:            1,
+				DNSCacheTTL:        5 * time.Minute,
+				ConnectionTimeout:  5 * time.Second,
+				GreetingDeferDelay: 30 * time.Second,
+			}
+			q := queue.NewMemoryQueue(10)
+			service := NewService(cfg, q)
+			var mx []*net.MX
+			for i, host := range tt.hosts {
+				mx = append(mx, &net.MX{Host: host, Pref: uint16(10 * (i + 1))})
+			}
+			service.resolver = &mockDNSResolver{mx: map[string][]*net.MX{"example.com": mx}}
+			service.client = newClient(cfg)
+			
+			// Each host's transaction is recorded, busy ones as greeting-deferred
+			e := &email.Email{ID: "greet-1", From: "sender@test.com", To: []string{"rcpt@example.com"}, Subject: "Hi", Body: "Body", Status: email.StatusQueued}
+			attemptCtx, a := withAttempt(ctx, e)
+			_, err := service.processEmail(attemptCtx, e)
+			txns := a.transactions()
+			if len(txns) != len(tt.hosts) || txns[0].Outcome != OutcomeGreetingDeferred {
+				t.Fatalf("Expected the first host greeting-deferred, got %+v", txns)
+			}
+			if tt.wantDelivered {
+				if err != nil || txns[1].Outcome != "" {
+					t.Errorf("Expected delivery through the next host, got %v, %+v", err, txns[1])
+				}
+				return
+			}
+			if txns[1].Outcome != OutcomeGreetingDeferred {
+				t.Errorf("Expected the second host greeting-deferred, got %+v", txns[1])
+			}
+			if !isDeferral(err) || deferralDelay(err) != 30*time.Second {
+				t.Fatalf("Expected a 30s deferral, got %v", err)
+			}
+			
+			// The email is deferred briefly without using up a retry
+			q.Enqueue(ctx, e)
+			emails, _ := q.Dequeue(ctx, 1)
+			service.deliver(ctx, emails[0])
+			got, err := q.Get(ctx, "greet-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.DeferCount != 1 || got.RetryCount != 0 {
+				t.Errorf("Expected a deferral without a retry, got defer=%d retry=%d", got.DeferCount, got.RetryCount)
+			}
+			if got.ScheduledAt == nil || got.ScheduledAt.After(time.Now().Add(31*time.Second)) || got.ScheduledAt.Before(time.Now().Add(25*time.Second)) {
+				t.Errorf("Expected the email held for about 30s, scheduled at %v", got.ScheduledAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Address families outbound connections may use; see
+// config.DeliveryConfig.IPFamily.
+const (
+	IPFamilyAny  = "any"
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+)
+
+// dialStagger is how long a connection attempt runs alone before the next
+// address is tried alongside it, as in Happy Eyeballs (RFC 8305).
+var dialStagger = 300 * time.Millisecond
+
+// dialHost connects to host, a name or IP with a port. A name is resolved
+// to its A and AAAA records, and the addresses of the allowed family are
+// tried in turn, alternating families starting with the resolver's first
+// choice. Each attempt gets dialStagger before the next starts alongside
+// it, or at once if it fails, and the first to connect is used. Only
+// addresses of the source IP's family, if one is set, are tried.
+func (c *SimpleSMTPClient) dialHost(ctx context.Context, dialer *net.Dialer, host string) (net.Conn, error) {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := c.lookupAddrs(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	
+	var local net.IP
+	if addr, ok := dialer.LocalAddr.(*net.TCPAddr); ok {
+		local = addr.IP
+	}
+	ips = interleaveFamilies(filterFamily(ips, c.ipFamily, local))
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no usable address for %s (ip family %s)", name, c.family())
+	}
+	
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip.String(), port)
+	}
+	return c.raceAddrs(ctx, dialer, addrs)
+}
+
+// lookupAddrs returns the addresses of name, which may be an IP literal.
+func (c *SimpleSMTPClient) lookupAddrs(ctx context.Context, name string) ([]net.IP, error) {
+	if ip := net.ParseIP(name); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	lookup := c.lookupIP
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIPAddr
+	}
+	addrs, err := lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// family is the configured address family, IPFamilyAny if unset.
+func (c *SimpleSMTPClient) family() string {
+	if c.ipFamily == "" {
+		return IPFamilyAny
+	}
+	return c.ipFamily
+}
+
+// filterFamily returns the addresses in ips of family, and of local's
+// family if local is set.
+func filterFamily(ips []net.IP, family string, local net.IP) []net.IP {
+	var kept []net.IP
+	for _, ip := range ips {
+		v4 := ip.To4() != nil
+		switch {
+		case family == IPFamilyIPv4 && !v4, family == IPFamilyIPv6 && v4:
+			continue
+		case local != nil && (local.To4() != nil) != v4:
+			continue
+		}
+		kept = append(kept, ip)
+	}
+	return kept
+}
+
+// interleaveFamilies orders ips alternating between IPv6 and IPv4,
+// starting with the family of the first, and otherwise keeping their
+// order.
+func interleaveFamilies(ips []net.IP) []net.IP {
+	if len(ips) == 0 {
+		return ips
+	}
+	var first, second []net.IP
+	firstV4 := ips[0].To4() != nil
+	for _, ip := range ips {
+		if (ip.To4() != nil) == firstV4 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	ordered := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
+
+// raceAddrs connects to the first of addrs to answer, starting an attempt
+// every dialStagger, or as soon as the previous one fails. Connections
+// that lose the race are closed. It returns the first attempt's error if
+// none connects.
+func (c *SimpleSMTPClient) raceAddrs(ctx context.Context, dialer *net.Dialer, addrs []string) (net.Conn, error) {
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	
+	results := make(chan dialResult, len(addrs))
+	started := 0
+	start := func(i int) {
+		started++
+		go func() {
+			conn, err := c.dialAddr(raceCtx, dialer, addrs[i])
+			results <- dialResult{index: i, conn: conn, err: err}
+		}()
+	}
+	
+	start(0)
+	stagger := time.NewTimer(dialStagger)
+	defer stagger.Stop()
+	
+	var firstErr error
+	finished := 0
+	for finished < started {
+		select {
+		case <-stagger.C:
+			if started < len(addrs) {
+				start(started)
+				stagger.Reset(dialStagger)
+			}
+			
+		case r := <-results:
+			finished++
+			if r.err != nil {
+				if firstErr == nil {
+					firstErr = r.err
+				}
+				// Don't wait out the stagger when an attempt fails outright
+				if started < len(addrs) && started == finished {
+					start(started)
+					stagger.Reset(dialStagger)
+				}
+				continue
+			}
+			
+			cancel()
+			drainDials(results, started-finished)
+			return r.conn, nil
+		}
+	}
+	return nil, firstErr
+}
+
+// dialAddr connects to one address, through the test hook if set.
+func (c *SimpleSMTPClient) dialAddr(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+	if c.dial != nil {
+		return c.dial(ctx, dialer, addr)
+	}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
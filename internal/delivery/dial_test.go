@@ -0,0 +1,127 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	ips := func(addrs ...string) []net.IP {
+		var out []net.IP
+		for _, a := range addrs {
+			out = append(out, net.ParseIP(a))
+		}
+		return out
+	}
+	tests := []struct {
+		name   string
+		ips    []net.IP
+		family string
+		local  net.IP
+		want   string
+	}{
+		{name: "IPv6 first", ips: ips("2001:db8::1", "2001:db8::2", "192.0.2.1"), family: IPFamilyAny, want: "2001:db8::1 192.0.2.1 2001:db8::2"},
+		{name: "IPv4 first", ips: ips("192.0.2.1", "192.0.2.2", "2001:db8::1"), family: IPFamilyAny, want: "192.0.2.1 2001:db8::1 192.0.2.2"},
+		{name: "IPv4 only", ips: ips("2001:db8::1", "192.0.2.1"), family: IPFamilyIPv4, want: "192.0.2.1"},
+		{name: "IPv6 only", ips: ips("2001:db8::1", "192.0.2.1"), family: IPFamilyIPv6, want: "2001:db8::1"},
+		{name: "source IP's family", ips: ips("2001:db8::1", "192.0.2.1"), family: IPFamilyAny, local: net.ParseIP("203.0.113.10"), want: "192.0.2.1"},
+		{name: "nothing left", ips: ips("192.0.2.1"), family: IPFamilyIPv6, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, ip := range interleaveFamilies(filterFamily(tt.ips, tt.family, tt.local)) {
+				got = append(got, ip.String())
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, strings.Join(got, " "))
+			}
+		})
+	}
+}
+
+// dualStackClient returns a client that resolves mx.test to an IPv6
+// address that never answers and to 127.0.0.1, and records each address
+// it tries.
+func dualStackClient(family string) (*SimpleSMTPClient, func() []string) {
+	var mu sync.Mutex
+	var tried []string
+	client := NewSMTPClient(5 * time.Second)
+	client.ipFamily = family
+	client.lookupIP = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("2001:db8::25")}, {IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+	client.dial = func(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+		mu.Lock()
+		tried = append(tried, addr)
+		mu.Unlock()
+		if strings.HasPrefix(addr, "[2001:db8::25]") {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	return client, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, tried...)
+	}
+}
+
+func TestSMTPClient_HappyEyeballs(t *testing.T) {
+	sink := newSinkServer(t, false)
+	_, port, _ := net.SplitHostPort(sink.addr())
+	client, tried := dualStackClient(IPFamilyAny)
+	
+	start := time.Now()
+	e := poolTestEmail()
+	if err := client.Send(context.Background(), net.JoinHostPort("mx.test", port), e, e.To); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the IPv4 address to be used after the stagger, took %v", elapsed)
+	}
+	want := []string{"[2001:db8::25]:" + port, "127.0.0.1:" + port}
+	if got := tried(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected attempts %v, got %v", want, got)
+	}
+	if sink.messages.Load() != 1 {
+		t.Errorf("Expected the message delivered over IPv4, got %d", sink.messages.Load())
+	}
+}
+
+func TestSMTPClient_IPFamily(t *testing.T) {
+	sink := newSinkServer(t, false)
+	_, port, _ := net.SplitHostPort(sink.addr())
+	host := net.JoinHostPort("mx.test", port)
+	e := poolTestEmail()
+	
+	client, tried := dualStackClient(IPFamilyIPv4)
+	if err := client.Send(context.Background(), host, e, e.To); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := tried(); len(got) != 1 || got[0] != "127.0.0.1:"+port {
+		t.Errorf("Expected only the IPv4 address tried, got %v", got)
+	}
+	
+	// Forced to IPv6, the dead address is all there is
+	client, _ = dualStackClient(IPFamilyIPv6)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := client.Send(ctx, host, e, e.To); err == nil {
+		t.Error("Expected IPv6-only delivery to fail")
+	}
+	
+	// A literal of the wrong family is refused without dialing
+	client, tried = dualStackClient(IPFamilyIPv6)
+	if err := client.Send(context.Background(), sink.addr(), e, e.To); err == nil || !strings.Contains(err.Error(), "no usable address") {
+		t.Errorf("Expected no usable address, got %v", err)
+	}
+	if got := tried(); len(got) != 0 {
+		t.Errorf("Expected nothing dialed, got %v", got)
+	}
+}
@@ -0,0 +1,196 @@
+package delivery
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+)
+
+// defaultDNSCacheSize bounds the MX cache when no size is configured.
+const defaultDNSCacheSize = 10000
+
+// dnsCache holds MX lookups by domain, evicting the least recently used
+// domain once it holds size entries. Besides records it caches negative
+// answers, a domain that does not exist or has no mail hosts, for the
+// shorter negativeTTL, so retries to bogus domains do not hit the
+// resolver every time. Expired entries are dropped when they are next
+// looked up or reach the end of the eviction order.
+type dnsCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	size        int
+	
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type dnsCacheEntry struct {
+	domain    string
+	mx        []*net.MX
+	err       error
+	expiresAt time.Time
+}
+
+func newDNSCache(cfg *config.DeliveryConfig) *dnsCache {
+	size := cfg.DNSCacheSize
+	if size <= 0 {
+		size = defaultDNSCacheSize
+	}
+	return &dnsCache{
+		ttl:         cfg.DNSCacheTTL,
+		negativeTTL: cfg.DNSNegativeTTL,
+		size:        size,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// get returns the cached answer for domain, records or the error of a
+// negative answer, or false on a miss.
+func (c *dnsCache) get(domain string, now time.Time) (*dnsCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	el, exists := c.entries[domain]
+	if !exists {
+		return nil, false
+	}
+	entry := el.Value.(*dnsCacheEntry)
+	if !now.Before(entry.expiresAt) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return entry, true
+}
+
+// put caches the outcome of looking up domain. Records are kept for the
+// TTL and negative answers for the negative TTL; other errors, such as a
+// resolver timeout, are not cached.
+func (c *dnsCache) put(domain string, mx []*net.MX, err error, now time.Time) {
+	ttl := c.ttl
+	switch {
+	case err != nil && !notFound(err):
+		return
+	case err != nil, len(mx) == 0:
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	if el, exists := c.entries[domain]; exists {
+		c.remove(el)
+	}
+	c.entries[domain] = c.lru.PushFront(&dnsCacheEntry{
+		domain:    domain,
+		mx:        mx,
+		err:       err,
+		expiresAt: now.Add(ttl),
+	})
+	
+	// Drop expired entries from the cold end before evicting live ones
+	for c.lru.Len() > 0 {
+		oldest := c.lru.Back()
+		if c.lru.Len() <= c.size && now.Before(oldest.Value.(*dnsCacheEntry).expiresAt) {
+			break
+		}
+		c.remove(oldest)
+	}
+}
+
+// len returns how many domains are cached, including expired ones not
+// yet dropped.
+func (c *dnsCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// remove drops el. Callers must hold c.mu.
+func (c *dnsCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*dnsCacheEntry).domain)
+}
+
+// notFound reports whether err is a definitive answer that the name does
+// not exist.
+func notFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// mxLookups collapses concurrent MX lookups for the same domain, so that
+// workers dequeuing a burst of mail for a cold domain share one query
+// instead of each sending its own.
+type mxLookups struct {
+	mu      sync.Mutex
+	flights map[string]*mxFlight
+}
+
+// mxFlight is a lookup in progress and the callers waiting for it.
+type mxFlight struct {
+	done    chan struct{}
+	mx      []*net.MX
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newMXLookups() *mxLookups {
+	return &mxLookups{flights: make(map[string]*mxFlight)}
+}
+
+// do runs lookup for domain, or joins the one already in flight, and
+// returns its result. The lookup is not tied to any one caller: it runs
+// until it finishes or every caller waiting for it has given up.
+func (g *mxLookups) do(ctx context.Context, domain string, lookup func(context.Context) ([]*net.MX, error)) ([]*net.MX, error) {
+	g.mu.Lock()
+	f := g.flights[domain]
+	if f == nil {
+		lookupCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &mxFlight{done: make(chan struct{}), cancel: cancel}
+		g.flights[domain] = f
+		go func() {
+			f.mx, f.err = lookup(lookupCtx)
+			cancel()
+			g.forget(domain, f)
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	g.mu.Unlock()
+	
+	select {
+	case <-f.done:
+		return f.mx, f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.waiters--
+		abandoned := f.waiters == 0
+		g.mu.Unlock()
+		if abandoned {
+			f.cancel()
+			g.forget(domain, f)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// forget stops new callers joining f.
+func (g *mxLookups) forget(domain string, f *mxFlight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.flights[domain] == f {
+		delete(g.flights, domain)
+	}
+}
@@ -0,0 +1,111 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+)
+
+// countingResolver counts MX lookups and answers them like mockDNSResolver,
+// failing with SERVFAIL for domains in servfail.
+type countingResolver struct {
+	mockDNSResolver
+	servfail map[string]bool
+	lookups  atomic.Int64
+}
+
+func (c *countingResolver) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	c.lookups.Add(1)
+	if c.servfail[domain] {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: domain, IsTemporary: true}
+	}
+	return c.mockDNSResolver.LookupMX(ctx, domain)
+}
+
+func TestDNSCache_Negative(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		DNSCacheTTL:    5 * time.Minute,
+		DNSNegativeTTL: time.Minute,
+	}
+	service := NewService(cfg, newMockQueue())
+	resolver := &countingResolver{
+		mockDNSResolver: mockDNSResolver{mx: map[string][]*net.MX{
+			"example.com": {{Host: "mx.example.com", Pref: 10}},
+		}},
+		servfail: map[string]bool{"flaky.example.com": true},
+	}
+	service.resolver = resolver
+	ctx := context.Background()
+	
+	// A domain that does not exist is looked up once, then answered from
+	// the cache with the same error
+	for i := 0; i < 3; i++ {
+		_, err := service.getMXRecords(ctx, "bogus.example.com")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Fatalf("Expected NXDOMAIN, got %v", err)
+		}
+	}
+	if n := resolver.lookups.Load(); n != 1 {
+		t.Errorf("Expected 1 lookup for a cached negative answer, got %d", n)
+	}
+	
+	// Temporary failures are not cached
+	for i := 0; i < 2; i++ {
+		service.getMXRecords(ctx, "flaky.example.com")
+	}
+	if n := resolver.lookups.Load(); n != 3 {
+		t.Errorf("Expected SERVFAIL to be looked up every time, got %d lookups", n)
+	}
+	
+	// Negative answers expire sooner than records
+	now := time.Now()
+	service.dnsCache.put("example.com", resolver.mx["example.com"], nil, now)
+	service.dnsCache.put("bogus.example.com", nil, &net.DNSError{IsNotFound: true}, now)
+	later := now.Add(2 * time.Minute)
+	if _, ok := service.dnsCache.get("bogus.example.com", later); ok {
+		t.Error("Expected the negative answer to have expired")
+	}
+	if _, ok := service.dnsCache.get("example.com", later); !ok {
+		t.Error("Expected the records to still be cached")
+	}
+}
+
+func TestDNSCache_Eviction(t *testing.T) {
+	c := newDNSCache(&config.DeliveryConfig{
+		DNSCacheTTL:    time.Minute,
+		DNSNegativeTTL: time.Second,
+		DNSCacheSize:   3,
+	})
+	now := time.Now()
+	mx := []*net.MX{{Host: "mx.example.com", Pref: 10}}
+	
+	c.put("a.com", mx, nil, now)
+	c.put("b.com", mx, nil, now)
+	c.put("c.com", mx, nil, now)
+	
+	// Reading a.com makes b.com the least recently used
+	c.get("a.com", now)
+	c.put("d.com", mx, nil, now)
+	if _, ok := c.get("b.com", now); ok {
+		t.Error("Expected b.com evicted as least recently used")
+	}
+	for _, domain := range []string{"a.com", "c.com", "d.com"} {
+		if _, ok := c.get(domain, now); !ok {
+			t.Errorf("Expected %s still cached", domain)
+		}
+	}
+	
+	// Expired entries at the cold end are dropped even below the limit
+	c = newDNSCache(&config.DeliveryConfig{DNSCacheTTL: time.Minute, DNSNegativeTTL: time.Second, DNSCacheSize: 3})
+	c.put("gone.com", nil, nil, now)
+	c.put("live.com", mx, nil, now.Add(2*time.Second))
+	if n := c.len(); n != 1 {
+		t.Errorf("Expected the expired entry swept, %d cached", n)
+	}
+}
@@ -0,0 +1,117 @@
+package delivery
+
+import (
+	"fmt"
+	"strings"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// recipientGroup is the recipients of an email at one domain, which are
+// delivered together in a single SMTP transaction.
+type recipientGroup struct {
+	domain string
+	rcpts  []string
+	
+	// The catch-all address the recipients' mail goes to instead, if
+	// delivery redirects it
+	redirectTo string
+}
+
+// groupRecipients splits e's outstanding recipients by domain, in the
+// order each domain first appears. Domains are compared
+// case-insensitively. Recipients already done are left out.
+func groupRecipients(e *email.Email) ([]recipientGroup, error) {
+	recipients := e.Recipients()
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients")
+	}
+	
+	var groups []recipientGroup
+	index := make(map[string]int)
+	for _, rcpt := range recipients {
+		domain := strings.ToLower(extractDomain(rcpt))
+		if domain == "" {
+			return nil, fmt.Errorf("invalid recipient domain: %s", rcpt)
+		}
+		if e.RecipientStatus[rcpt].Done() {
+			continue
+		}
+		i, ok := index[domain]
+		if !ok {
+			i = len(groups)
+			index[domain] = i
+			groups = append(groups, recipientGroup{domain: domain})
+		}
+		groups[i].rcpts = append(groups[i].rcpts, rcpt)
+	}
+	return groups, nil
+}
+
+// pendingDomains returns the domains of e's outstanding recipients, or
+// false if they cannot be grouped; processEmail reports why.
+func pendingDomains(e *email.Email) ([]string, bool) {
+	groups, err := groupRecipients(e)
+	if err != nil {
+		return nil, false
+	}
+	domains := make([]string, len(groups))
+	for i, g := range groups {
+		domains[i] = g.domain
+	}
+	return domains, true
+}
+
+// domainError is a delivery failure for one of several recipient domains.
+type domainError struct {
+	domain string
+	err    error
+}
+
+func (d *domainError) Error() string { return d.domain + ": " + d.err.Error() }
+func (d *domainError) Unwrap() error { return d.err }
+
+// deliveryErrors is the failures of an email sent to several domains.
+type deliveryErrors []error
+
+func (e deliveryErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e deliveryErrors) Unwrap() []error { return e }
+
+// joinDomainErrors combines the failures of an attempt into one error. The
+// result is a deferral only if every failed domain was deferred; if any
+// domain was actually attempted the email uses up a retry like any other
+// failure.
+func joinDomainErrors(failed []*domainError) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	
+	allDeferred := true
+	for _, d := range failed {
+		if !isDeferral(d.err) {
+			allDeferred = false
+			break
+		}
+	}
+	
+	errs := make(deliveryErrors, len(failed))
+	for i, d := range failed {
+		if !allDeferred {
+			if de, ok := d.err.(*deferralError); ok {
+				d = &domainError{domain: d.domain, err: de.err}
+			}
+		}
+		errs[i] = d
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errs
+}
@@ -0,0 +1,197 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestGroupRecipients(t *testing.T) {
+	groups, err := groupRecipients(&email.Email{
+		To:  []string{"a@one.test", "b@Two.test"},
+		CC:  []string{"c@ONE.test"},
+		BCC: []string{"d@two.test"},
+	})
+	if err != nil {
+		t.Fatalf("groupRecipients failed: %v", err)
+	}
+	
+	want := []recipientGroup{
+		{domain: "one.test", rcpts: []string{"a@one.test", "c@ONE.test"}},
+		{domain: "two.test", rcpts: []string{"b@Two.test", "d@two.test"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("Expected %v, got %v", want, groups)
+	}
+	
+	if _, err := groupRecipients(&email.Email{To: []string{"a@one.test", "nobody"}}); err == nil {
+		t.Error("Expected an error for a recipient without a domain")
+	}
+	if _, err := groupRecipients(&email.Email{}); err == nil {
+		t.Error("Expected an error for an email without recipients")
+	}
+}
+
+type sentEnvelope struct {
+	host  string
+	rcpts []string
+}
+
+// envelopeClient records each transaction's envelope, fails hosts listed
+// in fail and refuses recipients listed in refuse.
+type envelopeClient struct {
+	mu     sync.Mutex
+	sent   []sentEnvelope
+	fail   map[string]error
+	refuse map[string]error
+}
+
+func (c *envelopeClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.fail[host]; err != nil {
+		return err
+	}
+	
+	var accepted []string
+	rejected := make(map[string]error)
+	for _, rcpt := range rcpts {
+		if err := c.refuse[rcpt]; err != nil {
+			rejected[rcpt] = err
+			continue
+		}
+		accepted = append(accepted, rcpt)
+	}
+	if len(accepted) > 0 {
+		c.sent = append(c.sent, sentEnvelope{host: host, rcpts: accepted})
+	}
+	if len(rejected) > 0 {
+		return &RecipientError{Rejected: rejected}
+	}
+	return nil
+}
+
+func newDomainTestService(q queue.Queue, client SMTPClient) *Service {
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"one.test": {{Host: "mx.one.test", Pref: 10}},
+			"two.test": {{Host: "mx.two.test", Pref: 10}},
+		},
+	}
+	service.client = client
+	service.failures.logf = (&capturedLog{}).logf
+	return service
+}
+
+func TestDeliveryService_DeliversPerDomain(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	client := &envelopeClient{}
+	service := newDomainTestService(q, client)
+	
+	q.Enqueue(ctx, &email.Email{
+		ID:     "test-1",
+		From:   "sender@test.com",
+		To:     []string{"a@one.test", "b@two.test"},
+		CC:     []string{"c@one.test"},
+		Status: email.StatusQueued,
+	})
+	emails, _ := q.Dequeue(ctx, 1)
+	service.deliver(ctx, emails[0])
+	
+	want := []sentEnvelope{
+		{host: "mx.one.test", rcpts: []string{"a@one.test", "c@one.test"}},
+		{host: "mx.two.test", rcpts: []string{"b@two.test"}},
+	}
+	if !reflect.DeepEqual(client.sent, want) {
+		t.Errorf("Expected envelopes %v, got %v", want, client.sent)
+	}
+	
+	if _, err := q.Get(ctx, "test-1"); !errors.Is(err, queue.ErrEmailNotFound) {
+		t.Errorf("Expected the email to leave the queue once delivered, got %v", err)
+	}
+}
+
+func TestDeliveryService_RetrySkipsDeliveredDomains(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	client := &envelopeClient{
+		fail: map[string]error{"mx.two.test": &textproto.Error{Code: 451, Msg: "4.3.0 try again later"}},
+	}
+	service := newDomainTestService(q, client)
+	
+	q.Enqueue(ctx, &email.Email{
+		ID:     "test-1",
+		From:   "sender@test.com",
+		To:     []string{"a@one.test", "b@two.test"},
+		Status: email.StatusQueued,
+	})
+	emails, _ := q.Dequeue(ctx, 1)
+	service.deliver(ctx, emails[0])
+	
+	e, err := q.Get(ctx, "test-1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if e.RecipientStatus["a@one.test"].Status != email.StatusDelivered || e.RecipientStatus["b@two.test"].Status != email.StatusQueued {
+		t.Errorf("Expected a@one.test delivered and b@two.test waiting, got %v", e.RecipientStatus)
+	}
+	if e.RetryCount != 1 || !strings.HasPrefix(e.LastError, "two.test: ") {
+		t.Errorf("Expected a failed attempt for two.test, got retry %d: %q", e.RetryCount, e.LastError)
+	}
+	
+	// The retry goes to the outstanding domain only
+	client.fail = nil
+	client.sent = nil
+	results, err := service.processEmail(ctx, e)
+	if err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+	if len(results) != 1 || results["b@two.test"].Status != email.StatusDelivered {
+		t.Errorf("Expected only b@two.test delivered, got %v", results)
+	}
+	want := []sentEnvelope{{host: "mx.two.test", rcpts: []string{"b@two.test"}}}
+	if !reflect.DeepEqual(client.sent, want) {
+		t.Errorf("Expected envelopes %v, got %v", want, client.sent)
+	}
+}
+
+func TestJoinDomainErrors(t *testing.T) {
+	lookup := deferred(errors.New("server misbehaving"))
+	refused := errors.New("connection refused")
+	
+	if err := joinDomainErrors(nil); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+	
+	err := joinDomainErrors([]*domainError{{"one.test", lookup}, {"two.test", lookup}})
+	if !isDeferral(err) {
+		t.Errorf("Expected a deferral when every domain was deferred, got %v", err)
+	}
+	
+	err = joinDomainErrors([]*domainError{{"one.test", lookup}, {"two.test", refused}})
+	if isDeferral(err) {
+		t.Errorf("Expected no deferral when a domain was attempted, got %v", err)
+	}
+	if !errors.Is(err, refused) {
+		t.Errorf("Expected the attempted domain's error to be kept, got %v", err)
+	}
+	if want := "one.test: server misbehaving; two.test: connection refused"; err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+}
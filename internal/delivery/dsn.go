@@ -0,0 +1,208 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	
+	"github.com/google/uuid"
+	"github.com/tpdoyle87/simple-email-server/internal/identity"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// maxReturnedHeaders caps how much of the original header block a
+// non-delivery report returns.
+const maxReturnedHeaders = 64 * 1024
+
+// errHeadersDone stops writing the original message once its header block
+// has been captured.
+var errHeadersDone = errors.New("headers captured")
+
+// replyPattern finds an SMTP reply, and its enhanced status code if any,
+// in a recipient's error such as `failed to send data: 554 "5.7.1 Spam"`.
+// net/textproto quotes the reply text.
+var replyPattern = regexp.MustCompile(`(?:^|: )([245])\d\d[ -]"?(?:([245]\.\d{1,3}\.\d{1,3})\b)?`)
+
+// notifySender queues a non-delivery report (RFC 3464) to e's sender for
+// the recipients that failed for good on this attempt. Only mail relayed
+// over SMTP, or submitted with NotifyOnFailure, gets one; mail with a null
+// sender and reports themselves never do, so two servers cannot bounce
+// reports back and forth.
+func (s *Service) notifySender(ctx context.Context, e *email.Email, results map[string]email.RecipientStatus) {
+	if !e.Relayed && !e.NotifyOnFailure || e.Notification || e.From == "" {
+		return
+	}
+	var failed []string
+	for rcpt, status := range results {
+		if status.Status == email.StatusFailed {
+			failed = append(failed, rcpt)
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	sort.Strings(failed)
+	
+	hostname := s.config.Identity.Hostname()
+	msg, err := nonDeliveryReport(e, s.config.Identity, failed, results)
+	if err != nil {
+		logctx.Printf(ctx, "Failed to build non-delivery report: %v", err)
+		return
+	}
+	now := time.Now()
+	report := &email.Email{
+		ID:             uuid.New().String(),
+		From:           "MAILER-DAEMON@" + hostname,
+		To:             []string{e.From},
+		Subject:        "Undelivered Mail Returned to Sender",
+		Raw:            msg,
+		Notification:   true,
+		AllowDuplicate: true,
+		CreatedAt:      now,
+	}
+	report.MarkQueued(now)
+	if err := s.queue.Enqueue(ctx, report); err != nil {
+		logctx.Printf(ctx, "Failed to queue non-delivery report to %s: %v", e.From, err)
+		return
+	}
+	logctx.Printf(ctx, "Queued non-delivery report %s to %s for %d recipients", report.ID, e.From, len(failed))
+}
+
+// nonDeliveryReport builds the multipart/report message from the server
+// id telling e's sender that delivery to failed did not succeed: an
+// explanation, the delivery status of each recipient, and the original
+// message's headers.
+func nonDeliveryReport(e *email.Email, id *identity.Identity, failed []string, results map[string]email.RecipientStatus) ([]byte, error) {
+	hostname := id.Hostname()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	
+	headers := []string{
+		"From: Mail Delivery System <MAILER-DAEMON@" + hostname + ">",
+		"To: " + e.From,
+		"Subject: Undelivered Mail Returned to Sender",
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: <" + uuid.New().String() + "@" + hostname + ">",
+		"Auto-Submitted: auto-replied",
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: multipart/report; report-type=delivery-status; boundary=%q", mw.Boundary()),
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s\r\n", h)
+	}
+	buf.WriteString("\r\n")
+	
+	// Explanation for the sender
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(part, "This is the mail system at host %s.\r\n\r\n", hostname)
+	fmt.Fprintf(part, "Your message could not be delivered to one or more recipients.\r\n")
+	fmt.Fprintf(part, "It has not been sent to them and will not be retried.\r\n\r\n")
+	for _, rcpt := range failed {
+		fmt.Fprintf(part, "<%s>: %s\r\n", rcpt, results[rcpt].LastError)
+	}
+	
+	// Machine-readable status, one block per recipient
+	part, err = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/delivery-status"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(part, "Reporting-MTA: dns; %s\r\n", hostname)
+	if !e.CreatedAt.IsZero() {
+		fmt.Fprintf(part, "Arrival-Date: %s\r\n", e.CreatedAt.Format(time.RFC1123Z))
+	}
+	for _, rcpt := range failed {
+		status, diagnostic := deliveryStatus(results[rcpt].LastError)
+		fmt.Fprintf(part, "\r\nFinal-Recipient: rfc822; %s\r\n", rcpt)
+		fmt.Fprintf(part, "Action: failed\r\n")
+		fmt.Fprintf(part, "Status: %s\r\n", status)
+		if diagnostic != "" {
+			fmt.Fprintf(part, "Diagnostic-Code: smtp; %s\r\n", diagnostic)
+		}
+	}
+	
+	// The original message's headers
+	original, err := originalHeaders(e, id)
+	if err != nil {
+		return nil, err
+	}
+	part, err = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/rfc822-headers"}})
+	if err != nil {
+		return nil, err
+	}
+	part.Write(original)
+	
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deliveryStatus returns the enhanced status code for a recipient that
+// failed with lastError, and the remote server's reply if there was one.
+// A reply without an enhanced code gets the generic one for its class;
+// failures without a reply, such as a domain that does not exist or
+// retries running out on timeouts, get 5.0.0.
+func deliveryStatus(lastError string) (status, reply string) {
+	m := replyPattern.FindStringSubmatchIndex(lastError)
+	if m == nil {
+		return "5.0.0", ""
+	}
+	reply = strings.TrimPrefix(lastError[m[0]:], ": ")
+	if text, err := strconv.Unquote(reply[4:]); err == nil {
+		reply = reply[:4] + text
+	}
+	reply = strings.Join(strings.Fields(reply), " ")
+	if m[4] >= 0 {
+		return lastError[m[4]:m[5]], reply
+	}
+	return lastError[m[2]:m[3]] + ".0.0", reply
+}
+
+// originalHeaders returns the header block of e as it was sent by id,
+// ending with the blank line, and cut short at maxReturnedHeaders.
+func originalHeaders(e *email.Email, id *identity.Identity) ([]byte, error) {
+	w := &headerCapture{}
+	if err := WriteMessage(w, e, id); err != nil && !errors.Is(err, errHeadersDone) {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// headerCapture keeps what is written to it up to the end of the header
+// block, then fails the write so the body is not produced.
+type headerCapture struct {
+	buf bytes.Buffer
+}
+
+func (h *headerCapture) Write(p []byte) (int, error) {
+	h.buf.Write(p)
+	b := h.buf.Bytes()
+	end := -1
+	if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+		end = i + 4
+	}
+	if i := bytes.Index(b, []byte("\n\n")); i >= 0 && (end < 0 || i+2 < end) {
+		end = i + 2
+	}
+	switch {
+	case end >= 0:
+		h.buf.Truncate(end)
+	case h.buf.Len() > maxReturnedHeaders:
+		h.buf.Truncate(maxReturnedHeaders)
+	default:
+		return len(p), nil
+	}
+	return 0, errHeadersDone
+}
@@ -0,0 +1,142 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/bounce"
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/identity"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestService_NotifySender(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      email.Email
+		wantReport bool
+	}{
+		{name: "relayed mail", email: email.Email{Relayed: true, Raw: []byte("From: author@test.com\r\nSubject: Hi\r\n\r\nBody\r\n")}, wantReport: true},
+		{name: "API mail", email: email.Email{Subject: "Hi", Body: "Body"}},
+		{name: "API mail asking for reports", email: email.Email{Subject: "Hi", Body: "Body", NotifyOnFailure: true}, wantReport: true},
+		{name: "report", email: email.Email{Subject: "Hi", Body: "Body", NotifyOnFailure: true, Notification: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newMockQueue()
+			service := NewService(&config.DeliveryConfig{
+				Workers:           1,
+				DNSCacheTTL:       5 * time.Minute,
+				ConnectionTimeout: 5 * time.Second,
+				Identity:          identity.New("mx.test.com"),
+			}, q)
+			service.resolver = &mockDNSResolver{
+				mx: map[string][]*net.MX{
+					"example.com": {{Host: "mail.example.com", Pref: 10}},
+				},
+			}
+			service.client = &replyClient{err: &RecipientError{Rejected: map[string]error{
+				"missing@example.com": &textproto.Error{Code: 550, Msg: "5.1.1 user unknown"},
+			}}}
+			
+			e := tt.email
+			e.ID = "dsn-1"
+			e.From = "author@test.com"
+			e.To = []string{"missing@example.com", "rcpt@example.com"}
+			e.CreatedAt = time.Now()
+			e.MarkQueued(e.CreatedAt)
+			service.deliver(context.Background(), &e)
+			
+			if !tt.wantReport {
+				if len(q.emails) != 0 {
+					t.Fatalf("Expected no report, got %+v", q.emails[0])
+				}
+				return
+			}
+			if len(q.emails) != 1 {
+				t.Fatalf("Expected one report queued, got %d", len(q.emails))
+			}
+			r := q.emails[0]
+			if !r.Notification || r.From != "MAILER-DAEMON@mx.test.com" || len(r.To) != 1 || r.To[0] != "author@test.com" {
+				t.Errorf("Expected a report from MAILER-DAEMON to the sender, got %+v", r)
+			}
+			if r.Status != email.StatusQueued {
+				t.Errorf("Expected the report queued, got %s", r.Status)
+			}
+			
+			report, err := bounce.Parse(r.Raw)
+			if err != nil {
+				t.Fatalf("Expected a delivery status notification, got %v:\n%s", err, r.Raw)
+			}
+			want := []bounce.Recipient{{
+				Address:    "missing@example.com",
+				Action:     "failed",
+				Status:     "5.1.1",
+				Diagnostic: "550 5.1.1 user unknown",
+			}}
+			if len(report.Recipients) != 1 || report.Recipients[0] != want[0] {
+				t.Errorf("Expected %+v, got %+v", want, report.Recipients)
+			}
+			if report.Original.Get("Subject") != "Hi" {
+				t.Errorf("Expected the original headers returned, got %v", report.Original)
+			}
+		})
+	}
+}
+
+func TestService_NotificationNullSender(t *testing.T) {
+	sink := newSinkServer(t, false)
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 5 * time.Second,
+		ReturnPath:        config.ReturnPathConfig{Domain: "bounces.example.com", VERP: true},
+	}, newMockQueue())
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: sink.addr(), Pref: 10}},
+		},
+	}
+	var result Result
+	service.SetResultHook(func(r Result) { result = r })
+	sink.mailFrom.Store("unset")
+	
+	e := &email.Email{
+		ID:           "report-1",
+		From:         "MAILER-DAEMON@mx.test.com",
+		To:           []string{"author@example.com"},
+		Raw:          []byte("From: MAILER-DAEMON@mx.test.com\r\nSubject: Undelivered\r\n\r\nBody\r\n"),
+		Notification: true,
+		Status:       email.StatusQueued,
+	}
+	service.deliver(context.Background(), e)
+	if result.Status != email.StatusDelivered {
+		t.Fatalf("Expected delivery, got %s: %v", result.Status, result.Err)
+	}
+	if got := sink.mailFrom.Load().(string); got != "" {
+		t.Errorf("Expected the null sender, got MAIL FROM %s", got)
+	}
+}
+
+func TestDeliveryStatus(t *testing.T) {
+	tests := []struct {
+		lastError  string
+		wantStatus string
+		wantReply  string
+	}{
+		{lastError: `550 "5.1.1 user unknown"`, wantStatus: "5.1.1", wantReply: "550 5.1.1 user unknown"},
+		{lastError: "550 5.1.1 user unknown", wantStatus: "5.1.1", wantReply: "550 5.1.1 user unknown"},
+		{lastError: "failed to send data: 554 Message rejected", wantStatus: "5.0.0", wantReply: "554 Message rejected"},
+		{lastError: `all MX servers failed: 421 "4.7.0 try again\r\nlater"`, wantStatus: "4.7.0", wantReply: "421 4.7.0 try again later"},
+		{lastError: "lookup nowhere.test: no such host", wantStatus: "5.0.0"},
+	}
+	for _, tt := range tests {
+		status, reply := deliveryStatus(tt.lastError)
+		if status != tt.wantStatus || reply != tt.wantReply {
+			t.Errorf("deliveryStatus(%q) = %q, %q, want %q, %q", tt.lastError, status, reply, tt.wantStatus, tt.wantReply)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// ErrPort25Blocked is returned by Start, if delivery.egress_probe.required
+// is set, when none of the probe targets can be reached on port 25.
+var ErrPort25Blocked = errors.New("outbound port 25 appears to be blocked")
+
+// EgressStatus is the outcome of the startup check that outbound port 25
+// is open.
+type EgressStatus struct {
+	Checked   bool       `json:"checked"`
+	Blocked   bool       `json:"blocked"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// EgressStatus returns the outcome of the port 25 check made when the
+// service started; Checked is false if none was made.
+func (s *Service) EgressStatus() EgressStatus {
+	if status := s.egress.Load(); status != nil {
+		return *status
+	}
+	return EgressStatus{}
+}
+
+// probeEgress checks that outbound port 25 is open, unless the probe is
+// skipped or all mail goes through a relay. If it is blocked it logs a
+// warning that says so plainly, rather than leaving a wall of dial
+// timeouts to explain it, and returns an error if the probe is required.
+func (s *Service) probeEgress(ctx context.Context) error {
+	probe := s.config.EgressProbe
+	if len(probe.Targets) == 0 || s.relaysAll() {
+		return nil
+	}
+	
+	err := s.dialAny(ctx, probe.Targets, probe.Timeout)
+	now := time.Now()
+	status := &EgressStatus{Checked: true, CheckedAt: &now}
+	if err != nil {
+		status.Blocked = true
+		status.Error = err.Error()
+	}
+	s.egress.Store(status)
+	if err == nil {
+		return nil
+	}
+	
+	log.Printf("WARNING: %v: %v", ErrPort25Blocked, err)
+	log.Printf("WARNING: deliveries to MX hosts will time out. Ask your provider to open port 25, or deliver through a relay with delivery.mode: relay")
+	if probe.Required {
+		return fmt.Errorf("%w: %v", ErrPort25Blocked, err)
+	}
+	return nil
+}
+
+// relaysAll reports whether mail without a route of its own goes through
+// a relay, so direct delivery on port 25 is not relied on.
+func (s *Service) relaysAll() bool {
+	return s.routes != nil && s.routes.fallback != nil && s.routes.fallback.relay != nil
+}
+
+// dialAny connects to targets at once and returns nil as soon as one
+// answers, or the first error if none does within timeout.
+func (s *Service) dialAny(ctx context.Context, targets []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	
+	errs := make(chan error, len(targets))
+	for _, target := range targets {
+		go func(target string) {
+			conn, err := s.probeDial(ctx, target)
+			if err == nil {
+				conn.Close()
+			}
+			errs <- err
+		}(target)
+	}
+	var firstErr error
+	for range targets {
+		err := <-errs
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// probeDial connects to target for the egress probe.
+func (s *Service) probeDial(ctx context.Context, target string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", target)
+}
@@ -0,0 +1,127 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/lifecycle"
+)
+
+func TestService_ProbeEgress(t *testing.T) {
+	open, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+	
+	newService := func(probe config.EgressProbeConfig) *Service {
+		probe.Timeout = time.Second
+		return NewService(&config.DeliveryConfig{
+			Workers:           1,
+			DNSCacheTTL:       5 * time.Minute,
+			ConnectionTimeout: 5 * time.Second,
+			EgressProbe:       probe,
+		}, newMockQueue())
+	}
+	
+	// One target answering is enough
+	service := newService(config.EgressProbeConfig{Targets: []string{closedAddr, open.Addr().String()}})
+	if err := service.probeEgress(context.Background()); err != nil {
+		t.Fatalf("Expected the probe to pass, got %v", err)
+	}
+	if status := service.EgressStatus(); !status.Checked || status.Blocked || status.CheckedAt == nil {
+		t.Errorf("Expected port 25 reported open, got %+v", status)
+	}
+	
+	// None answering is reported but doesn't stop the service
+	service = newService(config.EgressProbeConfig{Targets: []string{closedAddr}})
+	if err := service.probeEgress(context.Background()); err != nil {
+		t.Fatalf("Expected only a warning, got %v", err)
+	}
+	if status := service.EgressStatus(); !status.Blocked || status.Error == "" {
+		t.Errorf("Expected port 25 reported blocked, got %+v", status)
+	}
+	
+	// ...unless the probe is required
+	service = newService(config.EgressProbeConfig{Targets: []string{closedAddr}, Required: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := service.Start(ctx); !errors.Is(err, ErrPort25Blocked) {
+		t.Fatalf("Expected Start to fail with ErrPort25Blocked, got %v", err)
+	}
+	
+	// Nothing is checked when mail goes through a relay
+	service = NewService(&config.DeliveryConfig{
+		Mode:        "relay",
+		Relay:       config.RelayConfig{Host: "smarthost", Port: 587, TLS: "starttls"},
+		EgressProbe: config.EgressProbeConfig{Targets: []string{closedAddr}, Timeout: time.Second},
+	}, newMockQueue())
+	if err := service.probeEgress(context.Background()); err != nil || service.EgressStatus().Checked {
+		t.Errorf("Expected no probe when relaying, got %v %+v", err, service.EgressStatus())
+	}
+}
+
+func TestService_ProbeEgressOnce(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	var dials atomic.Int32
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			dials.Add(1)
+			conn.Close()
+		}
+	}()
+	
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 5 * time.Second,
+		EgressProbe:       config.EgressProbeConfig{Targets: []string{target.Addr().String()}, Timeout: time.Second},
+	}, newMockQueue())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- service.Start(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	
+	deadline := time.Now().Add(5 * time.Second)
+	for !service.EgressStatus().Checked || dials.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the probe to run on start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkedAt := service.EgressStatus().CheckedAt
+	
+	// A second Start is refused before probing again
+	if err := service.Start(ctx); !errors.Is(err, lifecycle.ErrAlreadyRunning) {
+		t.Fatalf("Expected ErrAlreadyRunning, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := dials.Load(); n != 1 {
+		t.Errorf("Expected a single probe, got %d", n)
+	}
+	if !service.EgressStatus().CheckedAt.Equal(*checkedAt) {
+		t.Error("Expected the stored status kept")
+	}
+}
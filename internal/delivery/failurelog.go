@@ -0,0 +1,234 @@
+package delivery
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+)
+
+// maxFailureKeys bounds how many distinct (target, category) pairs are
+// tracked for suppression at once.
+const maxFailureKeys = 1000
+
+type failureKey struct {
+	target   string
+	category string
+}
+
+type failureEntry struct {
+	since      time.Time
+	count      int
+	suppressed int
+}
+
+// failureLog collapses repeated identical delivery failures into periodic
+// summary lines. The first failure for a target and category, and the
+// recovery of a target, are always logged in full.
+type failureLog struct {
+	window time.Duration
+	
+	mu         sync.Mutex
+	entries    map[failureKey]*failureEntry
+	categories map[string]int64
+	
+	// logf is replaced in tests
+	logf func(ctx context.Context, format string, args ...interface{})
+}
+
+func newFailureLog(window time.Duration) *failureLog {
+	if window <= 0 {
+		window = time.Minute
+	}
+	return &failureLog{
+		window:     window,
+		entries:    make(map[failureKey]*failureEntry),
+		categories: make(map[string]int64),
+		logf:       logctx.Printf,
+	}
+}
+
+// failure logs err against target unless an identical failure was already
+// logged within the window, in which case it is only counted.
+func (f *failureLog) failure(ctx context.Context, target string, err error) {
+	key := failureKey{target: target, category: classifyError(err)}
+	now := time.Now()
+	
+	f.mu.Lock()
+	entry, exists := f.entries[key]
+	if exists && now.Sub(entry.since) >= f.window {
+		f.summarize(key, entry)
+		delete(f.entries, key)
+		exists = false
+	}
+	
+	if exists {
+		entry.count++
+		entry.suppressed++
+		f.mu.Unlock()
+		return
+	}
+	
+	// Never drop a new distinct error; just stop tracking it when full
+	if len(f.entries) < maxFailureKeys {
+		f.entries[key] = &failureEntry{since: now, count: 1}
+	}
+	f.mu.Unlock()
+	
+	f.logf(ctx, "Failed to deliver to %s: %v", target, err)
+}
+
+// recovered logs a summary of any failures suppressed for target and a
+// recovery line, then forgets them.
+func (f *failureLog) recovered(ctx context.Context, target string) {
+	f.mu.Lock()
+	total := 0
+	for key, entry := range f.entries {
+		if key.target != target {
+			continue
+		}
+		f.summarize(key, entry)
+		total += entry.count
+		delete(f.entries, key)
+	}
+	f.mu.Unlock()
+	
+	if total > 0 {
+		f.logf(ctx, "%s: delivery recovered after %d failures", target, total)
+	}
+}
+
+// count records a failed delivery under its error category.
+func (f *failureLog) count(err error) {
+	category := classifyError(err)
+	
+	f.mu.Lock()
+	f.categories[category]++
+	f.mu.Unlock()
+}
+
+// flush emits summaries for entries whose window has elapsed.
+func (f *failureLog) flush(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	
+	for key, entry := range f.entries {
+		if now.Sub(entry.since) >= f.window {
+			f.summarize(key, entry)
+			delete(f.entries, key)
+		}
+	}
+}
+
+// run flushes expired entries every window until ctx is done.
+func (f *failureLog) run(ctx context.Context) {
+	ticker := time.NewTicker(f.window)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ctx.Done():
+			f.flush(time.Now().Add(f.window))
+			return
+		case now := <-ticker.C:
+			f.flush(now)
+		}
+	}
+}
+
+// stats returns a copy of the per-category failure counts.
+func (f *failureLog) stats() map[string]int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	
+	result := make(map[string]int64, len(f.categories))
+	for k, v := range f.categories {
+		result[k] = v
+	}
+	return result
+}
+
+// summarize must be called with f.mu held.
+func (f *failureLog) summarize(key failureKey, entry *failureEntry) {
+	if entry.suppressed == 0 {
+		return
+	}
+	f.logf(context.Background(), "WARN %s: %s x%d in last %s", key.target, key.category, entry.count,
+		time.Since(entry.since).Round(time.Second))
+}
+
+// classifyError reduces err to a short category so that failures which
+// differ only in incidental detail are grouped together.
+func classifyError(err error) string {
+	if err == nil {
+		return "none"
+	}
+	
+	if isReputationBlock(err) {
+		return "reputation block"
+	}
+	
+	if code := smtpCode(err); code != 0 {
+		return fmt.Sprintf("smtp %d", code)
+	}
+	
+	if errors.Is(err, ErrNullMX) {
+		return "null mx"
+	}
+	
+	if errors.Is(err, ErrMailLoop) {
+		return "mail loop"
+	}
+	
+	if errors.Is(err, ErrTLSRequired) {
+		return "tls required"
+	}
+	
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+	
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "connection refused"):
+		return "connection refused"
+	case strings.Contains(msg, "connection reset"):
+		return "connection reset"
+	case strings.Contains(msg, "no route to host"), strings.Contains(msg, "network is unreachable"):
+		return "unreachable"
+	}
+	
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsNotFound {
+			return "dns not found"
+		}
+		return "dns error"
+	}
+	
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) || strings.Contains(msg, "tls") {
+		return "tls error"
+	}
+	
+	return "other"
+}
+
+// FailureStats returns the number of failed deliveries per error category.
+func (s *Service) FailureStats() map[string]int64 {
+	return s.failures.stats()
+}
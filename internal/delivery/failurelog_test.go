@@ -0,0 +1,160 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+type capturedLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *capturedLog) logf(ctx context.Context, format string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, fmt.Sprintf(format, args...))
+}
+
+func TestFailureLog_CollapsesIdenticalFailures(t *testing.T) {
+	captured := &capturedLog{}
+	f := newFailureLog(time.Hour)
+	f.logf = captured.logf
+	
+	ctx := context.Background()
+	refused := &net.OpError{Op: "dial", Err: fmt.Errorf("connect: connection refused")}
+	
+	for i := 0; i < 100; i++ {
+		f.failure(ctx, "mail.example.com", refused)
+	}
+	
+	if len(captured.lines) != 1 {
+		t.Fatalf("Expected 1 log line for 100 identical failures, got %d", len(captured.lines))
+	}
+	
+	// A new distinct error is never hidden
+	f.failure(ctx, "mail.example.com", &textproto.Error{Code: 550, Msg: "mailbox unavailable"})
+	f.failure(ctx, "mx.other.com", refused)
+	if len(captured.lines) != 3 {
+		t.Fatalf("Expected distinct failures to be logged, got %v", captured.lines)
+	}
+	
+	// Flushing after the window emits a summary with the full count
+	f.flush(time.Now().Add(2 * time.Hour))
+	var summary string
+	for _, line := range captured.lines {
+		if strings.HasPrefix(line, "WARN mail.example.com: connection refused") {
+			summary = line
+		}
+	}
+	if !strings.Contains(summary, "x100") {
+		t.Errorf("Expected summary with x100, got %v", captured.lines)
+	}
+	
+	// After the summary the next occurrence is logged in full again
+	before := len(captured.lines)
+	f.failure(ctx, "mail.example.com", refused)
+	if len(captured.lines) != before+1 {
+		t.Error("Expected failure after window to be logged in full")
+	}
+}
+
+func TestFailureLog_Recovery(t *testing.T) {
+	captured := &capturedLog{}
+	f := newFailureLog(time.Hour)
+	f.logf = captured.logf
+	
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		f.failure(ctx, "mail.example.com", context.DeadlineExceeded)
+	}
+	f.recovered(ctx, "mail.example.com")
+	
+	last := captured.lines[len(captured.lines)-1]
+	if !strings.Contains(last, "recovered after 5 failures") {
+		t.Errorf("Expected recovery line, got %v", captured.lines)
+	}
+	
+	// Recovery with no outstanding failures stays quiet
+	before := len(captured.lines)
+	f.recovered(ctx, "mail.example.com")
+	if len(captured.lines) != before {
+		t.Error("Expected no log for recovery without failures")
+	}
+}
+
+func TestFailureLog_Bounded(t *testing.T) {
+	captured := &capturedLog{}
+	f := newFailureLog(time.Hour)
+	f.logf = captured.logf
+	
+	ctx := context.Background()
+	for i := 0; i < maxFailureKeys+50; i++ {
+		f.failure(ctx, fmt.Sprintf("mx%d.example.com", i), context.DeadlineExceeded)
+	}
+	
+	if len(f.entries) > maxFailureKeys {
+		t.Errorf("Expected at most %d tracked entries, got %d", maxFailureKeys, len(f.entries))
+	}
+	if len(captured.lines) != maxFailureKeys+50 {
+		t.Errorf("Expected every distinct failure to be logged, got %d lines", len(captured.lines))
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&net.OpError{Op: "dial", Err: fmt.Errorf("connect: connection refused")}, "connection refused"},
+		{fmt.Errorf("failed to set sender: %w", &textproto.Error{Code: 421, Msg: "try later"}), "smtp 421"},
+		{fmt.Errorf("dial: %w", context.DeadlineExceeded), "timeout"},
+		{&net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}, "dns not found"},
+		{fmt.Errorf("something odd"), "other"},
+	}
+	
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("classifyError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDeliveryService_FailureStats(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	
+	queue := newMockQueue()
+	service := NewService(cfg, queue)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com", Pref: 10}},
+		},
+	}
+	service.client = &mockSMTPClient{shouldErr: true}
+	service.failures.logf = (&capturedLog{}).logf
+	
+	for i := 0; i < 3; i++ {
+		service.deliver(context.Background(), &email.Email{
+			ID:   fmt.Sprintf("test-%d", i),
+			From: "sender@test.com",
+			To:   []string{"recipient@example.com"},
+		})
+	}
+	
+	if got := service.FailureStats()["connection refused"]; got != 3 {
+		t.Errorf("Expected 3 connection refused failures, got %d", got)
+	}
+}
@@ -0,0 +1,107 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// SuppressedBy is recorded as RejectedBy for emails whose recipients had
+// all been suppressed by the time they were sent.
+const SuppressedBy = "suppression"
+
+// recheck runs after the pre-delivery hooks, just before the SMTP
+// transactions, and re-evaluates what may have changed since e was queued:
+// cancellation, expiry and the suppression list. Suppressed recipients are
+// recorded as such and left out of the attempt. It returns nil if nothing
+// is left to send, after recording that in the queue.
+func (s *Service) recheck(ctx, resultCtx context.Context, e *email.Email) *email.Email {
+	if c, ok := s.queue.(queue.Canceller); ok {
+		if reason, cancelled := c.Cancelled(ctx, e.ID); cancelled {
+			logctx.Printf(ctx, "Delivery cancelled: %s", reason)
+			s.abortEmail(resultCtx, e, queue.CancelledBy, reason)
+			return nil
+		}
+	}
+	
+	if e.ExpiresAt != nil && !e.ExpiresAt.After(time.Now()) {
+		logctx.Printf(ctx, "Email expired before delivery")
+		s.expireEmail(resultCtx, e)
+		return nil
+	}
+	
+	if s.suppression == nil {
+		return e
+	}
+	suppressed, pending, err := suppressedRecipients(ctx, s.suppression, e)
+	if err != nil {
+		if s.config.HookFailurePolicy == "open" {
+			logctx.Printf(ctx, "Suppression check failed, sending anyway: %v", err)
+			return e
+		}
+		s.deferEmail(resultCtx, e, fmt.Sprintf("suppression check failed: %v", err), 0)
+		return nil
+	}
+	if len(suppressed) == 0 {
+		return e
+	}
+	
+	logctx.Printf(ctx, "Dropped %d suppressed recipients", len(suppressed))
+	s.updateRecipients(resultCtx, e, suppressed)
+	if pending == 0 {
+		s.abortEmail(resultCtx, e, SuppressedBy, "all recipients are suppressed")
+		return nil
+	}
+	
+	markRecipients(e, suppressed)
+	return e
+}
+
+// suppressedRecipients looks up e's outstanding recipients in list and
+// returns those suppressed, and how many are not.
+func suppressedRecipients(ctx context.Context, list SuppressionList, e *email.Email) (map[string]email.RecipientStatus, int, error) {
+	suppressed := make(map[string]email.RecipientStatus)
+	pending := 0
+	for _, rcpt := range e.Recipients() {
+		if e.RecipientStatus[rcpt].Done() {
+			continue
+		}
+		ok, err := list.Suppressed(ctx, rcpt)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			suppressed[rcpt] = email.RecipientStatus{Status: email.StatusSuppressed}
+		} else {
+			pending++
+		}
+	}
+	return suppressed, pending, nil
+}
+
+// markRecipients records statuses in e's own recipient statuses.
+func markRecipients(e *email.Email, statuses map[string]email.RecipientStatus) {
+	if e.RecipientStatus == nil {
+		e.RecipientStatus = make(map[string]email.RecipientStatus, len(statuses))
+	}
+	for rcpt, status := range statuses {
+		e.RecipientStatus[rcpt] = status
+	}
+}
+
+// expireEmail fails e for good as expired in the queue.
+func (s *Service) expireEmail(ctx context.Context, e *email.Email) {
+	var err error
+	if categorizer, ok := s.queue.(queue.FailureCategorizer); ok {
+		err = categorizer.MarkFailedCategory(ctx, e.ID, queue.ErrExpired, queue.CategoryExpired, false)
+	} else {
+		err = s.queue.MarkFailed(ctx, e.ID, queue.ErrExpired, false)
+	}
+	if err != nil {
+		logctx.Printf(ctx, "Failed to mark email as failed: %v", err)
+	}
+}
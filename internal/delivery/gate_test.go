@@ -0,0 +1,132 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestDeliveryService_SuppressedAtSendTime(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	client := &envelopeClient{}
+	service := newDomainTestService(q, client)
+	list := NewSuppressionSet()
+	service.SetSuppressionList(list)
+	
+	q.Enqueue(ctx, &email.Email{
+		ID:     "partial",
+		From:   "sender@test.com",
+		To:     []string{"a@one.test", "b@one.test"},
+		Status: email.StatusQueued,
+	})
+	emails, _ := q.Dequeue(ctx, 1)
+	
+	// Suppressed after the email was queued
+	list.Add("B@one.test")
+	service.deliver(ctx, emails[0])
+	
+	want := []sentEnvelope{{host: "mx.one.test", rcpts: []string{"a@one.test"}}}
+	if !reflect.DeepEqual(client.sent, want) {
+		t.Errorf("Expected envelopes %v, got %v", want, client.sent)
+	}
+	if stats := q.Stats(); stats.TotalSuppressed != 1 || stats.TotalRejected != 0 {
+		t.Errorf("Expected 1 suppressed recipient and no rejections, got %+v", stats)
+	}
+	
+	// With every recipient suppressed the email is rejected unsent
+	client.sent = nil
+	list.Add("a@one.test")
+	q.Enqueue(ctx, &email.Email{
+		ID:     "all",
+		From:   "sender@test.com",
+		To:     []string{"a@one.test", "b@one.test"},
+		Status: email.StatusQueued,
+	})
+	emails, _ = q.Dequeue(ctx, 1)
+	service.deliver(ctx, emails[0])
+	
+	if len(client.sent) != 0 {
+		t.Errorf("Expected nothing sent, got %v", client.sent)
+	}
+	if _, err := q.Get(ctx, "all"); !errors.Is(err, queue.ErrEmailNotFound) {
+		t.Errorf("Expected the email to leave the queue, got %v", err)
+	}
+	if stats := q.Stats(); stats.TotalSuppressed != 3 || stats.TotalRejected != 1 {
+		t.Errorf("Expected 3 suppressed recipients and 1 rejection, got %+v", stats)
+	}
+}
+
+func TestDeliveryService_SuppressionRecordedPerRecipient(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	client := &envelopeClient{fail: map[string]error{"mx.two.test": errors.New("connection refused")}}
+	service := newDomainTestService(q, client)
+	service.SetSuppressionList(NewSuppressionSet("a@one.test"))
+	
+	q.Enqueue(ctx, &email.Email{
+		ID:     "test-1",
+		From:   "sender@test.com",
+		To:     []string{"a@one.test", "b@two.test"},
+		Status: email.StatusQueued,
+	})
+	emails, _ := q.Dequeue(ctx, 1)
+	service.deliver(ctx, emails[0])
+	
+	e, err := q.Get(ctx, "test-1")
+	if err != nil {
+		t.Fatalf("Expected the email to be retried, got %v", err)
+	}
+	if e.RecipientStatus["a@one.test"].Status != email.StatusSuppressed {
+		t.Errorf("Expected a@one.test suppressed, got %+v", e.RecipientStatus)
+	}
+	if e.RecipientStatus["b@two.test"].Status != email.StatusQueued {
+		t.Errorf("Expected b@two.test pending, got %+v", e.RecipientStatus)
+	}
+}
+
+func TestDeliveryService_CancelledWhileSending(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	client := &envelopeClient{}
+	service := newDomainTestService(q, client)
+	
+	q.Enqueue(ctx, &email.Email{ID: "test-1", From: "sender@test.com", To: []string{"a@one.test"}, Status: email.StatusQueued})
+	emails, _ := q.Dequeue(ctx, 1)
+	if err := q.Cancel(ctx, "test-1", "campaign withdrawn"); err != nil {
+		t.Fatal(err)
+	}
+	service.deliver(ctx, emails[0])
+	
+	if len(client.sent) != 0 {
+		t.Errorf("Expected nothing sent, got %v", client.sent)
+	}
+	if stats := q.Stats(); stats.TotalRejected != 1 || stats.Sending != 0 {
+		t.Errorf("Expected a rejection, got %+v", stats)
+	}
+}
+
+func TestDeliveryService_ExpiredBeforeSending(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	client := &envelopeClient{}
+	service := newDomainTestService(q, client)
+	
+	q.Enqueue(ctx, &email.Email{ID: "test-1", From: "sender@test.com", To: []string{"a@one.test"}, Status: email.StatusQueued})
+	emails, _ := q.Dequeue(ctx, 1)
+	past := time.Now().Add(-time.Second)
+	emails[0].ExpiresAt = &past
+	service.deliver(ctx, emails[0])
+	
+	if len(client.sent) != 0 {
+		t.Errorf("Expected nothing sent, got %v", client.sent)
+	}
+	if stats := q.Stats(); stats.FailureCategories[queue.CategoryExpired] != 1 {
+		t.Errorf("Expected an expiry, got %+v", stats)
+	}
+}
@@ -0,0 +1,134 @@
+package delivery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// traceHeaders are emitted ahead of everything else, as a receiving MTA
+// would have prepended them.
+var traceHeaders = []string{"return-path", "received"}
+
+// positionedHeaders have a fixed place in a built message, so they are
+// never repeated among the custom headers.
+var positionedHeaders = map[string]string{
+	"from":         "From",
+	"to":           "To",
+	"cc":           "Cc",
+	"bcc":          "Bcc",
+	"reply-to":     "Reply-To",
+	"subject":      "Subject",
+	"date":         "Date",
+	"message-id":   "Message-ID",
+	"mime-version": "MIME-Version",
+	"content-type": "Content-Type",
+	"return-path":  "Return-Path",
+	"received":     "Received",
+}
+
+// buildHeaders returns a built message's header lines in a fixed order:
+// trace headers, From, To, Cc, Reply-To, Subject, Date, Message-ID, the
+// MIME headers, then custom headers sorted by name. Custom header names
+// keep the casing the caller gave them. The output depends only on e, so
+// the same email always builds the same header block.
+func buildHeaders(e *email.Email, contentType string) []string {
+	var headers []string
+	for _, key := range traceHeaders {
+		if v := headerValue(e, key); v != "" {
+			headers = append(headers, fmt.Sprintf("%s: %s", positionedHeaders[key], v))
+		}
+	}
+	
+	headers = append(headers,
+		fmt.Sprintf("From: %s", e.From),
+		fmt.Sprintf("To: %s", addressHeader(e, "To", e.To)),
+	)
+	if cc := addressHeader(e, "Cc", e.CC); cc != "" {
+		headers = append(headers, fmt.Sprintf("Cc: %s", cc))
+	}
+	if v := headerValue(e, "reply-to"); v != "" {
+		headers = append(headers, fmt.Sprintf("Reply-To: %s", v))
+	}
+	headers = append(headers,
+		fmt.Sprintf("Subject: %s", e.Subject),
+		fmt.Sprintf("Date: %s", messageDate(e)),
+	)
+	if v := headerValue(e, "message-id"); v != "" {
+		headers = append(headers, fmt.Sprintf("Message-ID: %s", v))
+	}
+	headers = append(headers,
+		"MIME-Version: 1.0",
+		"Content-Type: "+contentType,
+	)
+	
+	return append(headers, customHeaders(e)...)
+}
+
+// customHeaders returns the caller's own headers sorted case-insensitively
+// by name, with names that differ only in case ordered by their exact
+// spelling.
+func customHeaders(e *email.Email) []string {
+	keys := make([]string, 0, len(e.Headers))
+	for k := range e.Headers {
+		if _, ok := positionedHeaders[strings.ToLower(k)]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		li, lj := strings.ToLower(keys[i]), strings.ToLower(keys[j])
+		if li != lj {
+			return li < lj
+		}
+		return keys[i] < keys[j]
+	})
+	
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = fmt.Sprintf("%s: %s", k, e.Headers[k])
+	}
+	return lines
+}
+
+// headerValue looks up a caller-supplied header by lowercase name,
+// whatever casing it was given in. If it was given more than once in
+// different casings, the first spelling in sort order wins.
+func headerValue(e *email.Email, lower string) string {
+	found, value := "", ""
+	for k, v := range e.Headers {
+		if strings.ToLower(k) == lower && (found == "" || k < found) {
+			found, value = k, v
+		}
+	}
+	return value
+}
+
+// messageDate returns the Date header for a built message: the one the
+// caller supplied, else the time the email was submitted.
+func messageDate(e *email.Email) string {
+	if v := headerValue(e, "date"); v != "" {
+		return v
+	}
+	if !e.CreatedAt.IsZero() {
+		return e.CreatedAt.Format(time.RFC1123Z)
+	}
+	return time.Now().Format(time.RFC1123Z)
+}
+
+// multipartBoundary derives the MIME boundary from the message content
+// instead of choosing it at random, so rebuilding an email reproduces it.
+// A boundary made from a digest of the parts cannot appear inside them.
+func multipartBoundary(e *email.Email) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", e.ID, e.Body, e.HTML)
+	for _, att := range e.Attachments {
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00", att.Filename, att.ContentType, len(att.Data))
+		h.Write(att.Data)
+	}
+	return "=_" + hex.EncodeToString(h.Sum(nil)[:16])
+}
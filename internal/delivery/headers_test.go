@@ -0,0 +1,94 @@
+package delivery
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestWriteMessage_HeaderOrder(t *testing.T) {
+	e := &email.Email{
+		ID:        "order-1",
+		From:      "sender@example.com",
+		To:        []string{"recipient@example.com"},
+		CC:        []string{"copy@example.com"},
+		Subject:   "Ordered",
+		Body:      "Body",
+		CreatedAt: time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC),
+		Headers: map[string]string{
+			"X-Mailer":         "app",
+			"reply-to":         "support@example.com",
+			"List-Unsubscribe": "<mailto:unsubscribe@example.com>",
+			"Message-Id":       "<order-1@example.com>",
+			"x-campaign-ID":    "autumn",
+		},
+	}
+	
+	want := "From: sender@example.com\r\n" +
+		"To: recipient@example.com\r\n" +
+		"Cc: copy@example.com\r\n" +
+		"Reply-To: support@example.com\r\n" +
+		"Subject: Ordered\r\n" +
+		"Date: Fri, 16 Oct 2026 08:00:00 +0000\r\n" +
+		"Message-ID: <order-1@example.com>\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"List-Unsubscribe: <mailto:unsubscribe@example.com>\r\n" +
+		"x-campaign-ID: autumn\r\n" +
+		"X-Mailer: app\r\n" +
+		"\r\n" +
+		"Body"
+	
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, e, nil); err != nil {
+		t.Fatalf("Failed to write email: %v", err)
+	}
+	if buf.String() != want {
+		t.Errorf("Unexpected message:\n got %q\nwant %q", buf.String(), want)
+	}
+}
+
+func TestWriteMessage_Deterministic(t *testing.T) {
+	e := &email.Email{
+		ID:        "det-1",
+		From:      "sender@example.com",
+		To:        []string{"recipient@example.com"},
+		Subject:   "Invoice",
+		Body:      "See attached",
+		CreatedAt: time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC),
+		Headers: map[string]string{
+			"X-A": "1", "X-B": "2", "X-C": "3", "X-D": "4", "X-E": "5",
+		},
+		Attachments: []email.Attachment{
+			{Filename: "invoice.pdf", ContentType: "application/pdf", Data: []byte("pdf")},
+		},
+	}
+	
+	var first bytes.Buffer
+	if err := WriteMessage(&first, e, nil); err != nil {
+		t.Fatalf("Failed to write email: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		var buf bytes.Buffer
+		if err := WriteMessage(&buf, e.Clone(), nil); err != nil {
+			t.Fatalf("Failed to write email: %v", err)
+		}
+		if buf.String() != first.String() {
+			t.Fatalf("Build %d differs:\n got %q\nwant %q", i, buf.String(), first.String())
+		}
+	}
+	
+	// A different attachment gets a different boundary
+	other := e.Clone()
+	other.Attachments = []email.Attachment{{Filename: "invoice.pdf", ContentType: "application/pdf", Data: []byte("PDF")}}
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, other, nil); err != nil {
+		t.Fatalf("Failed to write email: %v", err)
+	}
+	if boundary := multipartBoundary(e); strings.Contains(buf.String(), boundary) {
+		t.Errorf("Expected a new boundary for different content")
+	}
+}
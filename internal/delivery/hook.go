@@ -0,0 +1,177 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// ActionKind is what a PreDeliveryHook decided to do with an email.
+type ActionKind int
+
+const (
+	ActionProceed ActionKind = iota
+	ActionDefer
+	ActionAbort
+)
+
+// Action is the outcome of a PreDeliveryHook. A deferred email is tried
+// again after Delay without using up a retry; an aborted email is
+// rejected with Reason.
+type Action struct {
+	Kind   ActionKind
+	Delay  time.Duration
+	Reason string
+}
+
+// Proceed lets delivery continue.
+func Proceed() Action {
+	return Action{Kind: ActionProceed}
+}
+
+// Defer postpones delivery by delay, or the queue's defer delay if zero.
+func Defer(delay time.Duration, reason string) Action {
+	return Action{Kind: ActionDefer, Delay: delay, Reason: reason}
+}
+
+// Abort cancels delivery and rejects the email.
+func Abort(reason string) Action {
+	return Action{Kind: ActionAbort, Reason: reason}
+}
+
+// PreDeliveryHook runs in the delivery worker just before each attempt's
+// SMTP transaction. Modify may change the email, for example to rewrite
+// links; changes apply to this attempt only and are not stored in the
+// queue. An error is handled according to the configured failure policy.
+type PreDeliveryHook interface {
+	Name() string
+	Modify(ctx context.Context, e *email.Email) (Action, error)
+}
+
+var errHookTimeout = errors.New("timed out")
+
+// SetPreDeliveryHooks installs hooks, run in order before every attempt.
+// A SuppressionHook is not run with the others: its list is handed to
+// SetSuppressionList, so suppression is only ever checked by the pre-send
+// gate.
+func (s *Service) SetPreDeliveryHooks(hooks ...PreDeliveryHook) {
+	s.hooks = nil
+	for _, hook := range hooks {
+		if h, ok := hook.(SuppressionHook); ok {
+			s.SetSuppressionList(h.List)
+			continue
+		}
+		s.hooks = append(s.hooks, hook)
+	}
+}
+
+// preDeliver runs the hooks on e and returns the email to send. It returns
+// nil if a hook deferred or aborted delivery, after recording that in the
+// queue.
+func (s *Service) preDeliver(ctx, resultCtx context.Context, e *email.Email) *email.Email {
+	for _, hook := range s.hooks {
+		modified, action, err := s.runHook(ctx, hook, e)
+		if err != nil {
+			if s.config.HookFailurePolicy == "open" {
+				logctx.Printf(ctx, "Pre-delivery hook %s failed, sending anyway: %v", hook.Name(), err)
+				continue
+			}
+			action = Defer(0, fmt.Sprintf("pre-delivery hook %s failed: %v", hook.Name(), err))
+		}
+		
+		switch action.Kind {
+		case ActionDefer:
+			logctx.Printf(ctx, "Delivery deferred by %s: %s", hook.Name(), action.Reason)
+			s.deferEmail(resultCtx, e, action.Reason, action.Delay)
+			return nil
+		case ActionAbort:
+			logctx.Printf(ctx, "Delivery aborted by %s: %s", hook.Name(), action.Reason)
+			s.abortEmail(resultCtx, e, hook.Name(), action.Reason)
+			return nil
+		}
+		e = modified
+	}
+	return e
+}
+
+// runHook calls hook on a copy of e, so a hook that overruns its timeout
+// cannot change the email being sent.
+func (s *Service) runHook(ctx context.Context, hook PreDeliveryHook, e *email.Email) (*email.Email, Action, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.config.HookTimeout)
+	defer cancel()
+	
+	type result struct {
+		action Action
+		err    error
+	}
+	c := e.Clone()
+	done := make(chan result, 1)
+	go func() {
+		action, err := hook.Modify(ctx, c)
+		done <- result{action, err}
+	}()
+	
+	select {
+	case res := <-done:
+		return c, res.action, res.err
+	case <-ctx.Done():
+		return nil, Action{}, errHookTimeout
+	}
+}
+
+// deferEmail postpones e, falling back to a failed attempt for queues
+// that cannot defer.
+func (s *Service) deferEmail(ctx context.Context, e *email.Email, reason string, delay time.Duration) {
+	if deferrer, ok := s.queue.(queue.Deferrer); ok {
+		if err := deferrer.Defer(ctx, e.ID, reason, delay); err != nil {
+			logctx.Printf(ctx, "Failed to defer email: %v", err)
+		}
+		return
+	}
+	if err := s.queue.MarkFailed(ctx, e.ID, reason, s.retries(e)); err != nil {
+		logctx.Printf(ctx, "Failed to mark email as failed: %v", err)
+	}
+}
+
+// postponeEmail puts e back in the queue for delay without counting
+// anything against it, falling back to a deferral for queues that cannot.
+func (s *Service) postponeEmail(ctx context.Context, e *email.Email, reason string, delay time.Duration) {
+	if p, ok := s.queue.(queue.Postponer); ok {
+		if err := p.Postpone(ctx, e.ID, delay); err != nil {
+			logctx.Printf(ctx, "Failed to postpone email: %v", err)
+		}
+		return
+	}
+	s.deferEmail(ctx, e, reason, delay)
+}
+
+// postponeWithReason postpones e for delay recording reason as its
+// DeferredReason, falling back to postponeEmail for queues that cannot.
+func (s *Service) postponeWithReason(ctx context.Context, e *email.Email, reason string, delay time.Duration) {
+	if p, ok := s.queue.(queue.ReasonPostponer); ok {
+		if err := p.PostponeWithReason(ctx, e.ID, reason, delay); err != nil {
+			logctx.Printf(ctx, "Failed to postpone email: %v", err)
+		}
+		return
+	}
+	s.postponeEmail(ctx, e, reason, delay)
+}
+
+// abortEmail rejects e, falling back to a permanent failure for queues
+// that cannot reject.
+func (s *Service) abortEmail(ctx context.Context, e *email.Email, by, reason string) {
+	if rejecter, ok := s.queue.(queue.Rejecter); ok {
+		if err := rejecter.Reject(ctx, e.ID, by, reason); err != nil {
+			logctx.Printf(ctx, "Failed to reject email: %v", err)
+		}
+		return
+	}
+	if err := s.queue.MarkFailed(ctx, e.ID, reason, false); err != nil {
+		logctx.Printf(ctx, "Failed to mark email as failed: %v", err)
+	}
+}
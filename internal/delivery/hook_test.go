@@ -0,0 +1,171 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+type hookFunc func(ctx context.Context, e *email.Email) (Action, error)
+
+func (f hookFunc) Name() string { return "test-hook" }
+
+func (f hookFunc) Modify(ctx context.Context, e *email.Email) (Action, error) {
+	return f(ctx, e)
+}
+
+type suppressionSet map[string]bool
+
+func (s suppressionSet) Suppressed(ctx context.Context, address string) (bool, error) {
+	return s[address], nil
+}
+
+// hookService returns a service delivering from q, which holds a single
+// email "test-1" that has been dequeued and is returned.
+func hookService(t *testing.T, policy string, hooks ...PreDeliveryHook) (*Service, *queue.MemoryQueue, *mockSMTPClient, *email.Email) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		HookTimeout:       50 * time.Millisecond,
+		HookFailurePolicy: policy,
+	}, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com", Pref: 10}},
+		},
+	}
+	client := &mockSMTPClient{}
+	service.client = client
+	service.SetPreDeliveryHooks(hooks...)
+	
+	q.Enqueue(ctx, &email.Email{
+		ID:     "test-1",
+		From:   "sender@test.com",
+		To:     []string{"a@example.com", "b@example.com"},
+		CC:     []string{"c@example.com"},
+		Status: email.StatusQueued,
+	})
+	emails, _ := q.Dequeue(ctx, 1)
+	if len(emails) != 1 {
+		t.Fatal("Expected the email to be dequeued")
+	}
+	return service, q, client, emails[0]
+}
+
+func TestPreDeliveryHook_Suppression(t *testing.T) {
+	hook := SuppressionHook{List: suppressionSet{"b@example.com": true}}
+	service, q, client, e := hookService(t, "closed", hook)
+	
+	service.deliver(context.Background(), e)
+	
+	if len(client.sent) != 1 {
+		t.Fatalf("Expected one email sent, got %d", len(client.sent))
+	}
+	if status := client.sent[0].RecipientStatus["b@example.com"]; status.Status != email.StatusSuppressed {
+		t.Errorf("Expected suppressed recipient to be dropped, got %+v", status)
+	}
+	if q.Size() != 0 {
+		t.Errorf("Expected email to be delivered")
+	}
+	
+	// The hook's list is checked by the pre-send gate, not as a hook
+	if len(service.hooks) != 0 || service.suppression == nil {
+		t.Errorf("Expected the hook to install its list on the gate, got %d hooks", len(service.hooks))
+	}
+	if stats := q.Stats(); stats.TotalSuppressed != 1 {
+		t.Errorf("Expected the suppressed recipient to be counted, got %+v", stats)
+	}
+}
+
+func TestPreDeliveryHook_SuppressionAbort(t *testing.T) {
+	hook := SuppressionHook{List: suppressionSet{
+		"a@example.com": true,
+		"b@example.com": true,
+		"c@example.com": true,
+	}}
+	service, q, client, e := hookService(t, "closed", hook)
+	
+	service.deliver(context.Background(), e)
+	
+	if len(client.sent) != 0 {
+		t.Fatalf("Expected nothing sent, got %d", len(client.sent))
+	}
+	if _, err := q.Get(context.Background(), "test-1"); err != queue.ErrEmailNotFound {
+		t.Errorf("Expected aborted email to leave the queue, got %v", err)
+	}
+	if stats := q.Stats(); stats.TotalRejected != 1 || stats.Sending != 0 {
+		t.Errorf("Expected one rejection, got %+v", stats)
+	}
+}
+
+func TestPreDeliveryHook_Defer(t *testing.T) {
+	hook := hookFunc(func(ctx context.Context, e *email.Email) (Action, error) {
+		return Defer(time.Hour, "campaign paused"), nil
+	})
+	service, q, client, e := hookService(t, "closed", hook)
+	
+	service.deliver(context.Background(), e)
+	
+	stored, err := q.Get(context.Background(), "test-1")
+	if err != nil {
+		t.Fatalf("Expected deferred email to stay queued, got %v", err)
+	}
+	if len(client.sent) != 0 || stored.DeferCount != 1 || stored.RetryCount != 0 {
+		t.Errorf("Expected a deferral without sending, got sent=%d defer=%d retry=%d",
+			len(client.sent), stored.DeferCount, stored.RetryCount)
+	}
+	if stored.ScheduledAt.Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("Expected the hook's delay, scheduled at %v", stored.ScheduledAt)
+	}
+}
+
+func TestPreDeliveryHook_FailurePolicy(t *testing.T) {
+	failing := hookFunc(func(ctx context.Context, e *email.Email) (Action, error) {
+		return Action{}, errors.New("suppression list unavailable")
+	})
+	slow := hookFunc(func(ctx context.Context, e *email.Email) (Action, error) {
+		time.Sleep(200 * time.Millisecond)
+		e.To = nil
+		return Proceed(), nil
+	})
+	
+	tests := []struct {
+		name     string
+		policy   string
+		hook     PreDeliveryHook
+		wantSent bool
+	}{
+		{name: "error fails closed", policy: "closed", hook: failing},
+		{name: "error fails open", policy: "open", hook: failing, wantSent: true},
+		{name: "timeout fails closed", policy: "closed", hook: slow},
+		{name: "timeout fails open", policy: "open", hook: slow, wantSent: true},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, q, client, e := hookService(t, tt.policy, tt.hook)
+			
+			service.deliver(context.Background(), e)
+			
+			if tt.wantSent {
+				if len(client.sent) != 1 || len(client.sent[0].To) != 2 {
+					t.Fatalf("Expected the unmodified email to be sent, got %+v", client.sent)
+				}
+				return
+			}
+			stored, err := q.Get(context.Background(), "test-1")
+			if err != nil || stored.DeferCount != 1 || len(client.sent) != 0 {
+				t.Errorf("Expected the email to be deferred, got %v, %+v", err, stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/identity"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// LocalHandler takes mail for the domains this server hosts, which is
+// never sent over SMTP. Returning a *RecipientError refuses some
+// recipients; any other error fails them all, temporarily unless it is
+// permanent.
+type LocalHandler interface {
+	DeliverLocal(ctx context.Context, e *email.Email, rcpts []string) error
+}
+
+// ErrNoLocalHandler is returned for mail to a local domain when there is
+// nowhere to store it. It is temporary, so the mail waits for one to be
+// configured.
+var ErrNoLocalHandler = errors.New("no local mail store configured")
+
+// MailboxStore is the built-in LocalHandler. It writes each message, as
+// it would have been sent, to <Dir>/<domain>/<user>/<id>.eml for each
+// recipient.
+type MailboxStore struct {
+	Dir string
+	
+	// The server whose name goes in the Received header, if the message
+	// needs one
+	Identity *identity.Identity
+}
+
+// NewMailboxStore returns a store keeping mailboxes under dir.
+func NewMailboxStore(dir string) *MailboxStore {
+	return &MailboxStore{Dir: dir}
+}
+
+func (m *MailboxStore) DeliverLocal(ctx context.Context, e *email.Email, rcpts []string) error {
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, e, m.Identity); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	for _, rcpt := range rcpts {
+		user, domain, _ := strings.Cut(rcpt, "@")
+		dir := filepath.Join(m.Dir, pathSafe(strings.ToLower(domain)), pathSafe(user))
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, pathSafe(e.ID)+".eml"), buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pathSafe makes s usable as one path element that stays inside its
+// directory.
+func pathSafe(s string) string {
+	s = strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(s)
+	if s == "" || s == "." || s == ".." {
+		return "_" + s
+	}
+	return s
+}
+
+// newLocalDomains returns the set of domains cfg says this server hosts,
+// or nil if none.
+func newLocalDomains(cfg *config.DeliveryConfig) map[string]bool {
+	if len(cfg.LocalDomains) == 0 {
+		return nil
+	}
+	domains := make(map[string]bool, len(cfg.LocalDomains))
+	for _, domain := range cfg.LocalDomains {
+		domains[strings.ToLower(domain)] = true
+	}
+	return domains
+}
+
+// newLocalHandler returns the store for local mail cfg configures, or nil
+// if there is none.
+func newLocalHandler(cfg *config.DeliveryConfig) LocalHandler {
+	if cfg.LocalDir == "" {
+		return nil
+	}
+	return &MailboxStore{Dir: cfg.LocalDir, Identity: cfg.Identity}
+}
+
+// SetLocalHandler sets where mail for the local domains goes, in place of
+// the mailbox store under delivery.local_dir.
+func (s *Service) SetLocalHandler(h LocalHandler) {
+	s.localHandler = h
+}
+
+// deliverLocal hands e, for rcpts at one of the local domains, to the
+// local handler instead of sending it.
+func (s *Service) deliverLocal(ctx context.Context, e *email.Email, rcpts []string) error {
+	if s.localHandler == nil {
+		return ErrNoLocalHandler
+	}
+	err := s.localHandler.DeliverLocal(ctx, e, rcpts)
+	logDelivered(ctx, "local store", rcpts, err, "")
+	return err
+}
@@ -0,0 +1,151 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestDeliveryService_LocalDomains(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	q := queue.NewMemoryQueue(10)
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		LocalDomains:      []string{"Hosted.test"},
+		LocalDir:          dir,
+	}, q)
+	resolver := &mockDNSResolver{
+		mx: map[string][]*net.MX{"example.com": {{Host: "mail.example.com", Pref: 10}}},
+	}
+	service.resolver = resolver
+	client := &envelopeClient{}
+	service.client = client
+	
+	e := &email.Email{
+		ID:      "local-1",
+		From:    "sender@test.com",
+		To:      []string{"alice@hosted.test", "bob@HOSTED.test", "rcpt@example.com"},
+		Subject: "Hello",
+		Body:    "Body",
+	}
+	if err := q.Enqueue(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	var result Result
+	service.SetResultHook(func(r Result) { result = r })
+	service.poll(ctx, 0, "")
+	
+	if result.Status != email.StatusDelivered {
+		t.Fatalf("Expected delivery, got %s: %v", result.Status, result.Err)
+	}
+	// Only the remote recipient went out over SMTP
+	if len(client.sent) != 1 || strings.Join(client.sent[0].rcpts, ",") != "rcpt@example.com" {
+		t.Errorf("Expected only rcpt@example.com sent over SMTP, got %+v", client.sent)
+	}
+	for _, user := range []string{"alice", "bob"} {
+		msg, err := os.ReadFile(filepath.Join(dir, "hosted.test", user, "local-1.eml"))
+		if err != nil {
+			t.Fatalf("Expected %s's copy stored: %v", user, err)
+		}
+		if !strings.Contains(string(msg), "Subject: Hello") {
+			t.Errorf("Expected the built message stored, got:\n%s", msg)
+		}
+	}
+	
+	// A handler set in code takes the mail instead
+	var handled []string
+	service.SetLocalHandler(localHandlerFunc(func(ctx context.Context, e *email.Email, rcpts []string) error {
+		handled = append(handled, rcpts...)
+		return nil
+	}))
+	if _, err := service.processEmail(ctx, &email.Email{ID: "local-2", From: "sender@test.com", To: []string{"carol@hosted.test"}}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(handled, ",") != "carol@hosted.test" {
+		t.Errorf("Expected the handler to get carol@hosted.test, got %v", handled)
+	}
+	
+	// Without anywhere to store it, local mail waits for a retry
+	service.SetLocalHandler(nil)
+	_, err := service.processEmail(ctx, &email.Email{ID: "local-3", From: "sender@test.com", To: []string{"carol@hosted.test"}})
+	if !errors.Is(err, ErrNoLocalHandler) || isRejected(err) {
+		t.Errorf("Expected a temporary ErrNoLocalHandler, got %v", err)
+	}
+}
+
+type localHandlerFunc func(ctx context.Context, e *email.Email, rcpts []string) error
+
+func (f localHandlerFunc) DeliverLocal(ctx context.Context, e *email.Email, rcpts []string) error {
+	return f(ctx, e, rcpts)
+}
+
+func TestMailboxStore_PathSafe(t *testing.T) {
+	dir := t.TempDir()
+	store := NewMailboxStore(filepath.Join(dir, "mail"))
+	e := &email.Email{ID: "../id", From: "sender@test.com", To: []string{"../../x@hosted.test"}, Body: "Body"}
+	if err := store.DeliverLocal(context.Background(), e, e.To); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "mail", "hosted.test", ".._.._x", ".._id.eml")); err != nil {
+		t.Errorf("Expected the message kept inside the store: %v", err)
+	}
+}
+
+func TestDeliveryService_MailLoop(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		MaxHops:           5,
+	}, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{"example.com": {{Host: "mail.example.com", Pref: 10}}},
+	}
+	client := &mockSMTPClient{}
+	service.client = client
+	
+	raw := func(hops int) []byte {
+		var b strings.Builder
+		for i := 0; i < hops; i++ {
+			b.WriteString("Received: from a.test by b.test; Fri, 16 Oct 2026 08:00:00 +0000\r\n")
+		}
+		b.WriteString("From: sender@test.com\r\nSubject: Loop\r\n\r\nreceived: in the body is not a hop\r\n")
+		return []byte(b.String())
+	}
+	for _, e := range []*email.Email{
+		{ID: "looping", From: "sender@test.com", To: []string{"rcpt@example.com"}, Raw: raw(6), Relayed: true},
+		{ID: "fine", From: "sender@test.com", To: []string{"rcpt@example.com"}, Raw: raw(5), Relayed: true},
+	} {
+		if err := q.Enqueue(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results := make(map[string]Result)
+	service.SetResultHook(func(r Result) { results[r.ID] = r })
+	service.poll(ctx, 0, "")
+	
+	if len(client.sent) != 1 || client.sent[0].ID != "fine" {
+		t.Fatalf("Expected only the email within the hop limit sent, got %d", len(client.sent))
+	}
+	r := results["looping"]
+	if r.Status != email.StatusBounced || !errors.Is(r.Err, ErrMailLoop) {
+		t.Errorf("Expected the looping email failed as a mail loop, got %s: %v", r.Status, r.Err)
+	}
+	if FailureReason(r.Err) != ReasonMailLoop {
+		t.Errorf("Expected reason %q, got %q", ReasonMailLoop, FailureReason(r.Err))
+	}
+}
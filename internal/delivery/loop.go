@@ -0,0 +1,43 @@
+package delivery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// ErrMailLoop is returned for mail that has passed through more servers
+// than delivery.max_hops allows, as mail going round in a loop does. It
+// is permanent.
+var ErrMailLoop = errors.New("mail loop detected")
+
+// checkLoop returns an error wrapping ErrMailLoop if e, as it would be
+// sent, has more Received headers than the hop limit. Mail whose headers
+// can't be read is left for the send to fail.
+func (s *Service) checkLoop(e *email.Email) error {
+	limit := s.config.MaxHops
+	if limit <= 0 {
+		return nil
+	}
+	header, err := originalHeaders(e, s.config.Identity)
+	if err != nil {
+		return nil
+	}
+	if n := countReceived(header); n > limit {
+		return fmt.Errorf("%w: %d Received headers, more than %d", ErrMailLoop, n, limit)
+	}
+	return nil
+}
+
+// countReceived counts the Received fields in a header block.
+func countReceived(header []byte) int {
+	n := 0
+	for _, line := range bytes.Split(header, []byte("\n")) {
+		if len(line) >= 9 && bytes.EqualFold(line[:9], []byte("received:")) {
+			n++
+		}
+	}
+	return n
+}
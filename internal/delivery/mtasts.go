@@ -0,0 +1,303 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+)
+
+// MTA-STS policy modes (RFC 8461)
+const (
+	MTASTSEnforce = "enforce"
+	MTASTSTesting = "testing"
+	MTASTSNone    = "none"
+)
+
+const (
+	// mtaSTSFetchTimeout bounds fetching a policy over HTTPS
+	mtaSTSFetchTimeout = 10 * time.Second
+	
+	// maxMTASTSPolicySize caps a policy file, as RFC 8461 suggests
+	maxMTASTSPolicySize = 64 * 1024
+	
+	// maxMTASTSMaxAge is the longest max_age RFC 8461 allows, one year
+	maxMTASTSMaxAge = 31557600 * time.Second
+)
+
+// ErrTLSRequired is returned when a host must be reached over verified TLS,
+// because its domain enforces an MTA-STS policy or it has TLSA records,
+// and that failed. It is temporary.
+var ErrTLSRequired = errors.New("verified TLS required")
+
+// TXTResolver is implemented by resolvers that can look up TXT records,
+// which MTA-STS policy discovery needs. Without one no policies are used.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// MTASTSPolicy is a domain's MTA-STS policy: the MX hosts it may be sent
+// mail through, and whether that is enforced.
+type MTASTSPolicy struct {
+	ID     string
+	Mode   string
+	MX     []string
+	MaxAge time.Duration
+}
+
+// parseMTASTSPolicy parses the policy file served by a domain.
+func parseMTASTSPolicy(body string) (*MTASTSPolicy, error) {
+	p := &MTASTSPolicy{}
+	var version string
+	maxAge := -1
+	for _, line := range strings.Split(body, "\n") {
+		key, value, ok := strings.Cut(strings.TrimRight(line, "\r"), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "version":
+			version = value
+		case "mode":
+			p.Mode = value
+		case "mx":
+			p.MX = append(p.MX, strings.ToLower(value))
+		case "max_age":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid max_age %q", value)
+			}
+			maxAge = n
+		}
+	}
+	
+	switch {
+	case version != "STSv1":
+		return nil, fmt.Errorf("unsupported version %q", version)
+	case p.Mode != MTASTSEnforce && p.Mode != MTASTSTesting && p.Mode != MTASTSNone:
+		return nil, fmt.Errorf("invalid mode %q", p.Mode)
+	case maxAge < 0:
+		return nil, errors.New("missing max_age")
+	case len(p.MX) == 0 && p.Mode != MTASTSNone:
+		return nil, errors.New("no mx patterns")
+	}
+	p.MaxAge = min(time.Duration(maxAge)*time.Second, maxMTASTSMaxAge)
+	return p, nil
+}
+
+// matches reports whether host may receive mail under the policy: it
+// equals one of its mx patterns, or a pattern "*.example.com" with
+// exactly one more label in front.
+func (p *MTASTSPolicy) matches(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.MX {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			label, found := strings.CutSuffix(host, suffix)
+			if found && label != "" && !strings.Contains(label, ".") {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// mtaSTS discovers and caches recipient domains' MTA-STS policies. A
+// policy is kept for its max_age; a domain without one is remembered for
+// noPolicyTTL. When a policy cannot be refreshed, the expired one stays in
+// use, and a domain whose policy was never fetched is treated as having
+// none, as RFC 8461 asks.
+type mtaSTS struct {
+	// enforce is false to only log what enforce policies would refuse
+	enforce     bool
+	noPolicyTTL time.Duration
+	client      *http.Client
+	policyURL   func(domain string) string
+	
+	mu       sync.Mutex
+	policies map[string]*mtaSTSEntry
+}
+
+type mtaSTSEntry struct {
+	policy    *MTASTSPolicy
+	expiresAt time.Time
+}
+
+// newMTASTS returns the MTA-STS component configured by cfg, or nil if it
+// is off.
+func newMTASTS(cfg *config.DeliveryConfig) *mtaSTS {
+	if cfg.MTASTS == "off" {
+		return nil
+	}
+	return &mtaSTS{
+		enforce:     cfg.MTASTS != MTASTSTesting,
+		noPolicyTTL: cfg.DNSCacheTTL,
+		client: &http.Client{
+			Timeout: mtaSTSFetchTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		policyURL: func(domain string) string {
+			return "https://mta-sts." + domain + "/.well-known/mta-sts.txt"
+		},
+		policies: make(map[string]*mtaSTSEntry),
+	}
+}
+
+// policy returns domain's policy, or nil if it has none.
+func (m *mtaSTS) policy(ctx context.Context, resolver TXTResolver, domain string, now time.Time) *MTASTSPolicy {
+	m.mu.Lock()
+	cached := m.policies[domain]
+	m.mu.Unlock()
+	if cached != nil && now.Before(cached.expiresAt) {
+		return cached.policy
+	}
+	
+	stale := func(err error) *MTASTSPolicy {
+		if cached == nil || cached.policy == nil {
+			logctx.Printf(ctx, "MTA-STS policy for %s unavailable, sending without one: %v", domain, err)
+			return nil
+		}
+		logctx.Printf(ctx, "MTA-STS policy for %s could not be refreshed, keeping the cached one: %v", domain, err)
+		return cached.policy
+	}
+	
+	id, err := m.lookupID(ctx, resolver, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound && (cached == nil || cached.policy == nil) {
+			m.store(domain, nil, now.Add(m.noPolicyTTL))
+			return nil
+		}
+		return stale(err)
+	}
+	if id == "" {
+		if cached == nil || cached.policy == nil {
+			m.store(domain, nil, now.Add(m.noPolicyTTL))
+			return nil
+		}
+		return stale(errors.New("no MTA-STS TXT record"))
+	}
+	
+	// An unchanged id means the cached policy is still current
+	if cached != nil && cached.policy != nil && cached.policy.ID == id {
+		m.store(domain, cached.policy, now.Add(cached.policy.MaxAge))
+		return cached.policy
+	}
+	
+	p, err := m.fetch(ctx, domain)
+	if err != nil {
+		return stale(err)
+	}
+	p.ID = id
+	m.store(domain, p, now.Add(p.MaxAge))
+	return p
+}
+
+// lookupID returns the id of domain's MTA-STS TXT record, or "" if it
+// has none.
+func (m *mtaSTS) lookupID(ctx context.Context, resolver TXTResolver, domain string) (string, error) {
+	records, err := resolver.LookupTXT(ctx, "_mta-sts."+domain)
+	if err != nil {
+		return "", err
+	}
+	for _, record := range records {
+		if !strings.HasPrefix(record, "v=STSv1") {
+			continue
+		}
+		for _, field := range strings.Split(record, ";") {
+			if key, value, ok := strings.Cut(strings.TrimSpace(field), "="); ok && key == "id" {
+				return value, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// fetch downloads and parses domain's policy file.
+func (m *mtaSTS) fetch(ctx context.Context, domain string) (*MTASTSPolicy, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.policyURL(domain), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("policy fetch returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMTASTSPolicySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMTASTSPolicySize {
+		return nil, errors.New("policy too large")
+	}
+	return parseMTASTSPolicy(string(body))
+}
+
+// store caches p, nil for no policy, until expiresAt, forgetting expired
+// domains when the cache is full.
+func (m *mtaSTS) store(domain string, p *MTASTSPolicy, expiresAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.policies[domain]; !exists && len(m.policies) >= maxTrackedHosts {
+		for d, entry := range m.policies {
+			if entry.policy == nil || time.Until(entry.expiresAt) < 0 {
+				delete(m.policies, d)
+			}
+		}
+		if len(m.policies) >= maxTrackedHosts {
+			return
+		}
+	}
+	m.policies[domain] = &mtaSTSEntry{policy: p, expiresAt: expiresAt}
+}
+
+// applyMTASTS applies domain's MTA-STS policy to mxRecords. Under an
+// enforced policy only matching hosts are returned, with ctx requiring
+// verified TLS to them; if none match the domain fails temporarily. A
+// policy in testing mode, or when enforcement is turned off, only logs
+// the hosts it would refuse.
+func (s *Service) applyMTASTS(ctx context.Context, domain string, mxRecords []*net.MX) (context.Context, []*net.MX, error) {
+	resolver, ok := s.resolver.(TXTResolver)
+	if s.sts == nil || !ok {
+		return ctx, mxRecords, nil
+	}
+	p := s.sts.policy(ctx, resolver, domain, time.Now())
+	if p == nil || p.Mode == MTASTSNone {
+		return ctx, mxRecords, nil
+	}
+	
+	matching := make([]*net.MX, 0, len(mxRecords))
+	for _, mx := range mxRecords {
+		if p.matches(mx.Host) {
+			matching = append(matching, mx)
+		}
+	}
+	
+	if p.Mode != MTASTSEnforce || !s.sts.enforce {
+		if len(matching) < len(mxRecords) {
+			logctx.Printf(ctx, "MTA-STS policy for %s (%s) does not list some of its MX hosts, sending anyway", domain, p.Mode)
+		}
+		return ctx, mxRecords, nil
+	}
+	if len(matching) == 0 {
+		return ctx, nil, fmt.Errorf("no MX host of %s matches its MTA-STS policy", domain)
+	}
+	return requireTLS(ctx), matching, nil
+}
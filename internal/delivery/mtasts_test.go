@@ -0,0 +1,229 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// fakeSTSResolver answers MX lookups from mx and TXT lookups from txt.
+type fakeSTSResolver struct {
+	mockDNSResolver
+	txt     map[string][]string
+	lookups atomic.Int32
+}
+
+func (f *fakeSTSResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	f.lookups.Add(1)
+	if records, ok := f.txt[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// fakePolicyServer serves MTA-STS policies by domain over HTTPS.
+type fakePolicyServer struct {
+	*httptest.Server
+	policies map[string]string
+	fetches  atomic.Int32
+	down     atomic.Bool
+}
+
+func newFakePolicyServer(t *testing.T, policies map[string]string) *fakePolicyServer {
+	t.Helper()
+	f := &fakePolicyServer{policies: policies}
+	f.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.fetches.Add(1)
+		policy, ok := f.policies[r.URL.Query().Get("domain")]
+		if !ok || f.down.Load() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, policy)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// use points m at the fake server instead of mta-sts.<domain>.
+func (f *fakePolicyServer) use(m *mtaSTS) {
+	m.client = f.Client()
+	m.policyURL = func(domain string) string {
+		return f.URL + "/.well-known/mta-sts.txt?domain=" + domain
+	}
+}
+
+func TestParseMTASTSPolicy(t *testing.T) {
+	p, err := parseMTASTSPolicy("version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmx: *.Example.net\r\nmax_age: 86400\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Mode != MTASTSEnforce || p.MaxAge != 24*time.Hour || len(p.MX) != 2 {
+		t.Fatalf("Unexpected policy %+v", p)
+	}
+	
+	for host, want := range map[string]bool{
+		"mail.example.com":      true,
+		"MAIL.example.com.":     true,
+		"mx1.example.net":       true,
+		"example.net":           false,
+		"a.mx1.example.net":     false,
+		"mail.example.com.evil": false,
+	} {
+		if got := p.matches(host); got != want {
+			t.Errorf("matches(%q) = %v, want %v", host, got, want)
+		}
+	}
+	
+	for _, body := range []string{
+		"mode: enforce\nmx: a.example.com\nmax_age: 60\n",
+		"version: STSv1\nmode: strict\nmx: a.example.com\nmax_age: 60\n",
+		"version: STSv1\nmode: enforce\nmx: a.example.com\n",
+		"version: STSv1\nmode: enforce\nmax_age: 60\n",
+		"version: STSv1\nmode: enforce\nmx: a.example.com\nmax_age: soon\n",
+	} {
+		if _, err := parseMTASTSPolicy(body); err == nil {
+			t.Errorf("Expected an error parsing %q", body)
+		}
+	}
+}
+
+func TestMTASTS_PolicyCache(t *testing.T) {
+	ctx := context.Background()
+	server := newFakePolicyServer(t, map[string]string{
+		"example.com": "version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 3600\n",
+	})
+	resolver := &fakeSTSResolver{txt: map[string][]string{
+		"_mta-sts.example.com": {"v=STSv1; id=20260101"},
+	}}
+	m := newMTASTS(&config.DeliveryConfig{MTASTS: "enforce", DNSCacheTTL: time.Minute})
+	server.use(m)
+	now := time.Now()
+	
+	// Fetched once, then served from the cache for max_age
+	for i := 0; i < 3; i++ {
+		p := m.policy(ctx, resolver, "example.com", now.Add(time.Duration(i)*time.Minute))
+		if p == nil || p.Mode != MTASTSEnforce || p.ID != "20260101" {
+			t.Fatalf("Expected the enforce policy, got %+v", p)
+		}
+	}
+	if got := server.fetches.Load(); got != 1 {
+		t.Errorf("Expected 1 fetch within max_age, got %d", got)
+	}
+	
+	// Once expired, an unchanged id keeps the policy without a fetch
+	if p := m.policy(ctx, resolver, "example.com", now.Add(2*time.Hour)); p == nil {
+		t.Fatal("Expected the cached policy")
+	}
+	if got := server.fetches.Load(); got != 1 {
+		t.Errorf("Expected no fetch for an unchanged id, got %d", got)
+	}
+	
+	// A new id is fetched; if that fails the cached policy stays in use
+	resolver.txt["_mta-sts.example.com"] = []string{"v=STSv1; id=20260202"}
+	server.down.Store(true)
+	if p := m.policy(ctx, resolver, "example.com", now.Add(4*time.Hour)); p == nil || p.ID != "20260101" {
+		t.Fatalf("Expected the stale policy while the server is down, got %+v", p)
+	}
+	server.down.Store(false)
+	if p := m.policy(ctx, resolver, "example.com", now.Add(4*time.Hour)); p == nil || p.ID != "20260202" {
+		t.Fatalf("Expected the refreshed policy, got %+v", p)
+	}
+	
+	// A domain without a TXT record has no policy, remembered for a while
+	lookups := resolver.lookups.Load()
+	for i := 0; i < 2; i++ {
+		if p := m.policy(ctx, resolver, "plain.example", now); p != nil {
+			t.Fatalf("Expected no policy, got %+v", p)
+		}
+	}
+	if got := resolver.lookups.Load() - lookups; got != 1 {
+		t.Errorf("Expected 1 TXT lookup for a domain without a policy, got %d", got)
+	}
+	
+	// A policy that can't be fetched the first time means none
+	resolver.txt["_mta-sts.broken.example"] = []string{"v=STSv1; id=1"}
+	if p := m.policy(ctx, resolver, "broken.example", now); p != nil {
+		t.Errorf("Expected no policy when the fetch fails, got %+v", p)
+	}
+}
+
+func TestDeliveryService_MTASTS(t *testing.T) {
+	// The sink offers no STARTTLS
+	sink := newSinkServer(t, false)
+	
+	tests := []struct {
+		name          string
+		mode          string
+		policy        string
+		wantDelivered bool
+		wantTLSError  bool
+	}{
+		{name: "enforce without STARTTLS", mode: "enforce", policy: "enforce", wantTLSError: true},
+		{name: "enforce with no matching MX", mode: "enforce", policy: "enforce-other"},
+		{name: "testing policy", mode: "enforce", policy: "testing", wantDelivered: true},
+		{name: "enforcement disabled", mode: "testing", policy: "enforce", wantDelivered: true},
+		{name: "off", mode: "off", policy: "enforce-other", wantDelivered: true},
+	}
+	
+	policies := map[string]string{
+		"enforce":       "version: STSv1\nmode: enforce\nmx: " + sink.addr() + "\nmax_age: 3600\n",
+		"enforce-other": "version: STSv1\nmode: enforce\nmx: *.example.com\nmax_age: 3600\n",
+		"testing":       "version: STSv1\nmode: testing\nmx: *.example.com\nmax_age: 3600\n",
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakePolicyServer(t, map[string]string{"example.com": policies[tt.policy]})
+			cfg := &config.DeliveryConfig{
+				Workers:           1,
+				DNSCacheTTL:       5 * time.Minute,
+				ConnectionTimeout: 5 * time.Second,
+				MTASTS:            tt.mode,
+			}
+			service := NewService(cfg, queue.NewMemoryQueue(10))
+			service.resolver = &fakeSTSResolver{
+				mockDNSResolver: mockDNSResolver{mx: map[string][]*net.MX{
+					"example.com": {{Host: sink.addr(), Pref: 10}},
+				}},
+				txt: map[string][]string{"_mta-sts.example.com": {"v=STSv1; id=1"}},
+			}
+			service.client = newClient(cfg)
+			if service.sts != nil {
+				server.use(service.sts)
+			}
+			
+			messages := sink.messages.Load()
+			e := &email.Email{ID: "sts-1", From: "sender@test.com", To: []string{"rcpt@example.com"}, Subject: "Hi", Body: "Body", Status: email.StatusQueued}
+			_, err := service.processEmail(context.Background(), e)
+			delivered := sink.messages.Load() > messages
+			
+			if tt.wantDelivered {
+				if err != nil || !delivered {
+					t.Fatalf("Expected delivery, got %v", err)
+				}
+				return
+			}
+			if err == nil || delivered {
+				t.Fatal("Expected the email to be held back")
+			}
+			if permanent(err) {
+				t.Errorf("Expected a temporary failure, got %v", err)
+			}
+			if got := errors.Is(err, ErrTLSRequired); got != tt.wantTLSError {
+				t.Errorf("errors.Is(err, ErrTLSRequired) = %v for %v", got, err)
+			}
+		})
+	}
+}
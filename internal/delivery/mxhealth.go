@@ -0,0 +1,144 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+)
+
+// maxTrackedHosts bounds the MX host health table. When it is full, hosts
+// whose last failure is older than the longest backoff are forgotten.
+const maxTrackedHosts = 10000
+
+// mxHealth remembers MX hosts that recently failed to answer, so that an
+// attempt goes to a host known to work instead of waiting out a timeout
+// on one that is down. A failed host is skipped for backoff, doubling with
+// each further failure up to maxBackoff, and is tried again as usual once
+// that has passed. A success forgets it.
+type mxHealth struct {
+	backoff    time.Duration
+	maxBackoff time.Duration
+	
+	mu    sync.Mutex
+	hosts map[string]*hostHealth
+}
+
+type hostHealth struct {
+	failures    int
+	lastFailure time.Time
+	retryAt     time.Time
+}
+
+// newMXHealth returns the host health table configured by cfg, or nil if
+// the backoff is zero or negative.
+func newMXHealth(cfg *config.DeliveryConfig) *mxHealth {
+	if cfg.MXHostBackoff <= 0 {
+		return nil
+	}
+	maxBackoff := cfg.MXHostMaxBackoff
+	if maxBackoff < cfg.MXHostBackoff {
+		maxBackoff = cfg.MXHostBackoff
+	}
+	return &mxHealth{
+		backoff:    cfg.MXHostBackoff,
+		maxBackoff: maxBackoff,
+		hosts:      make(map[string]*hostHealth),
+	}
+}
+
+// order returns mxRecords with the hosts being skipped moved to the end,
+// soonest to be retried first, so they are only tried when every other
+// host has failed. The rest keep their order.
+func (h *mxHealth) order(mxRecords []*net.MX, now time.Time) []*net.MX {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	
+	var healthy, skipped []*net.MX
+	for _, mx := range mxRecords {
+		if hh := h.hosts[mx.Host]; hh != nil && now.Before(hh.retryAt) {
+			skipped = append(skipped, mx)
+		} else {
+			healthy = append(healthy, mx)
+		}
+	}
+	if len(skipped) == 0 {
+		return mxRecords
+	}
+	sort.SliceStable(skipped, func(i, j int) bool {
+		return h.hosts[skipped[i].Host].retryAt.Before(h.hosts[skipped[j].Host].retryAt)
+	})
+	return append(healthy, skipped...)
+}
+
+// failure records that host did not answer, and skips it for the backoff
+// its run of failures has earned.
+func (h *mxHealth) failure(ctx context.Context, host string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	
+	hh := h.hosts[host]
+	if hh == nil {
+		if len(h.hosts) >= maxTrackedHosts {
+			h.forgetStale(now)
+			if len(h.hosts) >= maxTrackedHosts {
+				return
+			}
+		}
+		hh = &hostHealth{}
+		h.hosts[host] = hh
+	}
+	
+	hh.failures++
+	hh.lastFailure = now
+	backoff := h.backoff
+	for i := 1; i < hh.failures && backoff < h.maxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, h.maxBackoff)
+	hh.retryAt = now.Add(backoff)
+	logctx.Printf(ctx, "MX host %s failed %d time(s) in a row, preferring other hosts for %s", host, hh.failures, backoff)
+}
+
+// success forgets host's failures once it has answered.
+func (h *mxHealth) success(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.hosts, host)
+}
+
+// forgetStale drops hosts that have not failed for longer than the
+// longest backoff. Callers must hold h.mu.
+func (h *mxHealth) forgetStale(now time.Time) {
+	for host, hh := range h.hosts {
+		if now.Sub(hh.lastFailure) >= h.maxBackoff {
+			delete(h.hosts, host)
+		}
+	}
+}
+
+// orderHosts puts domain's MX hosts in the order to try them, preferring
+// hosts that have not failed recently.
+func (s *Service) orderHosts(mxRecords []*net.MX) []*net.MX {
+	if s.hosts == nil {
+		return mxRecords
+	}
+	return s.hosts.order(mxRecords, time.Now())
+}
+
+// recordHost notes the outcome of a transaction to host in the host health
+// table. Any answer counts as the host working. Attempts cut short by
+// shutdown do not count.
+func (s *Service) recordHost(ctx context.Context, host string, err error) {
+	switch {
+	case s.hosts == nil, ctx.Err() != nil:
+	case hostAnswered(err):
+		s.hosts.success(host)
+	default:
+		s.hosts.failure(ctx, host, time.Now())
+	}
+}
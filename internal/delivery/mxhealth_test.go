@@ -0,0 +1,140 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestMXHealth_Order(t *testing.T) {
+	ctx := context.Background()
+	h := newMXHealth(&config.DeliveryConfig{
+		MXHostBackoff:    time.Minute,
+		MXHostMaxBackoff: 4 * time.Minute,
+	})
+	mx := []*net.MX{
+		{Host: "mx1.example.com", Pref: 10},
+		{Host: "mx2.example.com", Pref: 20},
+		{Host: "mx3.example.com", Pref: 30},
+	}
+	hosts := func(records []*net.MX) []string {
+		names := make([]string, len(records))
+		for i, r := range records {
+			names[i] = r.Host
+		}
+		return names
+	}
+	now := time.Now()
+	
+	h.failure(ctx, "mx1.example.com", now)
+	h.failure(ctx, "mx2.example.com", now.Add(-30*time.Second))
+	got := hosts(h.order(mx, now))
+	want := []string{"mx3.example.com", "mx2.example.com", "mx1.example.com"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+	
+	// Past its backoff a host is back in its usual place
+	if got := hosts(h.order(mx, now.Add(time.Minute))); got[0] != "mx1.example.com" {
+		t.Errorf("Expected mx1 eligible again after the backoff, got %v", got)
+	}
+	
+	// Repeated failures back off longer, up to the maximum
+	for i := 0; i < 5; i++ {
+		h.failure(ctx, "mx1.example.com", now)
+	}
+	if retryAt := h.hosts["mx1.example.com"].retryAt; !retryAt.Equal(now.Add(4 * time.Minute)) {
+		t.Errorf("Expected backoff capped at 4m, retry at %v", retryAt.Sub(now))
+	}
+	
+	// A success forgets the host
+	h.success("mx1.example.com")
+	if got := hosts(h.order(mx, now)); got[0] != "mx1.example.com" {
+		t.Errorf("Expected mx1 first after it answered, got %v", got)
+	}
+	
+	// Hosts that have not failed for a while are forgotten when full
+	h.forgetStale(now.Add(5 * time.Minute))
+	if len(h.hosts) != 0 {
+		t.Errorf("Expected stale hosts forgotten, got %d", len(h.hosts))
+	}
+	
+	if newMXHealth(&config.DeliveryConfig{MXHostBackoff: -1}) != nil {
+		t.Error("Expected a negative backoff to disable host health")
+	}
+}
+
+// hostSMTPClient times out on the hosts in down and records every host it
+// is asked to send to.
+type hostSMTPClient struct {
+	mu    sync.Mutex
+	down  map[string]bool
+	tried []string
+}
+
+func (c *hostSMTPClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	c.mu.Lock()
+	c.tried = append(c.tried, host)
+	c.mu.Unlock()
+	if c.down[host] {
+		return &net.OpError{Op: "dial", Err: context.DeadlineExceeded}
+	}
+	return nil
+}
+
+func TestDeliveryService_SkipsFailedMXHost(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		MXHostBackoff:     time.Minute,
+		MXHostMaxBackoff:  15 * time.Minute,
+	}
+	q := newMockQueue()
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {
+				{Host: "mx1.example.com", Pref: 10},
+				{Host: "mx2.example.com", Pref: 20},
+			},
+		},
+	}
+	client := &hostSMTPClient{down: map[string]bool{"mx1.example.com": true}}
+	service.client = client
+	
+	for i := 0; i < 3; i++ {
+		q.Enqueue(context.Background(), &email.Email{
+			ID:      "mx-" + string(rune('a'+i)),
+			From:    "sender@example.com",
+			To:      []string{"recipient@example.com"},
+			Subject: "Test",
+			Body:    "Body",
+			Status:  email.StatusQueued,
+		})
+	}
+	emails, _ := q.Dequeue(context.Background(), 3)
+	for _, e := range emails {
+		service.deliver(context.Background(), e)
+	}
+	
+	want := []string{"mx1.example.com", "mx2.example.com", "mx2.example.com", "mx2.example.com"}
+	if len(client.tried) != len(want) {
+		t.Fatalf("Expected hosts %v, got %v", want, client.tried)
+	}
+	for i := range want {
+		if client.tried[i] != want[i] {
+			t.Fatalf("Expected hosts %v, got %v", want, client.tried)
+		}
+	}
+	if len(q.delivered) != 3 {
+		t.Errorf("Expected 3 emails delivered, got %d", len(q.delivered))
+	}
+}
@@ -0,0 +1,360 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// DeferredDomainPaused is the DeferredReason of emails held because
+// their recipient domain is paused.
+const DeferredDomainPaused = "domain_paused"
+
+// pauseRecheck is the longest an email is held for a paused domain before
+// it is looked at again, so that resuming the domain releases its mail
+// within about this long.
+const pauseRecheck = time.Minute
+
+// DomainPause is a recipient domain whose mail is held until it is
+// resumed, or until Until if set.
+type DomainPause struct {
+	Domain   string     `json:"domain"`
+	Reason   string     `json:"reason,omitempty"`
+	PausedAt time.Time  `json:"paused_at"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+// expired reports whether a timed pause has run out at now.
+func (p *DomainPause) expired(now time.Time) bool {
+	return p.Until != nil && !now.Before(*p.Until)
+}
+
+// domainPauses are the paused recipient domains. When opened with a path
+// they are saved after every change, so a restart does not resume them.
+type domainPauses struct {
+	mu     sync.Mutex
+	path   string
+	pauses map[string]*DomainPause
+}
+
+// openDomainPauses loads the pauses saved at path, if any, dropping those
+// that have expired. An empty path keeps pauses in memory only.
+func openDomainPauses(path string) (*domainPauses, error) {
+	p := &domainPauses{
+		path:   path,
+		pauses: make(map[string]*DomainPause),
+	}
+	if path == "" {
+		return p, nil
+	}
+	
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	var saved []*DomainPause
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return p, err
+	}
+	now := time.Now()
+	for _, dp := range saved {
+		if !dp.expired(now) {
+			p.pauses[dp.Domain] = dp
+		}
+	}
+	return p, nil
+}
+
+// newDomainPauses returns the pauses saved to cfg.PausedDomainsFile. A
+// file that can't be read is logged and replaced on the next change.
+func newDomainPauses(cfg *config.DeliveryConfig) *domainPauses {
+	p, err := openDomainPauses(cfg.PausedDomainsFile)
+	if err != nil {
+		log.Printf("ERROR failed to load paused domains from %s: %v", cfg.PausedDomainsFile, err)
+	}
+	return p
+}
+
+// pause holds domain's mail until resume, or until the given time if
+// until is set. Pausing a paused domain replaces its reason and end. An
+// error means the pause could not be saved; it still stands.
+func (p *domainPauses) pause(domain string, until *time.Time, reason string, now time.Time) (DomainPause, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	dp := &DomainPause{Domain: domain, Reason: reason, PausedAt: now, Until: until}
+	if old, ok := p.pauses[domain]; ok && !old.expired(now) {
+		dp.PausedAt = old.PausedAt
+	}
+	p.pauses[domain] = dp
+	return *dp, p.save()
+}
+
+// resume lifts domain's pause and reports whether it was paused.
+func (p *domainPauses) resume(domain string, now time.Time) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	dp, ok := p.pauses[domain]
+	if !ok {
+		return false, nil
+	}
+	delete(p.pauses, domain)
+	if dp.expired(now) {
+		return false, p.save()
+	}
+	return true, p.save()
+}
+
+// paused returns the first of domains that is paused at now and how much
+// longer its pause lasts, zero if it has no end. Pauses found to have
+// expired are removed.
+func (p *domainPauses) paused(ctx context.Context, domains []string, now time.Time) (string, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	for _, domain := range domains {
+		dp, ok := p.pauses[domain]
+		if !ok {
+			continue
+		}
+		if dp.expired(now) {
+			logctx.Printf(ctx, "Pause of %s expired, resuming delivery", domain)
+			delete(p.pauses, domain)
+			if err := p.save(); err != nil {
+				logctx.Printf(ctx, "Failed to save paused domains: %v", err)
+			}
+			continue
+		}
+		if dp.Until == nil {
+			return domain, 0
+		}
+		return domain, dp.Until.Sub(now)
+	}
+	return "", 0
+}
+
+// list returns the domains paused at now, sorted by domain.
+func (p *domainPauses) list(now time.Time) []DomainPause {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	pauses := make([]DomainPause, 0, len(p.pauses))
+	for _, dp := range p.pauses {
+		if !dp.expired(now) {
+			pauses = append(pauses, *dp)
+		}
+	}
+	sort.Slice(pauses, func(i, j int) bool { return pauses[i].Domain < pauses[j].Domain })
+	return pauses
+}
+
+// save writes every pause to the file, replacing it atomically. Callers
+// must hold p.mu.
+func (p *domainPauses) save() error {
+	if p.path == "" {
+		return nil
+	}
+	
+	saved := make([]*DomainPause, 0, len(p.pauses))
+	for _, dp := range p.pauses {
+		saved = append(saved, dp)
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.path, data)
+}
+
+// writeFileAtomic replaces the file at path with data, so a crash leaves
+// either the old contents or the new.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PauseDomain holds all mail to domain, queued now or later, until
+// ResumeDomain is called or, if d is positive, for d. Held emails wait in
+// the queue with DeferredReason set to DeferredDomainPaused, counting
+// neither a retry nor a deferral. An error means the pause could not be
+// saved and would not survive a restart; it is in effect regardless.
+func (s *Service) PauseDomain(domain string, d time.Duration, reason string) (DomainPause, error) {
+	now := time.Now()
+	var until *time.Time
+	if d > 0 {
+		t := now.Add(d)
+		until = &t
+	}
+	return s.pauses.pause(strings.ToLower(domain), until, reason, now)
+}
+
+// ResumeDomain lifts domain's pause and reports whether it was paused.
+// Its held emails are delivered as they come up for their next check,
+// within a minute.
+func (s *Service) ResumeDomain(domain string) (bool, error) {
+	return s.pauses.resume(strings.ToLower(domain), time.Now())
+}
+
+// PausedDomains returns the paused recipient domains, sorted by domain.
+func (s *Service) PausedDomains() []DomainPause {
+	return s.pauses.list(time.Now())
+}
+
+// domainPaused checks e's recipient domains for a pause. If one is paused
+// it puts e back in the queue until the pause ends or for pauseRecheck,
+// whichever is sooner, without an attempt, and returns true.
+func (s *Service) domainPaused(ctx, resultCtx context.Context, e *email.Email) bool {
+	domains, ok := pendingDomains(e)
+	if !ok {
+		return false
+	}
+	domain, wait := s.pauses.paused(ctx, domains, time.Now())
+	if domain == "" {
+		return false
+	}
+	if wait <= 0 || wait > pauseRecheck {
+		wait = pauseRecheck
+	}
+	wait = max(wait, minThrottleDelay)
+	logctx.Printf(ctx, "Delivery to %s paused, holding email for %s", domain, wait.Round(time.Millisecond))
+	s.postponeWithReason(resultCtx, e, DeferredDomainPaused, wait)
+	return true
+}
+
+// DeferredDeliveryPaused is the DeferredReason of emails put back in the
+// queue because all delivery was paused after they were dequeued.
+const DeferredDeliveryPaused = "delivery_paused"
+
+// Pause is the state of the pause of all outbound delivery.
+type Pause struct {
+	Paused   bool       `json:"paused"`
+	Reason   string     `json:"reason,omitempty"`
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+}
+
+// deliveryPause holds all outbound delivery. resumed is closed when the
+// pause is lifted, waking the idle workers.
+type deliveryPause struct {
+	mu      sync.Mutex
+	state   Pause
+	resumed chan struct{}
+}
+
+// newDeliveryPause returns the pause of all delivery, in effect from the
+// start if cfg.Paused is set.
+func newDeliveryPause(cfg *config.DeliveryConfig) *deliveryPause {
+	p := &deliveryPause{resumed: make(chan struct{})}
+	if cfg.Paused {
+		p.pause("paused by configuration", time.Now())
+	}
+	return p
+}
+
+// pause holds all delivery until resume. Pausing again replaces the
+// reason only.
+func (p *deliveryPause) pause(reason string, now time.Time) Pause {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	if !p.state.Paused {
+		p.state = Pause{Paused: true, PausedAt: &now}
+	}
+	p.state.Reason = reason
+	return p.get()
+}
+
+// resume lifts the pause and reports whether delivery was paused.
+func (p *deliveryPause) resume() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	if !p.state.Paused {
+		return false
+	}
+	p.state = Pause{}
+	close(p.resumed)
+	p.resumed = make(chan struct{})
+	return true
+}
+
+// current returns the state of the pause.
+func (p *deliveryPause) current() Pause {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.get()
+}
+
+// get returns a copy of the state. Callers must hold p.mu.
+func (p *deliveryPause) get() Pause {
+	state := p.state
+	if state.PausedAt != nil {
+		at := *state.PausedAt
+		state.PausedAt = &at
+	}
+	return state
+}
+
+// paused reports whether delivery is paused and, if it is, returns a
+// channel closed when it resumes.
+func (p *deliveryPause) paused() (bool, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state.Paused, p.resumed
+}
+
+// Pause stops all outbound delivery until Resume is called. Workers stop
+// dequeuing and the queue keeps accepting mail; attempts already under
+// way finish normally, and emails dequeued but not yet attempted go back
+// to the queue with DeferredReason set to DeferredDeliveryPaused.
+func (s *Service) Pause(reason string) Pause {
+	return s.hold.pause(reason, time.Now())
+}
+
+// Resume restarts delivery paused by Pause or by the paused setting, and
+// reports whether it was paused.
+func (s *Service) Resume() bool {
+	return s.hold.resume()
+}
+
+// PauseState returns whether all delivery is paused, since when and why.
+func (s *Service) PauseState() Pause {
+	return s.hold.current()
+}
+
+// deliveryPaused puts e back in the queue without an attempt and returns
+// true if all delivery is paused.
+func (s *Service) deliveryPaused(ctx, resultCtx context.Context, e *email.Email) bool {
+	if paused, _ := s.hold.paused(); !paused {
+		return false
+	}
+	logctx.Printf(ctx, "Delivery paused, returning email to the queue")
+	s.postponeWithReason(resultCtx, e, DeferredDeliveryPaused, minThrottleDelay)
+	return true
+}
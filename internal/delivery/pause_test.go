@@ -0,0 +1,205 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestDomainPauses(t *testing.T) {
+	ctx := context.Background()
+	// Saved pauses are loaded against the real clock
+	now := time.Now()
+	path := filepath.Join(t.TempDir(), "paused.json")
+	p, err := openDomainPauses(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	until := now.Add(time.Hour)
+	if _, err := p.pause("example.com", nil, "incident", now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.pause("timed.com", &until, "", now); err != nil {
+		t.Fatal(err)
+	}
+	
+	if domain, wait := p.paused(ctx, []string{"other.com", "example.com"}, now); domain != "example.com" || wait != 0 {
+		t.Errorf("Expected example.com paused without an end, got %q, %s", domain, wait)
+	}
+	if domain, wait := p.paused(ctx, []string{"timed.com"}, now.Add(40*time.Minute)); domain != "timed.com" || wait != 20*time.Minute {
+		t.Errorf("Expected timed.com paused 20 more minutes, got %q, %s", domain, wait)
+	}
+	
+	// A restart keeps both pauses
+	reopened, err := openDomainPauses(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.list(now); len(got) != 2 || got[0].Domain != "example.com" || got[0].Reason != "incident" || got[1].Until == nil {
+		t.Fatalf("Expected both pauses after reopening, got %+v", got)
+	}
+	
+	// The timed pause expires by itself
+	if domain, _ := reopened.paused(ctx, []string{"timed.com"}, until); domain != "" {
+		t.Errorf("Expected timed.com resumed at its end, got %q", domain)
+	}
+	if resumed, err := reopened.resume("example.com", now); err != nil || !resumed {
+		t.Fatalf("Expected example.com resumed, got %v, %v", resumed, err)
+	}
+	if resumed, _ := reopened.resume("example.com", now); resumed {
+		t.Error("Expected resuming twice to report nothing paused")
+	}
+	if again, err := openDomainPauses(path); err != nil || len(again.list(now)) != 0 {
+		t.Errorf("Expected no pauses saved, got %+v, %v", again.list(now), err)
+	}
+	
+	// A corrupt file is reported
+	os.WriteFile(path, []byte("{"), 0o600)
+	if _, err := openDomainPauses(path); err == nil {
+		t.Error("Expected an error for a corrupt file")
+	}
+}
+
+func TestDeliveryService_PauseDomain(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	q := queue.NewMemoryQueue(10)
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com", Pref: 10}},
+			"other.com":   {{Host: "mail.other.com", Pref: 10}},
+		},
+	}
+	client := &mockSMTPClient{}
+	service.client = client
+	
+	if _, err := service.PauseDomain("Example.com", 0, "incident"); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []*email.Email{
+		{ID: "paused", From: "sender@test.com", To: []string{"rcpt@example.com"}},
+		{ID: "other", From: "sender@test.com", To: []string{"rcpt@other.com"}},
+	} {
+		if err := q.Enqueue(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	service.poll(ctx, 0, "")
+	
+	e, err := q.Get(ctx, "paused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != email.StatusQueued || e.RetryCount != 0 || e.DeferCount != 0 || e.DeferredReason != DeferredDomainPaused {
+		t.Errorf("Expected the email held without an attempt, got %+v", e)
+	}
+	if e.ScheduledAt == nil || e.ScheduledAt.After(time.Now().Add(pauseRecheck)) {
+		t.Errorf("Expected the email checked again within a minute, scheduled at %v", e.ScheduledAt)
+	}
+	if len(client.sent) != 1 || client.sent[0].ID != "other" {
+		t.Errorf("Expected only the other domain's email sent, got %d", len(client.sent))
+	}
+	
+	// Once resumed the domain's mail is no longer held
+	if resumed, err := service.ResumeDomain("example.com"); err != nil || !resumed {
+		t.Fatalf("Expected example.com resumed, got %v, %v", resumed, err)
+	}
+	if service.domainPaused(ctx, ctx, e) {
+		t.Error("Expected the email not held after resuming")
+	}
+	if got := service.PausedDomains(); len(got) != 0 {
+		t.Errorf("Expected no paused domains, got %+v", got)
+	}
+}
+
+func TestDeliveryService_Pause(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.DeliveryConfig{
+		Workers:           2,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		Paused:            true,
+	}
+	q := queue.NewMemoryQueue(10)
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{"example.com": {{Host: "mail.example.com", Pref: 10}}},
+	}
+	service.client = &mockSMTPClient{}
+	delivered := make(chan string, 10)
+	service.SetResultHook(func(r Result) { delivered <- r.ID })
+	
+	if state := service.PauseState(); !state.Paused || state.PausedAt == nil {
+		t.Fatalf("Expected the service to start paused, got %+v", state)
+	}
+	
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		service.Start(runCtx)
+		close(stopped)
+	}()
+	
+	q.Enqueue(ctx, &email.Email{ID: "held", From: "sender@test.com", To: []string{"rcpt@example.com"}})
+	select {
+	case id := <-delivered:
+		t.Fatalf("Expected nothing delivered while paused, got %s", id)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if q.Size() != 1 {
+		t.Fatalf("Expected the email to wait in the queue, got size %d", q.Size())
+	}
+	
+	// Resuming wakes the workers at once
+	if !service.Resume() {
+		t.Fatal("Expected Resume to report delivery was paused")
+	}
+	select {
+	case id := <-delivered:
+		if id != "held" {
+			t.Errorf("Expected the held email delivered, got %s", id)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Expected delivery soon after resuming")
+	}
+	if service.Resume() {
+		t.Error("Expected resuming twice to report nothing paused")
+	}
+	
+	cancel()
+	<-stopped
+	
+	// An email dequeued before a pause goes back without an attempt
+	service.Pause("blocklisted")
+	e := &email.Email{ID: "dequeued", From: "sender@test.com", To: []string{"rcpt@example.com"}}
+	q.Enqueue(ctx, e)
+	emails, _ := q.Dequeue(ctx, 1)
+	if len(emails) != 1 {
+		t.Fatal("Expected the email dequeued")
+	}
+	service.deliver(ctx, emails[0])
+	got, err := q.Get(ctx, "dequeued")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != email.StatusQueued || got.RetryCount != 0 || got.DeferredReason != DeferredDeliveryPaused {
+		t.Errorf("Expected the email back in the queue without an attempt, got %+v", got)
+	}
+	if state := service.PauseState(); state.Reason != "blocklisted" {
+		t.Errorf("Expected the pause's reason kept, got %+v", state)
+	}
+}
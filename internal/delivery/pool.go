@@ -0,0 +1,250 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+const defaultIdleTimeout = 30 * time.Second
+
+// connPool keeps SMTP sessions open after a transaction so the next email
+// for the same MX host skips the TCP handshake, greeting and STARTTLS. At
+// most size sessions are open at once, checked out or idle; idle sessions
+// are closed after idleTimeout.
+type connPool struct {
+	idleTimeout time.Duration
+	dial        func(ctx context.Context, host string) (*session, error)
+	
+	// One token per open session
+	slots chan struct{}
+	
+	mu     sync.Mutex
+	idle   map[string][]*session
+	closed bool
+}
+
+// session is an SMTP connection to one host, ready for MAIL FROM.
+type session struct {
+	host   string
+	conn   net.Conn
+	client *smtp.Client
+	tls    bool
+	
+	// The TLS policy the session was opened under
+	policy string
+	
+	idleSince time.Time
+	expiry    *time.Timer
+}
+
+func newConnPool(size int, idleTimeout time.Duration, dial func(ctx context.Context, host string) (*session, error)) *connPool {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	return &connPool{
+		idleTimeout: idleTimeout,
+		dial:        dial,
+		slots:       make(chan struct{}, size),
+		idle:        make(map[string][]*session),
+	}
+}
+
+// get checks out a session to host: an idle one opened under ctx's TLS
+// policy that still answers RSET, or else a new one. It waits for a free slot when the pool is full and
+// nothing idle can be closed to make room.
+func (p *connPool) get(ctx context.Context, host string) (*session, error) {
+	for {
+		s := p.takeIdle(host, tlsPolicy(ctx))
+		if s == nil {
+			break
+		}
+		setDeadline(ctx, s.conn)
+		if err := s.client.Reset(); err == nil {
+			return s, nil
+		}
+		// The server dropped the session while it was idle
+		p.discard(s)
+	}
+	
+	if err := p.acquire(ctx); err != nil {
+		return nil, err
+	}
+	s, err := p.dial(ctx, host)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	setDeadline(ctx, s.conn)
+	return s, nil
+}
+
+// put returns a checked-out session. A session that is not reusable, or
+// returned after Close, is closed.
+func (p *connPool) put(s *session, reusable bool) {
+	if !reusable {
+		p.discard(s)
+		return
+	}
+	s.conn.SetDeadline(time.Time{})
+	
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.quit(s)
+		return
+	}
+	s.idleSince = time.Now()
+	s.expiry = time.AfterFunc(p.idleTimeout, func() { p.expire(s) })
+	p.idle[s.host] = append(p.idle[s.host], s)
+	p.mu.Unlock()
+}
+
+// Close quits every idle session. Sessions still checked out are closed
+// when they are returned.
+func (p *connPool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	var idle []*session
+	for host, sessions := range p.idle {
+		idle = append(idle, sessions...)
+		delete(p.idle, host)
+	}
+	p.mu.Unlock()
+	
+	for _, s := range idle {
+		s.expiry.Stop()
+		p.quit(s)
+	}
+	return nil
+}
+
+// takeIdle removes and returns the most recently used idle session to
+// host opened under policy, or nil if there is none. A session held to
+// the host's DANE records serves any policy.
+func (p *connPool) takeIdle(host, policy string) *session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	sessions := p.idle[host]
+	for i := len(sessions) - 1; i >= 0; i-- {
+		s := sessions[i]
+		if s.policy != policy && s.policy != TLSPolicyDANE {
+			continue
+		}
+		p.removeIdle(s)
+		s.expiry.Stop()
+		return s
+	}
+	return nil
+}
+
+// acquire takes a slot for a new session, closing the longest idle
+// session to another host if the pool is full.
+func (p *connPool) acquire(ctx context.Context) error {
+	for {
+		select {
+		case p.slots <- struct{}{}:
+			return nil
+		default:
+		}
+		if s := p.oldestIdle(); s != nil {
+			p.quit(s)
+			continue
+		}
+	
+		select {
+		case p.slots <- struct{}{}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *connPool) release() {
+	<-p.slots
+}
+
+// oldestIdle removes and returns the session that has been idle longest.
+func (p *connPool) oldestIdle() *session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	var oldest *session
+	for _, sessions := range p.idle {
+		if len(sessions) > 0 && (oldest == nil || sessions[0].idleSince.Before(oldest.idleSince)) {
+			oldest = sessions[0]
+		}
+	}
+	if oldest != nil {
+		p.removeIdle(oldest)
+		oldest.expiry.Stop()
+	}
+	return oldest
+}
+
+// expire closes s if it is still idle when its idle timeout fires.
+func (p *connPool) expire(s *session) {
+	p.mu.Lock()
+	found := p.removeIdle(s)
+	p.mu.Unlock()
+	
+	if found {
+		p.quit(s)
+	}
+}
+
+// removeIdle takes s out of the idle list, reporting whether it was there.
+// Callers must hold p.mu.
+func (p *connPool) removeIdle(s *session) bool {
+	sessions := p.idle[s.host]
+	for i, idle := range sessions {
+		if idle == s {
+			p.idle[s.host] = append(sessions[:i], sessions[i+1:]...)
+			if len(p.idle[s.host]) == 0 {
+				delete(p.idle, s.host)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// quit ends a healthy session politely and frees its slot.
+func (p *connPool) quit(s *session) {
+	s.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	s.client.Quit()
+	p.discard(s)
+}
+
+// discard closes s without QUIT and frees its slot.
+func (p *connPool) discard(s *session) {
+	s.client.Close()
+	p.release()
+}
+
+// reusable reports whether a session can carry another transaction after
+// one that ended with err: it succeeded, or the server replied with an
+// error other than 421, which means it is closing the connection.
+func reusable(err error) bool {
+	if err == nil {
+		return true
+	}
+	var rcptErr *RecipientError
+	if errors.As(err, &rcptErr) {
+		return true
+	}
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code != 421
+}
+
+// setDeadline bounds I/O on conn by ctx's deadline, if it has one.
+func setDeadline(ctx context.Context, conn net.Conn) {
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+}
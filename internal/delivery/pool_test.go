@@ -0,0 +1,361 @@
+package delivery
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/identity"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// sinkServer is a minimal SMTP server that accepts every message.
+type sinkServer struct {
+	ln       net.Listener
+	dials    atomic.Int32
+	messages atomic.Int32
+	quits    atomic.Int32
+	mailFrom atomic.Value // string, the last MAIL FROM address
+	helo     atomic.Value // string, the last name given in EHLO or HELO
+	
+	// drop closes each connection after its first message without QUIT
+	drop bool
+	
+	// greeting replaces the 220 greeting; a 4xx one ends the session
+	greeting string
+	
+	// tls, if set, is offered with STARTTLS
+	tls *tls.Config
+	
+	// implicitTLS starts tls as soon as a client connects
+	implicitTLS bool
+	
+	// auth, if set, lists the AUTH mechanisms offered, and MAIL is refused
+	// until the client logs in as user with password, or token for XOAUTH2
+	auth                  []string
+	user, password, token string
+	mechanism             atomic.Value // string, the last one used
+	wg                    sync.WaitGroup
+}
+
+func newSinkServer(t *testing.T, drop bool) *sinkServer {
+	t.Helper()
+	return startSinkServer(t, &sinkServer{drop: drop})
+}
+
+// newBusyServer returns a server that greets every connection with a 421
+// and hangs up.
+func newBusyServer(t *testing.T) *sinkServer {
+	t.Helper()
+	return startSinkServer(t, &sinkServer{greeting: "421 4.3.2 busy, try again later"})
+}
+
+func startSinkServer(t *testing.T, s *sinkServer) *sinkServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ln = ln
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *sinkServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *sinkServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.dials.Add(1)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.session(conn)
+		}()
+	}
+}
+
+func (s *sinkServer) session(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if s.implicitTLS {
+		conn = tls.Server(conn, s.tls)
+	}
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	authed := false
+	
+	if s.greeting != "" {
+		reply(s.greeting)
+		if strings.HasPrefix(s.greeting, "4") {
+			return
+		}
+	} else {
+		reply("220 sink ESMTP")
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			if fields := strings.Fields(line); len(fields) > 1 {
+				s.helo.Store(fields[1])
+			}
+			lines := []string{"sink"}
+			if s.tls != nil && !s.implicitTLS {
+				lines = append(lines, "STARTTLS")
+			}
+			if len(s.auth) > 0 {
+				lines = append(lines, "AUTH "+strings.Join(s.auth, " "))
+			}
+			for i, line := range lines {
+				if i < len(lines)-1 {
+					reply("250-" + line)
+				} else {
+					reply("250 " + line)
+				}
+			}
+		case "AUTH":
+			if authed = s.login(line, r, reply); authed {
+				reply("235 2.7.0 authenticated")
+			} else {
+				reply("535 5.7.8 authentication credentials invalid")
+			}
+		case "MAIL":
+			if len(s.auth) > 0 && !authed {
+				reply("530 5.7.0 authentication required")
+			} else {
+				from, _, _ := strings.Cut(strings.TrimSpace(line)[len("MAIL FROM:"):], " ")
+				s.mailFrom.Store(strings.Trim(from, "<>"))
+				reply("250 ok")
+			}
+		case "STARTTLS":
+			reply("220 go ahead")
+			tlsConn := tls.Server(conn, s.tls)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, r = tlsConn, bufio.NewReader(tlsConn)
+		case "DATA":
+			reply("354 go ahead")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+			}
+			s.messages.Add(1)
+			reply("250 queued")
+			if s.drop {
+				return
+			}
+		case "QUIT":
+			s.quits.Add(1)
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// login reads the rest of an AUTH exchange started by line and reports
+// whether it named the server's user and password or token. The
+// mechanism used is kept in s.mechanism.
+func (s *sinkServer) login(line string, r *bufio.Reader, reply func(string)) bool {
+	read := func() string {
+		line, _ := r.ReadString('\n')
+		decoded, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+		return string(decoded)
+	}
+	args := strings.Fields(line)
+	if len(args) > 1 {
+		s.mechanism.Store(strings.ToUpper(args[1]))
+	}
+	switch {
+	case len(args) == 3 && strings.EqualFold(args[1], "PLAIN"):
+		decoded, _ := base64.StdEncoding.DecodeString(args[2])
+		return string(decoded) == "\x00"+s.user+"\x00"+s.password
+	case len(args) == 2 && strings.EqualFold(args[1], "LOGIN"):
+		reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+		user := read()
+		reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+		return user == s.user && read() == s.password
+	case len(args) == 2 && strings.EqualFold(args[1], "CRAM-MD5"):
+		challenge := "<1896.697170952@sink>"
+		reply("334 " + base64.StdEncoding.EncodeToString([]byte(challenge)))
+		mac := hmac.New(md5.New, []byte(s.password))
+		mac.Write([]byte(challenge))
+		return read() == s.user+" "+hex.EncodeToString(mac.Sum(nil))
+	case len(args) == 3 && strings.EqualFold(args[1], "XOAUTH2"):
+		decoded, _ := base64.StdEncoding.DecodeString(args[2])
+		if string(decoded) == "user="+s.user+"\x01auth=Bearer "+s.token+"\x01\x01" {
+			return true
+		}
+		reply("334 " + base64.StdEncoding.EncodeToString([]byte(`{"status":"401","schemes":"bearer"}`)))
+		read()
+	}
+	return false
+}
+
+func poolTestEmail() *email.Email {
+	return &email.Email{
+		ID:      "pool-test",
+		From:    "sender@test.com",
+		To:      []string{"rcpt@test.com"},
+		Subject: "Pooled",
+		Body:    "Body",
+	}
+}
+
+func TestSMTPClient_PoolReusesSessions(t *testing.T) {
+	ctx := context.Background()
+	pooled := newSinkServer(t, false)
+	unpooled := newSinkServer(t, false)
+	
+	client := NewSMTPClient(5 * time.Second)
+	client.SetPool(10, time.Minute)
+	plain := NewSMTPClient(5 * time.Second)
+	
+	e := poolTestEmail()
+	for i := 0; i < 50; i++ {
+		if err := client.Send(ctx, pooled.addr(), e, e.To); err != nil {
+			t.Fatalf("Pooled send %d failed: %v", i, err)
+		}
+		if err := plain.Send(ctx, unpooled.addr(), e, e.To); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+	
+	if got := pooled.messages.Load(); got != 50 {
+		t.Errorf("Expected 50 pooled messages, got %d", got)
+	}
+	if got := pooled.dials.Load(); got != 1 {
+		t.Errorf("Expected 1 connection for 50 pooled messages, got %d", got)
+	}
+	if got := unpooled.dials.Load(); got != 50 {
+		t.Errorf("Expected 50 connections without a pool, got %d", got)
+	}
+	
+	client.Close()
+	waitFor(t, func() bool { return pooled.quits.Load() == 1 })
+}
+
+func TestSMTPClient_Hello(t *testing.T) {
+	machine, err := os.Hostname()
+	if err != nil || machine == "" {
+		machine = "localhost"
+	}
+	tests := []struct {
+		name     string
+		hostname string
+		want     string
+	}{
+		{name: "configured hostname", hostname: "mx.test.com", want: "mx.test.com"},
+		{name: "machine's hostname", want: machine},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newSinkServer(t, false)
+			client := NewSMTPClient(5 * time.Second)
+			client.SetIdentity(identity.New(tt.hostname))
+			
+			e := poolTestEmail()
+			if err := client.Send(context.Background(), sink.addr(), e, e.To); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			if got, _ := sink.helo.Load().(string); got != tt.want {
+				t.Errorf("Expected EHLO %s, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSMTPClient_PoolReplacesBrokenSessions(t *testing.T) {
+	ctx := context.Background()
+	sink := newSinkServer(t, true)
+	client := NewSMTPClient(5 * time.Second)
+	client.SetPool(10, time.Minute)
+	defer client.Close()
+	
+	e := poolTestEmail()
+	for i := 0; i < 5; i++ {
+		if err := client.Send(ctx, sink.addr(), e, e.To); err != nil {
+			t.Fatalf("Send %d failed after the server dropped the session: %v", i, err)
+		}
+	}
+	if got := sink.messages.Load(); got != 5 {
+		t.Errorf("Expected 5 messages, got %d", got)
+	}
+	if got := sink.dials.Load(); got != 5 {
+		t.Errorf("Expected a new connection per dropped session, got %d", got)
+	}
+}
+
+func TestSMTPClient_PoolLimits(t *testing.T) {
+	ctx := context.Background()
+	first := newSinkServer(t, false)
+	second := newSinkServer(t, false)
+	client := NewSMTPClient(5 * time.Second)
+	client.SetPool(1, 50*time.Millisecond)
+	defer client.Close()
+	
+	e := poolTestEmail()
+	send := func(addr string) {
+		t.Helper()
+		if err := client.Send(ctx, addr, e, e.To); err != nil {
+			t.Fatal(err)
+		}
+	}
+	
+	// A full pool closes an idle session to make room for another host
+	send(first.addr())
+	send(second.addr())
+	waitFor(t, func() bool { return first.quits.Load() == 1 })
+	
+	// Idle sessions are closed after the idle timeout
+	waitFor(t, func() bool { return second.quits.Load() == 1 })
+	send(second.addr())
+	if got := second.dials.Load(); got != 2 {
+		t.Errorf("Expected a new connection after the idle timeout, got %d", got)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
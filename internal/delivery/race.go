@@ -0,0 +1,208 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// ConnSMTPClient is an SMTPClient that can separate connecting from the
+// SMTP transaction, which is required for racing MX connections.
+type ConnSMTPClient interface {
+	SMTPClient
+	Dial(ctx context.Context, host string) (net.Conn, error)
+	SendOnConn(ctx context.Context, conn net.Conn, host string, e *email.Email, rcpts []string) error
+}
+
+// RaceStats reports how MX connection racing has performed.
+type RaceStats struct {
+	Races      int64 `json:"races"`
+	PrimaryWon int64 `json:"primary_won"`
+	RacerWon   int64 `json:"racer_won"`
+	AllFailed  int64 `json:"all_failed"`
+}
+
+type raceCounters struct {
+	races      atomic.Int64
+	primaryWon atomic.Int64
+	racerWon   atomic.Int64
+	allFailed  atomic.Int64
+}
+
+type dialResult struct {
+	index int
+	conn  net.Conn
+	err   error
+}
+
+// RaceStats returns a snapshot of the MX racing counters.
+func (s *Service) RaceStats() RaceStats {
+	return RaceStats{
+		Races:      s.race.races.Load(),
+		PrimaryWon: s.race.primaryWon.Load(),
+		RacerWon:   s.race.racerWon.Load(),
+		AllFailed:  s.race.allFailed.Load(),
+	}
+}
+
+// shouldRace reports whether e should race connections to its MX hosts.
+func (s *Service) shouldRace(e *email.Email, mxRecords []*net.MX) bool {
+	if !e.RaceMX || len(mxRecords) < 2 || s.sandboxed(e) {
+		return false
+	}
+	_, ok := s.client.(ConnSMTPClient)
+	return ok
+}
+
+// raceConnect dials the top MX hosts with a stagger between attempts and
+// returns the first connection to succeed. Losing dials are cancelled and
+// any connection that completes after the winner is closed before an SMTP
+// command is issued, so the message is only ever sent over one connection.
+func (s *Service) raceConnect(ctx context.Context, client ConnSMTPClient, hosts []string) (net.Conn, int, error) {
+	s.race.races.Add(1)
+	
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	
+	results := make(chan dialResult, len(hosts))
+	started := 0
+	start := func(i int) {
+		started++
+		go func() {
+			dialCtx, dialCancel := context.WithTimeout(raceCtx, s.config.ConnectionTimeout)
+			defer dialCancel()
+			conn, err := client.Dial(dialCtx, hosts[i])
+			results <- dialResult{index: i, conn: conn, err: err}
+		}()
+	}
+	
+	start(0)
+	stagger := time.NewTimer(s.config.MXRaceStagger)
+	defer stagger.Stop()
+	
+	var lastErr error
+	finished := 0
+	for finished < len(hosts) {
+		select {
+		case <-ctx.Done():
+			drainDials(results, started-finished)
+			return nil, -1, ctx.Err()
+			
+		case <-stagger.C:
+			if started < len(hosts) {
+				start(started)
+				stagger.Reset(s.config.MXRaceStagger)
+			}
+			
+		case r := <-results:
+			finished++
+			if r.err != nil {
+				lastErr = r.err
+				s.failures.failure(ctx, hosts[r.index], r.err)
+				s.recordHost(ctx, hosts[r.index], r.err)
+				// Don't wait out the stagger when an attempt fails outright
+				if started < len(hosts) && started == finished {
+					start(started)
+					stagger.Reset(s.config.MXRaceStagger)
+				}
+				continue
+			}
+			
+			cancel()
+			drainDials(results, started-finished)
+			if r.index == 0 {
+				s.race.primaryWon.Add(1)
+			} else {
+				s.race.racerWon.Add(1)
+			}
+			return r.conn, r.index, nil
+		}
+	}
+	
+	s.race.allFailed.Add(1)
+	if lastErr == nil {
+		lastErr = errors.New("no hosts to race")
+	}
+	return nil, -1, fmt.Errorf("all raced MX hosts failed: %w", lastErr)
+}
+
+// drainDials closes connections from dials still in flight after a race
+// has been decided.
+func drainDials(results <-chan dialResult, pending int) {
+	if pending <= 0 {
+		return
+	}
+	go func() {
+		for i := 0; i < pending; i++ {
+			if r := <-results; r.conn != nil {
+				r.conn.Close()
+			}
+		}
+	}()
+}
+
+// deliverRaced races connections to the top MX hosts and sends over the
+// winner, falling back to the remaining hosts sequentially on failure.
+func (s *Service) deliverRaced(ctx context.Context, e *email.Email, rcpts []string, mxRecords []*net.MX) error {
+	client := s.client.(ConnSMTPClient)
+	
+	extra := s.config.MXRaceMaxExtra
+	if extra < 1 {
+		extra = 1
+	}
+	raced := 1 + extra
+	if raced > len(mxRecords) {
+		raced = len(mxRecords)
+	}
+	hosts := make([]string, raced)
+	for i := range hosts {
+		hosts[i] = mxRecords[i].Host
+	}
+	
+	conn, winner, err := s.raceConnect(ctx, client, hosts)
+	if err == nil {
+		err = s.transact(ctx, hosts[winner], rcpts, func(ctx context.Context) error {
+			return client.SendOnConn(ctx, conn, hosts[winner], e, rcpts)
+		})
+		conn.Close()
+		s.recordHost(ctx, hosts[winner], err)
+		if hostAnswered(err) {
+			logDelivered(ctx, hosts[winner], rcpts, err, " (raced)")
+			s.failures.recovered(ctx, hosts[winner])
+			return err
+		}
+		s.failures.failure(ctx, hosts[winner], err)
+	}
+	
+	// Fall back to the hosts that weren't part of the race. A raced host
+	// that connected but refused in its greeting still counts as busy.
+	lastErr := err
+	busy := isGreetingDeferral(err)
+	for _, mx := range mxRecords[raced:] {
+		err := s.transact(ctx, mx.Host, rcpts, func(ctx context.Context) error {
+			return s.client.Send(ctx, mx.Host, e, rcpts)
+		})
+		s.recordHost(ctx, mx.Host, err)
+		
+		if hostAnswered(err) {
+			logDelivered(ctx, mx.Host, rcpts, err, "")
+			s.failures.recovered(ctx, mx.Host)
+			return err
+		}
+		
+		lastErr = err
+		busy = busy && isGreetingDeferral(err)
+		s.failures.failure(ctx, mx.Host, err)
+		
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	
+	return s.allMXFailed(lastErr, busy)
+}
@@ -0,0 +1,212 @@
+package delivery
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// maxIdleBuckets is how many domains' buckets are kept before those that
+// have refilled completely are forgotten.
+const maxIdleBuckets = 10000
+
+// minThrottleDelay is the shortest an email over its domain's limit is put
+// back for, so a busy domain is not polled in a tight loop.
+const minThrottleDelay = time.Second
+
+// DomainThrottle is the rate limit state of one recipient domain.
+type DomainThrottle struct {
+	Domain    string  `json:"domain"`
+	Limit     string  `json:"limit"`
+	Available float64 `json:"available"`
+	
+	// Limited is true while the domain has no room for another email
+	Limited       bool       `json:"limited"`
+	Throttled     int64      `json:"throttled"`
+	LastThrottled *time.Time `json:"last_throttled,omitempty"`
+}
+
+// domainLimiter keeps a token bucket per recipient domain, holding up to a
+// period's worth of emails and refilling at the domain's rate.
+type domainLimiter struct {
+	defaultRate *config.Rate
+	rates       map[string]config.Rate
+	
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	rate          config.Rate
+	tokens        float64
+	updated       time.Time
+	throttled     int64
+	lastThrottled time.Time
+}
+
+// newDomainLimiter returns the limiter configured by cfg, or nil if no
+// domain is limited. Rates that do not parse are ignored; Validate reports
+// them.
+func newDomainLimiter(cfg *config.DeliveryConfig) *domainLimiter {
+	l := &domainLimiter{
+		rates:   make(map[string]config.Rate),
+		buckets: make(map[string]*bucket),
+	}
+	if rate, err := config.ParseRate(cfg.DomainRateLimit); err == nil {
+		l.defaultRate = &rate
+	}
+	for domain, limit := range cfg.DomainLimits {
+		if rate, err := config.ParseRate(limit); err == nil {
+			l.rates[strings.ToLower(domain)] = rate
+		}
+	}
+	if l.defaultRate == nil && len(l.rates) == 0 {
+		return nil
+	}
+	return l
+}
+
+// take spends one send for each of domains if all of them have room. If
+// one does not, nothing is spent and take returns that domain and how long
+// until it has room.
+func (l *domainLimiter) take(domains []string, now time.Time) (string, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	var limited []*bucket
+	for _, domain := range domains {
+		b := l.bucket(domain, now)
+		if b == nil {
+			continue
+		}
+		if b.tokens < 1 {
+			b.throttled++
+			b.lastThrottled = now
+			wait := time.Duration((1 - b.tokens) / b.rate.PerSecond() * float64(time.Second))
+			return domain, wait
+		}
+		limited = append(limited, b)
+	}
+	for _, b := range limited {
+		b.tokens--
+	}
+	return "", 0
+}
+
+// rate returns domain's rate limit, if it has one.
+func (l *domainLimiter) rate(domain string) (config.Rate, bool) {
+	if rate, ok := l.rates[domain]; ok {
+		return rate, true
+	}
+	if l.defaultRate != nil {
+		return *l.defaultRate, true
+	}
+	return config.Rate{}, false
+}
+
+// bucket returns domain's bucket brought up to now, or nil if the domain
+// is not limited. Callers must hold l.mu.
+func (l *domainLimiter) bucket(domain string, now time.Time) *bucket {
+	b, ok := l.buckets[domain]
+	if !ok {
+		rate, ok := l.rate(domain)
+		if !ok {
+			return nil
+		}
+		if len(l.buckets) >= maxIdleBuckets {
+			l.forgetFull(now)
+		}
+		b = &bucket{rate: rate, tokens: float64(rate.Count), updated: now}
+		l.buckets[domain] = b
+		return b
+	}
+	
+	b.tokens += now.Sub(b.updated).Seconds() * b.rate.PerSecond()
+	if capacity := float64(b.rate.Count); b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.updated = now
+	return b
+}
+
+// forgetFull drops the buckets that have refilled completely, which a new
+// bucket would be the same as. Callers must hold l.mu.
+func (l *domainLimiter) forgetFull(now time.Time) {
+	for domain, b := range l.buckets {
+		full := b.tokens + now.Sub(b.updated).Seconds()*b.rate.PerSecond()
+		if full >= float64(b.rate.Count) {
+			delete(l.buckets, domain)
+		}
+	}
+}
+
+func (l *domainLimiter) stats(now time.Time) []DomainThrottle {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	stats := make([]DomainThrottle, 0, len(l.buckets))
+	for domain := range l.buckets {
+		b := l.bucket(domain, now)
+		t := DomainThrottle{
+			Domain:    domain,
+			Limit:     b.rate.String(),
+			Available: b.tokens,
+			Limited:   b.tokens < 1,
+			Throttled: b.throttled,
+		}
+		if !b.lastThrottled.IsZero() {
+			last := b.lastThrottled
+			t.LastThrottled = &last
+		}
+		stats = append(stats, t)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Domain < stats[j].Domain })
+	return stats
+}
+
+// ThrottleStats reports the rate limit state of each recipient domain
+// sent to recently, sorted by domain. It is empty when no domain is
+// limited.
+func (s *Service) ThrottleStats() []DomainThrottle {
+	if s.limiter == nil {
+		return nil
+	}
+	return s.limiter.stats(time.Now())
+}
+
+// DomainRate returns the send rate limit applying to domain, if any.
+func (s *Service) DomainRate(domain string) (config.Rate, bool) {
+	if s.limiter == nil {
+		return config.Rate{}, false
+	}
+	return s.limiter.rate(strings.ToLower(domain))
+}
+
+// throttle checks e against its recipient domains' rate limits, spending
+// one send from each if they all have room. Otherwise it puts e back in
+// the queue until the domain over its limit has room, and returns true.
+func (s *Service) throttle(ctx, resultCtx context.Context, e *email.Email) bool {
+	if s.limiter == nil {
+		return false
+	}
+	domains, ok := pendingDomains(e)
+	if !ok {
+		return false
+	}
+	domain, wait := s.limiter.take(domains, time.Now())
+	if domain == "" {
+		return false
+	}
+	if wait < minThrottleDelay {
+		wait = minThrottleDelay
+	}
+	logctx.Printf(ctx, "Rate limit for %s reached, holding email for %s", domain, wait.Round(time.Millisecond))
+	s.postponeEmail(resultCtx, e, "rate limit for "+domain+" reached", wait)
+	return true
+}
@@ -0,0 +1,106 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestDomainLimiter(t *testing.T) {
+	l := newDomainLimiter(&config.DeliveryConfig{
+		DomainRateLimit: "60/m",
+		DomainLimits:    map[string]string{"Gmail.com": "2/m"},
+	})
+	now := time.Now()
+	
+	for i := 0; i < 2; i++ {
+		if domain, _ := l.take([]string{"gmail.com"}, now); domain != "" {
+			t.Fatalf("Expected send %d to gmail.com to be allowed", i)
+		}
+	}
+	domain, wait := l.take([]string{"gmail.com"}, now)
+	if domain != "gmail.com" || wait != 30*time.Second {
+		t.Fatalf("Expected gmail.com throttled for 30s, got %q for %s", domain, wait)
+	}
+	
+	// A multi-domain email spends nothing while one domain is full
+	if domain, _ := l.take([]string{"other.com", "gmail.com"}, now); domain != "gmail.com" {
+		t.Fatalf("Expected gmail.com to hold back a multi-domain email, got %q", domain)
+	}
+	
+	if domain, _ := l.take([]string{"gmail.com"}, now.Add(30*time.Second)); domain != "" {
+		t.Fatal("Expected gmail.com to have room after refilling")
+	}
+	
+	stats := l.stats(now.Add(30 * time.Second))
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 domains in stats, got %+v", stats)
+	}
+	gmail, other := stats[0], stats[1]
+	if gmail.Domain != "gmail.com" || gmail.Limit != "2/m" || !gmail.Limited || gmail.Throttled != 2 || gmail.LastThrottled == nil {
+		t.Errorf("Unexpected gmail.com state %+v", gmail)
+	}
+	if other.Domain != "other.com" || other.Limit != "60/m" || other.Available != 60 || other.Throttled != 0 {
+		t.Errorf("Unexpected other.com state %+v", other)
+	}
+	
+	if newDomainLimiter(&config.DeliveryConfig{}) != nil {
+		t.Error("Expected no limiter without limits")
+	}
+}
+
+func TestDeliveryService_DomainRateLimit(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		DomainLimits:      map[string]string{"example.com": "2/m"},
+	}
+	q := queue.NewMemoryQueue(10)
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com", Pref: 10}},
+		},
+	}
+	client := &mockSMTPClient{}
+	service.client = client
+	
+	for i := 0; i < 5; i++ {
+		q.Enqueue(ctx, &email.Email{
+			ID:     fmt.Sprintf("limited-%d", i),
+			From:   "sender@test.com",
+			To:     []string{"recipient@example.com"},
+			Status: email.StatusQueued,
+		})
+	}
+	service.poll(ctx, 0, "")
+	
+	if len(client.sent) != 2 {
+		t.Fatalf("Expected 2 emails sent within the limit, got %d", len(client.sent))
+	}
+	for i := 2; i < 5; i++ {
+		e, err := q.Get(ctx, fmt.Sprintf("limited-%d", i))
+		if err != nil {
+			t.Fatalf("Expected throttled email to stay queued: %v", err)
+		}
+		if e.Status != email.StatusQueued || e.RetryCount != 0 || e.DeferCount != 0 || e.LastError != "" {
+			t.Errorf("Expected throttled email queued without a failure, got %+v", e)
+		}
+		if e.ScheduledAt == nil || !e.ScheduledAt.After(time.Now().Add(20*time.Second)) {
+			t.Errorf("Expected throttled email held until the domain has room, scheduled at %v", e.ScheduledAt)
+		}
+	}
+	
+	stats := service.ThrottleStats()
+	if len(stats) != 1 || stats[0].Throttled != 3 || !stats[0].Limited {
+		t.Errorf("Expected example.com throttled 3 times, got %+v", stats)
+	}
+}
@@ -0,0 +1,146 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// rejectedError means every outstanding recipient was refused for good,
+// so the email is failed without further retries.
+type rejectedError struct {
+	err error
+}
+
+func (r *rejectedError) Error() string { return r.err.Error() }
+func (r *rejectedError) Unwrap() error { return r.err }
+
+func isRejected(err error) bool {
+	var r *rejectedError
+	return errors.As(err, &r)
+}
+
+// hostAnswered reports whether a send to an MX host settled the outcome:
+// the transaction finished, successfully or with some recipients refused,
+// or the host refused it for good with a 5xx reply or because of the
+// sending IP's reputation. Other MX hosts must not be tried then, or
+// accepted recipients would get the message twice and refused ones would
+// be asked again.
+func hostAnswered(err error) bool {
+	var rcptErr *RecipientError
+	return err == nil || errors.As(err, &rcptErr) || smtpCode(err) >= 500 || isReputationBlock(err)
+}
+
+// logDelivered logs a transaction to rcpts that host answered.
+func logDelivered(ctx context.Context, host string, rcpts []string, err error, note string) {
+	var rcptErr *RecipientError
+	switch {
+	case err == nil:
+		logctx.Printf(ctx, "Email delivered to %s%s", host, note)
+	case !errors.As(err, &rcptErr):
+		logctx.Printf(ctx, "Email refused by %s%s: %v", host, note, err)
+	case len(rcptErr.Rejected) == len(rcpts):
+		logctx.Printf(ctx, "All recipients refused by %s%s", host, note)
+	default:
+		logctx.Printf(ctx, "Email delivered to %s%s, %d of %d recipients refused", host, note, len(rcptErr.Rejected), len(rcpts))
+	}
+}
+
+// recordOutcome stores the outcome of a transaction to rcpts in results.
+// Recipients refused with a 5xx reply, or whose domain does not exist,
+// have failed for good; any other failure, including every recipient
+// being refused because of the sending IP's reputation, is retried. It returns the
+// errors for the recipients to retry and for those refused for good,
+// either of which may be nil.
+func recordOutcome(results map[string]email.RecipientStatus, rcpts []string, err error) (temporary, refused error) {
+	now := time.Now()
+	
+	var rcptErr *RecipientError
+	if err != nil && (isReputationBlock(err) || !errors.As(err, &rcptErr)) {
+		status := email.StatusQueued
+		if permanent(err) {
+			status = email.StatusFailed
+		}
+		for _, rcpt := range rcpts {
+			results[rcpt] = email.RecipientStatus{Status: status, LastError: err.Error()}
+		}
+		if status == email.StatusFailed {
+			return nil, err
+		}
+		return err, nil
+	}
+	
+	retry := make(map[string]error)
+	refusedRcpts := make(map[string]error)
+	for _, rcpt := range rcpts {
+		var rejection error
+		if rcptErr != nil {
+			rejection = rcptErr.Rejected[rcpt]
+		}
+		
+		switch {
+		case rejection == nil:
+			results[rcpt] = email.RecipientStatus{Status: email.StatusDelivered, DeliveredAt: &now}
+		case permanent(rejection):
+			results[rcpt] = email.RecipientStatus{Status: email.StatusFailed, LastError: rejection.Error()}
+			refusedRcpts[rcpt] = rejection
+		default:
+			results[rcpt] = email.RecipientStatus{Status: email.StatusQueued, LastError: rejection.Error()}
+			retry[rcpt] = rejection
+		}
+	}
+	
+	if len(retry) > 0 {
+		temporary = &RecipientError{Rejected: retry}
+	}
+	if len(refusedRcpts) > 0 {
+		refused = &RecipientError{Rejected: refusedRcpts}
+	}
+	return temporary, refused
+}
+
+// anyDelivered reports whether any recipient of e has been delivered to,
+// on this attempt or an earlier one.
+func anyDelivered(e *email.Email, results map[string]email.RecipientStatus) bool {
+	for _, status := range results {
+		if status.Status == email.StatusDelivered {
+			return true
+		}
+	}
+	for _, status := range e.RecipientStatus {
+		if status.Status == email.StatusDelivered {
+			return true
+		}
+	}
+	return false
+}
+
+// failOutstanding marks the recipients still waiting as failed, for an
+// email that will not be retried.
+func failOutstanding(results map[string]email.RecipientStatus) {
+	for rcpt, status := range results {
+		if status.Status == email.StatusQueued {
+			status.Status = email.StatusFailed
+			results[rcpt] = status
+		}
+	}
+}
+
+// updateRecipients records the per-recipient outcome of an attempt in the
+// queue. Queues that cannot track this resend to every recipient on retry.
+func (s *Service) updateRecipients(ctx context.Context, e *email.Email, results map[string]email.RecipientStatus) {
+	if len(results) == 0 {
+		return
+	}
+	tracker, ok := s.queue.(queue.RecipientTracker)
+	if !ok {
+		return
+	}
+	if err := tracker.UpdateRecipients(ctx, e.ID, results); err != nil {
+		logctx.Printf(ctx, "Failed to record recipient statuses: %v", err)
+	}
+}
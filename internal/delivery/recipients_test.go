@@ -0,0 +1,156 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"reflect"
+	"testing"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestDeliveryService_RecipientStatus(t *testing.T) {
+	unknown := &textproto.Error{Code: 550, Msg: "5.1.1 user unknown"}
+	busy := &textproto.Error{Code: 450, Msg: "4.2.1 mailbox busy"}
+	
+	tests := []struct {
+		name       string
+		refuse     map[string]error
+		wantStatus map[string]email.Status
+		wantQueued bool
+		wantRetry  bool
+	}{
+		{
+			name:   "one refused for good",
+			refuse: map[string]error{"b@one.test": unknown},
+			wantStatus: map[string]email.Status{
+				"a@one.test": email.StatusDelivered,
+				"b@one.test": email.StatusFailed,
+				"c@one.test": email.StatusDelivered,
+			},
+		},
+		{
+			name:   "one refused for now",
+			refuse: map[string]error{"b@one.test": busy},
+			wantStatus: map[string]email.Status{
+				"a@one.test": email.StatusDelivered,
+				"b@one.test": email.StatusQueued,
+				"c@one.test": email.StatusDelivered,
+			},
+			wantQueued: true,
+			wantRetry:  true,
+		},
+		{
+			name:   "all refused for good",
+			refuse: map[string]error{"a@one.test": unknown, "b@one.test": unknown, "c@one.test": unknown},
+			wantStatus: map[string]email.Status{
+				"a@one.test": email.StatusFailed,
+				"b@one.test": email.StatusFailed,
+				"c@one.test": email.StatusFailed,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			q := queue.NewMemoryQueue(10)
+			service := newDomainTestService(q, &envelopeClient{refuse: tt.refuse})
+			service.maxRetry = 3
+			
+			var updates map[string]email.RecipientStatus
+			tracker := &recordingTracker{MemoryQueue: q, updates: &updates}
+			service.queue = tracker
+			
+			q.Enqueue(ctx, &email.Email{
+				ID:     "test-1",
+				From:   "sender@test.com",
+				To:     []string{"a@one.test", "b@one.test", "c@one.test"},
+				Status: email.StatusQueued,
+			})
+			emails, _ := q.Dequeue(ctx, 1)
+			service.deliver(ctx, emails[0])
+			
+			got := make(map[string]email.Status)
+			for rcpt, status := range updates {
+				got[rcpt] = status.Status
+			}
+			if !reflect.DeepEqual(got, tt.wantStatus) {
+				t.Errorf("Expected recipient statuses %v, got %v", tt.wantStatus, got)
+			}
+			
+			e, err := q.Get(ctx, "test-1")
+			if queued := err == nil; queued != tt.wantQueued {
+				t.Fatalf("Expected still queued %v, got %v", tt.wantQueued, err)
+			}
+			if tt.wantRetry && e.RetryCount != 1 {
+				t.Errorf("Expected a retry to be scheduled, got retry count %d", e.RetryCount)
+			}
+		})
+	}
+}
+
+func TestDeliveryService_RetriesOnlyRefusedRecipients(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	client := &envelopeClient{
+		refuse: map[string]error{"b@one.test": &textproto.Error{Code: 450, Msg: "4.2.1 mailbox busy"}},
+	}
+	service := newDomainTestService(q, client)
+	service.maxRetry = 3
+	
+	q.Enqueue(ctx, &email.Email{
+		ID:     "test-1",
+		From:   "sender@test.com",
+		To:     []string{"a@one.test", "b@one.test"},
+		Status: email.StatusQueued,
+	})
+	emails, _ := q.Dequeue(ctx, 1)
+	service.deliver(ctx, emails[0])
+	
+	e, err := q.Get(ctx, "test-1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	
+	client.refuse = nil
+	client.sent = nil
+	if _, err := service.processEmail(ctx, e); err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+	want := []sentEnvelope{{host: "mx.one.test", rcpts: []string{"b@one.test"}}}
+	if !reflect.DeepEqual(client.sent, want) {
+		t.Errorf("Expected envelopes %v, got %v", want, client.sent)
+	}
+}
+
+func TestRecipientError(t *testing.T) {
+	unknown := &textproto.Error{Code: 550, Msg: "5.1.1 user unknown"}
+	err := &RecipientError{Rejected: map[string]error{
+		"b@example.com": unknown,
+		"a@example.com": &textproto.Error{Code: 450, Msg: "4.2.1 mailbox busy"},
+	}}
+	
+	want := `recipient a@example.com refused: 450 "4.2.1 mailbox busy"; recipient b@example.com refused: 550 "5.1.1 user unknown"`
+	if err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+	if !errors.Is(err, unknown) {
+		t.Error("Expected the refusals to be unwrappable")
+	}
+	if !hostAnswered(err) || hostAnswered(errors.New("connection refused")) {
+		t.Error("Expected only refused recipients to count as an answer from the host")
+	}
+}
+
+// recordingTracker captures recipient updates before passing them on.
+type recordingTracker struct {
+	*queue.MemoryQueue
+	updates *map[string]email.RecipientStatus
+}
+
+func (r *recordingTracker) UpdateRecipients(ctx context.Context, id string, statuses map[string]email.RecipientStatus) error {
+	*r.updates = statuses
+	return r.MemoryQueue.UpdateRecipients(ctx, id, statuses)
+}
@@ -0,0 +1,96 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// workerRestartDelay is how long a worker that died of a panic waits
+// before it is started again, so a panic on every poll doesn't spin.
+var workerRestartDelay = time.Second
+
+// recoverDelivery, deferred by deliver, stops a panic while delivering e
+// from killing the worker: it logs the stack and fails the attempt, so e
+// is retried like any other failure instead of being left sending.
+func (s *Service) recoverDelivery(ctx context.Context, e *email.Email, a *attempt) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	logctx.Printf(ctx, "PANIC delivering email: %v\n%s", r, debug.Stack())
+	
+	err := fmt.Errorf("delivery panicked: %v", r)
+	retry := s.retries(e)
+	if err := s.markFailed(ctx, e, err, retry); err != nil {
+		logctx.Printf(ctx, "Failed to mark email as failed: %v", err)
+		return
+	}
+	status := email.StatusFailed
+	if retry {
+		status = email.StatusQueued
+	}
+	s.report(e, a, status, err, nil)
+}
+
+// releaseOnPanic, deferred by poll, puts the emails of a batch that a
+// panic outside deliver interrupted back in the queue for a retry if they
+// are still being sent, then lets the panic take the worker down to be
+// restarted. Queues that can't look emails up keep them as they are.
+func (s *Service) releaseOnPanic(ctx context.Context, emails []*email.Email) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	defer panic(r)
+	
+	getter, ok := s.queue.(queue.Getter)
+	if !ok {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	err := fmt.Errorf("delivery worker panicked: %v", r)
+	for _, e := range emails {
+		current, getErr := getter.Get(ctx, e.ID)
+		if getErr != nil || current.Status != email.StatusSending {
+			continue
+		}
+		if markErr := s.markFailed(ctx, current, err, s.retries(current)); markErr != nil {
+			logctx.Printf(logctx.With(ctx, "email_id", e.ID), "Failed to release email: %v", markErr)
+		}
+	}
+}
+
+// supervise runs worker id until ctx is done or stop is closed, starting
+// it again whenever it dies of a panic.
+func (s *Service) supervise(ctx context.Context, id int, lane email.Lane, stop <-chan struct{}) {
+	defer s.wg.Done()
+	for s.runWorker(ctx, id, lane, stop) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-time.After(workerRestartDelay):
+		}
+		log.Printf("Restarting delivery worker %d", id)
+	}
+}
+
+// runWorker runs worker id and reports whether it died of a panic.
+func (s *Service) runWorker(ctx context.Context, id int, lane email.Lane, stop <-chan struct{}) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in delivery worker %d: %v\n%s", id, r, debug.Stack())
+			panicked = true
+		}
+	}()
+	s.worker(ctx, id, lane, stop)
+	return false
+}
@@ -0,0 +1,123 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// panickingSMTPClient panics sending the email with ID panicID and
+// delivers the rest.
+type panickingSMTPClient struct {
+	mockSMTPClient
+	panicID string
+}
+
+func (p *panickingSMTPClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	if e.ID == p.panicID {
+		panic("client exploded")
+	}
+	return p.mockSMTPClient.Send(ctx, host, e, rcpts)
+}
+
+// panickingQueue panics on the first dequeue.
+type panickingQueue struct {
+	*queue.MemoryQueue
+	panicked atomic.Bool
+}
+
+func (p *panickingQueue) DequeueLane(ctx context.Context, lane email.Lane, count int) ([]*email.Email, error) {
+	if p.panicked.CompareAndSwap(false, true) {
+		panic("queue exploded")
+	}
+	return p.MemoryQueue.DequeueLane(ctx, lane, count)
+}
+
+func recoverTestService(q queue.Queue, client SMTPClient) *Service {
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+	}, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{"example.com": {{Host: "mail.example.com", Pref: 10}}},
+	}
+	service.client = client
+	return service
+}
+
+// waitSent waits for client to send the email with id.
+func waitSent(t *testing.T, client *mockSMTPClient, id string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		client.mu.Lock()
+		for _, e := range client.sent {
+			if e.ID == id {
+				client.mu.Unlock()
+				return
+			}
+		}
+		client.mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("Expected %s sent", id)
+}
+
+func TestDeliveryService_RecoversClientPanic(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	client := &panickingSMTPClient{panicID: "boom"}
+	service := recoverTestService(q, client)
+	
+	if err := q.Enqueue(ctx, &email.Email{ID: "boom", From: "sender@test.com", To: []string{"rcpt@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	go service.Start(ctx)
+	defer service.Stop(context.Background())
+	
+	// Once the panic is handled the worker goes on to the next email
+	time.Sleep(100 * time.Millisecond)
+	if err := q.Enqueue(ctx, &email.Email{ID: "next", From: "sender@test.com", To: []string{"rcpt@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, &client.mockSMTPClient, "next")
+	
+	e, err := q.Get(ctx, "boom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != email.StatusQueued || e.RetryCount != 1 {
+		t.Errorf("Expected the email queued for a retry, got %s after %d attempts", e.Status, e.RetryCount)
+	}
+	if e.LastError != "delivery panicked: client exploded" {
+		t.Errorf("Expected the panic recorded, got %q", e.LastError)
+	}
+}
+
+func TestDeliveryService_RestartsDeadWorker(t *testing.T) {
+	defer func(d time.Duration) { workerRestartDelay = d }(workerRestartDelay)
+	workerRestartDelay = 10 * time.Millisecond
+	
+	ctx := context.Background()
+	q := &panickingQueue{MemoryQueue: queue.NewMemoryQueue(10)}
+	client := &mockSMTPClient{}
+	service := recoverTestService(q, client)
+	
+	if err := q.Enqueue(ctx, &email.Email{ID: "test-1", From: "sender@test.com", To: []string{"rcpt@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	go service.Start(ctx)
+	defer service.Stop(context.Background())
+	
+	waitSent(t, client, "test-1")
+	if !q.panicked.Load() {
+		t.Error("Expected the first dequeue to panic")
+	}
+}
@@ -0,0 +1,81 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"strings"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// redirector sends the mail of recipients outside the allowed domains to
+// one catch-all address instead, so a pre-production server cannot reach
+// real customers.
+type redirector struct {
+	to      string
+	domain  string
+	allowed map[string]bool
+}
+
+// newRedirector returns the redirector cfg configures, or nil if mail is
+// delivered to its recipients. Redirection needs confirming as well as an
+// address, which config.Validate insists on.
+func newRedirector(cfg *config.DeliveryConfig) *redirector {
+	if cfg.RedirectAllTo == "" || !cfg.RedirectConfirm {
+		return nil
+	}
+	r := &redirector{
+		to:      cfg.RedirectAllTo,
+		domain:  strings.ToLower(extractDomain(cfg.RedirectAllTo)),
+		allowed: make(map[string]bool),
+	}
+	for _, domain := range cfg.RedirectAllowDomains {
+		r.allowed[strings.ToLower(domain)] = true
+	}
+	return r
+}
+
+// apply merges the groups outside the allowed domains into one for the
+// catch-all address, after the others, and returns the recipients it
+// redirected.
+func (r *redirector) apply(groups []recipientGroup) ([]recipientGroup, []string) {
+	if r == nil {
+		return groups, nil
+	}
+	kept := groups[:0:0]
+	var redirected []string
+	for _, g := range groups {
+		if r.allowed[g.domain] {
+			kept = append(kept, g)
+			continue
+		}
+		redirected = append(redirected, g.rcpts...)
+	}
+	if len(redirected) == 0 {
+		return kept, nil
+	}
+	return append(kept, recipientGroup{domain: r.domain, rcpts: redirected, redirectTo: r.to}), redirected
+}
+
+// deliverRedirected sends e to g's catch-all address in place of its
+// recipients, naming them in an X-Original-To header. A refusal of the
+// catch-all address is a refusal of each of them.
+func (s *Service) deliverRedirected(ctx context.Context, e *email.Email, g recipientGroup) error {
+	logctx.Printf(ctx, "Redirecting %d recipients to %s", len(g.rcpts), g.redirectTo)
+	c := *e
+	c.OriginalTo = g.rcpts
+	err := s.deliverDomain(ctx, &c, g.domain, []string{g.redirectTo})
+	
+	var rcptErr *RecipientError
+	if !errors.As(err, &rcptErr) {
+		return err
+	}
+	rejection := rcptErr.Rejected[g.redirectTo]
+	rejected := make(map[string]error, len(g.rcpts))
+	for _, rcpt := range g.rcpts {
+		rejected[rcpt] = rejection
+	}
+	return &RecipientError{Rejected: rejected}
+}
@@ -0,0 +1,108 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// messageClient records the message sent to each host.
+type messageClient struct {
+	envelopeClient
+	messages map[string]string
+}
+
+func (c *messageClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, e, nil); err != nil {
+		return err
+	}
+	c.messages[host] = buf.String()
+	return c.envelopeClient.Send(ctx, host, e, rcpts)
+}
+
+func TestDeliveryService_Redirect(t *testing.T) {
+	ctx := context.Background()
+	newEmail := func() *email.Email {
+		return &email.Email{
+			ID:      "redirect-1",
+			From:    "sender@test.com",
+			To:      []string{"a@one.test", "customer@elsewhere.test"},
+			CC:      []string{"other@somewhere.test"},
+			Subject: "Hi",
+			Body:    "Body",
+			Status:  email.StatusQueued,
+		}
+	}
+	deliver := func(service *Service) {
+		service.queue.Enqueue(ctx, newEmail())
+		emails, _ := service.queue.Dequeue(ctx, 1)
+		service.deliver(ctx, emails[0])
+	}
+	newService := func(client SMTPClient) (*Service, *Result) {
+		service := newDomainTestService(queue.NewMemoryQueue(10), client)
+		service.redirect = newRedirector(&config.DeliveryConfig{
+			RedirectAllTo:        "qa@two.test",
+			RedirectAllowDomains: []string{"ONE.test"},
+			RedirectConfirm:      true,
+		})
+		var result Result
+		service.SetResultHook(func(r Result) { result = r })
+		return service, &result
+	}
+	
+	client := &messageClient{messages: make(map[string]string)}
+	service, result := newService(client)
+	deliver(service)
+	
+	want := []sentEnvelope{
+		{host: "mx.one.test", rcpts: []string{"a@one.test"}},
+		{host: "mx.two.test", rcpts: []string{"qa@two.test"}},
+	}
+	if !reflect.DeepEqual(client.sent, want) {
+		t.Errorf("Expected envelopes %v, got %v", want, client.sent)
+	}
+	if msg := client.messages["mx.two.test"]; !strings.HasPrefix(msg, "X-Original-To: customer@elsewhere.test, other@somewhere.test\r\n") {
+		t.Errorf("Expected the original recipients named, got:\n%s", msg)
+	}
+	if msg := client.messages["mx.one.test"]; strings.Contains(msg, "X-Original-To") {
+		t.Errorf("Expected the allowed domain's message unchanged, got:\n%s", msg)
+	}
+	
+	if result.Status != email.StatusDelivered {
+		t.Fatalf("Expected delivery, got %s: %v", result.Status, result.Err)
+	}
+	wantRedirect := &email.Redirect{To: "qa@two.test", Recipients: []string{"customer@elsewhere.test", "other@somewhere.test"}}
+	if !reflect.DeepEqual(result.Redirect, wantRedirect) {
+		t.Errorf("Expected %+v reported, got %+v", wantRedirect, result.Redirect)
+	}
+	for _, rcpt := range []string{"a@one.test", "customer@elsewhere.test", "other@somewhere.test"} {
+		if status := result.Recipients[rcpt].Status; status != email.StatusDelivered {
+			t.Errorf("Expected %s delivered, got %s", rcpt, status)
+		}
+	}
+	
+	// A refusal of the catch-all address fails the recipients it stood for
+	client = &messageClient{
+		envelopeClient: envelopeClient{refuse: map[string]error{"qa@two.test": &textproto.Error{Code: 550, Msg: "5.1.1 no such user"}}},
+		messages:       make(map[string]string),
+	}
+	service, result = newService(client)
+	deliver(service)
+	for rcpt, want := range map[string]email.Status{
+		"a@one.test":              email.StatusDelivered,
+		"customer@elsewhere.test": email.StatusFailed,
+		"other@somewhere.test":    email.StatusFailed,
+	} {
+		if status := result.Recipients[rcpt].Status; status != want {
+			t.Errorf("Expected %s %s, got %s", rcpt, want, status)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package delivery
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// dkimHeaders are the headers signed by the test fixture.
+var dkimHeaders = []string{"From", "To", "Subject", "Date"}
+
+// signedFixture returns a message signed with DKIM using simple/simple
+// canonicalization, which any change to the signed headers or body breaks.
+// Its unusual header casing and spacing are lost if it is re-serialized.
+func signedFixture(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+	
+	signed := "From:  Sender <sender@example.org>\r\n" +
+		"To: rcpt@example.com\r\n" +
+		"Subject:   Relay fidelity\r\n" +
+		"Date: Fri, 16 Oct 2026 08:00:00 +0000\r\n"
+	unsigned := "X-Odd-HEADER:keep me exactly\r\n"
+	body := "Line one\r\n\r\n  indented line\r\n"
+	
+	bh := sha256.Sum256([]byte(dkimBody(body)))
+	sig := "DKIM-Signature: v=1; a=rsa-sha256; c=simple/simple; d=example.org; s=test; h=" +
+		strings.Join(dkimHeaders, ":") + "; bh=" + base64.StdEncoding.EncodeToString(bh[:]) + "; b="
+	
+	digest := sha256.Sum256([]byte(signed + sig))
+	b, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign fixture: %v", err)
+	}
+	return sig + base64.StdEncoding.EncodeToString(b) + "\r\n" + signed + unsigned + "\r\n" + body
+}
+
+// dkimBody applies simple body canonicalization: trailing empty lines are
+// reduced to a single line ending.
+func dkimBody(body string) string {
+	return strings.TrimRight(body, "\r\n") + "\r\n"
+}
+
+// verifyDKIM checks the simple/simple DKIM signature on msg against pub.
+func verifyDKIM(msg string, pub *rsa.PublicKey) error {
+	end := strings.Index(msg, "\r\n\r\n")
+	if end < 0 {
+		return fmt.Errorf("no header/body separator")
+	}
+	body := msg[end+4:]
+	
+	var sig string
+	fields := make(map[string]string)
+	for _, line := range strings.SplitAfter(msg[:end+2], "\r\n") {
+		name, _, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if name == "DKIM-Signature" {
+			sig = line
+			continue
+		}
+		if _, seen := fields[strings.ToLower(name)]; !seen {
+			fields[strings.ToLower(name)] = line
+		}
+	}
+	if sig == "" {
+		return fmt.Errorf("no DKIM-Signature header")
+	}
+	
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(sig, "DKIM-Signature: "), "\r\n"), "; ") {
+		k, v, _ := strings.Cut(tag, "=")
+		tags[k] = v
+	}
+	
+	bh := sha256.Sum256([]byte(dkimBody(body)))
+	if base64.StdEncoding.EncodeToString(bh[:]) != tags["bh"] {
+		return fmt.Errorf("body hash mismatch")
+	}
+	
+	var signed strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		signed.WriteString(fields[strings.ToLower(name)])
+	}
+	signed.WriteString(strings.TrimSuffix(sig, tags["b"]+"\r\n"))
+	
+	b, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(signed.String()))
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], b)
+}
+
+func TestWriteMessage_RelayPreservesDKIM(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	
+	fixture := signedFixture(t, key)
+	if err := verifyDKIM(fixture, &key.PublicKey); err != nil {
+		t.Fatalf("Fixture does not verify: %v", err)
+	}
+	
+	parsed, err := email.Parse("sender@example.org", []string{"rcpt@example.com"}, strings.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+	
+	// Rebuilding from the parsed fields breaks the signature
+	var rebuilt bytes.Buffer
+	if err := WriteMessage(&rebuilt, parsed, nil); err != nil {
+		t.Fatalf("Failed to write email: %v", err)
+	}
+	if verifyDKIM(rebuilt.String(), &key.PublicKey) == nil {
+		t.Fatal("Expected a rebuilt message to fail verification")
+	}
+	
+	trace := "Received: from client.example.org (192.0.2.1:40000) by mx.test with ESMTP id relay-1; Fri, 16 Oct 2026 08:00:01 +0000\r\n"
+	stored := trace + fixture
+	spilled := filepath.Join(t.TempDir(), "raw.eml")
+	if err := os.WriteFile(spilled, []byte(stored), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	
+	tests := []struct {
+		name string
+		set  func(e *email.Email)
+	}{
+		{"in memory", func(e *email.Email) { e.Raw = []byte(stored) }},
+		{"spilled to disk", func(e *email.Email) { e.RawPath = spilled }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := parsed.Clone()
+			e.ID = "relay-1"
+			e.Relayed = true
+			tt.set(e)
+			
+			var buf bytes.Buffer
+			if err := WriteMessage(&buf, e, nil); err != nil {
+				t.Fatalf("Failed to write email: %v", err)
+			}
+			if buf.String() != stored {
+				t.Errorf("Expected the message as received, got %q", buf.String())
+			}
+			if err := verifyDKIM(buf.String(), &key.PublicKey); err != nil {
+				t.Errorf("Relayed message failed DKIM verification: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+)
+
+// reputationWebhookTimeout bounds each POST to the reputation webhook.
+const reputationWebhookTimeout = 10 * time.Second
+
+// defaultReputationPatterns are the replies of the common blocklists and
+// of receivers refusing a sending IP outright, checked after the
+// configured patterns.
+var defaultReputationPatterns = []config.ReputationPattern{
+	{Name: "Spamhaus", Pattern: `(?i)spamhaus`},
+	{Name: "Barracuda", Pattern: `(?i)barracuda`},
+	{Name: "Proofpoint", Pattern: `(?i)proofpoint|pphosted`},
+	{Name: "SpamCop", Pattern: `(?i)spamcop`},
+	{Name: "SORBS", Pattern: `(?i)\bsorbs\b`},
+	{Name: "blocklist", Pattern: `(?i)\b(block|black|deny) ?list(ed)?\b|\blisted (at|on|in|by)\b|\bblocked using\b|\b(ip|sender|poor) reputation\b|\brbl\b|\bdnsbl\b`},
+}
+
+// ReputationBlock is a blocklist, or other reputation check, that
+// receivers have refused our sending IP under.
+type ReputationBlock struct {
+	Blocklist string `json:"blocklist"`
+	
+	// The latest refusal: where it came from and what was said
+	Domain string `json:"domain"`
+	Host   string `json:"host"`
+	Reply  string `json:"reply"`
+	
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// ReputationStats reports refusals because of the sending IP's reputation.
+type ReputationStats struct {
+	// Refusals since the server started
+	Blocked int64 `json:"blocked"`
+	
+	// Blocklists seen within the clear-after period, by name
+	Active []ReputationBlock `json:"active,omitempty"`
+}
+
+// ReputationAlert is the body POSTed to the reputation webhook when
+// receivers start refusing mail under a blocklist.
+type ReputationAlert struct {
+	Event string `json:"event"`
+	ReputationBlock
+}
+
+// reputationError is a reply refusing mail because of the sending IP's
+// reputation. It says nothing about the message, so the email is
+// deferred rather than failed.
+type reputationError struct {
+	blocklist string
+	host      string
+	reply     *textproto.Error
+	err       error
+}
+
+func (r *reputationError) Error() string {
+	return fmt.Sprintf("sending IP blocked by %s: %v", r.blocklist, r.err)
+}
+
+func (r *reputationError) Unwrap() error { return r.err }
+
+func reputationBlocked(err error) (*reputationError, bool) {
+	var r *reputationError
+	ok := errors.As(err, &r)
+	return r, ok
+}
+
+func isReputationBlock(err error) bool {
+	_, ok := reputationBlocked(err)
+	return ok
+}
+
+type reputationPattern struct {
+	name  string
+	re    *regexp.Regexp
+	codes []int
+}
+
+func (p *reputationPattern) matches(reply *textproto.Error) bool {
+	if reply.Code < 400 || !p.re.MatchString(reply.Msg) {
+		return false
+	}
+	if len(p.codes) == 0 {
+		return true
+	}
+	for _, code := range p.codes {
+		if code == reply.Code {
+			return true
+		}
+	}
+	return false
+}
+
+// reputation recognizes reputation refusals and keeps track of the
+// blocklists behind them.
+type reputation struct {
+	patterns   []reputationPattern
+	clearAfter time.Duration
+	webhook    string
+	client     *http.Client
+	
+	blocked atomic.Int64
+	
+	mu     sync.Mutex
+	blocks map[string]*ReputationBlock
+}
+
+// newReputation returns the reputation table for cfg: its patterns, which
+// Validate has checked, followed by the built-in ones.
+func newReputation(cfg *config.DeliveryConfig) *reputation {
+	r := &reputation{
+		clearAfter: cfg.ReputationClearAfter,
+		webhook:    cfg.ReputationWebhook,
+		client:     &http.Client{Timeout: reputationWebhookTimeout},
+		blocks:     make(map[string]*ReputationBlock),
+	}
+	for _, p := range append(append([]config.ReputationPattern(nil), cfg.ReputationPatterns...), defaultReputationPatterns...) {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			log.Printf("Ignoring reputation pattern %s: %v", p.Name, err)
+			continue
+		}
+		r.patterns = append(r.patterns, reputationPattern{name: p.Name, re: re, codes: p.Codes})
+	}
+	if r.clearAfter <= 0 {
+		r.clearAfter = time.Hour
+	}
+	return r
+}
+
+// check returns err from a transaction with host to rcpts as a deferred
+// reputationError if the host refused the sending IP: the transaction
+// was refused outright, or every recipient was, with a reply matching a
+// pattern. Other errors are returned unchanged.
+func (r *reputation) check(host string, rcpts []string, err error) error {
+	var replies []*textproto.Error
+	var rcptErr *RecipientError
+	if errors.As(err, &rcptErr) {
+		if len(rcptErr.Rejected) < len(rcpts) {
+			return err
+		}
+		for _, rcpt := range rcptErr.recipients() {
+			var reply *textproto.Error
+			if !errors.As(rcptErr.Rejected[rcpt], &reply) {
+				return err
+			}
+			replies = append(replies, reply)
+		}
+	} else {
+		var reply *textproto.Error
+		if !errors.As(err, &reply) {
+			return err
+		}
+		replies = append(replies, reply)
+	}
+	
+	for i := range r.patterns {
+		p := &r.patterns[i]
+		all := true
+		for _, reply := range replies {
+			all = all && p.matches(reply)
+		}
+		if all {
+			return deferred(&reputationError{blocklist: p.name, host: host, reply: replies[0], err: err})
+		}
+	}
+	return err
+}
+
+// record notes a refusal from domain, alerting when its blocklist has not
+// been seen within the clear-after period.
+func (r *reputation) record(ctx context.Context, domain string, rerr *reputationError, now time.Time) {
+	r.blocked.Add(1)
+	
+	r.mu.Lock()
+	b := r.blocks[rerr.blocklist]
+	fresh := b == nil || now.Sub(b.LastSeen) >= r.clearAfter
+	if fresh {
+		b = &ReputationBlock{Blocklist: rerr.blocklist, FirstSeen: now}
+		r.blocks[rerr.blocklist] = b
+	}
+	b.Domain, b.Host = domain, rerr.host
+	b.Reply = fmt.Sprintf("%d %s", rerr.reply.Code, rerr.reply.Msg)
+	b.Count++
+	b.LastSeen = now
+	alert := *b
+	r.mu.Unlock()
+	
+	if !fresh {
+		return
+	}
+	logctx.Printf(ctx, "Sending IP blocked by %s: %s refused mail with %q", rerr.blocklist, rerr.host, alert.Reply)
+	if r.webhook != "" {
+		go r.notify(alert)
+	}
+}
+
+// notify POSTs an alert for b to the webhook.
+func (r *reputation) notify(b ReputationBlock) {
+	body, err := json.Marshal(ReputationAlert{Event: "reputation_block", ReputationBlock: b})
+	if err != nil {
+		log.Printf("Failed to encode reputation alert: %v", err)
+		return
+	}
+	resp, err := r.client.Post(r.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to send reputation alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Reputation webhook returned %s", resp.Status)
+	}
+}
+
+func (r *reputation) stats(now time.Time) ReputationStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	
+	stats := ReputationStats{Blocked: r.blocked.Load()}
+	for _, b := range r.blocks {
+		if now.Sub(b.LastSeen) < r.clearAfter {
+			stats.Active = append(stats.Active, *b)
+		}
+	}
+	sort.Slice(stats.Active, func(i, j int) bool { return stats.Active[i].Blocklist < stats.Active[j].Blocklist })
+	return stats
+}
+
+// ReputationStats reports how often receivers have refused the sending IP
+// because of its reputation, and the blocklists they currently cite.
+func (s *Service) ReputationStats() ReputationStats {
+	return s.reputation.stats(time.Now())
+}
+
+// recordReputation notes a reputation refusal from domain, if err is one.
+func (s *Service) recordReputation(ctx context.Context, domain string, err error) {
+	if rerr, ok := reputationBlocked(err); ok {
+		s.reputation.record(ctx, domain, rerr, time.Now())
+	}
+}
@@ -0,0 +1,177 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestReputation_Check(t *testing.T) {
+	r := newReputation(&config.DeliveryConfig{
+		ReputationPatterns: []config.ReputationPattern{
+			{Name: "Example RBL", Pattern: `rbl\.example\.net`, Codes: []int{554}},
+		},
+	})
+	sender := func(code int, msg string) error {
+		return fmt.Errorf("failed to set sender: %w", &textproto.Error{Code: code, Msg: msg})
+	}
+	
+	tests := []struct {
+		name      string
+		err       error
+		blocklist string
+	}{
+		{name: "spamhaus", err: sender(550, "5.7.1 Service unavailable; Client host [192.0.2.1] blocked using zen.spamhaus.org"), blocklist: "Spamhaus"},
+		{name: "barracuda", err: sender(554, "Service unavailable; Client host [192.0.2.1] blocked using Barracuda Reputation"), blocklist: "Barracuda"},
+		{name: "proofpoint", err: sender(554, "5.7.0 Blocked - see https://ipcheck.proofpoint.com/?ip=192.0.2.1"), blocklist: "Proofpoint"},
+		{name: "temporary", err: sender(421, "4.7.0 [192.0.2.1] Our system has detected an unusual rate of mail, IP reputation too low"), blocklist: "blocklist"},
+		{name: "block list", err: sender(550, "5.7.1 Part of their network is on our block list (S3150)"), blocklist: "blocklist"},
+		{name: "configured", err: sender(554, "Rejected, listed at rbl.example.net"), blocklist: "Example RBL"},
+		{name: "configured code", err: sender(550, "Rejected by rbl.example.net"), blocklist: "blocklist"},
+		{name: "every recipient", err: &RecipientError{Rejected: map[string]error{
+			"a@example.com": &textproto.Error{Code: 554, Msg: "5.7.1 Client host rejected: listed by Spamhaus"},
+			"b@example.com": &textproto.Error{Code: 554, Msg: "5.7.1 Client host rejected: listed by Spamhaus"},
+		}}, blocklist: "Spamhaus"},
+		{name: "some recipients", err: &RecipientError{Rejected: map[string]error{
+			"a@example.com": &textproto.Error{Code: 554, Msg: "5.7.1 Client host rejected: listed by Spamhaus"},
+		}}},
+		{name: "unknown user", err: sender(550, "5.1.1 User unknown")},
+		{name: "not a reply", err: errors.New("connection refused from spamhaus")},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.check("mx.example.com", []string{"a@example.com", "b@example.com"}, tt.err)
+			rerr, ok := reputationBlocked(err)
+			if tt.blocklist == "" {
+				if ok || err != tt.err {
+					t.Fatalf("Expected the error unchanged, got %v", err)
+				}
+				return
+			}
+			if !ok || rerr.blocklist != tt.blocklist {
+				t.Fatalf("Expected a block by %s, got %v", tt.blocklist, err)
+			}
+			if !isDeferral(err) || permanent(err) || !hostAnswered(err) {
+				t.Errorf("Expected a deferral from a host that answered, got %v", err)
+			}
+			if got := FailureReason(err); got != ReasonReputation {
+				t.Errorf("Expected reason %q, got %q", ReasonReputation, got)
+			}
+		})
+	}
+}
+
+// senderRefusingClient refuses every transaction at MAIL FROM.
+type senderRefusingClient struct {
+	reply *textproto.Error
+	sends int
+}
+
+func (c *senderRefusingClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	c.sends++
+	return fmt.Errorf("failed to set sender: %w", c.reply)
+}
+
+func TestDeliveryService_ReputationBlock(t *testing.T) {
+	alerts := make(chan ReputationAlert, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert ReputationAlert
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts <- alert
+	}))
+	defer webhook.Close()
+	
+	ctx := context.Background()
+	cfg := &config.DeliveryConfig{
+		Workers:              1,
+		DNSCacheTTL:          5 * time.Minute,
+		ConnectionTimeout:    30 * time.Second,
+		BreakerThreshold:     5,
+		BreakerWindow:        time.Minute,
+		BreakerCooldown:      time.Minute,
+		ReputationClearAfter: time.Hour,
+		ReputationWebhook:    webhook.URL,
+	}
+	q := queue.NewMemoryQueue(10)
+	service := NewService(cfg, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mx1.example.com", Pref: 10}, {Host: "mx2.example.com", Pref: 20}},
+		},
+	}
+	client := &senderRefusingClient{reply: &textproto.Error{Code: 550, Msg: "5.7.1 Service unavailable; client [192.0.2.1] blocked using Spamhaus"}}
+	service.client = client
+	
+	for i := 0; i < 2; i++ {
+		q.Enqueue(ctx, &email.Email{
+			ID:     fmt.Sprintf("blocked-%d", i),
+			From:   "sender@test.com",
+			To:     []string{"recipient@example.com"},
+			Status: email.StatusQueued,
+		})
+	}
+	service.poll(ctx, 0, "")
+	
+	// One refusal opens the circuit, holding the second email
+	if client.sends != 1 {
+		t.Errorf("Expected one transaction, got %d", client.sends)
+	}
+	e, err := q.Get(ctx, "blocked-0")
+	if err != nil {
+		t.Fatalf("Expected the email to stay queued, got %v", err)
+	}
+	if e.DeferCount != 1 || e.RetryCount != 0 {
+		t.Errorf("Expected a deferral without a retry, got defer=%d retry=%d", e.DeferCount, e.RetryCount)
+	}
+	if e, _ := q.Get(ctx, "blocked-1"); e.DeferCount != 0 || e.RetryCount != 0 || e.ScheduledAt == nil {
+		t.Errorf("Expected the second email held by the circuit, got %+v", e)
+	}
+	if stats := service.BreakerStats(); len(stats) != 1 || stats[0].State != CircuitOpen {
+		t.Errorf("Expected example.com circuit open, got %+v", stats)
+	}
+	
+	stats := service.ReputationStats()
+	if stats.Blocked != 1 || len(stats.Active) != 1 {
+		t.Fatalf("Expected one block by one blocklist, got %+v", stats)
+	}
+	if b := stats.Active[0]; b.Blocklist != "Spamhaus" || b.Domain != "example.com" || b.Host != "mx1.example.com" {
+		t.Errorf("Expected Spamhaus blocking at mx1.example.com, got %+v", b)
+	}
+	
+	select {
+	case alert := <-alerts:
+		if alert.Event != "reputation_block" || alert.Blocklist != "Spamhaus" || alert.Count != 1 {
+			t.Errorf("Unexpected alert %+v", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected an alert on the webhook")
+	}
+}
+
+func TestReputation_Clears(t *testing.T) {
+	r := newReputation(&config.DeliveryConfig{ReputationClearAfter: time.Hour})
+	err := r.check("mx.example.com", []string{"a@example.com"}, &textproto.Error{Code: 554, Msg: "blocked using Barracuda"})
+	rerr, _ := reputationBlocked(err)
+	
+	now := time.Now()
+	r.record(context.Background(), "example.com", rerr, now)
+	r.record(context.Background(), "example.com", rerr, now.Add(time.Minute))
+	if stats := r.stats(now.Add(time.Minute)); stats.Blocked != 2 || len(stats.Active) != 1 || stats.Active[0].Count != 2 {
+		t.Errorf("Expected one active block seen twice, got %+v", stats)
+	}
+	if stats := r.stats(now.Add(2 * time.Hour)); stats.Blocked != 2 || len(stats.Active) != 0 {
+		t.Errorf("Expected the block cleared after an hour, got %+v", stats)
+	}
+}
@@ -0,0 +1,89 @@
+package delivery
+
+import (
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// Result is the outcome of a delivery attempt, reported once the queue
+// has recorded it. Status is StatusDelivered, StatusBounced once the email
+// has been refused for good, StatusFailed when it will not be tried again
+// for another reason, or StatusQueued when it will be retried. Failure
+// classifies Err.
+type Result struct {
+	ID         string
+	Status     email.Status
+	Err        error
+	Failure    *email.Failure
+	Attempt    int
+	Recipients map[string]email.RecipientStatus
+	At         time.Time
+	
+	// The envelope sender the attempt used
+	EnvelopeFrom string
+	
+	// Recipients whose mail went to a catch-all address instead, if any
+	Redirect *email.Redirect
+	
+	// The attempt's SMTP transactions, in order, and for a diagnostic
+	// email its full delivery log
+	Transactions []Transaction
+	Log          []string
+	
+	// The attempt's transactions as entries in the email's Attempts
+	Attempts []email.Attempt
+	
+	// SLABreached reports that the first attempt started after the
+	// email's SLA deadline
+	SLABreached bool
+}
+
+// SetResultHook calls hook with the outcome of every delivery attempt, so
+// the API can keep its totals and tracked emails current after an email
+// has left the queue. Deferrals, expiry and rejections by pre-delivery
+// hooks are not attempts and are not reported; the queue reports the ones
+// that end an email to its queue.RemovalListener listeners. hook runs on
+// the delivery worker and should return quickly.
+func (s *Service) SetResultHook(hook func(Result)) {
+	s.resultHook = hook
+}
+
+// SetResolver replaces the resolver used for MX lookups.
+func (s *Service) SetResolver(r DNSResolver) {
+	s.resolver = r
+}
+
+// SetClient replaces the client that sends each SMTP transaction.
+func (s *Service) SetClient(c SMTPClient) {
+	s.client = c
+}
+
+// report passes the outcome of e's attempt a to the result hook, if any.
+func (s *Service) report(e *email.Email, a *attempt, status email.Status, err error, results map[string]email.RecipientStatus) {
+	if s.resultHook == nil {
+		return
+	}
+	var failure *email.Failure
+	if err != nil {
+		f := Classify(err, e.RetryCount)
+		failure = &f
+	}
+	s.resultHook(Result{
+		ID:         e.ID,
+		Status:     status,
+		Err:        err,
+		Failure:    failure,
+		Attempt:    e.RetryCount + 1,
+		Recipients: results,
+		At:         time.Now(),
+		
+		EnvelopeFrom: e.EnvelopeFrom,
+		Redirect:     e.Redirect,
+		
+		Transactions: a.transactions(),
+		Log:          a.lines(),
+		Attempts:     a.attempts(e.RetryCount + 1),
+		SLABreached:  e.SLABreached,
+	})
+}
@@ -0,0 +1,26 @@
+package delivery
+
+import (
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// envelopeFrom returns the MAIL FROM for e: the configured return path,
+// VERP-encoded with e's signed ID if enabled, or e's From. Relayed mail keeps
+// the sender it was received from, and non-delivery reports have none.
+func (s *Service) envelopeFrom(e *email.Email) string {
+	if e.Notification {
+		return ""
+	}
+	rp := s.config.ReturnPath
+	if rp.Domain == "" || e.Relayed {
+		return e.From
+	}
+	localPart := rp.LocalPart
+	if localPart == "" {
+		localPart = "bounce"
+	}
+	if rp.VERP {
+		return email.EncodeVERP(localPart, e.ID, rp.Domain, rp.Secret)
+	}
+	return localPart + "@" + rp.Domain
+}
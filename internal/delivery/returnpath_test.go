@@ -0,0 +1,58 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestService_ReturnPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		returnPath config.ReturnPathConfig
+		relayed    bool
+		want       string
+	}{
+		{name: "author by default", want: "author@test.com"},
+		{name: "fixed return path", returnPath: config.ReturnPathConfig{Domain: "bounces.example.com", LocalPart: "bounce"}, want: "bounce@bounces.example.com"},
+		{name: "VERP", returnPath: config.ReturnPathConfig{Domain: "bounces.example.com", LocalPart: "bounce", VERP: true, Secret: "secret"}, want: email.EncodeVERP("bounce", "rp-1", "bounces.example.com", "secret")},
+		{name: "relayed mail keeps its sender", returnPath: config.ReturnPathConfig{Domain: "bounces.example.com", VERP: true}, relayed: true, want: "author@test.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newSinkServer(t, false)
+			service := NewService(&config.DeliveryConfig{
+				Workers:           1,
+				DNSCacheTTL:       5 * time.Minute,
+				ConnectionTimeout: 5 * time.Second,
+				ReturnPath:        tt.returnPath,
+			}, newMockQueue())
+			service.resolver = &mockDNSResolver{
+				mx: map[string][]*net.MX{
+					"example.com": {{Host: sink.addr(), Pref: 10}},
+				},
+			}
+			var result Result
+			service.SetResultHook(func(r Result) { result = r })
+			
+			e := &email.Email{ID: "rp-1", From: "author@test.com", To: []string{"rcpt@example.com"}, Subject: "Hi", Body: "Body", Relayed: tt.relayed, Status: email.StatusQueued}
+			if tt.relayed {
+				e.Raw = []byte("From: author@test.com\r\nSubject: Hi\r\n\r\nBody\r\n")
+			}
+			service.deliver(context.Background(), e)
+			if result.Status != email.StatusDelivered {
+				t.Fatalf("Expected delivery, got %s: %v", result.Status, result.Err)
+			}
+			if got, _ := sink.mailFrom.Load().(string); got != tt.want {
+				t.Errorf("Expected MAIL FROM %s, got %s", tt.want, got)
+			}
+			if result.EnvelopeFrom != tt.want {
+				t.Errorf("Expected the envelope sender %s recorded, got %s", tt.want, result.EnvelopeFrom)
+			}
+		})
+	}
+}
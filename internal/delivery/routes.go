@@ -0,0 +1,133 @@
+package delivery
+
+import (
+	"context"
+	"sort"
+	"strings"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+)
+
+// route is how mail for the recipient domains matching pattern is sent:
+// through relay, or straight to their MX hosts if it is nil.
+type route struct {
+	pattern string
+	relay   *relay
+	
+	// Replaces the domain's TLS policy on a direct route
+	tlsPolicy string
+}
+
+// target names where the route sends mail, for the log.
+func (r *route) target() string {
+	if r.relay != nil {
+		return "relay " + r.relay.addr
+	}
+	return "MX hosts"
+}
+
+// routeTable finds the route for a recipient domain: an exact match,
+// then the wildcard with the longest suffix, then the fallback.
+type routeTable struct {
+	exact     map[string]*route
+	wildcards []*route
+	
+	// "*", or the relay in relay mode; nil sends unmatched mail direct
+	fallback *route
+}
+
+// newRoutes returns cfg's routes, or nil if there are none and mail goes
+// straight to MX hosts.
+func newRoutes(cfg *config.DeliveryConfig) *routeTable {
+	t := &routeTable{exact: make(map[string]*route)}
+	if cfg.Mode == "relay" {
+		t.fallback = &route{pattern: "*", relay: newRelay(cfg, &cfg.Relay, "")}
+	}
+	for i := range cfg.Routes {
+		rc := &cfg.Routes[i]
+		r := &route{pattern: strings.ToLower(rc.Domain)}
+		if rc.Target == "relay" {
+			r.relay = newRelay(cfg, &rc.Relay, rc.TLSPolicy)
+		} else {
+			r.tlsPolicy = rc.TLSPolicy
+		}
+		switch {
+		case r.pattern == "*":
+			t.fallback = r
+		case strings.HasPrefix(r.pattern, "*."):
+			t.wildcards = append(t.wildcards, r)
+		default:
+			t.exact[r.pattern] = r
+		}
+	}
+	if t.fallback == nil && len(t.exact) == 0 && len(t.wildcards) == 0 {
+		return nil
+	}
+	
+	sort.SliceStable(t.wildcards, func(i, j int) bool {
+		return len(t.wildcards[i].pattern) > len(t.wildcards[j].pattern)
+	})
+	return t
+}
+
+// match returns the route for domain, nil if none applies.
+func (t *routeTable) match(domain string) *route {
+	if t == nil {
+		return nil
+	}
+	domain = strings.ToLower(domain)
+	if r, ok := t.exact[domain]; ok {
+		return r
+	}
+	for _, r := range t.wildcards {
+		if strings.HasSuffix(domain, r.pattern[1:]) {
+			return r
+		}
+	}
+	return t.fallback
+}
+
+// route logs and returns the route for domain when routes are configured.
+func (s *Service) route(ctx context.Context, domain string) *route {
+	if s.routes == nil {
+		return nil
+	}
+	r := s.routes.match(domain)
+	if r == nil {
+		logctx.Printf(ctx, "Routing %s to its MX hosts, no route matches", domain)
+		return nil
+	}
+	logctx.Printf(ctx, "Routing %s to %s (route %s)", domain, r.target(), r.pattern)
+	return r
+}
+
+// len returns the number of routes, counting the fallback.
+func (t *routeTable) len() int {
+	n := len(t.exact) + len(t.wildcards)
+	if t.fallback != nil {
+		n++
+	}
+	return n
+}
+
+// relays returns the relays of every route.
+func (t *routeTable) relays() []*relay {
+	if t == nil {
+		return nil
+	}
+	var relays []*relay
+	add := func(r *route) {
+		if r != nil && r.relay != nil {
+			relays = append(relays, r.relay)
+		}
+	}
+	add(t.fallback)
+	for _, r := range t.exact {
+		add(r)
+	}
+	for _, r := range t.wildcards {
+		add(r)
+	}
+	return relays
+}
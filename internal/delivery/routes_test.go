@@ -0,0 +1,105 @@
+package delivery
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestRouteTable_Match(t *testing.T) {
+	relayTo := func(host string) config.RelayConfig {
+		return config.RelayConfig{Host: host, Port: 587, TLS: "starttls"}
+	}
+	routes := []config.RouteConfig{
+		{Domain: "partner.com", Target: "relay", Relay: relayTo("partner-relay")},
+		{Domain: "*.partner.com", Target: "direct", TLSPolicy: TLSPolicyRequired},
+		{Domain: "*.eu.partner.com", Target: "relay", Relay: relayTo("eu-relay")},
+		{Domain: "Direct.Partner.com", Target: "direct"},
+	}
+	
+	tests := []struct {
+		name   string
+		mode   string
+		routes []config.RouteConfig
+		domain string
+		want   string // pattern of the matching route, "" for none
+	}{
+		{name: "exact", routes: routes, domain: "partner.com", want: "partner.com"},
+		{name: "exact case-insensitive", routes: routes, domain: "PARTNER.com", want: "partner.com"},
+		{name: "exact beats wildcard", routes: routes, domain: "direct.partner.com", want: "direct.partner.com"},
+		{name: "wildcard", routes: routes, domain: "mail.partner.com", want: "*.partner.com"},
+		{name: "longest wildcard", routes: routes, domain: "mx.eu.partner.com", want: "*.eu.partner.com"},
+		{name: "wildcard skips its apex", routes: routes, domain: "eu.partner.com", want: "*.partner.com"},
+		{name: "suffix is not a subdomain", routes: routes, domain: "notpartner.com", want: ""},
+		{name: "no match", routes: routes, domain: "example.org", want: ""},
+		{name: "catch-all", routes: append(routes, config.RouteConfig{Domain: "*", Target: "direct"}), domain: "example.org", want: "*"},
+		{name: "relay mode is the fallback", mode: "relay", routes: routes, domain: "example.org", want: "*"},
+		{name: "routes beat relay mode", mode: "relay", routes: routes, domain: "mail.partner.com", want: "*.partner.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.DeliveryConfig{Mode: tt.mode, Relay: relayTo("smarthost"), Routes: tt.routes}
+			got := newRoutes(cfg).match(tt.domain)
+			switch {
+			case tt.want == "" && got != nil:
+				t.Errorf("match(%q) = %s, want none", tt.domain, got.pattern)
+			case tt.want != "" && (got == nil || got.pattern != tt.want):
+				t.Errorf("match(%q) = %v, want %s", tt.domain, got, tt.want)
+			}
+		})
+	}
+	
+	if newRoutes(&config.DeliveryConfig{Mode: "mx"}) != nil {
+		t.Error("Expected no route table without routes")
+	}
+}
+
+func TestService_Routes(t *testing.T) {
+	f := newDANEFixture(t, time.Now().Add(time.Hour))
+	relaySink := startSinkServer(t, &sinkServer{tls: f.tls, implicitTLS: true, auth: []string{"PLAIN"}, user: "partner-user", password: "secret"})
+	directSink := startSinkServer(t, &sinkServer{})
+	host, port, _ := net.SplitHostPort(relaySink.addr())
+	portNum, _ := strconv.Atoi(port)
+	
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 5 * time.Second,
+		Mode:              "mx",
+		Routes: []config.RouteConfig{{
+			Domain: "*.partner.com",
+			Target: "relay",
+			Relay:  config.RelayConfig{Host: host, Port: portNum, Username: "partner-user", Password: "secret", TLS: "tls"},
+		}},
+	}
+	service := NewService(cfg, queue.NewMemoryQueue(10))
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.org": {{Host: directSink.addr(), Pref: 10}},
+		},
+	}
+	service.client = newClient(cfg)
+	roots := x509.NewCertPool()
+	roots.AddCert(f.ca)
+	for _, r := range service.routes.relays() {
+		r.client.rootCAs = roots
+	}
+	
+	e := &email.Email{ID: "routed-1", From: "sender@test.com", To: []string{"rcpt@mail.partner.com", "rcpt@example.org"}, Subject: "Hi", Body: "Body", Status: email.StatusQueued}
+	if _, err := service.processEmail(context.Background(), e); err != nil {
+		t.Fatalf("Expected delivery, got %v", err)
+	}
+	if got := relaySink.messages.Load(); got != 1 {
+		t.Errorf("Expected the partner's recipient relayed, got %d messages", got)
+	}
+	if got := directSink.messages.Load(); got != 1 {
+		t.Errorf("Expected the other recipient delivered to its MX, got %d messages", got)
+	}
+}
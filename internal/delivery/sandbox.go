@@ -0,0 +1,75 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/identity"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// SandboxClient is an SMTPClient that sends nothing. Each message is built
+// exactly as it would be sent and its envelope, target host and size are
+// logged, so a staging server runs the whole pipeline, MX lookups
+// included, and reports every email delivered without any leaving it.
+type SandboxClient struct {
+	// Dir, if set, receives each built message as <id>-<host>.eml
+	Dir string
+	
+	// The server whose name goes in the Received header, if the message
+	// needs one
+	Identity *identity.Identity
+}
+
+// NewSandboxClient returns a client that writes the messages it would
+// have sent to dir, or only logs them if dir is empty.
+func NewSandboxClient(dir string) *SandboxClient {
+	return &SandboxClient{Dir: dir}
+}
+
+func (c *SandboxClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, e, c.Identity); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	logctx.Printf(ctx, "Sandbox: not sending %d bytes to %s: MAIL FROM:<%s> RCPT TO:<%s>",
+		buf.Len(), host, mailFrom(e), strings.Join(rcpts, ">,<"))
+	
+	if c.Dir == "" {
+		return nil
+	}
+	// The message is only kept for inspection, so failing to write it
+	// doesn't change the outcome
+	path, err := c.write(e, host, buf.Bytes())
+	if err != nil {
+		logctx.Printf(ctx, "Sandbox: failed to keep message: %v", err)
+		return nil
+	}
+	logctx.Printf(ctx, "Sandbox: message kept in %s", path)
+	return nil
+}
+
+// write saves msg, e as sent to host, in the client's directory.
+func (c *SandboxClient) write(e *email.Email, host string, msg []byte) (string, error) {
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return "", err
+	}
+	name := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(e.ID + "-" + host)
+	path := filepath.Join(c.Dir, name+".eml")
+	if err := os.WriteFile(path, msg, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// sandboxed reports whether e goes to the sandbox client instead of being
+// sent: all mail when the service is configured so, or e alone if it
+// asked to be.
+func (s *Service) sandboxed(e *email.Email) bool {
+	return s.config.Sandbox || e.Sandbox
+}
@@ -0,0 +1,72 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestDeliveryService_Sandbox(t *testing.T) {
+	sink := newSinkServer(t, false)
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		sandbox bool
+		email   bool
+	}{
+		{name: "configured", sandbox: true},
+		{name: "per email", email: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(&config.DeliveryConfig{
+				Workers:           1,
+				DNSCacheTTL:       5 * time.Minute,
+				ConnectionTimeout: 5 * time.Second,
+				Sandbox:           tt.sandbox,
+				SandboxDir:        dir,
+			}, newMockQueue())
+			resolver := &mockDNSResolver{mx: map[string][]*net.MX{"example.com": {{Host: sink.addr(), Pref: 10}}}}
+			service.resolver = resolver
+			var result Result
+			service.SetResultHook(func(r Result) { result = r })
+			
+			e := &email.Email{
+				ID:      "sandbox-" + strings.ReplaceAll(tt.name, " ", "-"),
+				From:    "sender@test.com",
+				To:      []string{"rcpt@example.com"},
+				Subject: "Staging",
+				Body:    "Body",
+				Sandbox: tt.email,
+				Status:  email.StatusQueued,
+			}
+			service.deliver(context.Background(), e)
+			
+			if result.Status != email.StatusDelivered {
+				t.Fatalf("Expected the email reported delivered, got %s: %v", result.Status, result.Err)
+			}
+			if len(result.Attempts) != 1 || result.Attempts[0].Host != sink.addr() {
+				t.Errorf("Expected the MX host resolved and recorded, got %+v", result.Attempts)
+			}
+			if sink.messages.Load() != 0 {
+				t.Errorf("Expected nothing sent, got %d messages", sink.messages.Load())
+			}
+			
+			name := strings.ReplaceAll(e.ID+"-"+sink.addr(), ":", "_") + ".eml"
+			msg, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatalf("Expected the built message kept, got %v", err)
+			}
+			if !strings.Contains(string(msg), "Subject: Staging\r\n") {
+				t.Errorf("Expected the message as it would be sent, got:\n%s", msg)
+			}
+		})
+	}
+}
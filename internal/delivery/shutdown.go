@@ -0,0 +1,61 @@
+package delivery
+
+import (
+	"context"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+type stopKey struct{}
+
+// shutdownContext returns the context poll delivers a batch under. Once
+// ctx, the worker's, is cancelled no new delivery starts, but the one
+// under way has ShutdownTimeout to finish, or until Stop's deadline
+// passes, before it is aborted too. Without a timeout deliveries run
+// under ctx and are aborted at once.
+func (s *Service) shutdownContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	grace := s.config.ShutdownTimeout
+	if grace <= 0 {
+		return ctx, func() {}
+	}
+	deliverCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	deliverCtx = context.WithValue(deliverCtx, stopKey{}, ctx)
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(grace, cancel)
+	})
+	stopAbort := context.AfterFunc(s.aborted, cancel)
+	return deliverCtx, func() {
+		stop()
+		stopAbort()
+		cancel()
+	}
+}
+
+// stopping reports whether the worker delivering under ctx has been told
+// to stop.
+func stopping(ctx context.Context) bool {
+	if worker, ok := ctx.Value(stopKey{}).(context.Context); ok {
+		return worker.Err() != nil
+	}
+	return ctx.Err() != nil
+}
+
+// shuttingDown puts e back in the queue, as it was, if the service is
+// stopping, so emails a worker had taken but not started are left queued
+// for the next start instead of sending with no one to deliver them.
+func (s *Service) shuttingDown(ctx, resultCtx context.Context, e *email.Email) bool {
+	if !stopping(ctx) {
+		return false
+	}
+	logctx.Printf(ctx, "Delivery service stopping, returning email to the queue")
+	s.postponeEmail(resultCtx, e, "delivery service stopping", 0)
+	return true
+}
+
+// interrupted reports whether err is shutdown having aborted an attempt
+// under ctx, which is put back in the queue without using up a retry.
+func interrupted(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() != nil && stopping(ctx)
+}
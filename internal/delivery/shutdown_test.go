@@ -0,0 +1,166 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// stallingSMTPClient holds each send until it is released or its context
+// is done.
+type stallingSMTPClient struct {
+	mockSMTPClient
+	started chan string
+	release chan struct{}
+}
+
+func (c *stallingSMTPClient) Send(ctx context.Context, host string, e *email.Email, rcpts []string) error {
+	c.started <- e.ID
+	select {
+	case <-c.release:
+		return c.mockSMTPClient.Send(ctx, host, e, rcpts)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestDeliveryService_ShutdownMidBatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		finish        bool
+		wantDelivered int
+	}{
+		{name: "delivery under way finishes", finish: true, wantDelivered: 1},
+		{name: "delivery under way aborted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := queue.NewMemoryQueue(10)
+			service := NewService(&config.DeliveryConfig{
+				Workers:           1,
+				DNSCacheTTL:       5 * time.Minute,
+				ConnectionTimeout: 30 * time.Second,
+				ShutdownTimeout:   200 * time.Millisecond,
+			}, q)
+			service.resolver = &mockDNSResolver{
+				mx: map[string][]*net.MX{"example.com": {{Host: "mail.example.com", Pref: 10}}},
+			}
+			client := &stallingSMTPClient{started: make(chan string, 1), release: make(chan struct{})}
+			service.client = client
+			
+			ids := []string{"batch-1", "batch-2", "batch-3"}
+			for _, id := range ids {
+				if err := q.Enqueue(context.Background(), &email.Email{ID: id, From: "sender@test.com", To: []string{"rcpt@example.com"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				service.poll(ctx, 0, "")
+				close(done)
+			}()
+			<-client.started
+			cancel()
+			if tt.finish {
+				close(client.release)
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Expected the worker to stop")
+			}
+			
+			if len(client.sent) != tt.wantDelivered {
+				t.Fatalf("Expected %d delivered, got %d", tt.wantDelivered, len(client.sent))
+			}
+			for _, id := range ids[tt.wantDelivered:] {
+				e, err := q.Get(context.Background(), id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if e.Status != email.StatusQueued || e.RetryCount != 0 {
+					t.Errorf("Expected %s requeued without an attempt, got %s after %d attempts", id, e.Status, e.RetryCount)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliveryService_Stop(t *testing.T) {
+	tests := []struct {
+		name    string
+		finish  bool
+		wantErr bool
+	}{
+		{name: "clean drain", finish: true},
+		{name: "deadline exceeded", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := queue.NewMemoryQueue(10)
+			service := NewService(&config.DeliveryConfig{
+				Workers:           1,
+				DNSCacheTTL:       5 * time.Minute,
+				ConnectionTimeout: 30 * time.Second,
+				ShutdownTimeout:   time.Minute,
+			}, q)
+			service.resolver = &mockDNSResolver{
+				mx: map[string][]*net.MX{"example.com": {{Host: "mail.example.com", Pref: 10}}},
+			}
+			client := &stallingSMTPClient{started: make(chan string, 1), release: make(chan struct{})}
+			service.client = client
+			
+			for _, id := range []string{"stop-1", "stop-2"} {
+				if err := q.Enqueue(context.Background(), &email.Email{ID: id, From: "sender@test.com", To: []string{"rcpt@example.com"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			go service.Start(context.Background())
+			first := <-client.started
+			
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			if tt.finish {
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					close(client.release)
+				}()
+			}
+			err := service.Stop(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			
+			// The delivery under way finished or was requeued; the other
+			// email was never started
+			wantQueued := []string{"stop-1", "stop-2"}
+			if tt.finish {
+				if len(client.sent) != 1 || client.sent[0].ID != first {
+					t.Fatalf("Expected %s delivered, got %d sent", first, len(client.sent))
+				}
+				wantQueued = nil
+				for _, id := range []string{"stop-1", "stop-2"} {
+					if id != first {
+						wantQueued = append(wantQueued, id)
+					}
+				}
+			}
+			for _, id := range wantQueued {
+				e, err := q.Get(context.Background(), id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if e.Status != email.StatusQueued || e.RetryCount != 0 {
+					t.Errorf("Expected %s requeued without an attempt, got %s after %d attempts", id, e.Status, e.RetryCount)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package delivery
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// ErrRelayAuth is returned when the relay refuses the configured login, or
+// offers no way to log in that the client supports. It is permanent, so
+// mail does not wait out its retries on bad credentials, unless the relay
+// says the failure is temporary with a 4xx reply.
+var ErrRelayAuth = errors.New("relay authentication failed")
+
+// TokenSource returns an OAuth2 access token for logging in to the relay
+// with XOAUTH2. It is called for every new session, so it should cache
+// the token and refresh it only when it is about to expire. An error fails
+// the attempt temporarily.
+type TokenSource func(ctx context.Context) (string, error)
+
+// StaticToken is a TokenSource always returning token.
+func StaticToken(token string) TokenSource {
+	return func(ctx context.Context) (string, error) {
+		return token, nil
+	}
+}
+
+// relay is a smarthost mail is handed to instead of its MX hosts. Each
+// has its own client, logging in with its own credentials.
+type relay struct {
+	host   string
+	addr   string
+	policy string
+	client *SimpleSMTPClient
+}
+
+// newRelay returns a relay for rc. tlsPolicy, if set, replaces the
+// verified TLS a relay using STARTTLS requires.
+func newRelay(cfg *config.DeliveryConfig, rc *config.RelayConfig, tlsPolicy string) *relay {
+	policy := TLSPolicyRequired
+	switch {
+	case rc.TLS == "none":
+		policy = TLSPolicyNone
+	case rc.TLS == "starttls" && tlsPolicy != "":
+		policy = tlsPolicy
+	}
+	client := newClient(cfg)
+	setRelay(client, rc)
+	return &relay{
+		host:   rc.Host,
+		addr:   net.JoinHostPort(rc.Host, strconv.Itoa(rc.Port)),
+		policy: policy,
+		client: client,
+	}
+}
+
+// deliverRelay sends e to rcpts, all at domain, through r instead of
+// domain's MX hosts, which are never looked up.
+func (s *Service) deliverRelay(ctx context.Context, r *relay, e *email.Email, rcpts []string) error {
+	ctx = withTLSPolicy(ctx, r.policy)
+	var client SMTPClient = r.client
+	if s.sandboxed(e) {
+		client = s.sandbox
+	}
+	err := s.transact(ctx, r.addr, rcpts, func(ctx context.Context) error {
+		return client.Send(ctx, r.addr, e, rcpts)
+	})
+	if hostAnswered(err) {
+		logDelivered(ctx, r.addr, rcpts, err, "")
+		return err
+	}
+	return fmt.Errorf("relay %s failed: %w", r.addr, err)
+}
+
+// setRelay sets client up for cfg's relay. A CA file that can't be read is
+// logged and the system's CAs used instead.
+func setRelay(client *SimpleSMTPClient, cfg *config.RelayConfig) {
+	var roots *x509.CertPool
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err == nil {
+			roots = x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(pem) {
+				err = errors.New("no certificates found")
+			}
+		}
+		if err != nil {
+			log.Printf("ERROR failed to load relay CAs from %s: %v", cfg.CAFile, err)
+			roots = nil
+		}
+	}
+	client.SetRelay(cfg.Username, cfg.Password, cfg.TLS == "tls", roots)
+	
+	var tokens TokenSource
+	if cfg.OAuthToken != "" {
+		tokens = StaticToken(cfg.OAuthToken)
+	}
+	client.SetRelayAuth(cfg.Mechanisms, tokens)
+}
+
+// SetRelayTokenSource has every relay on host, the default one or a
+// route's, log in with tokens for XOAUTH2 instead of its configured
+// oauth_token, for example to refresh them from an OAuth2 provider. Call
+// it before Start.
+func (s *Service) SetRelayTokenSource(host string, tokens TokenSource) {
+	for _, r := range s.routes.relays() {
+		if strings.EqualFold(r.host, host) {
+			r.client.tokens = tokens
+		}
+	}
+}
+
+// SetRelay sets the client up for a smarthost. With a username it logs in
+// once TLS is established; with implicitTLS it starts TLS as soon as it
+// connects, as relays on port 465 expect. Certificates are verified
+// against roots, or the system's CAs if nil.
+func (c *SimpleSMTPClient) SetRelay(username, password string, implicitTLS bool, roots *x509.CertPool) {
+	c.username = username
+	c.password = password
+	c.implicitTLS = implicitTLS
+	c.rootCAs = roots
+}
+
+// SetRelayAuth sets the SASL mechanisms the client may log in with, in
+// order of preference, and where XOAUTH2 gets its token; see
+// config.RelayMechanisms. XOAUTH2 is skipped without tokens, and the
+// others without a password.
+func (c *SimpleSMTPClient) SetRelayAuth(mechanisms []string, tokens TokenSource) {
+	c.mechanisms = mechanisms
+	c.tokens = tokens
+}
+
+// login authenticates as the relay user with the first of the client's
+// mechanisms the server offers and the client can use.
+func (c *SimpleSMTPClient) login(ctx context.Context, client *smtp.Client, serverName string) error {
+	ok, offered := client.Extension("AUTH")
+	if !ok {
+		return fmt.Errorf("%w: %s does not offer AUTH", ErrRelayAuth, serverName)
+	}
+	
+	mechanisms := c.mechanisms
+	if len(mechanisms) == 0 {
+		mechanisms = config.RelayMechanisms
+	}
+	available := strings.Fields(strings.ToUpper(offered))
+	for _, mechanism := range mechanisms {
+		if !slices.Contains(available, mechanism) {
+			continue
+		}
+		auth, err := c.relayAuth(ctx, mechanism, serverName)
+		if err != nil {
+			return err
+		}
+		if auth == nil {
+			continue
+		}
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("%w with %s using %s: %w", ErrRelayAuth, serverName, mechanism, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s offers none of %s usable, only %s", ErrRelayAuth, serverName, strings.Join(mechanisms, ", "), offered)
+}
+
+// relayAuth returns mechanism for the relay user, or nil if the client
+// lacks what it needs.
+func (c *SimpleSMTPClient) relayAuth(ctx context.Context, mechanism, serverName string) (smtp.Auth, error) {
+	if mechanism == "XOAUTH2" {
+		if c.tokens == nil {
+			return nil, nil
+		}
+		token, err := c.tokens(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get an OAuth2 token for %s: %w", serverName, err)
+		}
+		return &xoauth2Auth{username: c.username, token: token}, nil
+	}
+	
+	if c.password == "" {
+		return nil, nil
+	}
+	switch mechanism {
+	case "PLAIN":
+		return smtp.PlainAuth("", c.username, c.password, serverName), nil
+	case "LOGIN":
+		return &loginAuth{username: c.username, password: c.password}, nil
+	case "CRAM-MD5":
+		return smtp.CRAMMD5Auth(c.username, c.password), nil
+	}
+	return nil, nil
+}
+
+// loginAuth is the LOGIN mechanism, which net/smtp lacks: the server asks
+// for the username and then the password.
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN prompt %q", fromServer)
+}
+
+// xoauth2Auth is Google's and Microsoft's XOAUTH2 mechanism, which sends
+// an OAuth2 bearer token instead of a password.
+type xoauth2Auth struct {
+	username, token string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+// Next answers the JSON error a server sends for a rejected token with
+// the empty response it expects before its final reply.
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		return []byte{}, nil
+	}
+	return nil, nil
+}
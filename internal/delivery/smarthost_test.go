@@ -0,0 +1,182 @@
+package delivery
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestService_Relay(t *testing.T) {
+	f := newDANEFixture(t, time.Now().Add(time.Hour))
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.ca.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	
+	tests := []struct {
+		name     string
+		server   *sinkServer
+		tls      string
+		password string
+		wantErr  bool
+	}{
+		{name: "PLAIN over STARTTLS", server: &sinkServer{tls: f.tls, auth: []string{"LOGIN", "PLAIN"}}, tls: "starttls", password: "secret"},
+		{name: "LOGIN over STARTTLS", server: &sinkServer{tls: f.tls, auth: []string{"LOGIN"}}, tls: "starttls", password: "secret"},
+		{name: "PLAIN over implicit TLS", server: &sinkServer{tls: f.tls, implicitTLS: true, auth: []string{"PLAIN"}}, tls: "tls", password: "secret"},
+		{name: "wrong password", server: &sinkServer{tls: f.tls, auth: []string{"PLAIN"}}, tls: "starttls", password: "wrong", wantErr: true},
+		{name: "no AUTH offered", server: &sinkServer{tls: f.tls}, tls: "starttls", password: "secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.server.user, tt.server.password = "relay-user", "secret"
+			sink := startSinkServer(t, tt.server)
+			host, port, _ := net.SplitHostPort(sink.addr())
+			portNum, _ := strconv.Atoi(port)
+			
+			cfg := &config.DeliveryConfig{
+				Workers:           1,
+				DNSCacheTTL:       5 * time.Minute,
+				ConnectionTimeout: 5 * time.Second,
+				Mode:              "relay",
+				Relay: config.RelayConfig{
+					Host:     host,
+					Port:     portNum,
+					Username: "relay-user",
+					Password: tt.password,
+					TLS:      tt.tls,
+					CAFile:   caFile,
+				},
+			}
+			service := NewService(cfg, queue.NewMemoryQueue(10))
+			// No MX records anywhere: relay mode must not look them up
+			service.resolver = &mockDNSResolver{}
+			
+			e := &email.Email{ID: "relay-1", From: "sender@test.com", To: []string{"rcpt@example.com", "rcpt@example.org"}, Subject: "Hi", Body: "Body", Status: email.StatusQueued}
+			_, err := service.processEmail(context.Background(), e)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Expected delivery through the relay, got %v", err)
+				}
+				if got := sink.messages.Load(); got != 2 {
+					t.Errorf("Expected one message per recipient domain, got %d", got)
+				}
+				return
+			}
+			if !errors.Is(err, ErrRelayAuth) || !permanent(err) {
+				t.Fatalf("Expected a permanent ErrRelayAuth, got %v", err)
+			}
+			if sink.messages.Load() != 0 {
+				t.Error("Expected nothing relayed")
+			}
+		})
+	}
+}
+
+func TestService_RelayRequiresTLS(t *testing.T) {
+	// The fixture's CA is not trusted without ca_file
+	f := newDANEFixture(t, time.Now().Add(time.Hour))
+	sink := startSinkServer(t, &sinkServer{tls: f.tls, auth: []string{"PLAIN"}, user: "relay-user", password: "secret"})
+	host, port, _ := net.SplitHostPort(sink.addr())
+	portNum, _ := strconv.Atoi(port)
+	
+	cfg := &config.DeliveryConfig{
+		ConnectionTimeout: 5 * time.Second,
+		Mode:              "relay",
+		Relay:             config.RelayConfig{Host: host, Port: portNum, Username: "relay-user", Password: "secret", TLS: "starttls"},
+	}
+	service := NewService(cfg, queue.NewMemoryQueue(10))
+	service.resolver = &mockDNSResolver{}
+	
+	e := &email.Email{ID: "relay-2", From: "sender@test.com", To: []string{"rcpt@example.com"}, Subject: "Hi", Body: "Body", Status: email.StatusQueued}
+	_, err := service.processEmail(context.Background(), e)
+	if !errors.Is(err, ErrTLSRequired) || permanent(err) {
+		t.Fatalf("Expected a temporary ErrTLSRequired, got %v", err)
+	}
+	if sink.messages.Load() != 0 {
+		t.Error("Expected nothing relayed")
+	}
+}
+
+func TestLoginAuth(t *testing.T) {
+	auth := &loginAuth{username: "user", password: "pass"}
+	for prompt, want := range map[string]string{"Username:": "user", "password:": "pass"} {
+		got, err := auth.Next([]byte(prompt), true)
+		if err != nil || string(got) != want {
+			t.Errorf("Next(%q) = %q, %v, want %q", prompt, got, err, want)
+		}
+	}
+	if _, err := auth.Next([]byte("Realm:"), true); err == nil {
+		t.Error("Expected an unknown prompt to fail")
+	}
+}
+
+func TestSMTPClient_RelayMechanisms(t *testing.T) {
+	f := newDANEFixture(t, time.Now().Add(time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(f.ca)
+	all := []string{"PLAIN", "LOGIN", "CRAM-MD5", "XOAUTH2"}
+	failingTokens := func(ctx context.Context) (string, error) {
+		return "", errors.New("token endpoint unreachable")
+	}
+	
+	tests := []struct {
+		name          string
+		offered       []string
+		mechanisms    []string
+		password      string
+		tokens        TokenSource
+		wantMechanism string
+		wantErr       error
+		wantPermanent bool
+	}{
+		{name: "XOAUTH2 preferred with a token", offered: all, password: "secret", tokens: StaticToken("token"), wantMechanism: "XOAUTH2"},
+		{name: "PLAIN without a token", offered: all, password: "secret", wantMechanism: "PLAIN"},
+		{name: "configured order", offered: all, mechanisms: []string{"CRAM-MD5", "PLAIN"}, password: "secret", wantMechanism: "CRAM-MD5"},
+		{name: "only LOGIN offered", offered: []string{"LOGIN"}, password: "secret", wantMechanism: "LOGIN"},
+		{name: "token only", offered: all, tokens: StaticToken("token"), mechanisms: []string{"PLAIN", "XOAUTH2"}, wantMechanism: "XOAUTH2"},
+		{name: "nothing in common", offered: []string{"CRAM-MD5"}, mechanisms: []string{"PLAIN"}, password: "secret", wantErr: ErrRelayAuth, wantPermanent: true},
+		{name: "rejected token", offered: all, tokens: StaticToken("expired"), wantErr: ErrRelayAuth, wantPermanent: true},
+		{name: "wrong CRAM-MD5 password", offered: []string{"CRAM-MD5"}, password: "wrong", wantErr: ErrRelayAuth, wantPermanent: true},
+		{name: "token refresh fails", offered: all, tokens: failingTokens},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := startSinkServer(t, &sinkServer{tls: f.tls, auth: tt.offered, user: "relay-user", password: "secret", token: "token"})
+			client := NewSMTPClient(5 * time.Second)
+			client.SetRelay("relay-user", tt.password, false, roots)
+			client.SetRelayAuth(tt.mechanisms, tt.tokens)
+			
+			ctx := withTLSPolicy(context.Background(), TLSPolicyRequired)
+			err := client.Send(ctx, sink.addr(), poolTestEmail(), []string{"rcpt@test.com"})
+			if tt.wantMechanism != "" {
+				if err != nil {
+					t.Fatalf("Expected a login, got %v", err)
+				}
+				if got, _ := sink.mechanism.Load().(string); got != tt.wantMechanism {
+					t.Errorf("Expected %s, got %s", tt.wantMechanism, got)
+				}
+				return
+			}
+			if err == nil || sink.messages.Load() != 0 {
+				t.Fatal("Expected the login to fail")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if permanent(err) != tt.wantPermanent {
+				t.Errorf("Expected permanent %v, got %v", tt.wantPermanent, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package delivery
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// Strategies for choosing a source IP; see
+// config.DeliveryConfig.SourceIPStrategy.
+const (
+	SourceIPFixed      = "fixed"
+	SourceIPRoundRobin = "round-robin"
+	SourceIPPerDomain  = "per-domain"
+)
+
+// sourceIPs are the local addresses outbound connections are bound to.
+type sourceIPs struct {
+	ips      []net.IP
+	strategy string
+	next     atomic.Uint64
+}
+
+// newSourceIPs returns the addresses in ips chosen by strategy, or nil if
+// there are none and the OS chooses. Addresses that don't parse, which
+// config validation rejects, are skipped.
+func newSourceIPs(ips []string, strategy string) *sourceIPs {
+	p := &sourceIPs{strategy: strategy}
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			p.ips = append(p.ips, parsed)
+		}
+	}
+	if len(p.ips) == 0 {
+		return nil
+	}
+	return p
+}
+
+// pick returns the address to connect to host from, nil to let the OS
+// choose.
+func (p *sourceIPs) pick(host string) net.IP {
+	if p == nil {
+		return nil
+	}
+	switch p.strategy {
+	case SourceIPRoundRobin:
+		return p.ips[(p.next.Add(1)-1)%uint64(len(p.ips))]
+	case SourceIPPerDomain:
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			host = name
+		}
+		h := fnv.New32a()
+		h.Write([]byte(strings.ToLower(host)))
+		return p.ips[h.Sum32()%uint32(len(p.ips))]
+	}
+	return p.ips[0]
+}
+
+// check binds to each address, so one the host doesn't have fails at
+// startup rather than on every delivery.
+func (p *sourceIPs) check() error {
+	if p == nil {
+		return nil
+	}
+	for _, ip := range p.ips {
+		l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+		if err != nil {
+			return fmt.Errorf("cannot bind to source IP %s: %w", ip, err)
+		}
+		l.Close()
+	}
+	return nil
+}
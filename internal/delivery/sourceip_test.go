@@ -0,0 +1,93 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestSourceIPs_Pick(t *testing.T) {
+	ips := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+	
+	if ip := newSourceIPs(nil, SourceIPRoundRobin).pick("mx.example.com"); ip != nil {
+		t.Errorf("Expected no source IP without any configured, got %s", ip)
+	}
+	
+	fixed := newSourceIPs(ips, SourceIPFixed)
+	for i := 0; i < 3; i++ {
+		if ip := fixed.pick("mx.example.com"); ip.String() != "192.0.2.1" {
+			t.Fatalf("Expected the first IP every time, got %s", ip)
+		}
+	}
+	
+	rr := newSourceIPs(ips, SourceIPRoundRobin)
+	for i := 0; i < 6; i++ {
+		if ip := rr.pick("mx.example.com"); ip.String() != ips[i%3] {
+			t.Fatalf("Pick %d = %s, want %s", i, ip, ips[i%3])
+		}
+	}
+	
+	sticky := newSourceIPs(ips, SourceIPPerDomain)
+	seen := make(map[string]bool)
+	for _, host := range []string{"mx1.example.com", "mx2.example.com", "mx.example.org", "mx.example.net", "smtp.partner.com"} {
+		want := sticky.pick(host)
+		seen[want.String()] = true
+		for _, variant := range []string{host, host + ":25", "MX" + host[2:]} {
+			if ip := sticky.pick(variant); !ip.Equal(want) {
+				t.Errorf("pick(%q) = %s, want %s as for %s", variant, ip, want, host)
+			}
+		}
+	}
+	if len(seen) < 2 {
+		t.Errorf("Expected hosts spread across the IPs, all got %v", seen)
+	}
+}
+
+func TestService_SourceIPs(t *testing.T) {
+	sink := startSinkServer(t, &sinkServer{})
+	cfg := &config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 5 * time.Second,
+		SourceIPs:         []string{"127.0.0.1"},
+		SourceIPStrategy:  SourceIPFixed,
+	}
+	service := newSourceIPTestService(cfg, sink)
+	
+	var result Result
+	service.SetResultHook(func(r Result) { result = r })
+	e := &email.Email{ID: "source-1", From: "sender@test.com", To: []string{"rcpt@example.com"}, Subject: "Hi", Body: "Body", Status: email.StatusQueued}
+	service.deliver(context.Background(), e)
+	if result.Status != email.StatusDelivered {
+		t.Fatalf("Expected delivery, got %s: %v", result.Status, result.Err)
+	}
+	if len(result.Transactions) != 1 || result.Transactions[0].SourceIP != "127.0.0.1" {
+		t.Errorf("Expected the transaction to record source IP 127.0.0.1, got %+v", result.Transactions)
+	}
+	
+	// An address this host doesn't have stops the service starting
+	cfg.SourceIPs = []string{"127.0.0.1", "192.0.2.1"}
+	service = newSourceIPTestService(cfg, sink)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := service.Start(ctx); err == nil {
+		t.Fatal("Expected Start to fail binding 192.0.2.1")
+	}
+	if ctx.Err() != nil {
+		t.Error("Expected Start to fail at once")
+	}
+}
+
+func newSourceIPTestService(cfg *config.DeliveryConfig, sink *sinkServer) *Service {
+	service := NewService(cfg, newMockQueue())
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: sink.addr(), Pref: 10}},
+		},
+	}
+	return service
+}
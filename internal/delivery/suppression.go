@@ -0,0 +1,92 @@
+package delivery
+
+import (
+	"context"
+	"strings"
+	"sync"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// SuppressionList reports addresses that must no longer receive mail, such
+// as those that unsubscribed or hard bounced.
+type SuppressionList interface {
+	Suppressed(ctx context.Context, address string) (bool, error)
+}
+
+// SuppressionHook re-checks recipients against a suppression list that may
+// have grown since the email was queued. Suppressed recipients are marked
+// as such and left out of the attempt; if none are left the email is
+// aborted.
+//
+// Installing the hook with SetPreDeliveryHooks is the same as calling
+// SetSuppressionList with List: the check runs once, in the pre-send gate
+// after the other hooks, which also records the dropped recipients in the
+// queue so retries do not look them up again.
+type SuppressionHook struct {
+	List SuppressionList
+}
+
+func (h SuppressionHook) Name() string {
+	return SuppressedBy
+}
+
+func (h SuppressionHook) Modify(ctx context.Context, e *email.Email) (Action, error) {
+	suppressed, pending, err := suppressedRecipients(ctx, h.List, e)
+	if err != nil {
+		return Action{}, err
+	}
+	if len(suppressed) == 0 {
+		return Proceed(), nil
+	}
+	
+	markRecipients(e, suppressed)
+	if pending == 0 {
+		return Abort("all recipients are suppressed"), nil
+	}
+	return Proceed(), nil
+}
+
+// SuppressionSet is an in-memory SuppressionList. Addresses match
+// case-insensitively.
+type SuppressionSet struct {
+	mu    sync.RWMutex
+	addrs map[string]bool
+}
+
+// NewSuppressionSet creates a set holding addrs.
+func NewSuppressionSet(addrs ...string) *SuppressionSet {
+	s := &SuppressionSet{addrs: make(map[string]bool, len(addrs))}
+	for _, addr := range addrs {
+		s.addrs[strings.ToLower(addr)] = true
+	}
+	return s
+}
+
+// Add suppresses addr.
+func (s *SuppressionSet) Add(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addrs[strings.ToLower(addr)] = true
+}
+
+// Remove lifts the suppression of addr.
+func (s *SuppressionSet) Remove(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.addrs, strings.ToLower(addr))
+}
+
+func (s *SuppressionSet) Suppressed(ctx context.Context, addr string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.addrs[strings.ToLower(addr)], nil
+}
+
+// SetSuppressionList makes the service check every outstanding recipient
+// against list just before sending. Lookups run on every attempt, so list
+// should answer from an index rather than a scan. This is the server's one
+// suppression re-check; a SuppressionHook installs its list here.
+func (s *Service) SetSuppressionList(list SuppressionList) {
+	s.suppression = list
+}
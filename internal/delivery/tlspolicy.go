@@ -0,0 +1,110 @@
+package delivery
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+)
+
+// TLS policies for delivery to a recipient domain; see
+// config.DeliveryConfig.TLSPolicy. The policy a transaction used is
+// recorded in its Transaction.
+const (
+	TLSPolicyRequired            = "required"
+	TLSPolicyOpportunisticVerify = "opportunistic-verify"
+	TLSPolicyOpportunistic       = "opportunistic"
+	TLSPolicyNone                = "none"
+	
+	// A host with usable TLSA records must present a certificate
+	// matching them, whatever the domain's policy
+	TLSPolicyDANE = "dane"
+)
+
+// tlsVersions maps config.DeliveryConfig.TLSMinVersion to crypto/tls.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsPolicies are the configured TLS policies: one per domain, and a
+// default for the rest.
+type tlsPolicies struct {
+	fallback string
+	domains  map[string]string
+}
+
+func newTLSPolicies(cfg *config.DeliveryConfig) *tlsPolicies {
+	p := &tlsPolicies{
+		fallback: cfg.TLSPolicy,
+		domains:  make(map[string]string),
+	}
+	if p.fallback == "" {
+		p.fallback = TLSPolicyOpportunistic
+	}
+	for domain, policy := range cfg.TLSPolicies {
+		p.domains[strings.ToLower(domain)] = policy
+	}
+	return p
+}
+
+// policy returns domain's TLS policy.
+func (p *tlsPolicies) policy(domain string) string {
+	if p == nil {
+		return TLSPolicyOpportunistic
+	}
+	if policy, ok := p.domains[domain]; ok {
+		return policy
+	}
+	return p.fallback
+}
+
+type tlsPolicyKey struct{}
+
+// withTLSPolicy returns ctx carrying the TLS policy for SMTP sessions
+// opened with it.
+func withTLSPolicy(ctx context.Context, policy string) context.Context {
+	return context.WithValue(ctx, tlsPolicyKey{}, policy)
+}
+
+// tlsPolicy is ctx's TLS policy, opportunistic if it has none.
+func tlsPolicy(ctx context.Context) string {
+	if policy, ok := ctx.Value(tlsPolicyKey{}).(string); ok {
+		return policy
+	}
+	return TLSPolicyOpportunistic
+}
+
+// requireTLS marks ctx so that SMTP sessions opened with it fail with
+// ErrTLSRequired unless STARTTLS succeeds with a verified certificate.
+func requireTLS(ctx context.Context) context.Context {
+	return withTLSPolicy(ctx, TLSPolicyRequired)
+}
+
+// SetTLSMinVersion sets the oldest TLS version accepted from servers, one
+// of the crypto/tls version constants. Zero leaves crypto/tls's default.
+func (c *SimpleSMTPClient) SetTLSMinVersion(version uint16) {
+	c.minTLSVersion = version
+}
+
+// tlsConfig is the configuration for STARTTLS with serverName under
+// policy. Opportunistic TLS encrypts without authenticating the server,
+// since a certificate that fails to verify would otherwise leave the
+// session in plaintext.
+func (c *SimpleSMTPClient) tlsConfig(serverName, policy string, tlsa []TLSARecord) *tls.Config {
+	var config *tls.Config
+	switch policy {
+	case TLSPolicyDANE:
+		config = daneTLSConfig(serverName, tlsa)
+	case TLSPolicyOpportunistic:
+		config = &tls.Config{ServerName: serverName, InsecureSkipVerify: true}
+	default:
+		config = &tls.Config{ServerName: serverName}
+	}
+	config.MinVersion = c.minTLSVersion
+	config.RootCAs = c.rootCAs
+	return config
+}
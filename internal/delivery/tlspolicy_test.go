@@ -0,0 +1,98 @@
+package delivery
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+)
+
+func TestSMTPClient_TLSPolicy(t *testing.T) {
+	// The fixture's certificate is not trusted, so it never verifies
+	f := newDANEFixture(t, time.Now().Add(time.Hour))
+	untrusted := startSinkServer(t, &sinkServer{tls: f.tls})
+	plain := newSinkServer(t, false)
+	
+	tests := []struct {
+		name          string
+		server        *sinkServer
+		policy        string
+		wantDelivered bool
+		wantTLS       bool
+	}{
+		{name: "opportunistic, unverified TLS", server: untrusted, policy: TLSPolicyOpportunistic, wantDelivered: true, wantTLS: true},
+		{name: "opportunistic, no STARTTLS", server: plain, policy: TLSPolicyOpportunistic, wantDelivered: true},
+		{name: "opportunistic-verify, unverified TLS", server: untrusted, policy: TLSPolicyOpportunisticVerify},
+		{name: "opportunistic-verify, no STARTTLS", server: plain, policy: TLSPolicyOpportunisticVerify, wantDelivered: true},
+		{name: "required, unverified TLS", server: untrusted, policy: TLSPolicyRequired},
+		{name: "required, no STARTTLS", server: plain, policy: TLSPolicyRequired},
+		{name: "none", server: untrusted, policy: TLSPolicyNone, wantDelivered: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := poolTestEmail()
+			ctx, a := withAttempt(withTLSPolicy(context.Background(), tt.policy), e)
+			a.txns = append(a.txns, Transaction{Host: tt.server.addr()})
+			
+			messages := tt.server.messages.Load()
+			err := NewSMTPClient(5*time.Second).Send(ctx, tt.server.addr(), e, []string{"rcpt@test.com"})
+			delivered := tt.server.messages.Load() > messages
+			if !tt.wantDelivered {
+				if delivered || !errors.Is(err, ErrTLSRequired) || permanent(err) {
+					t.Fatalf("Expected a temporary ErrTLSRequired without delivery, got %v", err)
+				}
+				return
+			}
+			if err != nil || !delivered {
+				t.Fatalf("Expected delivery, got %v", err)
+			}
+			txn := a.transactions()[0]
+			if txn.TLS != tt.wantTLS || txn.TLSPolicy != tt.policy {
+				t.Errorf("Expected TLS %v under %s, got %+v", tt.wantTLS, tt.policy, txn)
+			}
+		})
+	}
+}
+
+func TestSMTPClient_TLSPolicyPooled(t *testing.T) {
+	f := newDANEFixture(t, time.Now().Add(time.Hour))
+	server := startSinkServer(t, &sinkServer{tls: f.tls})
+	client := NewSMTPClient(5 * time.Second)
+	client.SetPool(10, time.Minute)
+	defer client.Close()
+	
+	// A session opened without TLS is not reused for a domain that needs it
+	ctx := withTLSPolicy(context.Background(), TLSPolicyNone)
+	if err := client.Send(ctx, server.addr(), poolTestEmail(), []string{"rcpt@test.com"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx = withTLSPolicy(context.Background(), TLSPolicyOpportunistic)
+	if err := client.Send(ctx, server.addr(), poolTestEmail(), []string{"rcpt@test.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.dials.Load(); got != 2 {
+		t.Errorf("Expected a session per policy, got %d connections", got)
+	}
+}
+
+func TestTLSPolicies(t *testing.T) {
+	p := newTLSPolicies(&config.DeliveryConfig{
+		TLSPolicy:   TLSPolicyOpportunisticVerify,
+		TLSPolicies: map[string]string{"Bank.example.com": TLSPolicyRequired},
+	})
+	if got := p.policy("bank.example.com"); got != TLSPolicyRequired {
+		t.Errorf("Expected the domain's own policy, got %s", got)
+	}
+	if got := p.policy("example.com"); got != TLSPolicyOpportunisticVerify {
+		t.Errorf("Expected the default policy, got %s", got)
+	}
+	
+	client := NewSMTPClient(time.Second)
+	client.SetTLSMinVersion(tlsVersions["1.3"])
+	if cfg := client.tlsConfig("mx.example.com", TLSPolicyRequired, nil); cfg.MinVersion != tls.VersionTLS13 || cfg.InsecureSkipVerify {
+		t.Errorf("Expected a verifying TLS 1.3 config, got %+v", cfg)
+	}
+}
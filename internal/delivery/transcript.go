@@ -0,0 +1,246 @@
+package delivery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+)
+
+// Bounds on a transaction's transcript: it stops once maxTranscript bytes
+// of lines are kept, and a longer line is cut at maxTranscriptLine.
+const (
+	maxTranscript     = 64 * 1024
+	maxTranscriptLine = 512
+)
+
+// transcript records one SMTP transaction of an email sent with Debug,
+// as lines prefixed "C: " for what the client sent and "S: " for what
+// the server replied. The message body is replaced by its size, and
+// everything sent while logging in after the AUTH mechanism is redacted.
+type transcript struct {
+	mu      sync.Mutex
+	lines   []string
+	size    int
+	full    bool
+	partial [2][]byte
+	
+	// Set once the server agrees to STARTTLS, when the bytes on the
+	// connection are no longer readable and the SMTP client's reader and
+	// writer are followed instead
+	encrypted bool
+	
+	wantTLS  bool
+	wantData bool
+	inData   bool
+	body     int
+	inAuth   bool
+}
+
+// Sides of the exchange a line comes from.
+const (
+	sideClient = iota
+	sideServer
+)
+
+type transcriptKey struct{}
+
+func withTranscript(ctx context.Context, t *transcript) context.Context {
+	return context.WithValue(ctx, transcriptKey{}, t)
+}
+
+func transcriptFrom(ctx context.Context) *transcript {
+	t, _ := ctx.Value(transcriptKey{}).(*transcript)
+	return t
+}
+
+// wrap returns conn recording what passes over it until TLS starts.
+func (t *transcript) wrap(conn net.Conn) net.Conn {
+	return &transcriptConn{Conn: conn, t: t}
+}
+
+// follow records the exchange over client from now on, as its connection
+// has been upgraded to TLS.
+func (t *transcript) follow(client *smtp.Client) {
+	t.mu.Lock()
+	t.encrypt()
+	t.add("-- TLS started --")
+	t.mu.Unlock()
+	
+	client.Text.Reader.R = bufio.NewReader(io.TeeReader(client.Text.Reader.R, transcriptWriter{t: t, side: sideServer}))
+	client.Text.Writer.W = bufio.NewWriter(&flushWriter{w: client.Text.Writer.W, tee: transcriptWriter{t: t, side: sideClient}})
+}
+
+// recorded returns the transcript so far.
+func (t *transcript) recorded() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.lines...)
+}
+
+// write records p, sent by side, a line at a time. Bytes on the raw
+// connection are ignored once TLS has started unless followed is set.
+func (t *transcript) write(side int, p []byte, followed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.encrypted != followed {
+		return
+	}
+	buf := append(t.partial[side], p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(buf[:i]), "\r")
+		buf = buf[i+1:]
+		if side == sideClient {
+			t.client(line)
+		} else {
+			t.server(line)
+		}
+		if t.encrypted != followed {
+			// What follows is the TLS handshake
+			return
+		}
+	}
+	t.partial[side] = append([]byte(nil), buf...)
+}
+
+// client records a line the client sent.
+func (t *transcript) client(line string) {
+	switch {
+	case t.inData:
+		if line != "." {
+			t.body += len(line) + 2
+			return
+		}
+		t.inData = false
+		t.add(fmt.Sprintf("C: [message body, %d bytes]", t.body))
+		t.add("C: .")
+		return
+		
+	case t.inAuth:
+		t.add("C: [redacted]")
+		return
+	}
+	
+	command := strings.ToUpper(line)
+	switch {
+	case strings.HasPrefix(command, "AUTH "):
+		t.inAuth = true
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			line = fields[0] + " " + fields[1] + " [redacted]"
+		}
+	case command == "DATA":
+		t.wantData = true
+	case command == "STARTTLS":
+		t.wantTLS = true
+	}
+	t.add("C: " + line)
+}
+
+// server records a line the server replied with. A reply ends the login
+// unless it asks for more, 354 to DATA starts the message body, and 220
+// to STARTTLS the TLS handshake.
+func (t *transcript) server(line string) {
+	t.add("S: " + line)
+	if len(line) > 3 && line[3] == '-' {
+		return
+	}
+	code := line
+	if len(code) > 3 {
+		code = code[:3]
+	}
+	if code != "334" {
+		t.inAuth = false
+	}
+	if t.wantData {
+		t.wantData = false
+		t.inData = code == "354"
+		t.body = 0
+	}
+	if t.wantTLS {
+		t.wantTLS = false
+		if code == "220" {
+			t.encrypt()
+		}
+	}
+}
+
+// encrypt stops recording the connection's bytes, and drops any partial
+// lines read from it.
+func (t *transcript) encrypt() {
+	t.encrypted = true
+	t.partial = [2][]byte{}
+}
+
+// add keeps line, cut to maxTranscriptLine, unless the transcript is
+// full.
+func (t *transcript) add(line string) {
+	if t.full {
+		return
+	}
+	if len(line) > maxTranscriptLine {
+		line = line[:maxTranscriptLine] + "..."
+	}
+	if t.size+len(line) > maxTranscript {
+		t.full = true
+		t.lines = append(t.lines, "-- transcript truncated --")
+		return
+	}
+	t.size += len(line)
+	t.lines = append(t.lines, line)
+}
+
+// transcriptConn is a connection whose traffic is recorded in t.
+type transcriptConn struct {
+	net.Conn
+	t *transcript
+}
+
+func (c *transcriptConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.t.write(sideServer, p[:n], false)
+	return n, err
+}
+
+func (c *transcriptConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.t.write(sideClient, p[:n], false)
+	return n, err
+}
+
+// transcriptWriter records what is written to it as sent by side, once
+// TLS has started.
+type transcriptWriter struct {
+	t    *transcript
+	side int
+}
+
+func (w transcriptWriter) Write(p []byte) (int, error) {
+	w.t.write(w.side, p, true)
+	return len(p), nil
+}
+
+// flushWriter passes writes on to w at once, so the SMTP client's own
+// flushes still reach the connection, and records them in tee.
+type flushWriter struct {
+	w   *bufio.Writer
+	tee io.Writer
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = f.w.Flush()
+	}
+	f.tee.Write(p[:n])
+	return n, err
+}
@@ -0,0 +1,120 @@
+package delivery
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestSMTPClient_Transcript(t *testing.T) {
+	f := newDANEFixture(t, time.Now().Add(time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(f.ca)
+	secrets := []string{
+		"secret",
+		base64.StdEncoding.EncodeToString([]byte("secret")),
+		base64.StdEncoding.EncodeToString([]byte("\x00relay-user\x00secret")),
+	}
+	
+	for _, mechanism := range []string{"PLAIN", "LOGIN"} {
+		t.Run(mechanism, func(t *testing.T) {
+			sink := startSinkServer(t, &sinkServer{tls: f.tls, auth: []string{mechanism}, user: "relay-user", password: "secret"})
+			client := NewSMTPClient(5 * time.Second)
+			client.SetRelay("relay-user", "secret", false, roots)
+			client.SetRelayAuth([]string{mechanism}, nil)
+			client.SetPool(2, time.Minute)
+			defer client.Close()
+			
+			tr := &transcript{}
+			ctx := withTranscript(withTLSPolicy(context.Background(), TLSPolicyRequired), tr)
+			if err := client.Send(ctx, sink.addr(), poolTestEmail(), []string{"rcpt@test.com"}); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			
+			text := strings.Join(tr.recorded(), "\n")
+			for _, want := range []string{"S: 220 ", "C: STARTTLS", "-- TLS started --", "C: AUTH " + mechanism, "C: MAIL FROM:", "C: DATA", "S: 354", "C: [message body, ", "C: QUIT"} {
+				if !strings.Contains(text, want) {
+					t.Errorf("Expected %q in the transcript:\n%s", want, text)
+				}
+			}
+			for _, secret := range secrets {
+				if strings.Contains(text, secret) {
+					t.Errorf("Expected credentials redacted, found %q:\n%s", secret, text)
+				}
+			}
+			if strings.Contains(text, "Subject:") {
+				t.Errorf("Expected the message body left out:\n%s", text)
+			}
+			if sink.quits.Load() != 1 {
+				t.Error("Expected a session of its own, not a pooled one")
+			}
+		})
+	}
+}
+
+func TestTranscript_Bounded(t *testing.T) {
+	tr := &transcript{}
+	conn := tr.wrap(discardConn{})
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(conn, "RCPT TO:<rcpt-%d@example.com>\r\n", i)
+	}
+	fmt.Fprintf(conn, "NOOP %s\r\n", strings.Repeat("x", 2*maxTranscriptLine))
+	
+	lines := tr.recorded()
+	if size := len(strings.Join(lines, "")); size > maxTranscript+100 {
+		t.Errorf("Expected the transcript bounded to %d bytes, got %d", maxTranscript, size)
+	}
+	if lines[len(lines)-1] != "-- transcript truncated --" {
+		t.Errorf("Expected the transcript marked truncated, ends %q", lines[len(lines)-1])
+	}
+	
+	tr = &transcript{}
+	fmt.Fprintf(tr.wrap(discardConn{}), "NOOP %s\r\n", strings.Repeat("x", 2*maxTranscriptLine))
+	if lines := tr.recorded(); len(lines) != 1 || len(lines[0]) > maxTranscriptLine+3 {
+		t.Errorf("Expected the long line cut, got %d lines", len(lines))
+	}
+}
+
+// discardConn is a connection that accepts every write.
+type discardConn struct{ net.Conn }
+
+func (discardConn) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestService_DebugTranscript(t *testing.T) {
+	sink := newSinkServer(t, false)
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 5 * time.Second,
+	}, newMockQueue())
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: sink.addr(), Pref: 10}},
+		},
+	}
+	var result Result
+	service.SetResultHook(func(r Result) { result = r })
+	
+	for _, debug := range []bool{true, false} {
+		e := &email.Email{ID: fmt.Sprint("debug-", debug), From: "sender@test.com", To: []string{"rcpt@example.com"}, Subject: "Hi", Body: "Body", Status: email.StatusQueued, Debug: debug}
+		service.deliver(context.Background(), e)
+		if result.Status != email.StatusDelivered || len(result.Attempts) != 1 {
+			t.Fatalf("Expected delivery, got %s: %v", result.Status, result.Err)
+		}
+		transcript := result.Attempts[0].Transcript
+		if debug && (!strings.HasPrefix(transcript, "S: 220 ") || !strings.Contains(transcript, "C: RCPT TO:<rcpt@example.com>")) {
+			t.Errorf("Expected the SMTP exchange recorded, got:\n%s", transcript)
+		}
+		if !debug && transcript != "" {
+			t.Errorf("Expected no transcript without debug, got:\n%s", transcript)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// DeferredWarmup is the DeferredReason of emails held until the next day
+// because a recipient domain has used up the day's warm-up cap.
+const DeferredWarmup = "warmup_limit"
+
+// WarmupStats is the state of the warm-up schedule on its Day, 1 for the
+// first: the cap per recipient domain and what each domain has been sent
+// today. Active is false once the schedule has run out.
+type WarmupStats struct {
+	Active     bool           `json:"active"`
+	Start      string         `json:"start"`
+	Day        int            `json:"day"`
+	Days       int            `json:"days"`
+	DailyLimit int            `json:"daily_limit,omitempty"`
+	Domains    []DomainWarmup `json:"domains,omitempty"`
+}
+
+// DomainWarmup is one recipient domain's use of today's warm-up cap.
+type DomainWarmup struct {
+	Domain    string `json:"domain"`
+	Sent      int    `json:"sent"`
+	Remaining int    `json:"remaining"`
+}
+
+// warmupSaveInterval is how often changed warm-up counts are saved to the
+// state file while delivery runs.
+const warmupSaveInterval = 10 * time.Second
+
+// warmup caps the mail each recipient domain gets per day, following a
+// schedule from its start date. Today's counts are saved to path, if
+// set, every warmupSaveInterval and when delivery stops.
+type warmup struct {
+	start    time.Time
+	schedule []int
+	path     string
+	
+	mu    sync.Mutex
+	date  string
+	sent  map[string]int
+	dirty bool
+}
+
+// warmupState is what the state file holds: the counts of one day.
+type warmupState struct {
+	Date string         `json:"date"`
+	Sent map[string]int `json:"sent"`
+}
+
+// newWarmup returns the warm-up cfg configures, or nil if there is none.
+// A start date that does not parse disables it; Validate reports it. A
+// state file that can't be read is logged and replaced on the next save.
+func newWarmup(cfg *config.DeliveryConfig) *warmup {
+	if len(cfg.Warmup.Schedule) == 0 {
+		return nil
+	}
+	start, err := time.Parse(config.WarmupDateFormat, cfg.Warmup.Start)
+	if err != nil {
+		return nil
+	}
+	w := &warmup{
+		start:    start,
+		schedule: cfg.Warmup.Schedule,
+		path:     cfg.Warmup.StateFile,
+		sent:     make(map[string]int),
+	}
+	if err := w.load(); err != nil {
+		log.Printf("ERROR failed to load warm-up counts from %s: %v", w.path, err)
+	}
+	return w
+}
+
+// load reads the counts saved at the path, keeping them if they are for
+// today.
+func (w *warmup) load() error {
+	if w.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(w.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state warmupState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if state.Date == dateOf(time.Now()) && state.Sent != nil {
+		w.date, w.sent = state.Date, state.Sent
+	}
+	return nil
+}
+
+// dateOf is the UTC date of t, which warm-up days are counted in.
+func dateOf(t time.Time) string {
+	return t.UTC().Format(config.WarmupDateFormat)
+}
+
+// day is the index in the schedule of the day now falls on. Mail sent
+// before the start date is held to the first day's cap.
+func (w *warmup) day(now time.Time) int {
+	if now.Before(w.start) {
+		return 0
+	}
+	return int(now.Sub(w.start) / (24 * time.Hour))
+}
+
+// limit returns the cap per domain at now, or false once the schedule
+// has run out.
+func (w *warmup) limit(now time.Time) (int, bool) {
+	day := w.day(now)
+	if day >= len(w.schedule) {
+		return 0, false
+	}
+	return w.schedule[day], true
+}
+
+// today starts counting afresh if the day has changed since the last
+// send. Callers must hold w.mu.
+func (w *warmup) today(now time.Time) {
+	if date := dateOf(now); w.date != date {
+		w.date = date
+		w.sent = make(map[string]int)
+		w.dirty = true
+	}
+}
+
+// capped returns the first of domains to have used up today's cap and how
+// long until the next day, or "" if all of them are under it. Nothing is
+// counted: sends are counted by count once a server has accepted them, so
+// workers delivering at the same time can overshoot the cap by a few.
+func (w *warmup) capped(domains []string, now time.Time) (string, time.Duration) {
+	limit, ok := w.limit(now)
+	if !ok {
+		return "", 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	
+	w.today(now)
+	for _, domain := range domains {
+		if w.sent[domain] >= limit {
+			tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+			return domain, tomorrow.Sub(now)
+		}
+	}
+	return "", 0
+}
+
+// count records one send to domain today.
+func (w *warmup) count(domain string, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	
+	w.today(now)
+	w.sent[domain]++
+	w.dirty = true
+}
+
+// run saves changed counts every warmupSaveInterval until ctx is done.
+func (w *warmup) run(ctx context.Context) {
+	ticker := time.NewTicker(warmupSaveInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.flush()
+		}
+	}
+}
+
+// flush saves the counts if they have changed since the last save,
+// logging a failure; the counts are kept regardless.
+func (w *warmup) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	
+	if !w.dirty {
+		return
+	}
+	if err := w.save(); err != nil {
+		log.Printf("ERROR failed to save warm-up counts to %s: %v", w.path, err)
+		return
+	}
+	w.dirty = false
+}
+
+// save writes today's counts to the state file. Callers must hold w.mu.
+func (w *warmup) save() error {
+	if w.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(warmupState{Date: w.date, Sent: w.sent})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(w.path, data)
+}
+
+func (w *warmup) stats(now time.Time) WarmupStats {
+	limit, ok := w.limit(now)
+	stats := WarmupStats{
+		Active:     ok,
+		Start:      w.start.Format(config.WarmupDateFormat),
+		Day:        w.day(now) + 1,
+		Days:       len(w.schedule),
+		DailyLimit: limit,
+	}
+	if !ok {
+		return stats
+	}
+	
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.today(now)
+	for domain, sent := range w.sent {
+		stats.Domains = append(stats.Domains, DomainWarmup{Domain: domain, Sent: sent, Remaining: max(limit-sent, 0)})
+	}
+	sort.Slice(stats.Domains, func(i, j int) bool { return stats.Domains[i].Domain < stats.Domains[j].Domain })
+	return stats
+}
+
+// WarmupStats reports the warm-up schedule's state, or nil if none is
+// configured.
+func (s *Service) WarmupStats() *WarmupStats {
+	if s.warmup == nil {
+		return nil
+	}
+	stats := s.warmup.stats(time.Now())
+	return &stats
+}
+
+// warmupLimited checks e's recipient domains against today's warm-up cap.
+// If one has used it up it holds e until the next day, without an
+// attempt, and returns true.
+func (s *Service) warmupLimited(ctx, resultCtx context.Context, e *email.Email) bool {
+	if s.warmup == nil {
+		return false
+	}
+	domains, ok := pendingDomains(e)
+	if !ok {
+		return false
+	}
+	domain, wait := s.warmup.capped(domains, time.Now())
+	if domain == "" {
+		return false
+	}
+	wait = max(wait, minThrottleDelay)
+	logctx.Printf(ctx, "Warm-up cap for %s reached, holding email for %s", domain, wait.Round(time.Second))
+	s.postponeWithReason(resultCtx, e, DeferredWarmup, wait)
+	return true
+}
+
+// countWarmup counts a send to domain against today's warm-up cap if its
+// server accepted any of rcpts. Deferrals and refusals leave the budget
+// for the retry.
+func (s *Service) countWarmup(domain string, rcpts []string, results map[string]email.RecipientStatus) {
+	if s.warmup == nil {
+		return
+	}
+	for _, rcpt := range rcpts {
+		if results[rcpt].Status == email.StatusDelivered {
+			s.warmup.count(domain, time.Now())
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestWarmup(t *testing.T) {
+	// Saved counts are loaded against the real clock
+	now := time.Now()
+	cfg := &config.DeliveryConfig{Warmup: config.WarmupConfig{
+		Start:     dateOf(now),
+		Schedule:  []int{2, 5},
+		StateFile: filepath.Join(t.TempDir(), "warmup.json"),
+	}}
+	w := newWarmup(cfg)
+	
+	for i := 0; i < 2; i++ {
+		if domain, _ := w.capped([]string{"example.com"}, now); domain != "" {
+			t.Fatalf("Expected send %d within the cap, got %q", i+1, domain)
+		}
+		w.count("example.com", now)
+	}
+	domain, wait := w.capped([]string{"other.com", "example.com"}, now)
+	tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	if domain != "example.com" || wait != tomorrow.Sub(now) {
+		t.Errorf("Expected example.com capped until tomorrow, got %q, %s", domain, wait)
+	}
+	if stats := w.stats(now); len(stats.Domains) != 1 || stats.Domains[0].Sent != 2 || stats.Domains[0].Remaining != 0 {
+		t.Errorf("Expected nothing counted for a capped send, got %+v", stats.Domains)
+	}
+	
+	// Counts are only written when flushed, and a restart keeps them
+	if _, err := os.Stat(cfg.Warmup.StateFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no state file before a flush, got %v", err)
+	}
+	w.flush()
+	w = newWarmup(cfg)
+	if domain, _ := w.capped([]string{"example.com"}, now); domain != "example.com" {
+		t.Errorf("Expected the cap to survive a restart, got %q", domain)
+	}
+	
+	// The next day has a fresh, larger budget
+	if domain, _ := w.capped([]string{"example.com"}, tomorrow); domain != "" {
+		t.Errorf("Expected room the next day, got %q", domain)
+	}
+	w.count("example.com", tomorrow)
+	if stats := w.stats(tomorrow); !stats.Active || stats.Day != 2 || stats.DailyLimit != 5 || stats.Domains[0].Remaining != 4 {
+		t.Errorf("Expected day 2 with 4 of 5 left, got %+v", stats)
+	}
+	
+	// Once the schedule runs out the cap is lifted
+	after := tomorrow.Add(24 * time.Hour)
+	if stats := w.stats(after); stats.Active || stats.Day != 3 {
+		t.Errorf("Expected the warm-up over on day 3, got %+v", stats)
+	}
+	for i := 0; i < 10; i++ {
+		w.count("example.com", after)
+		if domain, _ := w.capped([]string{"example.com"}, after); domain != "" {
+			t.Fatalf("Expected no cap after the schedule, got %q", domain)
+		}
+	}
+}
+
+func TestDeliveryService_Warmup(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(10)
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		Warmup:            config.WarmupConfig{Start: dateOf(time.Now()), Schedule: []int{1}},
+	}, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{"example.com": {{Host: "mail.example.com", Pref: 10}}},
+	}
+	
+	// A greylisted attempt leaves the day's budget for the retry
+	service.client = &replyClient{err: &textproto.Error{Code: 451, Msg: "4.7.1 Greylisted, try again later"}}
+	if err := q.Enqueue(ctx, &email.Email{ID: "greylisted", From: "sender@test.com", To: []string{"rcpt@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	service.poll(ctx, 0, "")
+	if stats := service.WarmupStats(); len(stats.Domains) != 0 {
+		t.Fatalf("Expected a refused send not counted, got %+v", stats.Domains)
+	}
+	
+	client := &mockSMTPClient{}
+	service.client = client
+	for _, id := range []string{"first", "second"} {
+		if err := q.Enqueue(ctx, &email.Email{ID: id, From: "sender@test.com", To: []string{"rcpt@example.com"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	service.poll(ctx, 0, "")
+	
+	if len(client.sent) != 1 {
+		t.Fatalf("Expected one email within the day's cap, got %d", len(client.sent))
+	}
+	held, err := q.Get(ctx, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held.RetryCount != 0 || held.DeferredReason != DeferredWarmup {
+		t.Errorf("Expected the email held without an attempt, got %+v", held)
+	}
+	tomorrow := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	if held.ScheduledAt == nil || held.ScheduledAt.Before(tomorrow) {
+		t.Errorf("Expected the email held until %v, scheduled at %v", tomorrow, held.ScheduledAt)
+	}
+	
+	stats := service.WarmupStats()
+	if stats == nil || !stats.Active || stats.Day != 1 || len(stats.Domains) != 1 || stats.Domains[0].Sent != 1 {
+		t.Errorf("Expected example.com's send counted, got %+v", stats)
+	}
+}
@@ -0,0 +1,67 @@
+package delivery
+
+import (
+	"context"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/logctx"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// DeferredSendingWindow is the DeferredReason of emails held because
+// their lane's sending window is closed.
+const DeferredSendingWindow = "sending_window"
+
+// newSendingWindows returns the windows cfg configures for each lane.
+// Windows that do not parse are ignored; Validate reports them.
+func newSendingWindows(cfg *config.DeliveryConfig) map[email.Lane][]config.Window {
+	var windows map[email.Lane][]config.Window
+	for _, w := range cfg.SendingWindows {
+		parsed, err := config.ParseWindow(w)
+		if err != nil {
+			continue
+		}
+		if windows == nil {
+			windows = make(map[email.Lane][]config.Window)
+		}
+		lane := email.Lane(w.Lane)
+		windows[lane] = append(windows[lane], parsed)
+	}
+	return windows
+}
+
+// windowOpens returns t if one of windows is open at t, and otherwise
+// when the first of them next opens.
+func windowOpens(windows []config.Window, t time.Time) time.Time {
+	var first time.Time
+	for _, w := range windows {
+		next := w.Next(t)
+		if next.Equal(t) {
+			return t
+		}
+		if first.IsZero() || next.Before(first) {
+			first = next
+		}
+	}
+	return first
+}
+
+// outsideWindow checks e against its lane's sending windows. If none is
+// open it holds e until one opens, without an attempt, and returns true.
+// This also moves a scheduled email that came due outside its windows
+// forward to the next opening.
+func (s *Service) outsideWindow(ctx, resultCtx context.Context, e *email.Email) bool {
+	windows := s.windows[e.DeliveryLane()]
+	if len(windows) == 0 {
+		return false
+	}
+	now := time.Now()
+	opens := windowOpens(windows, now)
+	if !opens.After(now) {
+		return false
+	}
+	logctx.Printf(ctx, "Sending window for %s mail closed, holding email until %s", e.DeliveryLane(), opens.Format(time.RFC3339))
+	s.postponeWithReason(resultCtx, e, DeferredSendingWindow, opens.Sub(now))
+	return true
+}
@@ -0,0 +1,62 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestDeliveryService_SendingWindow(t *testing.T) {
+	ctx := context.Background()
+	// A bulk window that opens in two hours
+	now := time.Now().UTC()
+	window := config.SendingWindow{
+		Lane:  "bulk",
+		Start: now.Add(2 * time.Hour).Format("15:04"),
+		End:   now.Add(3 * time.Hour).Format("15:04"),
+	}
+	q := queue.NewMemoryQueue(10)
+	service := NewService(&config.DeliveryConfig{
+		Workers:           1,
+		DNSCacheTTL:       5 * time.Minute,
+		ConnectionTimeout: 30 * time.Second,
+		SendingWindows:    []config.SendingWindow{window},
+	}, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{"example.com": {{Host: "mail.example.com", Pref: 10}}},
+	}
+	client := &mockSMTPClient{}
+	service.client = client
+	
+	for _, e := range []*email.Email{
+		{ID: "bulk", From: "sender@test.com", To: []string{"rcpt@example.com"}, Lane: email.LaneBulk},
+		{ID: "urgent", From: "sender@test.com", To: []string{"rcpt@example.com"}},
+	} {
+		if err := q.Enqueue(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	service.poll(ctx, 0, email.LaneBulk)
+	service.poll(ctx, 0, email.LaneTransactional)
+	
+	if len(client.sent) != 1 || client.sent[0].ID != "urgent" {
+		t.Fatalf("Expected only the transactional email sent, got %d", len(client.sent))
+	}
+	held, err := q.Get(ctx, "bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held.RetryCount != 0 || held.DeferredReason != DeferredSendingWindow {
+		t.Errorf("Expected the bulk email held without an attempt, got %+v", held)
+	}
+	parsed, _ := config.ParseWindow(window)
+	opens := parsed.Next(now)
+	if held.ScheduledAt == nil || held.ScheduledAt.Sub(opens).Abs() > time.Second {
+		t.Errorf("Expected the email held until %v, scheduled at %v", opens, held.ScheduledAt)
+	}
+}
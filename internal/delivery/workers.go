@@ -0,0 +1,141 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// MaxWorkers caps how many delivery workers SetWorkers will run.
+const MaxWorkers = 1000
+
+// ErrNotRunning is returned by SetWorkers when the service has not been
+// started or is stopping.
+var ErrNotRunning = errors.New("delivery service is not running")
+
+// workerPool tracks the running workers so their number can change while
+// the service runs. Each worker has its own stop channel, closed to have
+// it exit once its current batch is done.
+type workerPool struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	workers []*poolWorker
+}
+
+type poolWorker struct {
+	id   int
+	lane email.Lane
+	stop chan struct{}
+}
+
+// Workers returns how many delivery workers are running, or the
+// configured number if the service isn't running.
+func (s *Service) Workers() int {
+	s.pool.mu.Lock()
+	defer s.pool.mu.Unlock()
+	
+	if s.pool.ctx == nil {
+		return s.config.Workers
+	}
+	return len(s.pool.workers)
+}
+
+// SetWorkers starts or stops workers until n are running, keeping the
+// configured share of them for transactional mail. Workers stopped finish
+// the batch they are delivering first. With no workers nothing is
+// delivered, as when paused, and mail waits in the queue.
+func (s *Service) SetWorkers(n int) error {
+	if n < 0 || n > MaxWorkers {
+		return fmt.Errorf("worker count must be between 0 and %d", MaxWorkers)
+	}
+	s.pool.mu.Lock()
+	defer s.pool.mu.Unlock()
+	
+	if s.pool.ctx == nil || s.pool.ctx.Err() != nil {
+		return ErrNotRunning
+	}
+	if n != len(s.pool.workers) {
+		log.Printf("Scaling delivery workers from %d to %d", len(s.pool.workers), n)
+	}
+	s.scale(n)
+	return nil
+}
+
+// startWorkers starts the configured workers under ctx.
+func (s *Service) startWorkers(ctx context.Context) {
+	s.pool.mu.Lock()
+	defer s.pool.mu.Unlock()
+	
+	s.pool.ctx = ctx
+	s.scale(s.config.Workers)
+}
+
+// closeWorkers stops SetWorkers starting any more workers, so Start can
+// wait for those running. They exit with the context they were started
+// with.
+func (s *Service) closeWorkers() {
+	s.pool.mu.Lock()
+	defer s.pool.mu.Unlock()
+	
+	s.pool.ctx = nil
+	s.pool.workers = nil
+}
+
+// scale starts or stops workers of each lane until n are running with
+// reservedFor(n) of them on transactional mail. Callers must hold
+// s.pool.mu.
+func (s *Service) scale(n int) {
+	reserved := s.reservedFor(n)
+	s.scaleLane(email.LaneTransactional, reserved)
+	s.scaleLane("", n-reserved)
+}
+
+// scaleLane starts or stops workers of lane until want are running,
+// stopping the most recently started first.
+func (s *Service) scaleLane(lane email.Lane, want int) {
+	var have []int
+	for i, w := range s.pool.workers {
+		if w.lane == lane {
+			have = append(have, i)
+		}
+	}
+	for i := len(have) - 1; i >= want; i-- {
+		w := s.pool.workers[have[i]]
+		close(w.stop)
+		s.pool.workers = append(s.pool.workers[:have[i]], s.pool.workers[have[i]+1:]...)
+	}
+	for i := len(have); i < want; i++ {
+		w := &poolWorker{id: s.freeWorkerID(), lane: lane, stop: make(chan struct{})}
+		s.pool.workers = append(s.pool.workers, w)
+		s.wg.Add(1)
+		go s.supervise(s.pool.ctx, w.id, w.lane, w.stop)
+	}
+}
+
+// freeWorkerID returns the lowest ID no running worker has, so IDs stay
+// small as workers come and go. Callers must hold s.pool.mu.
+func (s *Service) freeWorkerID() int {
+	used := make(map[int]bool, len(s.pool.workers))
+	for _, w := range s.pool.workers {
+		used[w.id] = true
+	}
+	id := 0
+	for used[id] {
+		id++
+	}
+	return id
+}
+
+// stopped reports whether stop has been closed.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,99 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// laneWorkers counts the running workers of each lane.
+func laneWorkers(s *Service) (transactional, general int) {
+	s.pool.mu.Lock()
+	defer s.pool.mu.Unlock()
+	for _, w := range s.pool.workers {
+		if w.lane == email.LaneTransactional {
+			transactional++
+		} else {
+			general++
+		}
+	}
+	return transactional, general
+}
+
+func TestDeliveryService_SetWorkers(t *testing.T) {
+	ctx := context.Background()
+	ratio := 0.5
+	q := queue.NewMemoryQueue(20)
+	service := NewService(&config.DeliveryConfig{
+		Workers:                  2,
+		TransactionalWorkerRatio: &ratio,
+		DNSCacheTTL:              5 * time.Minute,
+		ConnectionTimeout:        30 * time.Second,
+	}, q)
+	service.resolver = &mockDNSResolver{
+		mx: map[string][]*net.MX{"example.com": {{Host: "mail.example.com", Pref: 10}}},
+	}
+	client := &stallingSMTPClient{started: make(chan string, 10), release: make(chan struct{})}
+	service.client = client
+	
+	if err := service.SetWorkers(4); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("Expected ErrNotRunning before Start, got %v", err)
+	}
+	go service.Start(ctx)
+	defer service.Stop(context.Background())
+	time.Sleep(50 * time.Millisecond)
+	
+	if err := service.SetWorkers(6); err != nil {
+		t.Fatal(err)
+	}
+	if tx, general := laneWorkers(service); tx != 3 || general != 3 {
+		t.Errorf("Expected 3 transactional and 3 other workers, got %d and %d", tx, general)
+	}
+	if err := service.SetWorkers(MaxWorkers + 1); err == nil {
+		t.Error("Expected too many workers refused")
+	}
+	
+	// Workers stopped mid-batch finish it before exiting
+	if err := service.SetWorkers(1); err != nil {
+		t.Fatal(err)
+	}
+	if tx, general := laneWorkers(service); tx != 0 || general != 1 {
+		t.Errorf("Expected one worker for all mail, got %d and %d", tx, general)
+	}
+	for i := 0; i < 3; i++ {
+		e := &email.Email{ID: fmt.Sprintf("batch-%d", i), From: "sender@test.com", To: []string{"rcpt@example.com"}}
+		if err := q.Enqueue(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-client.started
+	if err := service.SetWorkers(0); err != nil {
+		t.Fatal(err)
+	}
+	close(client.release)
+	waitSent(t, &client.mockSMTPClient, "batch-2")
+	
+	// With no workers mail waits in the queue
+	if err := q.Enqueue(ctx, &email.Email{ID: "later", From: "sender@test.com", To: []string{"rcpt@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if e, err := q.Get(ctx, "later"); err != nil || e.Status == email.StatusSending {
+		t.Fatalf("Expected the email left queued with no workers, got %+v, %v", e, err)
+	}
+	if service.Workers() != 0 {
+		t.Errorf("Expected no workers, got %d", service.Workers())
+	}
+	
+	if err := service.SetWorkers(1); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, &client.mockSMTPClient, "later")
+}
@@ -0,0 +1,157 @@
+// Package identity holds the name this server goes by: in its SMTP
+// greeting and EHLO, in the Received and Message-ID headers it adds, in
+// non-delivery reports and in the self-test email. Config validation
+// builds one Identity from server.hostname and every component shares it.
+package identity
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Degrades lists what a hostname that is not fully qualified, does not
+// resolve or has no matching PTR record makes worse.
+var Degrades = []string{
+	"EHLO to receiving servers, which may reject or score it as spam",
+	"Received and Message-ID headers",
+	"non-delivery reports",
+	"SPF and DKIM alignment",
+}
+
+// checkTimeout bounds the DNS lookups Check makes.
+var checkTimeout = 5 * time.Second
+
+// Resolver is the part of net.Resolver that Check uses;
+// net.DefaultResolver will do.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// Identity is the hostname the server announces, with what Warn last
+// found wrong with it.
+type Identity struct {
+	hostname string
+	
+	mu       sync.Mutex
+	problems []string
+}
+
+// New returns the identity of a server called hostname, which should
+// already be normalized.
+func New(hostname string) *Identity {
+	return &Identity{hostname: hostname}
+}
+
+// Hostname returns the configured hostname or, if there is none, the
+// machine's, or "localhost". A nil Identity has none.
+func (id *Identity) Hostname() string {
+	if id != nil && id.hostname != "" {
+		return id.hostname
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "localhost"
+}
+
+// Normalize lowercases hostname and drops a trailing dot, returning an
+// error if what is left isn't a valid DNS name.
+func Normalize(hostname string) (string, error) {
+	name := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")
+	if name == "" || len(name) > 253 {
+		return "", fmt.Errorf("%q is not a valid hostname", hostname)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !validLabel(label) {
+			return "", fmt.Errorf("%q is not a valid hostname", hostname)
+		}
+	}
+	return name, nil
+}
+
+// validLabel reports whether label is a valid hostname label: letters,
+// digits and hyphens, not starting or ending with a hyphen.
+func validLabel(label string) bool {
+	if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, c := range label {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Check returns what is wrong with the hostname: that it is not fully
+// qualified, does not resolve, or that an address it resolves to has no
+// PTR record naming it.
+func (id *Identity) Check(ctx context.Context, r Resolver) []string {
+	if id == nil || id.hostname == "" {
+		return []string{"no hostname is configured"}
+	}
+	if !strings.Contains(id.hostname, ".") {
+		return []string{"it is not fully qualified"}
+	}
+	
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	addrs, err := r.LookupHost(ctx, id.hostname)
+	if err != nil {
+		return []string{fmt.Sprintf("it does not resolve: %v", err)}
+	}
+	var problems []string
+	for _, addr := range addrs {
+		if !id.pointsBack(ctx, r, addr) {
+			problems = append(problems, fmt.Sprintf("%s has no PTR record naming it", addr))
+		}
+	}
+	return problems
+}
+
+// pointsBack reports whether a PTR record of addr names the hostname.
+func (id *Identity) pointsBack(ctx context.Context, r Resolver, addr string) bool {
+	names, err := r.LookupAddr(ctx, addr)
+	if err != nil {
+		return false
+	}
+	for _, name := range names {
+		if strings.TrimSuffix(strings.ToLower(name), ".") == id.hostname {
+			return true
+		}
+	}
+	return false
+}
+
+// Warn checks the hostname and, if anything is wrong, logs one warning
+// naming every problem and every feature they degrade. The problems are
+// kept for Problems to report.
+func (id *Identity) Warn(ctx context.Context, r Resolver) []string {
+	problems := id.Check(ctx, r)
+	if id != nil {
+		id.mu.Lock()
+		id.problems = problems
+		id.mu.Unlock()
+	}
+	if len(problems) > 0 {
+		log.Printf("WARNING: server.hostname %q: %s; this degrades %s",
+			id.Hostname(), strings.Join(problems, "; "), strings.Join(Degrades, ", "))
+	}
+	return problems
+}
+
+// Problems returns what the last Warn found wrong with the hostname.
+func (id *Identity) Problems() []string {
+	if id == nil {
+		return nil
+	}
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	return append([]string(nil), id.problems...)
+}
@@ -0,0 +1,85 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		hostname string
+		want     string
+		wantErr  bool
+	}{
+		{hostname: "mail.example.com", want: "mail.example.com"},
+		{hostname: "Mail.Example.COM.", want: "mail.example.com"},
+		{hostname: " localhost ", want: "localhost"},
+		{hostname: "", wantErr: true},
+		{hostname: "mail_1.example.com", wantErr: true},
+		{hostname: "-mail.example.com", wantErr: true},
+		{hostname: "mail..example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.hostname)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v, want %q, error %v", tt.hostname, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+type fakeResolver struct {
+	hosts map[string][]string
+	ptrs  map[string][]string
+}
+
+func (r fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (r fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if names, ok := r.ptrs[addr]; ok {
+		return names, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestIdentity_Check(t *testing.T) {
+	r := fakeResolver{
+		hosts: map[string][]string{
+			"mail.example.com": {"192.0.2.1"},
+			"mx.example.com":   {"192.0.2.2"},
+		},
+		ptrs: map[string][]string{
+			"192.0.2.1": {"Mail.Example.com."},
+			"192.0.2.2": {"host-192-0-2-2.isp.example"},
+		},
+	}
+	tests := []struct {
+		hostname string
+		want     string
+	}{
+		{hostname: "mail.example.com"},
+		{hostname: "mx.example.com", want: "192.0.2.2 has no PTR record naming it"},
+		{hostname: "nowhere.example.com", want: "it does not resolve"},
+		{hostname: "localhost", want: "it is not fully qualified"},
+		{hostname: "", want: "no hostname is configured"},
+	}
+	for _, tt := range tests {
+		problems := New(tt.hostname).Check(context.Background(), r)
+		got := strings.Join(problems, "; ")
+		if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+			t.Errorf("Check(%q) = %q, want %q", tt.hostname, got, tt.want)
+		}
+	}
+	
+	id := New("mx.example.com")
+	id.Warn(context.Background(), r)
+	if len(id.Problems()) != 1 {
+		t.Errorf("Expected Warn to keep the problem found, got %v", id.Problems())
+	}
+}
@@ -0,0 +1,119 @@
+// Package lifecycle tracks the new → running → stopped states of the
+// server's long-running components. Restarting is not supported: once
+// stopped, a component must be constructed again.
+package lifecycle
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrAlreadyRunning = errors.New("already running")
+	ErrStopped        = errors.New("already stopped; construct a new instance to start again")
+)
+
+type State int
+
+const (
+	StateNew State = iota
+	StateRunning
+	StateStopping
+	StateStopped
+)
+
+func (s State) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	default:
+		return "stopped"
+	}
+}
+
+// Lifecycle guards a component's Start and Stop. The zero value is a new,
+// unstarted component.
+type Lifecycle struct {
+	mu    sync.Mutex
+	state State
+	stop  func() error
+	done  chan struct{}
+}
+
+// Start moves a new component to running. stop is called by the first
+// Stop to make the component's Start return. Start fails with
+// ErrAlreadyRunning or ErrStopped if the component was started before.
+func (l *Lifecycle) Start(stop func() error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	switch l.state {
+	case StateNew:
+	case StateRunning:
+		return ErrAlreadyRunning
+	default:
+		return ErrStopped
+	}
+	
+	l.state = StateRunning
+	l.stop = stop
+	l.done = make(chan struct{})
+	return nil
+}
+
+// Finished is called by the component when its Start returns, stopping it
+// if nobody else did and releasing anyone waiting in Stop.
+func (l *Lifecycle) Finished() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	if l.state == StateStopped {
+		return
+	}
+	l.state = StateStopped
+	l.stop = nil
+	if l.done != nil {
+		close(l.done)
+	}
+}
+
+// Stop stops a running component and waits until its Start has returned.
+// It is idempotent; only the call that stops the component returns the
+// stop function's error. Stopping a component that never started just
+// prevents it from starting.
+func (l *Lifecycle) Stop() error {
+	l.mu.Lock()
+	switch l.state {
+	case StateNew:
+		l.state = StateStopped
+		l.mu.Unlock()
+		return nil
+	case StateStopped:
+		l.mu.Unlock()
+		return nil
+	}
+	
+	stop, done := l.stop, l.done
+	l.stop = nil
+	l.state = StateStopping
+	l.mu.Unlock()
+	
+	var err error
+	if stop != nil {
+		err = stop()
+	}
+	<-done
+	return err
+}
+
+// State returns the current state.
+func (l *Lifecycle) State() State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	return l.state
+}
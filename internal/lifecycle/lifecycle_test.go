@@ -0,0 +1,95 @@
+package lifecycle
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLifecycle_StartStop(t *testing.T) {
+	var l Lifecycle
+	stopped := make(chan struct{})
+	if err := l.Start(func() error { close(stopped); return nil }); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := l.Start(nil); err != ErrAlreadyRunning {
+		t.Errorf("Expected ErrAlreadyRunning, got %v", err)
+	}
+	if l.State() != StateRunning {
+		t.Errorf("Expected running, got %s", l.State())
+	}
+	
+	// The component's Start returns once it is told to stop
+	go func() {
+		<-stopped
+		l.Finished()
+	}()
+	
+	if err := l.Stop(); err != nil {
+		t.Errorf("Stop failed: %v", err)
+	}
+	if l.State() != StateStopped {
+		t.Errorf("Expected stopped, got %s", l.State())
+	}
+	if err := l.Stop(); err != nil {
+		t.Errorf("Second Stop should be a no-op, got %v", err)
+	}
+	if err := l.Start(nil); err != ErrStopped {
+		t.Errorf("Expected ErrStopped on restart, got %v", err)
+	}
+}
+
+func TestLifecycle_StopBeforeStart(t *testing.T) {
+	var l Lifecycle
+	if err := l.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if err := l.Start(nil); err != ErrStopped {
+		t.Errorf("Expected ErrStopped, got %v", err)
+	}
+}
+
+func TestLifecycle_StopError(t *testing.T) {
+	var l Lifecycle
+	closeErr := errors.New("close failed")
+	l.Start(func() error {
+		go l.Finished()
+		return closeErr
+	})
+	
+	if err := l.Stop(); err != closeErr {
+		t.Errorf("Expected stop error, got %v", err)
+	}
+}
+
+func TestLifecycle_Concurrent(t *testing.T) {
+	var l Lifecycle
+	var started, stops atomic.Int32
+	
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if l.Start(func() error { stops.Add(1); go l.Finished(); return nil }) == nil {
+				started.Add(1)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			l.Stop()
+		}()
+	}
+	wg.Wait()
+	
+	if started.Load() > 1 {
+		t.Errorf("Expected at most one successful Start, got %d", started.Load())
+	}
+	if stops.Load() != started.Load() {
+		t.Errorf("Expected the stop function to run once per start, got %d", stops.Load())
+	}
+	if l.State() != StateStopped {
+		t.Errorf("Expected stopped, got %s", l.State())
+	}
+}
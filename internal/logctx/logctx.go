@@ -0,0 +1,76 @@
+package logctx
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+type contextKey struct{}
+
+type recorderKey struct{}
+
+type field struct {
+	key   string
+	value string
+}
+
+// With returns a copy of ctx carrying an additional log field. Fields are
+// printed in the order they were added.
+func With(ctx context.Context, key string, value interface{}) context.Context {
+	parent := fields(ctx)
+	next := make([]field, len(parent), len(parent)+1)
+	copy(next, parent)
+	next = append(next, field{key: key, value: fmt.Sprint(value)})
+	return context.WithValue(ctx, contextKey{}, next)
+}
+
+// Value returns the most recent value stored under key, if any.
+func Value(ctx context.Context, key string) (string, bool) {
+	f := fields(ctx)
+	for i := len(f) - 1; i >= 0; i-- {
+		if f[i].key == key {
+			return f[i].value, true
+		}
+	}
+	return "", false
+}
+
+// Prefix formats the fields carried by ctx as "key=value key=value".
+func Prefix(ctx context.Context) string {
+	f := fields(ctx)
+	parts := make([]string, len(f))
+	for i, kv := range f {
+		parts[i] = kv.key + "=" + kv.value
+	}
+	return strings.Join(parts, " ")
+}
+
+// WithRecorder returns a copy of ctx whose log lines are also passed to
+// record, without the field prefix, in addition to being logged.
+func WithRecorder(ctx context.Context, record func(line string)) context.Context {
+	return context.WithValue(ctx, recorderKey{}, record)
+}
+
+// Printf logs through the standard logger, prefixed with ctx's fields.
+func Printf(ctx context.Context, format string, args ...interface{}) {
+	if ctx != nil {
+		if record, ok := ctx.Value(recorderKey{}).(func(string)); ok {
+			record(fmt.Sprintf(format, args...))
+		}
+	}
+	if prefix := Prefix(ctx); prefix != "" {
+		log.Printf("["+prefix+"] "+format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func fields(ctx context.Context) []field {
+	if ctx == nil {
+		return nil
+	}
+	f, _ := ctx.Value(contextKey{}).([]field)
+	return f
+}
@@ -0,0 +1,39 @@
+package logctx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWith(t *testing.T) {
+	ctx := With(context.Background(), "email_id", "abc")
+	ctx = With(ctx, "attempt", 2)
+	
+	if got := Prefix(ctx); got != "email_id=abc attempt=2" {
+		t.Errorf("Unexpected prefix %q", got)
+	}
+	
+	if v, ok := Value(ctx, "email_id"); !ok || v != "abc" {
+		t.Errorf("Expected email_id abc, got %q", v)
+	}
+	
+	// Parent contexts are not modified
+	parent := With(context.Background(), "a", 1)
+	With(parent, "b", 2)
+	if got := Prefix(parent); got != "a=1" {
+		t.Errorf("Parent context changed: %q", got)
+	}
+}
+
+func TestWithRecorder(t *testing.T) {
+	var lines []string
+	ctx := With(context.Background(), "email_id", "abc")
+	ctx = WithRecorder(ctx, func(line string) { lines = append(lines, line) })
+	
+	Printf(ctx, "Delivered to %s", "mx.example.com")
+	Printf(context.Background(), "not recorded")
+	
+	if len(lines) != 1 || lines[0] != "Delivered to mx.example.com" {
+		t.Errorf("Unexpected recorded lines %q", lines)
+	}
+}
@@ -0,0 +1,39 @@
+package policy
+
+import (
+	"context"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// Policy decides whether an email may be sent. A non-nil error from Check
+// rejects the email, with the error text recorded as the reason.
+type Policy interface {
+	Name() string
+	Check(ctx context.Context, e *email.Email) error
+}
+
+// Func adapts a function to the Policy interface.
+type Func struct {
+	PolicyName string
+	CheckFunc  func(ctx context.Context, e *email.Email) error
+}
+
+func (f Func) Name() string {
+	return f.PolicyName
+}
+
+func (f Func) Check(ctx context.Context, e *email.Email) error {
+	return f.CheckFunc(ctx, e)
+}
+
+// Evaluate runs policies in order and returns the name of the first one
+// that rejects e along with its reason. It returns "", nil if all pass.
+func Evaluate(ctx context.Context, policies []Policy, e *email.Email) (string, error) {
+	for _, p := range policies {
+		if err := p.Check(ctx, e); err != nil {
+			return p.Name(), err
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,41 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+func TestEvaluate(t *testing.T) {
+	blockDomain := Func{
+		PolicyName: "blocked-domain",
+		CheckFunc: func(ctx context.Context, e *email.Email) error {
+			for _, to := range e.To {
+				if strings.HasSuffix(to, "@blocked.com") {
+					return errors.New("recipient domain is blocked")
+				}
+			}
+			return nil
+		},
+	}
+	allowAll := Func{
+		PolicyName: "allow-all",
+		CheckFunc: func(ctx context.Context, e *email.Email) error {
+			return nil
+		},
+	}
+	policies := []Policy{allowAll, blockDomain}
+	
+	name, err := Evaluate(context.Background(), policies, &email.Email{To: []string{"a@example.com"}})
+	if name != "" || err != nil {
+		t.Errorf("Expected email to pass, got %s: %v", name, err)
+	}
+	
+	name, err = Evaluate(context.Background(), policies, &email.Email{To: []string{"a@blocked.com"}})
+	if name != "blocked-domain" || err == nil {
+		t.Errorf("Expected rejection by blocked-domain, got %q: %v", name, err)
+	}
+}
@@ -0,0 +1,73 @@
+package queue
+
+import (
+	"container/heap"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// ageIndex is a min-heap of the queued emails, oldest first, so Stats can
+// report the oldest without a scan. Positions are tracked by ID so that
+// any email can be removed in O(log n) when it leaves the queued state.
+type ageIndex struct {
+	emails []*email.Email
+	pos    map[string]int
+}
+
+func newAgeIndex() *ageIndex {
+	return &ageIndex{pos: make(map[string]int)}
+}
+
+// add records e unless it already is, or has no creation time.
+func (a *ageIndex) add(e *email.Email) {
+	if _, ok := a.pos[e.ID]; ok || e.CreatedAt.IsZero() {
+		return
+	}
+	heap.Push(a, e)
+}
+
+// remove forgets e, if it was recorded.
+func (a *ageIndex) remove(e *email.Email) {
+	if i, ok := a.pos[e.ID]; ok {
+		heap.Remove(a, i)
+	}
+}
+
+// oldest returns when the oldest queued email was created, or the zero
+// time if there is none.
+func (a *ageIndex) oldest() time.Time {
+	if len(a.emails) == 0 {
+		return time.Time{}
+	}
+	return a.emails[0].CreatedAt
+}
+
+// heap.Interface
+
+func (a *ageIndex) Len() int { return len(a.emails) }
+
+func (a *ageIndex) Less(i, j int) bool {
+	return a.emails[i].CreatedAt.Before(a.emails[j].CreatedAt)
+}
+
+func (a *ageIndex) Swap(i, j int) {
+	a.emails[i], a.emails[j] = a.emails[j], a.emails[i]
+	a.pos[a.emails[i].ID] = i
+	a.pos[a.emails[j].ID] = j
+}
+
+func (a *ageIndex) Push(x interface{}) {
+	e := x.(*email.Email)
+	a.pos[e.ID] = len(a.emails)
+	a.emails = append(a.emails, e)
+}
+
+func (a *ageIndex) Pop() interface{} {
+	n := len(a.emails)
+	e := a.emails[n-1]
+	a.emails[n-1] = nil
+	a.emails = a.emails[:n-1]
+	delete(a.pos, e.ID)
+	return e
+}
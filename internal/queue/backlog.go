@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"strings"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// BacklogReporter is implemented by queues that can describe their backlog
+// for a drain forecast. It walks the waiting emails, so it costs about as
+// much as Stats.
+type BacklogReporter interface {
+	Backlog(now time.Time, interval time.Duration, intervals int) Backlog
+}
+
+// Backlog is the mail waiting in a queue: what is ready now and when the
+// scheduled rest becomes ready.
+type Backlog struct {
+	Ready   int
+	Sending int
+	
+	// Releases[i] is how many scheduled emails become ready in the i-th
+	// interval from now. The last interval also counts every later one.
+	Releases []int
+	
+	// Waiting emails by recipient domain. An email to several domains
+	// counts under each of them.
+	Domains map[string]DomainBacklog
+}
+
+// DomainBacklog is the waiting mail to one recipient domain.
+type DomainBacklog struct {
+	Ready     int
+	Scheduled int
+}
+
+// Scheduled is how many emails are waiting on a future time.
+func (b Backlog) Scheduled() int {
+	n := 0
+	for _, r := range b.Releases {
+		n += r
+	}
+	return n
+}
+
+// Backlog reports the ready and scheduled emails, releases bucketed into
+// intervals from now.
+func (q *MemoryQueue) Backlog(now time.Time, interval time.Duration, intervals int) Backlog {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	
+	b := Backlog{
+		Ready:    q.ready.Len(),
+		Sending:  q.sending,
+		Releases: make([]int, max(intervals, 1)),
+		Domains:  make(map[string]DomainBacklog),
+	}
+	for _, elem := range q.ready.elems {
+		countDomains(b.Domains, elem.Value.(*readyItem).email, false)
+	}
+	for _, e := range q.scheduled.emails {
+		i := len(b.Releases) - 1
+		if wait := e.ScheduledAt.Sub(now); wait < 0 {
+			i = 0
+		} else if interval > 0 && wait/interval < time.Duration(i) {
+			i = int(wait / interval)
+		}
+		b.Releases[i]++
+		countDomains(b.Domains, e, true)
+	}
+	return b
+}
+
+// countDomains counts e under each of its recipient domains.
+func countDomains(domains map[string]DomainBacklog, e *email.Email, scheduled bool) {
+	seen := make(map[string]bool, 1)
+	for _, rcpt := range e.Recipients() {
+		_, domain, ok := strings.Cut(rcpt, "@")
+		domain = strings.ToLower(domain)
+		if !ok || seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		d := domains[domain]
+		if scheduled {
+			d.Scheduled++
+		} else {
+			d.Ready++
+		}
+		domains[domain] = d
+	}
+}
@@ -0,0 +1,146 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// PurgedBy is recorded as RejectedBy for emails removed by a purge.
+const PurgedBy = "purged"
+
+// BulkOperator is implemented by queues that can act on many emails in one
+// call. Each email is still rejected or flagged on its own, exactly as by
+// Cancel, so counts and statuses stay right; only the caller sees the
+// operation as a whole.
+type BulkOperator interface {
+	// Match returns the IDs of the emails in the queue matching f.
+	Match(ctx context.Context, f Filter) ([]string, error)
+	// Purge cancels every email matching f, returning their IDs.
+	Purge(ctx context.Context, f Filter, reason string) ([]string, error)
+	// CancelBatch cancels each of ids, returning those it cancelled.
+	// Emails no longer in the queue are skipped.
+	CancelBatch(ctx context.Context, ids []string, reason string) ([]string, error)
+}
+
+// Filter selects emails in the queue. Zero fields match everything.
+type Filter struct {
+	// Domain matches emails with any recipient at it
+	Domain string
+	From   string
+	Status email.Status
+	// Before matches emails created before it
+	Before time.Time
+}
+
+// matches reports whether e is selected by f.
+func (f Filter) matches(e *email.Email) bool {
+	if f.From != "" && !strings.EqualFold(e.From, f.From) {
+		return false
+	}
+	if f.Status != "" && e.Status != f.Status {
+		return false
+	}
+	if !f.Before.IsZero() && !e.CreatedAt.Before(f.Before) {
+		return false
+	}
+	if f.Domain == "" {
+		return true
+	}
+	for _, rcpt := range e.Recipients() {
+		if _, domain, _ := strings.Cut(rcpt, "@"); strings.EqualFold(domain, f.Domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the IDs of the matching emails, oldest first.
+func (q *MemoryQueue) Match(ctx context.Context, f Filter) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	
+	return emailIDs(q.matching(f)), nil
+}
+
+// Purge cancels the matching emails, oldest first.
+func (q *MemoryQueue) Purge(ctx context.Context, f Filter, reason string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if reason == "" {
+		reason = "purged by request"
+	}
+	
+	var ev events
+	defer q.flush(&ev)
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	var purged []*email.Email
+	for _, e := range q.matching(f) {
+		if q.cancel(&ev, e, PurgedBy, reason) == nil {
+			purged = append(purged, e)
+		}
+	}
+	return emailIDs(purged), nil
+}
+
+// CancelBatch cancels each of ids still in the queue, in the order given.
+func (q *MemoryQueue) CancelBatch(ctx context.Context, batch []string, reason string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if reason == "" {
+		reason = "cancelled by request"
+	}
+	
+	var ev events
+	defer q.flush(&ev)
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	var cancelled []string
+	for _, id := range batch {
+		e, exists := q.emailMap[id]
+		if exists && q.cancel(&ev, e, CancelledBy, reason) == nil {
+			cancelled = append(cancelled, id)
+		}
+	}
+	return cancelled, nil
+}
+
+// matching returns the emails matching f, oldest first. Callers must hold
+// q.mu.
+func (q *MemoryQueue) matching(f Filter) []*email.Email {
+	var result []*email.Email
+	for _, e := range q.emailMap {
+		if f.matches(e) {
+			result = append(result, e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+func emailIDs(emails []*email.Email) []string {
+	result := make([]string, len(emails))
+	for i, e := range emails {
+		result[i] = e.ID
+	}
+	return result
+}
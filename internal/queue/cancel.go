@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"context"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// CancelledBy is recorded as RejectedBy for emails cancelled before they
+// were delivered.
+const CancelledBy = "cancelled"
+
+// Canceller is implemented by queues that can cancel emails that have not
+// been delivered yet. Cancelled reports whether a sending email has been
+// cancelled since it was dequeued, so workers can check just before the
+// SMTP transaction; it must be cheap.
+type Canceller interface {
+	Cancel(ctx context.Context, id, reason string) error
+	Cancelled(ctx context.Context, id string) (string, bool)
+}
+
+// Cancel rejects a waiting email straight away. An email being delivered
+// is only flagged: the worker rejects it before its SMTP transaction if it
+// has not started, and a failed attempt is not retried.
+func (q *MemoryQueue) Cancel(ctx context.Context, id, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if reason == "" {
+		reason = "cancelled by request"
+	}
+	
+	var ev events
+	defer q.flush(&ev)
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return ErrEmailNotFound
+	}
+	return q.cancel(&ev, e, CancelledBy, reason)
+}
+
+// cancel rejects e on behalf of by, or flags it if it is being delivered.
+// Callers must hold q.mu.
+func (q *MemoryQueue) cancel(ev *events, e *email.Email, by, reason string) error {
+	if e.Status == email.StatusSending {
+		e.CancelReason = reason
+		return nil
+	}
+	return q.reject(ev, e, by, reason)
+}
+
+// Cancelled returns the reason a sending email was cancelled.
+func (q *MemoryQueue) Cancelled(ctx context.Context, id string) (string, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists || e.CancelReason == "" {
+		return "", false
+	}
+	return e.CancelReason, true
+}
@@ -0,0 +1,138 @@
+package queue
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+var ErrDuplicate = errors.New("duplicate email")
+
+// DuplicateError is returned by Enqueue when an identical email was
+// enqueued within the dedup window. It matches ErrDuplicate with errors.Is.
+type DuplicateError struct {
+	OriginalID string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("duplicate of email %s", e.OriginalID)
+}
+
+func (e *DuplicateError) Unwrap() error {
+	return ErrDuplicate
+}
+
+type dedupEntry struct {
+	fingerprint string
+	id          string
+	seenAt      time.Time
+}
+
+// dedupIndex remembers content fingerprints for a fixed window. Entries are
+// kept in insertion order so expiry and eviction only touch the oldest ones.
+type dedupIndex struct {
+	window     time.Duration
+	maxEntries int
+	entries    map[string]*dedupEntry
+	order      []*dedupEntry
+}
+
+func newDedupIndex(window time.Duration, maxEntries int) *dedupIndex {
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	return &dedupIndex{
+		window:     window,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*dedupEntry),
+	}
+}
+
+// check returns the ID of an email with fingerprint fp seen within the
+// window, or records id under fp and returns "".
+func (d *dedupIndex) check(fp, id string, now time.Time) string {
+	d.expire(now)
+	
+	if entry, ok := d.entries[fp]; ok {
+		return entry.id
+	}
+	
+	// Evict oldest entries to stay within bounds
+	for len(d.order) >= d.maxEntries {
+		d.evictOldest()
+	}
+	
+	entry := &dedupEntry{fingerprint: fp, id: id, seenAt: now}
+	d.entries[fp] = entry
+	d.order = append(d.order, entry)
+	
+	return ""
+}
+
+func (d *dedupIndex) expire(now time.Time) {
+	for len(d.order) > 0 && now.Sub(d.order[0].seenAt) >= d.window {
+		d.evictOldest()
+	}
+}
+
+func (d *dedupIndex) evictOldest() {
+	oldest := d.order[0]
+	d.order[0] = nil
+	d.order = d.order[1:]
+	if d.entries[oldest.fingerprint] == oldest {
+		delete(d.entries, oldest.fingerprint)
+	}
+}
+
+// fingerprint hashes e's envelope and content. A raw message is hashed
+// whole, since its parsed fields leave out most headers and any
+// attachments; it may be read from disk, so call it without the queue
+// lock held.
+func fingerprint(e *email.Email) (string, error) {
+	h := sha256.New()
+	for _, part := range []string{
+		e.From,
+		strings.Join(e.To, ","),
+		strings.Join(e.CC, ","),
+		strings.Join(e.BCC, ","),
+		e.Subject,
+		e.Body,
+		e.HTML,
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	if e.HasRaw() {
+		if err := hashRaw(h, e); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashRaw writes e's raw message to h. Relayed mail starts with the trace
+// header added on receipt, which differs on every retransmission, so it
+// is left out.
+func hashRaw(h io.Writer, e *email.Email) error {
+	raw, err := e.OpenRaw()
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+	
+	r := bufio.NewReader(raw)
+	if e.Relayed {
+		if _, err := r.ReadString('\n'); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	_, err = io.Copy(h, r)
+	return err
+}
@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+const (
+	defaultDeferDelay   = time.Minute
+	defaultMaxDeferrals = 100
+)
+
+// ErrDeferralLimit prefixes the LastError of emails that were deferred
+// more than the configured maximum number of times.
+const ErrDeferralLimit = "deferral limit reached"
+
+// Deferrer is implemented by queues that can reschedule an email whose
+// delivery was never attempted, for example during a resolver outage,
+// without counting it against the retry budget.
+type Deferrer interface {
+	Defer(ctx context.Context, id string, reason string, delay time.Duration) error
+}
+
+// Defer reschedules a sending email after delay, or the configured defer
+// delay if delay is zero, counting a deferral instead of a retry. Once the
+// deferral limit is exceeded the email fails.
+func (q *MemoryQueue) Defer(ctx context.Context, id string, reason string, delay time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	var ev events
+	defer q.flush(&ev)
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return ErrEmailNotFound
+	}
+	
+	q.track(e, -1)
+	if delay <= 0 {
+		delay = q.deferDelay
+	}
+	e.Defer(time.Now().Add(delay), reason)
+	
+	if q.maxDeferrals > 0 && e.DeferCount > q.maxDeferrals {
+		e.MarkFailed(fmt.Sprintf("%s after %d deferrals; last error: %s", ErrDeferralLimit, q.maxDeferrals, reason), false)
+		q.removeEmail(&ev, id)
+		q.failures[CategoryDeferralLimit]++
+		q.failed(&ev, e, false)
+		return nil
+	}
+	
+	q.push(e, e.UpdatedAt)
+	q.track(e, 1)
+	q.failed(&ev, e, true)
+	
+	return nil
+}
+
+// Postponer is implemented by queues that can put a sending email back to
+// wait without recording anything against it, for delivery held back by
+// the sender's own limits rather than by any failure.
+type Postponer interface {
+	Postpone(ctx context.Context, id string, delay time.Duration) error
+}
+
+// Postpone puts a sending email back in the queue, due after delay. Unlike
+// Defer it counts neither a deferral nor a retry and leaves LastError
+// alone.
+func (q *MemoryQueue) Postpone(ctx context.Context, id string, delay time.Duration) error {
+	return q.PostponeWithReason(ctx, id, "", delay)
+}
+
+// ReasonPostponer is implemented by queues that can put a sending email
+// back to wait like Postponer, recording why it is being postponed.
+type ReasonPostponer interface {
+	PostponeWithReason(ctx context.Context, id string, reason string, delay time.Duration) error
+}
+
+// PostponeWithReason postpones a sending email like Postpone and sets its
+// DeferredReason, which stays until delivery is next attempted.
+func (q *MemoryQueue) PostponeWithReason(ctx context.Context, id string, reason string, delay time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return ErrEmailNotFound
+	}
+	if e.Status != email.StatusSending {
+		return fmt.Errorf("%w: %s email cannot be postponed", email.ErrInvalidTransition, e.Status)
+	}
+	
+	q.track(e, -1)
+	e.Postpone(time.Now().Add(delay), reason)
+	q.push(e, e.UpdatedAt)
+	q.track(e, 1)
+	
+	return nil
+}
@@ -0,0 +1,172 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+var ErrDraining = errors.New("queue is draining")
+
+// persistInlineLimit is the largest attachment Persist keeps inside the
+// queue file. Larger ones are written beside it, in attachmentDir(path).
+const persistInlineLimit = 64 << 10
+
+// Drainer is implemented by queues that can stop accepting new emails
+// while existing ones are delivered.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// drainPollInterval is how often Drain checks whether delivery finished.
+const drainPollInterval = 100 * time.Millisecond
+
+// Drain stops the queue accepting new emails and waits until nothing is
+// ready or sending. Emails scheduled for later are left for Persist. It
+// returns ctx's error if the deadline passes first.
+func (q *MemoryQueue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	q.draining = true
+	q.mu.Unlock()
+	
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	
+	for {
+		if q.drained() {
+			return nil
+		}
+		
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *MemoryQueue) drained() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	// Count emails that have become due since the last dequeue
+	if q.scheduled.due(time.Now()) {
+		return false
+	}
+	return q.ready.Len() == 0 && q.sending == 0
+}
+
+// Persist writes every email still in the queue to path as JSON lines in
+// the versioned stored format, replacing the file atomically, and returns
+// how many were written. Emails that were mid-delivery are saved as
+// queued.
+func (q *MemoryQueue) Persist(path string) (int, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	
+	codec := email.Codec{AttachmentDir: attachmentDir(path), InlineLimit: persistInlineLimit}
+	w := bufio.NewWriter(tmp)
+	for _, e := range q.emailMap {
+		saved := *e
+		if saved.Status == email.StatusSending {
+			saved.MarkQueued(saved.UpdatedAt)
+		}
+		data, err := codec.Marshal(&saved)
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+		w.Write(data)
+		if err := w.WriteByte('\n'); err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return len(q.emailMap), nil
+}
+
+// Restore enqueues the emails saved by Persist, by this or any earlier
+// release, and removes the file. A missing file restores nothing.
+//
+// The whole file is read before anything is enqueued, so a damaged file
+// restores nothing. Emails already in the queue are skipped, so Restore
+// can be run again after it fails part way through.
+func (q *MemoryQueue) Restore(ctx context.Context, path string) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	
+	var emails []*email.Email
+	dec := json.NewDecoder(file)
+	for dec.More() {
+		var data json.RawMessage
+		if err := dec.Decode(&data); err != nil {
+			return 0, err
+		}
+		e, err := email.Unmarshal(data)
+		if err != nil {
+			return 0, err
+		}
+		emails = append(emails, e)
+	}
+	
+	restored := 0
+	for _, e := range emails {
+		q.mu.RLock()
+		_, queued := q.emailMap[e.ID]
+		q.mu.RUnlock()
+		if queued {
+			continue
+		}
+		
+		// Restored emails were already accepted once
+		e.AllowDuplicate = true
+		if err := q.Enqueue(ctx, e); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	
+	if err := os.Remove(path); err != nil {
+		return restored, err
+	}
+	return restored, os.RemoveAll(attachmentDir(path))
+}
+
+// attachmentDir is where Persist writes attachments too large to keep in
+// the queue file at path.
+func attachmentDir(path string) string {
+	return path + ".attachments"
+}
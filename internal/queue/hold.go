@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"context"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// EmailHolder is implemented by queues that can hold individual emails
+// back on request. Held emails are skipped by workers, gain no retries
+// and do not expire until released.
+type EmailHolder interface {
+	HoldEmail(ctx context.Context, id, reason string) error
+	ReleaseEmail(ctx context.Context, id string) error
+}
+
+// HoldEmail holds a queued email back until ReleaseEmail. Emails that are
+// being delivered cannot be held.
+func (q *MemoryQueue) HoldEmail(ctx context.Context, id, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return ErrEmailNotFound
+	}
+	if e.Status != email.StatusQueued {
+		return email.ErrInvalidTransition
+	}
+	
+	q.track(e, -1)
+	if !q.ready.remove(e) {
+		q.scheduled.remove(e)
+	}
+	e.MarkHeld(reason)
+	q.track(e, 1)
+	
+	return nil
+}
+
+// ReleaseEmail returns a held email to the queue. Like Release, it keeps
+// its retry count and the time it was held does not count against the
+// retry window or queue age.
+func (q *MemoryQueue) ReleaseEmail(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return ErrEmailNotFound
+	}
+	
+	q.track(e, -1)
+	if err := e.ReleaseHold(); err != nil {
+		q.track(e, 1)
+		return err
+	}
+	q.push(e, e.UpdatedAt)
+	q.track(e, 1)
+	q.signal()
+	
+	return nil
+}
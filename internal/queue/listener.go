@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"log"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// Listener observes queue activity for metrics and alerting. Callbacks run
+// on the goroutine that caused the event, after the queue lock has been
+// released, so a slow listener delays only that caller. Emails passed to a
+// listener are copies it may keep.
+type Listener interface {
+	OnEnqueued(e *email.Email)
+	OnDequeued(e *email.Email)
+	// OnDelivered reports how long the email took from submission to
+	// delivery.
+	OnDelivered(id string, duration time.Duration)
+	// OnFailed reports a failed attempt. willRetry is false once the email
+	// has failed for good, including when it expires in the queue.
+	OnFailed(id string, reason string, willRetry bool)
+}
+
+// RemovalListener is implemented by listeners that want the final state of
+// every email that leaves the queue: delivered, failed for good, expired
+// or rejected. Anything tracking an email outside the queue can use it to
+// follow the email after Get stops finding it.
+type RemovalListener interface {
+	OnRemoved(e *email.Email)
+}
+
+// LogListener logs every queue transition.
+type LogListener struct{}
+
+func (LogListener) OnEnqueued(e *email.Email) {
+	log.Printf("Queue: enqueued %s for %d recipients", e.ID, len(e.Recipients()))
+}
+
+func (LogListener) OnDequeued(e *email.Email) {
+	log.Printf("Queue: dequeued %s (attempt %d)", e.ID, e.RetryCount+1)
+}
+
+func (LogListener) OnDelivered(id string, duration time.Duration) {
+	log.Printf("Queue: delivered %s after %s", id, duration.Round(time.Millisecond))
+}
+
+func (LogListener) OnFailed(id string, reason string, willRetry bool) {
+	if willRetry {
+		log.Printf("Queue: %s failed, will retry: %s", id, reason)
+		return
+	}
+	log.Printf("Queue: %s failed permanently: %s", id, reason)
+}
+
+// events collects listener callbacks while the queue lock is held so they
+// can be run once it is released. Register the flush before taking the
+// lock so it runs after the unlock:
+//
+//	var ev events
+//	defer q.flush(&ev)
+//	q.mu.Lock()
+//	defer q.mu.Unlock()
+type events []func(Listener)
+
+// enqueued records e as enqueued. e is copied now, while the lock is held.
+func (q *MemoryQueue) enqueued(ev *events, e *email.Email) {
+	if len(q.listeners) == 0 {
+		return
+	}
+	c := e.Clone()
+	*ev = append(*ev, func(l Listener) { l.OnEnqueued(c) })
+}
+
+func (q *MemoryQueue) dequeued(ev *events, e *email.Email) {
+	if len(q.listeners) == 0 {
+		return
+	}
+	c := e.Clone()
+	*ev = append(*ev, func(l Listener) { l.OnDequeued(c) })
+}
+
+func (q *MemoryQueue) delivered(ev *events, e *email.Email) {
+	if len(q.listeners) == 0 {
+		return
+	}
+	start := e.CreatedAt
+	if start.IsZero() && e.FirstAttemptAt != nil {
+		start = *e.FirstAttemptAt
+	}
+	id, duration := e.ID, e.UpdatedAt.Sub(start)
+	*ev = append(*ev, func(l Listener) { l.OnDelivered(id, duration) })
+}
+
+func (q *MemoryQueue) failed(ev *events, e *email.Email, willRetry bool) {
+	if len(q.listeners) == 0 {
+		return
+	}
+	id, reason := e.ID, e.LastError
+	*ev = append(*ev, func(l Listener) { l.OnFailed(id, reason, willRetry) })
+}
+
+// removed records that e has left the queue. e is copied now, while the
+// lock is held.
+func (q *MemoryQueue) removed(ev *events, e *email.Email) {
+	if len(q.listeners) == 0 {
+		return
+	}
+	c := e.Clone()
+	*ev = append(*ev, func(l Listener) {
+		if r, ok := l.(RemovalListener); ok {
+			r.OnRemoved(c)
+		}
+	})
+}
+
+// flush runs the collected callbacks. Callers must not hold q.mu.
+func (q *MemoryQueue) flush(ev *events) {
+	for _, fn := range *ev {
+		for _, l := range q.listeners {
+			fn(l)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"container/heap"
+	"container/list"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// defaultPriorityAging is how long a queued email waits to gain one level
+// of effective priority.
+const defaultPriorityAging = time.Minute
+
+// effectivePriority raises an email's priority the longer it has been
+// ready, so low-priority mail is not starved by a constant stream of
+// higher-priority submissions. A retry does not reset the clock.
+func (q *MemoryQueue) effectivePriority(item *readyItem, now time.Time) float64 {
+	priority := float64(item.email.Priority)
+	if !item.since.IsZero() && q.aging > 0 {
+		priority += float64(now.Sub(item.since)) / float64(q.aging)
+	}
+	return priority
+}
+
+type candidate struct {
+	email    *email.Email
+	priority float64
+	index    uint64
+	elem     *list.Element
+}
+
+// better orders by effective priority, then by queue position (FIFO).
+func (c candidate) better(o candidate) bool {
+	if c.priority != o.priority {
+		return c.priority > o.priority
+	}
+	return c.index < o.index
+}
+
+func (q *MemoryQueue) candidate(elem *list.Element, now time.Time) candidate {
+	item := elem.Value.(*readyItem)
+	return candidate{
+		email:    item.email,
+		priority: q.effectivePriority(item, now),
+		index:    item.seq,
+		elem:     elem,
+	}
+}
+
+// prioritySelector merges the ready buckets best first. Within a bucket
+// emails leave in FIFO order; aging decides between buckets. Taking n
+// emails from b buckets costs O((n + b) log b) however deep the queue is.
+type prioritySelector struct {
+	q     *MemoryQueue
+	now   time.Time
+	items []candidate
+}
+
+func (q *MemoryQueue) newPrioritySelector(lane email.Lane, now time.Time) *prioritySelector {
+	s := &prioritySelector{q: q, now: now}
+	for _, elem := range q.ready.fronts(lane) {
+		s.items = append(s.items, q.candidate(elem, now))
+	}
+	heap.Init(s)
+	return s
+}
+
+// next returns the best remaining email, or nil when none are left.
+func (s *prioritySelector) next() *email.Email {
+	if len(s.items) == 0 {
+		return nil
+	}
+	
+	c := heap.Pop(s).(candidate)
+	if next := c.elem.Next(); next != nil {
+		heap.Push(s, s.q.candidate(next, s.now))
+	}
+	return c.email
+}
+
+// heap.Interface, with the best candidate at the root
+
+func (s *prioritySelector) Len() int           { return len(s.items) }
+func (s *prioritySelector) Less(i, j int) bool { return s.items[i].better(s.items[j]) }
+func (s *prioritySelector) Swap(i, j int)      { s.items[i], s.items[j] = s.items[j], s.items[i] }
+
+func (s *prioritySelector) Push(x interface{}) {
+	s.items = append(s.items, x.(candidate))
+}
+
+func (s *prioritySelector) Pop() interface{} {
+	old := s.items
+	n := len(old)
+	item := old[n-1]
+	s.items = old[:n-1]
+	return item
+}
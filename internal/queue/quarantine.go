@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// QuarantinePolicy is recorded as RejectedBy for quarantined emails that
+// are rejected on review.
+const QuarantinePolicy = "quarantine"
+
+// Quarantiner is implemented by queues that can park emails for review.
+// Quarantined emails are skipped by workers until released.
+type Quarantiner interface {
+	Quarantine(ctx context.Context, id, reason string) error
+	ReleaseQuarantined(ctx context.Context, id string) error
+	RejectQuarantined(ctx context.Context, id, reason string) error
+	Quarantined(ctx context.Context) ([]*email.Email, error)
+}
+
+// Quarantine parks a queued email. Emails that are being delivered cannot
+// be quarantined.
+func (q *MemoryQueue) Quarantine(ctx context.Context, id, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return ErrEmailNotFound
+	}
+	if e.Status != email.StatusQueued {
+		return email.ErrInvalidTransition
+	}
+	
+	q.track(e, -1)
+	if !q.ready.remove(e) {
+		q.scheduled.remove(e)
+	}
+	e.Quarantine(reason)
+	q.track(e, 1)
+	
+	return nil
+}
+
+// ReleaseQuarantined returns a quarantined email to the queue. Its retry
+// count is unchanged and the time it was parked does not count against
+// the retry window or queue age.
+func (q *MemoryQueue) ReleaseQuarantined(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return ErrEmailNotFound
+	}
+	
+	q.track(e, -1)
+	if err := e.ReleaseQuarantined(); err != nil {
+		q.track(e, 1)
+		return err
+	}
+	q.push(e, e.UpdatedAt)
+	q.track(e, 1)
+	q.signal()
+	
+	return nil
+}
+
+// RejectQuarantined permanently rejects a quarantined email and removes
+// it from the queue.
+func (q *MemoryQueue) RejectQuarantined(ctx context.Context, id, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	var ev events
+	defer q.flush(&ev)
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return ErrEmailNotFound
+	}
+	if e.Status != email.StatusQuarantined {
+		return email.ErrInvalidTransition
+	}
+	
+	q.track(e, -1)
+	e.Reject(QuarantinePolicy, reason)
+	q.removeEmail(&ev, id)
+	q.totalRejected.Add(1)
+	
+	return nil
+}
+
+// Quarantined returns copies of the quarantined emails, longest parked
+// first.
+func (q *MemoryQueue) Quarantined(ctx context.Context) ([]*email.Email, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	
+	var result []*email.Email
+	for _, e := range q.emailMap {
+		if e.Status == email.StatusQuarantined {
+			result = append(result, e.Clone())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].QuarantinedAt.Before(*result[j].QuarantinedAt)
+	})
+	
+	return result, nil
+}
@@ -1,10 +1,17 @@
 package queue
 
 import (
+	"container/heap"
+	"context"
 	"errors"
+	"fmt"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/policy"
 	"github.com/tpdoyle87/simple-email-server/pkg/email"
 )
 
@@ -13,75 +20,407 @@ var (
 	ErrEmailNotFound = errors.New("email not found")
 )
 
+// ErrExpired is recorded as the LastError of emails that exceeded the
+// configured maximum queue age.
+const ErrExpired = "message expired in queue"
+
+// Failure categories assigned by the queue itself. Emails failed through
+// plain MarkFailed are counted as CategoryOther.
+const (
+	CategoryExpired       = "message expired"
+	CategoryDeferralLimit = "deferral limit reached"
+	CategoryOther         = "other"
+)
+
+// ErrRetryWindowExceeded prefixes the LastError of emails that were still
+// failing when the configured maximum retry duration ran out.
+const ErrRetryWindowExceeded = "retry window exceeded"
+
+// Queue stores emails awaiting delivery. Operations that may block on a
+// backend take a context and return its error once it is done.
 type Queue interface {
-	Enqueue(*email.Email) error
-	Dequeue(count int) ([]*email.Email, error)
-	MarkDelivered(id string) error
-	MarkFailed(id string, reason string, retry bool) error
+	Enqueue(ctx context.Context, e *email.Email) error
+	Dequeue(ctx context.Context, count int) ([]*email.Email, error)
+	// DequeueLane is Dequeue restricted to a single lane; an empty lane
+	// matches every email.
+	DequeueLane(ctx context.Context, lane email.Lane, count int) ([]*email.Email, error)
+	MarkDelivered(ctx context.Context, id string) error
+	MarkFailed(ctx context.Context, id string, reason string, retry bool) error
 	Size() int
+	Stats() QueueStats
+}
+
+// Notifier is implemented by queues that can wake idle workers as soon as
+// emails are enqueued. The returned channel is closed on the next Enqueue;
+// call Notify again afterwards for a fresh one.
+type Notifier interface {
+	Notify() <-chan struct{}
+}
+
+// Getter is implemented by queues that can look up an email they hold.
+// The result is a copy, safe to read while delivery continues.
+type Getter interface {
+	Get(ctx context.Context, id string) (*email.Email, error)
+}
+
+// FailureCategorizer is implemented by queues that break failed emails
+// down by category in their stats. MarkFailedCategory is MarkFailed with
+// the category the failure falls under.
+type FailureCategorizer interface {
+	MarkFailedCategory(ctx context.Context, id string, reason string, category string, retry bool) error
+}
+
+// FailureRecorder is implemented by queues that keep failed attempts in
+// structured form, as LastFailure. RecordFailure is MarkFailedCategory
+// with that record; a permanent failure is never retried, and the email
+// ends up bounced rather than failed.
+type FailureRecorder interface {
+	RecordFailure(ctx context.Context, id string, reason string, category string, failure email.Failure, retry bool) error
+}
+
+// Rejecter is implemented by queues that can reject an email that has not
+// been delivered yet, such as one vetoed just before its SMTP transaction.
+type Rejecter interface {
+	Reject(ctx context.Context, id string, by string, reason string) error
+}
+
+// RecipientTracker is implemented by queues that can record the outcome
+// of an attempt per recipient, so that retries are sent only to the
+// recipients still outstanding.
+type RecipientTracker interface {
+	UpdateRecipients(ctx context.Context, id string, statuses map[string]email.RecipientStatus) error
+}
+
+// AttemptRecorder is implemented by queues that keep the history of an
+// email's SMTP transactions in its Attempts.
+type AttemptRecorder interface {
+	RecordAttempts(ctx context.Context, id string, attempts []email.Attempt) error
+}
+
+// Committer is implemented by queue backends that write emails to durable
+// storage. Commit returns once the email with the given id has been
+// committed there, so it survives a crash. The memory queue is not a
+// Committer: nothing it holds outlives the process.
+type Committer interface {
+	Commit(ctx context.Context, id string) error
+}
+
+// QueueStats breaks the queue size down by state. Retried emails wait on a
+// future ScheduledAt, so they are counted in both Retrying and Scheduled.
+type QueueStats struct {
+	Queued          int
+	Sending         int
+	Scheduled       int
+	Retrying        int
+	Quarantined     int
+	Held            int
+	OldestQueuedAge time.Duration
+	TotalExpired    int64
+	TotalRejected   int64
+	
+	// Emails whose first attempt started after their SLA deadline
+	TotalSLABreached int64
+	
+	// Recipients dropped at send time because they had been suppressed
+	// since the email was queued. Emails rejected outright, by a policy
+	// or because no recipients were left, are in TotalRejected.
+	TotalSuppressed int64
+	
+	// Emails that failed for good, by failure category
+	FailureCategories map[string]int64
 }
 
 type MemoryQueue struct {
 	mu        sync.RWMutex
-	emails    []*email.Email
 	emailMap  map[string]*email.Email
+	
+	// Queued emails are either ready or waiting on a future ScheduledAt.
+	// Sending emails are only in emailMap.
+	ready     *readyList
+	scheduled *scheduleHeap
+	
 	maxSize   int
+	maxAge    time.Duration
+	maxRetry  time.Duration
+	aging     time.Duration
+	slaBoost  time.Duration
+	retry     retryPolicy
+	dedup     *dedupIndex
+	policies  []policy.Policy
+	notify    chan struct{}
+	draining  bool
+	listeners []Listener
+	
+	// Queued emails by age, for Stats
+	ages *ageIndex
+	
+	// Postponing emails whose delivery was never attempted
+	deferDelay   time.Duration
+	maxDeferrals int
+	
+	// Counters maintained on every state change
+	queued      int
+	sending     int
+	retrying    int
+	quarantined int
+	held        int
+	failures    map[string]int64
+	
+	totalExpired     atomic.Int64
+	totalRejected    atomic.Int64
+	totalSLABreached atomic.Int64
+	totalSuppressed  atomic.Int64
 }
 
-func NewMemoryQueue(maxSize int) *MemoryQueue {
+// NewMemoryQueue creates a memory queue holding at most maxSize emails.
+// Listeners are notified of every transition.
+func NewMemoryQueue(maxSize int, listeners ...Listener) *MemoryQueue {
 	return &MemoryQueue{
-		emails:   make([]*email.Email, 0),
-		emailMap: make(map[string]*email.Email),
-		maxSize:  maxSize,
+		emailMap:  make(map[string]*email.Email),
+		failures:  make(map[string]int64),
+		ready:     newReadyList(),
+		scheduled: newScheduleHeap(),
+		ages:      newAgeIndex(),
+		notify:    make(chan struct{}),
+		maxSize:   maxSize,
+		aging:     defaultPriorityAging,
+		slaBoost:  defaultSLABoost,
+		retry:     defaultRetryPolicy(),
+		listeners: listeners,
+		
+		deferDelay:   defaultDeferDelay,
+		maxDeferrals: defaultMaxDeferrals,
 	}
 }
 
-func (q *MemoryQueue) Enqueue(e *email.Email) error {
+// NewMemoryQueueWithConfig creates a memory queue using the size and
+// expiry settings from cfg.
+func NewMemoryQueueWithConfig(cfg *config.QueueConfig, listeners ...Listener) *MemoryQueue {
+	q := NewMemoryQueue(cfg.MaxSize, listeners...)
+	q.maxAge = cfg.MaxQueueAge
+	q.maxRetry = cfg.MaxRetryDuration
+	q.retry = newRetryPolicy(cfg)
+	if cfg.PriorityAging > 0 {
+		q.aging = cfg.PriorityAging
+	}
+	if cfg.SLABoost > 0 {
+		q.slaBoost = cfg.SLABoost
+	}
+	if cfg.DeferDelay > 0 {
+		q.deferDelay = cfg.DeferDelay
+	}
+	if cfg.MaxDeferrals > 0 {
+		q.maxDeferrals = cfg.MaxDeferrals
+	}
+	if cfg.DedupWindow > 0 {
+		q.dedup = newDedupIndex(cfg.DedupWindow, cfg.DedupMaxEntries)
+	}
+	return q
+}
+
+// SetPolicies installs pre-delivery policies. They are checked under the
+// queue lock as emails become ready, so they must be fast.
+func (q *MemoryQueue) SetPolicies(policies ...policy.Policy) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	q.policies = policies
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, e *email.Email) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	var fp string
+	if q.dedup != nil && !e.AllowDuplicate {
+		var err error
+		if fp, err = fingerprint(e); err != nil {
+			return fmt.Errorf("failed to fingerprint email: %w", err)
+		}
+	}
+	
+	var ev events
+	defer q.flush(&ev)
+	
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	
-	if len(q.emails) >= q.maxSize {
+	if q.draining {
+		return ErrDraining
+	}
+	
+	if len(q.emailMap) >= q.maxSize {
 		return ErrQueueFull
 	}
 	
-	e.UpdatedAt = time.Now()
-	q.emails = append(q.emails, e)
+	if fp != "" {
+		if originalID := q.dedup.check(fp, e.ID, time.Now()); originalID != "" {
+			return &DuplicateError{OriginalID: originalID}
+		}
+	}
+	
+	e.Touch(time.Now())
+	if q.maxAge > 0 && e.ExpiresAt == nil {
+		// Scheduled sends age from their scheduled time, not submission
+		start := e.CreatedAt
+		if start.IsZero() {
+			start = e.UpdatedAt
+		}
+		if e.ScheduledAt != nil && e.ScheduledAt.After(start) {
+			start = *e.ScheduledAt
+		}
+		expiresAt := start.Add(q.maxAge)
+		e.ExpiresAt = &expiresAt
+	}
 	q.emailMap[e.ID] = e
+	q.ready.forget(e.ID)
+	if e.Status != email.StatusQuarantined && e.Status != email.StatusHeld {
+		q.push(e, e.UpdatedAt)
+	}
+	q.track(e, 1)
+	q.enqueued(&ev, e)
+	q.signal()
 	
 	return nil
 }
 
-func (q *MemoryQueue) Dequeue(count int) ([]*email.Email, error) {
+// Notify returns a channel that is closed when an email is next enqueued.
+func (q *MemoryQueue) Notify() <-chan struct{} {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	
+	return q.notify
+}
+
+// signal wakes everyone waiting on Notify. Callers must hold q.mu.
+func (q *MemoryQueue) signal() {
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+// Get returns a copy of the email with the given id, or ErrEmailNotFound
+// once it has left the queue.
+func (q *MemoryQueue) Get(ctx context.Context, id string) (*email.Email, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return nil, ErrEmailNotFound
+	}
+	return e.Clone(), nil
+}
+
+// Dequeue returns copies of the next emails to deliver. The queue's own
+// records only change through MarkDelivered and MarkFailed.
+func (q *MemoryQueue) Dequeue(ctx context.Context, count int) ([]*email.Email, error) {
+	return q.DequeueLane(ctx, "", count)
+}
+
+func (q *MemoryQueue) DequeueLane(ctx context.Context, lane email.Lane, count int) ([]*email.Email, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	
+	var ev events
+	defer q.flush(&ev)
+	
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	
-	result := make([]*email.Email, 0, count)
-	
-	// Find emails ready to send
 	now := time.Now()
-	for i := 0; i < len(q.emails) && len(result) < count; i++ {
-		e := q.emails[i]
+	
+	// Move scheduled emails that are now due onto the ready list
+	for q.scheduled.due(now) {
+		q.ready.push(heap.Pop(q.scheduled).(*email.Email))
+	}
+	
+	// Take emails nearing their SLA deadline first, then the count emails
+	// with the highest effective priority. Expiry and policies are checked
+	// as emails reach the front of their bucket.
+	boostUntil := now.Add(q.slaBoost)
+	var selector *prioritySelector
+	var result, expired, rejected []*email.Email
+	for len(result) < count {
+		e := q.ready.urgent(lane, boostUntil)
+		if e == nil {
+			if selector == nil {
+				selector = q.newPrioritySelector(lane, now)
+			}
+			e = selector.next()
+		}
+		if e == nil {
+			break
+		}
+		q.ready.remove(e)
 		
-		// Skip if scheduled for future
-		if e.ScheduledAt != nil && e.ScheduledAt.After(now) {
+		// Fail emails that have been queued too long
+		if e.ExpiresAt != nil && !e.ExpiresAt.After(now) {
+			expired = append(expired, e)
 			continue
 		}
 		
-		// Skip if already sending or not queued
-		if e.Status != email.StatusQueued {
+		// Drop emails cancelled during a failed attempt
+		if e.CancelReason != "" {
+			q.track(e, -1)
+			e.Reject(CancelledBy, e.CancelReason)
+			rejected = append(rejected, e)
+			continue
+		}
+		
+		// Drop emails a policy no longer allows to be sent
+		if name, err := policy.Evaluate(ctx, q.policies, e); err != nil {
+			q.track(e, -1)
+			e.Reject(name, err.Error())
+			rejected = append(rejected, e)
 			continue
 		}
 		
-		// Mark as sending
-		e.Status = email.StatusSending
-		e.UpdatedAt = now
 		result = append(result, e)
 	}
 	
+	for _, e := range expired {
+		q.track(e, -1)
+		e.MarkFailed(ErrExpired, false)
+		q.removeEmail(&ev, e.ID)
+		q.totalExpired.Add(1)
+		q.failures[CategoryExpired]++
+		q.failed(&ev, e, false)
+	}
+	
+	for _, e := range rejected {
+		q.removeEmail(&ev, e.ID)
+		q.totalRejected.Add(1)
+	}
+	
+	for i, e := range result {
+		// Mark as sending
+		q.track(e, -1)
+		if e.SLADeadline != nil && e.FirstAttemptAt == nil && now.After(*e.SLADeadline) {
+			q.slaBreached(&ev, e, now)
+		}
+		e.MarkSending(now)
+		q.track(e, 1)
+		q.dequeued(&ev, e)
+		result[i] = e.Clone()
+	}
+	
 	return result, nil
 }
 
-func (q *MemoryQueue) MarkDelivered(id string) error {
+func (q *MemoryQueue) MarkDelivered(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	var ev events
+	defer q.flush(&ev)
+	
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	
@@ -91,18 +430,41 @@ func (q *MemoryQueue) MarkDelivered(id string) error {
 	}
 	
 	// Update status
-	now := time.Now()
-	e.Status = email.StatusDelivered
-	e.UpdatedAt = now
-	e.DeliveredAt = &now
+	q.track(e, -1)
+	e.MarkDelivered(time.Now())
+	q.delivered(&ev, e)
 	
 	// Remove from queue
-	q.removeEmail(id)
+	q.removeEmail(&ev, id)
 	
 	return nil
 }
 
-func (q *MemoryQueue) MarkFailed(id string, reason string, retry bool) error {
+func (q *MemoryQueue) MarkFailed(ctx context.Context, id string, reason string, retry bool) error {
+	return q.MarkFailedCategory(ctx, id, reason, CategoryOther, retry)
+}
+
+// MarkFailedCategory records a failed attempt like MarkFailed. If the email
+// has failed for good it is counted under category.
+func (q *MemoryQueue) MarkFailedCategory(ctx context.Context, id string, reason string, category string, retry bool) error {
+	return q.markFailed(ctx, id, reason, category, nil, retry)
+}
+
+// RecordFailure records a failed attempt like MarkFailedCategory, keeping
+// failure as the email's LastFailure. A permanent failure bounces the
+// email instead of retrying it.
+func (q *MemoryQueue) RecordFailure(ctx context.Context, id string, reason string, category string, failure email.Failure, retry bool) error {
+	return q.markFailed(ctx, id, reason, category, &failure, retry && !failure.Permanent)
+}
+
+func (q *MemoryQueue) markFailed(ctx context.Context, id string, reason string, category string, failure *email.Failure, retry bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	var ev events
+	defer q.flush(&ev)
+	
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	
@@ -112,41 +474,196 @@ func (q *MemoryQueue) MarkFailed(id string, reason string, retry bool) error {
 	}
 	
 	// Update email
-	e.LastError = reason
-	e.UpdatedAt = time.Now()
+	q.track(e, -1)
+	e.LastFailure = failure
+	now := time.Now()
+	
+	// Give up once the retry window has run out, whatever the retry count
+	if retry && q.maxRetry > 0 && e.FirstAttemptAt != nil && now.Sub(*e.FirstAttemptAt) >= q.maxRetry {
+		retry = false
+		reason = fmt.Sprintf("%s after %s; last error: %s", ErrRetryWindowExceeded, q.maxRetry, reason)
+	}
 	
 	if retry {
-		e.Status = email.StatusQueued
-		e.RetryCount++
-		
-		// Calculate next retry time with exponential backoff
-		retryDelay := time.Duration(e.RetryCount) * 5 * time.Minute
-		nextRetry := time.Now().Add(retryDelay)
-		e.ScheduledAt = &nextRetry
+		e.ScheduleRetry(now.Add(q.retry.after(e.RetryCount, failure)), reason)
+		q.push(e, e.UpdatedAt)
+		q.track(e, 1)
 	} else {
-		e.Status = email.StatusFailed
-		q.removeEmail(id)
+		e.MarkFailed(reason, failure != nil && failure.Permanent)
+		q.removeEmail(&ev, id)
+		q.failures[category]++
 	}
+	q.failed(&ev, e, retry)
 	
 	return nil
 }
 
+// Reject rejects an email still in the queue on behalf of by, removing
+// it. It is counted with emails rejected by policies.
+func (q *MemoryQueue) Reject(ctx context.Context, id string, by string, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	var ev events
+	defer q.flush(&ev)
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return ErrEmailNotFound
+	}
+	return q.reject(&ev, e, by, reason)
+}
+
+// reject rejects e and removes it. Callers must hold q.mu.
+func (q *MemoryQueue) reject(ev *events, e *email.Email, by, reason string) error {
+	waiting := e.Status == email.StatusQueued
+	q.track(e, -1)
+	if err := e.Reject(by, reason); err != nil {
+		q.track(e, 1)
+		return err
+	}
+	if waiting && !q.ready.remove(e) {
+		q.scheduled.remove(e)
+	}
+	q.removeEmail(ev, e.ID)
+	q.totalRejected.Add(1)
+	
+	return nil
+}
+
+// UpdateRecipients merges statuses into the email's recipient statuses.
+func (q *MemoryQueue) UpdateRecipients(ctx context.Context, id string, statuses map[string]email.RecipientStatus) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return ErrEmailNotFound
+	}
+	if e.RecipientStatus == nil {
+		e.RecipientStatus = make(map[string]email.RecipientStatus, len(statuses))
+	}
+	for rcpt, status := range statuses {
+		if status.Status == email.StatusSuppressed && e.RecipientStatus[rcpt].Status != email.StatusSuppressed {
+			q.totalSuppressed.Add(1)
+		}
+		e.RecipientStatus[rcpt] = status
+	}
+	e.Touch(time.Now())
+	return nil
+}
+
+// RecordAttempts adds attempts to the history of the email with the given
+// id, keeping the last email.MaxAttempts.
+func (q *MemoryQueue) RecordAttempts(ctx context.Context, id string, attempts []email.Attempt) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	e, exists := q.emailMap[id]
+	if !exists {
+		return ErrEmailNotFound
+	}
+	e.RecordAttempts(attempts)
+	e.Touch(time.Now())
+	return nil
+}
+
 func (q *MemoryQueue) Size() int {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
 	
-	return len(q.emails)
+	return len(q.emailMap)
 }
 
-func (q *MemoryQueue) removeEmail(id string) {
-	// Remove from slice
-	for i, e := range q.emails {
-		if e.ID == id {
-			q.emails = append(q.emails[:i], q.emails[i+1:]...)
-			break
-		}
+// Stats returns the number of emails in each state. The counts and the
+// oldest queued email are maintained incrementally, so it does not scan
+// the queue.
+func (q *MemoryQueue) Stats() QueueStats {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	
+	stats := QueueStats{
+		Queued:        q.queued,
+		Sending:       q.sending,
+		Scheduled:     q.scheduled.Len(),
+		Retrying:      q.retrying,
+		Quarantined:   q.quarantined,
+		Held:          q.held,
+		TotalExpired:  q.totalExpired.Load(),
+		TotalRejected: q.totalRejected.Load(),
+		
+		TotalSLABreached:  q.totalSLABreached.Load(),
+		TotalSuppressed:   q.totalSuppressed.Load(),
+		FailureCategories: make(map[string]int64, len(q.failures)),
+	}
+	for category, n := range q.failures {
+		stats.FailureCategories[category] = n
 	}
 	
-	// Remove from map
+	if oldest := q.ages.oldest(); !oldest.IsZero() {
+		stats.OldestQueuedAge = time.Since(oldest)
+	}
+	
+	return stats
+}
+
+// track adds delta to the counters matching e's current state.
+func (q *MemoryQueue) track(e *email.Email, delta int) {
+	switch e.Status {
+	case email.StatusQueued:
+		q.queued += delta
+		if delta > 0 {
+			q.ages.add(e)
+		} else {
+			q.ages.remove(e)
+		}
+	case email.StatusSending:
+		q.sending += delta
+	case email.StatusQuarantined:
+		q.quarantined += delta
+		return
+	case email.StatusHeld:
+		q.held += delta
+		return
+	}
+	if e.RetryCount > 0 {
+		q.retrying += delta
+	}
+}
+
+// push adds a queued email to the ready list, or to the schedule if it is
+// not due yet.
+func (q *MemoryQueue) push(e *email.Email, now time.Time) {
+	if e.ScheduledAt != nil && e.ScheduledAt.After(now) {
+		heap.Push(q.scheduled, e)
+		return
+	}
+	q.ready.push(e)
+}
+
+// removeEmail forgets an email that has left the ready list and schedule,
+// along with any raw message spilled to disk for it.
+func (q *MemoryQueue) removeEmail(ev *events, id string) {
+	e := q.emailMap[id]
+	if e == nil {
+		return
+	}
+	if e.RawPath != "" {
+		os.Remove(e.RawPath)
+	}
+	q.removed(ev, e)
 	delete(q.emailMap, id)
+	q.ready.forget(id)
 }
\ No newline at end of file
@@ -1,14 +1,25 @@
 package queue
 
 import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/policy"
 	"github.com/tpdoyle87/simple-email-server/pkg/email"
 )
 
 func TestMemoryQueue_EnqueueDequeue(t *testing.T) {
+	ctx := context.Background()
 	q := NewMemoryQueue(10)
 	
 	// Test enqueue
@@ -21,13 +32,13 @@ func TestMemoryQueue_EnqueueDequeue(t *testing.T) {
 		Status:  email.StatusQueued,
 	}
 	
-	err := q.Enqueue(e)
+	err := q.Enqueue(ctx, e)
 	if err != nil {
 		t.Fatalf("Failed to enqueue email: %v", err)
 	}
 	
 	// Test dequeue
-	emails, err := q.Dequeue(1)
+	emails, err := q.Dequeue(ctx, 1)
 	if err != nil {
 		t.Fatalf("Failed to dequeue emails: %v", err)
 	}
@@ -46,6 +57,7 @@ func TestMemoryQueue_EnqueueDequeue(t *testing.T) {
 }
 
 func TestMemoryQueue_MaxSize(t *testing.T) {
+	ctx := context.Background()
 	q := NewMemoryQueue(2)
 	
 	// Fill queue
@@ -54,7 +66,7 @@ func TestMemoryQueue_MaxSize(t *testing.T) {
 			ID:     "test-" + string(rune(i)),
 			Status: email.StatusQueued,
 		}
-		err := q.Enqueue(e)
+		err := q.Enqueue(ctx, e)
 		if err != nil {
 			t.Fatalf("Failed to enqueue email %d: %v", i, err)
 		}
@@ -65,13 +77,14 @@ func TestMemoryQueue_MaxSize(t *testing.T) {
 		ID:     "test-3",
 		Status: email.StatusQueued,
 	}
-	err := q.Enqueue(e)
+	err := q.Enqueue(ctx, e)
 	if err == nil {
 		t.Error("Expected error when exceeding max size")
 	}
 }
 
 func TestMemoryQueue_BatchDequeue(t *testing.T) {
+	ctx := context.Background()
 	q := NewMemoryQueue(100)
 	
 	// Enqueue 10 emails
@@ -80,14 +93,14 @@ func TestMemoryQueue_BatchDequeue(t *testing.T) {
 			ID:     "test-" + string(rune(i)),
 			Status: email.StatusQueued,
 		}
-		err := q.Enqueue(e)
+		err := q.Enqueue(ctx, e)
 		if err != nil {
 			t.Fatalf("Failed to enqueue email %d: %v", i, err)
 		}
 	}
 	
 	// Dequeue batch of 5
-	emails, err := q.Dequeue(5)
+	emails, err := q.Dequeue(ctx, 5)
 	if err != nil {
 		t.Fatalf("Failed to dequeue emails: %v", err)
 	}
@@ -97,13 +110,14 @@ func TestMemoryQueue_BatchDequeue(t *testing.T) {
 	}
 	
 	// Check remaining queue that can be dequeued (status = queued)
-	remaining, _ := q.Dequeue(100)
+	remaining, _ := q.Dequeue(ctx, 100)
 	if len(remaining) != 5 {
 		t.Errorf("Expected 5 more emails to dequeue, got %d", len(remaining))
 	}
 }
 
 func TestMemoryQueue_MarkDelivered(t *testing.T) {
+	ctx := context.Background()
 	q := NewMemoryQueue(10)
 	
 	e := &email.Email{
@@ -111,18 +125,18 @@ func TestMemoryQueue_MarkDelivered(t *testing.T) {
 		Status: email.StatusQueued,
 	}
 	
-	err := q.Enqueue(e)
+	err := q.Enqueue(ctx, e)
 	if err != nil {
 		t.Fatalf("Failed to enqueue email: %v", err)
 	}
 	
-	emails, _ := q.Dequeue(1)
+	emails, _ := q.Dequeue(ctx, 1)
 	if len(emails) != 1 {
 		t.Fatal("Failed to dequeue email")
 	}
 	
 	// Mark as delivered
-	err = q.MarkDelivered(emails[0].ID)
+	err = q.MarkDelivered(ctx, emails[0].ID)
 	if err != nil {
 		t.Fatalf("Failed to mark email as delivered: %v", err)
 	}
@@ -135,6 +149,7 @@ func TestMemoryQueue_MarkDelivered(t *testing.T) {
 }
 
 func TestMemoryQueue_MarkFailed(t *testing.T) {
+	ctx := context.Background()
 	q := NewMemoryQueue(10)
 	
 	e := &email.Email{
@@ -143,18 +158,18 @@ func TestMemoryQueue_MarkFailed(t *testing.T) {
 		RetryCount: 0,
 	}
 	
-	err := q.Enqueue(e)
+	err := q.Enqueue(ctx, e)
 	if err != nil {
 		t.Fatalf("Failed to enqueue email: %v", err)
 	}
 	
-	emails, _ := q.Dequeue(1)
+	emails, _ := q.Dequeue(ctx, 1)
 	if len(emails) != 1 {
 		t.Fatal("Failed to dequeue email")
 	}
 	
 	// Mark as failed with retry
-	err = q.MarkFailed(emails[0].ID, "Connection refused", true)
+	err = q.MarkFailed(ctx, emails[0].ID, "Connection refused", true)
 	if err != nil {
 		t.Fatalf("Failed to mark email as failed: %v", err)
 	}
@@ -169,7 +184,7 @@ func TestMemoryQueue_MarkFailed(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 	
 	// Force dequeue ignoring schedule for test
-	emails, _ = q.Dequeue(1)
+	emails, _ = q.Dequeue(ctx, 1)
 	if len(emails) > 0 {
 		// Should not dequeue because it's scheduled for future
 		t.Error("Email should be scheduled for future retry")
@@ -192,6 +207,7 @@ func TestMemoryQueue_MarkFailed(t *testing.T) {
 }
 
 func TestMemoryQueue_Concurrent(t *testing.T) {
+	ctx := context.Background()
 	q := NewMemoryQueue(1000)
 	
 	var wg sync.WaitGroup
@@ -206,7 +222,7 @@ func TestMemoryQueue_Concurrent(t *testing.T) {
 					ID:     string(rune(id*10 + j)),
 					Status: email.StatusQueued,
 				}
-				q.Enqueue(e)
+				q.Enqueue(ctx, e)
 			}
 		}(i)
 	}
@@ -217,7 +233,7 @@ func TestMemoryQueue_Concurrent(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			for j := 0; j < 10; j++ {
-				q.Dequeue(5)
+				q.Dequeue(ctx, 5)
 				time.Sleep(1 * time.Millisecond)
 			}
 		}()
@@ -226,7 +242,105 @@ func TestMemoryQueue_Concurrent(t *testing.T) {
 	wg.Wait()
 }
 
+// TestMemoryQueue_ConcurrentStateChanges runs every kind of state change
+// at once with readers looking on; run it with -race.
+func TestMemoryQueue_ConcurrentStateChanges(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:    1000,
+		RetryDelay: time.Nanosecond,
+	})
+	
+	const total = 50
+	var ids []string
+	for i := 0; i < total; i++ {
+		ids = append(ids, fmt.Sprintf("stress-%d", i))
+	}
+	
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			for _, id := range ids[start*total/2 : (start+1)*total/2] {
+				if err := q.Enqueue(ctx, &email.Email{ID: id, Status: email.StatusQueued}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	
+	// Each email is retried twice, deferred, held and then delivered
+	var held sync.Map
+	var mu sync.Mutex
+	delivered := 0
+	done := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return delivered == total
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !done() && ctx.Err() == nil {
+				emails, _ := q.Dequeue(ctx, 5)
+				for _, e := range emails {
+					switch _, wasHeld := held.Load(e.ID); {
+					case e.RetryCount < 2:
+						q.MarkFailed(ctx, e.ID, "451 try again later", true)
+					case e.DeferCount < 1:
+						q.Defer(ctx, e.ID, "421 too busy", time.Nanosecond)
+					case !wasHeld:
+						held.Store(e.ID, true)
+						q.PostponeWithReason(ctx, e.ID, "domain_paused", time.Nanosecond)
+					default:
+						if q.MarkDelivered(ctx, e.ID) == nil {
+							mu.Lock()
+							delivered++
+							mu.Unlock()
+						}
+					}
+				}
+			}
+		}()
+	}
+	
+	// Readers see each email's retries and updates only move forward
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retries := make(map[string]int)
+			updated := make(map[string]time.Time)
+			for !done() && ctx.Err() == nil {
+				for _, id := range ids {
+					e, err := q.Get(ctx, id)
+					if err != nil {
+						continue
+					}
+					if e.RetryCount < retries[id] || e.UpdatedAt.Before(updated[id]) {
+						t.Errorf("%s went back from %d retries at %v to %d at %v", id, retries[id], updated[id], e.RetryCount, e.UpdatedAt)
+					}
+					retries[id], updated[id] = e.RetryCount, e.UpdatedAt
+				}
+				q.Stats()
+			}
+		}()
+	}
+	
+	wg.Wait()
+	if !done() {
+		t.Fatalf("Expected all %d emails delivered before the deadline", total)
+	}
+	if size := q.Size(); size != 0 {
+		t.Errorf("Expected an empty queue, got %d", size)
+	}
+}
+
 func BenchmarkMemoryQueue_Enqueue(b *testing.B) {
+	ctx := context.Background()
 	q := NewMemoryQueue(b.N + 1)
 	
 	b.ResetTimer()
@@ -235,11 +349,12 @@ func BenchmarkMemoryQueue_Enqueue(b *testing.B) {
 			ID:     string(rune(i)),
 			Status: email.StatusQueued,
 		}
-		q.Enqueue(e)
+		q.Enqueue(ctx, e)
 	}
 }
 
 func BenchmarkMemoryQueue_Dequeue(b *testing.B) {
+	ctx := context.Background()
 	q := NewMemoryQueue(b.N + 1)
 	
 	// Pre-fill queue
@@ -248,11 +363,1465 @@ func BenchmarkMemoryQueue_Dequeue(b *testing.B) {
 			ID:     string(rune(i)),
 			Status: email.StatusQueued,
 		}
-		q.Enqueue(e)
+		q.Enqueue(ctx, e)
+	}
+	
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Dequeue(ctx, 1)
+	}
+}
+
+func TestMemoryQueue_Policies(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	q.SetPolicies(policy.Func{
+		PolicyName: "suppression-list",
+		CheckFunc: func(ctx context.Context, e *email.Email) error {
+			if e.To[0] == "unsubscribed@example.com" {
+				return errors.New("recipient unsubscribed")
+			}
+			return nil
+		},
+	})
+	
+	blocked := &email.Email{ID: "blocked", Status: email.StatusQueued, To: []string{"unsubscribed@example.com"}}
+	allowed := &email.Email{ID: "allowed", Status: email.StatusQueued, To: []string{"user@example.com"}}
+	for _, e := range []*email.Email{blocked, allowed} {
+		if err := q.Enqueue(ctx, e); err != nil {
+			t.Fatalf("Failed to enqueue email %s: %v", e.ID, err)
+		}
+	}
+	
+	emails, _ := q.Dequeue(ctx, 10)
+	if len(emails) != 1 || emails[0].ID != "allowed" {
+		t.Fatalf("Expected only allowed email to dequeue, got %v", emails)
+	}
+	
+	if blocked.Status != email.StatusRejected {
+		t.Errorf("Expected status %s, got %s", email.StatusRejected, blocked.Status)
+	}
+	if blocked.RejectedBy != "suppression-list" || blocked.RejectReason != "recipient unsubscribed" {
+		t.Errorf("Unexpected rejection details: %q %q", blocked.RejectedBy, blocked.RejectReason)
+	}
+	
+	stats := q.Stats()
+	if stats.TotalRejected != 1 {
+		t.Errorf("Expected 1 rejected, got %d", stats.TotalRejected)
+	}
+	if stats.Queued != 0 || q.Size() != 1 {
+		t.Errorf("Rejected email should leave the queue, got queued=%d size=%d", stats.Queued, q.Size())
+	}
+}
+
+func TestMemoryQueue_MaxQueueAge(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:     10,
+		MaxQueueAge: time.Hour,
+	})
+	
+	old := &email.Email{
+		ID:         "old",
+		Status:     email.StatusQueued,
+		RetryCount: 1,
+		CreatedAt:  time.Now().Add(-2 * time.Hour),
+	}
+	fresh := &email.Email{
+		ID:        "fresh",
+		Status:    email.StatusQueued,
+		CreatedAt: time.Now(),
+	}
+	
+	// Scheduled a week out, submitted two hours ago
+	scheduledAt := time.Now().Add(7 * 24 * time.Hour)
+	scheduled := &email.Email{
+		ID:          "scheduled",
+		Status:      email.StatusQueued,
+		CreatedAt:   time.Now().Add(-2 * time.Hour),
+		ScheduledAt: &scheduledAt,
+	}
+	
+	for _, e := range []*email.Email{old, fresh, scheduled} {
+		if err := q.Enqueue(ctx, e); err != nil {
+			t.Fatalf("Failed to enqueue email %s: %v", e.ID, err)
+		}
+	}
+	
+	emails, _ := q.Dequeue(ctx, 10)
+	if len(emails) != 1 || emails[0].ID != "fresh" {
+		t.Fatalf("Expected only fresh email to dequeue, got %v", emails)
+	}
+	
+	if old.Status != email.StatusFailed {
+		t.Errorf("Expected expired email status %s, got %s", email.StatusFailed, old.Status)
+	}
+	if old.LastError != ErrExpired {
+		t.Errorf("Expected last error %q, got %q", ErrExpired, old.LastError)
+	}
+	if q.Stats().TotalExpired != 1 {
+		t.Errorf("Expected 1 expired email, got %d", q.Stats().TotalExpired)
+	}
+	
+	if scheduled.ExpiresAt == nil || !scheduled.ExpiresAt.After(scheduledAt) {
+		t.Errorf("Scheduled email should expire relative to its scheduled time, got %v", scheduled.ExpiresAt)
+	}
+	
+	// Expired email is removed; fresh (sending) and scheduled remain
+	if q.Size() != 2 {
+		t.Errorf("Expected queue size 2, got %d", q.Size())
+	}
+}
+
+func TestMemoryQueue_NoMaxQueueAge(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	
+	e := &email.Email{
+		ID:        "old",
+		Status:    email.StatusQueued,
+		CreatedAt: time.Now().Add(-30 * 24 * time.Hour),
+	}
+	q.Enqueue(ctx, e)
+	
+	emails, _ := q.Dequeue(ctx, 1)
+	if len(emails) != 1 {
+		t.Fatal("Emails should not expire when max queue age is disabled")
+	}
+	if e.ExpiresAt != nil {
+		t.Error("ExpiresAt should not be set when max queue age is disabled")
+	}
+}
+
+func TestMemoryQueue_Dedup(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:     10,
+		DedupWindow: 50 * time.Millisecond,
+	})
+	
+	newEmail := func(id string) *email.Email {
+		return &email.Email{
+			ID:      id,
+			From:    "sender@example.com",
+			To:      []string{"recipient@example.com"},
+			Subject: "Reset your password",
+			Body:    "Click here",
+			Status:  email.StatusQueued,
+		}
+	}
+	
+	if err := q.Enqueue(ctx, newEmail("first")); err != nil {
+		t.Fatalf("Failed to enqueue email: %v", err)
+	}
+	
+	// Identical content is rejected with the original ID
+	err := q.Enqueue(ctx, newEmail("second"))
+	var dupErr *DuplicateError
+	if !errors.As(err, &dupErr) || !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("Expected duplicate error, got %v", err)
+	}
+	if dupErr.OriginalID != "first" {
+		t.Errorf("Expected original ID first, got %s", dupErr.OriginalID)
+	}
+	
+	// Opt-out allows intentional duplicates
+	optOut := newEmail("third")
+	optOut.AllowDuplicate = true
+	if err := q.Enqueue(ctx, optOut); err != nil {
+		t.Errorf("Expected duplicate with opt-out to be accepted, got %v", err)
+	}
+	
+	// Different content is accepted
+	other := newEmail("fourth")
+	other.Subject = "Welcome"
+	if err := q.Enqueue(ctx, other); err != nil {
+		t.Errorf("Expected distinct email to be accepted, got %v", err)
+	}
+	
+	// After the window the fingerprint expires
+	time.Sleep(60 * time.Millisecond)
+	if err := q.Enqueue(ctx, newEmail("fifth")); err != nil {
+		t.Errorf("Expected email to be accepted after window, got %v", err)
+	}
+}
+
+func TestMemoryQueue_DedupRaw(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:     100,
+		DedupWindow: time.Minute,
+	})
+	
+	newEmail := func(id, raw string) *email.Email {
+		return &email.Email{
+			ID:      id,
+			From:    "sender@example.com",
+			To:      []string{"recipient@example.com"},
+			Subject: "Report",
+			Body:    "Attached",
+			Raw:     []byte(raw),
+			Status:  email.StatusQueued,
+		}
+	}
+	
+	msg := "Subject: Report\r\nX-Campaign: one\r\n\r\nAttached\r\n"
+	if err := q.Enqueue(ctx, newEmail("first", msg)); err != nil {
+		t.Fatalf("Failed to enqueue email: %v", err)
+	}
+	if err := q.Enqueue(ctx, newEmail("second", msg)); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Expected the same raw message to be a duplicate, got %v", err)
+	}
+	
+	// The parsed fields match but the headers differ
+	other := strings.Replace(msg, "one", "two", 1)
+	if err := q.Enqueue(ctx, newEmail("third", other)); err != nil {
+		t.Errorf("Expected a message with other headers to be accepted, got %v", err)
+	}
+	
+	// Relayed mail differs only in the trace header added on receipt
+	relayed := func(id string) *email.Email {
+		e := newEmail(id, "Received: from client by mx id "+id+"\r\n"+msg)
+		e.Subject = "Relayed"
+		e.Relayed = true
+		return e
+	}
+	if err := q.Enqueue(ctx, relayed("fourth")); err != nil {
+		t.Fatalf("Failed to enqueue email: %v", err)
+	}
+	if err := q.Enqueue(ctx, relayed("fifth")); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Expected a retransmission to be a duplicate, got %v", err)
+	}
+}
+
+func TestDedupIndex_Bounded(t *testing.T) {
+	d := newDedupIndex(time.Hour, 3)
+	now := time.Now()
+	
+	for i := 0; i < 10; i++ {
+		d.check(string(rune('a'+i)), string(rune('a'+i)), now)
+	}
+	
+	if len(d.entries) != 3 || len(d.order) != 3 {
+		t.Errorf("Expected index bounded to 3 entries, got %d/%d", len(d.entries), len(d.order))
+	}
+}
+
+func TestMemoryQueue_Stats(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	
+	future := time.Now().Add(time.Hour)
+	for _, e := range []*email.Email{
+		{ID: "a", Status: email.StatusQueued, CreatedAt: time.Now().Add(-10 * time.Minute)},
+		{ID: "b", Status: email.StatusQueued, CreatedAt: time.Now()},
+		{ID: "c", Status: email.StatusQueued, CreatedAt: time.Now(), ScheduledAt: &future},
+	} {
+		q.Enqueue(ctx, e)
+	}
+	
+	stats := q.Stats()
+	if stats.Queued != 3 || stats.Sending != 0 || stats.Scheduled != 1 || stats.Retrying != 0 {
+		t.Errorf("Unexpected stats after enqueue: %+v", stats)
+	}
+	if stats.OldestQueuedAge < 10*time.Minute {
+		t.Errorf("Expected oldest queued age >= 10m, got %v", stats.OldestQueuedAge)
+	}
+	
+	emails, _ := q.Dequeue(ctx, 2)
+	if len(emails) != 2 {
+		t.Fatalf("Expected 2 emails, got %d", len(emails))
+	}
+	
+	stats = q.Stats()
+	if stats.Queued != 1 || stats.Sending != 2 {
+		t.Errorf("Unexpected stats after dequeue: %+v", stats)
+	}
+	
+	q.MarkDelivered(ctx, "a")
+	q.MarkFailed(ctx, "b", "connection refused", true)
+	
+	stats = q.Stats()
+	if stats.Queued != 2 || stats.Sending != 0 || stats.Retrying != 1 || stats.Scheduled != 2 {
+		t.Errorf("Unexpected stats after delivery and retry: %+v", stats)
+	}
+	if stats.OldestQueuedAge >= 10*time.Minute {
+		t.Errorf("Expected the oldest queued age to drop once a was delivered, got %v", stats.OldestQueuedAge)
+	}
+	
+	q.MarkFailed(ctx, "b", "connection refused", false)
+	
+	stats = q.Stats()
+	if stats.Queued != 1 || stats.Retrying != 0 {
+		t.Errorf("Unexpected stats after permanent failure: %+v", stats)
+	}
+}
+
+func TestMemoryQueue_CanceledContext(t *testing.T) {
+	q := NewMemoryQueue(10)
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	
+	err := q.Enqueue(ctx, &email.Email{ID: "test-1", Status: email.StatusQueued})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if q.Size() != 0 {
+		t.Errorf("Expected canceled enqueue to leave queue empty, got size %d", q.Size())
+	}
+	
+	if _, err := q.Dequeue(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from dequeue, got %v", err)
+	}
+}
+
+func TestMemoryQueue_DequeueLane(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	
+	q.Enqueue(ctx, &email.Email{ID: "bulk-1", Status: email.StatusQueued, Lane: email.LaneBulk})
+	q.Enqueue(ctx, &email.Email{ID: "bulk-2", Status: email.StatusQueued, Lane: email.LaneBulk})
+	q.Enqueue(ctx, &email.Email{ID: "default", Status: email.StatusQueued})
+	
+	emails, _ := q.DequeueLane(ctx, email.LaneTransactional, 10)
+	if len(emails) != 1 || emails[0].ID != "default" {
+		t.Fatalf("Expected only the default-lane email, got %v", emails)
+	}
+	
+	emails, _ = q.DequeueLane(ctx, email.LaneBulk, 1)
+	if len(emails) != 1 || emails[0].ID != "bulk-1" {
+		t.Fatalf("Expected bulk-1, got %v", emails)
+	}
+	
+	emails, _ = q.Dequeue(ctx, 10)
+	if len(emails) != 1 || emails[0].ID != "bulk-2" {
+		t.Fatalf("Expected Dequeue to return remaining bulk email, got %v", emails)
+	}
+}
+
+func TestMemoryQueue_PriorityOrder(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	
+	now := time.Now()
+	for _, e := range []*email.Email{
+		{ID: "normal-1", Status: email.StatusQueued, CreatedAt: now},
+		{ID: "high", Status: email.StatusQueued, CreatedAt: now, Priority: 5},
+		{ID: "normal-2", Status: email.StatusQueued, CreatedAt: now},
+		{ID: "medium", Status: email.StatusQueued, CreatedAt: now, Priority: 2},
+	} {
+		q.Enqueue(ctx, e)
+	}
+	
+	emails, _ := q.Dequeue(ctx, 4)
+	want := []string{"high", "medium", "normal-1", "normal-2"}
+	if len(emails) != len(want) {
+		t.Fatalf("Expected %d emails, got %d", len(want), len(emails))
+	}
+	for i, id := range want {
+		if emails[i].ID != id {
+			t.Errorf("Position %d: expected %s, got %s", i, id, emails[i].ID)
+		}
+	}
+}
+
+func TestMemoryQueue_FIFOWithinPriority(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(100)
+	
+	for i := 0; i < 20; i++ {
+		q.Enqueue(ctx, &email.Email{ID: fmt.Sprintf("email-%d", i), Status: email.StatusQueued})
+	}
+	
+	// Delivering from the middle must not disturb the order of the rest
+	first, _ := q.Dequeue(ctx, 5)
+	for _, e := range first {
+		q.MarkDelivered(ctx, e.ID)
+	}
+	
+	emails, _ := q.Dequeue(ctx, 20)
+	if len(emails) != 15 {
+		t.Fatalf("Expected 15 emails, got %d", len(emails))
+	}
+	for i, e := range emails {
+		if want := fmt.Sprintf("email-%d", i+5); e.ID != want {
+			t.Errorf("Position %d: expected %s, got %s", i, want, e.ID)
+		}
+	}
+	if q.Size() != 15 {
+		t.Errorf("Expected size 15, got %d", q.Size())
+	}
+}
+
+func TestMemoryQueue_PriorityAging(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:       10,
+		PriorityAging: time.Second,
+	})
+	
+	// Waiting ten seconds outranks a fresh priority-5 email
+	q.Enqueue(ctx, &email.Email{ID: "old-low", Status: email.StatusQueued, CreatedAt: time.Now().Add(-10 * time.Second)})
+	q.Enqueue(ctx, &email.Email{ID: "new-high", Status: email.StatusQueued, CreatedAt: time.Now(), Priority: 5})
+	
+	emails, _ := q.Dequeue(ctx, 1)
+	if len(emails) != 1 || emails[0].ID != "old-low" {
+		t.Fatalf("Expected aged email first, got %v", emails)
+	}
+}
+
+func TestMemoryQueue_PrioritySurvivesRetry(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	
+	q.Enqueue(ctx, &email.Email{ID: "test-1", Status: email.StatusQueued, Priority: 3})
+	q.Dequeue(ctx, 1)
+	q.MarkFailed(ctx, "test-1", "connection refused", true)
+	
+	if p := q.emailMap["test-1"].Priority; p != 3 {
+		t.Errorf("Expected priority 3 after retry, got %d", p)
+	}
+}
+
+func TestMemoryQueue_RetryKeepsPosition(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:       10,
+		PriorityAging: time.Second,
+	})
+	
+	now := time.Now()
+	q.Enqueue(ctx, &email.Email{ID: "a", Status: email.StatusQueued, CreatedAt: now.Add(-10 * time.Second)})
+	q.Enqueue(ctx, &email.Email{ID: "b", Status: email.StatusQueued, CreatedAt: now})
+	q.Enqueue(ctx, &email.Email{ID: "c", Status: email.StatusQueued, CreatedAt: now})
+	
+	q.Dequeue(ctx, 1)
+	q.MarkFailed(ctx, "a", "451 try again later", true)
+	
+	// Bring the retry forward rather than wait out the backoff
+	due := time.Now()
+	q.emailMap["a"].ScheduledAt = &due
+	
+	// The retry goes back ahead of b and c and keeps its age over new-high
+	q.Enqueue(ctx, &email.Email{ID: "new-high", Status: email.StatusQueued, CreatedAt: time.Now(), Priority: 5})
+	emails, _ := q.Dequeue(ctx, 4)
+	want := []string{"a", "new-high", "b", "c"}
+	if len(emails) != len(want) {
+		t.Fatalf("Expected %d emails, got %d", len(want), len(emails))
+	}
+	for i, id := range want {
+		if emails[i].ID != id {
+			t.Errorf("Position %d: expected %s, got %s", i, id, emails[i].ID)
+		}
+	}
+}
+
+func BenchmarkMemoryQueue_DequeueMixedPriority(b *testing.B) {
+	const size = 100000
+	ctx := context.Background()
+	
+	q := NewMemoryQueue(size + b.N)
+	now := time.Now()
+	for i := 0; i < size; i++ {
+		q.Enqueue(ctx, &email.Email{
+			ID:        fmt.Sprintf("email-%d", i),
+			Status:    email.StatusQueued,
+			CreatedAt: now.Add(-time.Duration(i%600) * time.Second),
+			Priority:  i % 10,
+		})
+	}
+	
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		emails, _ := q.Dequeue(ctx, 10)
+		
+		// Put them back so the queue stays at 100k
+		b.StopTimer()
+		for _, e := range emails {
+			q.MarkDelivered(ctx, e.ID)
+			e.Status = email.StatusQueued
+			q.Enqueue(ctx, e)
+		}
+		b.StartTimer()
+	}
+}
+
+func BenchmarkMemoryQueue_DequeueWithScheduled(b *testing.B) {
+	const scheduled = 50000
+	ctx := context.Background()
+	
+	q := NewMemoryQueue(scheduled + 10)
+	later := time.Now().Add(24 * time.Hour)
+	for i := 0; i < scheduled; i++ {
+		q.Enqueue(ctx, &email.Email{
+			ID:          fmt.Sprintf("scheduled-%d", i),
+			Status:      email.StatusQueued,
+			ScheduledAt: &later,
+		})
+	}
+	
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for j := 0; j < 10; j++ {
+			q.Enqueue(ctx, &email.Email{
+				ID:     fmt.Sprintf("ready-%d-%d", i, j),
+				Status: email.StatusQueued,
+			})
+		}
+		b.StartTimer()
+		
+		emails, _ := q.Dequeue(ctx, 10)
+		for _, e := range emails {
+			q.MarkDelivered(ctx, e.ID)
+		}
+	}
+}
+
+func TestMemoryQueue_ScheduledBecomesReady(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	
+	soon := time.Now().Add(50 * time.Millisecond)
+	later := time.Now().Add(time.Hour)
+	q.Enqueue(ctx, &email.Email{ID: "later", Status: email.StatusQueued, ScheduledAt: &later})
+	q.Enqueue(ctx, &email.Email{ID: "soon", Status: email.StatusQueued, ScheduledAt: &soon})
+	
+	if emails, _ := q.Dequeue(ctx, 10); len(emails) != 0 {
+		t.Fatalf("Expected no ready emails, got %d", len(emails))
+	}
+	
+	time.Sleep(60 * time.Millisecond)
+	
+	emails, _ := q.Dequeue(ctx, 10)
+	if len(emails) != 1 || emails[0].ID != "soon" {
+		t.Fatalf("Expected soon email once due, got %v", emails)
+	}
+	
+	// A retry goes back on the schedule
+	q.MarkFailed(ctx, "soon", "temporary failure", true)
+	if emails, _ := q.Dequeue(ctx, 10); len(emails) != 0 {
+		t.Errorf("Expected retried email to wait for its backoff, got %d", len(emails))
+	}
+	if stats := q.Stats(); stats.Scheduled != 2 {
+		t.Errorf("Expected 2 scheduled, got %d", stats.Scheduled)
+	}
+}
+
+func TestScheduleHeap_Remove(t *testing.T) {
+	h := newScheduleHeap()
+	now := time.Now()
+	emails := make(map[string]*email.Email)
+	for i := 0; i < 8; i++ {
+		at := now.Add(time.Duration(8-i) * time.Minute)
+		e := &email.Email{ID: fmt.Sprintf("email-%d", i), ScheduledAt: &at}
+		emails[e.ID] = e
+		heap.Push(h, e)
+	}
+	
+	h.remove(emails["email-3"])
+	h.remove(emails["email-7"])
+	h.remove(&email.Email{ID: "missing"})
+	
+	var got []string
+	for h.Len() > 0 {
+		got = append(got, heap.Pop(h).(*email.Email).ID)
+	}
+	want := []string{"email-6", "email-5", "email-4", "email-2", "email-1", "email-0"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if len(h.pos) != 0 {
+		t.Errorf("Expected no positions left, got %v", h.pos)
+	}
+}
+
+func BenchmarkMemoryQueue_Interleaved(b *testing.B) {
+	const size = 100000
+	ctx := context.Background()
+	
+	q := NewMemoryQueue(size + b.N)
+	for i := 0; i < size; i++ {
+		q.Enqueue(ctx, &email.Email{
+			ID:       fmt.Sprintf("email-%d", i),
+			Status:   email.StatusQueued,
+			Priority: i % 3,
+		})
 	}
 	
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		q.Dequeue(1)
+		q.Enqueue(ctx, &email.Email{
+			ID:       fmt.Sprintf("new-%d", i),
+			Status:   email.StatusQueued,
+			Priority: i % 3,
+		})
+		
+		emails, _ := q.Dequeue(ctx, 1)
+		for _, e := range emails {
+			q.MarkDelivered(ctx, e.ID)
+		}
+	}
+}
+
+func TestMemoryQueue_Notify(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	
+	wake := q.Notify()
+	select {
+	case <-wake:
+		t.Fatal("Notify channel should block while nothing is enqueued")
+	default:
+	}
+	
+	q.Enqueue(ctx, &email.Email{ID: "test-1", Status: email.StatusQueued})
+	
+	select {
+	case <-wake:
+	case <-time.After(time.Second):
+		t.Fatal("Notify channel should be closed by Enqueue")
 	}
-}
\ No newline at end of file
+	
+	select {
+	case <-q.Notify():
+		t.Error("A fresh Notify channel should block until the next Enqueue")
+	default:
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	exponential := retryPolicy{base: time.Minute, max: time.Hour}
+	scheduled := retryPolicy{schedule: []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute}}
+	
+	tests := []struct {
+		name    string
+		policy  retryPolicy
+		attempt int
+		random  float64
+		want    time.Duration
+	}{
+		{name: "first retry uses base", policy: exponential, attempt: 1, want: time.Minute},
+		{name: "doubles each attempt", policy: exponential, attempt: 3, want: 4 * time.Minute},
+		{name: "capped at max", policy: exponential, attempt: 10, want: time.Hour},
+		{name: "large attempt does not overflow", policy: exponential, attempt: 200, want: time.Hour},
+		{name: "schedule first entry", policy: scheduled, attempt: 1, want: time.Minute},
+		{name: "schedule later entry", policy: scheduled, attempt: 3, want: 30 * time.Minute},
+		{name: "schedule repeats last entry", policy: scheduled, attempt: 8, want: 30 * time.Minute},
+		{name: "jitter low end", policy: withJitter(exponential, 0.2), attempt: 1, random: 0, want: 48 * time.Second},
+		{name: "jitter midpoint", policy: withJitter(exponential, 0.2), attempt: 1, random: 0.5, want: time.Minute},
+		{name: "jitter high end", policy: withJitter(exponential, 0.2), attempt: 1, random: 1, want: 72 * time.Second},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			random := tt.random
+			tt.policy.random = func() float64 { return random }
+			if got := tt.policy.delay(tt.attempt); got != tt.want {
+				t.Errorf("delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_After(t *testing.T) {
+	policy := retryPolicy{base: 5 * time.Minute, max: time.Hour, greylist: 2 * time.Minute}
+	
+	tests := []struct {
+		name    string
+		policy  retryPolicy
+		retries int
+		failure *email.Failure
+		want    time.Duration
+	}{
+		{name: "no failure", policy: policy, want: 5 * time.Minute},
+		{name: "greylisted first attempt", policy: policy, failure: &email.Failure{Code: 450, Greylisted: true}, want: 2 * time.Minute},
+		{name: "greylisted later attempt", policy: policy, retries: 1, failure: &email.Failure{Code: 450, Greylisted: true}, want: 10 * time.Minute},
+		{name: "greylisting disabled", policy: retryPolicy{base: 5 * time.Minute, max: time.Hour}, failure: &email.Failure{Code: 450, Greylisted: true}, want: 5 * time.Minute},
+		{name: "server hint", policy: policy, retries: 2, failure: &email.Failure{Code: 421, RetryAfter: 90}, want: 90 * time.Second},
+		{name: "server hint capped", policy: policy, failure: &email.Failure{Code: 421, RetryAfter: 86400}, want: time.Hour},
+		{name: "hint beats greylist delay", policy: policy, failure: &email.Failure{Code: 451, Greylisted: true, RetryAfter: 300}, want: 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.after(tt.retries, tt.failure); got != tt.want {
+				t.Errorf("after(%d) = %v, want %v", tt.retries, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryQueue_GreylistRetry(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:            10,
+		RetryDelay:         time.Hour,
+		RetryJitter:        -1,
+		GreylistRetryDelay: 2 * time.Minute,
+	})
+	
+	// Only the first attempt is retried early; later ones back off as usual
+	q.Enqueue(ctx, &email.Email{ID: "first", Status: email.StatusQueued})
+	q.Enqueue(ctx, &email.Email{ID: "second", Status: email.StatusQueued, RetryCount: 1})
+	q.Dequeue(ctx, 2)
+	
+	greylisted := email.Failure{Code: 450, Text: "4.2.0 Greylisted", Greylisted: true}
+	before := time.Now()
+	for id, want := range map[string]time.Duration{"first": 2 * time.Minute, "second": 2 * time.Hour} {
+		q.RecordFailure(ctx, id, "450 4.2.0 Greylisted", CategoryOther, greylisted, true)
+		
+		e := q.emailMap[id]
+		if e.ScheduledAt == nil {
+			t.Fatalf("Expected %s to be retried", id)
+		}
+		if wait := e.ScheduledAt.Sub(before); wait < want || wait > want+time.Second {
+			t.Errorf("Expected %s to be retried after %v, got %v", id, want, wait)
+		}
+	}
+}
+
+func withJitter(p retryPolicy, jitter float64) retryPolicy {
+	p.jitter = jitter
+	return p
+}
+
+func TestMemoryQueue_RetrySchedule(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:       10,
+		RetrySchedule: []time.Duration{time.Minute, time.Hour},
+		RetryJitter:   -1,
+	})
+	
+	q.Enqueue(ctx, &email.Email{ID: "test-1", Status: email.StatusQueued})
+	q.Dequeue(ctx, 1)
+	
+	before := time.Now()
+	q.MarkFailed(ctx, "test-1", "connection refused", true)
+	
+	e := q.emailMap["test-1"]
+	if e.ScheduledAt == nil {
+		t.Fatal("Expected retry to be scheduled")
+	}
+	if wait := e.ScheduledAt.Sub(before); wait < time.Minute || wait > time.Minute+time.Second {
+		t.Errorf("Expected first retry after 1m, got %v", wait)
+	}
+}
+
+func TestMemoryQueue_MaxRetryDuration(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:          10,
+		MaxRetryDuration: 24 * time.Hour,
+	})
+	
+	q.Enqueue(ctx, &email.Email{ID: "recent", Status: email.StatusQueued})
+	q.Enqueue(ctx, &email.Email{ID: "stale", Status: email.StatusQueued})
+	emails, _ := q.Dequeue(ctx, 2)
+	if len(emails) != 2 || emails[0].FirstAttemptAt == nil {
+		t.Fatalf("Expected 2 emails with a first attempt time, got %v", emails)
+	}
+	
+	// The stale email has been retrying for longer than the window
+	stale := q.emailMap["stale"]
+	firstAttempt := time.Now().Add(-25 * time.Hour)
+	stale.FirstAttemptAt = &firstAttempt
+	
+	q.MarkFailed(ctx, "recent", "451 try again later", true)
+	q.MarkFailed(ctx, "stale", "451 try again later", true)
+	
+	if e := q.emailMap["recent"]; e == nil || e.Status != email.StatusQueued {
+		t.Error("Email within the retry window should be retried")
+	}
+	if _, ok := q.emailMap["stale"]; ok {
+		t.Fatal("Email past the retry window should leave the queue")
+	}
+	if stale.Status != email.StatusFailed {
+		t.Errorf("Expected status %s, got %s", email.StatusFailed, stale.Status)
+	}
+	if !strings.HasPrefix(stale.LastError, ErrRetryWindowExceeded) || !strings.Contains(stale.LastError, "451 try again later") {
+		t.Errorf("Expected retry window error with the last SMTP error, got %q", stale.LastError)
+	}
+}
+
+func TestMemoryQueue_Drain(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	q.Enqueue(ctx, &email.Email{ID: "test-1", Status: email.StatusQueued})
+	
+	done := make(chan error, 1)
+	go func() {
+		drainCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		done <- q.Drain(drainCtx)
+	}()
+	
+	// New emails are refused once draining starts
+	time.Sleep(20 * time.Millisecond)
+	if err := q.Enqueue(ctx, &email.Email{ID: "test-2", Status: email.StatusQueued}); err != ErrDraining {
+		t.Fatalf("Expected ErrDraining, got %v", err)
+	}
+	
+	emails, _ := q.Dequeue(ctx, 1)
+	if len(emails) != 1 {
+		t.Fatalf("Expected queued email to still be dequeued, got %d", len(emails))
+	}
+	select {
+	case <-done:
+		t.Fatal("Drain returned while an email was still sending")
+	case <-time.After(2 * drainPollInterval):
+	}
+	
+	q.MarkDelivered(ctx, "test-1")
+	if err := <-done; err != nil {
+		t.Errorf("Expected drain to finish, got %v", err)
+	}
+}
+
+func TestMemoryQueue_DrainDeadline(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	q.Enqueue(ctx, &email.Email{ID: "test-1", Status: email.StatusQueued})
+	
+	drainCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := q.Drain(drainCtx); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestMemoryQueue_PersistRestore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	
+	q := NewMemoryQueue(10)
+	later := time.Now().Add(time.Hour)
+	q.Enqueue(ctx, &email.Email{ID: "sending", Status: email.StatusQueued})
+	q.Dequeue(ctx, 1)
+	q.Enqueue(ctx, &email.Email{
+		ID:          "scheduled",
+		Status:      email.StatusQueued,
+		ScheduledAt: &later,
+		Attachments: []email.Attachment{{Filename: "big.bin", Data: make([]byte, persistInlineLimit+1)}},
+	})
+	
+	n, err := q.Persist(path)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 emails persisted, got %d (%v)", n, err)
+	}
+	
+	restored := NewMemoryQueue(10)
+	n, err = restored.Restore(ctx, path)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 emails restored, got %d (%v)", n, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected persisted file to be removed after restore")
+	}
+	
+	emails, _ := restored.Dequeue(ctx, 10)
+	if len(emails) != 1 || emails[0].ID != "sending" {
+		t.Fatalf("Expected interrupted email to be ready again, got %v", emails)
+	}
+	if e := restored.emailMap["scheduled"]; e == nil || e.ScheduledAt == nil {
+		t.Error("Expected scheduled email to keep its schedule")
+	} else if len(e.Attachments) != 1 || len(e.Attachments[0].Data) != persistInlineLimit+1 {
+		t.Error("Expected large attachment to be restored")
+	}
+	if _, err := os.Stat(attachmentDir(path)); !os.IsNotExist(err) {
+		t.Error("Expected attachment files to be removed after restore")
+	}
+	
+	// Restoring again finds nothing
+	if n, err := restored.Restore(ctx, path); n != 0 || err != nil {
+		t.Errorf("Expected nothing to restore, got %d (%v)", n, err)
+	}
+}
+
+func TestMemoryQueue_RestoreAfterFailure(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	
+	q := NewMemoryQueue(10)
+	for _, id := range []string{"a", "b", "c"} {
+		q.Enqueue(ctx, &email.Email{ID: id, Status: email.StatusQueued})
+	}
+	if _, err := q.Persist(path); err != nil {
+		t.Fatal(err)
+	}
+	
+	// Only room for two: the third fails and the file is kept
+	restored := NewMemoryQueue(2)
+	if n, err := restored.Restore(ctx, path); err != ErrQueueFull || n != 2 {
+		t.Fatalf("Expected 2 restored before the queue filled, got %d (%v)", n, err)
+	}
+	
+	// Trying again must not enqueue the first two a second time
+	if n, err := restored.Restore(ctx, path); err != ErrQueueFull || n != 0 {
+		t.Errorf("Expected nothing new restored, got %d (%v)", n, err)
+	}
+	if restored.Size() != 2 {
+		t.Errorf("Expected 2 emails queued, got %d", restored.Size())
+	}
+	
+	// A damaged file restores nothing
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, append(data, "{not json\n"...), 0o600)
+	damaged := NewMemoryQueue(10)
+	if n, err := damaged.Restore(ctx, path); err == nil || n != 0 || damaged.Size() != 0 {
+		t.Errorf("Expected a damaged file to restore nothing, got %d emails (%v)", damaged.Size(), err)
+	}
+}
+
+func TestMemoryQueue_DequeueReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	q.Enqueue(ctx, &email.Email{ID: "test-1", To: []string{"a@example.com"}, Status: email.StatusQueued})
+	
+	emails, _ := q.Dequeue(ctx, 1)
+	emails[0].To[0] = "b@example.com"
+	emails[0].LastError = "scratch"
+	
+	got, err := q.Get(ctx, "test-1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.To[0] != "a@example.com" || got.LastError != "" {
+		t.Errorf("Modifying a dequeued email changed the queue's record: %+v", got)
+	}
+	if got.Status != email.StatusSending {
+		t.Errorf("Expected status %s, got %s", email.StatusSending, got.Status)
+	}
+	
+	q.MarkDelivered(ctx, "test-1")
+	if _, err := q.Get(ctx, "test-1"); err != ErrEmailNotFound {
+		t.Errorf("Expected ErrEmailNotFound after delivery, got %v", err)
+	}
+}
+
+func TestMemoryQueue_Quarantine(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	later := time.Now().Add(time.Hour)
+	q.Enqueue(ctx, &email.Email{ID: "ready", Status: email.StatusQueued, RetryCount: 1})
+	q.Enqueue(ctx, &email.Email{ID: "scheduled", Status: email.StatusQueued, ScheduledAt: &later})
+	
+	if err := q.Quarantine(ctx, "ready", "suspicious link"); err != nil {
+		t.Fatalf("Quarantine failed: %v", err)
+	}
+	if err := q.Quarantine(ctx, "scheduled", "manual review"); err != nil {
+		t.Fatalf("Quarantine failed: %v", err)
+	}
+	if err := q.Quarantine(ctx, "missing", ""); err != ErrEmailNotFound {
+		t.Errorf("Expected ErrEmailNotFound, got %v", err)
+	}
+	
+	if emails, _ := q.Dequeue(ctx, 10); len(emails) != 0 {
+		t.Fatalf("Workers should skip quarantined emails, got %d", len(emails))
+	}
+	if q.scheduled.Len() != 0 {
+		t.Error("Expected quarantined email to leave the schedule")
+	}
+	
+	stats := q.Stats()
+	if stats.Quarantined != 2 || stats.Queued != 0 || stats.Retrying != 0 {
+		t.Errorf("Unexpected stats while quarantined: %+v", stats)
+	}
+	
+	parked, _ := q.Quarantined(ctx)
+	if len(parked) != 2 || parked[0].ID != "ready" || parked[0].QuarantineReason != "suspicious link" {
+		t.Fatalf("Unexpected quarantined list: %v", parked)
+	}
+	
+	if err := q.ReleaseQuarantined(ctx, "ready"); err != nil {
+		t.Fatalf("ReleaseQuarantined failed: %v", err)
+	}
+	emails, _ := q.Dequeue(ctx, 10)
+	if len(emails) != 1 || emails[0].ID != "ready" || emails[0].RetryCount != 1 {
+		t.Fatalf("Expected released email with its retry count, got %v", emails)
+	}
+	
+	if err := q.RejectQuarantined(ctx, "ready", "spam"); err != email.ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition for a sending email, got %v", err)
+	}
+	if err := q.RejectQuarantined(ctx, "scheduled", "spam"); err != nil {
+		t.Fatalf("RejectQuarantined failed: %v", err)
+	}
+	
+	stats = q.Stats()
+	if stats.Quarantined != 0 || stats.TotalRejected != 1 || q.Size() != 1 {
+		t.Errorf("Unexpected stats after reject: %+v", stats)
+	}
+}
+
+func TestMemoryQueue_HoldEmail(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	expired := time.Now().Add(-time.Minute)
+	q.Enqueue(ctx, &email.Email{ID: "held", Status: email.StatusQueued, RetryCount: 1})
+	q.Enqueue(ctx, &email.Email{ID: "overdue", Status: email.StatusQueued, ExpiresAt: &expired})
+	
+	for _, id := range []string{"held", "overdue"} {
+		if err := q.HoldEmail(ctx, id, "disputed"); err != nil {
+			t.Fatalf("HoldEmail failed: %v", err)
+		}
+	}
+	if err := q.HoldEmail(ctx, "held", ""); err != email.ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition holding twice, got %v", err)
+	}
+	if err := q.HoldEmail(ctx, "missing", ""); err != ErrEmailNotFound {
+		t.Errorf("Expected ErrEmailNotFound, got %v", err)
+	}
+	if emails, _ := q.Dequeue(ctx, 10); len(emails) != 0 {
+		t.Fatalf("Workers should skip held emails, got %d", len(emails))
+	}
+	if stats := q.Stats(); stats.Held != 2 || stats.Queued != 0 || stats.Retrying != 0 || stats.TotalExpired != 0 {
+		t.Errorf("Unexpected stats while held: %+v", stats)
+	}
+	
+	// Held emails stay held across a restart
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	if _, err := q.Persist(path); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewMemoryQueue(10)
+	if _, err := restored.Restore(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if emails, _ := restored.Dequeue(ctx, 10); len(emails) != 0 {
+		t.Fatalf("Expected restored emails still held, got %d", len(emails))
+	}
+	e, _ := restored.Get(ctx, "held")
+	if e.Status != email.StatusHeld || e.HoldReason != "disputed" || e.HeldAt == nil || restored.Stats().Held != 2 {
+		t.Fatalf("Unexpected restored email: %+v", e)
+	}
+	
+	// Released, an email keeps its retry count
+	if err := restored.ReleaseEmail(ctx, "held"); err != nil {
+		t.Fatalf("ReleaseEmail failed: %v", err)
+	}
+	if err := restored.ReleaseEmail(ctx, "held"); err != email.ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition releasing twice, got %v", err)
+	}
+	emails, _ := restored.Dequeue(ctx, 10)
+	if len(emails) != 1 || emails[0].ID != "held" || emails[0].RetryCount != 1 || emails[0].HoldReason != "" {
+		t.Fatalf("Expected the released email with its retry count, got %v", emails)
+	}
+	
+	// A held email can still be cancelled
+	if err := restored.Cancel(ctx, "overdue", "dispute upheld"); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if stats := restored.Stats(); stats.Held != 0 || stats.TotalRejected != 1 {
+		t.Errorf("Unexpected stats after cancelling: %+v", stats)
+	}
+}
+
+func TestMemoryQueue_Defer(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:      10,
+		DeferDelay:   time.Hour,
+		MaxDeferrals: 2,
+	})
+	q.Enqueue(ctx, &email.Email{ID: "test-1", Status: email.StatusQueued})
+	
+	for i := 1; i <= 2; i++ {
+		q.Dequeue(ctx, 1)
+		if err := q.Defer(ctx, "test-1", "lookup timed out", 0); err != nil {
+			t.Fatalf("Defer failed: %v", err)
+		}
+		
+		e := q.emailMap["test-1"]
+		if e.Status != email.StatusQueued || e.DeferCount != i || e.RetryCount != 0 {
+			t.Fatalf("Expected deferral %d without a retry, got %+v", i, e)
+		}
+		if e.ScheduledAt.Before(time.Now().Add(59 * time.Minute)) {
+			t.Fatalf("Expected email to wait the defer delay, scheduled at %v", e.ScheduledAt)
+		}
+		
+		// Make it due again
+		now := time.Now()
+		e.ScheduledAt = &now
+	}
+	
+	emails, _ := q.Dequeue(ctx, 1)
+	if len(emails) != 1 {
+		t.Fatal("Expected deferred email to be dequeued once due")
+	}
+	e := q.emailMap["test-1"]
+	q.Defer(ctx, "test-1", "lookup timed out", 0)
+	if _, ok := q.emailMap["test-1"]; ok {
+		t.Fatal("Expected email past the deferral limit to fail")
+	}
+	if e.Status != email.StatusFailed || !strings.HasPrefix(e.LastError, ErrDeferralLimit) {
+		t.Errorf("Expected deferral limit failure, got %s: %q", e.Status, e.LastError)
+	}
+}
+
+// recordingListener records events and checks the queue lock is free by
+// reading the queue from inside each callback.
+type recordingListener struct {
+	q      *MemoryQueue
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *recordingListener) record(event string) {
+	l.q.Size()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *recordingListener) OnEnqueued(e *email.Email) {
+	l.record("enqueued " + e.ID)
+}
+
+func (l *recordingListener) OnDequeued(e *email.Email) {
+	l.record("dequeued " + e.ID)
+}
+
+func (l *recordingListener) OnDelivered(id string, duration time.Duration) {
+	l.record("delivered " + id)
+}
+
+func (l *recordingListener) OnFailed(id string, reason string, willRetry bool) {
+	l.record(fmt.Sprintf("failed %s %s %v", id, reason, willRetry))
+}
+
+func TestMemoryQueue_Listeners(t *testing.T) {
+	ctx := context.Background()
+	l := &recordingListener{}
+	q := NewMemoryQueue(10, l)
+	l.q = q
+	
+	past := time.Now().Add(-time.Minute)
+	q.Enqueue(ctx, &email.Email{ID: "test-1", Status: email.StatusQueued, CreatedAt: time.Now()})
+	q.Enqueue(ctx, &email.Email{ID: "test-2", Status: email.StatusQueued, CreatedAt: time.Now()})
+	q.Enqueue(ctx, &email.Email{ID: "test-3", Status: email.StatusQueued, CreatedAt: time.Now(), ExpiresAt: &past})
+	
+	q.Dequeue(ctx, 10)
+	q.MarkDelivered(ctx, "test-1")
+	q.MarkFailed(ctx, "test-2", "mailbox full", true)
+	
+	// Retried emails are due again later; fail this one for good
+	now := time.Now()
+	q.emailMap["test-2"].ScheduledAt = &now
+	q.Dequeue(ctx, 10)
+	q.MarkFailed(ctx, "test-2", "no such user", false)
+	
+	expected := []string{
+		"enqueued test-1",
+		"enqueued test-2",
+		"enqueued test-3",
+		"failed test-3 " + ErrExpired + " false",
+		"dequeued test-1",
+		"dequeued test-2",
+		"delivered test-1",
+		"failed test-2 mailbox full true",
+		"dequeued test-2",
+		"failed test-2 no such user false",
+	}
+	if strings.Join(l.events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected events:\n%s\nexpected:\n%s", strings.Join(l.events, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestMemoryQueue_FailureCategories(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	
+	past := time.Now().Add(-time.Minute)
+	q.Enqueue(ctx, &email.Email{ID: "test-1", Status: email.StatusQueued})
+	q.Enqueue(ctx, &email.Email{ID: "test-2", Status: email.StatusQueued})
+	q.Enqueue(ctx, &email.Email{ID: "test-3", Status: email.StatusQueued})
+	q.Enqueue(ctx, &email.Email{ID: "test-4", Status: email.StatusQueued, ExpiresAt: &past})
+	q.Dequeue(ctx, 10)
+	
+	q.MarkFailedCategory(ctx, "test-1", "550 user unknown", "smtp 55x", false)
+	q.MarkFailedCategory(ctx, "test-2", "451 try later", "smtp 45x", true)
+	q.MarkFailed(ctx, "test-3", "unexpected", false)
+	
+	// Only emails that failed for good are counted
+	expected := map[string]int64{"smtp 55x": 1, CategoryOther: 1, CategoryExpired: 1}
+	categories := q.Stats().FailureCategories
+	if len(categories) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, categories)
+	}
+	for category, n := range expected {
+		if categories[category] != n {
+			t.Errorf("Expected %d failures for %q, got %d", n, category, categories[category])
+		}
+	}
+}
+
+func TestMemoryQueue_RemovesSpilledRaw(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	
+	path := filepath.Join(t.TempDir(), "raw.eml")
+	if err := os.WriteFile(path, []byte("Subject: Test\r\n\r\nBody\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	
+	q.Enqueue(ctx, &email.Email{ID: "test-1", RawPath: path, Status: email.StatusQueued})
+	q.Dequeue(ctx, 1)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected the raw message to be kept while queued: %v", err)
+	}
+	
+	q.MarkDelivered(ctx, "test-1")
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the raw message to be removed once delivered, got %v", err)
+	}
+}
+
+func TestMemoryQueue_SLABoost(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:  2000,
+		SLABoost: 10 * time.Second,
+	})
+	
+	// Bulk and high-priority traffic with a few SLA emails mixed in: those
+	// due within the boost window jump the queue, the rest wait their turn
+	now := time.Now()
+	soon, later := now.Add(5*time.Second), now.Add(time.Hour)
+	var urgent []string
+	for i := 0; i < 1000; i++ {
+		e := &email.Email{ID: fmt.Sprintf("bulk-%d", i), Status: email.StatusQueued, CreatedAt: now, Lane: email.LaneBulk}
+		switch {
+		case i%2 == 0:
+			e.ID, e.Lane, e.Priority = fmt.Sprintf("high-%d", i), email.LaneTransactional, 5
+		case i%200 == 99:
+			e.ID, e.SLADeadline = fmt.Sprintf("sla-%d", i), &soon
+			urgent = append(urgent, e.ID)
+		case i%200 == 199:
+			e.ID, e.SLADeadline = fmt.Sprintf("relaxed-%d", i), &later
+		}
+		q.Enqueue(ctx, e)
+	}
+	
+	emails, _ := q.Dequeue(ctx, 10)
+	for i, id := range urgent {
+		if emails[i].ID != id {
+			t.Errorf("Position %d: expected %s, got %s", i, id, emails[i].ID)
+		}
+	}
+	for _, e := range emails[len(urgent):] {
+		if !strings.HasPrefix(e.ID, "high-") {
+			t.Errorf("Expected the rest of the batch by priority, got %s", e.ID)
+		}
+	}
+	
+	// Everything else still drains
+	total := len(emails)
+	for {
+		batch, _ := q.Dequeue(ctx, 100)
+		if len(batch) == 0 {
+			break
+		}
+		for _, e := range batch {
+			if e.SLABreached {
+				t.Errorf("Expected %s to be within its SLA", e.ID)
+			}
+		}
+		total += len(batch)
+	}
+	if total != 1000 {
+		t.Errorf("Expected all 1000 emails dequeued, got %d", total)
+	}
+	for lane, h := range q.ready.sla {
+		if h.Len() != 0 {
+			t.Errorf("Expected the %s SLA heap to be empty, has %d", lane, h.Len())
+		}
+	}
+}
+
+// slaListener is a recordingListener that also records SLA breaches.
+type slaListener struct {
+	recordingListener
+}
+
+func (l *slaListener) OnSLABreached(id string, deadline time.Time, late time.Duration) {
+	l.record("sla breached " + id)
+}
+
+func TestMemoryQueue_SLABreach(t *testing.T) {
+	ctx := context.Background()
+	l := &slaListener{}
+	q := NewMemoryQueue(10, l)
+	l.q = q
+	
+	missed, met := time.Now().Add(-time.Second), time.Now().Add(time.Minute)
+	q.Enqueue(ctx, &email.Email{ID: "late", Status: email.StatusQueued, CreatedAt: time.Now(), SLADeadline: &missed})
+	q.Enqueue(ctx, &email.Email{ID: "on-time", Status: email.StatusQueued, CreatedAt: time.Now(), SLADeadline: &met})
+	
+	emails, _ := q.Dequeue(ctx, 10)
+	if len(emails) != 2 || emails[0].ID != "late" || !emails[0].SLABreached || emails[1].SLABreached {
+		t.Fatalf("Expected only the late email to breach its SLA, got %+v", emails)
+	}
+	if stats := q.Stats(); stats.TotalSLABreached != 1 {
+		t.Errorf("Expected 1 SLA breach, got %d", stats.TotalSLABreached)
+	}
+	
+	// Retries are no longer bound by the SLA
+	q.MarkFailed(ctx, "late", "mailbox busy", true)
+	now := time.Now()
+	q.emailMap["late"].ScheduledAt = &now
+	q.Dequeue(ctx, 10)
+	if stats := q.Stats(); stats.TotalSLABreached != 1 {
+		t.Errorf("Expected a retry not to breach again, got %d breaches", stats.TotalSLABreached)
+	}
+	
+	var breaches []string
+	for _, event := range l.events {
+		if strings.HasPrefix(event, "sla breached") {
+			breaches = append(breaches, event)
+		}
+	}
+	if len(breaches) != 1 || breaches[0] != "sla breached late" {
+		t.Errorf("Expected one breach event for late, got %v", breaches)
+	}
+}
+
+func TestMemoryQueue_Cancel(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:       10,
+		RetrySchedule: []time.Duration{time.Millisecond},
+		RetryJitter:   -1,
+	})
+	q.Enqueue(ctx, &email.Email{ID: "sending", Status: email.StatusQueued})
+	q.Dequeue(ctx, 1)
+	q.Enqueue(ctx, &email.Email{ID: "waiting", Status: email.StatusQueued})
+	
+	// A waiting email is rejected straight away
+	if err := q.Cancel(ctx, "waiting", ""); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if _, err := q.Get(ctx, "waiting"); err != ErrEmailNotFound {
+		t.Errorf("Expected cancelled email to leave the queue, got %v", err)
+	}
+	
+	// A sending email is flagged, and not retried if the attempt fails
+	if err := q.Cancel(ctx, "sending", "withdrawn"); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if reason, ok := q.Cancelled(ctx, "sending"); !ok || reason != "withdrawn" {
+		t.Errorf("Expected sending email flagged as cancelled, got %q %v", reason, ok)
+	}
+	q.MarkFailed(ctx, "sending", "connection refused", true)
+	time.Sleep(5 * time.Millisecond)
+	if emails, _ := q.Dequeue(ctx, 1); len(emails) != 0 {
+		t.Errorf("Expected cancelled email not to be retried, got %v", emails)
+	}
+	
+	if stats := q.Stats(); stats.TotalRejected != 2 || q.Size() != 0 {
+		t.Errorf("Expected 2 rejections and an empty queue, got %+v size %d", stats, q.Size())
+	}
+	if err := q.Cancel(ctx, "missing", ""); err != ErrEmailNotFound {
+		t.Errorf("Expected ErrEmailNotFound, got %v", err)
+	}
+}
+
+func TestMemoryQueue_Bulk(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	old := time.Now().Add(-time.Hour)
+	q.Enqueue(ctx, &email.Email{ID: "sending", From: "news@test.com", To: []string{"a@Example.com"}, Status: email.StatusQueued, CreatedAt: old})
+	q.Dequeue(ctx, 1)
+	q.Enqueue(ctx, &email.Email{ID: "old", From: "news@test.com", To: []string{"b@other.com", "c@example.com"}, Status: email.StatusQueued, CreatedAt: old.Add(time.Minute)})
+	q.Enqueue(ctx, &email.Email{ID: "new", From: "alerts@test.com", To: []string{"d@example.com"}, Status: email.StatusQueued, CreatedAt: time.Now()})
+	q.Enqueue(ctx, &email.Email{ID: "elsewhere", From: "news@test.com", To: []string{"e@other.com"}, Status: email.StatusQueued, CreatedAt: time.Now()})
+	
+	tests := []struct {
+		filter Filter
+		want   []string
+	}{
+		{Filter{Domain: "example.com"}, []string{"sending", "old", "new"}},
+		{Filter{Domain: "example.com", Status: email.StatusQueued}, []string{"old", "new"}},
+		{Filter{From: "NEWS@test.com", Before: time.Now().Add(-time.Minute)}, []string{"sending", "old"}},
+		{Filter{Domain: "nowhere.com"}, nil},
+	}
+	for _, tt := range tests {
+		got, _ := q.Match(ctx, tt.filter)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Match(%+v) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+	
+	// Each email is cancelled as Cancel would: the sending one is flagged
+	purged, err := q.Purge(ctx, Filter{Domain: "example.com"}, "")
+	if err != nil || len(purged) != 3 {
+		t.Fatalf("Expected 3 emails purged, got %v, %v", purged, err)
+	}
+	if reason, ok := q.Cancelled(ctx, "sending"); !ok || reason != "purged by request" {
+		t.Errorf("Expected the sending email flagged, got %q %v", reason, ok)
+	}
+	if _, err := q.Get(ctx, "old"); err != ErrEmailNotFound {
+		t.Errorf("Expected the waiting emails removed, got %v", err)
+	}
+	
+	cancelled, err := q.CancelBatch(ctx, []string{"elsewhere", "old", "missing"}, "")
+	if err != nil || !slices.Equal(cancelled, []string{"elsewhere"}) {
+		t.Errorf("Expected only elsewhere cancelled, got %v, %v", cancelled, err)
+	}
+	if stats := q.Stats(); stats.TotalRejected != 3 || stats.Queued != 0 {
+		t.Errorf("Expected 3 rejections and nothing queued, got %+v", stats)
+	}
+}
+
+func TestMemoryQueue_Postpone(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	q.Enqueue(ctx, &email.Email{ID: "test-1", Status: email.StatusQueued})
+	
+	if err := q.Postpone(ctx, "test-1", time.Minute); !errors.Is(err, email.ErrInvalidTransition) {
+		t.Fatalf("Expected a queued email not to be postponed, got %v", err)
+	}
+	
+	q.Dequeue(ctx, 1)
+	if err := q.Postpone(ctx, "test-1", time.Minute); err != nil {
+		t.Fatalf("Postpone failed: %v", err)
+	}
+	e := q.emailMap["test-1"]
+	if e.Status != email.StatusQueued || e.DeferCount != 0 || e.RetryCount != 0 || e.LastError != "" {
+		t.Errorf("Expected email queued with nothing counted against it, got %+v", e)
+	}
+	if emails, _ := q.Dequeue(ctx, 1); len(emails) != 0 {
+		t.Error("Expected postponed email to wait")
+	}
+	if stats := q.Stats(); stats.Queued != 1 || stats.Sending != 0 {
+		t.Errorf("Expected 1 queued and none sending, got %+v", stats)
+	}
+}
+
+func TestMemoryQueue_Backlog(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10)
+	now := time.Now()
+	in := func(d time.Duration) *time.Time {
+		at := now.Add(d)
+		return &at
+	}
+	q.Enqueue(ctx, &email.Email{ID: "ready-1", To: []string{"a@gmail.com", "b@Gmail.com"}, Status: email.StatusQueued})
+	q.Enqueue(ctx, &email.Email{ID: "ready-2", To: []string{"a@example.com"}, CC: []string{"c@gmail.com"}, Status: email.StatusQueued})
+	q.Enqueue(ctx, &email.Email{ID: "soon", To: []string{"d@gmail.com"}, ScheduledAt: in(90 * time.Second), Status: email.StatusQueued})
+	q.Enqueue(ctx, &email.Email{ID: "later", To: []string{"e@example.com"}, ScheduledAt: in(24 * time.Hour), Status: email.StatusQueued})
+	
+	b := q.Backlog(now, time.Minute, 3)
+	if b.Ready != 2 || b.Scheduled() != 2 {
+		t.Fatalf("Expected 2 ready and 2 scheduled, got %+v", b)
+	}
+	// Releases past the last interval are counted in it
+	if b.Releases[0] != 0 || b.Releases[1] != 1 || b.Releases[2] != 1 {
+		t.Errorf("Expected releases [0 1 1], got %v", b.Releases)
+	}
+	gmail, example := b.Domains["gmail.com"], b.Domains["example.com"]
+	if gmail.Ready != 2 || gmail.Scheduled != 1 || example.Ready != 1 || example.Scheduled != 1 {
+		t.Errorf("Unexpected domains %+v", b.Domains)
+	}
+}
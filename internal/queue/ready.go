@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"container/heap"
+	"container/list"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// readyList holds emails that are due for delivery. Emails are bucketed by
+// lane and priority, each bucket a FIFO, so the best email of every bucket
+// is at its front and any email can be removed by ID in O(1). Emails with
+// an SLA deadline are also kept in a per-lane heap by deadline.
+//
+// An email keeps the position and ready time of its first push, so one
+// that comes back after a retry or deferral goes ahead of newer mail and
+// keeps the priority it has aged into.
+type readyList struct {
+	buckets map[readyKey]*list.List
+	elems   map[string]*list.Element
+	sla     map[email.Lane]*slaHeap
+	first   map[string]readyPos
+	seq     uint64
+}
+
+type readyPos struct {
+	seq   uint64
+	since time.Time
+}
+
+type readyKey struct {
+	lane     email.Lane
+	priority int
+}
+
+type readyItem struct {
+	email    *email.Email
+	key      readyKey
+	seq      uint64    // first insertion order, for FIFO ties across buckets
+	since    time.Time // when the email first became ready, for aging
+	slaIndex int       // position in the lane's SLA heap, or -1
+}
+
+func newReadyList() *readyList {
+	return &readyList{
+		buckets: make(map[readyKey]*list.List),
+		elems:   make(map[string]*list.Element),
+		sla:     make(map[email.Lane]*slaHeap),
+		first:   make(map[string]readyPos),
+	}
+}
+
+func (r *readyList) Len() int {
+	return len(r.elems)
+}
+
+// push adds e to its bucket, at the back the first time and at its
+// original place when it returns.
+func (r *readyList) push(e *email.Email) {
+	key := readyKey{lane: e.DeliveryLane(), priority: e.Priority}
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = list.New()
+		r.buckets[key] = bucket
+	}
+	
+	pos, ok := r.first[e.ID]
+	if !ok {
+		r.seq++
+		pos = readyPos{seq: r.seq, since: readySince(e)}
+		r.first[e.ID] = pos
+	}
+	item := &readyItem{email: e, key: key, seq: pos.seq, since: pos.since, slaIndex: -1}
+	r.elems[e.ID] = insertBySeq(bucket, item)
+	
+	// Only the first attempt is bound by the SLA
+	if e.SLADeadline != nil && e.FirstAttemptAt == nil {
+		h, ok := r.sla[key.lane]
+		if !ok {
+			h = &slaHeap{}
+			r.sla[key.lane] = h
+		}
+		heap.Push(h, item)
+	}
+}
+
+// insertBySeq inserts item into bucket in seq order. New mail goes to the
+// back; returning mail is older than most of the bucket, which has mostly
+// moved on, so its place is found near the front.
+func insertBySeq(bucket *list.List, item *readyItem) *list.Element {
+	if back := bucket.Back(); back == nil || back.Value.(*readyItem).seq < item.seq {
+		return bucket.PushBack(item)
+	}
+	for elem := bucket.Front(); ; elem = elem.Next() {
+		if elem.Value.(*readyItem).seq > item.seq {
+			return bucket.InsertBefore(item, elem)
+		}
+	}
+}
+
+// readySince is when e became ready: when it was created, or when it was
+// scheduled for if that is later.
+func readySince(e *email.Email) time.Time {
+	since := e.CreatedAt
+	if e.ScheduledAt != nil && e.ScheduledAt.After(since) {
+		since = *e.ScheduledAt
+	}
+	return since
+}
+
+// forget drops the remembered position of an email that has left the
+// queue.
+func (r *readyList) forget(id string) {
+	delete(r.first, id)
+}
+
+// remove takes e off the ready list, reporting whether it was there.
+func (r *readyList) remove(e *email.Email) bool {
+	elem, ok := r.elems[e.ID]
+	if !ok {
+		return false
+	}
+	
+	item := elem.Value.(*readyItem)
+	bucket := r.buckets[item.key]
+	bucket.Remove(elem)
+	if bucket.Len() == 0 {
+		delete(r.buckets, item.key)
+	}
+	delete(r.elems, e.ID)
+	if item.slaIndex >= 0 {
+		heap.Remove(r.sla[item.key.lane], item.slaIndex)
+	}
+	return true
+}
+
+// urgent returns the ready email in lane, or in any lane when lane is
+// empty, with the earliest SLA deadline, provided that deadline is no
+// later than before.
+func (r *readyList) urgent(lane email.Lane, before time.Time) *email.Email {
+	var best *readyItem
+	for l, h := range r.sla {
+		if (lane != "" && l != lane) || h.Len() == 0 {
+			continue
+		}
+		if top := (*h)[0]; best == nil || slaBefore(top, best) {
+			best = top
+		}
+	}
+	if best == nil || best.email.SLADeadline.After(before) {
+		return nil
+	}
+	return best.email
+}
+
+// fronts returns the first element of every bucket in lane, or of every
+// bucket when lane is empty.
+func (r *readyList) fronts(lane email.Lane) []*list.Element {
+	fronts := make([]*list.Element, 0, len(r.buckets))
+	for key, bucket := range r.buckets {
+		if lane != "" && key.lane != lane {
+			continue
+		}
+		fronts = append(fronts, bucket.Front())
+	}
+	return fronts
+}
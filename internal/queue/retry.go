@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"math/rand"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+const (
+	defaultRetryDelay         = 5 * time.Minute
+	defaultMaxRetryDelay      = 8 * time.Hour
+	defaultRetryJitter        = 0.2
+	defaultGreylistRetryDelay = 3 * time.Minute
+)
+
+// retryPolicy computes how long a failed email waits before its next
+// attempt: an explicit schedule if one is configured, otherwise
+// exponential backoff from base capped at max. Jitter spreads out emails
+// that failed together so they do not all retry at once. A greylisted
+// first attempt is retried after greylist instead, zero to disable.
+type retryPolicy struct {
+	base     time.Duration
+	max      time.Duration
+	schedule []time.Duration
+	jitter   float64
+	random   func() float64
+	greylist time.Duration
+}
+
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		base:   defaultRetryDelay,
+		max:    defaultMaxRetryDelay,
+		jitter: defaultRetryJitter,
+		random: rand.Float64,
+		
+		greylist: defaultGreylistRetryDelay,
+	}
+}
+
+func newRetryPolicy(cfg *config.QueueConfig) retryPolicy {
+	p := defaultRetryPolicy()
+	if cfg.RetryDelay > 0 {
+		p.base = cfg.RetryDelay
+	}
+	if cfg.MaxRetryDelay > 0 {
+		p.max = cfg.MaxRetryDelay
+	}
+	if cfg.RetryJitter != 0 {
+		p.jitter = cfg.RetryJitter
+	}
+	if p.jitter < 0 {
+		p.jitter = 0
+	}
+	if cfg.GreylistRetryDelay != 0 {
+		p.greylist = max(cfg.GreylistRetryDelay, 0)
+	}
+	p.schedule = cfg.RetrySchedule
+	return p
+}
+
+// after returns the wait before retrying an email that failed with
+// failure, if known, after retries earlier retries. A wait the server
+// asked for is honoured up to max; a greylisted first attempt waits the
+// greylist delay; anything else follows delay. Neither of the first two
+// is jittered, as retrying early would only be refused again.
+func (p retryPolicy) after(retries int, failure *email.Failure) time.Duration {
+	if failure != nil && failure.RetryAfter > 0 {
+		return min(time.Duration(failure.RetryAfter)*time.Second, p.max)
+	}
+	if failure != nil && failure.Greylisted && retries == 0 && p.greylist > 0 {
+		return p.greylist
+	}
+	return p.delay(retries + 1)
+}
+
+// delay returns the wait before the given attempt, counting the first
+// retry as attempt 1.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.baseDelay(attempt)
+	if p.jitter > 0 {
+		// Uniform in [-jitter, +jitter] of the delay
+		d += time.Duration(float64(d) * p.jitter * (2*p.random() - 1))
+	}
+	return d
+}
+
+func (p retryPolicy) baseDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	
+	if len(p.schedule) > 0 {
+		// Past the end of the schedule keep using the last interval
+		if attempt > len(p.schedule) {
+			return p.schedule[len(p.schedule)-1]
+		}
+		return p.schedule[attempt-1]
+	}
+	
+	d := p.base
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= p.max || d <= 0 {
+			return p.max
+		}
+	}
+	if d > p.max {
+		return p.max
+	}
+	return d
+}
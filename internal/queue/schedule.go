@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"container/heap"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// scheduleHeap is a min-heap of emails waiting on a future ScheduledAt,
+// soonest first. Positions are tracked by ID so that an email can be
+// taken off the schedule in O(log n).
+type scheduleHeap struct {
+	emails []*email.Email
+	pos    map[string]int
+}
+
+func newScheduleHeap() *scheduleHeap {
+	return &scheduleHeap{pos: make(map[string]int)}
+}
+
+// due reports whether the earliest scheduled email is ready at now.
+func (h *scheduleHeap) due(now time.Time) bool {
+	return len(h.emails) > 0 && !h.emails[0].ScheduledAt.After(now)
+}
+
+// remove takes e off the schedule, if it is there.
+func (h *scheduleHeap) remove(e *email.Email) {
+	if i, ok := h.pos[e.ID]; ok {
+		heap.Remove(h, i)
+	}
+}
+
+// heap.Interface
+
+func (h *scheduleHeap) Len() int { return len(h.emails) }
+
+func (h *scheduleHeap) Less(i, j int) bool {
+	return h.emails[i].ScheduledAt.Before(*h.emails[j].ScheduledAt)
+}
+
+func (h *scheduleHeap) Swap(i, j int) {
+	h.emails[i], h.emails[j] = h.emails[j], h.emails[i]
+	h.pos[h.emails[i].ID] = i
+	h.pos[h.emails[j].ID] = j
+}
+
+func (h *scheduleHeap) Push(x interface{}) {
+	e := x.(*email.Email)
+	h.pos[e.ID] = len(h.emails)
+	h.emails = append(h.emails, e)
+}
+
+func (h *scheduleHeap) Pop() interface{} {
+	n := len(h.emails)
+	e := h.emails[n-1]
+	h.emails[n-1] = nil
+	h.emails = h.emails[:n-1]
+	delete(h.pos, e.ID)
+	return e
+}
@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"log"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// defaultSLABoost is how close to its SLA deadline an email must be to be
+// sent ahead of all other ready mail.
+const defaultSLABoost = 10 * time.Second
+
+// SLAListener is implemented by listeners that want to hear about emails
+// whose first delivery attempt started after their SLA deadline, for
+// example to raise an alert.
+type SLAListener interface {
+	OnSLABreached(id string, deadline time.Time, late time.Duration)
+}
+
+func (LogListener) OnSLABreached(id string, deadline time.Time, late time.Duration) {
+	log.Printf("Queue: %s missed its SLA deadline by %s", id, late.Round(time.Millisecond))
+}
+
+// slaBreached records that e is being dispatched after its SLA deadline.
+func (q *MemoryQueue) slaBreached(ev *events, e *email.Email, now time.Time) {
+	e.SLABreached = true
+	q.totalSLABreached.Add(1)
+	if len(q.listeners) == 0 {
+		return
+	}
+	id, deadline := e.ID, *e.SLADeadline
+	late := now.Sub(deadline)
+	*ev = append(*ev, func(l Listener) {
+		if s, ok := l.(SLAListener); ok {
+			s.OnSLABreached(id, deadline, late)
+		}
+	})
+}
+
+// slaHeap is a min-heap of ready emails awaiting their first attempt,
+// earliest SLA deadline first, then in queue order. Items track their index so that any can
+// be removed in O(log n).
+type slaHeap []*readyItem
+
+// slaBefore orders SLA emails by deadline, then by queue position.
+func slaBefore(a, b *readyItem) bool {
+	if !a.email.SLADeadline.Equal(*b.email.SLADeadline) {
+		return a.email.SLADeadline.Before(*b.email.SLADeadline)
+	}
+	return a.seq < b.seq
+}
+
+// heap.Interface
+
+func (h slaHeap) Len() int { return len(h) }
+
+func (h slaHeap) Less(i, j int) bool { return slaBefore(h[i], h[j]) }
+
+func (h slaHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].slaIndex = i
+	h[j].slaIndex = j
+}
+
+func (h *slaHeap) Push(x interface{}) {
+	item := x.(*readyItem)
+	item.slaIndex = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *slaHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.slaIndex = -1
+	*h = old[:n-1]
+	return item
+}
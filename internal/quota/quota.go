@@ -0,0 +1,219 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const dayFormat = "2006-01-02"
+
+// Usage is a key's consumption for the current day. A Limit of zero means
+// the key is unlimited, and Remaining is then meaningless.
+type Usage struct {
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+// counter is a key's usage on Day, a UTC date.
+type counter struct {
+	Day  string `json:"day"`
+	Used int    `json:"used"`
+}
+
+// Tracker counts sends per key per day. Counters reset at midnight UTC.
+// Keys without a limit are not counted. When opened with a path, usage is
+// saved by Run so a restart does not hand out a fresh quota.
+type Tracker struct {
+	mu       sync.Mutex
+	path     string
+	counters map[string]*counter
+	dirty    bool
+	now      func() time.Time
+}
+
+// NewTracker creates a tracker that keeps usage in memory only.
+func NewTracker() *Tracker {
+	return &Tracker{
+		counters: make(map[string]*counter),
+		now:      time.Now,
+	}
+}
+
+// Open creates a tracker persisted to path, loading any usage saved there.
+// A missing file starts every key from zero.
+func Open(path string) (*Tracker, error) {
+	t := NewTracker()
+	t.path = path
+	
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &t.counters); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Take charges up to n sends to key and returns how many were granted:
+// all of them for an unlimited key, otherwise no more than remain today.
+// The returned usage reflects the charge.
+func (t *Tracker) Take(key string, limit, n int) (int, Usage) {
+	now := t.now()
+	if limit == 0 {
+		return n, usage(&counter{}, 0, now)
+	}
+	
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	
+	c := t.current(key, now)
+	granted := n
+	if limit > 0 && c.Used+granted > limit {
+		granted = limit - c.Used
+		if granted < 0 {
+			granted = 0
+		}
+	}
+	if granted == 0 {
+		return 0, usage(c, limit, now)
+	}
+	
+	c.Used += granted
+	t.dirty = true
+	return granted, usage(c, limit, now)
+}
+
+// Return gives back n sends charged by Take that were never used, such as
+// when the email could not be queued, and returns the usage that leaves.
+func (t *Tracker) Return(key string, limit, n int) Usage {
+	now := t.now()
+	if limit == 0 {
+		return usage(&counter{}, 0, now)
+	}
+	
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	
+	c := t.current(key, now)
+	c.Used -= n
+	if c.Used < 0 {
+		c.Used = 0
+	}
+	t.dirty = true
+	return usage(c, limit, now)
+}
+
+// Usage reports key's usage today against limit.
+func (t *Tracker) Usage(key string, limit int) Usage {
+	now := t.now()
+	if limit == 0 {
+		return usage(&counter{}, 0, now)
+	}
+	
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	
+	return usage(t.current(key, now), limit, now)
+}
+
+// Run saves usage every interval, when it has changed, until ctx is done,
+// and once more before returning. Sends charged since the last save are
+// forgotten if the process exits without Run returning.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ctx.Done():
+			if err := t.Save(); err != nil {
+				log.Printf("Failed to save quota usage: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := t.Save(); err != nil {
+				log.Printf("Failed to save quota usage: %v", err)
+			}
+		}
+	}
+}
+
+// Save writes usage to the tracker's file if it has changed since the last
+// save. A tracker without a file has nothing to save.
+func (t *Tracker) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	
+	if !t.dirty || t.path == "" {
+		return nil
+	}
+	if err := t.save(); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+// current returns key's counter for today, resetting it if it was last
+// used on an earlier day. Callers must hold t.mu.
+func (t *Tracker) current(key string, now time.Time) *counter {
+	day := now.UTC().Format(dayFormat)
+	c, ok := t.counters[key]
+	if !ok {
+		c = &counter{}
+		t.counters[key] = c
+	}
+	if c.Day != day {
+		c.Day = day
+		c.Used = 0
+	}
+	return c
+}
+
+// save writes every counter to the tracker's file, replacing it
+// atomically. Callers must hold t.mu.
+func (t *Tracker) save() error {
+	data, err := json.Marshal(t.counters)
+	if err != nil {
+		return err
+	}
+	
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
+
+func usage(c *counter, limit int, now time.Time) Usage {
+	u := Usage{
+		Limit:   limit,
+		Used:    c.Used,
+		ResetAt: now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour),
+	}
+	if limit > 0 && c.Used < limit {
+		u.Remaining = limit - c.Used
+	}
+	return u
+}
@@ -0,0 +1,82 @@
+package quota
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTracker_Take(t *testing.T) {
+	tr := NewTracker()
+	now := time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC)
+	tr.now = func() time.Time { return now }
+	
+	if granted, _ := tr.Take("team", 10, 8); granted != 8 {
+		t.Fatalf("Expected 8 granted, got %d", granted)
+	}
+	
+	// Only what is left is granted
+	granted, usage := tr.Take("team", 10, 5)
+	if granted != 2 || usage.Used != 10 || usage.Remaining != 0 {
+		t.Fatalf("Expected 2 granted and quota used up, got %d and %+v", granted, usage)
+	}
+	if want := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC); !usage.ResetAt.Equal(want) {
+		t.Errorf("Expected reset at %v, got %v", want, usage.ResetAt)
+	}
+	if granted, _ := tr.Take("team", 10, 1); granted != 0 {
+		t.Errorf("Expected nothing granted once the quota is used, got %d", granted)
+	}
+	
+	tr.Return("team", 10, 1)
+	if usage := tr.Usage("team", 10); usage.Remaining != 1 {
+		t.Errorf("Expected returned send to be available, got %+v", usage)
+	}
+	
+	// Other keys and unlimited keys are unaffected, and unlimited keys are
+	// not counted
+	if granted, _ := tr.Take("other", 0, 1000); granted != 1000 {
+		t.Errorf("Expected unlimited key to be granted everything, got %d", granted)
+	}
+	if _, ok := tr.counters["other"]; ok {
+		t.Error("Expected no counter for an unlimited key")
+	}
+	
+	// Usage resets at midnight UTC
+	now = now.Add(9 * time.Hour)
+	if usage := tr.Usage("team", 10); usage.Used != 0 || usage.Remaining != 10 {
+		t.Errorf("Expected quota to reset the next day, got %+v", usage)
+	}
+}
+
+func TestTracker_Persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	
+	tr, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	tr.Take("team", 10, 4)
+	
+	// Nothing is written until the tracker saves
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected no file before saving, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tr.Run(ctx, time.Hour)
+		close(done)
+	}()
+	cancel()
+	<-done
+	
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if usage := reopened.Usage("team", 10); usage.Used != 4 {
+		t.Errorf("Expected usage to survive a restart, got %+v", usage)
+	}
+}
@@ -0,0 +1,180 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+)
+
+// recoveryFraction is how far below every threshold readings must fall
+// before a degraded monitor recovers, so it does not flap at the limit.
+const recoveryFraction = 0.9
+
+// Sample is a point-in-time reading of process resource usage. OpenFiles
+// is -1 where it cannot be measured.
+type Sample struct {
+	HeapBytes  uint64 `json:"heap_bytes"`
+	OpenFiles  int    `json:"open_files"`
+	Goroutines int    `json:"goroutines"`
+}
+
+type Sampler interface {
+	Sample() Sample
+}
+
+// Thresholds above which the server sheds load. Zero disables a check.
+type Thresholds struct {
+	MaxHeapBytes  uint64 `json:"max_heap_bytes,omitempty"`
+	MaxOpenFiles  int    `json:"max_open_files,omitempty"`
+	MaxGoroutines int    `json:"max_goroutines,omitempty"`
+}
+
+// Status is reported in the /health detail.
+type Status struct {
+	Degraded   bool       `json:"degraded"`
+	Reasons    []string   `json:"reasons,omitempty"`
+	Current    Sample     `json:"current"`
+	Thresholds Thresholds `json:"thresholds"`
+}
+
+// Monitor samples resource usage and reports when the process is under
+// pressure, so callers can reduce concurrency and defer new work.
+type Monitor struct {
+	interval   time.Duration
+	thresholds Thresholds
+	sampler    Sampler
+	
+	mu       sync.RWMutex
+	degraded bool
+	reasons  []string
+	current  Sample
+}
+
+func NewMonitor(cfg *config.ResourceConfig) *Monitor {
+	return newMonitor(cfg, runtimeSampler{})
+}
+
+func newMonitor(cfg *config.ResourceConfig, sampler Sampler) *Monitor {
+	return &Monitor{
+		interval: cfg.SampleInterval,
+		thresholds: Thresholds{
+			MaxHeapBytes:  cfg.MaxHeapBytes,
+			MaxOpenFiles:  cfg.MaxOpenFiles,
+			MaxGoroutines: cfg.MaxGoroutines,
+		},
+		sampler: sampler,
+	}
+}
+
+// Run samples every interval until ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	m.Check()
+	
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check()
+		}
+	}
+}
+
+// Check takes a sample and updates the degraded state.
+func (m *Monitor) Check() {
+	sample := m.sampler.Sample()
+	
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	m.current = sample
+	
+	if reasons := m.exceeded(sample, 1); len(reasons) > 0 {
+		if !m.degraded {
+			log.Printf("WARN resources constrained, shedding load: %s", strings.Join(reasons, ", "))
+		}
+		m.degraded = true
+		m.reasons = reasons
+		return
+	}
+	
+	// Stay degraded until usage is comfortably below every threshold
+	if m.degraded {
+		if reasons := m.exceeded(sample, recoveryFraction); len(reasons) > 0 {
+			m.reasons = reasons
+			return
+		}
+		log.Printf("Resource pressure subsided, resuming normal operation")
+	}
+	m.degraded = false
+	m.reasons = nil
+}
+
+// exceeded lists the readings above fraction of their threshold.
+func (m *Monitor) exceeded(s Sample, fraction float64) []string {
+	var reasons []string
+	t := m.thresholds
+	if t.MaxHeapBytes > 0 && float64(s.HeapBytes) > float64(t.MaxHeapBytes)*fraction {
+		reasons = append(reasons, fmt.Sprintf("heap %d bytes", s.HeapBytes))
+	}
+	if t.MaxOpenFiles > 0 && float64(s.OpenFiles) > float64(t.MaxOpenFiles)*fraction {
+		reasons = append(reasons, fmt.Sprintf("%d open files", s.OpenFiles))
+	}
+	if t.MaxGoroutines > 0 && float64(s.Goroutines) > float64(t.MaxGoroutines)*fraction {
+		reasons = append(reasons, fmt.Sprintf("%d goroutines", s.Goroutines))
+	}
+	return reasons
+}
+
+// Degraded reports whether the process is under resource pressure.
+func (m *Monitor) Degraded() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	return m.degraded
+}
+
+func (m *Monitor) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	return Status{
+		Degraded:   m.degraded,
+		Reasons:    m.reasons,
+		Current:    m.current,
+		Thresholds: m.thresholds,
+	}
+}
+
+type runtimeSampler struct{}
+
+func (runtimeSampler) Sample() Sample {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	
+	return Sample{
+		HeapBytes:  mem.HeapAlloc,
+		OpenFiles:  openFiles(),
+		Goroutines: runtime.NumGoroutine(),
+	}
+}
+
+// openFiles counts this process's file descriptors where /proc is
+// available.
+func openFiles() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
@@ -0,0 +1,64 @@
+package resource
+
+import (
+	"testing"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+)
+
+type fakeSampler struct {
+	sample Sample
+}
+
+func (f *fakeSampler) Sample() Sample {
+	return f.sample
+}
+
+func TestMonitor_DegradeAndRecover(t *testing.T) {
+	sampler := &fakeSampler{}
+	m := newMonitor(&config.ResourceConfig{
+		MaxHeapBytes:  1000,
+		MaxOpenFiles:  100,
+		MaxGoroutines: 50,
+	}, sampler)
+	
+	steps := []struct {
+		name     string
+		sample   Sample
+		degraded bool
+	}{
+		{"normal", Sample{HeapBytes: 500, OpenFiles: 10, Goroutines: 10}, false},
+		{"heap over threshold", Sample{HeapBytes: 1500, OpenFiles: 10, Goroutines: 10}, true},
+		{"just below threshold stays degraded", Sample{HeapBytes: 950, OpenFiles: 10, Goroutines: 10}, true},
+		{"well below threshold recovers", Sample{HeapBytes: 800, OpenFiles: 10, Goroutines: 10}, false},
+		{"file descriptors over threshold", Sample{HeapBytes: 800, OpenFiles: 101, Goroutines: 10}, true},
+		{"recovered", Sample{HeapBytes: 100, OpenFiles: 10, Goroutines: 10}, false},
+	}
+	
+	for _, step := range steps {
+		sampler.sample = step.sample
+		m.Check()
+		
+		if m.Degraded() != step.degraded {
+			t.Fatalf("%s: expected degraded=%v", step.name, step.degraded)
+		}
+		status := m.Status()
+		if status.Current != step.sample {
+			t.Errorf("%s: expected current %+v, got %+v", step.name, step.sample, status.Current)
+		}
+		if step.degraded && len(status.Reasons) == 0 {
+			t.Errorf("%s: expected reasons while degraded", step.name)
+		}
+	}
+}
+
+func TestMonitor_DisabledThresholds(t *testing.T) {
+	m := newMonitor(&config.ResourceConfig{}, &fakeSampler{
+		sample: Sample{HeapBytes: 1 << 40, OpenFiles: 1 << 20, Goroutines: 1 << 20},
+	})
+	m.Check()
+	
+	if m.Degraded() {
+		t.Error("Monitor with no thresholds should never degrade")
+	}
+}
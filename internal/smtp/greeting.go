@@ -0,0 +1,192 @@
+package smtp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+)
+
+var errEarlyTalker = errors.New("client spoke before the greeting")
+
+// greetingListener wraps accepted connections so the server's banner,
+// EHLO capabilities and greeting delay apply without changes to the SMTP
+// library.
+type greetingListener struct {
+	net.Listener
+	server *Server
+}
+
+func (l *greetingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &greetingConn{Conn: c, server: l.server}, nil
+}
+
+// greetingConn rewrites the replies the SMTP library writes: the 220
+// greeting is replaced by the configured banner and hidden extensions are
+// removed from multi-line 250 replies, which only EHLO sends. Once
+// STARTTLS succeeds everything written is encrypted, so it is passed
+// through untouched.
+type greetingConn struct {
+	net.Conn
+	server *Server
+	
+	waited  bool
+	greeted bool
+	tls     bool
+	
+	// Bytes of an incomplete reply, held until its final line arrives
+	pending []byte
+}
+
+func (c *greetingConn) Write(p []byte) (int, error) {
+	if c.tls {
+		return c.Conn.Write(p)
+	}
+	
+	if !c.waited {
+		c.waited = true
+		if err := c.holdGreeting(); err != nil {
+			return 0, err
+		}
+	}
+	
+	c.pending = append(c.pending, p...)
+	var out []byte
+	for {
+		end := replyEnd(c.pending)
+		if end < 0 {
+			break
+		}
+		out = append(out, c.rewrite(string(c.pending[:end]))...)
+		c.pending = c.pending[end:]
+	}
+	
+	if len(out) > 0 {
+		if _, err := c.Conn.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// holdGreeting waits out the banner delay, watching for clients that send
+// anything first. Those are told why and disconnected.
+func (c *greetingConn) holdGreeting() error {
+	delay := c.server.config.BannerDelay
+	if delay <= 0 {
+		return nil
+	}
+	
+	c.Conn.SetReadDeadline(time.Now().Add(delay))
+	n, err := c.Conn.Read(make([]byte, 1))
+	c.Conn.SetReadDeadline(time.Time{})
+	
+	if n > 0 {
+		c.server.earlyTalkers.Add(1)
+		c.Conn.Write([]byte("554 5.5.1 Protocol error: command sent before greeting\r\n"))
+		c.Conn.Close()
+		return errEarlyTalker
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return err
+	}
+	
+	// The library set its write deadline before the delay began
+	c.Conn.SetWriteDeadline(time.Now().Add(c.server.smtpServer.WriteTimeout))
+	return nil
+}
+
+// replyEnd returns the length of the first complete reply in b, ending
+// with its final line ("250 ..." rather than "250-..."), or -1.
+func replyEnd(b []byte) int {
+	start := 0
+	for {
+		i := bytes.Index(b[start:], []byte("\r\n"))
+		if i < 0 {
+			return -1
+		}
+		line := b[start : start+i]
+		start += i + 2
+		if len(line) < 4 || line[3] != '-' {
+			return start
+		}
+	}
+}
+
+// rewrite applies the banner and extension filter to one complete reply.
+func (c *greetingConn) rewrite(reply string) string {
+	lines := strings.Split(strings.TrimSuffix(reply, "\r\n"), "\r\n")
+	code := lines[0][:min(3, len(lines[0]))]
+	
+	switch {
+	case code == "220" && !c.greeted:
+		c.greeted = true
+		return "220 " + c.server.banner + "\r\n"
+	case code == "220":
+		// The reply to STARTTLS; the handshake follows
+		c.tls = true
+	case code == "250" && len(lines) > 1:
+		return joinReply(code, c.server.filterExtensions(lines))
+	}
+	return reply
+}
+
+// filterExtensions drops hidden extensions from an EHLO reply. The first
+// line is the greeting and is always kept.
+func (s *Server) filterExtensions(lines []string) []string {
+	kept := []string{lines[0]}
+	for _, line := range lines[1:] {
+		keyword, _, _ := strings.Cut(line[min(4, len(line)):], " ")
+		if !s.hiddenExtensions[strings.ToUpper(keyword)] {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+// joinReply renumbers lines as one multi-line reply with code.
+func joinReply(code string, lines []string) string {
+	var b strings.Builder
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		b.WriteString(code + sep + line[min(4, len(line)):] + "\r\n")
+	}
+	return b.String()
+}
+
+// banner expands the configured greeting.
+func banner(template, hostname, product string) string {
+	if template == "" {
+		template = "{hostname} ESMTP {product}"
+	}
+	s := strings.NewReplacer("{hostname}", hostname, "{product}", product).Replace(template)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// hiddenExtensions returns the EHLO keywords to leave out. AUTH is hidden
+// from every plaintext session when it requires TLS; after STARTTLS the
+// reply is no longer filtered.
+func hiddenExtensions(cfg *config.ExtensionsConfig) map[string]bool {
+	hidden := make(map[string]bool)
+	if cfg.HideSize {
+		hidden["SIZE"] = true
+	}
+	if cfg.AuthRequiresTLS {
+		hidden["AUTH"] = true
+	}
+	for _, ext := range cfg.Hide {
+		hidden[strings.ToUpper(ext)] = true
+	}
+	return hidden
+}
@@ -0,0 +1,86 @@
+package smtp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+// rawSpool collects a message exactly as received so it can be relayed
+// without re-serializing, which would break upstream DKIM signatures. It
+// is held in memory up to limit bytes and moved to a file in dir beyond
+// that, and size counts the bytes written.
+type rawSpool struct {
+	dir   string
+	limit int64
+	buf   bytes.Buffer
+	file  *os.File
+	size  int64
+}
+
+func (s *Server) newSpool() *rawSpool {
+	return &rawSpool{dir: s.config.RawSpoolDir, limit: s.config.RawMemoryLimit}
+}
+
+func (sp *rawSpool) Write(p []byte) (int, error) {
+	if sp.file == nil && sp.limit > 0 && int64(sp.buf.Len()+len(p)) > sp.limit {
+		f, err := os.CreateTemp(sp.dir, "raw-*.eml")
+		if err != nil {
+			return 0, fmt.Errorf("failed to spool message: %w", err)
+		}
+		sp.file = f
+		if _, err := f.Write(sp.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		sp.buf = bytes.Buffer{}
+	}
+	
+	sp.size += int64(len(p))
+	if sp.file != nil {
+		return sp.file.Write(p)
+	}
+	return sp.buf.Write(p)
+}
+
+// finish returns the message if it is in memory, or else the path of the
+// file holding it.
+func (sp *rawSpool) finish() ([]byte, string, error) {
+	if sp.file == nil {
+		return sp.buf.Bytes(), "", nil
+	}
+	if err := sp.file.Close(); err != nil {
+		os.Remove(sp.file.Name())
+		return nil, "", err
+	}
+	return nil, sp.file.Name(), nil
+}
+
+// discard removes the spool file of a message that was not accepted.
+func (sp *rawSpool) discard() {
+	if sp.file != nil {
+		sp.file.Close()
+		os.Remove(sp.file.Name())
+	}
+}
+
+// traceHeader is the Received header prepended to relayed mail.
+func (s *smtpSession) traceHeader(id string) string {
+	helo := s.conn.Hostname()
+	if helo == "" {
+		helo = "unknown"
+	}
+	
+	remote := "unknown"
+	if c := s.conn.Conn(); c != nil {
+		remote = c.RemoteAddr().String()
+	}
+	
+	with := "ESMTP"
+	if _, ok := s.conn.TLSConnectionState(); ok {
+		with = "ESMTPS"
+	}
+	
+	return fmt.Sprintf("Received: from %s (%s) by %s with %s id %s; %s\r\n",
+		helo, remote, s.server.identity.Hostname(), with, id, time.Now().Format(time.RFC1123Z))
+}
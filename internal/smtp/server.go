@@ -1,40 +1,75 @@
 package smtp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	
 	"github.com/emersion/go-smtp"
 	"github.com/google/uuid"
 	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/identity"
+	"github.com/tpdoyle87/simple-email-server/internal/lifecycle"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/internal/resource"
 	"github.com/tpdoyle87/simple-email-server/pkg/email"
 )
 
 type Queue interface {
-	Enqueue(*email.Email) error
+	Enqueue(ctx context.Context, e *email.Email) error
+}
+
+// BounceHandler takes the mail sent back to the return path, such as
+// delivery status notifications for emails that bounced after being
+// accepted. rcpt is the address it was sent to. An error defers the
+// message with a 451 so the remote server tries again later.
+type BounceHandler interface {
+	HandleBounce(ctx context.Context, rcpt string, msg []byte) error
 }
 
 type Server struct {
 	config         *config.ServerConfig
 	queue          Queue
 	maxMessageSize int64
-	hostname       string
+	identity       *identity.Identity
+	monitor        *resource.Monitor
+	draining       atomic.Bool
+	
+	banner           string
+	hiddenExtensions map[string]bool
+	earlyTalkers     atomic.Int64
+	
+	// Mail to bounceDomain goes to bounces instead of the queue
+	bounceDomain string
+	bounces      BounceHandler
 	
 	smtpServer *smtp.Server
 	listener   net.Listener
 	mu         sync.RWMutex
+	lifecycle  lifecycle.Lifecycle
 }
 
 func NewServer(cfg *config.ServerConfig, queue Queue, maxMessageSize int64) *Server {
+	// A config that hasn't been validated has no identity yet
+	id := cfg.Identity
+	if id == nil {
+		id = identity.New(cfg.Hostname)
+	}
 	s := &Server{
 		config:         cfg,
 		queue:          queue,
 		maxMessageSize: maxMessageSize,
-		hostname:       cfg.Hostname,
+		identity:       id,
+		
+		banner:           banner(cfg.Banner, id.Hostname(), cfg.Product),
+		hiddenExtensions: hiddenExtensions(&cfg.Extensions),
 	}
 	
 	backend := &smtpBackend{
@@ -43,19 +78,57 @@ func NewServer(cfg *config.ServerConfig, queue Queue, maxMessageSize int64) *Ser
 	
 	smtpServer := smtp.NewServer(backend)
 	smtpServer.Addr = cfg.ListenAddress
-	smtpServer.Domain = cfg.Hostname
+	smtpServer.Domain = id.Hostname()
 	smtpServer.MaxMessageBytes = maxMessageSize
-	smtpServer.MaxRecipients = 100
+	smtpServer.MaxRecipients = defaultMaxRecipients
 	smtpServer.ReadTimeout = 10 * time.Second
 	smtpServer.WriteTimeout = 10 * time.Second
-	smtpServer.AllowInsecureAuth = !cfg.TLS.Enabled
+	smtpServer.AllowInsecureAuth = !cfg.TLS.Enabled && !cfg.Extensions.AuthRequiresTLS
 	
 	s.smtpServer = smtpServer
 	
 	return s
 }
 
+// defaultMaxRecipients applies until SetLimits is called.
+const defaultMaxRecipients = 100
+
+// SetLimits applies the recipient limit in cfg to each SMTP transaction.
+// Call it before Start.
+func (s *Server) SetLimits(cfg *config.LimitsConfig) {
+	if cfg.MaxRecipients > 0 {
+		s.smtpServer.MaxRecipients = cfg.MaxRecipients
+	}
+}
+
+// SetMonitor makes the server defer new mail with a 451 while the monitor
+// reports resource pressure.
+func (s *Server) SetMonitor(m *resource.Monitor) {
+	s.monitor = m
+}
+
+// SetBounceHandler passes mail addressed to the return path domain in cfg
+// to h instead of relaying it. Call it before Start.
+func (s *Server) SetBounceHandler(cfg *config.ReturnPathConfig, h BounceHandler) {
+	s.bounceDomain = strings.ToLower(cfg.Domain)
+	s.bounces = h
+}
+
+// Drain makes the server refuse new mail with a 421 so senders retry
+// elsewhere or later.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+// Start listens and serves until Stop is called. It returns
+// lifecycle.ErrAlreadyRunning if the server is already running; a stopped
+// server cannot be restarted.
 func (s *Server) Start() error {
+	if err := s.lifecycle.Start(s.close); err != nil {
+		return err
+	}
+	defer s.lifecycle.Finished()
+	
 	listener, err := net.Listen("tcp", s.config.ListenAddress)
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
@@ -65,21 +138,46 @@ func (s *Server) Start() error {
 	s.listener = listener
 	s.mu.Unlock()
 	
+	// Stop may have run before the listener was visible to it
+	if s.lifecycle.State() == lifecycle.StateStopping {
+		listener.Close()
+		return nil
+	}
+	
 	log.Printf("SMTP server listening on %s", listener.Addr())
 	
-	return s.smtpServer.Serve(listener)
+	err = s.smtpServer.Serve(&greetingListener{Listener: listener, server: s})
+	if s.lifecycle.State() == lifecycle.StateStopping {
+		return nil
+	}
+	return err
 }
 
+// Stop closes the listener and open connections and waits for Start to
+// return. It is safe to call more than once.
 func (s *Server) Stop() error {
+	return s.lifecycle.Stop()
+}
+
+// close shuts the SMTP server down. The listener is also closed directly
+// in case Serve has not registered it yet.
+func (s *Server) close() error {
+	err := s.smtpServer.Close()
+	
 	s.mu.RLock()
 	listener := s.listener
 	s.mu.RUnlock()
 	
 	if listener != nil {
-		return listener.Close()
+		listener.Close()
 	}
-	
-	return s.smtpServer.Close()
+	return err
+}
+
+// EarlyTalkers returns how many clients were disconnected for speaking
+// before the delayed greeting.
+func (s *Server) EarlyTalkers() int64 {
+	return s.earlyTalkers.Load()
 }
 
 func (s *Server) Address() string {
@@ -110,6 +208,9 @@ type smtpSession struct {
 	from       string
 	to         []string
 	authPassed bool
+	
+	// Set when the recipients are at the return path domain
+	bounce bool
 }
 
 func (s *smtpSession) AuthPlain(username, password string) error {
@@ -119,35 +220,105 @@ func (s *smtpSession) AuthPlain(username, password string) error {
 }
 
 func (s *smtpSession) Mail(from string, opts *smtp.MailOptions) error {
+	if s.server.draining.Load() {
+		return &smtp.SMTPError{
+			Code:         421,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+			Message:      "Service shutting down, try again later",
+		}
+	}
+	
+	if m := s.server.monitor; m != nil && m.Degraded() {
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 1},
+			Message:      "Insufficient system resources, try again later",
+		}
+	}
+	
 	s.from = from
 	return nil
 }
 
 func (s *smtpSession) Rcpt(to string, opts *smtp.RcptOptions) error {
+	// Bounces are handled here and everything else relayed, so a
+	// transaction can't mix the two
+	bounce := s.server.isBounce(to)
+	if len(s.to) > 0 && bounce != s.bounce {
+		return &smtp.SMTPError{
+			Code:         452,
+			EnhancedCode: smtp.EnhancedCode{4, 5, 3},
+			Message:      "Send mail for this recipient in a separate transaction",
+		}
+	}
+	s.bounce = bounce
 	s.to = append(s.to, to)
 	return nil
 }
 
+// isBounce reports whether mail to rcpt goes to the bounce handler.
+func (s *Server) isBounce(rcpt string) bool {
+	if s.bounces == nil {
+		return false
+	}
+	at := strings.LastIndexByte(rcpt, '@')
+	return at >= 0 && strings.EqualFold(rcpt[at+1:], s.bounceDomain)
+}
+
 func (s *smtpSession) Data(r io.Reader) error {
-	// Parse email
-	parsedEmail, err := parseEmail(s.from, s.to, r)
+	if s.bounce {
+		return s.handleBounce(r)
+	}
+	
+	id := uuid.New().String()
+	
+	// Keep the message as received, behind our trace header, for relaying
+	spool := s.server.newSpool()
+	if _, err := io.WriteString(spool, s.traceHeader(id)); err != nil {
+		return fmt.Errorf("failed to spool email: %w", err)
+	}
+	
+	// Parse email, keeping no more of the body in memory than the spool
+	// does; the whole message streams through to the spool
+	parsedEmail, err := email.ParsePreview(s.from, s.to, io.TeeReader(r, spool), s.server.config.RawMemoryLimit)
 	if err != nil {
+		spool.discard()
 		return fmt.Errorf("failed to parse email: %w", err)
 	}
 	
+	raw, rawPath, err := spool.finish()
+	if err != nil {
+		return fmt.Errorf("failed to spool email: %w", err)
+	}
+	parsedEmail.Raw = raw
+	parsedEmail.RawPath = rawPath
+	if rawPath != "" {
+		parsedEmail.RawSize = spool.size
+	}
+	parsedEmail.Relayed = true
+	
 	// Validate email
 	if err := parsedEmail.Validate(s.server.maxMessageSize); err != nil {
+		spool.discard()
 		return fmt.Errorf("invalid email: %w", err)
 	}
 	
 	// Add metadata
-	parsedEmail.ID = uuid.New().String()
-	parsedEmail.Status = email.StatusQueued
+	parsedEmail.ID = id
 	parsedEmail.CreatedAt = time.Now()
-	parsedEmail.UpdatedAt = time.Now()
+	parsedEmail.MarkQueued(parsedEmail.CreatedAt)
 	
 	// Queue email
-	if err := s.server.queue.Enqueue(parsedEmail); err != nil {
+	if err := s.server.queue.Enqueue(context.Background(), parsedEmail); err != nil {
+		spool.discard()
+		
+		// A retransmission of mail already queued is accepted again so
+		// the client stops retrying, without naming the queued email
+		var dupErr *queue.DuplicateError
+		if errors.As(err, &dupErr) {
+			log.Printf("Email from %s to %v is a duplicate of %s; accepted without queueing", parsedEmail.From, parsedEmail.To, dupErr.OriginalID)
+			return nil
+		}
 		return fmt.Errorf("failed to queue email: %w", err)
 	}
 	
@@ -156,9 +327,31 @@ func (s *smtpSession) Data(r io.Reader) error {
 	return nil
 }
 
+// handleBounce passes the message to the bounce handler once for each
+// recipient.
+func (s *smtpSession) handleBounce(r io.Reader) error {
+	msg, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read bounce: %w", err)
+	}
+	for _, rcpt := range s.to {
+		if err := s.server.bounces.HandleBounce(context.Background(), rcpt, msg); err != nil {
+			log.Printf("Failed to handle bounce to %s: %v", rcpt, err)
+			return &smtp.SMTPError{
+				Code:         451,
+				EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+				Message:      "Bounce not processed, try again later",
+			}
+		}
+	}
+	log.Printf("Bounce to %v processed", s.to)
+	return nil
+}
+
 func (s *smtpSession) Reset() {
 	s.from = ""
 	s.to = nil
+	s.bounce = false
 }
 
 func (s *smtpSession) Logout() error {
@@ -1,13 +1,20 @@
 package smtp
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net"
 	"net/smtp"
+	"net/textproto"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 	
 	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/lifecycle"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
 	"github.com/tpdoyle87/simple-email-server/pkg/email"
 )
 
@@ -15,7 +22,7 @@ type mockQueue struct {
 	emails []*email.Email
 }
 
-func (m *mockQueue) Enqueue(e *email.Email) error {
+func (m *mockQueue) Enqueue(ctx context.Context, e *email.Email) error {
 	m.emails = append(m.emails, e)
 	return nil
 }
@@ -33,8 +40,20 @@ func TestNewServer(t *testing.T) {
 		t.Fatal("Expected server to be created")
 	}
 	
-	if server.hostname != cfg.Hostname {
-		t.Errorf("Expected hostname %s, got %s", cfg.Hostname, server.hostname)
+	if server.identity.Hostname() != cfg.Hostname {
+		t.Errorf("Expected hostname %s, got %s", cfg.Hostname, server.identity.Hostname())
+	}
+}
+
+func TestServer_SetLimits(t *testing.T) {
+	server := NewServer(&config.ServerConfig{Hostname: "localhost"}, &mockQueue{}, 25*1024*1024)
+	if server.smtpServer.MaxRecipients != defaultMaxRecipients {
+		t.Errorf("Expected default recipient limit %d, got %d", defaultMaxRecipients, server.smtpServer.MaxRecipients)
+	}
+	
+	server.SetLimits(&config.LimitsConfig{MaxRecipients: 500})
+	if server.smtpServer.MaxRecipients != 500 {
+		t.Errorf("Expected recipient limit 500, got %d", server.smtpServer.MaxRecipients)
 	}
 }
 
@@ -86,6 +105,42 @@ func TestServer_StartStop(t *testing.T) {
 	}
 }
 
+func TestServer_StartStopConcurrent(t *testing.T) {
+	cfg := &config.ServerConfig{
+		Hostname:      "localhost",
+		ListenAddress: "127.0.0.1:0",
+	}
+	server := NewServer(cfg, &mockQueue{}, 25*1024*1024)
+	
+	results := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() { results <- server.Start() }()
+	}
+	
+	for i := 0; i < 4; i++ {
+		if err := <-results; err != lifecycle.ErrAlreadyRunning {
+			t.Fatalf("Expected ErrAlreadyRunning, got %v", err)
+		}
+	}
+	
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server.Stop()
+		}()
+	}
+	wg.Wait()
+	
+	if err := <-results; err != nil {
+		t.Errorf("Expected the running Start to return cleanly, got %v", err)
+	}
+	if err := server.Start(); err != lifecycle.ErrStopped {
+		t.Errorf("Expected ErrStopped on restart, got %v", err)
+	}
+}
+
 func TestServer_HandleEmail(t *testing.T) {
 	cfg := &config.ServerConfig{
 		Hostname:      "localhost",
@@ -131,4 +186,255 @@ func TestServer_HandleEmail(t *testing.T) {
 	}
 	
 	server.Stop()
-}
\ No newline at end of file
+}
+
+func TestServer_KeepsRawMessage(t *testing.T) {
+	msg := "From:  Sender <sender@example.com>\r\n" +
+		"Subject: Test\r\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.com\r\n" +
+		"\r\n" +
+		"Body that must not change\r\n" +
+		strings.Repeat("x", 100) + "\r\n"
+	
+	tests := []struct {
+		name    string
+		limit   int64
+		spilled bool
+	}{
+		{"in memory", 1024 * 1024, false},
+		{"spilled to disk", 64, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.ServerConfig{
+				Hostname:       "localhost",
+				ListenAddress:  "127.0.0.1:0",
+				RawMemoryLimit: tt.limit,
+				RawSpoolDir:    t.TempDir(),
+			}
+			
+			queue := &mockQueue{}
+			server := NewServer(cfg, queue, 25*1024*1024)
+			go server.Start()
+			defer server.Stop()
+			time.Sleep(100 * time.Millisecond)
+			
+			if err := smtp.SendMail(server.Address(), nil, "sender@example.com", []string{"recipient@example.com"}, []byte(msg)); err != nil {
+				t.Fatalf("Failed to send email: %v", err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			
+			if len(queue.emails) != 1 {
+				t.Fatalf("Expected 1 email in queue, got %d", len(queue.emails))
+			}
+			queued := queue.emails[0]
+			if !queued.Relayed {
+				t.Error("Expected the email to be marked as relayed")
+			}
+			if (queued.RawPath != "") != tt.spilled {
+				t.Errorf("Expected spilled %v, got raw path %q", tt.spilled, queued.RawPath)
+			}
+			
+			r, err := queued.OpenRaw()
+			if err != nil {
+				t.Fatalf("Failed to open raw message: %v", err)
+			}
+			defer r.Close()
+			raw, _ := io.ReadAll(r)
+			
+			if !strings.HasPrefix(string(raw), "Received: from ") || !strings.Contains(string(raw), " id "+queued.ID+";") {
+				t.Errorf("Expected our trace header first, got %q", raw)
+			}
+			if !strings.HasSuffix(string(raw), msg) {
+				t.Errorf("Expected the message as received, got %q", raw)
+			}
+			if queued.Size() != int64(len(raw)) {
+				t.Errorf("Expected size %d, got %d", len(raw), queued.Size())
+			}
+			if int64(len(queued.Body)) > tt.limit {
+				t.Errorf("Expected at most %d bytes of body in memory, got %d", tt.limit, len(queued.Body))
+			}
+		})
+	}
+}
+
+func TestServer_DuplicateAccepted(t *testing.T) {
+	cfg := &config.ServerConfig{
+		Hostname:      "localhost",
+		ListenAddress: "127.0.0.1:0",
+	}
+	
+	q := queue.NewMemoryQueueWithConfig(&config.QueueConfig{
+		MaxSize:     10,
+		DedupWindow: time.Minute,
+	})
+	server := NewServer(cfg, q, 25*1024*1024)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+	
+	// The retransmission gets a 250 like the original, not a rejection
+	msg := []byte("Subject: Test\r\n\r\nThis is a test email")
+	for i := 0; i < 2; i++ {
+		if err := smtp.SendMail(server.Address(), nil, "sender@example.com", []string{"recipient@example.com"}, msg); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+	if q.Size() != 1 {
+		t.Errorf("Expected the duplicate not to be queued, got %d emails", q.Size())
+	}
+}
+
+// startServer runs a server for cfg on a free port until the test ends.
+func startServer(t *testing.T, cfg *config.ServerConfig) *Server {
+	t.Helper()
+	server := NewServer(cfg, &mockQueue{}, 25*1024*1024)
+	go server.Start()
+	t.Cleanup(func() { server.Stop() })
+	time.Sleep(100 * time.Millisecond)
+	return server
+}
+
+func TestServer_BannerAndExtensions(t *testing.T) {
+	server := startServer(t, &config.ServerConfig{
+		Hostname:      "mx.example.com",
+		ListenAddress: "127.0.0.1:0",
+		Banner:        "{hostname} ESMTP {product}",
+		Product:       "Mailroom",
+		Extensions: config.ExtensionsConfig{
+			AuthRequiresTLS: true,
+			HideSize:        true,
+			Hide:            []string{"chunking"},
+		},
+	})
+	
+	conn, err := textproto.Dial("tcp", server.Address())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	
+	_, banner, err := conn.ReadResponse(220)
+	if err != nil {
+		t.Fatalf("Failed to read greeting: %v", err)
+	}
+	if banner != "mx.example.com ESMTP Mailroom" {
+		t.Errorf("Expected banner %q, got %q", "mx.example.com ESMTP Mailroom", banner)
+	}
+	
+	id, _ := conn.Cmd("EHLO client.example.com")
+	conn.StartResponse(id)
+	_, reply, err := conn.ReadResponse(250)
+	conn.EndResponse(id)
+	if err != nil {
+		t.Fatalf("EHLO failed: %v", err)
+	}
+	
+	caps := strings.Split(reply, "\n")[1:]
+	for _, ext := range caps {
+		keyword := strings.Fields(ext)[0]
+		if keyword == "SIZE" || keyword == "AUTH" || keyword == "CHUNKING" {
+			t.Errorf("Expected %s to be hidden, got capabilities %q", keyword, caps)
+		}
+	}
+	if len(caps) == 0 {
+		t.Error("Expected the remaining capabilities to be advertised")
+	}
+}
+
+func TestServer_EarlyTalker(t *testing.T) {
+	server := startServer(t, &config.ServerConfig{
+		Hostname:      "mx.example.com",
+		ListenAddress: "127.0.0.1:0",
+		BannerDelay:   200 * time.Millisecond,
+	})
+	
+	// Speaking before the greeting gets the client disconnected
+	conn, err := textproto.Dial("tcp", server.Address())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	conn.PrintfLine("EHLO spambot.example.com")
+	if _, _, err := conn.ReadResponse(220); err == nil || !strings.HasPrefix(err.Error(), "554") {
+		t.Errorf("Expected a 554 for an early talker, got %v", err)
+	}
+	conn.Close()
+	
+	if n := server.EarlyTalkers(); n != 1 {
+		t.Errorf("Expected 1 early talker, got %d", n)
+	}
+	
+	// A client that waits is greeted after the delay
+	conn, err = textproto.Dial("tcp", server.Address())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	
+	start := time.Now()
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		t.Fatalf("Failed to read greeting: %v", err)
+	}
+	if waited := time.Since(start); waited < 150*time.Millisecond {
+		t.Errorf("Expected the greeting to be delayed, got it after %v", waited)
+	}
+	if n := server.EarlyTalkers(); n != 1 {
+		t.Errorf("Expected the patient client not to be counted, got %d", n)
+	}
+}
+
+// bounceRecorder keeps the bounces it is given.
+type bounceRecorder struct {
+	mu    sync.Mutex
+	rcpts []string
+	msgs  []string
+}
+
+func (r *bounceRecorder) HandleBounce(ctx context.Context, rcpt string, msg []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rcpts = append(r.rcpts, rcpt)
+	r.msgs = append(r.msgs, string(msg))
+	return nil
+}
+
+func TestServer_Bounces(t *testing.T) {
+	queue := &mockQueue{}
+	server := NewServer(&config.ServerConfig{Hostname: "localhost", ListenAddress: "127.0.0.1:0"}, queue, 25*1024*1024)
+	bounces := &bounceRecorder{}
+	server.SetBounceHandler(&config.ReturnPathConfig{Domain: "Bounces.example.com"}, bounces)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+	
+	msg := "Subject: Undelivered Mail Returned to Sender\r\n\r\nreport\r\n"
+	if err := smtp.SendMail(server.Address(), nil, "", []string{"bounce+id-1@bounces.example.com"}, []byte(msg)); err != nil {
+		t.Fatalf("Failed to send bounce: %v", err)
+	}
+	bounces.mu.Lock()
+	if len(bounces.rcpts) != 1 || bounces.rcpts[0] != "bounce+id-1@bounces.example.com" || !strings.HasSuffix(bounces.msgs[0], msg) {
+		t.Errorf("Expected the bounce handled, got %v %q", bounces.rcpts, bounces.msgs)
+	}
+	bounces.mu.Unlock()
+	if len(queue.emails) != 0 {
+		t.Errorf("Expected the bounce not relayed, got %d queued", len(queue.emails))
+	}
+	
+	// Bounces and mail to relay can't share a transaction
+	c, err := smtp.Dial(server.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Mail(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rcpt("bounce+id-2@bounces.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	err = c.Rcpt("recipient@example.com")
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != 452 {
+		t.Errorf("Expected 452 for a recipient to relay, got %v", err)
+	}
+}
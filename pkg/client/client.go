@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -27,6 +28,57 @@ type Email struct {
 	HTML        string            `json:"html,omitempty"`
 	Headers     map[string]string `json:"headers,omitempty"`
 	ScheduledAt *time.Time        `json:"scheduled_at,omitempty"`
+	Attachments []Attachment      `json:"attachments,omitempty"`
+	
+	// AllowDuplicate skips the server's duplicate suppression
+	AllowDuplicate bool `json:"allow_duplicate,omitempty"`
+	
+	// RaceMX asks the server to race connections to the top MX hosts
+	RaceMX bool `json:"race_mx,omitempty"`
+	
+	// Sandbox asks the server to skip the final SMTP send and mark the
+	// email delivered
+	Sandbox bool `json:"sandbox,omitempty"`
+	
+	// Debug asks the server to record the SMTP exchange of each delivery
+	// attempt; see Transcript
+	Debug bool `json:"debug,omitempty"`
+	
+	// NotifyOnFailure asks the server to send a non-delivery report to
+	// From if delivery fails for good
+	NotifyOnFailure bool `json:"notify_on_failure,omitempty"`
+	
+	// Lane is "transactional" (default) or "bulk"
+	Lane string `json:"lane,omitempty"`
+	
+	// Priority: 0 is normal, higher is sent sooner
+	Priority int `json:"priority,omitempty"`
+	
+	// SLA is how soon delivery must start, such as "60s"
+	SLA string `json:"sla,omitempty"`
+	
+	// MaxRetry overrides the server's retry limit; 0 means no retries
+	MaxRetry *int `json:"max_retry,omitempty"`
+	
+	// Durability set to DurabilityConfirmed makes Send return only once
+	// the email is in durable storage; see SendResponse.Committed
+	Durability string `json:"durability,omitempty"`
+}
+
+// DurabilityConfirmed waits for the server to commit an email to durable
+// storage before Send returns
+const DurabilityConfirmed = "confirmed"
+
+// Attachment is sent inline as Data, or by reference as an https URL or a
+// path the server is allowed to read, which avoids base64-encoding large
+// files into the request
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Path        string `json:"path,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
 }
 
 // SendResponse is the response from sending an email
@@ -34,6 +86,88 @@ type SendResponse struct {
 	ID      string `json:"id"`
 	Status  string `json:"status"`
 	Message string `json:"message"`
+	
+	// Warnings report soft limits (queue utilization, message size) that
+	// were crossed; the email was still accepted
+	Warnings []string `json:"warnings,omitempty"`
+	
+	// Committed reports that the email was in durable storage when the
+	// server answered. It stays false for a confirmed send to a server
+	// whose queue is held only in memory.
+	Committed bool `json:"committed"`
+}
+
+// TimeSeriesResponse is one metric's history, oldest bucket first
+type TimeSeriesResponse struct {
+	Metric        string  `json:"metric"`
+	Resolution    string  `json:"resolution"`
+	BucketSeconds float64 `json:"bucket_seconds"`
+	Points        []Point `json:"points"`
+}
+
+// Point is one bucket of a time series
+type Point struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// PausedDomain is a recipient domain whose delivery is paused, and how
+// many queued emails are held for it
+type PausedDomain struct {
+	Domain   string     `json:"domain"`
+	Reason   string     `json:"reason,omitempty"`
+	PausedAt time.Time  `json:"paused_at"`
+	Until    *time.Time `json:"until,omitempty"`
+	Held     int        `json:"held"`
+}
+
+// DeliveryPause is whether all outbound delivery is paused, since when and
+// why
+type DeliveryPause struct {
+	Paused   bool       `json:"paused"`
+	Reason   string     `json:"reason,omitempty"`
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+}
+
+// Event reports one bulk operation: how many emails it affected and a
+// sample of their IDs. ExportURL, a path on the server, lists them all
+type Event struct {
+	ID        string            `json:"id"`
+	Version   int               `json:"version"`
+	Type      string            `json:"type"`
+	Time      time.Time         `json:"time"`
+	Filter    map[string]string `json:"filter,omitempty"`
+	Count     int               `json:"count"`
+	SampleIDs []string          `json:"sample_ids"`
+	ExportURL string            `json:"export_url,omitempty"`
+}
+
+// PurgeFilter selects the emails Purge cancels. Set at least one field
+// besides Reason
+type PurgeFilter struct {
+	Domain string
+	From   string
+	Status string
+	Before time.Time
+	Reason string
+}
+
+// ForecastResponse is the expected draining of the queue backlog
+type ForecastResponse struct {
+	GeneratedAt         time.Time       `json:"generated_at"`
+	Ready               int             `json:"ready"`
+	Sending             int             `json:"sending"`
+	Scheduled           int             `json:"scheduled"`
+	ThroughputPerMinute float64         `json:"throughput_per_minute"`
+	EstimatedDrainAt    *time.Time      `json:"estimated_drain_at"`
+	LimitedBy           string          `json:"limited_by,omitempty"`
+	Points              []ForecastPoint `json:"points"`
+}
+
+// ForecastPoint is the backlog expected at a time
+type ForecastPoint struct {
+	Time        time.Time `json:"time"`
+	Outstanding int       `json:"outstanding"`
 }
 
 // StatusResponse is the response from checking email status
@@ -41,10 +175,56 @@ type StatusResponse struct {
 	ID          string     `json:"id"`
 	Status      string     `json:"status"`
 	RetryCount  int        `json:"retry_count"`
+	DeferCount  int        `json:"defer_count"`
 	LastError   string     `json:"last_error,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
 	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Priority    int        `json:"priority"`
+	
+	// Why a queued email is being held back, such as "domain_paused"
+	DeferredReason string `json:"deferred_reason,omitempty"`
+	
+	// When delivery was first attempted, and when a queued email will
+	// next be attempted
+	FirstAttemptAt *time.Time `json:"first_attempt_at,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	
+	// LastError classified: the SMTP reply code and text, and whether
+	// the failure is permanent
+	LastFailure *Failure `json:"last_failure,omitempty"`
+	
+	// Outcome per recipient, once delivery has been attempted
+	Recipients map[string]RecipientStatus `json:"recipients,omitempty"`
+	
+	// Set for rejected emails
+	RejectedBy   string `json:"rejected_by,omitempty"`
+	RejectReason string `json:"reject_reason,omitempty"`
+	
+	// Set for quarantined emails
+	QuarantineReason string     `json:"quarantine_reason,omitempty"`
+	QuarantinedAt    *time.Time `json:"quarantined_at,omitempty"`
+	
+	// Set for held emails
+	HoldReason string     `json:"hold_reason,omitempty"`
+	HeldAt     *time.Time `json:"held_at,omitempty"`
+	
+	// Set for emails sent with an SLA; SLABreached once the first
+	// attempt started after SLADeadline
+	SLADeadline *time.Time `json:"sla_deadline,omitempty"`
+	SLABreached bool       `json:"sla_breached,omitempty"`
+	
+	// The envelope sender (MAIL FROM) of the latest attempt
+	EnvelopeFrom string `json:"envelope_from,omitempty"`
+	
+	// The SMTP transactions tried, oldest first; the server keeps the
+	// last 20
+	Attempts []Attempt `json:"attempts,omitempty"`
+	
+	// Set when the server sent some recipients' mail to its catch-all
+	// address instead
+	Redirect *Redirect `json:"redirect,omitempty"`
 }
 
 // StatsResponse is the response from the stats endpoint
@@ -53,15 +233,252 @@ type StatsResponse struct {
 	TotalSent      int64 `json:"total_sent"`
 	TotalDelivered int64 `json:"total_delivered"`
 	TotalFailed    int64 `json:"total_failed"`
+	TotalExpired   int64 `json:"total_expired"`
+	TotalRejected  int64 `json:"total_rejected"`
+	
+	// Queue breakdown
+	Queued                 int     `json:"queued"`
+	Sending                int     `json:"sending"`
+	Scheduled              int     `json:"scheduled"`
+	Retrying               int     `json:"retrying"`
+	Quarantined            int     `json:"quarantined"`
+	Held                   int     `json:"held"`
+	OldestQueuedAgeSeconds float64 `json:"oldest_queued_age_seconds"`
+	
+	// Most common reasons emails failed for good, most frequent first
+	FailureReasons []FailureReasonCount `json:"failure_reasons,omitempty"`
+	
+	// SMTP clients disconnected for speaking before the greeting
+	EarlyTalkers int64 `json:"early_talkers"`
+	
+	// Emails first attempted after their SLA deadline
+	TotalSLABreached int64 `json:"total_sla_breached"`
+	
+	// Recipients dropped at send time because they were suppressed after
+	// the email was accepted; total_rejected counts whole emails
+	TotalSuppressed int64 `json:"total_suppressed"`
+	
+	// Status lookups served from the server's cache, if it has one
+	StatusCache *StatusCacheStats `json:"status_cache,omitempty"`
+	
+	// Per-domain send rate limits and how often they held mail back
+	DomainThrottles []DomainThrottle `json:"domain_throttles,omitempty"`
+	
+	// The IP warm-up schedule and each domain's use of today's cap, if the
+	// server is warming up
+	Warmup *WarmupStats `json:"warmup,omitempty"`
+	
+	// Per-domain circuit breakers that have tripped or are counting failures
+	CircuitBreakers []BreakerState `json:"circuit_breakers,omitempty"`
+	
+	// Refusals because of the sending IP's reputation, and the blocklists
+	// receivers currently cite
+	ReputationBlock int64             `json:"reputation_block"`
+	Blocklists      []ReputationBlock `json:"blocklists,omitempty"`
+	
+	// Whether all outbound delivery is paused
+	DeliveryPaused bool `json:"delivery_paused"`
+	
+	// Delivery workers running, if the server allows changing them
+	DeliveryWorkers *int `json:"delivery_workers,omitempty"`
+	
+	// How often connections to a second MX host were raced and which won
+	Racing *RaceStats `json:"racing,omitempty"`
 }
 
+// RaceStats counts MX connection races: how many ran, whether the primary
+// or a racing host won, and how many found no host answering
+type RaceStats struct {
+	Races      int64 `json:"races"`
+	PrimaryWon int64 `json:"primary_won"`
+	RacerWon   int64 `json:"racer_won"`
+	AllFailed  int64 `json:"all_failed"`
+}
+
+// StatusCacheStats reports how well the server's status cache is doing
+type StatusCacheStats struct {
+	Entries int     `json:"entries"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// DomainThrottle is the rate limit state of one recipient domain. Limited
+// is true while the domain has no room for another email
+type DomainThrottle struct {
+	Domain        string     `json:"domain"`
+	Limit         string     `json:"limit"`
+	Available     float64    `json:"available"`
+	Limited       bool       `json:"limited"`
+	Throttled     int64      `json:"throttled"`
+	LastThrottled *time.Time `json:"last_throttled,omitempty"`
+}
+
+// WarmupStats is the state of the server's warm-up schedule on Day, 1 for
+// the first. Active is false once the schedule has run out
+type WarmupStats struct {
+	Active     bool           `json:"active"`
+	Start      string         `json:"start"`
+	Day        int            `json:"day"`
+	Days       int            `json:"days"`
+	DailyLimit int            `json:"daily_limit,omitempty"`
+	Domains    []DomainWarmup `json:"domains,omitempty"`
+}
+
+// DomainWarmup is one recipient domain's use of today's warm-up cap
+type DomainWarmup struct {
+	Domain    string `json:"domain"`
+	Sent      int    `json:"sent"`
+	Remaining int    `json:"remaining"`
+}
+
+// BreakerState is the circuit breaker state of one recipient domain:
+// "closed", "open" while its mail is held, or "half-open" while a probe
+// is in flight
+type BreakerState struct {
+	Domain   string     `json:"domain"`
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	Opened   int64      `json:"opened"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	RetryAt  *time.Time `json:"retry_at,omitempty"`
+}
+
+// ReputationBlock is a blocklist that receivers have refused the server's
+// sending IP under, with the latest refusal
+type ReputationBlock struct {
+	Blocklist string    `json:"blocklist"`
+	Domain    string    `json:"domain"`
+	Host      string    `json:"host"`
+	Reply     string    `json:"reply"`
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// RecipientStatus is the delivery outcome for one recipient: "queued"
+// while it is still to be retried, then "delivered", "failed" or
+// "suppressed"
+type RecipientStatus struct {
+	Status      string     `json:"status"`
+	LastError   string     `json:"last_error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// Failure classifies a failed delivery attempt. Code is the SMTP reply
+// code, or 0 when no server replied; permanent failures are not retried
+// and leave the email "bounced". Greylisted failures and those whose reply
+// names a wait, RetryAfter in seconds, are retried sooner
+type Failure struct {
+	Code       int    `json:"code,omitempty"`
+	Text       string `json:"text"`
+	Permanent  bool   `json:"permanent"`
+	Greylisted bool   `json:"greylisted,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// Attempt is one SMTP transaction of a delivery attempt. Result is
+// "delivered", "smtp_error" with Code and Text the server's reply, or
+// "network_error" with Text the error when no server replied
+type Attempt struct {
+	At         time.Time `json:"at"`
+	Number     int       `json:"attempt"`
+	Host       string    `json:"host"`
+	IP         string    `json:"ip,omitempty"`
+	Result     string    `json:"result"`
+	Code       int       `json:"code,omitempty"`
+	Text       string    `json:"text,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	TLS        bool      `json:"tls"`
+}
+
+// TranscriptResponse is the SMTP exchange of each transaction of an email
+// sent with Debug, oldest first
+type TranscriptResponse struct {
+	ID           string                  `json:"id"`
+	Transactions []TranscriptTransaction `json:"transactions"`
+}
+
+// TranscriptTransaction is one transaction's exchange: lines the client
+// sent start "C: " and the server's replies "S: "
+type TranscriptTransaction struct {
+	Attempt int       `json:"attempt"`
+	At      time.Time `json:"at"`
+	Host    string    `json:"host"`
+	Result  string    `json:"result"`
+	Lines   []string  `json:"lines"`
+}
+
+// Redirect is the catch-all address a test server sent the mail of
+// Recipients to
+type Redirect struct {
+	To         string   `json:"to"`
+	Recipients []string `json:"recipients"`
+}
+
+// FailureReasonCount is one entry of the failure breakdown in stats
+type FailureReasonCount struct {
+	Reason string `json:"reason"`
+	Count  int64  `json:"count"`
+}
+
+// TestEmailResponse reports how delivery of a test email went. Status is
+// "pending" if the server gave up waiting for the result
+type TestEmailResponse struct {
+	ID             string        `json:"id"`
+	To             string        `json:"to"`
+	Status         string        `json:"status"`
+	DeliveredTo    string        `json:"delivered_to,omitempty"`
+	TLS            bool          `json:"tls"`
+	Error          string        `json:"error,omitempty"`
+	Failure        *Failure      `json:"failure,omitempty"`
+	Transactions   []Transaction `json:"transactions,omitempty"`
+	Log            []string      `json:"log,omitempty"`
+	ElapsedSeconds float64       `json:"elapsed_seconds"`
+}
+
+// Transaction is one SMTP transaction of a delivery attempt. Outcome is
+// "greeting-deferred" when the host refused the connection in its greeting
+type Transaction struct {
+	Host    string   `json:"host"`
+	Rcpts   []string `json:"rcpts"`
+	TLS     bool     `json:"tls"`
+	Error   string   `json:"error,omitempty"`
+	Outcome string   `json:"outcome,omitempty"`
+}
+
+// QuotaResponse is the response from the quota endpoint. Limit is zero
+// when the key has no daily quota.
+type QuotaResponse struct {
+	Key       string    `json:"key"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+// StatusError is returned when the server answers with an unexpected HTTP
+// status, such as 429 when a quota is used up
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.Code, e.Body)
+}
+
+// DefaultTimeout bounds each request made by a client created with New.
+// Use NewWithHTTPClient for a different timeout.
+const DefaultTimeout = 30 * time.Second
+
 // New creates a new email server client
 func New(baseURL, authToken string) *Client {
 	return &Client{
 		baseURL:   baseURL,
 		authToken: authToken,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout: DefaultTimeout,
 		},
 	}
 }
@@ -96,9 +513,10 @@ func (c *Client) Send(email *Email) (*SendResponse, error) {
 	}
 	defer resp.Body.Close()
 	
-	if resp.StatusCode != http.StatusAccepted {
+	// 201 answers a confirmed send that was committed
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusCreated {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 	
 	var sendResp SendResponse
@@ -132,7 +550,7 @@ func (c *Client) SendBatch(emails []*Email) ([]*SendResponse, error) {
 	
 	if resp.StatusCode != http.StatusAccepted {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 	
 	var responses []*SendResponse
@@ -143,6 +561,42 @@ func (c *Client) SendBatch(emails []*Email) ([]*SendResponse, error) {
 	return responses, nil
 }
 
+// SendRaw sends a complete RFC 5322 message as-is using the given
+// envelope sender and recipients
+func (c *Client) SendRaw(from string, to []string, raw []byte) (*SendResponse, error) {
+	params := url.Values{}
+	params.Set("from", from)
+	for _, addr := range to {
+		params.Add("to", addr)
+	}
+	
+	req, err := http.NewRequest("POST", c.baseURL+"/send/raw?"+params.Encode(), bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Content-Type", "message/rfc822")
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var sendResp SendResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sendResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return &sendResp, nil
+}
+
 // GetStatus gets the status of an email by ID
 func (c *Client) GetStatus(id string) (*StatusResponse, error) {
 	req, err := http.NewRequest("GET", c.baseURL+"/status/"+id, nil)
@@ -160,7 +614,7 @@ func (c *Client) GetStatus(id string) (*StatusResponse, error) {
 	
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 	
 	var statusResp StatusResponse
@@ -171,6 +625,140 @@ func (c *Client) GetStatus(id string) (*StatusResponse, error) {
 	return &statusResp, nil
 }
 
+// Transcript gets the SMTP transcript of an email sent with Debug
+func (c *Client) Transcript(id string) (*TranscriptResponse, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/status/"+id+"/transcript", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var transcript TranscriptResponse
+	if err := json.NewDecoder(resp.Body).Decode(&transcript); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return &transcript, nil
+}
+
+// Cancel cancels an email that has not been delivered yet. The response
+// status is "rejected" once it is cancelled, or "sending" if it is being
+// delivered and will be cancelled before its SMTP transaction starts.
+func (c *Client) Cancel(id, reason string) (*SendResponse, error) {
+	body, err := json.Marshal(map[string]string{"reason": reason})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	
+	req, err := http.NewRequest("DELETE", c.baseURL+"/status/"+id, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var cancelResp SendResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cancelResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return &cancelResp, nil
+}
+
+// Hold holds a queued email back, with status "held", until Release is
+// called. It is not sent, retried or expired while held
+func (c *Client) Hold(id, reason string) (*SendResponse, error) {
+	return c.holdAction(id, "hold", map[string]string{"reason": reason})
+}
+
+// Release returns a held email to the queue for delivery
+func (c *Client) Release(id string) (*SendResponse, error) {
+	return c.holdAction(id, "release", nil)
+}
+
+func (c *Client) holdAction(id, action string, payload map[string]string) (*SendResponse, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	
+	req, err := http.NewRequest("POST", c.baseURL+"/emails/"+url.PathEscape(id)+"/"+action, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var holdResp SendResponse
+	if err := json.NewDecoder(resp.Body).Decode(&holdResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return &holdResp, nil
+}
+
+// GetRaw gets the message exactly as it will be sent
+func (c *Client) GetRaw(id string) ([]byte, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/emails/"+id+"/raw", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	return body, nil
+}
+
 // GetStats gets server statistics
 func (c *Client) GetStats() (*StatsResponse, error) {
 	req, err := http.NewRequest("GET", c.baseURL+"/stats", nil)
@@ -188,7 +776,7 @@ func (c *Client) GetStats() (*StatsResponse, error) {
 	
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 	
 	var statsResp StatsResponse
@@ -197,4 +785,338 @@ func (c *Client) GetStats() (*StatsResponse, error) {
 	}
 	
 	return &statsResp, nil
-}
\ No newline at end of file
+}
+
+// GetTimeSeries gets one metric's recent history: "accepted",
+// "delivered", "failed", "deferred" or "bounced", per "minute", "hour" or
+// "day"
+func (c *Client) GetTimeSeries(metric, resolution string) (*TimeSeriesResponse, error) {
+	query := url.Values{"metric": {metric}, "resolution": {resolution}}
+	req, err := http.NewRequest("GET", c.baseURL+"/stats/timeseries?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var seriesResp TimeSeriesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&seriesResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return &seriesResp, nil
+}
+
+// GetForecast gets when the queue backlog is expected to clear at the
+// current throughput
+func (c *Client) GetForecast() (*ForecastResponse, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/stats/forecast", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var forecastResp ForecastResponse
+	if err := json.NewDecoder(resp.Body).Decode(&forecastResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return &forecastResp, nil
+}
+
+// GetQuota gets the daily quota usage of the client's key
+func (c *Client) GetQuota() (*QuotaResponse, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/quota", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var quotaResp QuotaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&quotaResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return &quotaResp, nil
+}
+
+// SendTestEmail sends a diagnostic email to to and waits for the server's
+// report on its delivery. The server waits up to its configured timeout,
+// so the client's own timeout should be longer.
+func (c *Client) SendTestEmail(to string) (*TestEmailResponse, error) {
+	body, err := json.Marshal(map[string]string{"to": to})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	
+	req, err := http.NewRequest("POST", c.baseURL+"/admin/test-email", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var testResp TestEmailResponse
+	if err := json.NewDecoder(resp.Body).Decode(&testResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return &testResp, nil
+}
+
+// PauseDomain holds all delivery to domain until ResumeDomain is called,
+// or for d if it is positive
+func (c *Client) PauseDomain(domain string, d time.Duration, reason string) (*PausedDomain, error) {
+	payload := map[string]string{"reason": reason}
+	if d > 0 {
+		payload["duration"] = d.String()
+	}
+	return c.domainAction(domain, "pause", payload)
+}
+
+// ResumeDomain resumes delivery to a paused domain
+func (c *Client) ResumeDomain(domain string) (*PausedDomain, error) {
+	return c.domainAction(domain, "resume", nil)
+}
+
+func (c *Client) domainAction(domain, action string, payload map[string]string) (*PausedDomain, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	
+	req, err := http.NewRequest("POST", c.baseURL+"/admin/domains/"+url.PathEscape(domain)+"/"+action, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var pauseResp PausedDomain
+	if err := json.NewDecoder(resp.Body).Decode(&pauseResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return &pauseResp, nil
+}
+
+// PauseDelivery stops all outbound delivery until ResumeDelivery is
+// called. The server keeps accepting and queueing mail
+func (c *Client) PauseDelivery(reason string) (*DeliveryPause, error) {
+	return c.deliveryAction("pause", map[string]string{"reason": reason})
+}
+
+// ResumeDelivery restarts paused outbound delivery
+func (c *Client) ResumeDelivery() (*DeliveryPause, error) {
+	return c.deliveryAction("resume", nil)
+}
+
+// SetWorkers changes how many delivery workers the server runs and
+// returns the new count. Zero stops delivery until workers are added
+func (c *Client) SetWorkers(n int) (int, error) {
+	body, err := json.Marshal(map[string]int{"count": n})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	
+	req, err := http.NewRequest("POST", c.baseURL+"/admin/delivery/workers", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var workers struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&workers); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return workers.Count, nil
+}
+
+func (c *Client) deliveryAction(action string, payload map[string]string) (*DeliveryPause, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	
+	req, err := http.NewRequest("POST", c.baseURL+"/admin/delivery/"+action, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var pause DeliveryPause
+	if err := json.NewDecoder(resp.Body).Decode(&pause); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return &pause, nil
+}
+
+// Purge cancels every email in the queue matching filter
+func (c *Client) Purge(filter PurgeFilter) (*Event, error) {
+	payload := map[string]string{}
+	for key, value := range map[string]string{"domain": filter.Domain, "from": filter.From, "status": filter.Status, "reason": filter.Reason} {
+		if value != "" {
+			payload[key] = value
+		}
+	}
+	if !filter.Before.IsZero() {
+		payload["before"] = filter.Before.Format(time.RFC3339)
+	}
+	return c.bulkAction("/admin/purge", payload)
+}
+
+// CancelBatch cancels each of ids as Cancel does. The event counts only
+// the emails still in the queue
+func (c *Client) CancelBatch(ids []string, reason string) (*Event, error) {
+	return c.bulkAction("/admin/cancel", map[string]any{"ids": ids, "reason": reason})
+}
+
+func (c *Client) bulkAction(path string, payload any) (*Event, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	
+	req, err := http.NewRequest("POST", c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var event Event
+	if err := json.NewDecoder(resp.Body).Decode(&event); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return &event, nil
+}
+
+// GetPausedDomains lists the recipient domains whose delivery is paused
+func (c *Client) GetPausedDomains() ([]PausedDomain, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/admin/domains", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	
+	var domains []PausedDomain
+	if err := json.NewDecoder(resp.Body).Decode(&domains); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	return domains, nil
+}
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -156,4 +158,83 @@ func TestClient_SendBatch(t *testing.T) {
 	if responses[1].ID != "test-2" {
 		t.Errorf("Expected second ID test-2, got %s", responses[1].ID)
 	}
-}
\ No newline at end of file
+}
+func TestClient_SendRaw(t *testing.T) {
+	raw := []byte("From: sender@example.com\r\nSubject: Test\r\n\r\nTest body")
+	
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/send/raw" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		
+		if r.Header.Get("Content-Type") != "message/rfc822" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		
+		query := r.URL.Query()
+		if query.Get("from") != "sender@example.com" || len(query["to"]) != 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != string(raw) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"id":"raw-123","status":"queued","message":"Email queued for delivery"}`))
+	}))
+	defer server.Close()
+	
+	client := New(server.URL, "test-token")
+	
+	resp, err := client.SendRaw("sender@example.com", []string{"a@example.com", "b@example.com"}, raw)
+	if err != nil {
+		t.Fatalf("Failed to send raw email: %v", err)
+	}
+	
+	if resp.ID != "raw-123" {
+		t.Errorf("Expected ID raw-123, got %s", resp.ID)
+	}
+}
+
+func TestClient_SendConfirmed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Email
+		json.NewDecoder(r.Body).Decode(&e)
+		if e.Durability != DurabilityConfirmed {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"id":"test-123","status":"queued","committed":false}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"test-123","status":"queued","committed":true}`))
+	}))
+	defer server.Close()
+	
+	client := New(server.URL, "test-token")
+	e := &Email{
+		From:       "sender@example.com",
+		To:         []string{"recipient@example.com"},
+		Subject:    "Test",
+		Body:       "Test body",
+		Durability: DurabilityConfirmed,
+	}
+	
+	resp, err := client.Send(e)
+	if err != nil {
+		t.Fatalf("Failed to send email: %v", err)
+	}
+	if !resp.Committed {
+		t.Errorf("Expected a committed send, got %+v", resp)
+	}
+	
+	e.Durability = ""
+	if resp, err := client.Send(e); err != nil || resp.Committed {
+		t.Errorf("Expected an uncommitted send, got %+v, %v", resp, err)
+	}
+}
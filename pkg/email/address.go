@@ -0,0 +1,74 @@
+package email
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// ParseAddressList returns the addresses in an address header value such
+// as To or Cc. Groups ("Team: a@example.com, b@example.com;") are flattened
+// into their members, so an empty group like "undisclosed-recipients:;"
+// yields no addresses. Comments and obsolete source routes are tolerated.
+func ParseAddressList(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	
+	parsed, err := mail.ParseAddressList(list)
+	if err != nil {
+		// Retry without the obsolete syntax net/mail does not accept
+		var retryErr error
+		if parsed, retryErr = mail.ParseAddressList(stripObsolete(list)); retryErr != nil {
+			return nil, err
+		}
+	}
+	
+	addresses := make([]string, 0, len(parsed))
+	for _, addr := range parsed {
+		addresses = append(addresses, addr.Address)
+	}
+	return addresses, nil
+}
+
+// stripObsolete removes comments outside quoted strings and source routes
+// ("<@relay.example:user@example.com>") from an address list.
+func stripObsolete(s string) string {
+	var b strings.Builder
+	depth := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && (quoted || depth > 0) && i+1 < len(s):
+			if depth == 0 {
+				b.WriteString(s[i : i+2])
+			}
+			i++
+		case quoted:
+			if c == '"' {
+				quoted = false
+			}
+			b.WriteByte(c)
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case depth > 0:
+			// Inside a comment
+		case c == '"':
+			quoted = true
+			b.WriteByte(c)
+		case c == '<':
+			b.WriteByte(c)
+			rest := strings.TrimLeft(s[i+1:], " \t")
+			if strings.HasPrefix(rest, "@") {
+				if j := strings.IndexByte(rest, ':'); j >= 0 {
+					i = len(s) - len(rest) + j
+				}
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
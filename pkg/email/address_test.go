@@ -0,0 +1,82 @@
+package email
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAddressList(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "plain",
+			list: "alice@example.com, Bob <bob@example.com>",
+			want: []string{"alice@example.com", "bob@example.com"},
+		},
+		{
+			name: "group",
+			list: "Team: alice@example.com, Bob <bob@example.com>;",
+			want: []string{"alice@example.com", "bob@example.com"},
+		},
+		{
+			name: "empty group",
+			list: "undisclosed-recipients:;",
+			want: []string{},
+		},
+		{
+			name: "group between mailboxes",
+			list: "carol@example.com, A Group: ed@example.org, \"Jo Doe\" <jo@example.org>;, dave@example.com",
+			want: []string{"carol@example.com", "ed@example.org", "jo@example.org", "dave@example.com"},
+		},
+		{
+			name: "comments",
+			list: "Pete(A nice \\) chap) <pete(his account)@silly.test(his host)>",
+			want: []string{"pete@silly.test"},
+		},
+		{
+			name: "trailing comment",
+			list: "jdoe@example.org (John Doe)",
+			want: []string{"jdoe@example.org"},
+		},
+		{
+			name: "obsolete phrase",
+			list: "Joe Q. Public <john.q.public@example.com>",
+			want: []string{"john.q.public@example.com"},
+		},
+		{
+			name: "obsolete route",
+			list: "Mary Smith <@node.test,@relay.test:mary@example.net>",
+			want: []string{"mary@example.net"},
+		},
+		{
+			name: "quoted parenthesis",
+			list: "\"Smith (Sales)\" <smith@example.com>",
+			want: []string{"smith@example.com"},
+		},
+		{
+			name: "blank",
+			list: "  ",
+		},
+		{
+			name:    "garbage",
+			list:    "not an address",
+			wantErr: true,
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAddressList(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAddressList(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAddressList(%q) = %v, want %v", tt.list, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package email
 
 import (
+	"bytes"
 	"errors"
+	"io"
 	"net/mail"
+	"os"
+	"slices"
 	"strings"
 	"time"
 )
@@ -14,6 +18,9 @@ var (
 	ErrEmptySubject      = errors.New("empty subject")
 	ErrEmptyBody         = errors.New("empty body")
 	ErrMessageTooLarge   = errors.New("message too large")
+	ErrInvalidLane       = errors.New("invalid lane")
+	ErrInvalidMaxRetry   = errors.New("max retry must not be negative")
+	ErrInvalidTransition = errors.New("invalid status transition")
 )
 
 type Status string
@@ -25,6 +32,54 @@ const (
 	StatusDelivered Status = "delivered"
 	StatusFailed    Status = "failed"
 	StatusBounced   Status = "bounced"
+	
+	// StatusRejected is terminal: a policy chose not to send the email
+	StatusRejected Status = "rejected"
+	
+	// StatusQuarantined parks a queued email for review; it is released
+	// back to queued or rejected
+	StatusQuarantined Status = "quarantined"
+	
+	// StatusHeld holds a queued email back on request until it is
+	// released back to queued or rejected
+	StatusHeld Status = "held"
+	
+	// StatusSuppressed is a recipient's status when it was found on the
+	// suppression list just before sending and dropped from the attempt
+	StatusSuppressed Status = "suppressed"
+)
+
+// Final reports whether an email with this status is done with, and will
+// not change again.
+func (s Status) Final() bool {
+	switch s {
+	case StatusDelivered, StatusFailed, StatusBounced, StatusRejected:
+		return true
+	}
+	return false
+}
+
+// RecipientStatus is the delivery outcome for one recipient: StatusQueued
+// while it is still to be retried, or StatusDelivered, StatusFailed or
+// StatusSuppressed once it is done.
+type RecipientStatus struct {
+	Status      Status     `json:"status"`
+	LastError   string     `json:"last_error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// Done reports whether the recipient has a final outcome.
+func (r RecipientStatus) Done() bool {
+	return r.Status == StatusDelivered || r.Status == StatusFailed || r.Status == StatusSuppressed
+}
+
+// Lane separates urgent transactional mail from bulk sends so that large
+// campaigns cannot delay it.
+type Lane string
+
+const (
+	LaneTransactional Lane = "transactional"
+	LaneBulk          Lane = "bulk"
 )
 
 type Email struct {
@@ -39,14 +94,176 @@ type Email struct {
 	Headers     map[string]string `json:"headers,omitempty"`
 	Attachments []Attachment      `json:"attachments,omitempty"`
 	
+	// Raw holds a complete RFC 5322 message submitted as-is. When set it
+	// is delivered verbatim instead of being built from the fields above.
+	Raw []byte `json:"raw,omitempty"`
+	
+	// RawPath replaces Raw for a raw message too large to keep in memory,
+	// and RawSize records its length. Body then holds only the start of
+	// the message body.
+	RawPath string `json:"raw_path,omitempty"`
+	RawSize int64  `json:"raw_size,omitempty"`
+	
+	// Relayed marks mail received over SMTP. Its raw message already
+	// carries our trace header and is sent exactly as stored.
+	Relayed bool `json:"relayed,omitempty"`
+	
+	// EnvelopeFrom is the MAIL FROM address bounces go to, recorded by
+	// delivery: a return path such as bounce+<id>@bounces.example.com, or
+	// From when none is configured
+	EnvelopeFrom string `json:"envelope_from,omitempty"`
+	
+	// NotifyOnFailure asks for a non-delivery report to From when
+	// delivery fails for good. Relayed mail always gets one.
+	NotifyOnFailure bool `json:"notify_on_failure,omitempty"`
+	
+	// Notification marks a non-delivery report we generated. It is sent
+	// with a null envelope sender and never triggers a report itself.
+	Notification bool `json:"notification,omitempty"`
+	
+	// AllowDuplicate bypasses the queue's duplicate suppression
+	AllowDuplicate bool `json:"allow_duplicate,omitempty"`
+	
+	// RaceMX races connections to the top MX hosts for lower latency
+	RaceMX bool `json:"race_mx,omitempty"`
+	
+	// Sandbox skips the final SMTP send; the email is marked delivered
+	Sandbox bool `json:"sandbox,omitempty"`
+	
+	// Diagnostic marks a test email; its delivery log is kept in full
+	Diagnostic bool `json:"diagnostic,omitempty"`
+	
+	// Debug records the SMTP exchange of each of the email's
+	// transactions in its Attempts, for GET /status/{id}/transcript
+	Debug bool `json:"debug,omitempty"`
+	
+	// Lane defaults to LaneTransactional when empty
+	Lane Lane `json:"lane,omitempty"`
+	
+	// Priority orders delivery within the queue; 0 is normal and higher
+	// values are sent sooner
+	Priority int `json:"priority,omitempty"`
+	
+	// MaxRetry overrides the configured number of retries for this email;
+	// zero means a single attempt. Nil uses the configured limit.
+	MaxRetry *int `json:"max_retry,omitempty"`
+	
+	// SLADeadline is when the first delivery attempt must have started.
+	// The queue sends the email ahead of other mail as it nears, and sets
+	// SLABreached if the attempt starts late.
+	SLADeadline *time.Time `json:"sla_deadline,omitempty"`
+	SLABreached bool       `json:"sla_breached,omitempty"`
+	
 	Status      Status            `json:"status"`
 	RetryCount  int               `json:"retry_count"`
 	LastError   string            `json:"last_error,omitempty"`
 	
+	// LastFailure is LastError in structured form, when the queue keeps it
+	LastFailure *Failure `json:"last_failure,omitempty"`
+	
+	// Attempts is the history of SMTP transactions tried, oldest first,
+	// keeping the last MaxAttempts
+	Attempts []Attempt `json:"attempts,omitempty"`
+	
+	// Redirect records the recipients whose mail delivery sent to a
+	// catch-all address instead
+	Redirect *Redirect `json:"redirect,omitempty"`
+	
+	// OriginalTo is set only on the copy of an email sent to a redirect
+	// address, and is written as its X-Original-To header
+	OriginalTo []string `json:"-"`
+	
+	// Times delivery was postponed without being attempted, for example
+	// because the resolver was unavailable. These do not count as retries.
+	DeferCount int `json:"defer_count,omitempty"`
+	
+	// Why the email is being held back while it waits, such as
+	// "domain_paused"; cleared when delivery is next attempted
+	DeferredReason string `json:"deferred_reason,omitempty"`
+	
+	// Outcome per recipient address. Recipients without an entry have not
+	// been attempted yet; retries skip those that are done.
+	RecipientStatus map[string]RecipientStatus `json:"recipient_status,omitempty"`
+	
+	// When delivery was first attempted
+	FirstAttemptAt *time.Time `json:"first_attempt_at,omitempty"`
+	
+	// Set when a policy rejects the email
+	RejectedBy   string `json:"rejected_by,omitempty"`
+	RejectReason string `json:"reject_reason,omitempty"`
+	
+	// Set when the email is cancelled while it is being delivered; it is
+	// rejected instead of being sent or retried
+	CancelReason string `json:"cancel_reason,omitempty"`
+	
+	// Set while the email is quarantined
+	QuarantineReason string     `json:"quarantine_reason,omitempty"`
+	QuarantinedAt    *time.Time `json:"quarantined_at,omitempty"`
+	
+	// SubmittedBy names the API key that submitted the email, "default"
+	// for the main token; empty for mail received over SMTP
+	SubmittedBy string `json:"submitted_by,omitempty"`
+	
+	// Set while the email is held
+	HoldReason string     `json:"hold_reason,omitempty"`
+	HeldAt     *time.Time `json:"held_at,omitempty"`
+	
 	CreatedAt   time.Time         `json:"created_at"`
 	UpdatedAt   time.Time         `json:"updated_at"`
 	ScheduledAt *time.Time        `json:"scheduled_at,omitempty"`
 	DeliveredAt *time.Time        `json:"delivered_at,omitempty"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"`
+}
+
+// Failure describes a failed delivery attempt. Code and Text are the SMTP
+// reply that decided it, or Code is 0 and Text the error when no server
+// replied, as with timeouts and DNS failures. Permanent failures, such as
+// 5xx replies and recipient domains that do not exist, are not retried.
+// Greylisted and RetryAfter, in seconds, shorten the wait before the next
+// attempt when the reply looks like greylisting or names a delay.
+type Failure struct {
+	Code       int    `json:"code,omitempty"`
+	Text       string `json:"text"`
+	Permanent  bool   `json:"permanent"`
+	Greylisted bool   `json:"greylisted,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// MaxAttempts is how many transactions an email's Attempts history keeps.
+const MaxAttempts = 20
+
+// Results of an Attempt.
+const (
+	AttemptDelivered    = "delivered"
+	AttemptSMTPError    = "smtp_error"
+	AttemptNetworkError = "network_error"
+)
+
+// Attempt is one SMTP transaction of a delivery attempt: when it started,
+// the MX host and address it went to, how long it took and whether the
+// connection used TLS. Result is AttemptDelivered, AttemptSMTPError with
+// Code and Text the server's reply, or AttemptNetworkError with Text the
+// error when no server replied. Number is the delivery attempt it was
+// part of, starting at 1. Transcript is the SMTP exchange, one line per
+// command or reply, for an email sent with Debug.
+type Attempt struct {
+	At         time.Time `json:"at"`
+	Number     int       `json:"attempt"`
+	Host       string    `json:"host"`
+	IP         string    `json:"ip,omitempty"`
+	Result     string    `json:"result"`
+	Code       int       `json:"code,omitempty"`
+	Text       string    `json:"text,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	TLS        bool      `json:"tls"`
+	Transcript string    `json:"transcript,omitempty"`
+}
+
+// Redirect is where delivery sent the mail of Recipients, the email's
+// own recipients outside the domains allowed through, in test mode.
+type Redirect struct {
+	To         string   `json:"to"`
+	Recipients []string `json:"recipients"`
 }
 
 type Attachment struct {
@@ -56,6 +273,14 @@ type Attachment struct {
 }
 
 func (e *Email) Validate(maxMessageSize int64) error {
+	if e.Lane != "" && e.Lane != LaneTransactional && e.Lane != LaneBulk {
+		return ErrInvalidLane
+	}
+	
+	if e.MaxRetry != nil && *e.MaxRetry < 0 {
+		return ErrInvalidMaxRetry
+	}
+	
 	if e.From == "" {
 		return ErrInvalidFrom
 	}
@@ -86,6 +311,14 @@ func (e *Email) Validate(maxMessageSize int64) error {
 		}
 	}
 	
+	// Raw messages carry their own content; only the envelope and size apply
+	if e.HasRaw() {
+		if e.Size() > maxMessageSize {
+			return ErrMessageTooLarge
+		}
+		return nil
+	}
+	
 	if strings.TrimSpace(e.Subject) == "" {
 		return ErrEmptySubject
 	}
@@ -94,22 +327,261 @@ func (e *Email) Validate(maxMessageSize int64) error {
 		return ErrEmptyBody
 	}
 	
+	if e.Size() > maxMessageSize {
+		return ErrMessageTooLarge
+	}
+	
+	return nil
+}
+
+// Size returns the content size checked against the maximum message size:
+// the raw message if set, otherwise the bodies plus attachment data.
+func (e *Email) Size() int64 {
+	if len(e.Raw) > 0 {
+		return int64(len(e.Raw))
+	}
+	if e.RawPath != "" {
+		// Spooled before RawSize was recorded
+		if e.RawSize == 0 {
+			if info, err := os.Stat(e.RawPath); err == nil {
+				return info.Size()
+			}
+		}
+		return e.RawSize
+	}
+	
 	size := int64(len(e.Body) + len(e.HTML))
 	for _, att := range e.Attachments {
 		size += int64(len(att.Data))
 	}
+	return size
+}
+
+// Reject moves the email to StatusRejected, recording the policy and
+// reason. Emails that have not been delivered yet can be rejected, up to
+// the moment a sending email's SMTP transaction starts.
+func (e *Email) Reject(policy, reason string) error {
+	if e.Status != StatusPending && e.Status != StatusQueued && e.Status != StatusQuarantined && e.Status != StatusHeld && e.Status != StatusSending {
+		return ErrInvalidTransition
+	}
 	
-	if size > maxMessageSize {
-		return ErrMessageTooLarge
+	e.Status = StatusRejected
+	e.RejectedBy = policy
+	e.RejectReason = reason
+	e.touch(time.Now())
+	return nil
+}
+
+// Quarantine parks a queued email for review, recording the reason.
+func (e *Email) Quarantine(reason string) error {
+	if e.Status != StatusQueued {
+		return ErrInvalidTransition
+	}
+	
+	now := time.Now()
+	e.Status = StatusQuarantined
+	e.QuarantineReason = reason
+	e.QuarantinedAt = &now
+	e.touch(now)
+	return nil
+}
+
+// ReleaseQuarantined returns a quarantined email to the queue. Deadlines
+// measured from the first attempt or submission are extended by the time
+// it was parked, so quarantine does not use up the retry window or queue
+// age.
+func (e *Email) ReleaseQuarantined() error {
+	if e.Status != StatusQuarantined {
+		return ErrInvalidTransition
+	}
+	
+	now := time.Now()
+	if e.QuarantinedAt != nil {
+		e.extendDeadlines(now.Sub(*e.QuarantinedAt))
 	}
 	
+	e.Status = StatusQueued
+	e.QuarantineReason = ""
+	e.QuarantinedAt = nil
+	e.touch(now)
 	return nil
 }
 
+// MarkHeld holds a queued email back until ReleaseHold, recording the
+// reason.
+func (e *Email) MarkHeld(reason string) error {
+	if e.Status != StatusQueued {
+		return ErrInvalidTransition
+	}
+	
+	now := time.Now()
+	e.Status = StatusHeld
+	e.HoldReason = reason
+	e.HeldAt = &now
+	e.touch(now)
+	return nil
+}
+
+// ReleaseHold returns a held email to the queue, extending its deadlines
+// by the time it was held as ReleaseQuarantined does.
+func (e *Email) ReleaseHold() error {
+	if e.Status != StatusHeld {
+		return ErrInvalidTransition
+	}
+	
+	now := time.Now()
+	if e.HeldAt != nil {
+		e.extendDeadlines(now.Sub(*e.HeldAt))
+	}
+	
+	e.Status = StatusQueued
+	e.HoldReason = ""
+	e.HeldAt = nil
+	e.touch(now)
+	return nil
+}
+
+// extendDeadlines moves the deadlines measured from the first attempt or
+// submission back by d, the time the email was parked.
+func (e *Email) extendDeadlines(d time.Duration) {
+	if e.FirstAttemptAt != nil {
+		firstAttempt := e.FirstAttemptAt.Add(d)
+		e.FirstAttemptAt = &firstAttempt
+	}
+	if e.ExpiresAt != nil {
+		expiresAt := e.ExpiresAt.Add(d)
+		e.ExpiresAt = &expiresAt
+	}
+}
+
+// HasRaw reports whether the email is sent as a pre-built message rather
+// than being built from its fields.
+func (e *Email) HasRaw() bool {
+	return len(e.Raw) > 0 || e.RawPath != ""
+}
+
+// OpenRaw returns a reader for the raw message, from disk if it was
+// spilled there. Only call it when HasRaw is true.
+func (e *Email) OpenRaw() (io.ReadCloser, error) {
+	if e.RawPath != "" {
+		return os.Open(e.RawPath)
+	}
+	return io.NopCloser(bytes.NewReader(e.Raw)), nil
+}
+
+// DeliveryLane returns the lane the email is delivered in.
+func (e *Email) DeliveryLane() Lane {
+	if e.Lane == "" {
+		return LaneTransactional
+	}
+	return e.Lane
+}
+
 func (e *Email) Recipients() []string {
 	recipients := make([]string, 0, len(e.To)+len(e.CC)+len(e.BCC))
 	recipients = append(recipients, e.To...)
 	recipients = append(recipients, e.CC...)
 	recipients = append(recipients, e.BCC...)
 	return recipients
-}
\ No newline at end of file
+}
+// Clone returns a copy of e that can be read and modified without
+// affecting the original. Raw and attachment data are shared, since message
+// content is never modified after submission.
+func (e *Email) Clone() *Email {
+	c := *e
+	c.To = cloneStrings(e.To)
+	c.CC = cloneStrings(e.CC)
+	c.BCC = cloneStrings(e.BCC)
+	if e.RecipientStatus != nil {
+		c.RecipientStatus = make(map[string]RecipientStatus, len(e.RecipientStatus))
+		for k, v := range e.RecipientStatus {
+			v.DeliveredAt = cloneTime(v.DeliveredAt)
+			c.RecipientStatus[k] = v
+		}
+	}
+	if e.Headers != nil {
+		c.Headers = make(map[string]string, len(e.Headers))
+		for k, v := range e.Headers {
+			c.Headers[k] = v
+		}
+	}
+	if e.Attachments != nil {
+		c.Attachments = append([]Attachment(nil), e.Attachments...)
+	}
+	c.FirstAttemptAt = cloneTime(e.FirstAttemptAt)
+	c.ScheduledAt = cloneTime(e.ScheduledAt)
+	if e.MaxRetry != nil {
+		n := *e.MaxRetry
+		c.MaxRetry = &n
+	}
+	c.DeliveredAt = cloneTime(e.DeliveredAt)
+	c.ExpiresAt = cloneTime(e.ExpiresAt)
+	c.QuarantinedAt = cloneTime(e.QuarantinedAt)
+	c.HeldAt = cloneTime(e.HeldAt)
+	c.SLADeadline = cloneTime(e.SLADeadline)
+	if e.LastFailure != nil {
+		f := *e.LastFailure
+		c.LastFailure = &f
+	}
+	if e.Attempts != nil {
+		c.Attempts = append([]Attempt(nil), e.Attempts...)
+	}
+	if e.Redirect != nil {
+		r := *e.Redirect
+		r.Recipients = cloneStrings(e.Redirect.Recipients)
+		c.Redirect = &r
+	}
+	return &c
+}
+
+// RecordAttempts adds attempts to the history, skipping any already in it,
+// and drops the oldest beyond MaxAttempts.
+func (e *Email) RecordAttempts(attempts []Attempt) {
+	for _, a := range attempts {
+		if !e.hasAttempt(a) {
+			e.Attempts = append(e.Attempts, a)
+		}
+	}
+	if len(e.Attempts) > MaxAttempts {
+		e.Attempts = append([]Attempt(nil), e.Attempts[len(e.Attempts)-MaxAttempts:]...)
+	}
+}
+
+func (e *Email) hasAttempt(a Attempt) bool {
+	for _, b := range e.Attempts {
+		if b.At.Equal(a.At) && b.Host == a.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordRedirect notes that the mail of rcpts went to the address to,
+// adding them to the recipients already redirected there.
+func (e *Email) RecordRedirect(to string, rcpts []string) {
+	r := &Redirect{To: to}
+	if e.Redirect != nil && e.Redirect.To == to {
+		r.Recipients = cloneStrings(e.Redirect.Recipients)
+	}
+	for _, rcpt := range rcpts {
+		if !slices.Contains(r.Recipients, rcpt) {
+			r.Recipients = append(r.Recipients, rcpt)
+		}
+	}
+	e.Redirect = r
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string(nil), s...)
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
@@ -120,6 +120,30 @@ func TestEmail_Validate(t *testing.T) {
 			maxMessageSize: 25 * 1024 * 1024,
 			wantErr:        nil,
 		},
+		{
+			name: "bulk lane",
+			email: &Email{
+				From:    "sender@example.com",
+				To:      []string{"recipient@example.com"},
+				Subject: "Test Subject",
+				Body:    "Test Body",
+				Lane:    LaneBulk,
+			},
+			maxMessageSize: 25 * 1024 * 1024,
+			wantErr:        nil,
+		},
+		{
+			name: "unknown lane",
+			email: &Email{
+				From:    "sender@example.com",
+				To:      []string{"recipient@example.com"},
+				Subject: "Test Subject",
+				Body:    "Test Body",
+				Lane:    "urgent",
+			},
+			maxMessageSize: 25 * 1024 * 1024,
+			wantErr:        ErrInvalidLane,
+		},
 	}
 	
 	for _, tt := range tests {
@@ -190,4 +214,162 @@ func TestEmailStatus(t *testing.T) {
 	if email.Status != StatusQueued {
 		t.Errorf("Expected status %s, got %s", StatusQueued, email.Status)
 	}
-}
\ No newline at end of file
+}
+func TestEmail_Reject(t *testing.T) {
+	tests := []struct {
+		status  Status
+		wantErr error
+	}{
+		{StatusPending, nil},
+		{StatusQueued, nil},
+		{StatusQuarantined, nil},
+		{StatusSending, nil},
+		{StatusDelivered, ErrInvalidTransition},
+		{StatusFailed, ErrInvalidTransition},
+	}
+	
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			e := &Email{Status: tt.status}
+			err := e.Reject("suppression-list", "recipient unsubscribed")
+			if err != tt.wantErr {
+				t.Fatalf("Reject() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if e.Status != tt.status {
+					t.Errorf("Status should be unchanged on invalid transition, got %s", e.Status)
+				}
+				return
+			}
+			if e.Status != StatusRejected || e.RejectedBy != "suppression-list" || e.RejectReason != "recipient unsubscribed" {
+				t.Errorf("Unexpected rejected email: %+v", e)
+			}
+		})
+	}
+}
+
+func TestEmail_QuarantineRelease(t *testing.T) {
+	firstAttempt := time.Now().Add(-time.Hour)
+	expiresAt := time.Now().Add(time.Hour)
+	e := &Email{Status: StatusSending}
+	if err := e.Quarantine("suspicious link"); err != ErrInvalidTransition {
+		t.Fatalf("Expected sending email to refuse quarantine, got %v", err)
+	}
+	
+	e = &Email{Status: StatusQueued, FirstAttemptAt: &firstAttempt, ExpiresAt: &expiresAt}
+	if err := e.Quarantine("suspicious link"); err != nil {
+		t.Fatalf("Quarantine() error = %v", err)
+	}
+	if e.Status != StatusQuarantined || e.QuarantineReason != "suspicious link" || e.QuarantinedAt == nil {
+		t.Fatalf("Unexpected quarantined email: %+v", e)
+	}
+	
+	// Pretend it was parked for ten minutes
+	parkedAt := e.QuarantinedAt.Add(-10 * time.Minute)
+	e.QuarantinedAt = &parkedAt
+	if err := e.ReleaseQuarantined(); err != nil {
+		t.Fatalf("ReleaseQuarantined() error = %v", err)
+	}
+	if e.Status != StatusQueued || e.QuarantineReason != "" || e.QuarantinedAt != nil {
+		t.Errorf("Unexpected released email: %+v", e)
+	}
+	if e.FirstAttemptAt.Sub(firstAttempt) < 10*time.Minute || e.ExpiresAt.Sub(expiresAt) < 10*time.Minute {
+		t.Error("Expected deadlines to be extended by the time parked")
+	}
+	if err := e.ReleaseQuarantined(); err != ErrInvalidTransition {
+		t.Errorf("Expected releasing a queued email to fail, got %v", err)
+	}
+}
+
+func TestEmail_HoldRelease(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	e := &Email{Status: StatusSending}
+	if err := e.MarkHeld("disputed"); err != ErrInvalidTransition {
+		t.Fatalf("Expected sending email to refuse a hold, got %v", err)
+	}
+	
+	e = &Email{Status: StatusQueued, ExpiresAt: &expiresAt}
+	if err := e.MarkHeld("disputed"); err != nil {
+		t.Fatalf("MarkHeld() error = %v", err)
+	}
+	if e.Status != StatusHeld || e.HoldReason != "disputed" || e.HeldAt == nil {
+		t.Fatalf("Unexpected held email: %+v", e)
+	}
+	
+	// Pretend it was held for a day
+	heldAt := e.HeldAt.Add(-24 * time.Hour)
+	e.HeldAt = &heldAt
+	if err := e.ReleaseHold(); err != nil {
+		t.Fatalf("ReleaseHold() error = %v", err)
+	}
+	if e.Status != StatusQueued || e.HoldReason != "" || e.HeldAt != nil {
+		t.Errorf("Unexpected released email: %+v", e)
+	}
+	if e.ExpiresAt.Sub(expiresAt) < 24*time.Hour {
+		t.Error("Expected the expiry extended by the time held")
+	}
+	if err := e.ReleaseHold(); err != ErrInvalidTransition {
+		t.Errorf("Expected releasing a queued email to fail, got %v", err)
+	}
+}
+
+func TestEmail_Clone(t *testing.T) {
+	scheduled := time.Now()
+	e := &Email{
+		ID:          "test-1",
+		To:          []string{"a@example.com"},
+		Headers:     map[string]string{"X-Test": "1"},
+		Attachments: []Attachment{{Filename: "a.txt"}},
+		ScheduledAt: &scheduled,
+		Status:      StatusQueued,
+	}
+	
+	c := e.Clone()
+	c.To[0] = "b@example.com"
+	c.Headers["X-Test"] = "2"
+	c.Attachments[0].Filename = "b.txt"
+	*c.ScheduledAt = scheduled.Add(time.Hour)
+	c.Status = StatusSending
+	
+	if e.To[0] != "a@example.com" || e.Headers["X-Test"] != "1" || e.Attachments[0].Filename != "a.txt" {
+		t.Errorf("Modifying the clone changed the original: %+v", e)
+	}
+	if !e.ScheduledAt.Equal(scheduled) || e.Status != StatusQueued {
+		t.Errorf("Modifying the clone changed the original: %+v", e)
+	}
+	if c.CC != nil || c.Raw != nil {
+		t.Error("Expected nil fields to stay nil")
+	}
+}
+
+func TestEmail_RecordAttempts(t *testing.T) {
+	start := time.Now()
+	attempt := func(i int) Attempt {
+		return Attempt{At: start.Add(time.Duration(i) * time.Second), Number: i + 1, Host: "mx.example.com", Result: AttemptNetworkError}
+	}
+	
+	e := &Email{}
+	e.RecordAttempts([]Attempt{attempt(0), attempt(1)})
+	e.RecordAttempts([]Attempt{attempt(1), attempt(2)})
+	if len(e.Attempts) != 3 || e.Attempts[2].Number != 3 {
+		t.Fatalf("Expected three attempts without the repeat, got %+v", e.Attempts)
+	}
+	
+	var more []Attempt
+	for i := 3; i < MaxAttempts+5; i++ {
+		more = append(more, attempt(i))
+	}
+	e.RecordAttempts(more)
+	if len(e.Attempts) != MaxAttempts {
+		t.Fatalf("Expected the history capped at %d, got %d", MaxAttempts, len(e.Attempts))
+	}
+	if e.Attempts[0].Number != 6 || e.Attempts[MaxAttempts-1].Number != MaxAttempts+5 {
+		t.Errorf("Expected the oldest attempts dropped, got %d to %d", e.Attempts[0].Number, e.Attempts[MaxAttempts-1].Number)
+	}
+	
+	c := e.Clone()
+	c.Attempts[0].Host = "other.example.com"
+	if e.Attempts[0].Host != "mx.example.com" {
+		t.Error("Modifying the clone's attempts changed the original")
+	}
+}
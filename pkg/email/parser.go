@@ -1,23 +1,24 @@
-package smtp
+package email
 
 import (
-	"bytes"
 	"io"
 	"net/mail"
 	"strings"
-	
-	"github.com/tpdoyle87/simple-email-server/pkg/email"
 )
 
-func parseEmail(from string, to []string, r io.Reader) (*email.Email, error) {
-	// Read the entire message
-	buf := new(bytes.Buffer)
-	if _, err := buf.ReadFrom(r); err != nil {
-		return nil, err
-	}
-	
+// Parse reads an RFC 5322 message from r and builds an Email using the
+// given envelope sender and recipients.
+func Parse(from string, to []string, r io.Reader) (*Email, error) {
+	return ParsePreview(from, to, r, 0)
+}
+
+// ParsePreview is Parse keeping at most bodyLimit bytes of the body, or
+// all of it when bodyLimit is not positive. The rest of r is still read,
+// so a message copied elsewhere as it is parsed arrives whole without
+// being held in memory.
+func ParsePreview(from string, to []string, r io.Reader, bodyLimit int64) (*Email, error) {
 	// Parse message
-	msg, err := mail.ReadMessage(bytes.NewReader(buf.Bytes()))
+	msg, err := mail.ReadMessage(r)
 	if err != nil {
 		return nil, err
 	}
@@ -31,13 +32,20 @@ func parseEmail(from string, to []string, r io.Reader) (*email.Email, error) {
 	}
 	
 	// Read body
-	body, err := io.ReadAll(msg.Body)
+	bodyReader := msg.Body
+	if bodyLimit > 0 {
+		bodyReader = io.LimitReader(msg.Body, bodyLimit)
+	}
+	body, err := io.ReadAll(bodyReader)
 	if err != nil {
 		return nil, err
 	}
+	if _, err := io.Copy(io.Discard, msg.Body); err != nil {
+		return nil, err
+	}
 	
 	// Create email object
-	e := &email.Email{
+	e := &Email{
 		From:    from,
 		To:      to,
 		Subject: headers["Subject"],
@@ -57,7 +65,13 @@ func parseEmail(from string, to []string, r io.Reader) (*email.Email, error) {
 	return e, nil
 }
 
+// parseAddressList extracts the addresses from a header value, falling
+// back to splitting on commas when the value is not valid address syntax.
 func parseAddressList(addresses string) []string {
+	if list, err := ParseAddressList(addresses); err == nil {
+		return list
+	}
+	
 	var result []string
 	for _, addr := range strings.Split(addresses, ",") {
 		trimmed := strings.TrimSpace(addr)
@@ -0,0 +1,72 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	msg := "Subject: Hello\r\n" +
+		"Cc: Carol <carol@example.com>, dave@example.com\r\n" +
+		"\r\n" +
+		"Message body"
+	
+	e, err := Parse("sender@example.com", []string{"recipient@example.com"}, strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	
+	if e.From != "sender@example.com" {
+		t.Errorf("Expected from sender@example.com, got %s", e.From)
+	}
+	if e.Subject != "Hello" {
+		t.Errorf("Expected subject Hello, got %s", e.Subject)
+	}
+	if e.Body != "Message body" {
+		t.Errorf("Expected body 'Message body', got %q", e.Body)
+	}
+	if len(e.CC) != 2 || e.CC[0] != "carol@example.com" || e.CC[1] != "dave@example.com" {
+		t.Errorf("Expected parsed CC addresses, got %v", e.CC)
+	}
+}
+
+func TestParsePreview(t *testing.T) {
+	msg := "Subject: Large\r\n" +
+		"\r\n" +
+		strings.Repeat("a", 10) + strings.Repeat("b", 1000)
+	
+	r := strings.NewReader(msg)
+	e, err := ParsePreview("sender@example.com", []string{"recipient@example.com"}, r, 10)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if e.Subject != "Large" {
+		t.Errorf("Expected subject Large, got %s", e.Subject)
+	}
+	if e.Body != strings.Repeat("a", 10) {
+		t.Errorf("Expected only the first 10 bytes of the body, got %q", e.Body)
+	}
+	if r.Len() != 0 {
+		t.Errorf("Expected the rest of the message to be read, %d bytes left", r.Len())
+	}
+}
+
+func TestParse_Groups(t *testing.T) {
+	msg := "To: undisclosed-recipients:;\r\n" +
+		"Cc: Team: alice@example.com, Bob <bob@example.com>;\r\n" +
+		"Subject: Hello\r\n" +
+		"\r\n" +
+		"Message body"
+	
+	e, err := Parse("sender@example.com", []string{"recipient@example.com"}, strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	
+	if len(e.CC) != 2 || e.CC[0] != "alice@example.com" || e.CC[1] != "bob@example.com" {
+		t.Errorf("Expected group members as CC addresses, got %v", e.CC)
+	}
+	if e.Headers["To"] != "undisclosed-recipients:;" {
+		t.Errorf("Expected original To header to be kept, got %q", e.Headers["To"])
+	}
+}
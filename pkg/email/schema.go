@@ -0,0 +1,220 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SchemaVersion is the version of the stored email format written by
+// Marshal. Bump it whenever a stored field is renamed, removed or changes
+// meaning, and add a migration from the previous version to migrations.
+const SchemaVersion = 2
+
+var (
+	ErrNewerSchema   = errors.New("email was stored by a newer schema version")
+	ErrInvalidRecord = errors.New("invalid stored email")
+)
+
+// record is the envelope a stored email is written in. Version 1 predates
+// the envelope and is a bare Email object.
+type record struct {
+	Version int             `json:"version"`
+	Email   json.RawMessage `json:"email"`
+	
+	// Files holds the data of attachments written outside the record, by
+	// index in the email's attachments
+	Files map[int]string `json:"attachment_files,omitempty"`
+}
+
+// migrations upgrade the fields of an email stored at a version to the
+// next one.
+var migrations = map[int]func(fields map[string]json.RawMessage) error{
+	1: migrateV1,
+}
+
+// Codec reads and writes emails in the stored format. With an
+// AttachmentDir, attachments larger than InlineLimit bytes are written to
+// files there and referenced from the record instead of being inlined as
+// base64, which is a third larger than the data and has to be decoded in
+// memory at once. The files are not removed when the email is read back.
+type Codec struct {
+	AttachmentDir string
+	InlineLimit   int
+}
+
+// Marshal encodes e in the current stored format, with its attachments
+// inline.
+func Marshal(e *Email) ([]byte, error) {
+	return Codec{}.Marshal(e)
+}
+
+// Unmarshal decodes an email stored by any released version, migrating it
+// to the current schema.
+func Unmarshal(data []byte) (*Email, error) {
+	return Codec{}.Unmarshal(data)
+}
+
+// Marshal encodes e in the current stored format.
+func (c Codec) Marshal(e *Email) ([]byte, error) {
+	rec := record{Version: SchemaVersion}
+	
+	stored := *e
+	if c.AttachmentDir != "" {
+		stored.Attachments = make([]Attachment, len(e.Attachments))
+		for i, a := range e.Attachments {
+			if len(a.Data) > c.InlineLimit {
+				path, err := writeAttachment(c.AttachmentDir, a.Data)
+				if err != nil {
+					return nil, err
+				}
+				if rec.Files == nil {
+					rec.Files = make(map[int]string)
+				}
+				rec.Files[i] = path
+				a.Data = nil
+			}
+			stored.Attachments[i] = a
+		}
+	}
+	
+	var err error
+	if rec.Email, err = json.Marshal(&stored); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&rec)
+}
+
+// Unmarshal decodes an email stored by any released version. Fields the
+// current schema does not know are an error rather than being dropped.
+func (c Codec) Unmarshal(data []byte) (*Email, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	
+	rec := record{Version: 1, Email: data}
+	if _, ok := top["version"]; ok {
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, err
+		}
+	}
+	if rec.Version > SchemaVersion {
+		return nil, fmt.Errorf("%w: %d", ErrNewerSchema, rec.Version)
+	}
+	if rec.Version < 1 {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidRecord, rec.Version)
+	}
+	
+	if rec.Version < SchemaVersion {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Email, &fields); err != nil {
+			return nil, err
+		}
+		for v := rec.Version; v < SchemaVersion; v++ {
+			if err := migrations[v](fields); err != nil {
+				return nil, fmt.Errorf("migrating from version %d: %w", v, err)
+			}
+		}
+		var err error
+		if rec.Email, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+	
+	var e Email
+	dec := json.NewDecoder(bytes.NewReader(rec.Email))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&e); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	
+	for i, path := range rec.Files {
+		if i < 0 || i >= len(e.Attachments) {
+			return nil, fmt.Errorf("%w: attachment file for missing attachment %d", ErrInvalidRecord, i)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		e.Attachments[i].Data = data
+	}
+	return &e, nil
+}
+
+func writeAttachment(dir string, data []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "attachment-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// migrateV1 replaces the delivered_domains list, which recorded the
+// recipient domains that had accepted the email, with a delivered entry in
+// recipient_status for each recipient in those domains.
+func migrateV1(fields map[string]json.RawMessage) error {
+	raw, ok := fields["delivered_domains"]
+	if !ok {
+		return nil
+	}
+	delete(fields, "delivered_domains")
+	
+	var domains []string
+	if err := json.Unmarshal(raw, &domains); err != nil {
+		return err
+	}
+	if len(domains) == 0 {
+		return nil
+	}
+	delivered := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		delivered[strings.ToLower(d)] = true
+	}
+	
+	statuses := make(map[string]RecipientStatus)
+	if raw, ok := fields["recipient_status"]; ok {
+		if err := json.Unmarshal(raw, &statuses); err != nil {
+			return err
+		}
+	}
+	for _, key := range []string{"to", "cc", "bcc"} {
+		var rcpts []string
+		if raw, ok := fields[key]; ok {
+			if err := json.Unmarshal(raw, &rcpts); err != nil {
+				return err
+			}
+		}
+		for _, rcpt := range rcpts {
+			at := strings.LastIndex(rcpt, "@")
+			if at < 0 || !delivered[strings.ToLower(rcpt[at+1:])] {
+				continue
+			}
+			if _, ok := statuses[rcpt]; !ok {
+				statuses[rcpt] = RecipientStatus{Status: StatusDelivered}
+			}
+		}
+	}
+	
+	raw, err := json.Marshal(statuses)
+	if err != nil {
+		return err
+	}
+	fields["recipient_status"] = raw
+	return nil
+}
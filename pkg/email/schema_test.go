@@ -0,0 +1,130 @@
+package email
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Every file in testdata/stored is an email as written by a released
+// schema version and must keep loading. Add a fixture whenever
+// SchemaVersion is bumped.
+func TestUnmarshal_StoredFixtures(t *testing.T) {
+	want := map[string]func(t *testing.T, e *Email){
+		"v1.json": func(t *testing.T, e *Email) {
+			if e.ID != "v1-basic" || e.HTML != "<p>Plain body</p>" || e.RetryCount != 2 {
+				t.Errorf("Unexpected email %+v", e)
+			}
+			if len(e.Attachments) != 1 || string(e.Attachments[0].Data) != "hello world" {
+				t.Errorf("Expected attachment data to survive, got %+v", e.Attachments)
+			}
+			if e.Headers["X-Campaign"] != "spring" {
+				t.Errorf("Expected headers to survive, got %v", e.Headers)
+			}
+		},
+		"v1-delivered-domains.json": func(t *testing.T, e *Email) {
+			for _, rcpt := range []string{"alice@example.com", "carol@Example.com"} {
+				if e.RecipientStatus[rcpt].Status != StatusDelivered {
+					t.Errorf("Expected %s delivered, got %+v", rcpt, e.RecipientStatus)
+				}
+			}
+			if _, ok := e.RecipientStatus["dave@example.net"]; ok {
+				t.Error("Expected recipient outside delivered domains to be left pending")
+			}
+		},
+		"v2.json": func(t *testing.T, e *Email) {
+			if e.ID != "v2-basic" || string(e.Attachments[0].Data) != "hello world" {
+				t.Errorf("Unexpected email %+v", e)
+			}
+			alice := e.RecipientStatus["alice@example.com"]
+			if alice.Status != StatusDelivered || alice.DeliveredAt == nil {
+				t.Errorf("Expected alice delivered, got %+v", alice)
+			}
+			if e.RecipientStatus["bob@example.net"].LastError != "451 try later" {
+				t.Errorf("Expected bob's last error, got %+v", e.RecipientStatus)
+			}
+		},
+	}
+	
+	files, err := filepath.Glob(filepath.Join("testdata", "stored", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(want) {
+		t.Errorf("Expected %d fixtures, found %d", len(want), len(files))
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Failed to load: %v", err)
+			}
+			check, ok := want[name]
+			if !ok {
+				t.Fatalf("No expectations for fixture %s", name)
+			}
+			check(t, e)
+			
+			// Loading again after a round trip gives the same email
+			again, err := Marshal(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err = Unmarshal(again)
+			if err != nil {
+				t.Fatalf("Failed to reload: %v", err)
+			}
+			check(t, e)
+		})
+	}
+}
+
+func TestUnmarshal_Rejects(t *testing.T) {
+	if _, err := Unmarshal([]byte(`{"version":99,"email":{"id":"x"}}`)); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("Expected ErrNewerSchema, got %v", err)
+	}
+	if _, err := Unmarshal([]byte(`{"version":2,"email":{"id":"x","renamed_field":1}}`)); !errors.Is(err, ErrInvalidRecord) {
+		t.Errorf("Expected unknown field to be an error, got %v", err)
+	}
+}
+
+func TestCodec_AttachmentFiles(t *testing.T) {
+	dir := t.TempDir()
+	codec := Codec{AttachmentDir: dir, InlineLimit: 16}
+	e := &Email{
+		ID: "big",
+		Attachments: []Attachment{
+			{Filename: "small.txt", Data: []byte("tiny")},
+			{Filename: "large.txt", Data: []byte(strings.Repeat("x", 1000))},
+		},
+	}
+	
+	data, err := codec.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 500 {
+		t.Errorf("Expected large attachment to be stored outside the record, got %d bytes", len(data))
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected 1 attachment file, got %d", len(files))
+	}
+	if e.Attachments[1].Data == nil {
+		t.Error("Expected Marshal to leave the email unchanged")
+	}
+	
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Attachments[0].Data) != "tiny" || len(got.Attachments[1].Data) != 1000 {
+		t.Errorf("Expected attachments restored, got %+v", got.Attachments)
+	}
+}
@@ -0,0 +1,121 @@
+package email
+
+import "time"
+
+// The delivery state of an email — Status, RetryCount, LastError,
+// ScheduledAt, UpdatedAt and DeliveredAt — is only changed through the
+// methods below, outside this package, so that its invariants hold in one
+// place: every change bumps UpdatedAt, RetryCount never goes down and
+// DeliveredAt is set once. An email has one owner at a time, normally the
+// queue, which holds its own lock around these calls; everyone else works
+// on a Clone. TestStateWrites enforces this.
+
+// touch records a change at now.
+func (e *Email) touch(now time.Time) {
+	e.UpdatedAt = now
+}
+
+// Touch records a change to a field with no method of its own, such as
+// RecipientStatus.
+func (e *Email) Touch(now time.Time) {
+	e.touch(now)
+}
+
+// MarkQueued makes a new or restored email ready to queue.
+func (e *Email) MarkQueued(now time.Time) {
+	e.Status = StatusQueued
+	e.touch(now)
+}
+
+// MarkSending moves the email to StatusSending for a delivery attempt,
+// recording its first attempt and clearing why it was held.
+func (e *Email) MarkSending(now time.Time) {
+	e.Status = StatusSending
+	e.DeferredReason = ""
+	if e.FirstAttemptAt == nil {
+		firstAttempt := now
+		e.FirstAttemptAt = &firstAttempt
+	}
+	e.touch(now)
+}
+
+// MarkDelivered moves the email to StatusDelivered. DeliveredAt keeps the
+// time it was first delivered.
+func (e *Email) MarkDelivered(now time.Time) {
+	e.Status = StatusDelivered
+	if e.DeliveredAt == nil {
+		deliveredAt := now
+		e.DeliveredAt = &deliveredAt
+	}
+	e.touch(now)
+}
+
+// ScheduleRetry counts a failed attempt and queues the email again, due
+// at at.
+func (e *Email) ScheduleRetry(at time.Time, reason string) {
+	e.Status = StatusQueued
+	e.LastError = reason
+	e.RetryCount++
+	e.ScheduledAt = &at
+	e.touch(time.Now())
+}
+
+// Defer queues the email again, due at at, without counting a retry.
+func (e *Email) Defer(at time.Time, reason string) {
+	e.Status = StatusQueued
+	e.LastError = reason
+	e.DeferCount++
+	e.ScheduledAt = &at
+	e.touch(time.Now())
+}
+
+// Postpone queues the email again, due at at, recording nothing against
+// it but reason as its DeferredReason.
+func (e *Email) Postpone(at time.Time, reason string) {
+	e.Status = StatusQueued
+	e.DeferredReason = reason
+	e.ScheduledAt = &at
+	e.touch(time.Now())
+}
+
+// MarkFailed fails the email for good, or bounces it if bounced is set.
+func (e *Email) MarkFailed(reason string, bounced bool) {
+	e.Status = StatusFailed
+	if bounced {
+		e.Status = StatusBounced
+	}
+	e.LastError = reason
+	e.touch(time.Now())
+}
+
+// SetError records the latest error without changing the status.
+func (e *Email) SetError(reason string) {
+	e.LastError = reason
+	e.touch(time.Now())
+}
+
+// Mirror brings a copy of an email tracked outside its owner, such as
+// the API's record of an email that has left the queue, up to date with
+// the outcome of an attempt reported at at, and reports whether it did.
+// No transition is checked, since the owner already made it, but reports
+// can arrive out of order: one that would take a finished email back is
+// ignored, as is a lower retry count than the copy's.
+func (e *Email) Mirror(status Status, at time.Time, retryCount int, lastError string) bool {
+	if e.Status.Final() && !status.Final() {
+		return false
+	}
+	
+	if status == StatusDelivered && e.DeliveredAt == nil {
+		deliveredAt := at
+		e.DeliveredAt = &deliveredAt
+	}
+	e.Status = status
+	if lastError != "" {
+		e.LastError = lastError
+	}
+	if retryCount > e.RetryCount {
+		e.RetryCount = retryCount
+	}
+	e.touch(at)
+	return true
+}
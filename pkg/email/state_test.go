@@ -0,0 +1,207 @@
+package email
+
+import (
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmail_StateTransitions(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	e := &Email{Status: StatusPending}
+	
+	e.MarkQueued(start)
+	if e.Status != StatusQueued || !e.UpdatedAt.Equal(start) {
+		t.Fatalf("Expected queued at %v, got %s at %v", start, e.Status, e.UpdatedAt)
+	}
+	
+	e.DeferredReason = "domain_paused"
+	e.MarkSending(start.Add(time.Minute))
+	if e.Status != StatusSending || e.DeferredReason != "" || e.FirstAttemptAt == nil || !e.FirstAttemptAt.Equal(start.Add(time.Minute)) {
+		t.Fatalf("Expected sending with its first attempt recorded, got %+v", e)
+	}
+	
+	retryAt := time.Now().Add(time.Minute)
+	e.ScheduleRetry(retryAt, "451 try again later")
+	if e.Status != StatusQueued || e.RetryCount != 1 || e.LastError != "451 try again later" || !e.ScheduledAt.Equal(retryAt) {
+		t.Fatalf("Expected a retry scheduled, got %+v", e)
+	}
+	if !e.UpdatedAt.After(start.Add(time.Minute)) {
+		t.Errorf("Expected UpdatedAt bumped by the retry, got %v", e.UpdatedAt)
+	}
+	
+	// A later attempt keeps the first attempt's time
+	e.MarkSending(start.Add(2 * time.Minute))
+	if !e.FirstAttemptAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected the first attempt kept, got %v", e.FirstAttemptAt)
+	}
+	
+	e.Defer(retryAt, "421 too busy")
+	if e.RetryCount != 1 || e.DeferCount != 1 || e.LastError != "421 too busy" {
+		t.Errorf("Expected a deferral without a retry, got %+v", e)
+	}
+	e.Postpone(retryAt, "domain_paused")
+	if e.DeferCount != 1 || e.DeferredReason != "domain_paused" || e.LastError != "421 too busy" {
+		t.Errorf("Expected a postponement recording only its reason, got %+v", e)
+	}
+	
+	deliveredAt := time.Now()
+	e.MarkDelivered(deliveredAt)
+	e.MarkDelivered(deliveredAt.Add(time.Hour))
+	if e.Status != StatusDelivered || !e.DeliveredAt.Equal(deliveredAt) || !e.UpdatedAt.Equal(deliveredAt.Add(time.Hour)) {
+		t.Errorf("Expected DeliveredAt set once and UpdatedAt bumped, got %v, %v", e.DeliveredAt, e.UpdatedAt)
+	}
+	
+	e.MarkFailed("550 no such user", true)
+	if e.Status != StatusBounced || e.LastError != "550 no such user" {
+		t.Errorf("Expected bounced, got %s %q", e.Status, e.LastError)
+	}
+}
+
+func TestEmail_Mirror(t *testing.T) {
+	at := time.Now()
+	e := &Email{Status: StatusSending, RetryCount: 3}
+	
+	// A lower count reported for the attempt does not undo retries
+	if !e.Mirror(StatusQueued, at, 2, "451 try again later") {
+		t.Fatal("Expected the retry mirrored")
+	}
+	if e.Status != StatusQueued || e.RetryCount != 3 || e.LastError != "451 try again later" || !e.UpdatedAt.Equal(at) {
+		t.Errorf("Expected queued with 3 retries at %v, got %+v", at, e)
+	}
+	e.Mirror(StatusDelivered, at, 4, "")
+	e.Mirror(StatusDelivered, at.Add(time.Minute), 4, "")
+	if e.Status != StatusDelivered || e.RetryCount != 4 || !e.DeliveredAt.Equal(at) || e.LastError != "451 try again later" {
+		t.Errorf("Expected delivered once at %v, got %+v", at, e)
+	}
+	
+	// A retry reported late does not undo delivery
+	if e.Mirror(StatusQueued, at, 5, "421 too busy") || e.Status != StatusDelivered || e.RetryCount != 4 {
+		t.Errorf("Expected a late retry ignored, got %+v", e)
+	}
+}
+
+// stateFields are the fields of Email only its methods may change.
+var stateFields = []string{"Status", "RetryCount", "LastError", "ScheduledAt", "UpdatedAt", "DeliveredAt"}
+
+// TestStateWrites type-checks every package of the module outside this
+// one, tests excluded, and fails on any assignment to a state field of an
+// Email, which must go through the methods in state.go instead.
+func TestStateWrites(t *testing.T) {
+	if testing.Short() {
+		t.Skip("type-checks the whole module")
+	}
+	
+	pkgPath := reflect.TypeOf(Email{}).PkgPath()
+	modulePath := strings.TrimSuffix(pkgPath, "/pkg/email")
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	fset := token.NewFileSet()
+	imp := importer.ForCompiler(fset, "source", nil)
+	emailPkg, err := imp.Import(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[types.Object]bool)
+	st := emailPkg.Scope().Lookup("Email").Type().Underlying().(*types.Struct)
+	for i := 0; i < st.NumFields(); i++ {
+		for _, name := range stateFields {
+			if st.Field(i).Name() == name {
+				fields[st.Field(i)] = true
+			}
+		}
+	}
+	if len(fields) != len(stateFields) {
+		t.Fatalf("Expected Email to have fields %v", stateFields)
+	}
+	
+	checked := 0
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		name := d.Name()
+		if path != root && (strings.HasPrefix(name, ".") || name == "testdata" || name == "vendor") {
+			return filepath.SkipDir
+		}
+		rel, _ := filepath.Rel(root, path)
+		importPath := modulePath
+		if rel != "." {
+			importPath += "/" + filepath.ToSlash(rel)
+		}
+		if importPath == pkgPath {
+			return nil
+		}
+		
+		bp, err := build.ImportDir(path, 0)
+		if err != nil {
+			// No Go files here
+			return nil
+		}
+		var files []*ast.File
+		for _, name := range bp.GoFiles {
+			f, err := parser.ParseFile(fset, filepath.Join(path, name), nil, 0)
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+		}
+		
+		info := &types.Info{Selections: make(map[*ast.SelectorExpr]*types.Selection)}
+		conf := types.Config{Importer: imp.(types.ImporterFrom)}
+		if _, err := conf.Check(importPath, fset, files, info); err != nil {
+			return err
+		}
+		checked++
+		
+		written := func(expr ast.Expr) {
+			for {
+				switch x := expr.(type) {
+				case *ast.ParenExpr:
+					expr = x.X
+					continue
+				case *ast.StarExpr:
+					expr = x.X
+					continue
+				case *ast.SelectorExpr:
+					if sel, ok := info.Selections[x]; ok && fields[sel.Obj()] {
+						t.Errorf("%s: %s written directly, use the Email methods in pkg/email/state.go", fset.Position(x.Sel.Pos()), sel.Obj().Name())
+					}
+				}
+				return
+			}
+		}
+		for _, f := range files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.AssignStmt:
+					for _, lhs := range n.Lhs {
+						written(lhs)
+					}
+				case *ast.IncDecStmt:
+					written(n.X)
+				}
+				return true
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked == 0 {
+		t.Fatal("Expected to check the module's packages")
+	}
+}
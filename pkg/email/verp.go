@@ -0,0 +1,102 @@
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VERP (variable envelope return path) puts an email's ID in its envelope
+// sender, as in bounce+<id>.<signature>@bounces.example.com, so a bounce
+// sent back to that address says which email it is about. Characters of
+// the ID other than letters, digits, '-' and '_' are escaped as '=' and two
+// hex digits, keeping the local part valid whatever the ID. The signature
+// is an HMAC of the ID under a secret key, so a forged bounce cannot name
+// an email it was never about.
+
+// verpSignatureLen is how many hex digits of the HMAC are kept
+const verpSignatureLen = 16
+
+// EncodeVERP returns the envelope sender for the email id: localPart, '+'
+// and the token VERPToken makes of id under secret, at domain.
+func EncodeVERP(localPart, id, domain, secret string) string {
+	return localPart + "+" + VERPToken(id, secret) + "@" + domain
+}
+
+// DecodeVERP returns the email ID encoded in address by EncodeVERP with
+// localPart and secret, and false if address is not one or its signature
+// does not match. Any domain is accepted.
+func DecodeVERP(address, localPart, secret string) (string, bool) {
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return "", false
+	}
+	prefix := localPart + "+"
+	local := address[:at]
+	if len(local) <= len(prefix) || !strings.EqualFold(local[:len(prefix)], prefix) {
+		return "", false
+	}
+	return ParseVERPToken(local[len(prefix):], secret)
+}
+
+// VERPToken returns id escaped for a local part, a '.' and its signature
+// under secret.
+func VERPToken(id, secret string) string {
+	var b strings.Builder
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "=%02X", c)
+		}
+	}
+	b.WriteByte('.')
+	b.WriteString(verpSignature(id, secret))
+	return b.String()
+}
+
+// ParseVERPToken returns the email ID in a token made by VERPToken, and
+// false if token is not one or was not signed with secret.
+func ParseVERPToken(token, secret string) (string, bool) {
+	dot := strings.LastIndexByte(token, '.')
+	if dot <= 0 {
+		return "", false
+	}
+	encoded, signature := token[:dot], token[dot+1:]
+	
+	var b strings.Builder
+	for i := 0; i < len(encoded); i++ {
+		if encoded[i] != '=' {
+			b.WriteByte(encoded[i])
+			continue
+		}
+		if i+2 >= len(encoded) {
+			return "", false
+		}
+		c, err := strconv.ParseUint(encoded[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", false
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	
+	// Some servers change the case of the local part
+	id := b.String()
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(verpSignature(id, secret))) {
+		return "", false
+	}
+	return id, true
+}
+
+// verpSignature returns the start of the HMAC-SHA256 of id under secret,
+// in lower case hex.
+func verpSignature(id, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))[:verpSignatureLen]
+}
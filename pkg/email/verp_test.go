@@ -0,0 +1,59 @@
+package email
+
+import "testing"
+
+func TestVERP_RoundTrip(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{id: "3f2b8c1e-9d4a-4e6b-8f1a-2c3d4e5f6a7b", want: "bounce+3f2b8c1e-9d4a-4e6b-8f1a-2c3d4e5f6a7b."},
+		{id: "order_42", want: "bounce+order_42."},
+		{id: "a+b@c.d", want: "bounce+a=2Bb=40c=2Ed."},
+		{id: "x=y z", want: "bounce+x=3Dy=20z."},
+		{id: "héllo", want: "bounce+h=C3=A9llo."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			got := EncodeVERP("bounce", tt.id, "bounces.example.com", "secret")
+			want := tt.want + verpSignature(tt.id, "secret") + "@bounces.example.com"
+			if got != want {
+				t.Errorf("EncodeVERP(%q) = %s, want %s", tt.id, got, want)
+			}
+			id, ok := DecodeVERP(got, "bounce", "secret")
+			if !ok || id != tt.id {
+				t.Errorf("DecodeVERP(%s) = %q, %v, want %q", got, id, ok, tt.id)
+			}
+		})
+	}
+}
+
+func TestDecodeVERP_Invalid(t *testing.T) {
+	signed := EncodeVERP("bounce", "abc", "bounces.example.com", "secret")
+	for _, address := range []string{
+		"bounce@bounces.example.com",
+		"bounce+@bounces.example.com",
+		"other+abc." + verpSignature("abc", "secret") + "@bounces.example.com",
+		"bounce+abc",
+		"bounce+abc=4." + verpSignature("abc", "secret") + "@bounces.example.com",
+		"bounce+abc=ZZ." + verpSignature("abc", "secret") + "@bounces.example.com",
+		
+		// Forged: unsigned, signed for another ID, or with another key
+		"bounce+abc@bounces.example.com",
+		"bounce+abd." + verpSignature("abc", "secret") + "@bounces.example.com",
+		EncodeVERP("bounce", "abc", "bounces.example.com", "other"),
+	} {
+		if id, ok := DecodeVERP(address, "bounce", "secret"); ok {
+			t.Errorf("DecodeVERP(%s) = %q, want no ID", address, id)
+		}
+	}
+	
+	// Some servers change the case of the local part
+	upper := "BOUNCE+abc." + verpSignature("abc", "secret") + "@bounces.example.com"
+	if id, ok := DecodeVERP(upper, "bounce", "secret"); !ok || id != "abc" {
+		t.Errorf("Expected a case-insensitive prefix, got %q, %v", id, ok)
+	}
+	if _, ok := DecodeVERP(signed, "bounce", "secret"); !ok {
+		t.Errorf("Expected %s to verify", signed)
+	}
+}
@@ -0,0 +1,241 @@
+package traffic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/client"
+)
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	// Speed scales the recording's pace: 1 replays it in real time, 2
+	// twice as fast. Zero means 1.
+	Speed float64
+	
+	// From is the sender of every replayed email.
+	From string
+	
+	// RecipientDomain receives all replayed mail. Each recorded domain
+	// becomes a subdomain of it, so gmail.com maps to
+	// gmail-com.<RecipientDomain> and per-domain behaviour is kept; give
+	// it a wildcard MX pointing at the Sink.
+	RecipientDomain string
+	
+	// Sink, if set, is waited on for the accepted emails to arrive, for up
+	// to DeliveryTimeout after the last submission (default: 1m).
+	Sink            *Sink
+	DeliveryTimeout time.Duration
+}
+
+// Report summarizes a replay.
+type Report struct {
+	Submitted int `json:"submitted"`
+	Accepted  int `json:"accepted"`
+	
+	// Submissions the server answered with an error status, by status
+	Rejected         int         `json:"rejected"`
+	RejectedByStatus map[int]int `json:"rejected_by_status,omitempty"`
+	RejectionRate    float64     `json:"rejection_rate"`
+	
+	// Submissions that got no answer at all
+	Errors int `json:"errors"`
+	
+	Acceptance Latency `json:"acceptance_latency"`
+	
+	// Accepted emails that reached the sink, and how long they took from
+	// submission; zero without a sink
+	Delivered   int     `json:"delivered"`
+	Undelivered int     `json:"undelivered"`
+	Delivery    Latency `json:"delivery_latency"`
+}
+
+// Latency is a latency distribution.
+type Latency struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "submitted %d, accepted %d, rejected %d (%.1f%%), errors %d\n",
+		r.Submitted, r.Accepted, r.Rejected, 100*r.RejectionRate, r.Errors)
+	fmt.Fprintf(&b, "acceptance latency p50 %s, p90 %s, p99 %s, max %s\n",
+		r.Acceptance.P50, r.Acceptance.P90, r.Acceptance.P99, r.Acceptance.Max)
+	if r.Delivered+r.Undelivered > 0 {
+		fmt.Fprintf(&b, "delivered %d, undelivered %d, delivery latency p50 %s, p90 %s, p99 %s, max %s\n",
+			r.Delivered, r.Undelivered, r.Delivery.P50, r.Delivery.P90, r.Delivery.P99, r.Delivery.Max)
+	}
+	return b.String()
+}
+
+// submission is the outcome of replaying one event.
+type submission struct {
+	id       string
+	at       time.Time
+	latency  time.Duration
+	accepted bool
+	status   int
+}
+
+// Replay submits events to c at their recorded pace, scaled by
+// opts.Speed, with synthetic content of the recorded sizes. It returns
+// once every submission has been answered and, with a sink, every accepted
+// email has arrived or the delivery timeout has passed. Cancelling ctx
+// stops submitting.
+func Replay(ctx context.Context, c *client.Client, events []Event, opts ReplayOptions) (*Report, error) {
+	if opts.From == "" || opts.RecipientDomain == "" {
+		return nil, errors.New("replay needs a sender and a recipient domain")
+	}
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	
+	results := make([]submission, len(events))
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, ev := range events {
+		due := start.Add(time.Duration(float64(ev.Time.Sub(events[0].Time)) / speed))
+		if wait := time.Until(due); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				wg.Wait()
+				return report(results[:i], opts), ctx.Err()
+			}
+		}
+		
+		wg.Add(1)
+		go func(i int, ev Event) {
+			defer wg.Done()
+			results[i] = submit(c, i, ev, opts)
+		}(i, ev)
+	}
+	wg.Wait()
+	
+	if opts.Sink != nil {
+		awaitDeliveries(ctx, opts, results)
+	}
+	return report(results, opts), nil
+}
+
+func submit(c *client.Client, i int, ev Event, opts ReplayOptions) submission {
+	sub := submission{id: fmt.Sprintf("replay-%d-%d", time.Now().UnixNano(), i), at: time.Now()}
+	e := &client.Email{
+		From:           opts.From,
+		Subject:        "Replayed email " + sub.id,
+		Body:           synthetic(ev.Size),
+		Headers:        map[string]string{ReplayHeader: sub.id},
+		Priority:       ev.Priority,
+		Lane:           ev.Lane,
+		AllowDuplicate: true,
+	}
+	for j, domain := range ev.Domains {
+		mapped := strings.ReplaceAll(domain, ".", "-") + "." + opts.RecipientDomain
+		e.To = append(e.To, fmt.Sprintf("rcpt%d@%s", j, mapped))
+	}
+	
+	_, err := c.Send(e)
+	sub.latency = time.Since(sub.at)
+	var statusErr *client.StatusError
+	switch {
+	case err == nil:
+		sub.accepted = true
+	case errors.As(err, &statusErr):
+		sub.status = statusErr.Code
+	}
+	return sub
+}
+
+// synthetic returns a body of about size bytes in lines of 76.
+func synthetic(size int64) string {
+	line := strings.Repeat("x", 76) + "\r\n"
+	var b strings.Builder
+	for int64(b.Len()) < size {
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// awaitDeliveries waits until every accepted email has reached the sink or
+// the delivery timeout passes.
+func awaitDeliveries(ctx context.Context, opts ReplayOptions, results []submission) {
+	timeout := opts.DeliveryTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	deadline := time.After(timeout)
+	for {
+		done := true
+		for _, sub := range results {
+			if _, ok := opts.Sink.Arrival(sub.id); sub.accepted && !ok {
+				done = false
+				break
+			}
+		}
+		if done {
+			return
+		}
+		select {
+		case <-opts.Sink.arrived:
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func report(results []submission, opts ReplayOptions) *Report {
+	r := &Report{Submitted: len(results)}
+	var acceptance, delivery []time.Duration
+	for _, sub := range results {
+		acceptance = append(acceptance, sub.latency)
+		switch {
+		case sub.accepted:
+			r.Accepted++
+		case sub.status != 0:
+			r.Rejected++
+			if r.RejectedByStatus == nil {
+				r.RejectedByStatus = make(map[int]int)
+			}
+			r.RejectedByStatus[sub.status]++
+		default:
+			r.Errors++
+		}
+		
+		if opts.Sink == nil || !sub.accepted {
+			continue
+		}
+		if at, ok := opts.Sink.Arrival(sub.id); ok {
+			r.Delivered++
+			delivery = append(delivery, at.Sub(sub.at))
+		} else {
+			r.Undelivered++
+		}
+	}
+	if r.Submitted > 0 {
+		r.RejectionRate = float64(r.Rejected) / float64(r.Submitted)
+	}
+	r.Acceptance = distribution(acceptance)
+	r.Delivery = distribution(delivery)
+	return r
+}
+
+func distribution(d []time.Duration) Latency {
+	if len(d) == 0 {
+		return Latency{}
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	at := func(p int) time.Duration { return d[(len(d)-1)*p/100] }
+	return Latency{P50: at(50), P90: at(90), P99: at(99), Max: d[len(d)-1]}
+}
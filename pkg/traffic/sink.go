@@ -0,0 +1,144 @@
+package traffic
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayHeader carries the ID Replay gives each email, so the sink can
+// tell when it arrives.
+const ReplayHeader = "X-Replay-ID"
+
+// Sink is an SMTP server that accepts every message and notes when each
+// replayed email arrived. Point the recipient domain's MX at it to
+// measure end-to-end delivery latency.
+type Sink struct {
+	ln net.Listener
+	wg sync.WaitGroup
+	
+	mu       sync.Mutex
+	arrivals map[string]time.Time
+	arrived  chan struct{}
+}
+
+// NewSink listens for SMTP on addr, such as ":25".
+func NewSink(addr string) (*Sink, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sink{
+		ln:       ln,
+		arrivals: make(map[string]time.Time),
+		arrived:  make(chan struct{}, 1),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address the sink is listening on.
+func (s *Sink) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the sink and waits for open sessions to end.
+func (s *Sink) Close() error {
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Arrival returns when the email with the given replay ID arrived.
+func (s *Sink) Arrival(id string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at, ok := s.arrivals[id]
+	return at, ok
+}
+
+func (s *Sink) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.session(conn)
+		}()
+	}
+}
+
+func (s *Sink) session(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	reply := func(code int, text string) error {
+		conn.SetDeadline(time.Now().Add(5 * time.Minute))
+		return tp.PrintfLine("%d %s", code, text)
+	}
+	
+	if reply(220, "replay sink ESMTP") != nil {
+		return
+	}
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, _, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			err = reply(250, "sink")
+		case "DATA":
+			if reply(354, "go ahead") != nil {
+				return
+			}
+			data, readErr := tp.ReadDotBytes()
+			if readErr != nil {
+				return
+			}
+			s.received(data)
+			err = reply(250, "queued")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			err = reply(250, "ok")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// received notes the arrival of a message carrying a replay ID.
+func (s *Sink) received(data []byte) {
+	msg, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return
+	}
+	id := msg.Header.Get(ReplayHeader)
+	if id == "" {
+		return
+	}
+	
+	s.mu.Lock()
+	if _, ok := s.arrivals[id]; !ok {
+		s.arrivals[id] = time.Now()
+	}
+	s.mu.Unlock()
+	
+	select {
+	case s.arrived <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,114 @@
+// Package traffic records the shape of submitted mail and replays it
+// against a server for load testing. A recording holds one Event per
+// accepted email, carrying when it arrived, its size, its recipients'
+// domains and how it was to be sent, but no content or addresses.
+package traffic
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+)
+
+// Event is one anonymized submission. Domains has an entry per recipient.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Size     int64     `json:"size"`
+	Domains  []string  `json:"domains"`
+	Priority int       `json:"priority,omitempty"`
+	Lane     string    `json:"lane,omitempty"`
+	Raw      bool      `json:"raw,omitempty"`
+}
+
+// EventFor returns the event recording e.
+func EventFor(e *email.Email) Event {
+	ev := Event{
+		Time:     e.CreatedAt,
+		Size:     e.Size(),
+		Priority: e.Priority,
+		Lane:     string(e.Lane),
+		Raw:      e.HasRaw(),
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	for _, rcpt := range e.Recipients() {
+		domain := ""
+		if at := strings.LastIndex(rcpt, "@"); at >= 0 {
+			domain = strings.ToLower(rcpt[at+1:])
+		}
+		ev.Domains = append(ev.Domains, domain)
+	}
+	return ev
+}
+
+// Recorder writes events as JSON lines. It is safe for concurrent use.
+type Recorder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w, enc: json.NewEncoder(w)}
+}
+
+// OpenRecorder appends events to the file at path, creating it if needed.
+func OpenRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(f), nil
+}
+
+// Record writes ev.
+func (r *Recorder) Record(ev Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(&ev)
+}
+
+// Close closes the underlying writer if it is a Closer.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ReadEvents reads a recording, sorted by time.
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var ev Event
+		if err := dec.Decode(&ev); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
+
+// ReadFile reads the recording at path.
+func ReadFile(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadEvents(f)
+}
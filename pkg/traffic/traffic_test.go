@@ -0,0 +1,154 @@
+package traffic_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	
+	"github.com/tpdoyle87/simple-email-server/internal/api"
+	"github.com/tpdoyle87/simple-email-server/internal/config"
+	"github.com/tpdoyle87/simple-email-server/internal/delivery"
+	"github.com/tpdoyle87/simple-email-server/internal/queue"
+	"github.com/tpdoyle87/simple-email-server/pkg/client"
+	"github.com/tpdoyle87/simple-email-server/pkg/email"
+	"github.com/tpdoyle87/simple-email-server/pkg/traffic"
+)
+
+func TestRecorder_Anonymizes(t *testing.T) {
+	var buf bytes.Buffer
+	r := traffic.NewRecorder(&buf)
+	
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.Record(traffic.EventFor(&email.Email{
+		From:      "alice@secret.example",
+		To:        []string{"bob@Gmail.com", "carol@yahoo.com"},
+		Subject:   "Quarterly numbers",
+		Body:      "confidential",
+		Priority:  5,
+		Lane:      email.LaneBulk,
+		CreatedAt: at.Add(time.Second),
+	}))
+	r.Record(traffic.EventFor(&email.Email{To: []string{"dave@example.com"}, Body: "hi", CreatedAt: at}))
+	
+	for _, secret := range []string{"alice", "bob", "carol", "Quarterly", "confidential", "secret.example"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("Recording leaks %q: %s", secret, buf.String())
+		}
+	}
+	
+	events, err := traffic.ReadEvents(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || !events[0].Time.Equal(at) {
+		t.Fatalf("Expected 2 events in time order, got %+v", events)
+	}
+	ev := events[1]
+	if strings.Join(ev.Domains, ",") != "gmail.com,yahoo.com" || ev.Priority != 5 || ev.Lane != "bulk" || ev.Size != int64(len("confidential")) {
+		t.Errorf("Unexpected event %+v", ev)
+	}
+}
+
+// sinkResolver points every domain's MX at the sink.
+type sinkResolver struct {
+	addr string
+}
+
+func (r sinkResolver) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	return []*net.MX{{Host: r.addr, Pref: 10}}, nil
+}
+
+func TestReplay_EndToEnd(t *testing.T) {
+	sink, err := traffic.NewSink("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	
+	q := queue.NewMemoryQueue(100)
+	server := api.New(&config.APIConfig{AuthToken: "token"}, q, 1024*1024)
+	
+	var recording bytes.Buffer
+	server.SetTrafficRecorder(traffic.NewRecorder(&recording))
+	
+	service := delivery.NewService(&config.DeliveryConfig{
+		Workers:           2,
+		DNSCacheTTL:       time.Minute,
+		ConnectionTimeout: 5 * time.Second,
+	}, q)
+	service.SetResolver(sinkResolver{addr: sink.Addr()})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go service.Start(ctx)
+	
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	c := client.New(ts.URL, "token")
+	
+	start := time.Now()
+	var events []traffic.Event
+	for i := 0; i < 5; i++ {
+		events = append(events, traffic.Event{
+			Time:    start.Add(time.Duration(i) * 100 * time.Millisecond),
+			Size:    2000,
+			Domains: []string{"gmail.com", "yahoo.com"},
+		})
+	}
+	
+	report, err := traffic.Replay(ctx, c, events, traffic.ReplayOptions{
+		Speed:           10,
+		From:            "loadtest@sender.test",
+		RecipientDomain: "sink.test",
+		Sink:            sink,
+		DeliveryTimeout: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Submitted != 5 || report.Accepted != 5 || report.Delivered != 5 {
+		t.Errorf("Expected 5 emails accepted and delivered, got %+v", report)
+	}
+	if report.Delivery.Max <= 0 || report.Acceptance.Max <= 0 {
+		t.Errorf("Expected latencies, got %+v", report)
+	}
+	
+	// The target recorded the replay, with the domains mapped to the sink
+	recorded, _ := traffic.ReadEvents(&recording)
+	if len(recorded) != 5 || recorded[0].Domains[0] != "gmail-com.sink.test" || recorded[0].Size < 2000 {
+		t.Errorf("Unexpected recording of the replay: %+v", recorded)
+	}
+}
+
+func TestReplay_CountsRejections(t *testing.T) {
+	var n atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1)%2 == 0 {
+			http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"id":"x","status":"queued"}`))
+	}))
+	defer ts.Close()
+	
+	events := make([]traffic.Event, 4)
+	for i := range events {
+		events[i] = traffic.Event{Time: time.Now(), Size: 10, Domains: []string{"example.com"}}
+	}
+	report, err := traffic.Replay(context.Background(), client.New(ts.URL, "token"), events, traffic.ReplayOptions{
+		From:            "loadtest@sender.test",
+		RecipientDomain: "sink.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accepted != 2 || report.Rejected != 2 || report.RejectedByStatus[429] != 2 || report.RejectionRate != 0.5 {
+		t.Errorf("Expected half rejected with 429, got %+v", report)
+	}
+}